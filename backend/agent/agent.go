@@ -3,27 +3,37 @@ package agent
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"os/exec"
+	"net/http"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"isc.org/stork"
 	agentapi "isc.org/stork/api"
+	storkutil "isc.org/stork/util"
 )
 
 // Stork Agent settings.
 type Settings struct {
 	Host string `long:"host" description:"the IP to listen on" env:"STORK_AGENT_ADDRESS"`
 	Port int    `long:"port" description:"the port to listen on for connections" default:"8080" env:"STORK_AGENT_PORT"`
+
+	ServerURL   string `long:"server-url" description:"the URL of Stork Server, used to register the agent" env:"STORK_AGENT_SERVER_URL"`
+	ServerToken string `long:"server-token" description:"the token of Stork Server; if specified, the agent is authorized without the approval of an administrator" env:"STORK_AGENT_SERVER_TOKEN"`
+	CertDir     string `long:"cert-dir" description:"the directory where the agent key and certificates are stored" default:"/var/lib/stork-agent/certs" env:"STORK_AGENT_CERT_DIR"`
 }
 
 // Global Stork Agent state
@@ -31,30 +41,38 @@ type StorkAgent struct {
 	Settings   Settings
 	AppMonitor AppMonitor
 
-	HTTPClient *HTTPClient // to communicate with Kea Control Agent and named statistics-channel
-	RndcClient *RndcClient // to communicate with BIND 9 via rndc
-	server     *grpc.Server
+	HTTPClient   *HTTPClient  // to communicate with Kea Control Agent and named statistics-channel
+	RndcClient   *RndcClient  // to communicate with BIND 9 via rndc
+	serverClient *http.Client // to enroll in Stork Server and notify it about the changed apps
+	server       *grpc.Server
+
+	certStore *certStore // agent key and certificates used for TLS
+	done      chan bool
+	wg        *sync.WaitGroup
 }
 
 // API exposed to Stork Server
 
 func NewStorkAgent(appMonitor AppMonitor) *StorkAgent {
-	// rndc is the command to interface with BIND 9.
-	rndc := func(command []string) ([]byte, error) {
-		cmd := exec.Command(command[0], command[1:]...) //nolint:gosec
-		return cmd.Output()
-	}
-	rndcClient := NewRndcClient(rndc)
+	rndcClient := NewRndcClient(executeRndcCommand)
 
 	httpClient := NewHTTPClient()
 
-	server := grpc.NewServer()
+	// Only the server presenting a certificate issued by its root CA
+	// is allowed to connect. The agent certificates are loaded when the
+	// agent starts serving.
+	certStore := &certStore{}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(certStore.tlsConfig())))
 
 	sa := &StorkAgent{
-		AppMonitor: appMonitor,
-		HTTPClient: httpClient,
-		RndcClient: rndcClient,
-		server:     server,
+		AppMonitor:   appMonitor,
+		HTTPClient:   httpClient,
+		RndcClient:   rndcClient,
+		serverClient: &http.Client{Timeout: 30 * time.Second},
+		server:       server,
+		certStore:    certStore,
+		done:         make(chan bool),
+		wg:           &sync.WaitGroup{},
 	}
 
 	return sa
@@ -72,16 +90,27 @@ func (sa *StorkAgent) GetState(ctx context.Context, in *agentapi.GetStateReq) (*
 		var accessPoints []*agentapi.AccessPoint
 		for _, point := range app.AccessPoints {
 			accessPoints = append(accessPoints, &agentapi.AccessPoint{
-				Type:    point.Type,
-				Address: point.Address,
-				Port:    point.Port,
-				Key:     point.Key,
+				Type:              point.Type,
+				Address:           point.Address,
+				Port:              point.Port,
+				Key:               point.Key,
+				UseSecureProtocol: point.UseSecureProtocol,
+			})
+		}
+
+		var controlSockets []*agentapi.KeaControlSocket
+		for _, socket := range app.KeaControlSockets {
+			controlSockets = append(controlSockets, &agentapi.KeaControlSocket{
+				Daemon:     socket.Daemon,
+				SocketType: socket.SocketType,
+				SocketName: socket.SocketName,
 			})
 		}
 
 		apps = append(apps, &agentapi.App{
-			Type:         app.Type,
-			AccessPoints: accessPoints,
+			Type:              app.Type,
+			AccessPoints:      accessPoints,
+			KeaControlSockets: controlSockets,
 		})
 	}
 
@@ -203,6 +232,26 @@ func (sa *StorkAgent) ForwardToNamedStats(ctx context.Context, in *agentapi.Forw
 	return response, nil
 }
 
+// Returns the settings used to connect to the Kea Control Agent with the
+// given URL or nil if the Kea app with such control access point hasn't
+// been detected or doesn't require any special settings.
+func (sa *StorkAgent) getKeaHTTPClientSettings(url string) *HTTPClientSettings {
+	address, port := storkutil.ParseURL(url)
+	for _, app := range sa.AppMonitor.GetApps() {
+		if app.Type != AppTypeKea {
+			continue
+		}
+		ctrl, err := getAccessPoint(app, AccessPointControl)
+		if err != nil {
+			continue
+		}
+		if ctrl.Address == address && ctrl.Port == port {
+			return app.HTTPClientSettings
+		}
+	}
+	return nil
+}
+
 // Forwards one or more Kea commands sent by the Stork server to the appropriate Kea instance over
 // HTTP (via Control Agent).
 func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.ForwardToKeaOverHTTPReq) (*agentapi.ForwardToKeaOverHTTPRsp, error) {
@@ -210,6 +259,10 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 
 	requests := in.GetKeaRequests()
 
+	// The Kea Control Agent may require TLS client certificate or
+	// credentials, which are known to the agent only.
+	settings := sa.getKeaHTTPClientSettings(reqURL)
+
 	response := &agentapi.ForwardToKeaOverHTTPRsp{
 		Status: &agentapi.Status{
 			Code: agentapi.Status_OK, // all ok
@@ -222,7 +275,7 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 			Status: &agentapi.Status{},
 		}
 		// Try to forward the command to Kea Control Agent.
-		keaRsp, err := sa.HTTPClient.Call(reqURL, bytes.NewBuffer([]byte(req.Request)))
+		keaRsp, err := sa.HTTPClient.CallWithSettings(reqURL, bytes.NewBuffer([]byte(req.Request)), settings)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"URL": reqURL,
@@ -255,7 +308,156 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 	return response, nil
 }
 
+// Checks if the socket is the control socket of one of the detected Kea
+// daemons running without the Kea Control Agent. The commands are not
+// forwarded to any other sockets.
+func (sa *StorkAgent) isKeaControlSocket(socketName string) bool {
+	for _, app := range sa.AppMonitor.GetApps() {
+		if app.Type != AppTypeKea {
+			continue
+		}
+		point, err := getAccessPoint(app, AccessPointControlSocket)
+		if err == nil && point.Address == socketName {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwards one or more Kea commands sent by the Stork server directly to the
+// Kea daemon over its unix control socket. The daemon returns a single response
+// to each command, so it is wrapped in a list like the responses of the Kea
+// Control Agent.
+func (sa *StorkAgent) ForwardToKeaOverUnixSocket(ctx context.Context, in *agentapi.ForwardToKeaOverUnixSocketReq) (*agentapi.ForwardToKeaOverUnixSocketRsp, error) {
+	socketName := in.GetSocketName()
+
+	response := &agentapi.ForwardToKeaOverUnixSocketRsp{
+		Status: &agentapi.Status{
+			Code: agentapi.Status_OK, // all ok
+		},
+	}
+
+	if !sa.isKeaControlSocket(socketName) {
+		log.WithFields(log.Fields{
+			"Socket": socketName,
+		}).Errorf("Refused to forward commands to unknown Kea control socket")
+		response.Status.Code = agentapi.Status_ERROR
+		response.Status.Message = fmt.Sprintf("Kea control socket %s not found", socketName)
+		return response, nil
+	}
+
+	// forward requests to kea one by one
+	for _, req := range in.GetKeaRequests() {
+		rsp := &agentapi.KeaResponse{
+			Status: &agentapi.Status{},
+		}
+		body, err := sendToKeaOverUnixSocket(socketName, []byte(req.Request))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Socket": socketName,
+			}).Errorf("Failed to forward commands to Kea: %+v", err)
+			rsp.Status.Code = agentapi.Status_ERROR
+			rsp.Status.Message = "Failed to forward commands to Kea"
+			response.KeaResponses = append(response.KeaResponses, rsp)
+			continue
+		}
+
+		rsp.Response = "[" + string(body) + "]"
+		rsp.Status.Code = agentapi.Status_OK
+		response.KeaResponses = append(response.KeaResponses, rsp)
+	}
+
+	return response, nil
+}
+
+// Notifies the server that the apps detected on the machine have changed,
+// so the server can fetch the machine state right away. The agent is
+// identified by its address, port and the agent token. The agent token is
+// only sent over HTTPS because anyone knowing it could impersonate the
+// agent.
+func (sa *StorkAgent) notifyAppsChanged() error {
+	// The server doesn't know the agent until it registers.
+	if sa.Settings.ServerURL == "" || sa.certStore.getLeaf() == nil {
+		return nil
+	}
+
+	url := strings.TrimRight(sa.Settings.ServerURL, "/") + "/api/machines-apps-changed"
+	if !strings.HasPrefix(strings.ToLower(url), "https://") {
+		return errors.Errorf("refusing to send agent token to %s over plain HTTP, the server URL must use HTTPS", url)
+	}
+
+	address, err := sa.getEnrollmentAddress()
+	if err != nil {
+		return err
+	}
+
+	agentToken, err := sa.getAgentToken()
+	if err != nil {
+		return err
+	}
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"address":    address,
+		"agentPort":  sa.Settings.Port,
+		"agentToken": agentToken,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "problem with preparing apps changed notification")
+	}
+
+	rsp, err := sa.serverClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return errors.Wrapf(err, "problem with sending apps changed notification to %s", url)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusAccepted {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := ioutil.ReadAll(rsp.Body)
+		_ = json.Unmarshal(body, &apiErr)
+		return errors.Errorf("server refused apps changed notification: %d %s", rsp.StatusCode, apiErr.Message)
+	}
+	return nil
+}
+
+// Sends the notifications about the changes of the apps detected by the
+// app monitor to the server.
+func (sa *StorkAgent) appsChangedLoop() {
+	defer sa.wg.Done()
+	for {
+		select {
+		case <-sa.AppMonitor.AppsChanged():
+			err := sa.notifyAppsChanged()
+			if err != nil {
+				log.Errorf("problem with notifying server about changed apps: %+v", err)
+			}
+		case <-sa.done:
+			return
+		}
+	}
+}
+
 func (sa *StorkAgent) Serve() {
+	// Register the agent in the server or load the certificates obtained
+	// previously. If the server can't be reached, the agent keeps trying
+	// to register in the background.
+	err := sa.setupCerts()
+	if err != nil {
+		if sa.Settings.ServerURL == "" {
+			log.Fatalf("Failed to set up agent certificates: %+v", err)
+		}
+		log.Errorf("Failed to register the agent, retrying in %s: %v", registrationRetryInterval, err)
+	}
+	sa.wg.Add(1)
+	go sa.certRenewalLoop()
+
+	// Let the server know when the apps change, so it doesn't have to
+	// wait for the next pull of the machine state.
+	sa.wg.Add(1)
+	go sa.appsChangedLoop()
+
 	// Install gRPC API handlers.
 	agentapi.RegisterAgentServer(sa.server, sa)
 
@@ -280,4 +482,6 @@ func (sa *StorkAgent) Shutdown() {
 	if sa.server != nil {
 		sa.server.GracefulStop()
 	}
+	close(sa.done)
+	sa.wg.Wait()
 }
@@ -2,7 +2,12 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -18,10 +23,10 @@ type FakeAppMonitor struct {
 }
 
 // mockRndc mocks successful rndc output.
-func mockRndc(command []string) ([]byte, error) {
+func mockRndc(ctrl *AccessPoint, command []string) ([]byte, error) {
 	var output string
 
-	if command[len(command)-1] == "status" {
+	if len(command) > 0 && command[len(command)-1] == "status" {
 		output = "server is up and running"
 		return []byte(output), nil
 	}
@@ -32,21 +37,21 @@ func mockRndc(command []string) ([]byte, error) {
 }
 
 // mockRndcError mocks an error.
-func mockRndcError(command []string) ([]byte, error) {
+func mockRndcError(ctrl *AccessPoint, command []string) ([]byte, error) {
 	log.Debugf("mock rndc: error")
 
 	return []byte(""), fmt.Errorf("mocking an error")
 }
 
 // mockRndcEmpty mocks empty output.
-func mockRndcEmpty(command []string) ([]byte, error) {
+func mockRndcEmpty(ctrl *AccessPoint, command []string) ([]byte, error) {
 	log.Debugf("mock rndc: empty")
 
 	return []byte(""), nil
 }
 
 // Initializes StorkAgent instance and context used by the tests.
-func setupAgentTest(rndc CommandExecutor) (*StorkAgent, context.Context) {
+func setupAgentTest(rndc RndcExecutor) (*StorkAgent, context.Context) {
 	httpClient := NewHTTPClient()
 	rndcClient := NewRndcClient(rndc)
 	gock.InterceptClient(httpClient.client)
@@ -65,6 +70,10 @@ func (fam *FakeAppMonitor) GetApps() []*App {
 	return fam.Apps
 }
 
+func (fam *FakeAppMonitor) AppsChanged() <-chan bool {
+	return nil
+}
+
 func (fam *FakeAppMonitor) Shutdown() {
 }
 
@@ -176,6 +185,118 @@ func TestForwardToKeaOverHTTPSuccess(t *testing.T) {
 	require.JSONEq(t, "[{\"result\":0}]", rsp.KeaResponses[0].Response)
 }
 
+// Test that the credentials from the Kea Control Agent configuration are
+// sent when forwarding the command to this Kea Control Agent.
+func TestForwardToKeaOverHTTPBasicAuth(t *testing.T) {
+	sa, ctx := setupAgentTest(mockRndc)
+
+	fam, _ := sa.AppMonitor.(*FakeAppMonitor)
+	fam.Apps = []*App{
+		{
+			Type:         AppTypeKea,
+			AccessPoints: makeAccessPoint(AccessPointControl, "localhost", "", 45634),
+			HTTPClientSettings: &HTTPClientSettings{
+				BasicAuth: &BasicAuthCredentials{
+					User:     "admin",
+					Password: "secret",
+				},
+			},
+		},
+	}
+
+	// The request without the credentials would not be matched.
+	defer gock.Off()
+	gock.New("http://localhost:45634").
+		MatchHeader("Authorization", "Basic YWRtaW46c2VjcmV0").
+		Post("/").
+		Reply(200).
+		JSON([]map[string]int{{"result": 0}})
+
+	req := &agentapi.ForwardToKeaOverHTTPReq{
+		Url:         "http://localhost:45634/",
+		KeaRequests: []*agentapi.KeaRequest{{Request: "{ \"command\": \"list-commands\"}"}},
+	}
+
+	rsp, err := sa.ForwardToKeaOverHTTP(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, rsp)
+	require.Len(t, rsp.KeaResponses, 1)
+	require.Equal(t, agentapi.Status_OK, rsp.KeaResponses[0].Status.Code)
+	require.JSONEq(t, "[{\"result\":0}]", rsp.KeaResponses[0].Response)
+}
+
+// Test forwarding the commands directly to the Kea daemon over its control
+// socket. The response is wrapped in a list like the responses of the Kea
+// Control Agent.
+func TestForwardToKeaOverUnixSocket(t *testing.T) {
+	sa, ctx := setupAgentTest(mockRndc)
+
+	dir, err := ioutil.TempDir("", "keasocket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketName := path.Join(dir, "kea-dhcp4.sock")
+
+	// Fake Kea daemon responding to the commands and closing the
+	// connection like Kea does.
+	listener, err := net.Listen("unix", socketName)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var request map[string]interface{}
+			if err = json.NewDecoder(conn).Decode(&request); err == nil {
+				fmt.Fprintf(conn, `{ "result": 0, "text": "%s" }`, request["command"])
+			}
+			conn.Close()
+		}
+	}()
+
+	fam, _ := sa.AppMonitor.(*FakeAppMonitor)
+	fam.Apps = []*App{
+		{
+			Type:         AppTypeKea,
+			AccessPoints: []AccessPoint{{Type: AccessPointControlSocket, Address: socketName}},
+		},
+	}
+
+	req := &agentapi.ForwardToKeaOverUnixSocketReq{
+		SocketName: socketName,
+		KeaRequests: []*agentapi.KeaRequest{
+			{Request: `{ "command": "version-get", "service": [ "dhcp4" ] }`},
+			{Request: `{ "command": "config-get" }`},
+		},
+	}
+	rsp, err := sa.ForwardToKeaOverUnixSocket(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, rsp)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+	require.Len(t, rsp.KeaResponses, 2)
+	require.Equal(t, agentapi.Status_OK, rsp.KeaResponses[0].Status.Code)
+	require.JSONEq(t, `[{ "result": 0, "text": "version-get" }]`, rsp.KeaResponses[0].Response)
+	require.JSONEq(t, `[{ "result": 0, "text": "config-get" }]`, rsp.KeaResponses[1].Response)
+
+	// The commands are not sent to the sockets other than the detected
+	// control sockets.
+	req.SocketName = path.Join(dir, "other.sock")
+	rsp, err = sa.ForwardToKeaOverUnixSocket(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_ERROR, rsp.Status.Code)
+	require.Empty(t, rsp.KeaResponses)
+
+	// The daemon is not running.
+	listener.Close()
+	req.SocketName = socketName
+	rsp, err = sa.ForwardToKeaOverUnixSocket(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+	require.Len(t, rsp.KeaResponses, 2)
+	require.Equal(t, agentapi.Status_ERROR, rsp.KeaResponses[0].Status.Code)
+}
+
 // Test forwarding command to Kea when HTTP 400 (Bad Request) status
 // code is returned.
 func TestForwardToKeaOverHTTPBadRequest(t *testing.T) {
@@ -3,37 +3,167 @@ package agent
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
+// Credentials used in the HTTP basic authentication.
+type BasicAuthCredentials struct {
+	User     string
+	Password string
+}
+
+// Settings used to connect to the HTTP server requiring TLS or the
+// authentication, e.g. Kea Control Agent.
+type HTTPClientSettings struct {
+	// File or directory with the certificates of the CAs trusted when
+	// verifying the server certificate. The system's CAs are trusted if
+	// it is empty.
+	TrustAnchor string
+	// Certificate and key presented to the server requiring the client
+	// certificate.
+	CertFile string
+	KeyFile  string
+	// Credentials sent if not nil.
+	BasicAuth *BasicAuthCredentials
+}
+
+// Part of the settings determining the TLS configuration of the client.
+type httpClientTLSSettings struct {
+	trustAnchor string
+	certFile    string
+	keyFile     string
+}
+
 // HTTPClient is a normal http client.
 type HTTPClient struct {
 	client *http.Client
+
+	// Clients using the TLS configurations other than the default one.
+	// They are created on demand and reused for the same settings.
+	tlsClients map[httpClientTLSSettings]*http.Client
+	mutex      sync.Mutex
 }
 
-// Create a client to contact with Kea Control Agent or named statistics-channel.
-func NewHTTPClient() *HTTPClient {
+// Creates the http client with the given TLS configuration.
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
 	// Kea only supports HTTP/1.1. By default, the client here would use HTTP/2.
 	// The instance of the client which is created here disables HTTP/2 and should
 	// be used whenever the communication with the Kea servers is required.
 	httpTransport := &http.Transport{
 		// Creating empty, non-nil map here disables the HTTP/2.
-		TLSNextProto: make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
+		TLSNextProto:    make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
+		TLSClientConfig: tlsConfig,
 	}
-	httpClient := &http.Client{
+	return &http.Client{
 		Transport: httpTransport,
 	}
+}
 
+// Create a client to contact with Kea Control Agent or named statistics-channel.
+func NewHTTPClient() *HTTPClient {
 	client := &HTTPClient{
-		client: httpClient,
+		client:     newHTTPClient(nil),
+		tlsClients: make(map[httpClientTLSSettings]*http.Client),
 	}
 	return client
 }
 
+// Loads the certificates from the file or from all files in the
+// directory.
+func loadTrustAnchor(trustAnchor string) (*x509.CertPool, error) {
+	files := []string{trustAnchor}
+	info, err := os.Stat(trustAnchor)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read trust anchor %s", trustAnchor)
+	}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(trustAnchor)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read trust anchor %s", trustAnchor)
+		}
+		files = []string{}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, path.Join(trustAnchor, entry.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read trust anchor %s", file)
+		}
+		pool.AppendCertsFromPEM(pem)
+	}
+	return pool, nil
+}
+
+// Returns the client using the TLS configuration for the given settings.
+func (c *HTTPClient) getClient(settings *HTTPClientSettings) (*http.Client, error) {
+	if settings == nil || (settings.TrustAnchor == "" && settings.CertFile == "") {
+		return c.client, nil
+	}
+	key := httpClientTLSSettings{
+		trustAnchor: settings.TrustAnchor,
+		certFile:    settings.CertFile,
+		keyFile:     settings.KeyFile,
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if client, ok := c.tlsClients[key]; ok {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if key.trustAnchor != "" {
+		pool, err := loadTrustAnchor(key.trustAnchor)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if key.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(key.certFile, key.keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load client certificate %s", key.certFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	client := newHTTPClient(tlsConfig)
+	c.tlsClients[key] = client
+	return client, nil
+}
+
 func (c *HTTPClient) Call(url string, payload *bytes.Buffer) (*http.Response, error) {
-	rsp, err := c.client.Post(url, "application/json", payload)
+	return c.CallWithSettings(url, payload, nil)
+}
+
+// Sends the payload to the given URL using the TLS configuration and the
+// credentials from the settings. The settings may be nil.
+func (c *HTTPClient) CallWithSettings(url string, payload *bytes.Buffer, settings *HTTPClientSettings) (*http.Response, error) {
+	client, err := c.getClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with creating POST request to %s", url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if settings != nil && settings.BasicAuth != nil {
+		req.SetBasicAuth(settings.BasicAuth.User, settings.BasicAuth.Password)
+	}
+	rsp, err := client.Do(req)
 	if err != nil {
 		err = errors.Wrapf(err, "problem with sending POST to %s", url)
 	}
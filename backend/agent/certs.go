@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/pki"
+)
+
+// Names of the files in the certificates directory.
+const (
+	agentKeyFile   = "key.pem"         // private key of the agent
+	agentCertFile  = "cert.pem"        // certificate issued to the agent by the server
+	rootCAFile     = "ca.pem"          // root CA certificate of the server
+	agentTokenFile = "agent-token.txt" // token identifying the agent in the server
+)
+
+// Interval between checks whether the agent certificate should be renewed.
+const certRenewalCheckInterval = 12 * time.Hour
+
+// Interval between the attempts to register the agent when it has no
+// certificates, e.g. because the server is not reachable yet.
+const registrationRetryInterval = 30 * time.Second
+
+// Holds the agent's key and certificate, and the root CA certificate the
+// server certificate is verified against. The certificates can be replaced
+// while the agent is running, e.g. when the agent certificate is renewed.
+type certStore struct {
+	mutex  sync.RWMutex
+	cert   *tls.Certificate
+	leaf   *x509.Certificate
+	caPool *x509.CertPool
+}
+
+// Loads the key and certificates from the given directory into the store.
+func (cs *certStore) load(certDir string) error {
+	keyPEM, err := ioutil.ReadFile(path.Join(certDir, agentKeyFile))
+	if err != nil {
+		return errors.Wrapf(err, "problem with reading agent key")
+	}
+	certPEM, err := ioutil.ReadFile(path.Join(certDir, agentCertFile))
+	if err != nil {
+		return errors.Wrapf(err, "problem with reading agent certificate")
+	}
+	caCertPEM, err := ioutil.ReadFile(path.Join(certDir, rootCAFile))
+	if err != nil {
+		return errors.Wrapf(err, "problem with reading root CA certificate")
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.Wrapf(err, "problem with loading agent key and certificate")
+	}
+	leaf, err := pki.ParseCert(certPEM)
+	if err != nil {
+		return err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCertPEM) {
+		return errors.New("problem with loading root CA certificate")
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cs.cert = &cert
+	cs.leaf = leaf
+	cs.caPool = caPool
+	return nil
+}
+
+// Returns the agent certificate or nil if it hasn't been loaded yet.
+func (cs *certStore) getLeaf() *x509.Certificate {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.leaf
+}
+
+// Returns the TLS configuration used by the gRPC server. The configuration
+// is built upon each connection, so the connections use the current
+// certificates. The clients must present the certificate of the server
+// issued by the root CA.
+func (cs *certStore) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cs.mutex.RLock()
+			defer cs.mutex.RUnlock()
+			if cs.cert == nil {
+				return nil, errors.New("agent certificate is not loaded")
+			}
+			return &tls.Config{
+				Certificates:          []tls.Certificate{*cs.cert},
+				ClientCAs:             cs.caPool,
+				ClientAuth:            tls.RequireAndVerifyClientCert,
+				MinVersion:            tls.VersionTLS12,
+				VerifyPeerCertificate: verifyServerCert,
+			}, nil
+		},
+	}
+}
+
+// Checks that the client certificate, which has already been verified
+// against the root CA, belongs to the server.
+func verifyServerCert(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.New("missing server certificate")
+	}
+	if verifiedChains[0][0].Subject.CommonName != pki.ServerCommonName {
+		return errors.Errorf("certificate %s does not belong to the server", verifiedChains[0][0].Subject.CommonName)
+	}
+	return nil
+}
+
+// Returns the address the server uses to connect to the agent. It is the
+// address the agent listens on or, if the agent listens on all addresses,
+// the host name.
+func (sa *StorkAgent) getEnrollmentAddress() (string, error) {
+	host := sa.Settings.Host
+	ip := net.ParseIP(host)
+	if host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return host, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrapf(err, "problem with getting host name")
+	}
+	return hostname, nil
+}
+
+// Returns the token identifying the agent in the server. The token is
+// generated when the agent registers for the first time and is stored in
+// the certificates directory, so the agent can register again without the
+// server token.
+func (sa *StorkAgent) getAgentToken() (string, error) {
+	tokenPath := path.Join(sa.Settings.CertDir, agentTokenFile)
+	token, err := ioutil.ReadFile(tokenPath)
+	if err == nil && len(bytes.TrimSpace(token)) > 0 {
+		return string(bytes.TrimSpace(token)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "problem with reading agent token")
+	}
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", errors.Wrapf(err, "problem with generating agent token")
+	}
+	err = os.MkdirAll(sa.Settings.CertDir, 0700)
+	if err != nil {
+		return "", errors.Wrapf(err, "problem with creating directory %s", sa.Settings.CertDir)
+	}
+	newToken := hex.EncodeToString(buf)
+	err = ioutil.WriteFile(tokenPath, []byte(newToken), 0600)
+	if err != nil {
+		return "", errors.Wrapf(err, "problem with writing agent token")
+	}
+	return newToken, nil
+}
+
+// Registers the agent in the server. It generates a new key and CSR, sends
+// the CSR to the server along with the agent token and, if specified, the
+// server token, and stores the key and the certificates returned by the
+// server in the certificates directory. The tokens are sent over HTTPS
+// only and the server certificate is verified, so the server CA cert
+// returned by the server can be trusted. Without the server token the
+// machine waits for the approval of an administrator and the server
+// doesn't contact the agent until then.
+func (sa *StorkAgent) enroll() error {
+	if sa.Settings.ServerURL == "" {
+		return errors.New("server URL must be specified to register the agent")
+	}
+
+	url := strings.TrimRight(sa.Settings.ServerURL, "/") + "/api/machines-enrollment"
+	if !strings.HasPrefix(strings.ToLower(url), "https://") {
+		return errors.Errorf("refusing to send agent token to %s over plain HTTP, the server URL must use HTTPS", url)
+	}
+
+	address, err := sa.getEnrollmentAddress()
+	if err != nil {
+		return err
+	}
+
+	agentToken, err := sa.getAgentToken()
+	if err != nil {
+		return err
+	}
+
+	keyPEM, csrPEM, err := pki.GenKeyAndCSR(address, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"address":     address,
+		"agentPort":   sa.Settings.Port,
+		"agentCSR":    string(csrPEM),
+		"agentToken":  agentToken,
+		"serverToken": sa.Settings.ServerToken,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "problem with preparing enrollment request")
+	}
+
+	rsp, err := sa.serverClient.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return errors.Wrapf(err, "problem with sending enrollment request to %s", url)
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "problem with reading enrollment response from %s", url)
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(body, &apiErr)
+		return errors.Errorf("server refused to enroll the agent: %d %s", rsp.StatusCode, apiErr.Message)
+	}
+
+	var result struct {
+		AgentCert    string `json:"agentCert"`
+		ServerCACert string `json:"serverCACert"`
+		Authorized   bool   `json:"authorized"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return errors.Wrapf(err, "problem with parsing enrollment response from %s", url)
+	}
+
+	certDir := sa.Settings.CertDir
+	err = os.MkdirAll(certDir, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "problem with creating directory %s", certDir)
+	}
+	files := []struct {
+		name    string
+		content []byte
+		perm    os.FileMode
+	}{
+		{agentKeyFile, keyPEM, 0600},
+		{agentCertFile, []byte(result.AgentCert), 0644},
+		{rootCAFile, []byte(result.ServerCACert), 0644},
+	}
+	for _, f := range files {
+		err = ioutil.WriteFile(path.Join(certDir, f.name), f.content, f.perm)
+		if err != nil {
+			return errors.Wrapf(err, "problem with writing %s", f.name)
+		}
+	}
+
+	logger := log.WithFields(log.Fields{
+		"server":  sa.Settings.ServerURL,
+		"address": address,
+	})
+	if result.Authorized {
+		logger.Info("agent enrolled in the server")
+	} else {
+		logger.Warn("agent registered in the server and waits for the approval of an administrator")
+	}
+	return nil
+}
+
+// Sets up the agent key and certificates. If the server URL is specified,
+// the agent registers in the server upon each startup, so the server learns
+// about the agent even if the machine has been removed in the meantime. If
+// the registration fails, the agent uses the certificates it obtained
+// previously. Without the server URL the certificates must be in place.
+func (sa *StorkAgent) setupCerts() error {
+	if sa.Settings.ServerURL == "" {
+		return sa.certStore.load(sa.Settings.CertDir)
+	}
+	err := sa.enroll()
+	if err != nil {
+		loadErr := sa.certStore.load(sa.Settings.CertDir)
+		if loadErr != nil {
+			return err
+		}
+		log.Warnf("problem with registering the agent, using existing certificates: %v", err)
+		return nil
+	}
+	return sa.certStore.load(sa.Settings.CertDir)
+}
+
+// Enrolls the agent again if its certificate is due for renewal. If the
+// renewal fails the agent keeps using the current certificate.
+func (sa *StorkAgent) renewCerts() error {
+	leaf := sa.certStore.getLeaf()
+	if leaf == nil || !pki.NeedsRenewal(leaf, time.Now()) {
+		return nil
+	}
+	log.Infof("agent certificate expires at %s, renewing it", leaf.NotAfter)
+	err := sa.enroll()
+	if err != nil {
+		log.Errorf("problem with renewing agent certificate: %+v", err)
+		return nil
+	}
+	return sa.certStore.load(sa.Settings.CertDir)
+}
+
+// Periodically checks whether the agent certificate should be renewed.
+// If the agent has no certificates yet, it periodically tries to register
+// in the server.
+func (sa *StorkAgent) certRenewalLoop() {
+	defer sa.wg.Done()
+	for {
+		registered := sa.certStore.getLeaf() != nil
+		interval := certRenewalCheckInterval
+		if !registered {
+			interval = registrationRetryInterval
+		}
+		select {
+		case <-time.After(interval):
+			var err error
+			if registered {
+				err = sa.renewCerts()
+			} else {
+				err = sa.setupCerts()
+			}
+			if err != nil {
+				log.Errorf("problem with setting up agent certificates: %v", err)
+			}
+		case <-sa.done:
+			return
+		}
+	}
+}
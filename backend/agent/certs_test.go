@@ -0,0 +1,336 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+)
+
+// Fake Stork Server issuing certificates for the agents using its own
+// root CA.
+type fakeEnrollmentServer struct {
+	caKey       []byte
+	caCert      []byte
+	serverKey   []byte
+	serverCert  []byte
+	token       string
+	enrolled    int
+	authorized  bool
+	agentToken  string
+	appsChanged int
+	server      *httptest.Server
+}
+
+// Starts fake Stork Server expecting the given token. The agents which
+// don't provide the token are registered as unauthorized.
+func newFakeEnrollmentServer(t *testing.T, token string) *fakeEnrollmentServer {
+	caKey, caCert, err := pki.GenCAKeyCert(1)
+	require.NoError(t, err)
+	serverKey, serverCert, err := pki.GenServerKeyCert(2, caCert, caKey)
+	require.NoError(t, err)
+
+	fs := &fakeEnrollmentServer{
+		caKey:      caKey,
+		caCert:     caCert,
+		serverKey:  serverKey,
+		serverCert: serverCert,
+		token:      token,
+	}
+	fs.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/machines-apps-changed" {
+			var req map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if fs.agentToken == "" || req["agentToken"] != fs.agentToken {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"message": "invalid agent token"}`))
+				return
+			}
+			fs.appsChanged++
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if r.URL.Path != "/api/machines-enrollment" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["serverToken"] != "" && req["serverToken"] != fs.token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "invalid server token"}`))
+			return
+		}
+		fs.enrolled++
+		fs.authorized = req["serverToken"] == fs.token
+		fs.agentToken, _ = req["agentToken"].(string)
+		agentCert, err := pki.SignAgentCSR([]byte(req["agentCSR"].(string)), req["address"].(string),
+			int64(2+fs.enrolled), fs.caCert, fs.caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"agentCert":    string(agentCert),
+			"serverCACert": string(fs.caCert),
+			"authorized":   fs.authorized,
+		})
+	}))
+	return fs
+}
+
+// Creates an agent which stores its certificates in a temporary directory
+// and trusts the TLS certificate of the fake server.
+func newTestAgentWithCertDir(t *testing.T, fs *fakeEnrollmentServer, token string) (*StorkAgent, func()) {
+	certDir, err := ioutil.TempDir("", "stork-agent-certs")
+	require.NoError(t, err)
+
+	sa := NewStorkAgent(&FakeAppMonitor{})
+	sa.Settings.Host = "127.0.0.1"
+	sa.Settings.Port = 8080
+	sa.Settings.ServerURL = fs.server.URL
+	sa.Settings.ServerToken = token
+	sa.serverClient = fs.server.Client()
+	sa.Settings.CertDir = path.Join(certDir, "certs")
+
+	return sa, func() {
+		os.RemoveAll(certDir)
+	}
+}
+
+// Test that the agent enrolls when it has no certificates and registers
+// again with the same agent token upon the next startup.
+func TestSetupCertsEnroll(t *testing.T) {
+	fs := newFakeEnrollmentServer(t, "secret")
+	defer fs.server.Close()
+
+	sa, teardown := newTestAgentWithCertDir(t, fs, "secret")
+	defer teardown()
+
+	err := sa.setupCerts()
+	require.NoError(t, err)
+	require.Equal(t, 1, fs.enrolled)
+	require.True(t, fs.authorized)
+	require.Len(t, fs.agentToken, 64)
+
+	leaf := sa.certStore.getLeaf()
+	require.NotNil(t, leaf)
+	require.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+
+	// The key and the agent token must not be readable by others.
+	for _, name := range []string{agentKeyFile, agentTokenFile} {
+		info, err := os.Stat(path.Join(sa.Settings.CertDir, name))
+		require.NoError(t, err)
+		require.EqualValues(t, 0600, info.Mode().Perm())
+	}
+
+	// The agent registers again upon startup, using the same agent token.
+	agentToken := fs.agentToken
+	sa2 := NewStorkAgent(&FakeAppMonitor{})
+	sa2.Settings = sa.Settings
+	sa2.serverClient = sa.serverClient
+	err = sa2.setupCerts()
+	require.NoError(t, err)
+	require.Equal(t, 2, fs.enrolled)
+	require.Equal(t, agentToken, fs.agentToken)
+	require.NotEqual(t, leaf.SerialNumber, sa2.certStore.getLeaf().SerialNumber)
+}
+
+// Test that the agent registers without the server token.
+func TestSetupCertsWithoutServerToken(t *testing.T) {
+	fs := newFakeEnrollmentServer(t, "secret")
+	defer fs.server.Close()
+
+	sa, teardown := newTestAgentWithCertDir(t, fs, "")
+	defer teardown()
+
+	err := sa.setupCerts()
+	require.NoError(t, err)
+	require.Equal(t, 1, fs.enrolled)
+	require.False(t, fs.authorized)
+	require.NotEmpty(t, fs.agentToken)
+	require.NotNil(t, sa.certStore.getLeaf())
+}
+
+// Test that the agent doesn't start without certificates when it can't
+// enroll and that it uses the existing certificates when the server is
+// not available.
+func TestSetupCertsEnrollFailure(t *testing.T) {
+	fs := newFakeEnrollmentServer(t, "secret")
+	defer fs.server.Close()
+
+	// Wrong token.
+	sa, teardown := newTestAgentWithCertDir(t, fs, "foo")
+	defer teardown()
+	err := sa.setupCerts()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid server token")
+
+	// No server URL.
+	sa.Settings.ServerURL = ""
+	err = sa.setupCerts()
+	require.Error(t, err)
+
+	// The tokens are not sent over plain HTTP.
+	sa.Settings.ServerURL = strings.Replace(fs.server.URL, "https://", "http://", 1)
+	sa.Settings.ServerToken = "secret"
+	err = sa.setupCerts()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "plain HTTP")
+	require.Zero(t, fs.enrolled)
+
+	// The server certificate is not trusted.
+	sa.Settings.ServerURL = fs.server.URL
+	sa.serverClient = &http.Client{}
+	err = sa.setupCerts()
+	require.Error(t, err)
+	require.Zero(t, fs.enrolled)
+	sa.serverClient = fs.server.Client()
+
+	// Obtain the certificates.
+	sa.Settings.ServerURL = fs.server.URL
+	sa.Settings.ServerToken = "secret"
+	err = sa.setupCerts()
+	require.NoError(t, err)
+	leaf := sa.certStore.getLeaf()
+
+	// The server is not available but the certificates are in place.
+	fs.server.Close()
+	sa2 := NewStorkAgent(&FakeAppMonitor{})
+	sa2.Settings = sa.Settings
+	sa2.serverClient = sa.serverClient
+	err = sa2.setupCerts()
+	require.NoError(t, err)
+	require.Equal(t, leaf.SerialNumber, sa2.certStore.getLeaf().SerialNumber)
+
+	// The certificates are in place so the server URL is not needed.
+	sa3 := NewStorkAgent(&FakeAppMonitor{})
+	sa3.Settings = sa.Settings
+	sa3.serverClient = sa.serverClient
+	sa3.Settings.ServerURL = ""
+	err = sa3.setupCerts()
+	require.NoError(t, err)
+	require.Equal(t, leaf.SerialNumber, sa3.certStore.getLeaf().SerialNumber)
+}
+
+// Test that the agent accepts the connections from the server only.
+func TestCertStoreTLSConfig(t *testing.T) {
+	fs := newFakeEnrollmentServer(t, "secret")
+	defer fs.server.Close()
+
+	sa, teardown := newTestAgentWithCertDir(t, fs, "secret")
+	defer teardown()
+	require.NoError(t, sa.setupCerts())
+
+	caPool := x509.NewCertPool()
+	require.True(t, caPool.AppendCertsFromPEM(fs.caCert))
+
+	// Performs TLS handshake between the agent and a client presenting
+	// the given certificate.
+	handshake := func(clientCerts []tls.Certificate) error {
+		lis, err := tls.Listen("tcp", "127.0.0.1:0", sa.certStore.tlsConfig())
+		require.NoError(t, err)
+		defer lis.Close()
+
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer conn.Close()
+			serverErr <- conn.(*tls.Conn).Handshake()
+		}()
+
+		conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+			Certificates: clientCerts,
+			RootCAs:      caPool,
+			ServerName:   "127.0.0.1",
+		})
+		if err == nil {
+			// With TLS 1.3 the client certificate is verified by the
+			// agent after the client completes the handshake.
+			_, err = conn.Read(make([]byte, 1))
+			if err == io.EOF {
+				err = nil
+			}
+			conn.Close()
+		}
+		if srvErr := <-serverErr; srvErr != nil {
+			return srvErr
+		}
+		return err
+	}
+
+	// Server certificate is accepted.
+	serverCert, err := tls.X509KeyPair(fs.serverCert, fs.serverKey)
+	require.NoError(t, err)
+	require.NoError(t, handshake([]tls.Certificate{serverCert}))
+
+	// No client certificate.
+	require.Error(t, handshake(nil))
+
+	// Another agent's certificate is not accepted.
+	agentKeyPEM, csrPEM, err := pki.GenKeyAndCSR("other", nil, nil)
+	require.NoError(t, err)
+	agentCertPEM, err := pki.SignAgentCSR(csrPEM, "192.0.2.1", 100, fs.caCert, fs.caKey)
+	require.NoError(t, err)
+	agentCert, err := tls.X509KeyPair(agentCertPEM, agentKeyPEM)
+	require.NoError(t, err)
+	require.Error(t, handshake([]tls.Certificate{agentCert}))
+
+	// Certificate issued by another CA is not accepted.
+	otherCAKey, otherCACert, err := pki.GenCAKeyCert(1)
+	require.NoError(t, err)
+	otherKey, otherCert, err := pki.GenServerKeyCert(2, otherCACert, otherCAKey)
+	require.NoError(t, err)
+	otherServerCert, err := tls.X509KeyPair(otherCert, otherKey)
+	require.NoError(t, err)
+	require.Error(t, handshake([]tls.Certificate{otherServerCert}))
+}
+
+// Test that the registered agent notifies the server about the changed
+// apps and that the unregistered agent doesn't.
+func TestNotifyAppsChanged(t *testing.T) {
+	fs := newFakeEnrollmentServer(t, "secret")
+	defer fs.server.Close()
+
+	sa, teardown := newTestAgentWithCertDir(t, fs, "secret")
+	defer teardown()
+
+	// Not registered yet.
+	require.NoError(t, sa.notifyAppsChanged())
+	require.Zero(t, fs.appsChanged)
+
+	require.NoError(t, sa.setupCerts())
+
+	// The agent token is not sent over plain HTTP.
+	sa.Settings.ServerURL = strings.Replace(fs.server.URL, "https://", "http://", 1)
+	err := sa.notifyAppsChanged()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "plain HTTP")
+	require.Zero(t, fs.appsChanged)
+
+	sa.Settings.ServerURL = fs.server.URL
+	require.NoError(t, sa.notifyAppsChanged())
+	require.Equal(t, 1, fs.appsChanged)
+
+	// The server doesn't recognize the agent.
+	fs.agentToken = "other"
+	err = sa.notifyAppsChanged()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid agent token")
+	require.Equal(t, 1, fs.appsChanged)
+}
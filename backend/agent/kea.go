@@ -1,79 +1,113 @@
 package agent
 
 import (
-	"io/ioutil"
 	"path"
-	"regexp"
-	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func getCtrlAddressFromKeaConfig(path string) (string, int64) {
-	text, err := ioutil.ReadFile(path)
-	if err != nil {
-		log.Warnf("cannot read kea config file: %+v", err)
-		return "", 0
+// Returns the path to the Kea configuration file specified in the command
+// line of the Kea process.
+func getKeaConfPath(confPath, cwd string) string {
+	// if path to config is not absolute then join it with CWD of kea
+	if !strings.HasPrefix(confPath, "/") {
+		confPath = path.Join(cwd, confPath)
 	}
+	return confPath
+}
 
-	ptrn := regexp.MustCompile(`"http-port"\s*:\s*([0-9]+)`)
-	m := ptrn.FindStringSubmatch(string(text))
-	if len(m) == 0 {
-		log.Warnf("cannot parse http-port: %+v", err)
-		return "", 0
+func detectKeaApp(match []string, cwd string) *App {
+	if len(match) < 3 {
+		log.Warnf("problem with parsing Kea cmdline: %s", match[0])
+		return nil
 	}
+	keaConfPath := getKeaConfPath(match[2], cwd)
 
-	port, err := strconv.Atoi(m[1])
+	config, err := readKeaCAConfig(keaConfPath, cwd)
 	if err != nil {
-		log.Warnf("cannot parse http-port: %+v", err)
-		return "", 0
+		log.Warnf("cannot get Kea Control Agent configuration: %+v", err)
+		return nil
 	}
 
-	ptrn = regexp.MustCompile(`"http-host"\s*:\s*\"(\S+)\"\s*,`)
-	m = ptrn.FindStringSubmatch(string(text))
-	address := "localhost"
-	if len(m) == 0 {
-		log.Warnf("cannot parse http-host: %+v", err)
-	} else {
-		address = m[1]
-		if address == "0.0.0.0" {
-			address = "127.0.0.1"
-		} else if address == "::" {
-			address = "::1"
-		}
+	address, port := config.getCtrlAddress()
+	accessPoints := []AccessPoint{
+		{
+			Type:              AccessPointControl,
+			Address:           address,
+			Port:              port,
+			UseSecureProtocol: config.useSecureProtocol(),
+		},
+	}
+	keaApp := &App{
+		Type:               AppTypeKea,
+		AccessPoints:       accessPoints,
+		KeaControlSockets:  config.getControlSockets(),
+		HTTPClientSettings: config.getHTTPClientSettings(),
 	}
 
-	return address, int64(port)
+	return keaApp
 }
 
-func detectKeaApp(match []string, cwd string) *App {
+// Detects the Kea daemon, i.e. dhcp4, dhcp6 or d2, which is not controlled
+// by the Kea Control Agent. The agent sends the commands directly to its
+// control socket, so the daemon without the unix control socket is not
+// detected.
+func detectKeaDaemonApp(daemon string, match []string, cwd string) *App {
 	if len(match) < 3 {
 		log.Warnf("problem with parsing Kea cmdline: %s", match[0])
 		return nil
 	}
-	keaConfPath := match[2]
+	keaConfPath := getKeaConfPath(match[2], cwd)
 
-	// if path to config is not absolute then join it with CWD of kea
-	if !strings.HasPrefix(keaConfPath, "/") {
-		keaConfPath = path.Join(cwd, keaConfPath)
-	}
-
-	address, port := getCtrlAddressFromKeaConfig(keaConfPath)
-	if port == 0 || len(address) == 0 {
+	socket, err := readKeaDaemonControlSocket(keaConfPath, cwd, daemon)
+	if err != nil {
+		log.Warnf("cannot get Kea %s configuration: %+v", daemon, err)
 		return nil
 	}
-	accessPoints := []AccessPoint{
-		{
-			Type:    AccessPointControl,
-			Address: address,
-			Port:    port,
-		},
+	if socket == nil || socket.SocketType != "unix" || socket.SocketName == "" {
+		log.Warnf("Kea %s configured in %s has no unix control socket", daemon, keaConfPath)
+		return nil
 	}
+
 	keaApp := &App{
-		Type:         AppTypeKea,
-		AccessPoints: accessPoints,
+		Type: AppTypeKea,
+		AccessPoints: []AccessPoint{
+			{
+				Type:    AccessPointControlSocket,
+				Address: socket.SocketName,
+			},
+		},
+		KeaControlSockets: []KeaControlSocket{*socket},
 	}
 
 	return keaApp
 }
+
+// Removes the Kea daemons which are controlled by one of the detected
+// Kea Control Agents. They are monitored via the Kea Control Agent.
+func removeKeaDaemonsBehindCA(apps []*App) []*App {
+	caSockets := make(map[string]bool)
+	for _, app := range apps {
+		if app.Type != AppTypeKea {
+			continue
+		}
+		if _, err := getAccessPoint(app, AccessPointControl); err != nil {
+			continue
+		}
+		for _, socket := range app.KeaControlSockets {
+			caSockets[socket.SocketName] = true
+		}
+	}
+
+	var filtered []*App
+	for _, app := range apps {
+		if app.Type == AppTypeKea {
+			if point, err := getAccessPoint(app, AccessPointControlSocket); err == nil && caSockets[point.Address] {
+				continue
+			}
+		}
+		filtered = append(filtered, app)
+	}
+	return filtered
+}
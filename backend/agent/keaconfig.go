@@ -0,0 +1,290 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Maximal depth of the nested include directives. It protects against
+// the files including each other.
+const keaConfigMaxIncludeDepth = 16
+
+// Address and port the Kea Control Agent listens on if they are not
+// specified in its configuration.
+const (
+	keaCADefaultHost = "127.0.0.1"
+	keaCADefaultPort = 8000
+)
+
+// Control socket of a Kea daemon configured in the Kea Control Agent or
+// in the daemon itself. The daemon is one of d2, dhcp4 and dhcp6.
+type KeaControlSocket struct {
+	Daemon     string
+	SocketType string `json:"socket-type"`
+	SocketName string `json:"socket-name"`
+}
+
+// Client allowed to connect to the Kea Control Agent using the basic
+// HTTP authentication.
+type keaCAAuthClient struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Authentication settings of the Kea Control Agent.
+type keaCAAuthentication struct {
+	Type    string            `json:"type"`
+	Realm   string            `json:"realm"`
+	Clients []keaCAAuthClient `json:"clients"`
+}
+
+// Parameters of the Kea Control Agent configuration used by the agent.
+type keaCAConfig struct {
+	HTTPHost       string                      `json:"http-host"`
+	HTTPPort       int64                       `json:"http-port"`
+	TrustAnchor    string                      `json:"trust-anchor"`
+	CertFile       string                      `json:"cert-file"`
+	KeyFile        string                      `json:"key-file"`
+	CertRequired   *bool                       `json:"cert-required"`
+	Authentication *keaCAAuthentication        `json:"authentication"`
+	ControlSockets map[string]KeaControlSocket `json:"control-sockets"`
+}
+
+// Names of the Kea daemons and the keys of their configurations, i.e. the
+// top level maps in their configuration files.
+var keaDaemonConfigKeys = map[string]string{
+	"dhcp4": "Dhcp4",
+	"dhcp6": "Dhcp6",
+	"d2":    "DhcpDdns",
+}
+
+// Pattern of the include directive.
+var keaIncludePattern = regexp.MustCompile(`<\?include\s+"([^"]+)"\s*\?>`)
+
+// Removes the comments from the Kea configuration. Kea accepts the
+// comments in C, C++ and shell styles. The comment markers within the
+// strings are left intact.
+func stripKeaConfigComments(text string) string {
+	stripped := strings.Builder{}
+	inString := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(text) {
+				stripped.WriteByte(c)
+				i++
+				c = text[i]
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+			continue
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return stripped.String()
+			}
+			// Keep the new lines, so the lines reported in the errors
+			// are right.
+			stripped.WriteString(strings.Repeat("\n", strings.Count(text[i:i+2+end], "\n")))
+			i += end + 3
+			continue
+		}
+		stripped.WriteByte(c)
+	}
+	return stripped.String()
+}
+
+// Reads the Kea configuration file, removes the comments and replaces the
+// include directives with the contents of the included files. The relative
+// paths of the files are resolved against the given directory, i.e. the
+// working directory of the Kea process.
+func readKeaConfig(file, dir string, depth int) (string, error) {
+	if depth > keaConfigMaxIncludeDepth {
+		return "", errors.Errorf("too many nested includes in %s", file)
+	}
+	if !path.IsAbs(file) {
+		file = path.Join(dir, file)
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot read Kea configuration file %s", file)
+	}
+
+	var includeErr error
+	expanded := keaIncludePattern.ReplaceAllStringFunc(stripKeaConfigComments(string(text)), func(directive string) string {
+		if includeErr != nil {
+			return ""
+		}
+		included, err := readKeaConfig(keaIncludePattern.FindStringSubmatch(directive)[1], dir, depth+1)
+		if err != nil {
+			includeErr = err
+		}
+		return included
+	})
+	if includeErr != nil {
+		return "", includeErr
+	}
+	return expanded, nil
+}
+
+// Returns the paths of the Kea configuration file and the files it
+// includes, also indirectly. The included files which can't be read are
+// returned too, so the monitor notices when they appear.
+func getKeaConfigFiles(file, dir string) []string {
+	files := []string{}
+	collectKeaConfigFiles(file, dir, 0, &files)
+	return files
+}
+
+// Appends the path of the file and the files it includes to the list.
+func collectKeaConfigFiles(file, dir string, depth int, files *[]string) {
+	if !path.IsAbs(file) {
+		file = path.Join(dir, file)
+	}
+	for _, f := range *files {
+		if f == file {
+			return
+		}
+	}
+	*files = append(*files, file)
+	if depth >= keaConfigMaxIncludeDepth {
+		return
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	for _, m := range keaIncludePattern.FindAllStringSubmatch(stripKeaConfigComments(string(text)), -1) {
+		collectKeaConfigFiles(m[1], dir, depth+1, files)
+	}
+}
+
+// Reads the Kea Control Agent configuration from the file. It returns
+// an error if the file can't be read or parsed or it doesn't contain the
+// Control-agent map.
+func readKeaCAConfig(file, dir string) (*keaCAConfig, error) {
+	text, err := readKeaConfig(file, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		ControlAgent *keaCAConfig `json:"Control-agent"`
+	}
+	if err = json.Unmarshal([]byte(text), &parsed); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse Kea configuration file %s", file)
+	}
+	if parsed.ControlAgent == nil {
+		return nil, errors.Errorf("no Control-agent configuration in %s", file)
+	}
+	return parsed.ControlAgent, nil
+}
+
+// Reads the configuration of the Kea daemon, i.e. dhcp4, dhcp6 or d2, from
+// the file and returns the control socket it listens on. It returns nil if
+// the control socket is not configured, so the daemon can't be contacted.
+func readKeaDaemonControlSocket(file, dir, daemon string) (*KeaControlSocket, error) {
+	text, err := readKeaConfig(file, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]*struct {
+		ControlSocket *KeaControlSocket `json:"control-socket"`
+	}
+	if err = json.Unmarshal([]byte(text), &parsed); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse Kea configuration file %s", file)
+	}
+	key := keaDaemonConfigKeys[daemon]
+	config, ok := parsed[key]
+	if !ok || config == nil {
+		return nil, errors.Errorf("no %s configuration in %s", key, file)
+	}
+	if config.ControlSocket == nil {
+		return nil, nil
+	}
+	socket := *config.ControlSocket
+	socket.Daemon = daemon
+	return &socket, nil
+}
+
+// Returns the address and port to connect to the Kea Control Agent. If
+// it listens on all addresses, the loopback address is returned.
+func (c *keaCAConfig) getCtrlAddress() (string, int64) {
+	address := c.HTTPHost
+	switch address {
+	case "":
+		address = keaCADefaultHost
+	case "0.0.0.0":
+		address = "127.0.0.1"
+	case "::":
+		address = "::1"
+	}
+	port := c.HTTPPort
+	if port == 0 {
+		port = keaCADefaultPort
+	}
+	return address, port
+}
+
+// Checks if the Kea Control Agent accepts the connections over TLS. Kea
+// enables TLS when the trust anchor, the certificate and its key are all
+// specified.
+func (c *keaCAConfig) useSecureProtocol() bool {
+	return c.TrustAnchor != "" && c.CertFile != "" && c.KeyFile != ""
+}
+
+// Returns the settings the agent uses to connect to the Kea Control Agent
+// or nil if neither TLS nor the authentication is enabled. When the Kea
+// Control Agent requires the client certificate, its own certificate is
+// presented, because it is signed by the trusted CA.
+func (c *keaCAConfig) getHTTPClientSettings() *HTTPClientSettings {
+	settings := &HTTPClientSettings{}
+	if c.useSecureProtocol() {
+		settings.TrustAnchor = c.TrustAnchor
+		if c.CertRequired == nil || *c.CertRequired {
+			settings.CertFile = c.CertFile
+			settings.KeyFile = c.KeyFile
+		}
+	}
+	if c.Authentication != nil && c.Authentication.Type == "basic" {
+		for _, client := range c.Authentication.Clients {
+			if client.User != "" {
+				settings.BasicAuth = &BasicAuthCredentials{
+					User:     client.User,
+					Password: client.Password,
+				}
+				break
+			}
+		}
+	}
+	if *settings == (HTTPClientSettings{}) {
+		return nil
+	}
+	return settings
+}
+
+// Returns the control sockets of the Kea daemons sorted by the daemon
+// names.
+func (c *keaCAConfig) getControlSockets() []KeaControlSocket {
+	sockets := []KeaControlSocket{}
+	for daemon, socket := range c.ControlSockets {
+		socket.Daemon = daemon
+		sockets = append(sockets, socket)
+	}
+	sort.Slice(sockets, func(i, j int) bool {
+		return sockets[i].Daemon < sockets[j].Daemon
+	})
+	return sockets
+}
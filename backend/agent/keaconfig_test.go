@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the comments are removed except the comment markers within
+// the strings.
+func TestStripKeaConfigComments(t *testing.T) {
+	text := `{
+    # shell comment
+    "a": "http://example.org/#fragment", // C++ comment
+    /* C comment
+       spanning lines */ "b": "/* not a comment */",
+    "c": "escaped \" quote // not a comment"
+}`
+	stripped := stripKeaConfigComments(text)
+	require.NotContains(t, stripped, "comment\n")
+	require.NotContains(t, stripped, "spanning")
+
+	// The lines are preserved, so the errors point to the right lines.
+	require.Equal(t, strings.Count(text, "\n"), strings.Count(stripped, "\n"))
+
+	parsed := map[string]string{}
+	err := json.Unmarshal([]byte(stripped), &parsed)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"a": "http://example.org/#fragment",
+		"b": "/* not a comment */",
+		"c": `escaped " quote // not a comment`,
+	}, parsed)
+}
+
+// Test that the Control Agent configuration spread over multiple files
+// with comments is parsed.
+func TestReadKeaCAConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keaconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(path.Join(dir, "kea-ctrl-agent.conf"), []byte(`{
+    "Control-agent": {
+        "http-host": "192.0.2.1", // the address
+        "http-port": 8001,
+        "trust-anchor": "/etc/kea/ca.pem",
+        "cert-file": "/etc/kea/ca-cert.pem",
+        "key-file": "/etc/kea/ca-key.pem",
+        "cert-required": false,
+        "authentication": {
+            "type": "basic",
+            "realm": "kea",
+            "clients": [ { "user": "admin", "password": "secret" } ]
+        },
+        /* The sockets are in the separate file. */
+        "control-sockets": <?include "sockets.json"?>
+    }
+}`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "sockets.json"), []byte(`{
+    "dhcp6": { "socket-type": "unix", "socket-name": "/run/kea/dhcp6.sock" },
+    "dhcp4": { "socket-type": "unix", "socket-name": "/run/kea/dhcp4.sock" },
+    "d2": { "socket-type": "unix", "socket-name": "/run/kea/d2.sock" }
+}`), 0600)
+	require.NoError(t, err)
+
+	config, err := readKeaCAConfig("kea-ctrl-agent.conf", dir)
+	require.NoError(t, err)
+
+	address, port := config.getCtrlAddress()
+	require.Equal(t, "192.0.2.1", address)
+	require.EqualValues(t, 8001, port)
+	require.True(t, config.useSecureProtocol())
+
+	// The client certificate is not required.
+	require.Equal(t, &HTTPClientSettings{
+		TrustAnchor: "/etc/kea/ca.pem",
+		BasicAuth: &BasicAuthCredentials{
+			User:     "admin",
+			Password: "secret",
+		},
+	}, config.getHTTPClientSettings())
+
+	sockets := config.getControlSockets()
+	require.Len(t, sockets, 3)
+	require.Equal(t, KeaControlSocket{Daemon: "d2", SocketType: "unix", SocketName: "/run/kea/d2.sock"}, sockets[0])
+	require.Equal(t, "dhcp4", sockets[1].Daemon)
+	require.Equal(t, "dhcp6", sockets[2].Daemon)
+
+	// The included file doesn't exist.
+	err = os.Remove(path.Join(dir, "sockets.json"))
+	require.NoError(t, err)
+	_, err = readKeaCAConfig("kea-ctrl-agent.conf", dir)
+	require.Error(t, err)
+
+	// The file includes itself.
+	err = ioutil.WriteFile(path.Join(dir, "sockets.json"), []byte(`<?include "sockets.json"?>`), 0600)
+	require.NoError(t, err)
+	_, err = readKeaCAConfig("kea-ctrl-agent.conf", dir)
+	require.Error(t, err)
+}
+
+// Test that the client certificate is presented when it is required and
+// that no settings are returned when neither TLS nor the authentication
+// are configured.
+func TestKeaCAConfigHTTPClientSettings(t *testing.T) {
+	config := &keaCAConfig{
+		TrustAnchor: "/etc/kea/ca.pem",
+		CertFile:    "/etc/kea/ca-cert.pem",
+		KeyFile:     "/etc/kea/ca-key.pem",
+	}
+	require.Equal(t, &HTTPClientSettings{
+		TrustAnchor: "/etc/kea/ca.pem",
+		CertFile:    "/etc/kea/ca-cert.pem",
+		KeyFile:     "/etc/kea/ca-key.pem",
+	}, config.getHTTPClientSettings())
+
+	// TLS is not enabled without the trust anchor.
+	config.TrustAnchor = ""
+	require.False(t, config.useSecureProtocol())
+	require.Nil(t, config.getHTTPClientSettings())
+}
+
+// Test that the control socket is read from the configuration of the Kea
+// daemon.
+func TestReadKeaDaemonControlSocket(t *testing.T) {
+	file := writeKeaConfFile(`{
+    "DhcpDdns": {
+        "control-socket": { "socket-type": "unix", "socket-name": "/run/kea/d2.sock" }
+    }
+}`)
+	defer os.Remove(file)
+
+	socket, err := readKeaDaemonControlSocket(file, "", "d2")
+	require.NoError(t, err)
+	require.Equal(t, &KeaControlSocket{Daemon: "d2", SocketType: "unix", SocketName: "/run/kea/d2.sock"}, socket)
+
+	// The file contains the configuration of the other daemon.
+	_, err = readKeaDaemonControlSocket(file, "", "dhcp4")
+	require.Error(t, err)
+}
+
+// Test that the files included by the Kea configuration are found.
+func TestGetKeaConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keaconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(path.Join(dir, "kea-ctrl-agent.conf"), []byte(`{
+    "Control-agent": {
+        // <?include "commented.json"?>
+        "control-sockets": <?include "sockets.json"?>,
+        "hooks-libraries": <?include "/etc/kea/hooks.json"?>
+    }
+}`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "sockets.json"), []byte(`{
+    "dhcp4": <?include "dhcp4-socket.json"?>,
+    "dhcp6": <?include "kea-ctrl-agent.conf"?>
+}`), 0600)
+	require.NoError(t, err)
+
+	// The missing files and the files including each other are returned
+	// once. The includes in the comments are ignored.
+	files := getKeaConfigFiles(path.Join(dir, "kea-ctrl-agent.conf"), dir)
+	require.Equal(t, []string{
+		path.Join(dir, "kea-ctrl-agent.conf"),
+		path.Join(dir, "sockets.json"),
+		path.Join(dir, "dhcp4-socket.json"),
+		"/etc/kea/hooks.json",
+	}, files)
+}
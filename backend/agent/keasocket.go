@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Deadline for sending the command to the Kea daemon over its control
+// socket and receiving the response.
+const keaSocketTimeout = 30 * time.Second
+
+// Sends the command to the Kea daemon over its unix control socket and
+// returns the response. Unlike the Kea Control Agent, the daemon returns
+// a single response rather than the list of responses.
+func sendToKeaOverUnixSocket(socketName string, request []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", socketName, keaSocketTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to Kea control socket %s", socketName)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(keaSocketTimeout))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot set deadline for Kea control socket %s", socketName)
+	}
+	if _, err = conn.Write(request); err != nil {
+		return nil, errors.Wrapf(err, "cannot send command to Kea control socket %s", socketName)
+	}
+
+	// Long responses are sent in multiple chunks, so the response is read
+	// until it is a complete JSON value.
+	var response json.RawMessage
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, errors.Wrapf(err, "cannot read response from Kea control socket %s", socketName)
+	}
+	return response, nil
+}
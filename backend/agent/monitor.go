@@ -2,7 +2,9 @@ package agent
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,19 +19,34 @@ import (
 // An access point for an application to retrieve information such
 // as status or metrics.
 type AccessPoint struct {
-	Type    string
-	Address string
-	Port    int64
-	Key     string
+	Type              string
+	Address           string
+	Port              int64
+	Key               string
+	UseSecureProtocol bool
 }
 
-// Currently supported types are: "control" and "statistics"
+// Currently supported types are: "control", "statistics" and
+// "control-socket". The last one is the unix socket of the Kea daemon
+// running without the Kea Control Agent. Its address is the path to the
+// socket and the port is not used.
 const AccessPointControl = "control"
 const AccessPointStatistics = "statistics"
+const AccessPointControlSocket = "control-socket"
 
 type App struct {
 	Type         string
 	AccessPoints []AccessPoint
+
+	// Control sockets of the Kea daemons configured in the Kea Control
+	// Agent or the control socket of the Kea daemon running without the
+	// Kea Control Agent. Empty for other apps.
+	KeaControlSockets []KeaControlSocket
+
+	// Settings used to connect to the control access point over HTTP,
+	// e.g. the basic auth credentials. They are used by the agent only
+	// and not sent to the server. Nil if no special settings are needed.
+	HTTPClientSettings *HTTPClientSettings
 }
 
 // Currently supported types are: "kea" and "bind9"
@@ -38,16 +55,40 @@ const AppTypeBind9 = "bind9"
 
 type AppMonitor interface {
 	GetApps() []*App
+	// Returns the channel receiving a notification whenever the apps
+	// detected on the host change.
+	AppsChanged() <-chan bool
 	Shutdown()
 }
 
 type appMonitor struct {
-	requests chan chan []*App // input to app monitor, ie. channel for receiving requests
-	quit     chan bool        // channel for stopping app monitor
-	running  bool
-	wg       *sync.WaitGroup
+	requests    chan chan []*App // input to app monitor, ie. channel for receiving requests
+	quit        chan bool        // channel for stopping app monitor
+	appsChanged chan bool        // channel for notifying about changes in detected apps
+	running     bool
+	wg          *sync.WaitGroup
+
+	apps     []*App             // list of detected apps on the host
+	procApps map[int32]*procApp // apps detected in the monitored processes by pid
 
-	apps []*App // list of detected apps on the host
+	configWatcher *configWatcher // nil if config files changes can't be watched
+}
+
+// App detected in a process along with the paths to the configuration file
+// it was detected from and the files the app reads along with it, i.e. the
+// included files and the default rndc key file. The app is nil if the
+// process is one of the monitored programs but its configuration can't be
+// used. Such process is checked again when any of these files changes.
+type procApp struct {
+	app       *App
+	confPaths []string
+}
+
+// Event about a process started or exited on the host. The pid is 0 if
+// some events have been lost, so all processes must be checked again.
+type procEvent struct {
+	pid    int32
+	exited bool
 }
 
 // Names of apps that are being detected.
@@ -56,11 +97,49 @@ const (
 	namedProcName = "named"
 )
 
+// Names of the Kea daemon processes detected when they are not controlled
+// by the Kea Control Agent mapped to the daemon names.
+var keaDaemonProcNames = map[string]string{
+	"kea-dhcp4":     "dhcp4",
+	"kea-dhcp6":     "dhcp6",
+	"kea-dhcp-ddns": "d2",
+}
+
+// Kea app is being detected by browsing list of processes in the systam
+// where cmdline of the process contains given pattern with kea-ctrl-agent
+// substring. Such found processes are being processed further and all other
+// Kea daemons are discovered and queried for their versions, etc.
+var keaPtrn = regexp.MustCompile(`(.*?)kea-ctrl-agent\s+.*-c\s+(\S+)`)
+
+// Kea daemons are also detected on their own, because they may run
+// without the Kea Control Agent, e.g. in the separate network namespaces.
+var keaDaemonPtrn = regexp.MustCompile(`(.*?)kea-(?:dhcp4|dhcp6|dhcp-ddns)\s+.*-c\s+(\S+)`)
+
+// BIND 9 app is being detecting by browsing list of processes in the system
+// where cmdline of the process contains given pattern with named substring.
+var bind9Ptrn = regexp.MustCompile(`(.*?)named\s+(.*)`)
+
+// Interval between the detections of all apps when the processes or the
+// config files can't be watched.
+const detectionInterval = 10 * time.Second
+
+// Interval between the detections of all apps when the processes and the
+// config files are watched. It is a safety net in case some events were
+// missed.
+const fullDetectionInterval = 10 * time.Minute
+
+// Time the monitor waits after an event before the affected processes are
+// checked. The events often come in bursts, e.g. when a daemon forks or an
+// editor saves a file in several steps, so they are handled together once
+// the process has settled down.
+const eventSettleDelay = time.Second
+
 func NewAppMonitor() AppMonitor {
 	sm := &appMonitor{
-		requests: make(chan chan []*App),
-		quit:     make(chan bool),
-		wg:       &sync.WaitGroup{},
+		requests:    make(chan chan []*App),
+		quit:        make(chan bool),
+		appsChanged: make(chan bool, 1),
+		wg:          &sync.WaitGroup{},
 	}
 	sm.wg.Add(1)
 	go sm.run()
@@ -72,14 +151,42 @@ func (sm *appMonitor) run() {
 	sm.running = true
 	defer sm.wg.Done()
 
+	// Processes are watched for start and exit, and config files for
+	// changes, so only the affected apps are detected again. If any of
+	// them can't be watched, all apps are periodically detected instead.
+	var procEvents <-chan procEvent
+	procWatcher, err := newProcWatcher()
+	if err != nil {
+		log.Warnf("cannot watch processes, apps are detected every %s: %+v", detectionInterval, err)
+	} else {
+		defer procWatcher.close()
+		procEvents = procWatcher.events
+	}
+	var configEvents <-chan string
+	sm.configWatcher, err = newConfigWatcher()
+	if err != nil {
+		log.Warnf("cannot watch config files, apps are detected every %s: %+v", detectionInterval, err)
+	} else {
+		defer sm.configWatcher.close()
+		configEvents = sm.configWatcher.events
+	}
+
 	// run app detection one time immediately at startup
 	sm.detectApps()
 
 	// prepare ticker
-	const detectionInterval = 10 * time.Second
-	ticker := time.NewTicker(detectionInterval)
+	interval := fullDetectionInterval
+	if procEvents == nil || configEvents == nil {
+		interval = detectionInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// processes to be checked once the events settle down
+	pending := make(map[int32]bool)
+	detectAll := false
+	var settled <-chan time.Time
+
 	for {
 		select {
 		case ret := <-sm.requests:
@@ -88,7 +195,55 @@ func (sm *appMonitor) run() {
 
 		case <-ticker.C:
 			// periodic detection
-			sm.detectApps()
+			if sm.detectApps() {
+				sm.notifyAppsChanged()
+			}
+
+		case ev := <-procEvents:
+			switch {
+			case ev.pid == 0:
+				// some events were lost
+				detectAll = true
+			case !ev.exited:
+				pending[ev.pid] = true
+			case sm.procApps[ev.pid] != nil:
+				pending[ev.pid] = true
+			default:
+				// exit of a process which is not monitored
+				continue
+			}
+			if settled == nil {
+				settled = time.After(eventSettleDelay)
+			}
+
+		case file := <-configEvents:
+			found := false
+			for pid, pa := range sm.procApps {
+				for _, confPath := range pa.confPaths {
+					if confPath == file {
+						pending[pid] = true
+						found = true
+					}
+				}
+			}
+			if found && settled == nil {
+				settled = time.After(eventSettleDelay)
+			}
+
+		case <-settled:
+			// detection of the apps affected by the events
+			var changed bool
+			if detectAll {
+				changed = sm.detectApps()
+			} else {
+				changed = sm.detectProcApps(pending)
+			}
+			if changed {
+				sm.notifyAppsChanged()
+			}
+			pending = make(map[int32]bool)
+			detectAll = false
+			settled = nil
 
 		case <-sm.quit:
 			// exit run
@@ -99,31 +254,33 @@ func (sm *appMonitor) run() {
 	}
 }
 
+// Checks if the apps are of the same type and have the same access points.
+// Multiple apps of the same type may run on one host, e.g. the Kea daemons
+// without the Kea Control Agent, so they are distinguished by the access
+// points.
+func appsEqual(app1, app2 *App) bool {
+	if app1.Type != app2.Type || len(app1.AccessPoints) != len(app2.AccessPoints) {
+		return false
+	}
+	for idx, point1 := range app1.AccessPoints {
+		point2 := app2.AccessPoints[idx]
+		if point1.Type != point2.Type || point1.Address != point2.Address || point1.Port != point2.Port {
+			return false
+		}
+	}
+	return true
+}
+
 func printNewOrUpdatedApps(newApps []*App, oldApps []*App) {
 	// look for new or updated apps
 	var newUpdatedApps []*App
 	for _, appNew := range newApps {
 		found := false
 		for _, appOld := range oldApps {
-			if appOld.Type != appNew.Type {
-				continue
+			if appsEqual(appNew, appOld) {
+				found = true
+				break
 			}
-			if len(appNew.AccessPoints) != len(appOld.AccessPoints) {
-				continue
-			}
-			for idx, acPtNew := range appNew.AccessPoints {
-				acPtOld := appOld.AccessPoints[idx]
-				if acPtNew.Type != acPtOld.Type {
-					continue
-				}
-				if acPtNew.Address != acPtOld.Address {
-					continue
-				}
-				if acPtNew.Port != acPtOld.Port {
-					continue
-				}
-			}
-			found = true
 		}
 		if !found {
 			newUpdatedApps = append(newUpdatedApps, appNew)
@@ -135,7 +292,12 @@ func printNewOrUpdatedApps(newApps []*App, oldApps []*App) {
 		for _, app := range newUpdatedApps {
 			var acPts []string
 			for _, acPt := range app.AccessPoints {
-				s := fmt.Sprintf("%s: %s:%d", acPt.Type, acPt.Address, acPt.Port)
+				var s string
+				if acPt.Type == AccessPointControlSocket {
+					s = fmt.Sprintf("%s: %s", acPt.Type, acPt.Address)
+				} else {
+					s = fmt.Sprintf("%s: %s:%d", acPt.Type, acPt.Address, acPt.Port)
+				}
 				acPts = append(acPts, s)
 			}
 			log.Printf("   %s: %s", app.Type, strings.Join(acPts, ", "))
@@ -143,68 +305,154 @@ func printNewOrUpdatedApps(newApps []*App, oldApps []*App) {
 	}
 }
 
-func (sm *appMonitor) detectApps() {
-	// Kea app is being detected by browsing list of processes in the systam
-	// where cmdline of the process contains given pattern with kea-ctrl-agent
-	// substring. Such found processes are being processed further and all other
-	// Kea daemons are discovered and queried for their versions, etc.
-	keaPtrn := regexp.MustCompile(`(.*?)kea-ctrl-agent\s+.*-c\s+(\S+)`)
-	// BIND 9 app is being detecting by browsing list of processes in the system
-	// where cmdline of the process contains given pattern with named substring.
-	bind9Ptrn := regexp.MustCompile(`(.*?)named\s+(.*)`)
+// Detects the app in the given process. It returns nil if the process is
+// not one of the monitored programs.
+func detectProcApp(p *process.Process) *procApp {
+	procName, _ := p.Name()
+	keaDaemon, isKeaDaemon := keaDaemonProcNames[procName]
+	if procName != keaProcName && procName != namedProcName && !isKeaDaemon {
+		return nil
+	}
 
-	var apps []*App
+	cmdline, err := p.Cmdline()
+	if err != nil {
+		log.Warnf("cannot get process command line: %+v", err)
+		return nil
+	}
+	cwd, err := p.Cwd()
+	if err != nil {
+		log.Warnf("cannot get process current working directory: %+v", err)
+		cwd = ""
+	}
 
-	procs, _ := process.Processes()
-	for _, p := range procs {
-		procName, _ := p.Name()
-		cmdline := ""
-		cwd := ""
-		var err error
-		if procName == keaProcName || procName == namedProcName {
-			cmdline, err = p.Cmdline()
-			if err != nil {
-				log.Warnf("cannot get process command line: %+v", err)
-				continue
-			}
-			cwd, err = p.Cwd()
-			if err != nil {
-				log.Warnf("cannot get process current working directory: %+v", err)
-				cwd = ""
+	switch {
+	case procName == keaProcName:
+		// detect kea
+		m := keaPtrn.FindStringSubmatch(cmdline)
+		if m != nil {
+			return &procApp{
+				app:       detectKeaApp(m, cwd),
+				confPaths: getKeaConfigFiles(getKeaConfPath(m[2], cwd), cwd),
 			}
 		}
 
-		if procName == keaProcName {
-			// detect kea
-			m := keaPtrn.FindStringSubmatch(cmdline)
-			if m != nil {
-				keaApp := detectKeaApp(m, cwd)
-				if keaApp != nil {
-					apps = append(apps, keaApp)
-				}
+	case isKeaDaemon:
+		// detect kea daemon without kea-ctrl-agent
+		m := keaDaemonPtrn.FindStringSubmatch(cmdline)
+		if m != nil {
+			return &procApp{
+				app:       detectKeaDaemonApp(keaDaemon, m, cwd),
+				confPaths: getKeaConfigFiles(getKeaConfPath(m[2], cwd), cwd),
 			}
-			continue
 		}
 
-		if procName == namedProcName {
-			// detect bind9
-			m := bind9Ptrn.FindStringSubmatch(cmdline)
-			if m != nil {
-				cmdr := &storkutil.RealCommander{}
-				bind9App := detectBind9App(m, cwd, cmdr)
-				if bind9App != nil {
-					apps = append(apps, bind9App)
+	case procName == namedProcName:
+		// detect bind9
+		m := bind9Ptrn.FindStringSubmatch(cmdline)
+		if m != nil {
+			cmdr := &storkutil.RealCommander{}
+			app := detectBind9App(m, cwd, cmdr)
+			confPaths := getNamedConfFiles(getBind9ConfPath(m[2], cwd), cwd)
+			// the default rndc key is used when the controls clause
+			// specifies no key
+			if app != nil {
+				if ctrl, err := getAccessPoint(app, AccessPointControl); err == nil && ctrl.Key == "" {
+					confPaths = append(confPaths, RndcKeyFile)
 				}
 			}
+			return &procApp{
+				app:       app,
+				confPaths: confPaths,
+			}
+		}
+	}
+	return nil
+}
+
+// Detects the apps in all processes running on the host. It returns true
+// if the detected apps have changed.
+func (sm *appMonitor) detectApps() bool {
+	procApps := make(map[int32]*procApp)
+
+	procs, _ := process.Processes()
+	for _, p := range procs {
+		if pa := detectProcApp(p); pa != nil {
+			procApps[p.Pid] = pa
+		}
+	}
+
+	sm.procApps = procApps
+	return sm.updateApps()
+}
+
+// Detects the apps in the given processes again, e.g. because they have
+// started or their configuration has changed. The apps of the processes
+// which don't exist anymore are removed. It returns true if the detected
+// apps have changed.
+func (sm *appMonitor) detectProcApps(pids map[int32]bool) bool {
+	if sm.procApps == nil {
+		sm.procApps = make(map[int32]*procApp)
+	}
+	for pid := range pids {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			delete(sm.procApps, pid)
 			continue
 		}
+		if pa := detectProcApp(p); pa != nil {
+			sm.procApps[pid] = pa
+		} else {
+			delete(sm.procApps, pid)
+		}
 	}
+	return sm.updateApps()
+}
+
+// Builds the list of apps from the apps detected in the processes and
+// watches their config files. It returns true if the list has changed.
+func (sm *appMonitor) updateApps() bool {
+	// keep the apps in the order of the processes, so the lists
+	// can be compared
+	var pids []int
+	for pid := range sm.procApps {
+		pids = append(pids, int(pid))
+	}
+	sort.Ints(pids)
+
+	var apps []*App
+	var confPaths []string
+	for _, pid := range pids {
+		pa := sm.procApps[int32(pid)]
+		if pa.app != nil {
+			apps = append(apps, pa.app)
+		}
+		confPaths = append(confPaths, pa.confPaths...)
+	}
+
+	// the daemons controlled by the detected kea-ctrl-agents are
+	// monitored via kea-ctrl-agent
+	apps = removeKeaDaemonsBehindCA(apps)
 
 	// check changes in apps and print them
 	printNewOrUpdatedApps(apps, sm.apps)
+	changed := !reflect.DeepEqual(apps, sm.apps)
 
 	// remember detected apps
 	sm.apps = apps
+
+	if sm.configWatcher != nil {
+		sm.configWatcher.watch(confPaths)
+	}
+	return changed
+}
+
+// Notifies about the changes in the detected apps. The notification is
+// dropped if the previous one hasn't been received yet.
+func (sm *appMonitor) notifyAppsChanged() {
+	select {
+	case sm.appsChanged <- true:
+	default:
+	}
 }
 
 func (sm *appMonitor) GetApps() []*App {
@@ -214,6 +462,10 @@ func (sm *appMonitor) GetApps() []*App {
 	return srvs
 }
 
+func (sm *appMonitor) AppsChanged() <-chan bool {
+	return sm.appsChanged
+}
+
 func (sm *appMonitor) Shutdown() {
 	sm.quit <- true
 	sm.wg.Wait()
@@ -226,7 +478,8 @@ func getAccessPoint(app *App, accessType string) (*AccessPoint, error) {
 			continue
 		}
 
-		if point.Port == 0 {
+		// The control socket is a file, so it has no port.
+		if point.Port == 0 && point.Type != AccessPointControlSocket {
 			return nil, errors.Errorf("%s access point does not have port number", accessType)
 		} else if len(point.Address) == 0 {
 			return nil, errors.Errorf("%s access point does not have address", accessType)
@@ -3,6 +3,7 @@ package agent
 import (
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"path"
 	"testing"
@@ -20,104 +21,100 @@ func TestGetApps(t *testing.T) {
 func TestGetCtrlAddressFromKeaConfigNonExisting(t *testing.T) {
 	// check reading from non existing file
 	path := "/tmp/non-exisiting-path"
-	address, port := getCtrlAddressFromKeaConfig(path)
-	require.EqualValues(t, 0, port)
-	require.Empty(t, address)
+	config, err := readKeaCAConfig(path, "")
+	require.Error(t, err)
+	require.Nil(t, config)
 }
 
-func TestGetCtrlFromKeaConfigBadContent(t *testing.T) {
-	// prepare kea conf file
+// Writes the Kea configuration to the temporary file and returns its path.
+func writeKeaConfFile(text string) string {
 	tmpFile, err := ioutil.TempFile(os.TempDir(), "prefix-")
 	if err != nil {
 		log.Fatal("Cannot create temporary file", err)
 	}
-	defer os.Remove(tmpFile.Name())
-
-	text := []byte("random content")
-	if _, err = tmpFile.Write(text); err != nil {
+	if _, err = tmpFile.Write([]byte(text)); err != nil {
 		log.Fatal("Failed to write to temporary file", err)
 	}
 	if err := tmpFile.Close(); err != nil {
 		log.Fatal(err)
 	}
+	return tmpFile.Name()
+}
+
+func TestGetCtrlFromKeaConfigBadContent(t *testing.T) {
+	// prepare kea conf file
+	path := writeKeaConfFile("random content")
+	defer os.Remove(path)
 
 	// check reading from prepared file with bad content
-	// so 0 should be returned as port
-	address, port := getCtrlAddressFromKeaConfig(tmpFile.Name())
-	require.EqualValues(t, 0, port)
-	require.Empty(t, address)
+	config, err := readKeaCAConfig(path, "")
+	require.Error(t, err)
+	require.Nil(t, config)
+
+	// the file doesn't contain the Control-agent map
+	path2 := writeKeaConfFile(`{ "Dhcp4": { "http-host": "host.example.org", "http-port": 1234 } }`)
+	defer os.Remove(path2)
+	config, err = readKeaCAConfig(path2, "")
+	require.Error(t, err)
+	require.Nil(t, config)
 }
 
 func TestGetCtrlAddressFromKeaConfigOk(t *testing.T) {
 	// prepare kea conf file
-	tmpFile, err := ioutil.TempFile(os.TempDir(), "prefix-")
-	if err != nil {
-		log.Fatal("Cannot create temporary file", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	text := []byte(string("\"http-host\": \"host.example.org\", \"http-port\": 1234"))
-	if _, err = tmpFile.Write(text); err != nil {
-		log.Fatal("Failed to write to temporary file", err)
-	}
-	if err := tmpFile.Close(); err != nil {
-		log.Fatal(err)
-	}
+	path := writeKeaConfFile(`{ "Control-agent": { "http-host": "host.example.org", "http-port": 1234 } }`)
+	defer os.Remove(path)
 
 	// check reading from proper file
-	address, port := getCtrlAddressFromKeaConfig(tmpFile.Name())
+	config, err := readKeaCAConfig(path, "")
+	require.NoError(t, err)
+	address, port := config.getCtrlAddress()
 	require.EqualValues(t, 1234, port)
 	require.Equal(t, "host.example.org", address)
 }
 
 func TestGetCtrlAddressFromKeaConfigAddress0000(t *testing.T) {
 	// prepare kea conf file
-	tmpFile, err := ioutil.TempFile(os.TempDir(), "prefix-")
-	if err != nil {
-		log.Fatal("Cannot create temporary file", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	text := []byte(string("\"http-host\": \"0.0.0.0\", \"http-port\": 1234"))
-	if _, err = tmpFile.Write(text); err != nil {
-		log.Fatal("Failed to write to temporary file", err)
-	}
-	if err := tmpFile.Close(); err != nil {
-		log.Fatal(err)
-	}
+	path := writeKeaConfFile(`{ "Control-agent": { "http-host": "0.0.0.0", "http-port": 1234 } }`)
+	defer os.Remove(path)
 
 	// check reading from proper file;
 	// if CA is listening on 0.0.0.0 then 127.0.0.1 should be returned
 	// as it is not possible to connect to 0.0.0.0
-	address, port := getCtrlAddressFromKeaConfig(tmpFile.Name())
+	config, err := readKeaCAConfig(path, "")
+	require.NoError(t, err)
+	address, port := config.getCtrlAddress()
 	require.EqualValues(t, 1234, port)
 	require.Equal(t, "127.0.0.1", address)
 }
 
 func TestGetCtrlAddressFromKeaConfigAddressColons(t *testing.T) {
 	// prepare kea conf file
-	tmpFile, err := ioutil.TempFile(os.TempDir(), "prefix-")
-	if err != nil {
-		log.Fatal("Cannot create temporary file", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	text := []byte(string("\"http-host\": \"::\", \"http-port\": 1234"))
-	if _, err = tmpFile.Write(text); err != nil {
-		log.Fatal("Failed to write to temporary file", err)
-	}
-	if err := tmpFile.Close(); err != nil {
-		log.Fatal(err)
-	}
+	path := writeKeaConfFile(`{ "Control-agent": { "http-host": "::", "http-port": 1234 } }`)
+	defer os.Remove(path)
 
 	// check reading from proper file;
 	// if CA is listening on :: then ::1 should be returned
 	// as it is not possible to connect to ::
-	address, port := getCtrlAddressFromKeaConfig(tmpFile.Name())
+	config, err := readKeaCAConfig(path, "")
+	require.NoError(t, err)
+	address, port := config.getCtrlAddress()
 	require.EqualValues(t, 1234, port)
 	require.Equal(t, "::1", address)
 }
 
+// Test that the defaults used by Kea are returned when the address and
+// port are not specified.
+func TestGetCtrlAddressFromKeaConfigDefaults(t *testing.T) {
+	path := writeKeaConfFile(`{ "Control-agent": { } }`)
+	defer os.Remove(path)
+
+	config, err := readKeaCAConfig(path, "")
+	require.NoError(t, err)
+	address, port := config.getCtrlAddress()
+	require.EqualValues(t, 8000, port)
+	require.Equal(t, "127.0.0.1", address)
+}
+
 func TestDetectApps(t *testing.T) {
 	am := &appMonitor{}
 	am.detectApps()
@@ -126,7 +123,7 @@ func TestDetectApps(t *testing.T) {
 type TestCommander struct{}
 
 func (c TestCommander) Output(command string, args ...string) ([]byte, error) {
-	text := `keys "foo" {
+	text := `key "foo" {
                       algorithm "hmac-md5";
                       secret "abcd";
                  };
@@ -172,7 +169,19 @@ func makeKeaConfFile() (file *os.File, removeFunc func(string) error) {
 	}
 	removeFunc = os.Remove
 
-	text := []byte("\"http-host\": \"localhost\", \"http-port\": 45634")
+	text := []byte(`{
+    "Control-agent": {
+        // The CA listens on the loopback.
+        "http-host": "localhost",
+        "http-port": 45634,
+        "control-sockets": {
+            "dhcp4": {
+                "socket-type": "unix",
+                "socket-name": "/tmp/kea-dhcp4-ctrl.sock"
+            }
+        }
+    }
+}`)
 	if _, err = file.Write(text); err != nil {
 		log.Fatal("Failed to write to temporary file", err)
 	}
@@ -197,6 +206,9 @@ func TestDetectKeaApp(t *testing.T) {
 		require.Equal(t, "localhost", ctrlPoint.Address)
 		require.EqualValues(t, 45634, ctrlPoint.Port)
 		require.Empty(t, ctrlPoint.Key)
+		require.False(t, ctrlPoint.UseSecureProtocol)
+		require.Equal(t, []KeaControlSocket{{Daemon: "dhcp4", SocketType: "unix", SocketName: "/tmp/kea-dhcp4-ctrl.sock"}}, app.KeaControlSockets)
+		require.Nil(t, app.HTTPClientSettings)
 	}
 
 	// check kea app detection
@@ -209,6 +221,64 @@ func TestDetectKeaApp(t *testing.T) {
 	checkApp(app)
 }
 
+// Test that the Kea daemon running without the Kea Control Agent is
+// detected with its control socket.
+func TestDetectKeaDaemonApp(t *testing.T) {
+	file := writeKeaConfFile(`{
+    "Dhcp4": {
+        # The socket used by the agent.
+        "control-socket": {
+            "socket-type": "unix",
+            "socket-name": "/run/kea/dhcp4.sock"
+        }
+    }
+}`)
+	defer os.Remove(file)
+
+	app := detectKeaDaemonApp("dhcp4", []string{"", "", file}, "")
+	require.NotNil(t, app)
+	require.Equal(t, AppTypeKea, app.Type)
+	require.Equal(t, []AccessPoint{{Type: AccessPointControlSocket, Address: "/run/kea/dhcp4.sock"}}, app.AccessPoints)
+	require.Equal(t, []KeaControlSocket{{Daemon: "dhcp4", SocketType: "unix", SocketName: "/run/kea/dhcp4.sock"}}, app.KeaControlSockets)
+
+	point, err := getAccessPoint(app, AccessPointControlSocket)
+	require.NoError(t, err)
+	require.Equal(t, "/run/kea/dhcp4.sock", point.Address)
+
+	// The configuration of the other daemon.
+	require.Nil(t, detectKeaDaemonApp("dhcp6", []string{"", "", file}, ""))
+
+	// The daemon can't be contacted without the control socket.
+	noSocketFile := writeKeaConfFile(`{ "Dhcp4": { "interfaces-config": { "interfaces": [] } } }`)
+	defer os.Remove(noSocketFile)
+	require.Nil(t, detectKeaDaemonApp("dhcp4", []string{"", "", noSocketFile}, ""))
+}
+
+// Test that the Kea daemons controlled by the detected Kea Control Agents
+// are not reported as separate apps.
+func TestRemoveKeaDaemonsBehindCA(t *testing.T) {
+	caApp := &App{
+		Type:              AppTypeKea,
+		AccessPoints:      makeAccessPoint(AccessPointControl, "127.0.0.1", "", 8000),
+		KeaControlSockets: []KeaControlSocket{{Daemon: "dhcp4", SocketType: "unix", SocketName: "/run/kea/dhcp4.sock"}},
+	}
+	behindCA := &App{
+		Type:         AppTypeKea,
+		AccessPoints: []AccessPoint{{Type: AccessPointControlSocket, Address: "/run/kea/dhcp4.sock"}},
+	}
+	withoutCA := &App{
+		Type:         AppTypeKea,
+		AccessPoints: []AccessPoint{{Type: AccessPointControlSocket, Address: "/run/kea/dhcp6.sock"}},
+	}
+	bind9App := &App{
+		Type:         AppTypeBind9,
+		AccessPoints: makeAccessPoint(AccessPointControl, "127.0.0.1", "", 953),
+	}
+
+	apps := removeKeaDaemonsBehindCA([]*App{behindCA, caApp, withoutCA, bind9App})
+	require.Equal(t, []*App{caApp, withoutCA, bind9App}, apps)
+}
+
 func TestGetAccessPoint(t *testing.T) {
 	bind9App := &App{
 		Type: AppTypeBind9,
@@ -306,4 +376,73 @@ func TestPrintNewOrUpdatedApps(t *testing.T) {
 	var oldApps []*App
 
 	printNewOrUpdatedApps(newApps, oldApps)
+
+	// The apps of the same type are distinguished by the access points.
+	require.True(t, appsEqual(keaApp, keaApp))
+	require.False(t, appsEqual(keaApp, bind9App))
+	otherKeaApp := &App{
+		Type:         AppTypeKea,
+		AccessPoints: makeAccessPoint(AccessPointControl, "localhost", "", 45635),
+	}
+	require.False(t, appsEqual(keaApp, otherKeaApp))
+}
+
+// Test that the config paths are taken from the command line and are
+// relative to the current working directory of the process.
+func TestGetConfPaths(t *testing.T) {
+	require.Equal(t, "/etc/kea/kea-ctrl-agent.conf", getKeaConfPath("/etc/kea/kea-ctrl-agent.conf", "/tmp"))
+	require.Equal(t, "/tmp/kea-ctrl-agent.conf", getKeaConfPath("kea-ctrl-agent.conf", "/tmp"))
+
+	require.Equal(t, "/etc/named.conf", getBind9ConfPath("-u bind -c /etc/named.conf", "/tmp"))
+	require.Equal(t, "/tmp/named.conf", getBind9ConfPath("-c named.conf -f", "/tmp"))
+	require.Equal(t, defaultNamedConfFile, getBind9ConfPath("-u bind", "/tmp"))
+}
+
+// Test that the changes of the apps detected in the processes are
+// reported and the apps of the processes which don't exist are removed.
+func TestUpdateApps(t *testing.T) {
+	am := &appMonitor{
+		appsChanged: make(chan bool, 1),
+	}
+
+	keaApp := &App{
+		Type:         AppTypeKea,
+		AccessPoints: makeAccessPoint(AccessPointControl, "localhost", "", 45634),
+	}
+	bind9App := &App{
+		Type:         AppTypeBind9,
+		AccessPoints: makeAccessPoint(AccessPointControl, "127.0.0.1", "", 953),
+	}
+	am.procApps = map[int32]*procApp{
+		20: {app: bind9App, confPaths: []string{"/etc/bind/named.conf"}},
+		10: {app: keaApp, confPaths: []string{"/etc/kea/kea-ctrl-agent.conf"}},
+		30: {app: nil, confPaths: []string{"/etc/kea/kea-dhcp4.conf"}},
+	}
+	require.True(t, am.updateApps())
+	require.Len(t, am.apps, 2)
+	require.Equal(t, keaApp, am.apps[0])
+	require.Equal(t, bind9App, am.apps[1])
+
+	// Nothing has changed.
+	require.False(t, am.updateApps())
+
+	// The process with the invalid configuration doesn't exist so it is
+	// not monitored anymore but the apps are the same.
+	pid := int32(math.MaxInt32)
+	am.procApps[pid] = am.procApps[30]
+	delete(am.procApps, 30)
+	require.False(t, am.detectProcApps(map[int32]bool{pid: true}))
+	require.NotContains(t, am.procApps, pid)
+
+	// The process of BIND 9 has exited.
+	am.procApps[pid] = am.procApps[20]
+	delete(am.procApps, 20)
+	require.True(t, am.detectProcApps(map[int32]bool{pid: true}))
+	require.Len(t, am.apps, 1)
+	require.Equal(t, keaApp, am.apps[0])
+
+	// Only one notification is pending.
+	am.notifyAppsChanged()
+	am.notifyAppsChanged()
+	require.Len(t, am.AppsChanged(), 1)
 }
@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants of the netlink process events connector, see
+// linux/connector.h and linux/cn_proc.h.
+const (
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	procEventFork     = 0x00000001
+	procEventExec     = 0x00000002
+	procEventExit     = 0x80000000
+	cnMsgSize         = 20 // struct cn_msg without data
+	procEventHdrSize  = 16 // what, cpu and timestamp_ns of struct proc_event
+)
+
+// Size of the buffers the process and inotify events are read into.
+const eventsBufSize = 64 * 1024
+
+// The connector messages are in the host byte order.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Watches the processes started and exited on the host using the netlink
+// process events connector. It requires root privileges.
+type procWatcher struct {
+	file   *os.File
+	events chan procEvent
+	done   chan bool
+}
+
+// Subscribes to the process events and starts receiving them.
+func newProcWatcher() (*procWatcher, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with creating netlink connector socket")
+	}
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: cnIdxProc,
+	}
+	err = syscall.Bind(fd, addr)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrapf(err, "problem with binding netlink connector socket")
+	}
+
+	// struct nlmsghdr followed by struct cn_msg with the listen operation
+	msg := make([]byte, syscall.NLMSG_HDRLEN+cnMsgSize+4)
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[12:], uint32(os.Getpid()))
+	cn := msg[syscall.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(cn[0:], cnIdxProc)
+	nativeEndian.PutUint32(cn[4:], cnValProc)
+	nativeEndian.PutUint16(cn[16:], 4)
+	nativeEndian.PutUint32(cn[cnMsgSize:], procCnMcastListen)
+	err = syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrapf(err, "problem with subscribing to process events")
+	}
+
+	w := &procWatcher{
+		// The socket is non-blocking, so closing the file interrupts
+		// pending reads.
+		file:   os.NewFile(uintptr(fd), "proc-connector"),
+		events: make(chan procEvent, 256),
+		done:   make(chan bool),
+	}
+	go w.receive()
+	return w, nil
+}
+
+// Receives the process events until the watcher is closed.
+func (w *procWatcher) receive() {
+	buf := make([]byte, eventsBufSize)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.ENOBUFS {
+				// the events came faster than they were read
+				if !w.send(procEvent{}) {
+					return
+				}
+				continue
+			}
+			select {
+			case <-w.done:
+			default:
+				log.Errorf("problem with receiving process events: %+v", err)
+			}
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			log.Warnf("cannot parse process events: %+v", err)
+			continue
+		}
+		for _, msg := range msgs {
+			if ev, ok := parseProcEvent(msg.Data); ok {
+				if !w.send(ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Sends the event to the monitor. It returns false if the watcher has been
+// closed in the meantime.
+func (w *procWatcher) send(ev procEvent) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// Parses struct cn_msg carrying struct proc_event. Only the events about
+// the processes are returned, the events about the threads are ignored.
+func parseProcEvent(data []byte) (procEvent, bool) {
+	if len(data) < cnMsgSize+procEventHdrSize+16 {
+		return procEvent{}, false
+	}
+	ev := data[cnMsgSize:]
+	what := nativeEndian.Uint32(ev[0:])
+	args := ev[procEventHdrSize:]
+	switch what {
+	case procEventFork:
+		// parent pid and tgid followed by child pid and tgid
+		pid := nativeEndian.Uint32(args[8:])
+		tgid := nativeEndian.Uint32(args[12:])
+		if pid == tgid {
+			return procEvent{pid: int32(pid)}, true
+		}
+	case procEventExec, procEventExit:
+		pid := nativeEndian.Uint32(args[0:])
+		tgid := nativeEndian.Uint32(args[4:])
+		if pid == tgid {
+			return procEvent{pid: int32(pid), exited: what == procEventExit}, true
+		}
+	}
+	return procEvent{}, false
+}
+
+// Stops receiving the process events.
+func (w *procWatcher) close() {
+	close(w.done)
+	w.file.Close()
+}
+
+// Watches the config files for changes using inotify. The directories
+// containing the files are watched rather than the files themselves,
+// because the editors often replace the file with a new one.
+type configWatcher struct {
+	file   *os.File
+	fd     int
+	events chan string
+	done   chan bool
+
+	mutex sync.Mutex
+	dirs  map[int32]string // watched directories by watch descriptor
+	wds   map[string]int32 // watch descriptors by directory
+}
+
+// Creates the inotify instance and starts receiving the changes of the
+// files in the watched directories.
+func newConfigWatcher() (*configWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with creating inotify instance")
+	}
+	w := &configWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		events: make(chan string, 16),
+		done:   make(chan bool),
+		dirs:   make(map[int32]string),
+		wds:    make(map[string]int32),
+	}
+	go w.receive()
+	return w, nil
+}
+
+// Watches the directories of the given files and stops watching the
+// directories which don't contain any of them.
+func (w *configWatcher) watch(files []string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		dirs[path.Dir(file)] = true
+	}
+	for dir, wd := range w.wds {
+		if !dirs[dir] {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, dir)
+			delete(w.dirs, wd)
+		}
+	}
+	for dir := range dirs {
+		if _, ok := w.wds[dir]; ok {
+			continue
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_CREATE|
+			syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO)
+		if err != nil {
+			log.Warnf("cannot watch config files in %s: %+v", dir, err)
+			continue
+		}
+		w.wds[dir] = int32(wd)
+		w.dirs[int32(wd)] = dir
+	}
+}
+
+// Receives the inotify events until the watcher is closed and sends the
+// paths of the changed files to the monitor.
+func (w *configWatcher) receive() {
+	buf := make([]byte, eventsBufSize)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				log.Errorf("problem with receiving config file events: %+v", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(ev.Len)
+			if offset > n {
+				break
+			}
+
+			w.mutex.Lock()
+			dir, ok := w.dirs[ev.Wd]
+			if ev.Mask&syscall.IN_IGNORED != 0 && ok {
+				// the directory has been removed
+				delete(w.dirs, ev.Wd)
+				delete(w.wds, dir)
+			}
+			w.mutex.Unlock()
+			if !ok || ev.Len == 0 {
+				continue
+			}
+
+			name := string(buf[nameStart:offset])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			select {
+			case w.events <- path.Join(dir, name):
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Stops watching the config files.
+func (w *configWatcher) close() {
+	close(w.done)
+	w.file.Close()
+}
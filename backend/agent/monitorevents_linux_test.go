@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Builds struct cn_msg carrying struct proc_event of the given type with
+// the given arguments.
+func makeProcEventMsg(what uint32, args ...uint32) []byte {
+	data := make([]byte, cnMsgSize+procEventHdrSize+24)
+	nativeEndian.PutUint32(data[cnMsgSize:], what)
+	for i, arg := range args {
+		nativeEndian.PutUint32(data[cnMsgSize+procEventHdrSize+4*i:], arg)
+	}
+	return data
+}
+
+// Test that the process events are parsed and the thread events are
+// ignored.
+func TestParseProcEvent(t *testing.T) {
+	ev, ok := parseProcEvent(makeProcEventMsg(procEventExec, 123, 123))
+	require.True(t, ok)
+	require.Equal(t, procEvent{pid: 123}, ev)
+
+	ev, ok = parseProcEvent(makeProcEventMsg(procEventExit, 123, 123, 0, 17))
+	require.True(t, ok)
+	require.Equal(t, procEvent{pid: 123, exited: true}, ev)
+
+	ev, ok = parseProcEvent(makeProcEventMsg(procEventFork, 100, 100, 123, 123))
+	require.True(t, ok)
+	require.Equal(t, procEvent{pid: 123}, ev)
+
+	// threads
+	_, ok = parseProcEvent(makeProcEventMsg(procEventFork, 100, 100, 124, 100))
+	require.False(t, ok)
+	_, ok = parseProcEvent(makeProcEventMsg(procEventExit, 124, 100))
+	require.False(t, ok)
+
+	// other event and truncated message
+	_, ok = parseProcEvent(makeProcEventMsg(0x4, 123, 123))
+	require.False(t, ok)
+	_, ok = parseProcEvent(make([]byte, cnMsgSize))
+	require.False(t, ok)
+}
+
+// Test that the started and exited processes are reported. It requires
+// root privileges, so it is skipped otherwise.
+func TestProcWatcher(t *testing.T) {
+	w, err := newProcWatcher()
+	if err != nil {
+		t.Skipf("cannot watch processes: %v", err)
+	}
+	defer w.close()
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	pid := int32(cmd.Process.Pid)
+
+	started := false
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-w.events:
+			if ev.pid != pid {
+				continue
+			}
+			if !ev.exited {
+				started = true
+				continue
+			}
+			require.True(t, started)
+			return
+		case <-timeout:
+			require.FailNow(t, "process events not received")
+		}
+	}
+}
+
+// Test that the changes of the files in the watched directories are
+// reported.
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stork-agent-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := newConfigWatcher()
+	require.NoError(t, err)
+	defer w.close()
+
+	file := path.Join(dir, "kea-ctrl-agent.conf")
+	w.watch([]string{file})
+	require.Len(t, w.wds, 1)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("{}"), 0600))
+	select {
+	case changed := <-w.events:
+		require.Equal(t, file, changed)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "config file change not received")
+	}
+
+	// The directory is not watched when no file in it is watched.
+	w.watch(nil)
+	require.Empty(t, w.wds)
+	require.Empty(t, w.dirs)
+}
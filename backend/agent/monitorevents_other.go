@@ -0,0 +1,37 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The processes can't be watched on this system, so the apps are
+// periodically detected instead.
+type procWatcher struct {
+	events chan procEvent
+}
+
+func newProcWatcher() (*procWatcher, error) {
+	return nil, errors.New("process events are not supported on this system")
+}
+
+func (w *procWatcher) close() {
+}
+
+// The config files can't be watched on this system, so the apps are
+// periodically detected instead.
+type configWatcher struct {
+	events chan string
+}
+
+func newConfigWatcher() (*configWatcher, error) {
+	return nil, errors.New("config file events are not supported on this system")
+}
+
+func (w *configWatcher) watch(files []string) {
+}
+
+func (w *configWatcher) close() {
+}
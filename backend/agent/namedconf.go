@@ -0,0 +1,548 @@
+package agent
+
+import (
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Maximal depth of the nested include statements. It protects against
+// the files including each other.
+const namedConfMaxIncludeDepth = 16
+
+// Parsed named configuration. The configuration consists of statements
+// terminated with semicolons. Each statement is a list of values: words,
+// quoted strings and blocks of nested statements enclosed in braces, e.g.
+//
+//    zone "example.org" in {
+//        type master;
+//        file "example.org.db";
+//    };
+//
+// This generic representation covers all statements and their nested
+// clauses. The functions returning the keys, the control and statistics
+// channels, the views and the zones are built on top of it.
+type NamedConf struct {
+	Statements []*NamedConfStatement
+}
+
+// Statement of the named configuration.
+type NamedConfStatement struct {
+	Values []NamedConfValue
+}
+
+// Value in the statement: a word or a quoted string held in Text, or a
+// block of statements if IsBlock is true.
+type NamedConfValue struct {
+	Text    string
+	IsBlock bool
+	Block   []*NamedConfStatement
+}
+
+// Key defined in the key statement.
+type NamedConfKey struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+// The inet clause of the controls or statistics-channels statement. The
+// port is 0 if it is not specified.
+type NamedConfInet struct {
+	Address string
+	Port    int64
+	Allow   []string
+	Keys    []string
+}
+
+// Zone defined at the top level or in a view. The view is empty for the
+// top level zones.
+type NamedConfZone struct {
+	Name  string
+	Class string
+	View  string
+	Type  string
+	File  string
+}
+
+// View defined in the view statement.
+type NamedConfView struct {
+	Name  string
+	Class string
+	Zones []NamedConfZone
+}
+
+// Token of the named configuration.
+type namedConfToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// Checks if the token is the given unquoted special character.
+func (t *namedConfToken) is(special string) bool {
+	return !t.quoted && t.text == special
+}
+
+// Splits the configuration text into tokens: words, quoted strings,
+// braces and semicolons. The comments in C, C++ and shell styles are
+// skipped.
+func tokenizeNamedConf(text string) ([]namedConfToken, error) {
+	tokens := []namedConfToken{}
+	line := 1
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(text[i:i+2+end], "\n")
+			i += end + 4
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, namedConfToken{text: string(c), line: line})
+			i++
+		case c == '"':
+			start := line
+			value := strings.Builder{}
+			i++
+			for ; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				if text[i] == '\n' {
+					line++
+				}
+				value.WriteByte(text[i])
+			}
+			if i == len(text) {
+				return nil, errors.Errorf("line %d: unterminated string", start)
+			}
+			tokens = append(tokens, namedConfToken{text: value.String(), quoted: true, line: start})
+			i++
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\r\n{};\"#", rune(text[i])) &&
+				!strings.HasPrefix(text[i:], "//") && !strings.HasPrefix(text[i:], "/*") {
+				i++
+			}
+			tokens = append(tokens, namedConfToken{text: text[start:i], line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// Parses the statements from the tokens starting at the given position
+// until the end of the tokens or, if nested is true, until the closing
+// brace. It returns the statements and the position of the first token
+// following them.
+func parseNamedConfStatements(tokens []namedConfToken, pos int, nested bool) ([]*NamedConfStatement, int, error) {
+	statements := []*NamedConfStatement{}
+	for pos < len(tokens) {
+		token := &tokens[pos]
+		switch {
+		case token.is("}"):
+			if !nested {
+				return nil, pos, errors.Errorf("line %d: unexpected }", token.line)
+			}
+			return statements, pos, nil
+		case token.is(";"):
+			pos++
+			continue
+		}
+
+		statement := &NamedConfStatement{}
+		for {
+			if pos == len(tokens) {
+				return nil, pos, errors.Errorf("line %d: missing ; at the end of the configuration", tokens[pos-1].line)
+			}
+			token = &tokens[pos]
+			if token.is(";") {
+				pos++
+				break
+			}
+			if token.is("}") {
+				return nil, pos, errors.Errorf("line %d: missing ; before }", token.line)
+			}
+			if token.is("{") {
+				block, next, err := parseNamedConfStatements(tokens, pos+1, true)
+				if err != nil {
+					return nil, next, err
+				}
+				if next == len(tokens) {
+					return nil, next, errors.Errorf("line %d: missing }", token.line)
+				}
+				statement.Values = append(statement.Values, NamedConfValue{IsBlock: true, Block: block})
+				pos = next + 1
+				continue
+			}
+			statement.Values = append(statement.Values, NamedConfValue{Text: token.text})
+			pos++
+		}
+		statements = append(statements, statement)
+	}
+	return statements, pos, nil
+}
+
+// Parses the named configuration text. The include statements are not
+// expanded. Use ParseNamedConfFile to parse the configuration spread over
+// multiple files or parse the output of named-checkconf -p.
+func ParseNamedConf(text string) (*NamedConf, error) {
+	tokens, err := tokenizeNamedConf(text)
+	if err != nil {
+		return nil, err
+	}
+	statements, _, err := parseNamedConfStatements(tokens, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return &NamedConf{Statements: statements}, nil
+}
+
+// Parses the named configuration file and the files it includes. The
+// relative paths of the included files are resolved against the given
+// directory, i.e. the working directory of named.
+func ParseNamedConfFile(file, dir string) (*NamedConf, error) {
+	statements, err := parseNamedConfFile(file, dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &NamedConf{Statements: statements}, nil
+}
+
+// Parses the file and replaces the include statements with the statements
+// from the included files.
+func parseNamedConfFile(file, dir string, depth int) ([]*NamedConfStatement, error) {
+	if depth > namedConfMaxIncludeDepth {
+		return nil, errors.Errorf("too many nested includes in %s", file)
+	}
+	if !path.IsAbs(file) {
+		file = path.Join(dir, file)
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read named configuration file %s", file)
+	}
+	conf, err := ParseNamedConf(string(text))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "cannot parse named configuration file %s", file)
+	}
+	return expandNamedConfIncludes(conf.Statements, dir, depth)
+}
+
+// Returns the paths of the named configuration file and the files it
+// includes, also indirectly. The included files which can't be read are
+// returned too, so the monitor notices when they appear.
+func getNamedConfFiles(file, dir string) []string {
+	files := []string{}
+	collectNamedConfFiles(file, dir, 0, &files)
+	return files
+}
+
+// Appends the path of the file and the files it includes to the list.
+func collectNamedConfFiles(file, dir string, depth int, files *[]string) {
+	if !path.IsAbs(file) {
+		file = path.Join(dir, file)
+	}
+	for _, f := range *files {
+		if f == file {
+			return
+		}
+	}
+	*files = append(*files, file)
+	if depth >= namedConfMaxIncludeDepth {
+		return
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	conf, err := ParseNamedConf(string(text))
+	if err != nil {
+		return
+	}
+	for _, included := range findNamedConfIncludes(conf.Statements) {
+		collectNamedConfFiles(included, dir, depth+1, files)
+	}
+}
+
+// Returns the files included by the statements, also in the nested blocks.
+func findNamedConfIncludes(statements []*NamedConfStatement) []string {
+	files := []string{}
+	for _, statement := range statements {
+		if statement.Keyword() == "include" {
+			files = append(files, statement.Arg(0))
+			continue
+		}
+		for i := range statement.Values {
+			if statement.Values[i].IsBlock {
+				files = append(files, findNamedConfIncludes(statement.Values[i].Block)...)
+			}
+		}
+	}
+	return files
+}
+
+// Replaces the include statements, also in the nested blocks, with the
+// statements from the included files.
+func expandNamedConfIncludes(statements []*NamedConfStatement, dir string, depth int) ([]*NamedConfStatement, error) {
+	expanded := []*NamedConfStatement{}
+	for _, statement := range statements {
+		if statement.Keyword() == "include" {
+			included, err := parseNamedConfFile(statement.Arg(0), dir, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, included...)
+			continue
+		}
+		for i := range statement.Values {
+			if !statement.Values[i].IsBlock {
+				continue
+			}
+			block, err := expandNamedConfIncludes(statement.Values[i].Block, dir, depth)
+			if err != nil {
+				return nil, err
+			}
+			statement.Values[i].Block = block
+		}
+		expanded = append(expanded, statement)
+	}
+	return expanded, nil
+}
+
+// Returns the first value of the statement, e.g. zone for the zone
+// statement.
+func (s *NamedConfStatement) Keyword() string {
+	if len(s.Values) == 0 {
+		return ""
+	}
+	return s.Values[0].Text
+}
+
+// Returns the i-th value following the keyword which is not a block or
+// an empty string if there is no such value.
+func (s *NamedConfStatement) Arg(i int) string {
+	if len(s.Values) == 0 {
+		return ""
+	}
+	for _, value := range s.Values[1:] {
+		if value.IsBlock {
+			continue
+		}
+		if i == 0 {
+			return value.Text
+		}
+		i--
+	}
+	return ""
+}
+
+// Returns the value following the given word, e.g. the port number for
+// the word port in the inet clause, or an empty string if the word is
+// not present.
+func (s *NamedConfStatement) ValueAfter(word string) string {
+	for i := 1; i < len(s.Values)-1; i++ {
+		if !s.Values[i].IsBlock && s.Values[i].Text == word && !s.Values[i+1].IsBlock {
+			return s.Values[i+1].Text
+		}
+	}
+	return ""
+}
+
+// Returns the first block of the statement or nil if it has no block.
+func (s *NamedConfStatement) Block() []*NamedConfStatement {
+	for _, value := range s.Values {
+		if value.IsBlock {
+			return value.Block
+		}
+	}
+	return nil
+}
+
+// Returns the block following the given word, e.g. the list of keys for
+// the word keys in the inet clause, or nil if there is no such block.
+func (s *NamedConfStatement) BlockAfter(word string) []*NamedConfStatement {
+	for i := 1; i < len(s.Values)-1; i++ {
+		if !s.Values[i].IsBlock && s.Values[i].Text == word && s.Values[i+1].IsBlock {
+			return s.Values[i+1].Block
+		}
+	}
+	return nil
+}
+
+// Returns the statement in the block of the statement with the given
+// keyword or nil if there is no such statement.
+func (s *NamedConfStatement) Find(keyword string) *NamedConfStatement {
+	return findNamedConfStatement(s.Block(), keyword)
+}
+
+// Returns the statements with the given keyword.
+func (c *NamedConf) FindAll(keyword string) []*NamedConfStatement {
+	return findAllNamedConfStatements(c.Statements, keyword)
+}
+
+func findNamedConfStatement(statements []*NamedConfStatement, keyword string) *NamedConfStatement {
+	for _, statement := range statements {
+		if statement.Keyword() == keyword {
+			return statement
+		}
+	}
+	return nil
+}
+
+func findAllNamedConfStatements(statements []*NamedConfStatement, keyword string) []*NamedConfStatement {
+	found := []*NamedConfStatement{}
+	for _, statement := range statements {
+		if statement.Keyword() == keyword {
+			found = append(found, statement)
+		}
+	}
+	return found
+}
+
+// Returns the texts of the elements of the list, e.g. the addresses in
+// the allow clause. The element consisting of several values, e.g. the
+// negated address, is returned as its values separated with spaces.
+func namedConfList(statements []*NamedConfStatement) []string {
+	list := []string{}
+	for _, statement := range statements {
+		texts := []string{}
+		for _, value := range statement.Values {
+			if !value.IsBlock {
+				texts = append(texts, value.Text)
+			}
+		}
+		if len(texts) > 0 {
+			list = append(list, strings.Join(texts, " "))
+		}
+	}
+	return list
+}
+
+// Returns the block of the options statement or nil if there is no
+// options statement.
+func (c *NamedConf) Options() []*NamedConfStatement {
+	options := findNamedConfStatement(c.Statements, "options")
+	if options == nil {
+		return nil
+	}
+	return options.Block()
+}
+
+// Returns the keys defined at the top level.
+func (c *NamedConf) Keys() []NamedConfKey {
+	keys := []NamedConfKey{}
+	for _, statement := range c.FindAll("key") {
+		key := NamedConfKey{
+			Name: statement.Arg(0),
+		}
+		if algorithm := statement.Find("algorithm"); algorithm != nil {
+			key.Algorithm = algorithm.Arg(0)
+		}
+		if secret := statement.Find("secret"); secret != nil {
+			key.Secret = secret.Arg(0)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Returns the key with the given name or nil if there is no such key.
+func (c *NamedConf) Key(name string) *NamedConfKey {
+	for _, key := range c.Keys() {
+		if key.Name == name {
+			return &key
+		}
+	}
+	return nil
+}
+
+// Returns the inet clauses of the statements with the given keyword.
+func (c *NamedConf) getInetClauses(keyword string) []NamedConfInet {
+	inets := []NamedConfInet{}
+	for _, statement := range c.FindAll(keyword) {
+		for _, clause := range findAllNamedConfStatements(statement.Block(), "inet") {
+			inet := NamedConfInet{
+				Address: clause.Arg(0),
+				Allow:   namedConfList(clause.BlockAfter("allow")),
+				Keys:    namedConfList(clause.BlockAfter("keys")),
+			}
+			if port := clause.ValueAfter("port"); port != "" && port != "*" {
+				p, err := strconv.ParseInt(port, 10, 64)
+				if err != nil {
+					continue
+				}
+				inet.Port = p
+			}
+			inets = append(inets, inet)
+		}
+	}
+	return inets
+}
+
+// Returns the inet clauses of the controls statements. The unix clauses
+// are not returned.
+func (c *NamedConf) Controls() []NamedConfInet {
+	return c.getInetClauses("controls")
+}
+
+// Returns the inet clauses of the statistics-channels statements.
+func (c *NamedConf) StatisticsChannels() []NamedConfInet {
+	return c.getInetClauses("statistics-channels")
+}
+
+// Returns the zones defined in the given statements.
+func getNamedConfZones(statements []*NamedConfStatement, view string) []NamedConfZone {
+	zones := []NamedConfZone{}
+	for _, statement := range findAllNamedConfStatements(statements, "zone") {
+		zone := NamedConfZone{
+			Name:  statement.Arg(0),
+			Class: statement.Arg(1),
+			View:  view,
+		}
+		if tp := statement.Find("type"); tp != nil {
+			zone.Type = tp.Arg(0)
+		}
+		if file := statement.Find("file"); file != nil {
+			zone.File = file.Arg(0)
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+// Returns the views with their zones.
+func (c *NamedConf) Views() []NamedConfView {
+	views := []NamedConfView{}
+	for _, statement := range c.FindAll("view") {
+		views = append(views, NamedConfView{
+			Name:  statement.Arg(0),
+			Class: statement.Arg(1),
+			Zones: getNamedConfZones(statement.Block(), statement.Arg(0)),
+		})
+	}
+	return views
+}
+
+// Returns all zones, defined at the top level and in the views.
+func (c *NamedConf) Zones() []NamedConfZone {
+	zones := getNamedConfZones(c.Statements, "")
+	for _, view := range c.Views() {
+		zones = append(zones, view.Zones...)
+	}
+	return zones
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Configuration used in the tests. It contains multiple inet clauses,
+// ACL names and IPv6 addresses, which the regular expressions used to
+// choke on, as well as the comments in all styles.
+const testNamedConf = `
+// Keys used by rndc.
+key "rndc-key" {
+	algorithm hmac-sha256;
+	secret "OmItW1lOyLVUEuvv+Fme+Q==";
+};
+key "rndc-remote" { algorithm "hmac-md5"; secret "abcd"; };
+
+acl "rndc-users" { 10.0.0.0/8; ! 10.1.0.0/16; localhost; };
+
+options {
+	directory "/var/cache/bind"; # the working directory
+	listen-on-v6 { any; };
+};
+
+/* The first inet clause is used
+   to connect to named. */
+controls {
+	inet ::1 port 7766 allow { "rndc-users"; localhost; } keys { "missing"; "rndc-remote"; };
+	inet * allow { localhost; } keys { rndc-key; };
+	unix "/run/named/rndc.sock" perm 0600 owner 0 group 0;
+};
+
+statistics-channels {
+	inet 0.0.0.0 port 8053 allow { 127.0.0.1; };
+};
+
+zone "." {
+	type hint;
+	file "/usr/share/dns/root.hints";
+};
+
+view "internal" in {
+	match-clients { "rndc-users"; };
+	zone "example.org" in {
+		type master;
+		file "example.org.db";
+	};
+	zone "example.com" {
+		type slave;
+		masters { 192.0.2.1; };
+	};
+};
+`
+
+// Test that the configuration is split into statements and values.
+func TestParseNamedConf(t *testing.T) {
+	conf, err := ParseNamedConf(testNamedConf)
+	require.NoError(t, err)
+	require.Len(t, conf.Statements, 8)
+
+	acl := conf.FindAll("acl")
+	require.Len(t, acl, 1)
+	require.Equal(t, "rndc-users", acl[0].Arg(0))
+	require.Equal(t, []string{"10.0.0.0/8", "! 10.1.0.0/16", "localhost"}, namedConfList(acl[0].Block()))
+
+	options := conf.Options()
+	require.Len(t, options, 2)
+	directory := findNamedConfStatement(options, "directory")
+	require.NotNil(t, directory)
+	require.Equal(t, "/var/cache/bind", directory.Arg(0))
+}
+
+// Test that the keys are returned.
+func TestNamedConfKeys(t *testing.T) {
+	conf, err := ParseNamedConf(testNamedConf)
+	require.NoError(t, err)
+
+	keys := conf.Keys()
+	require.Len(t, keys, 2)
+	require.Equal(t, NamedConfKey{Name: "rndc-key", Algorithm: "hmac-sha256", Secret: "OmItW1lOyLVUEuvv+Fme+Q=="}, keys[0])
+	require.Equal(t, NamedConfKey{Name: "rndc-remote", Algorithm: "hmac-md5", Secret: "abcd"}, keys[1])
+
+	require.NotNil(t, conf.Key("rndc-remote"))
+	require.Nil(t, conf.Key("missing"))
+}
+
+// Test that the inet clauses of the controls and statistics-channels
+// statements are returned.
+func TestNamedConfChannels(t *testing.T) {
+	conf, err := ParseNamedConf(testNamedConf)
+	require.NoError(t, err)
+
+	controls := conf.Controls()
+	require.Len(t, controls, 2)
+	require.Equal(t, NamedConfInet{
+		Address: "::1",
+		Port:    7766,
+		Allow:   []string{"rndc-users", "localhost"},
+		Keys:    []string{"missing", "rndc-remote"},
+	}, controls[0])
+	require.Equal(t, "*", controls[1].Address)
+	require.Zero(t, controls[1].Port)
+	require.Equal(t, []string{"rndc-key"}, controls[1].Keys)
+
+	channels := conf.StatisticsChannels()
+	require.Len(t, channels, 1)
+	require.Equal(t, "0.0.0.0", channels[0].Address)
+	require.EqualValues(t, 8053, channels[0].Port)
+	require.Empty(t, channels[0].Keys)
+}
+
+// Test that the zones are returned along with the views they belong to.
+func TestNamedConfZones(t *testing.T) {
+	conf, err := ParseNamedConf(testNamedConf)
+	require.NoError(t, err)
+
+	views := conf.Views()
+	require.Len(t, views, 1)
+	require.Equal(t, "internal", views[0].Name)
+	require.Equal(t, "in", views[0].Class)
+	require.Len(t, views[0].Zones, 2)
+
+	zones := conf.Zones()
+	require.Len(t, zones, 3)
+	require.Equal(t, NamedConfZone{Name: ".", Type: "hint", File: "/usr/share/dns/root.hints"}, zones[0])
+	require.Equal(t, NamedConfZone{Name: "example.org", Class: "in", View: "internal", Type: "master", File: "example.org.db"}, zones[1])
+	require.Equal(t, NamedConfZone{Name: "example.com", View: "internal", Type: "slave"}, zones[2])
+}
+
+// Test that the syntax errors are reported.
+func TestParseNamedConfErrors(t *testing.T) {
+	for _, text := range []string{
+		`options { directory "/var/cache/bind" };`,
+		`options { directory "/var/cache/bind"; }`,
+		`options { directory "/var/cache/bind"; `,
+		`options { directory "/var/cache/bind; };`,
+		`}; options {};`,
+		`/* options {};`,
+	} {
+		_, err := ParseNamedConf(text)
+		require.Error(t, err, text)
+	}
+
+	// The quoted special characters and the escaped quotes are allowed.
+	conf, err := ParseNamedConf(`key "a;{b}\"" {};`)
+	require.NoError(t, err)
+	require.Equal(t, `a;{b}"`, conf.Statements[0].Arg(0))
+}
+
+// Test that the included files are parsed.
+func TestParseNamedConfFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namedconf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(path.Join(dir, "named.conf"), []byte(`
+include "rndc.key";
+controls { inet 127.0.0.1 allow { localhost; } keys { rndc-key; }; };
+view "default" { include "zones.conf"; };
+`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "rndc.key"), []byte(`key "rndc-key" { algorithm hmac-md5; secret "abcd"; };`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "zones.conf"), []byte(`zone "example.org" { type master; };`), 0600)
+	require.NoError(t, err)
+
+	conf, err := ParseNamedConfFile("named.conf", dir)
+	require.NoError(t, err)
+	require.NotNil(t, conf.Key("rndc-key"))
+	zones := conf.Zones()
+	require.Len(t, zones, 1)
+	require.Equal(t, "default", zones[0].View)
+
+	// The files including each other.
+	err = ioutil.WriteFile(path.Join(dir, "zones.conf"), []byte(`include "named.conf";`), 0600)
+	require.NoError(t, err)
+	_, err = ParseNamedConfFile(path.Join(dir, "named.conf"), dir)
+	require.Error(t, err)
+
+	// The included file doesn't exist.
+	err = os.Remove(path.Join(dir, "rndc.key"))
+	require.NoError(t, err)
+	_, err = ParseNamedConfFile(path.Join(dir, "named.conf"), dir)
+	require.Error(t, err)
+}
+
+// Test that the files included by the named configuration are found.
+func TestGetNamedConfFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namedconf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(path.Join(dir, "named.conf"), []byte(`
+include "rndc.key";
+view "default" { include "zones.conf"; };
+`), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(path.Join(dir, "zones.conf"), []byte(`
+zone "example.org" { type master; };
+include "named.conf";
+include "/etc/bind/zones.local";
+`), 0600)
+	require.NoError(t, err)
+
+	// The missing files and the files including each other are returned
+	// once.
+	files := getNamedConfFiles("named.conf", dir)
+	require.Equal(t, []string{
+		path.Join(dir, "named.conf"),
+		path.Join(dir, "rndc.key"),
+		path.Join(dir, "zones.conf"),
+		"/etc/bind/zones.local",
+	}, files)
+
+	// The main file is returned even if it can't be read.
+	files = getNamedConfFiles(path.Join(dir, "other.conf"), dir)
+	require.Equal(t, []string{path.Join(dir, "other.conf")}, files)
+}
+
+// Test that the access points used by the agent are found in the
+// configuration.
+func TestGetAccessPointsFromBind9Config(t *testing.T) {
+	conf, err := ParseNamedConf(testNamedConf)
+	require.NoError(t, err)
+
+	// The first key listed in the first inet clause which is defined.
+	address, port, key := getCtrlAddressFromBind9Config(conf)
+	require.Equal(t, "::1", address)
+	require.EqualValues(t, 7766, port)
+	require.Equal(t, "hmac-md5:abcd", key)
+
+	address, port = getStatisticsChannelFromBind9Config(conf)
+	require.Equal(t, "127.0.0.1", address)
+	require.EqualValues(t, 8053, port)
+
+	// named listens on the default port when the controls statement is
+	// not specified and doesn't listen when it is empty.
+	conf, err = ParseNamedConf(`options {};`)
+	require.NoError(t, err)
+	address, port, key = getCtrlAddressFromBind9Config(conf)
+	require.Equal(t, "127.0.0.1", address)
+	require.EqualValues(t, RndcDefaultPort, port)
+	require.Empty(t, key)
+	address, port = getStatisticsChannelFromBind9Config(conf)
+	require.Empty(t, address)
+	require.Zero(t, port)
+
+	conf, err = ParseNamedConf(`controls {};`)
+	require.NoError(t, err)
+	address, port, _ = getCtrlAddressFromBind9Config(conf)
+	require.Empty(t, address)
+	require.Zero(t, port)
+}
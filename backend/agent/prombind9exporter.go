@@ -274,7 +274,7 @@ func (pbe *PromBind9Exporter) collectStats() error {
 			log.Errorf("problem with getting stats from BIND 9, bad access statistics point: %+v", err)
 			continue
 		}
-		address := storkutil.HostWithPortURL(sap.Address, sap.Port)
+		address := storkutil.HostWithPortURL(sap.Address, sap.Port, false)
 		path := "json/v1/server"
 		url := fmt.Sprintf("%s%s", address, path)
 		httpRsp, err := pbe.HTTPClient.Call(url, bytes.NewBuffer([]byte(request)))
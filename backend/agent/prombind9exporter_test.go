@@ -31,6 +31,10 @@ func (fam *PromFakeBind9AppMonitor) GetApps() []*App {
 	}}
 }
 
+func (fam *PromFakeBind9AppMonitor) AppsChanged() <-chan bool {
+	return nil
+}
+
 func (fam *PromFakeBind9AppMonitor) Shutdown() {
 }
 
@@ -419,6 +419,62 @@ func (pke *PromKeaExporter) setDaemonStats(daemonIdx int, rspIfc interface{}, ig
 	return nil
 }
 
+// Sends the request for the statistics to the Kea app and returns the list
+// of responses and the index of the daemon which sent the first response,
+// i.e. 0 for dhcp4 and 1 for dhcp6. The Kea daemon running without the Kea
+// Control Agent is queried over its control socket and its response is
+// wrapped in a list.
+func (pke *PromKeaExporter) getStatistics(app *App) (string, int, error) {
+	if socket, err := getAccessPoint(app, AccessPointControlSocket); err == nil {
+		// the daemon returns its own stats, so no service is specified
+		request := `{
+             "command":"statistic-get-all",
+             "arguments": {}
+        }`
+
+		firstDaemonIdx := 0
+		for _, s := range app.KeaControlSockets {
+			switch s.Daemon {
+			case "dhcp4":
+			case "dhcp6":
+				firstDaemonIdx = 1
+			default:
+				// d2 has no statistics collected here
+				return "[]", 0, nil
+			}
+		}
+		body, err := sendToKeaOverUnixSocket(socket.Address, []byte(request))
+		if err != nil {
+			return "", 0, err
+		}
+		return "[" + string(body) + "]", firstDaemonIdx, nil
+	}
+
+	// Request to kea dhcp daemons for getting all stats. Both v4 and v6 is queried because
+	// here we do not have knowledge which are active.
+	request := `{
+             "command":"statistic-get-all",
+             "service":["dhcp4", "dhcp6"],
+             "arguments": {}
+        }`
+
+	ctrl, err := getAccessPoint(app, AccessPointControl)
+	if err != nil {
+		return "", 0, errors.WithMessage(err, "bad Kea access control point")
+	}
+	caURL := storkutil.HostWithPortURL(ctrl.Address, ctrl.Port, ctrl.UseSecureProtocol)
+	httpRsp, err := pke.HTTPClient.CallWithSettings(caURL, bytes.NewBuffer([]byte(request)), app.HTTPClientSettings)
+	if err != nil {
+		return "", 0, err
+	}
+	body, err := ioutil.ReadAll(httpRsp.Body)
+	httpRsp.Body.Close()
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "problem with reading stats response from kea")
+	}
+	return string(body), 0, nil
+}
+
 // Collect stats from all Kea apps.
 func (pke *PromKeaExporter) collectStats() error {
 	var lastErr error
@@ -430,14 +486,6 @@ func (pke *PromKeaExporter) collectStats() error {
 		"pkt6-sent":     true,
 	}
 
-	// Request to kea dhcp daemons for getting all stats. Both v4 and v6 is queried because
-	// here we do not have knowledge which are active.
-	request := `{
-             "command":"statistic-get-all",
-             "service":["dhcp4", "dhcp6"],
-             "arguments": {}
-        }`
-
 	// go through all kea apps discovered by monitor and query them for stats
 	apps := pke.AppMonitor.GetApps()
 	for _, app := range apps {
@@ -447,27 +495,12 @@ func (pke *PromKeaExporter) collectStats() error {
 		}
 
 		// get stats from kea
-		ctrl, err := getAccessPoint(app, AccessPointControl)
-		if err != nil {
-			lastErr = err
-			log.Errorf("problem with getting stats from kea, bad Kea access control point: %+v", err)
-			continue
-		}
-		caURL := storkutil.HostWithPortURL(ctrl.Address, ctrl.Port)
-		httpRsp, err := pke.HTTPClient.Call(caURL, bytes.NewBuffer([]byte(request)))
+		response, firstDaemonIdx, err := pke.getStatistics(app)
 		if err != nil {
 			lastErr = err
 			log.Errorf("problem with getting stats from kea: %+v", err)
 			continue
 		}
-		body, err := ioutil.ReadAll(httpRsp.Body)
-		httpRsp.Body.Close()
-		if err != nil {
-			lastErr = err
-			log.Errorf("problem with reading stats response from kea: %+v", err)
-			continue
-		}
-		response := string(body)
 
 		// parse response
 		var rspsIfc interface{}
@@ -487,7 +520,7 @@ func (pke *PromKeaExporter) collectStats() error {
 		// Go though list of responses from daemons (it can have none or some responses from dhcp4/dhcp6)
 		// and store collected stats in Prometheus structures.
 		for daemonIdx, rspIfc := range rspList {
-			err = pke.setDaemonStats(daemonIdx, rspIfc, ignoredStats)
+			err = pke.setDaemonStats(firstDaemonIdx+daemonIdx, rspIfc, ignoredStats)
 			if err != nil {
 				log.Errorf("cannot get stat from daemon: %+v", err)
 			}
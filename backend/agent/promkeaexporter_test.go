@@ -24,6 +24,10 @@ func (fam *PromFakeAppMonitor) GetApps() []*App {
 	}}
 }
 
+func (fam *PromFakeAppMonitor) AppsChanged() <-chan bool {
+	return nil
+}
+
 func (fam *PromFakeAppMonitor) Shutdown() {
 }
 
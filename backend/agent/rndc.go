@@ -1,28 +1,44 @@
 package agent
 
 import (
-	"fmt"
-	"os"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-// CommandExecutor takes an array of strings, with the first element of the
-// array being the program to call, followed by its arguments.  It returns
-// the command output, and possibly an error (for example if running the
-// command failed).
-type CommandExecutor func([]string) ([]byte, error)
+// RndcExecutor sends the command to the named daemon listening on the
+// given control access point and returns the output of the command, and
+// possibly an error (for example if the daemon could not be reached or
+// the command failed).
+type RndcExecutor func(ctrl *AccessPoint, command []string) ([]byte, error)
 
 type RndcClient struct {
-	execute CommandExecutor
+	execute RndcExecutor
 }
 
 const RndcKeyFile = "/etc/bind/rndc.key"
 
 // Create an rndc client to communicate with BIND 9 named daemon.
-func NewRndcClient(ce CommandExecutor) *RndcClient {
+func NewRndcClient(re RndcExecutor) *RndcClient {
 	rndcClient := &RndcClient{
-		execute: ce,
+		execute: re,
 	}
 	return rndcClient
 }
@@ -33,16 +49,457 @@ func (c *RndcClient) Call(app *App, command []string) (output []byte, err error)
 		return nil, err
 	}
 
-	rndcCommand := []string{"rndc", "-s", ctrl.Address, "-p", fmt.Sprintf("%d", ctrl.Port)}
-	if len(ctrl.Key) > 0 {
-		rndcCommand = append(rndcCommand, "-y")
-		rndcCommand = append(rndcCommand, ctrl.Key)
-	} else if _, err := os.Stat(RndcKeyFile); err == nil {
-		rndcCommand = append(rndcCommand, "-k")
-		rndcCommand = append(rndcCommand, RndcKeyFile)
+	// If the key is not specified in the named configuration, the
+	// default key used by rndc is tried.
+	ctrlCopy := *ctrl
+	if len(ctrlCopy.Key) == 0 {
+		if contents, err := ioutil.ReadFile(RndcKeyFile); err == nil {
+			ctrlCopy.Key = getRndcKeyFromKeyFile(string(contents))
+		}
 	}
-	rndcCommand = append(rndcCommand, command...)
-	log.Debugf("rndc: %+v", rndcCommand)
+	log.Debugf("rndc: %s:%d %+v", ctrlCopy.Address, ctrlCopy.Port, command)
 
-	return c.execute(rndcCommand)
+	return c.execute(&ctrlCopy, command)
+}
+
+// getRndcKeyFromKeyFile returns the first key found in the contents of the
+// key file, e.g. generated by rndc-confgen, in the algorithm:secret format.
+func getRndcKeyFromKeyFile(contents string) string {
+	conf, err := ParseNamedConf(contents)
+	if err != nil {
+		log.Warnf("cannot parse %s: %s", RndcKeyFile, err)
+		return ""
+	}
+	keys := conf.Keys()
+	if len(keys) == 0 || keys[0].Algorithm == "" || keys[0].Secret == "" {
+		log.Warnf("no rndc key found in %s", RndcKeyFile)
+		return ""
+	}
+	return keys[0].Algorithm + ":" + keys[0].Secret
+}
+
+// The rndc control channel protocol is implemented below. Each message
+// starts with its length and the protocol version, followed by a table of
+// named values. The table of the requests and responses holds the _auth
+// table with the message signature, the _ctrl table with the serial number,
+// the timestamps and the nonce, and the _data table with the command and
+// its result. The values are encoded as their type, length and the data.
+// The numbers are sent as decimal strings.
+
+// Version of the rndc control channel protocol.
+const rndcProtocolVersion = 1
+
+// Types of the values in the rndc messages.
+const (
+	rndcTypeString byte = 0
+	rndcTypeBinary byte = 1
+	rndcTypeTable  byte = 2
+	rndcTypeList   byte = 3
+)
+
+// Algorithm identifiers sent along with the HMAC-SHA signatures. They are
+// the identifiers of the algorithms in BIND 9. The HMAC-MD5 signatures are
+// sent without the algorithm identifier.
+const (
+	rndcAlgHMACMD5    byte = 157
+	rndcAlgHMACSHA1   byte = 161
+	rndcAlgHMACSHA224 byte = 162
+	rndcAlgHMACSHA256 byte = 163
+	rndcAlgHMACSHA384 byte = 164
+	rndcAlgHMACSHA512 byte = 165
+)
+
+// Length of the base64 encoded HMAC-MD5 signature without the padding
+// and the length of the space reserved for the HMAC-SHA signatures.
+const (
+	rndcHMD5Length = 22
+	rndcHSHALength = 88
+)
+
+// Messages exceeding this size are not accepted.
+const rndcMaxMessageSize = 16 * 1024 * 1024
+
+// Timeout for the whole exchange with named.
+const rndcTimeout = 30 * time.Second
+
+// Validity period of the requests. named rejects the expired requests.
+const rndcRequestLifetime = 60 * time.Second
+
+// Table of named values in the rndc message. The values are []byte,
+// rndcTable or []interface{} holding the values of the list.
+type rndcTable map[string]interface{}
+
+// Returns the value of the given key as string. The second returned
+// value indicates if the key exists and holds a string.
+func (t rndcTable) getString(key string) (string, bool) {
+	value, ok := t[key].([]byte)
+	if !ok {
+		return "", false
+	}
+	return string(value), true
+}
+
+// Returns the value of the given key as uint32. The second returned value
+// indicates if the key exists and holds a number.
+func (t rndcTable) getUint32(key string) (uint32, bool) {
+	value, ok := t.getString(key)
+	if !ok {
+		return 0, false
+	}
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(number), true
+}
+
+// Returns the table held under the given key or nil.
+func (t rndcTable) getTable(key string) rndcTable {
+	value, _ := t[key].(rndcTable)
+	return value
+}
+
+// Secret and algorithm used to sign the messages.
+type rndcKey struct {
+	algorithm byte
+	hash      func() hash.Hash
+	secret    []byte
+}
+
+// Parses the key in the algorithm:secret format. The algorithm:name:secret
+// format used by rndc is also accepted.
+func parseRndcKey(key string) (*rndcKey, error) {
+	fields := strings.Split(key, ":")
+	if len(fields) < 2 {
+		return nil, errors.New("invalid rndc key format")
+	}
+	algorithm := strings.ToLower(strings.Trim(fields[0], `"`))
+	secret, err := base64.StdEncoding.DecodeString(strings.Trim(fields[len(fields)-1], `"`))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid rndc key secret")
+	}
+
+	k := &rndcKey{
+		secret: secret,
+	}
+	switch algorithm {
+	case "hmac-md5", "hmac-md5.sig-alg.reg.int":
+		k.algorithm, k.hash = rndcAlgHMACMD5, md5.New
+	case "hmac-sha1":
+		k.algorithm, k.hash = rndcAlgHMACSHA1, sha1.New
+	case "hmac-sha224":
+		k.algorithm, k.hash = rndcAlgHMACSHA224, sha256.New224
+	case "hmac-sha256":
+		k.algorithm, k.hash = rndcAlgHMACSHA256, sha256.New
+	case "hmac-sha384":
+		k.algorithm, k.hash = rndcAlgHMACSHA384, sha512.New384
+	case "hmac-sha512":
+		k.algorithm, k.hash = rndcAlgHMACSHA512, sha512.New
+	default:
+		return nil, errors.Errorf("unsupported rndc key algorithm %s", algorithm)
+	}
+	return k, nil
+}
+
+// Returns the _auth table holding the signature of the message body.
+// The signature is the base64 encoded HMAC of the body. The HMAC-MD5
+// signature is sent without the padding. The HMAC-SHA signatures are
+// preceded by the algorithm identifier and padded with zeros.
+func (k *rndcKey) sign(body []byte) rndcTable {
+	mac := hmac.New(k.hash, k.secret)
+	_, _ = mac.Write(body)
+	digest := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if k.algorithm == rndcAlgHMACMD5 {
+		return rndcTable{"hmd5": []byte(digest[:rndcHMD5Length])}
+	}
+	signature := make([]byte, rndcHSHALength+1)
+	signature[0] = k.algorithm
+	copy(signature[1:], digest)
+	return rndcTable{"hsha": signature}
+}
+
+// Checks if the _auth table holds the valid signature of the message body.
+func (k *rndcKey) verify(auth rndcTable, body []byte) bool {
+	expected := k.sign(body)
+	for name, value := range expected {
+		received, ok := auth[name].([]byte)
+		if !ok || !hmac.Equal(received, value.([]byte)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Appends the encoded value to the buffer.
+func encodeRndcValue(buf *bytes.Buffer, value interface{}) {
+	var data []byte
+	var tp byte
+	switch v := value.(type) {
+	case []byte:
+		tp, data = rndcTypeBinary, v
+	case string:
+		tp, data = rndcTypeBinary, []byte(v)
+	case rndcTable:
+		tp, data = rndcTypeTable, encodeRndcTable(v)
+	case []interface{}:
+		list := &bytes.Buffer{}
+		for _, item := range v {
+			encodeRndcValue(list, item)
+		}
+		tp, data = rndcTypeList, list.Bytes()
+	default:
+		panic(errors.Errorf("unsupported rndc value type %T", value))
+	}
+	buf.WriteByte(tp)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// Appends the encoded key and value to the buffer.
+func encodeRndcKeyValue(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteByte(byte(len(key)))
+	buf.WriteString(key)
+	encodeRndcValue(buf, value)
+}
+
+// Encodes the keys and values of the table. The keys are sorted to make
+// the encoding deterministic.
+func encodeRndcTable(table rndcTable) []byte {
+	keys := []string{}
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	for _, key := range keys {
+		encodeRndcKeyValue(buf, key, table[key])
+	}
+	return buf.Bytes()
+}
+
+// Encodes the message and signs it with the key. The _auth table, if any,
+// is replaced with the signature of the remaining part of the message. It
+// must be sent first, because the signature covers the data following it.
+func encodeRndcMessage(msg rndcTable, key *rndcKey) []byte {
+	unsigned := rndcTable{}
+	for name, value := range msg {
+		if name != "_auth" {
+			unsigned[name] = value
+		}
+	}
+	body := encodeRndcTable(unsigned)
+
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.BigEndian, uint32(0))
+	_ = binary.Write(buf, binary.BigEndian, uint32(rndcProtocolVersion))
+	if key != nil {
+		encodeRndcKeyValue(buf, "_auth", key.sign(body))
+	}
+	buf.Write(body)
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	return data
+}
+
+// Decodes the value from the data. It returns the value and the remaining
+// data.
+func decodeRndcValue(data []byte) (interface{}, []byte, error) {
+	if len(data) < 5 {
+		return nil, nil, errors.New("truncated rndc value")
+	}
+	tp := data[0]
+	length := binary.BigEndian.Uint32(data[1:5])
+	data = data[5:]
+	if uint32(len(data)) < length {
+		return nil, nil, errors.New("truncated rndc value")
+	}
+	value, rest := data[:length], data[length:]
+
+	switch tp {
+	case rndcTypeString, rndcTypeBinary:
+		return value, rest, nil
+	case rndcTypeTable:
+		table, err := decodeRndcTable(value)
+		return table, rest, err
+	case rndcTypeList:
+		list := []interface{}{}
+		for len(value) > 0 {
+			var item interface{}
+			var err error
+			item, value, err = decodeRndcValue(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, rest, nil
+	default:
+		return nil, nil, errors.Errorf("unsupported rndc value type %d", tp)
+	}
+}
+
+// Decodes the key and value from the data. It returns the key, the value
+// and the remaining data.
+func decodeRndcKeyValue(data []byte) (string, interface{}, []byte, error) {
+	if len(data) < 1 || len(data) < int(data[0])+1 {
+		return "", nil, nil, errors.New("truncated rndc key")
+	}
+	key := string(data[1 : data[0]+1])
+	value, rest, err := decodeRndcValue(data[data[0]+1:])
+	return key, value, rest, err
+}
+
+// Decodes the keys and values of the table.
+func decodeRndcTable(data []byte) (rndcTable, error) {
+	table := rndcTable{}
+	for len(data) > 0 {
+		key, value, rest, err := decodeRndcKeyValue(data)
+		if err != nil {
+			return nil, err
+		}
+		table[key] = value
+		data = rest
+	}
+	return table, nil
+}
+
+// Decodes the message, excluding its length, and checks its signature
+// if the key is specified.
+func decodeRndcMessage(data []byte, key *rndcKey) (rndcTable, error) {
+	if len(data) < 4 {
+		return nil, errors.New("truncated rndc message")
+	}
+	if version := binary.BigEndian.Uint32(data); version != rndcProtocolVersion {
+		return nil, errors.Errorf("unsupported rndc protocol version %d", version)
+	}
+	data = data[4:]
+
+	var auth rndcTable
+	if len(data) > 0 {
+		name, value, rest, err := decodeRndcKeyValue(data)
+		if err != nil {
+			return nil, err
+		}
+		if name == "_auth" {
+			auth, _ = value.(rndcTable)
+			data = rest
+		}
+	}
+	if key != nil && (auth == nil || !key.verify(auth, data)) {
+		return nil, errors.New("invalid rndc message signature")
+	}
+
+	msg, err := decodeRndcTable(data)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		msg["_auth"] = auth
+	}
+	return msg, nil
+}
+
+// Reads the message from the connection, excluding its length.
+func readRndcMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, errors.Wrapf(err, "cannot read rndc message length")
+	}
+	if length > rndcMaxMessageSize {
+		return nil, errors.Errorf("rndc message too long: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.Wrapf(err, "cannot read rndc message")
+	}
+	return data, nil
+}
+
+// Creates the request carrying the command. The nonce received from named
+// must be sent in all requests but the first one.
+func newRndcRequest(serial, nonce uint32, now time.Time, command string) rndcTable {
+	ctrl := rndcTable{
+		"_ser": strconv.FormatUint(uint64(serial), 10),
+		"_tim": strconv.FormatInt(now.Unix(), 10),
+		"_exp": strconv.FormatInt(now.Add(rndcRequestLifetime).Unix(), 10),
+	}
+	if nonce != 0 {
+		ctrl["_nonce"] = strconv.FormatUint(uint64(nonce), 10)
+	}
+	return rndcTable{
+		"_ctrl": ctrl,
+		"_data": rndcTable{
+			"type": command,
+		},
+	}
+}
+
+// Sends the request and returns the response.
+func exchangeRndcMessages(conn net.Conn, request rndcTable, key *rndcKey) (rndcTable, error) {
+	if _, err := conn.Write(encodeRndcMessage(request, key)); err != nil {
+		return nil, errors.Wrapf(err, "cannot send rndc request")
+	}
+	data, err := readRndcMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRndcMessage(data, key)
+}
+
+// Sends the command to named over the control channel. It first sends
+// the null command to get the nonce from named and then the actual
+// command. It returns the text output of the command or an error if the
+// command failed. This is the default executor used by the agent.
+func executeRndcCommand(ctrl *AccessPoint, command []string) ([]byte, error) {
+	if len(ctrl.Key) == 0 {
+		return nil, errors.New("no rndc key configured")
+	}
+	key, err := parseRndcKey(ctrl.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(ctrl.Address, strconv.FormatInt(ctrl.Port, 10))
+	conn, err := net.DialTimeout("tcp", address, rndcTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to named control channel %s", address)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(rndcTimeout))
+
+	buf := make([]byte, 4)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, errors.Wrapf(err, "cannot generate rndc request serial")
+	}
+	serial := binary.BigEndian.Uint32(buf)
+
+	response, err := exchangeRndcMessages(conn, newRndcRequest(serial, 0, time.Now(), "null"), key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ok := response.getTable("_ctrl").getUint32("_nonce")
+	if !ok {
+		return nil, errors.New("no nonce in the response from named")
+	}
+
+	response, err = exchangeRndcMessages(conn, newRndcRequest(serial+1, nonce, time.Now(), strings.Join(command, " ")), key)
+	if err != nil {
+		return nil, err
+	}
+	data := response.getTable("_data")
+	if data == nil {
+		return nil, errors.New("no data in the response from named")
+	}
+	text, _ := data.getString("text")
+	if result, ok := data.getUint32("result"); ok && result != 0 {
+		msg, ok := data.getString("err")
+		if !ok {
+			msg = "result " + strconv.FormatUint(uint64(result), 10)
+		}
+		return []byte(text), errors.Errorf("rndc command '%s' failed: %s", strings.Join(command, " "), msg)
+	}
+	return []byte(text), nil
 }
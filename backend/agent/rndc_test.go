@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Fake named control channel. It accepts a single connection, checks the
+// signatures and the nonce of the requests and responds to them. The
+// status command returns a text, the other commands fail.
+type fakeControlChannel struct {
+	listener net.Listener
+	key      *rndcKey
+	nonce    uint32
+	commands []string
+	errors   []string
+	done     chan struct{}
+}
+
+// Starts the fake control channel using the given key.
+func newFakeControlChannel(t *testing.T, key string) *fakeControlChannel {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	k, err := parseRndcKey(key)
+	require.NoError(t, err)
+	fcc := &fakeControlChannel{
+		listener: listener,
+		key:      k,
+		nonce:    12345,
+		done:     make(chan struct{}),
+	}
+	go fcc.serve()
+	return fcc
+}
+
+// Returns the control access point of the fake channel.
+func (fcc *fakeControlChannel) accessPoint(key string) *AccessPoint {
+	addr := fcc.listener.Addr().(*net.TCPAddr)
+	return &AccessPoint{
+		Type:    AccessPointControl,
+		Address: addr.IP.String(),
+		Port:    int64(addr.Port),
+		Key:     key,
+	}
+}
+
+func (fcc *fakeControlChannel) serve() {
+	defer close(fcc.done)
+	conn, err := fcc.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		data, err := readRndcMessage(conn)
+		if err != nil {
+			return
+		}
+		request, err := decodeRndcMessage(data, fcc.key)
+		if err != nil {
+			fcc.errors = append(fcc.errors, err.Error())
+			return
+		}
+		ctrl := request.getTable("_ctrl")
+		command, _ := request.getTable("_data").getString("type")
+		fcc.commands = append(fcc.commands, command)
+		if sent, ok := ctrl.getUint32("_tim"); !ok || time.Since(time.Unix(int64(sent), 0)) > time.Minute {
+			fcc.errors = append(fcc.errors, "invalid time")
+			return
+		}
+		if command != "null" {
+			if nonce, ok := ctrl.getUint32("_nonce"); !ok || nonce != fcc.nonce {
+				fcc.errors = append(fcc.errors, "invalid nonce")
+				return
+			}
+		}
+
+		result := rndcTable{
+			"type":   command,
+			"result": "0",
+		}
+		switch command {
+		case "null":
+		case "status":
+			result["text"] = "server is up and running"
+		default:
+			result["result"] = "1"
+			result["err"] = "unknown command"
+		}
+		serial, _ := ctrl.getString("_ser")
+		response := rndcTable{
+			"_ctrl": rndcTable{
+				"_ser":   serial,
+				"_tim":   strconv.FormatInt(time.Now().Unix(), 10),
+				"_exp":   strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10),
+				"_rpl":   "1",
+				"_nonce": strconv.FormatUint(uint64(fcc.nonce), 10),
+			},
+			"_data": result,
+		}
+		if _, err = conn.Write(encodeRndcMessage(response, fcc.key)); err != nil {
+			return
+		}
+	}
+}
+
+// Test that the keys in the supported formats are parsed.
+func TestParseRndcKey(t *testing.T) {
+	key, err := parseRndcKey("hmac-md5:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.NoError(t, err)
+	require.Equal(t, rndcAlgHMACMD5, key.algorithm)
+	require.Len(t, key.secret, 16)
+
+	key, err = parseRndcKey("hmac-sha256:rndc-key:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.NoError(t, err)
+	require.Equal(t, rndcAlgHMACSHA256, key.algorithm)
+
+	_, err = parseRndcKey("hmac-sha256")
+	require.Error(t, err)
+	_, err = parseRndcKey("hmac-foo:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.Error(t, err)
+	_, err = parseRndcKey("hmac-md5:not base64")
+	require.Error(t, err)
+}
+
+// Test that the key is found in the key file generated by rndc-confgen.
+func TestGetRndcKeyFromKeyFile(t *testing.T) {
+	contents := `key "rndc-key" {
+	algorithm hmac-sha256;
+	secret "OmItW1lOyLVUEuvv+Fme+Q==";
+};`
+	require.Equal(t, "hmac-sha256:OmItW1lOyLVUEuvv+Fme+Q==", getRndcKeyFromKeyFile(contents))
+	require.Empty(t, getRndcKeyFromKeyFile("options {};"))
+}
+
+// Test that the messages are signed the way BIND 9 expects: the _auth
+// table goes first and its layout is fixed.
+func TestEncodeRndcMessageAuth(t *testing.T) {
+	msg := newRndcRequest(1, 0, time.Now(), "status")
+
+	key, err := parseRndcKey("hmac-md5:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.NoError(t, err)
+	data := encodeRndcMessage(msg, key)
+	header := append([]byte{0, 0, 0, 1, 5}, []byte("_auth")...)
+	header = append(header, 2, 0, 0, 0, 0x20, 4)
+	header = append(header, []byte("hmd5")...)
+	header = append(header, 1, 0, 0, 0, 0x16)
+	require.True(t, bytes.HasPrefix(data[4:], header))
+
+	key, err = parseRndcKey("hmac-sha512:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.NoError(t, err)
+	data = encodeRndcMessage(msg, key)
+	header = append([]byte{0, 0, 0, 1, 5}, []byte("_auth")...)
+	header = append(header, 2, 0, 0, 0, 0x63, 4)
+	header = append(header, []byte("hsha")...)
+	header = append(header, 1, 0, 0, 0, 0x59, rndcAlgHMACSHA512)
+	require.True(t, bytes.HasPrefix(data[4:], header))
+}
+
+// Test that the encoded message is decoded and its signature is checked.
+func TestDecodeRndcMessage(t *testing.T) {
+	key, err := parseRndcKey("hmac-sha1:OmItW1lOyLVUEuvv+Fme+Q==")
+	require.NoError(t, err)
+	msg := newRndcRequest(1, 2, time.Now(), "status")
+	msg["_data"].(rndcTable)["list"] = []interface{}{"a", rndcTable{"b": "c"}}
+	data := encodeRndcMessage(msg, key)
+	require.EqualValues(t, len(data)-4, binary.BigEndian.Uint32(data))
+
+	decoded, err := decodeRndcMessage(data[4:], key)
+	require.NoError(t, err)
+	nonce, ok := decoded.getTable("_ctrl").getUint32("_nonce")
+	require.True(t, ok)
+	require.EqualValues(t, 2, nonce)
+	command, ok := decoded.getTable("_data").getString("type")
+	require.True(t, ok)
+	require.Equal(t, "status", command)
+	list := decoded.getTable("_data")["list"].([]interface{})
+	require.Len(t, list, 2)
+	require.Equal(t, []byte("a"), list[0])
+	require.Equal(t, rndcTable{"b": []byte("c")}, list[1])
+
+	// The message signed with another key.
+	otherKey, err := parseRndcKey("hmac-sha1:c2VjcmV0")
+	require.NoError(t, err)
+	_, err = decodeRndcMessage(data[4:], otherKey)
+	require.Error(t, err)
+
+	// The message has been tampered with.
+	data[len(data)-1]++
+	_, err = decodeRndcMessage(data[4:], key)
+	require.Error(t, err)
+
+	// The message is truncated.
+	_, err = decodeRndcMessage(data[4:len(data)-1], nil)
+	require.Error(t, err)
+}
+
+// Test that the command is sent over the control channel and its output
+// is returned.
+func TestExecuteRndcCommand(t *testing.T) {
+	for _, key := range []string{"hmac-md5:OmItW1lOyLVUEuvv+Fme+Q==", "hmac-sha256:OmItW1lOyLVUEuvv+Fme+Q=="} {
+		fcc := newFakeControlChannel(t, key)
+		defer fcc.listener.Close()
+
+		output, err := executeRndcCommand(fcc.accessPoint(key), []string{"status"})
+		require.NoError(t, err)
+		require.Equal(t, "server is up and running", string(output))
+		<-fcc.done
+		require.Equal(t, []string{"null", "status"}, fcc.commands)
+		require.Empty(t, fcc.errors)
+	}
+}
+
+// Test that the failure of the command is reported.
+func TestExecuteRndcCommandFailure(t *testing.T) {
+	key := "hmac-sha256:OmItW1lOyLVUEuvv+Fme+Q=="
+	fcc := newFakeControlChannel(t, key)
+	defer fcc.listener.Close()
+
+	_, err := executeRndcCommand(fcc.accessPoint(key), []string{"foo", "bar"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown command")
+	<-fcc.done
+	require.Equal(t, []string{"null", "foo bar"}, fcc.commands)
+}
+
+// Test that the command is not executed when the key doesn't match.
+func TestExecuteRndcCommandBadKey(t *testing.T) {
+	fcc := newFakeControlChannel(t, "hmac-sha256:OmItW1lOyLVUEuvv+Fme+Q==")
+	defer fcc.listener.Close()
+
+	_, err := executeRndcCommand(fcc.accessPoint("hmac-sha256:c2VjcmV0"), []string{"status"})
+	require.Error(t, err)
+	<-fcc.done
+	require.NotEmpty(t, fcc.errors)
+
+	// No key at all.
+	_, err = executeRndcCommand(fcc.accessPoint(""), []string{"status"})
+	require.Error(t, err)
+}
+
+// Test that the client reports an error when named is not listening.
+func TestExecuteRndcCommandNoNamed(t *testing.T) {
+	key := "hmac-md5:OmItW1lOyLVUEuvv+Fme+Q=="
+	fcc := newFakeControlChannel(t, key)
+	ctrl := fcc.accessPoint(key)
+	fcc.listener.Close()
+
+	_, err := executeRndcCommand(ctrl, []string{"status"})
+	require.Error(t, err)
+}
@@ -0,0 +1,260 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This module provides the primitives of the internal public key
+// infrastructure used to secure the communication between Stork Server
+// and Stork Agents. The server acts as a certificate authority (CA).
+// It holds a self-signed root certificate and issues a client
+// certificate for itself and server certificates for the agents. All
+// keys are ECDSA keys on the P-256 curve and all keys, certificates
+// and certificate signing requests (CSRs) are PEM encoded.
+
+const (
+	// Organization put in the subject of all issued certificates.
+	Organization = "ISC Stork"
+
+	// Common name of the root CA certificate.
+	RootCACommonName = "Stork Root CA"
+
+	// Common name of the certificate that the server presents
+	// to the agents.
+	ServerCommonName = "Stork Server"
+
+	// Validity period of the root CA certificate.
+	RootCAValidity = 30 * 365 * 24 * time.Hour
+
+	// Validity period of the server and agent certificates.
+	CertValidity = 365 * 24 * time.Hour
+
+	// Certificate is considered due for renewal when less than
+	// this fraction of its validity period is left.
+	renewalThreshold = 3
+)
+
+// Generates a new ECDSA private key.
+func genKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "problem with generating private key")
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "problem with marshaling private key")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key, keyPEM, nil
+}
+
+// Creates a certificate from a template, signs it with the parent's key
+// and returns it PEM encoded.
+func createCert(template, parent *x509.Certificate, pub crypto.PublicKey, parentKey crypto.Signer) ([]byte, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with creating certificate %s", template.Subject.CommonName)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// Generates a private key and a self-signed root CA certificate with
+// the given serial number. It returns PEM encoded key and certificate.
+func GenCAKeyCert(serialNumber int64) (keyPEM []byte, certPEM []byte, err error) {
+	key, keyPEM, err := genKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject: pkix.Name{
+			Organization: []string{Organization},
+			CommonName:   RootCACommonName,
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(RootCAValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	certPEM, err = createCert(template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyPEM, certPEM, nil
+}
+
+// Generates a private key and a client certificate signed by the given
+// CA. The certificate is meant to be used by Stork Server when it
+// connects to the agents. It returns PEM encoded key and certificate.
+func GenServerKeyCert(serialNumber int64, caCertPEM, caKeyPEM []byte) (keyPEM []byte, certPEM []byte, err error) {
+	caCert, caKey, err := parseCAKeyCert(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, keyPEM, err := genKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject: pkix.Name{
+			Organization: []string{Organization},
+			CommonName:   ServerCommonName,
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(CertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certPEM, err = createCert(template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keyPEM, certPEM, nil
+}
+
+// Generates a private key and a certificate signing request for the
+// given common name and alternative names. It is used by the agent
+// to request a certificate from the server. It returns PEM encoded
+// key and CSR.
+func GenKeyAndCSR(commonName string, dnsNames []string, ipAddresses []net.IP) (keyPEM []byte, csrPEM []byte, err error) {
+	key, keyPEM, err := genKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{Organization},
+			CommonName:   commonName,
+		},
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "problem with creating certificate signing request")
+	}
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	return keyPEM, csrPEM, nil
+}
+
+// Signs the certificate signing request sent by an agent with the CA
+// key. The alternative names requested in the CSR are replaced with
+// the given address which is the address the server uses to connect
+// to the agent, so the agent can't obtain a certificate for a name it
+// was not registered with. The issued certificate can only be used
+// for authenticating a TLS server. It returns PEM encoded certificate.
+func SignAgentCSR(csrPEM []byte, address string, serialNumber int64, caCertPEM, caKeyPEM []byte) ([]byte, error) {
+	caCert, caKey, err := parseCAKeyCert(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("certificate signing request is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing certificate signing request")
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid signature of certificate signing request")
+	}
+
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject: pkix.Name{
+			Organization: []string{Organization},
+			CommonName:   address,
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(CertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{address}
+	}
+
+	return createCert(template, caCert, csr.PublicKey, caKey)
+}
+
+// Parses PEM encoded certificate.
+func ParseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing certificate")
+	}
+	return cert, nil
+}
+
+// Parses PEM encoded ECDSA private key.
+func ParseKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing private key")
+	}
+	return key, nil
+}
+
+// Parses CA certificate and its key.
+func parseCAKeyCert(caCertPEM, caKeyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caCert, err := ParseCert(caCertPEM)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "problem with CA certificate")
+	}
+	caKey, err := ParseKey(caKeyPEM)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "problem with CA key")
+	}
+	return caCert, caKey, nil
+}
+
+// Returns SHA-256 fingerprint of the certificate as a hex string.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks if the certificate is due for renewal, i.e. less than a third
+// of its validity period is left or it has already expired.
+func NeedsRenewal(cert *x509.Certificate, now time.Time) bool {
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < validity/renewalThreshold
+}
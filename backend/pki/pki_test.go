@@ -0,0 +1,109 @@
+package pki
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the whole chain of certificates can be generated and that
+// the issued certificates verify against the root CA.
+func TestCertChain(t *testing.T) {
+	caKeyPEM, caCertPEM, err := GenCAKeyCert(1)
+	require.NoError(t, err)
+
+	caCert, err := ParseCert(caCertPEM)
+	require.NoError(t, err)
+	require.True(t, caCert.IsCA)
+	require.Equal(t, RootCACommonName, caCert.Subject.CommonName)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	// Server certificate may only be used by a TLS client.
+	srvKeyPEM, srvCertPEM, err := GenServerKeyCert(2, caCertPEM, caKeyPEM)
+	require.NoError(t, err)
+	_, err = ParseKey(srvKeyPEM)
+	require.NoError(t, err)
+	srvCert, err := ParseCert(srvCertPEM)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, srvCert.SerialNumber.Int64())
+	_, err = srvCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.NoError(t, err)
+	_, err = srvCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	require.Error(t, err)
+
+	// Agent certificate may only be used by a TLS server.
+	_, csrPEM, err := GenKeyAndCSR("agent", []string{"agent.example.org"}, []net.IP{net.ParseIP("10.0.0.1")})
+	require.NoError(t, err)
+	agentCertPEM, err := SignAgentCSR(csrPEM, "192.0.2.1", 3, caCertPEM, caKeyPEM)
+	require.NoError(t, err)
+	agentCert, err := ParseCert(agentCertPEM)
+	require.NoError(t, err)
+
+	// Requested alternative names are replaced with the given address.
+	require.Empty(t, agentCert.DNSNames)
+	require.Len(t, agentCert.IPAddresses, 1)
+	require.Equal(t, "192.0.2.1", agentCert.IPAddresses[0].String())
+
+	_, err = agentCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		DNSName:   "192.0.2.1",
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	require.NoError(t, err)
+	_, err = agentCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.Error(t, err)
+
+	// Host names are put in the DNS names.
+	agentCertPEM, err = SignAgentCSR(csrPEM, "agent.example.org", 4, caCertPEM, caKeyPEM)
+	require.NoError(t, err)
+	agentCert, err = ParseCert(agentCertPEM)
+	require.NoError(t, err)
+	require.Equal(t, []string{"agent.example.org"}, agentCert.DNSNames)
+	require.Empty(t, agentCert.IPAddresses)
+}
+
+// Test that garbage is rejected by the parsing functions.
+func TestParseInvalid(t *testing.T) {
+	_, err := ParseCert([]byte("foo"))
+	require.Error(t, err)
+	_, err = ParseKey([]byte("foo"))
+	require.Error(t, err)
+
+	caKeyPEM, caCertPEM, err := GenCAKeyCert(1)
+	require.NoError(t, err)
+	_, err = SignAgentCSR([]byte("foo"), "192.0.2.1", 2, caCertPEM, caKeyPEM)
+	require.Error(t, err)
+	_, err = SignAgentCSR(caCertPEM, "192.0.2.1", 2, caCertPEM, caKeyPEM)
+	require.Error(t, err)
+}
+
+// Test that certificates are considered due for renewal when a third
+// of their validity is left.
+func TestNeedsRenewal(t *testing.T) {
+	caKeyPEM, caCertPEM, err := GenCAKeyCert(1)
+	require.NoError(t, err)
+	_, certPEM, err := GenServerKeyCert(2, caCertPEM, caKeyPEM)
+	require.NoError(t, err)
+	cert, err := ParseCert(certPEM)
+	require.NoError(t, err)
+
+	require.False(t, NeedsRenewal(cert, time.Now()))
+	require.False(t, NeedsRenewal(cert, time.Now().Add(CertValidity/2)))
+	require.True(t, NeedsRenewal(cert, time.Now().Add(CertValidity*3/4)))
+	require.True(t, NeedsRenewal(cert, cert.NotAfter.Add(time.Hour)))
+	require.NotEmpty(t, Fingerprint(cert))
+}
@@ -2,17 +2,28 @@ package agentcomm
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	agentapi "isc.org/stork/api"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
 )
 
 // Settings specific to communication with Agents
 type AgentsSettings struct {
+	MaxParallelAgents int           `long:"agents-max-parallel" description:"the maximum number of agents the server sends requests to in parallel" default:"16" env:"STORK_SERVER_AGENTS_MAX_PARALLEL"`
+	AgentQueueSize    int           `long:"agents-queue-size" description:"the maximum number of requests waiting to be sent to a single agent" default:"64" env:"STORK_SERVER_AGENTS_QUEUE_SIZE"`
+	RequestTimeout    time.Duration `long:"agents-request-timeout" description:"the deadline for the requests to the agents" default:"30s" env:"STORK_SERVER_AGENTS_REQUEST_TIMEOUT"`
 }
 
 // Runtime information about the agent, e.g. connection.
@@ -20,6 +31,8 @@ type Agent struct {
 	Address  string
 	Client   agentapi.AgentClient
 	GrpcConn *grpc.ClientConn
+
+	tlsCreds credentials.TransportCredentials
 }
 
 // Prepare gRPC connection to agent.
@@ -29,9 +42,11 @@ func (agent *Agent) MakeGrpcConnection() error {
 		agent.GrpcConn.Close()
 	}
 
-	// Setup new connection
+	// Setup new connection. The agent must present a certificate
+	// issued by the server's CA and the server presents its own
+	// certificate to the agent.
 	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithInsecure())
+	opts = append(opts, grpc.WithTransportCredentials(agent.tlsCreds))
 
 	grpcConn, err := grpc.Dial(agent.Address, opts...)
 	if err != nil {
@@ -48,52 +63,135 @@ func (agent *Agent) MakeGrpcConnection() error {
 type ConnectedAgents interface {
 	Shutdown()
 	GetConnectedAgent(address string) (*Agent, error)
+	RemoveAgent(address string, agentPort int64)
+	GetAgentHealth(address string, agentPort int64) AgentHealth
+	IsAgentAvailable(address string, agentPort int64) bool
 	GetState(ctx context.Context, address string, agentPort int64) (*State, error)
 	ForwardRndcCommand(ctx context.Context, agentAddress string, agentPort int64, rndcSettings Bind9Control, command string) (*RndcOutput, error)
 	ForwardToNamedStats(ctx context.Context, agentAddress string, agentPort int64, statsURL string, statsOutput interface{}) error
 	ForwardToKeaOverHTTP(ctx context.Context, agentAddress string, agentPort int64, caURL string, commands []*KeaCommand, cmdResponses ...interface{}) (*KeaCmdsResult, error)
+	ForwardToKeaOverUnixSocket(ctx context.Context, agentAddress string, agentPort int64, socketName string, commands []*KeaCommand, cmdResponses ...interface{}) (*KeaCmdsResult, error)
 }
 
-// Agents management map. It tracks Agents currently connected to the Server.
+// Agents management map. It tracks Agents currently connected to the Server
+// and the workers sending the requests to them.
 type connectedAgentsData struct {
-	Settings     *AgentsSettings
-	AgentsMap    map[string]*Agent
-	CommLoopReqs chan *commLoopReq
-	DoneCommLoop chan bool
-	Wg           *sync.WaitGroup
+	Settings    *AgentsSettings
+	Db          *dbops.PgDB
+	AgentsMap   map[string]*Agent
+	Health      map[string]*AgentHealth
+	Workers     map[string]*agentWorker
+	WorkerSlots chan struct{}
+	Done        chan bool
+	Wg          *sync.WaitGroup
+
+	mutex     sync.Mutex
+	tlsConfig *tls.Config
 }
 
-// Create new ConnectedAgents objects.
-func NewConnectedAgents(settings *AgentsSettings) ConnectedAgents {
-	agents := connectedAgentsData{
-		Settings:     settings,
-		AgentsMap:    make(map[string]*Agent),
-		CommLoopReqs: make(chan *commLoopReq),
-		DoneCommLoop: make(chan bool),
-		Wg:           &sync.WaitGroup{},
+// Create new ConnectedAgents objects. The PEM encoded root CA certificate,
+// server certificate and server key are used to establish mutual TLS
+// connections with the agents. The database is used to check whether
+// the certificates presented by the agents belong to the machines.
+func NewConnectedAgents(settings *AgentsSettings, db *dbops.PgDB, caCertPEM, serverCertPEM, serverKeyPEM []byte) (ConnectedAgents, error) {
+	agents := &connectedAgentsData{
+		Settings:  settings,
+		Db:        db,
+		AgentsMap: make(map[string]*Agent),
+		Health:    make(map[string]*AgentHealth),
+		Workers:   make(map[string]*agentWorker),
+		Done:      make(chan bool),
+		Wg:        &sync.WaitGroup{},
+	}
+	agents.WorkerSlots = make(chan struct{}, agents.maxParallelAgents())
+
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with loading server certificate")
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCertPEM) {
+		return nil, errors.New("problem with loading root CA certificate")
+	}
+	agents.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		RootCAs:      caPool,
+		MinVersion:   tls.VersionTLS12,
 	}
 
-	agents.Wg.Add(1)
-	go agents.communicationLoop()
+	return agents, nil
+}
+
+// Checks that the certificate presented by the agent at the given address
+// is the certificate issued to the machine with this address and that the
+// machine has been authorized. Any agent can obtain a certificate issued
+// by the root CA by registering, so verifying the chain is not enough.
+// It is called during the TLS handshake after the certificate chain has
+// been verified against the root CA.
+func (agents *connectedAgentsData) verifyAgentCert(address string, verifiedChains [][]*x509.Certificate) error {
+	if agents.Db == nil {
+		return nil
+	}
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return errors.Errorf("agent %s presented no certificate", address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "problem with parsing agent address %s", address)
+	}
+	agentPort, err := strconv.ParseInt(port, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "problem with parsing agent port %s", port)
+	}
+	machine, err := dbmodel.GetMachineByAddressAndAgentPort(agents.Db, host, agentPort)
+	if err != nil {
+		return err
+	}
+	if machine == nil {
+		return errors.Errorf("machine %s is not registered", address)
+	}
+	if !machine.Authorized {
+		return errors.Errorf("machine %s has not been authorized", address)
+	}
+	serial := verifiedChains[0][0].SerialNumber.Int64()
+	if serial != machine.AgentCertSerial {
+		return errors.Errorf("agent certificate %d was not issued to machine %s, the agent must enroll again", serial, address)
+	}
+	return nil
+}
 
-	return &agents
+// Returns the credentials used to connect to the agent at the given
+// address. They accept only the certificate issued to the machine.
+func (agents *connectedAgentsData) agentTLSCreds(address string) credentials.TransportCredentials {
+	tlsConfig := agents.tlsConfig.Clone()
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		return agents.verifyAgentCert(address, verifiedChains)
+	}
+	return credentials.NewTLS(tlsConfig)
 }
 
-// Shutdown agents in agents map.
+// Shutdown agents in agents map and stop the workers.
 func (agents *connectedAgentsData) Shutdown() {
 	log.Printf("Stopping communication with agents")
+	agents.mutex.Lock()
 	for _, agent := range agents.AgentsMap {
 		agent.GrpcConn.Close()
 	}
+	close(agents.Done)
+	agents.mutex.Unlock()
 
-	close(agents.CommLoopReqs)
-	agents.DoneCommLoop <- true
 	agents.Wg.Wait()
+	for address := range agents.Workers {
+		agentQueueDepth.DeleteLabelValues(address)
+	}
 	log.Printf("Stopped communication with agents")
 }
 
 // Get Agent object by its address.
 func (agents *connectedAgentsData) GetConnectedAgent(address string) (*Agent, error) {
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
+
 	// Look for agent in Agents map and if found then return it
 	agent, ok := agents.AgentsMap[address]
 	if ok {
@@ -104,6 +202,7 @@ func (agents *connectedAgentsData) GetConnectedAgent(address string) (*Agent, er
 	// Agent not found so allocate agent and prepare connection
 	agent = new(Agent)
 	agent.Address = address
+	agent.tlsCreds = agents.agentTLSCreds(address)
 	err := agent.MakeGrpcConnection()
 	if err != nil {
 		return nil, err
@@ -1,14 +1,95 @@
 package agentcomm
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/pki"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
 )
 
-func TestConnectingToAgent(t *testing.T) {
+// Set of PEM encoded keys and certificates used in the tests.
+type testCerts struct {
+	caCert     []byte
+	caKey      []byte
+	serverCert []byte
+	serverKey  []byte
+}
+
+// Generates root CA and server certificates for the tests.
+func makeTestCerts(t *testing.T) *testCerts {
+	caKey, caCert, err := pki.GenCAKeyCert(1)
+	require.NoError(t, err)
+	serverKey, serverCert, err := pki.GenServerKeyCert(2, caCert, caKey)
+	require.NoError(t, err)
+	return &testCerts{
+		caCert:     caCert,
+		caKey:      caKey,
+		serverCert: serverCert,
+		serverKey:  serverKey,
+	}
+}
+
+// Creates ConnectedAgents instance using freshly generated certificates.
+func newTestConnectedAgents(t *testing.T) ConnectedAgents {
+	certs := makeTestCerts(t)
 	settings := AgentsSettings{}
-	agents := NewConnectedAgents(&settings)
+	agents, err := NewConnectedAgents(&settings, nil, certs.caCert, certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+	return agents
+}
+
+// Minimal agent responding to GetState over gRPC.
+type testAgentServer struct {
+	agentapi.UnimplementedAgentServer
+}
+
+func (s *testAgentServer) GetState(ctx context.Context, in *agentapi.GetStateReq) (*agentapi.GetStateRsp, error) {
+	return &agentapi.GetStateRsp{AgentVersion: "1.2.3"}, nil
+}
+
+// Starts an agent at 127.0.0.1 on a random port. The agent uses the
+// certificate issued by the given CA and requires the clients to
+// present a certificate issued by the same CA. It returns the agent
+// port and the teardown function.
+func startTestAgent(t *testing.T, certs *testCerts) (int64, func()) {
+	agentKeyPEM, csrPEM, err := pki.GenKeyAndCSR("agent", nil, nil)
+	require.NoError(t, err)
+	agentCertPEM, err := pki.SignAgentCSR(csrPEM, "127.0.0.1", 3, certs.caCert, certs.caKey)
+	require.NoError(t, err)
+	agentCert, err := tls.X509KeyPair(agentCertPEM, agentKeyPEM)
+	require.NoError(t, err)
+	caPool := x509.NewCertPool()
+	require.True(t, caPool.AppendCertsFromPEM(certs.caCert))
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{agentCert},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	server := grpc.NewServer(grpc.Creds(creds))
+	agentapi.RegisterAgentServer(server, &testAgentServer{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	return int64(lis.Addr().(*net.TCPAddr).Port), server.Stop
+}
+
+func TestConnectingToAgent(t *testing.T) {
+	agents := newTestConnectedAgents(t)
 	defer agents.Shutdown()
 
 	// connect one agent and check if it is in agents map
@@ -17,3 +98,103 @@ func TestConnectingToAgent(t *testing.T) {
 	_, ok := agents.(*connectedAgentsData).AgentsMap["127.0.0.1:8080"]
 	require.True(t, ok)
 }
+
+// Test that the certificates passed to the ConnectedAgents are validated.
+func TestNewConnectedAgentsInvalidCerts(t *testing.T) {
+	certs := makeTestCerts(t)
+	settings := AgentsSettings{}
+
+	_, err := NewConnectedAgents(&settings, nil, certs.caCert, certs.serverCert, []byte("foo"))
+	require.Error(t, err)
+
+	_, err = NewConnectedAgents(&settings, nil, []byte("foo"), certs.serverCert, certs.serverKey)
+	require.Error(t, err)
+}
+
+// Test that the server can talk to an agent which has a certificate
+// issued by the server's CA.
+func TestMutualTLS(t *testing.T) {
+	certs := makeTestCerts(t)
+	port, teardown := startTestAgent(t, certs)
+	defer teardown()
+
+	settings := AgentsSettings{}
+	agents, err := NewConnectedAgents(&settings, nil, certs.caCert, certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+	defer agents.Shutdown()
+
+	state, err := agents.GetState(context.Background(), "127.0.0.1", port)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3", state.AgentVersion)
+}
+
+// Test that the server refuses to talk to an agent having a certificate
+// issued by another CA and that the agent refuses the server presenting
+// a certificate issued by another CA.
+func TestMutualTLSOtherCA(t *testing.T) {
+	agentCerts := makeTestCerts(t)
+	port, teardown := startTestAgent(t, agentCerts)
+	defer teardown()
+
+	// Server uses its own CA.
+	serverCerts := makeTestCerts(t)
+	settings := AgentsSettings{}
+	agents, err := NewConnectedAgents(&settings, nil, serverCerts.caCert, serverCerts.serverCert, serverCerts.serverKey)
+	require.NoError(t, err)
+	defer agents.Shutdown()
+
+	_, err = agents.GetState(context.Background(), "127.0.0.1", port)
+	require.Error(t, err)
+
+	// Server trusts the agent's CA but presents a certificate issued by
+	// another CA.
+	agents2, err := NewConnectedAgents(&settings, nil, agentCerts.caCert, serverCerts.serverCert, serverCerts.serverKey)
+	require.NoError(t, err)
+	defer agents2.Shutdown()
+
+	_, err = agents2.GetState(context.Background(), "127.0.0.1", port)
+	require.Error(t, err)
+}
+
+// Test that the server talks only to the agent presenting the certificate
+// issued to the machine and only when the machine is authorized.
+func TestMutualTLSAgentCertSerial(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	certs := makeTestCerts(t)
+	port, agentTeardown := startTestAgent(t, certs)
+	defer agentTeardown()
+
+	getState := func() error {
+		settings := AgentsSettings{}
+		agents, err := NewConnectedAgents(&settings, db, certs.caCert, certs.serverCert, certs.serverKey)
+		require.NoError(t, err)
+		defer agents.Shutdown()
+		_, err = agents.GetState(context.Background(), "127.0.0.1", port)
+		return err
+	}
+
+	// The machine is not registered.
+	require.Error(t, getState())
+
+	// The machine is registered but not authorized.
+	machine := &dbmodel.Machine{
+		Address:         "127.0.0.1",
+		AgentPort:       port,
+		AgentCertSerial: 3,
+	}
+	require.NoError(t, dbmodel.AddMachine(db, machine))
+	require.Error(t, getState())
+
+	// The machine is authorized.
+	machine.Authorized = true
+	require.NoError(t, dbmodel.UpdateMachineAgentCert(db, machine, 0))
+	require.NoError(t, getState())
+
+	// The certificate presented by the agent was issued to another
+	// agent registering the machine.
+	machine.AgentCertSerial = 4
+	require.NoError(t, dbmodel.UpdateMachineAgentCert(db, machine, 3))
+	require.Error(t, getState())
+}
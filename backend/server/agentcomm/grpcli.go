@@ -15,19 +15,32 @@ import (
 // An access point for an application to retrieve information such
 // as status or metrics.
 type AccessPoint struct {
-	Type    string
-	Address string
-	Port    int64
-	Key     string
+	Type              string
+	Address           string
+	Port              int64
+	Key               string
+	UseSecureProtocol bool
 }
 
-// Currently supported types are: "control" and "statistics"
+// Currently supported types are: "control", "statistics" and
+// "control-socket". The last one is the unix socket of the Kea daemon
+// running without the Kea Control Agent.
 const AccessPointControl = "control"
 const AccessPointStatistics = "statistics"
+const AccessPointControlSocket = "control-socket"
+
+// Control socket of a Kea daemon configured in the Kea Control Agent or in
+// the Kea daemon running without the Kea Control Agent.
+type KeaControlSocket struct {
+	Daemon     string
+	SocketType string
+	SocketName string
+}
 
 type App struct {
-	Type         string
-	AccessPoints []AccessPoint
+	Type              string
+	AccessPoints      []AccessPoint
+	KeaControlSockets []KeaControlSocket
 }
 
 // Currently supported types are: "kea" and "bind9"
@@ -64,7 +77,7 @@ func (agents *connectedAgentsData) GetState(ctx context.Context, address string,
 	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
 
 	// Call agent for version.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, &agentapi.GetStateReq{})
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, &agentapi.GetStateReq{})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get state from agent %s", addrPort)
 	}
@@ -76,16 +89,27 @@ func (agents *connectedAgentsData) GetState(ctx context.Context, address string,
 
 		for _, point := range app.AccessPoints {
 			accessPoints = append(accessPoints, AccessPoint{
-				Type:    point.Type,
-				Address: point.Address,
-				Port:    point.Port,
-				Key:     point.Key,
+				Type:              point.Type,
+				Address:           point.Address,
+				Port:              point.Port,
+				Key:               point.Key,
+				UseSecureProtocol: point.UseSecureProtocol,
+			})
+		}
+
+		var controlSockets []KeaControlSocket
+		for _, socket := range app.KeaControlSockets {
+			controlSockets = append(controlSockets, KeaControlSocket{
+				Daemon:     socket.Daemon,
+				SocketType: socket.SocketType,
+				SocketName: socket.SocketName,
 			})
 		}
 
 		apps = append(apps, &App{
-			Type:         app.Type,
-			AccessPoints: accessPoints,
+			Type:              app.Type,
+			AccessPoints:      accessPoints,
+			KeaControlSockets: controlSockets,
 		})
 	}
 
@@ -134,7 +158,7 @@ func (agents *connectedAgentsData) ForwardRndcCommand(ctx context.Context, agent
 	}
 
 	// Send the command to the Stork agent.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, req)
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, req)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to forward rndc command %s to agent %s", command, addrPort)
 		return nil, err
@@ -175,7 +199,7 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 	}
 
 	// Send the commands to the Stork agent.
-	storkRsp, err := agents.sendAndRecvViaQueue(addrPort, storkReq)
+	storkRsp, err := agents.sendAndRecvViaQueue(ctx, addrPort, storkReq)
 	if err != nil {
 		return errors.Wrapf(err, "failed to forward named statistics commands to agent %s, to %s, commands were: %+v", addrPort, statsURL, storkReq.NamedStatsRequest)
 	}
@@ -218,20 +242,56 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, age
 	}
 
 	// Send the commands to the Stork agent.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, fdReq)
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, fdReq)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to forward Kea commands to agent %s, to %s, commands were: %+v", addrPort, caURL, fdReq.KeaRequests)
 		return nil, err
 	}
 	fdRsp := resp.(*agentapi.ForwardToKeaOverHTTPRsp)
 
+	return parseKeaResponses(caURL, commands, fdRsp.Status, fdRsp.GetKeaResponses(), cmdResponses...), nil
+}
+
+// Forwards a Kea command via the Stork Agent directly to the Kea daemon over
+// its unix control socket and then parses the response. It is used for the
+// daemons running without the Kea Control Agent. socketName is the path to
+// the socket on the machine where the agent runs.
+func (agents *connectedAgentsData) ForwardToKeaOverUnixSocket(ctx context.Context, agentAddress string, agentPort int64, socketName string, commands []*KeaCommand, cmdResponses ...interface{}) (*KeaCmdsResult, error) {
+	addrPort := net.JoinHostPort(agentAddress, strconv.FormatInt(agentPort, 10))
+
+	// Prepare the on-wire representation of the commands.
+	fdReq := &agentapi.ForwardToKeaOverUnixSocketReq{
+		SocketName: socketName,
+	}
+	for _, cmd := range commands {
+		fdReq.KeaRequests = append(fdReq.KeaRequests, &agentapi.KeaRequest{
+			Request: cmd.Marshal(),
+		})
+	}
+
+	// Send the commands to the Stork agent.
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, fdReq)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to forward Kea commands to agent %s, to %s, commands were: %+v", addrPort, socketName, fdReq.KeaRequests)
+		return nil, err
+	}
+	fdRsp := resp.(*agentapi.ForwardToKeaOverUnixSocketRsp)
+
+	return parseKeaResponses(socketName, commands, fdRsp.Status, fdRsp.GetKeaResponses(), cmdResponses...), nil
+}
+
+// Parses the responses to the Kea commands forwarded by the Stork Agent
+// into the cmdResponses. The errors returned by the agent and the parsing
+// errors are stored in the returned result. The destination is the URL of
+// the Kea Control Agent or the control socket the commands were sent to.
+func parseKeaResponses(destination string, commands []*KeaCommand, status *agentapi.Status, responses []*agentapi.KeaResponse, cmdResponses ...interface{}) *KeaCmdsResult {
 	result := &KeaCmdsResult{}
 	result.Error = nil
-	if fdRsp.Status.Code != agentapi.Status_OK {
-		result.Error = errors.New(fdRsp.Status.Message)
+	if status.Code != agentapi.Status_OK {
+		result.Error = errors.New(status.Message)
 	}
 
-	for idx, rsp := range fdRsp.GetKeaResponses() {
+	for idx, rsp := range responses {
 		cmdResp := cmdResponses[idx]
 		if rsp.Status.Code != agentapi.Status_OK {
 			result.CmdsErrors = append(result.CmdsErrors, errors.New(rsp.Status.Message))
@@ -239,9 +299,9 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, age
 		}
 
 		// Try to parse the response from the on-wire format.
-		err = UnmarshalKeaResponseList(commands[idx], rsp.Response, cmdResp)
+		err := UnmarshalKeaResponseList(commands[idx], rsp.Response, cmdResp)
 		if err != nil {
-			err = errors.Wrapf(err, "failed to parse Kea response from %s, response was: %s", caURL, rsp)
+			err = errors.Wrapf(err, "failed to parse Kea response from %s, response was: %s", destination, rsp)
 			result.CmdsErrors = append(result.CmdsErrors, err)
 			continue
 		}
@@ -249,6 +309,5 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, age
 		result.CmdsErrors = append(result.CmdsErrors, nil)
 	}
 
-	// Everything was fine, so return no error.
-	return result, nil
+	return result
 }
@@ -24,8 +24,7 @@ func makeAccessPoint(tp, address, key string, port int64) (ap []*agentapi.Access
 // 127.0.0.1:8080. The returned function performs a test teardown and
 // should be invoked when the unit test finishes.
 func setupGrpcliTestCase(t *testing.T) (*MockAgentClient, ConnectedAgents, func()) {
-	settings := AgentsSettings{}
-	agents := NewConnectedAgents(&settings)
+	agents := newTestConnectedAgents(t)
 
 	// pre-add an agent
 	addr := "127.0.0.1:8080"
@@ -202,6 +201,54 @@ func TestForwardToKeaOverHTTPWith2Cmds(t *testing.T) {
 	require.Nil(t, responseList[0].Arguments)
 }
 
+// Test that the command is forwarded to the Kea daemon control socket and
+// the response is parsed and tagged with the daemon name.
+func TestForwardToKeaOverUnixSocket(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	rsp := agentapi.ForwardToKeaOverUnixSocketRsp{
+		Status: &agentapi.Status{
+			Code: 0,
+		},
+		KeaResponses: []*agentapi.KeaResponse{{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			Response: `[
+            {
+                "result": 0,
+                "text": "operation succeeded"
+            }
+        ]`}},
+	}
+
+	var recordedReq *agentapi.ForwardToKeaOverUnixSocketReq
+	mockAgentClient.EXPECT().ForwardToKeaOverUnixSocket(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardToKeaOverUnixSocketReq, opts ...interface{}) (*agentapi.ForwardToKeaOverUnixSocketRsp, error) {
+			recordedReq = in
+			return &rsp, nil
+		})
+
+	ctx := context.Background()
+	daemons, _ := NewKeaDaemons("dhcp4")
+	command, _ := NewKeaCommand("test-command", daemons, nil)
+	actualResponse := KeaResponseList{}
+	cmdsResult, err := agents.ForwardToKeaOverUnixSocket(ctx, "127.0.0.1", 8080, "/run/kea/dhcp4.sock", []*KeaCommand{command}, &actualResponse)
+	require.NoError(t, err)
+	require.NoError(t, cmdsResult.Error)
+	require.Len(t, cmdsResult.CmdsErrors, 1)
+	require.NoError(t, cmdsResult.CmdsErrors[0])
+
+	require.NotNil(t, recordedReq)
+	require.Equal(t, "/run/kea/dhcp4.sock", recordedReq.SocketName)
+	require.Len(t, recordedReq.KeaRequests, 1)
+
+	require.Len(t, actualResponse, 1)
+	require.Equal(t, 0, actualResponse[0].Result)
+	require.Equal(t, "dhcp4", actualResponse[0].Daemon)
+}
+
 // Test that the error is returned when the response to the forwarded Kea command
 // is malformed.
 func TestForwardToKeaOverHTTPInvalidResponse(t *testing.T) {
@@ -0,0 +1,191 @@
+package agentcomm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+)
+
+// Bounds of the delay before the server tries to contact an unreachable
+// agent again. The delay doubles upon each consecutive failure.
+const (
+	minRedialBackoff = 2 * time.Second
+	maxRedialBackoff = 5 * time.Minute
+)
+
+// Connectivity state of an agent. It is updated upon each request sent
+// to the agent.
+type AgentHealth struct {
+	LastSuccessAt       time.Time
+	LastFailureAt       time.Time
+	ConsecutiveFailures int
+	// Time of the first failure since the agent was last reachable. It is
+	// zero when the agent is reachable.
+	UnreachableSince time.Time
+	// The pullers don't send requests to the agent before this time.
+	NextAttemptAt time.Time
+}
+
+// Returns the delay before contacting the agent which failed to respond
+// to the given number of consecutive requests.
+func redialBackoff(failures int) time.Duration {
+	backoff := minRedialBackoff
+	for i := 1; i < failures && backoff < maxRedialBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRedialBackoff {
+		backoff = maxRedialBackoff
+	}
+	return backoff
+}
+
+// Checks whether the error returned by the gRPC call means that the agent
+// couldn't be reached. Other errors are returned by the agent itself, so
+// they mean that the agent is reachable.
+func isConnectivityError(err error) bool {
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(errors.Cause(err)) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the health of the agent with the given address and port. The
+// agents the server hasn't talked to yet are considered healthy.
+func (agents *connectedAgentsData) GetAgentHealth(address string, agentPort int64) AgentHealth {
+	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
+	if health, ok := agents.Health[addrPort]; ok {
+		return *health
+	}
+	return AgentHealth{}
+}
+
+// Checks whether requests can be sent to the agent. It returns false when
+// the agent failed to respond recently and the backoff period hasn't
+// elapsed yet. The pullers use it to skip the agents which are down.
+func (agents *connectedAgentsData) IsAgentAvailable(address string, agentPort int64) bool {
+	health := agents.GetAgentHealth(address, agentPort)
+	return !time.Now().Before(health.NextAttemptAt)
+}
+
+// Returns the number of consecutive failures of the agent.
+func (agents *connectedAgentsData) getConsecutiveFailures(addrPort string) int {
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
+	health, ok := agents.Health[addrPort]
+	if !ok {
+		return 0
+	}
+	return health.ConsecutiveFailures
+}
+
+// Records the outcome of a request sent to the agent. Only the errors
+// indicating that the agent couldn't be reached are counted as failures.
+// When the agent becomes unreachable or reachable again, the machine is
+// updated in the database accordingly and an event is emitted.
+func (agents *connectedAgentsData) recordRequestResult(addrPort string, err error) {
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == context.Canceled || status.Code(cause) == codes.Canceled {
+			// The requestor gave up so nothing has been learnt about
+			// the agent.
+			return
+		}
+		if !isConnectivityError(err) {
+			err = nil
+		}
+	}
+	now := time.Now()
+
+	agents.mutex.Lock()
+	health, ok := agents.Health[addrPort]
+	if !ok {
+		health = &AgentHealth{}
+		agents.Health[addrPort] = health
+	}
+	var becameUnreachable, becameReachable bool
+	if err == nil {
+		// The server has just started talking to the agent or the agent
+		// has recovered.
+		becameReachable = !ok || !health.UnreachableSince.IsZero()
+		health.LastSuccessAt = now
+		health.ConsecutiveFailures = 0
+		health.UnreachableSince = time.Time{}
+		health.NextAttemptAt = time.Time{}
+	} else {
+		becameUnreachable = health.UnreachableSince.IsZero()
+		health.LastFailureAt = now
+		health.ConsecutiveFailures++
+		if becameUnreachable {
+			health.UnreachableSince = now
+		}
+		health.NextAttemptAt = now.Add(redialBackoff(health.ConsecutiveFailures))
+	}
+	failures := health.ConsecutiveFailures
+	agents.mutex.Unlock()
+
+	if err != nil && !becameUnreachable {
+		log.Warnf("agent %s is still unreachable after %d attempts, next attempt in %s",
+			addrPort, failures, redialBackoff(failures))
+	}
+	if !becameUnreachable && !becameReachable {
+		return
+	}
+
+	var machine *dbmodel.Machine
+	if agents.Db != nil {
+		machine = agents.updateMachineReachability(addrPort, becameUnreachable, now)
+	}
+
+	if becameUnreachable {
+		event := eventcenter.CreateEvent(dbmodel.EvWarning, fmt.Sprintf("agent %s is unreachable", addrPort), machine)
+		event.Details = err.Error()
+		eventcenter.AddEvent(agents.Db, event)
+	} else if ok {
+		// The agent has recovered rather than been contacted for the
+		// first time.
+		eventcenter.AddInfoEvent(agents.Db, fmt.Sprintf("agent %s is reachable again", addrPort), machine)
+	}
+}
+
+// Marks the machine with the given agent address as unreachable or reachable
+// in the database. It returns the machine, so the events can refer to it, or
+// nil if the machine is not found.
+func (agents *connectedAgentsData) updateMachineReachability(addrPort string, unreachable bool, since time.Time) *dbmodel.Machine {
+	address, portStr, err := net.SplitHostPort(addrPort)
+	if err != nil {
+		return nil
+	}
+	port, _ := strconv.ParseInt(portStr, 10, 64)
+	if unreachable {
+		err = dbmodel.SetMachineUnreachable(agents.Db, address, port, since)
+	} else {
+		err = dbmodel.SetMachineReachable(agents.Db, address, port)
+	}
+	if err != nil {
+		log.Errorf("problem with updating connectivity state of agent %s: %+v", addrPort, err)
+		return nil
+	}
+	machine, err := dbmodel.GetMachineByAddressAndAgentPort(agents.Db, address, port)
+	if err != nil {
+		log.Errorf("problem with getting machine of agent %s: %+v", addrPort, err)
+		return nil
+	}
+	return machine
+}
@@ -0,0 +1,151 @@
+package agentcomm
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Test that the backoff doubles upon each failure and doesn't exceed
+// the maximum.
+func TestRedialBackoff(t *testing.T) {
+	require.Equal(t, minRedialBackoff, redialBackoff(0))
+	require.Equal(t, minRedialBackoff, redialBackoff(1))
+	require.Equal(t, 2*minRedialBackoff, redialBackoff(2))
+	require.Equal(t, 4*minRedialBackoff, redialBackoff(3))
+	require.Equal(t, maxRedialBackoff, redialBackoff(20))
+	require.Equal(t, maxRedialBackoff, redialBackoff(1000))
+}
+
+// Test that the errors indicating that the agent can't be reached are
+// told apart from the errors returned by the agent.
+func TestIsConnectivityError(t *testing.T) {
+	require.True(t, isConnectivityError(status.Error(codes.Unavailable, "connection refused")))
+	require.True(t, isConnectivityError(errors.Wrap(status.Error(codes.DeadlineExceeded, "timeout"), "foo")))
+	require.True(t, isConnectivityError(context.DeadlineExceeded))
+	require.False(t, isConnectivityError(status.Error(codes.Unimplemented, "foo")))
+	require.False(t, isConnectivityError(errors.New("foo")))
+}
+
+// Test that the consecutive failures are counted and the agent becomes
+// available again after a successful request.
+func TestRecordRequestResult(t *testing.T) {
+	agents := newTestConnectedAgents(t).(*connectedAgentsData)
+	defer agents.Shutdown()
+
+	// Unknown agent is available.
+	require.True(t, agents.IsAgentAvailable("192.0.2.1", 8080))
+	require.Zero(t, agents.GetAgentHealth("192.0.2.1", 8080).ConsecutiveFailures)
+
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	agents.recordRequestResult("192.0.2.1:8080", unavailable)
+	health := agents.GetAgentHealth("192.0.2.1", 8080)
+	require.Equal(t, 1, health.ConsecutiveFailures)
+	require.False(t, health.UnreachableSince.IsZero())
+	require.True(t, health.NextAttemptAt.After(time.Now()))
+	require.False(t, agents.IsAgentAvailable("192.0.2.1", 8080))
+	unreachableSince := health.UnreachableSince
+
+	// The time since when the agent is unreachable doesn't change.
+	agents.recordRequestResult("192.0.2.1:8080", unavailable)
+	health = agents.GetAgentHealth("192.0.2.1", 8080)
+	require.Equal(t, 2, health.ConsecutiveFailures)
+	require.Equal(t, unreachableSince, health.UnreachableSince)
+
+	// Cancelled request says nothing about the agent.
+	agents.recordRequestResult("192.0.2.1:8080", context.Canceled)
+	require.Equal(t, 2, agents.GetAgentHealth("192.0.2.1", 8080).ConsecutiveFailures)
+
+	// An error returned by the agent means that it is reachable.
+	agents.recordRequestResult("192.0.2.1:8080", status.Error(codes.Internal, "foo"))
+	health = agents.GetAgentHealth("192.0.2.1", 8080)
+	require.Zero(t, health.ConsecutiveFailures)
+	require.True(t, health.UnreachableSince.IsZero())
+	require.False(t, health.LastSuccessAt.IsZero())
+	require.True(t, agents.IsAgentAvailable("192.0.2.1", 8080))
+
+	// Other agents are not affected.
+	require.True(t, agents.IsAgentAvailable("192.0.2.2", 8080))
+}
+
+// Test that the agent which is down is not available to the pullers until
+// the backoff period elapses and that the requests triggered by the users
+// are sent to it anyway.
+func TestRequestBackoff(t *testing.T) {
+	// Find a port nothing listens on.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := int64(lis.Addr().(*net.TCPAddr).Port)
+	lis.Close()
+
+	address := net.JoinHostPort("127.0.0.1", strconv.FormatInt(port, 10))
+	agents, clients, teardown := setupManagerTestCase(t, &AgentsSettings{}, address)
+	defer teardown()
+
+	// The first request fails and so does the retry on a new connection.
+	// The mock client is replaced with the real one upon redialing, which
+	// fails because nothing listens on the port.
+	clients[0].EXPECT().GetState(gomock.Any(), gomock.Any()).
+		Return(nil, status.Error(codes.Unavailable, "connection refused"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = agents.GetState(ctx, "127.0.0.1", port)
+	require.Error(t, err)
+	health := agents.GetAgentHealth("127.0.0.1", port)
+	require.Equal(t, 1, health.ConsecutiveFailures)
+	require.False(t, agents.IsAgentAvailable("127.0.0.1", port))
+
+	// The request sent during the backoff period is not refused. The
+	// agent is dialed again and the backoff period is extended.
+	_, err = agents.GetState(ctx, "127.0.0.1", port)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "next attempt")
+	health = agents.GetAgentHealth("127.0.0.1", port)
+	require.Equal(t, 2, health.ConsecutiveFailures)
+	require.True(t, health.NextAttemptAt.Sub(health.LastFailureAt) >= 2*minRedialBackoff)
+	require.False(t, agents.IsAgentAvailable("127.0.0.1", port))
+
+	// When the backoff period elapses the agent is available again.
+	agents.mutex.Lock()
+	agents.Health[address].NextAttemptAt = time.Now()
+	agents.mutex.Unlock()
+	require.True(t, agents.IsAgentAvailable("127.0.0.1", port))
+}
+
+// Test that the agent which responds again is considered healthy.
+func TestRequestRecovery(t *testing.T) {
+	certs := makeTestCerts(t)
+	port, teardown := startTestAgent(t, certs)
+	defer teardown()
+
+	agents, err := NewConnectedAgents(&AgentsSettings{}, nil, certs.caCert, certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+	defer agents.Shutdown()
+	data := agents.(*connectedAgentsData)
+
+	// Pretend that the agent failed to respond previously.
+	address := "127.0.0.1"
+	addrPort := net.JoinHostPort(address, strconv.FormatInt(port, 10))
+	data.recordRequestResult(addrPort, status.Error(codes.Unavailable, "foo"))
+	require.False(t, agents.IsAgentAvailable(address, port))
+	data.mutex.Lock()
+	data.Health[addrPort].NextAttemptAt = time.Now()
+	data.mutex.Unlock()
+
+	state, err := agents.GetState(context.Background(), address, port)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3", state.AgentVersion)
+	health := agents.GetAgentHealth(address, port)
+	require.Zero(t, health.ConsecutiveFailures)
+	require.True(t, health.UnreachableSince.IsZero())
+	require.True(t, agents.IsAgentAvailable(address, port))
+}
@@ -2,32 +2,41 @@ package agentcomm
 
 import (
 	"context"
+	"net"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 
 	agentapi "isc.org/stork/api"
 )
 
-// Loop that receives requests to agents, sends to them, receives responses
-// which are passed back to requestor. Requests and responses are passed
-// via channels what guarantees that requests are forwarded to agents one
-// by one.
-func (agents *connectedAgentsData) communicationLoop() {
-	defer agents.Wg.Done()
-	for {
-		select {
-		// wait for requests from parties that want to talk to agents
-		case req := <-agents.CommLoopReqs:
-			if req != nil {
-				agents.handleRequest(req)
-			}
-		// wait for done signal from shutdown function
-		case <-agents.DoneCommLoop:
-			return
-		}
-	}
-}
+// Values used when the corresponding agents settings are not specified.
+const (
+	defaultMaxParallelAgents = 16
+	defaultAgentQueueSize    = 64
+	defaultRequestTimeout    = 30 * time.Second
+)
+
+// Metrics describing the communication with the agents.
+var (
+	agentQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "stork_server",
+		Subsystem: "agentcomm",
+		Name:      "queue_depth",
+		Help:      "Number of requests waiting to be sent to the agent",
+	}, []string{"agent"})
+
+	agentRequestsInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "stork_server",
+		Subsystem: "agentcomm",
+		Name:      "requests_in_progress",
+		Help:      "Number of requests being currently sent to the agents",
+	})
+)
 
 type channelResp struct {
 	Response interface{}
@@ -35,18 +44,208 @@ type channelResp struct {
 }
 
 type commLoopReq struct {
+	Ctx       context.Context
 	AgentAddr string
 	ReqData   interface{}
 	RespChan  chan *channelResp
 }
 
-// Send a request to agent and receive response using channel to communication loop.
-func (agents *connectedAgentsData) sendAndRecvViaQueue(agentAddr string, in interface{}) (interface{}, error) {
-	respChan := make(chan *channelResp)
-	req := &commLoopReq{AgentAddr: agentAddr, ReqData: in, RespChan: respChan}
-	agents.CommLoopReqs <- req
-	respErr := <-respChan
-	return respErr.Response, respErr.Err
+// Queue of the requests to a single agent. Each agent has its own worker
+// which sends the requests from the queue to the agent one by one, so the
+// requests to the given agent are sent in the order they were queued. The
+// workers of different agents run in parallel, so a slow or unreachable
+// agent doesn't hold the requests to other agents.
+type agentWorker struct {
+	Address    string
+	Reqs       chan *commLoopReq
+	QueueDepth prometheus.Gauge
+	Stop       chan bool
+}
+
+// Returns the maximum number of agents the server sends requests to
+// in parallel.
+func (agents *connectedAgentsData) maxParallelAgents() int {
+	if agents.Settings != nil && agents.Settings.MaxParallelAgents > 0 {
+		return agents.Settings.MaxParallelAgents
+	}
+	return defaultMaxParallelAgents
+}
+
+// Returns the maximum number of requests waiting for a single agent.
+func (agents *connectedAgentsData) agentQueueSize() int {
+	if agents.Settings != nil && agents.Settings.AgentQueueSize > 0 {
+		return agents.Settings.AgentQueueSize
+	}
+	return defaultAgentQueueSize
+}
+
+// Returns the deadline for the requests which don't have their own.
+func (agents *connectedAgentsData) requestTimeout() time.Duration {
+	if agents.Settings != nil && agents.Settings.RequestTimeout > 0 {
+		return agents.Settings.RequestTimeout
+	}
+	return defaultRequestTimeout
+}
+
+// Returns the worker for the given agent. The worker is started upon the
+// first request to the agent.
+func (agents *connectedAgentsData) getWorker(agentAddr string) (*agentWorker, error) {
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
+
+	select {
+	case <-agents.Done:
+		return nil, errors.New("communication with agents has been stopped")
+	default:
+	}
+
+	worker, ok := agents.Workers[agentAddr]
+	if !ok {
+		worker = &agentWorker{
+			Address:    agentAddr,
+			Reqs:       make(chan *commLoopReq, agents.agentQueueSize()),
+			QueueDepth: agentQueueDepth.WithLabelValues(agentAddr),
+			Stop:       make(chan bool),
+		}
+		agents.Workers[agentAddr] = worker
+		agents.Wg.Add(1)
+		go agents.workerLoop(worker)
+	}
+	return worker, nil
+}
+
+// Stops the worker of the agent with the given address, closes the
+// connection to the agent and removes its queue depth metric. The requests
+// waiting in the queue are rejected. It is called when the machine is
+// removed, so the workers of the removed machines don't pile up.
+func (agents *connectedAgentsData) RemoveAgent(address string, agentPort int64) {
+	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
+
+	agents.mutex.Lock()
+	defer agents.mutex.Unlock()
+
+	if worker, ok := agents.Workers[addrPort]; ok {
+		close(worker.Stop)
+		delete(agents.Workers, addrPort)
+		agentQueueDepth.DeleteLabelValues(addrPort)
+	}
+	if agent, ok := agents.AgentsMap[addrPort]; ok {
+		agent.GrpcConn.Close()
+		delete(agents.AgentsMap, addrPort)
+	}
+	delete(agents.Health, addrPort)
+}
+
+// Rejects the given request and the requests waiting in the queue of
+// the stopped worker.
+func (worker *agentWorker) rejectQueued(req *commLoopReq) {
+	for {
+		if req != nil {
+			worker.QueueDepth.Dec()
+			req.RespChan <- &channelResp{Err: errors.Errorf("agent %s has been removed", worker.Address)}
+		}
+		select {
+		case req = <-worker.Reqs:
+		default:
+			return
+		}
+	}
+}
+
+// Loop that receives requests to the agent from its queue, sends them to
+// the agent and passes the responses back to the requestors. The number of
+// agents the requests are sent to at the same time is limited, so the
+// worker waits for its turn before it sends a request.
+func (agents *connectedAgentsData) workerLoop(worker *agentWorker) {
+	defer agents.Wg.Done()
+	queueDepth := worker.QueueDepth
+	for {
+		select {
+		case req := <-worker.Reqs:
+			// The worker may have been stopped while it was sending
+			// the previous request.
+			select {
+			case <-worker.Stop:
+				worker.rejectQueued(req)
+				return
+			default:
+			}
+			select {
+			case agents.WorkerSlots <- struct{}{}:
+			case <-req.Ctx.Done():
+				queueDepth.Dec()
+				req.RespChan <- &channelResp{Err: errors.Wrapf(req.Ctx.Err(), "request to agent %s has not been sent", worker.Address)}
+				continue
+			case <-agents.Done:
+				queueDepth.Dec()
+				req.RespChan <- &channelResp{Err: errors.New("communication with agents has been stopped")}
+				return
+			case <-worker.Stop:
+				worker.rejectQueued(req)
+				return
+			}
+			queueDepth.Dec()
+			agentRequestsInProgress.Inc()
+			agents.handleRequest(req)
+			agentRequestsInProgress.Dec()
+			<-agents.WorkerSlots
+		// wait for done signal from shutdown function
+		case <-agents.Done:
+			return
+		case <-worker.Stop:
+			worker.rejectQueued(nil)
+			return
+		}
+	}
+}
+
+// Send a request to agent and receive response using the queue of the
+// agent. If the context has no deadline, the default deadline from the
+// settings is applied, so the requestor doesn't wait forever when the
+// agent is not responding.
+func (agents *connectedAgentsData) sendAndRecvViaQueue(ctx context.Context, agentAddr string, in interface{}) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, agents.requestTimeout())
+		defer cancel()
+	}
+
+	worker, err := agents.getWorker(agentAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The response channel is buffered, so the worker doesn't block when
+	// the requestor stops waiting for the response.
+	respChan := make(chan *channelResp, 1)
+	req := &commLoopReq{Ctx: ctx, AgentAddr: agentAddr, ReqData: in, RespChan: respChan}
+
+	// The gauge of the worker is used rather than the one looked up by the
+	// address, so the metric is not recreated when the agent has been
+	// removed in the meantime.
+	queueDepth := worker.QueueDepth
+	queueDepth.Inc()
+	select {
+	case worker.Reqs <- req:
+	case <-ctx.Done():
+		queueDepth.Dec()
+		return nil, errors.Wrapf(ctx.Err(), "too many requests waiting for agent %s", agentAddr)
+	case <-agents.Done:
+		queueDepth.Dec()
+		return nil, errors.New("communication with agents has been stopped")
+	case <-worker.Stop:
+		queueDepth.Dec()
+		return nil, errors.Errorf("agent %s has been removed", agentAddr)
+	}
+
+	select {
+	case respErr := <-respChan:
+		return respErr.Response, respErr.Err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "no response from agent %s", agentAddr)
+	case <-agents.Done:
+		return nil, errors.New("communication with agents has been stopped")
+	}
 }
 
 // Pass given request directly to an agent.
@@ -62,15 +261,29 @@ func doCall(ctx context.Context, agent *Agent, in interface{}) (interface{}, err
 		response, err = agent.Client.ForwardToNamedStats(ctx, inData)
 	case *agentapi.ForwardToKeaOverHTTPReq:
 		response, err = agent.Client.ForwardToKeaOverHTTP(ctx, inData)
+	case *agentapi.ForwardToKeaOverUnixSocketReq:
+		response, err = agent.Client.ForwardToKeaOverUnixSocket(ctx, inData)
 	default:
 		err = errors.New("doCall: unsupported request type")
 	}
 	return response, err
 }
 
-// Forward request received from channel to given agent and send back response
-// via channel to requestor.
+// Forward request received from the queue to given agent and send back
+// response via channel to requestor. The request is sent even if the agent
+// failed to respond recently. The pullers skip such agents until the
+// backoff period elapses, so the requests sent during the backoff period
+// are triggered by the users and they should not be refused.
 func (agents *connectedAgentsData) handleRequest(req *commLoopReq) {
+	// The requestor may have given up while the request was waiting
+	// in the queue.
+	if req.Ctx.Err() != nil {
+		req.RespChan <- &channelResp{Response: nil, Err: errors.Wrapf(req.Ctx.Err(), "request to agent %s has not been sent", req.AgentAddr)}
+		return
+	}
+
+	failures := agents.getConsecutiveFailures(req.AgentAddr)
+
 	// get agent and its grpc connection
 	agent, err := agents.GetConnectedAgent(req.AgentAddr)
 	if err != nil {
@@ -78,29 +291,39 @@ func (agents *connectedAgentsData) handleRequest(req *commLoopReq) {
 		return
 	}
 
+	// The agent didn't respond previously so its connection is likely
+	// broken. Dial it again.
+	if failures > 0 {
+		err = agent.MakeGrpcConnection()
+		if err != nil {
+			req.RespChan <- &channelResp{Response: nil, Err: errors.WithMessage(err, "problem with connection to agent")}
+			return
+		}
+	}
+
 	// do call
-	ctx := context.Background()
-	response, err := doCall(ctx, agent, req.ReqData)
+	response, err := doCall(req.Ctx, agent, req.ReqData)
 
-	if err != nil {
+	if err != nil && failures == 0 && req.Ctx.Err() == nil {
 		// GetConnectedAgent remembers the grpc connection so it might
 		// return an already existing connection.  This connection may
 		// be broken so we should retry at least once.
 		err2 := agent.MakeGrpcConnection()
-		if err2 != nil {
-			log.Warn(err)
-			req.RespChan <- &channelResp{Response: nil, Err: errors.Wrap(err2, "problem with connection to agent")}
-			return
+		if err2 == nil {
+			// do call once again
+			response, err2 = doCall(req.Ctx, agent, req.ReqData)
 		}
-
-		// do call once again
-		response, err2 = doCall(ctx, agent, req.ReqData)
 		if err2 != nil {
 			log.Warn(err)
-			req.RespChan <- &channelResp{Response: nil, Err: errors.Wrap(err2, "problem with connection to agent")}
-			return
+			err = errors.Wrap(err2, "problem with connection to agent")
+		} else {
+			err = nil
 		}
 	}
 
+	agents.recordRequestResult(req.AgentAddr, err)
+	if err != nil {
+		response = nil
+	}
 	req.RespChan <- &channelResp{Response: response, Err: err}
 }
@@ -0,0 +1,268 @@
+package agentcomm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	agentapi "isc.org/stork/api"
+)
+
+// Creates ConnectedAgents with the given settings and the mock clients
+// for the agents with given addresses.
+func setupManagerTestCase(t *testing.T, settings *AgentsSettings, addresses ...string) (*connectedAgentsData, []*MockAgentClient, func()) {
+	certs := makeTestCerts(t)
+	agents, err := NewConnectedAgents(settings, nil, certs.caCert, certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	var clients []*MockAgentClient
+	for _, address := range addresses {
+		agent, err := agents.GetConnectedAgent(address)
+		require.NoError(t, err)
+		client := NewMockAgentClient(ctrl)
+		agent.Client = client
+		clients = append(clients, client)
+	}
+
+	return agents.(*connectedAgentsData), clients, func() {
+		agents.Shutdown()
+		ctrl.Finish()
+	}
+}
+
+// Returns a request to the agent which can be told apart from other
+// requests by its command.
+func makeRndcReq(command string) *agentapi.ForwardRndcCommandReq {
+	return &agentapi.ForwardRndcCommandReq{
+		RndcRequest: &agentapi.RndcRequest{Request: command},
+	}
+}
+
+// Waits until the number of requests in the queue of the given agent
+// reaches the expected value.
+func waitForQueueDepth(t *testing.T, address string, depth int) {
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(agentQueueDepth.WithLabelValues(address)) == float64(depth)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// Test that an agent which doesn't respond doesn't hold the requests
+// to other agents.
+func TestSlowAgentDoesNotBlockOthers(t *testing.T) {
+	agents, clients, teardown := setupManagerTestCase(t, &AgentsSettings{}, "192.0.2.1:8080", "192.0.2.2:8080")
+	defer teardown()
+
+	release := make(chan bool)
+	clients[0].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardRndcCommandReq, opts ...interface{}) (*agentapi.ForwardRndcCommandRsp, error) {
+			<-release
+			return &agentapi.ForwardRndcCommandRsp{}, nil
+		})
+	clients[1].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		Return(&agentapi.ForwardRndcCommandRsp{}, nil)
+
+	slowDone := make(chan error)
+	go func() {
+		_, err := agents.sendAndRecvViaQueue(context.Background(), "192.0.2.1:8080", makeRndcReq("slow"))
+		slowDone <- err
+	}()
+
+	_, err := agents.sendAndRecvViaQueue(context.Background(), "192.0.2.2:8080", makeRndcReq("fast"))
+	require.NoError(t, err)
+
+	close(release)
+	require.NoError(t, <-slowDone)
+}
+
+// Test that the requests to a single agent are sent in the order they
+// were queued.
+func TestRequestsOrderPerAgent(t *testing.T) {
+	address := "192.0.2.1:8080"
+	agents, clients, teardown := setupManagerTestCase(t, &AgentsSettings{}, address)
+	defer teardown()
+
+	release := make(chan bool)
+	var mutex sync.Mutex
+	var commands []string
+	clients[0].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardRndcCommandReq, opts ...interface{}) (*agentapi.ForwardRndcCommandRsp, error) {
+			mutex.Lock()
+			commands = append(commands, in.RndcRequest.Request)
+			first := len(commands) == 1
+			mutex.Unlock()
+			if first {
+				<-release
+			}
+			return &agentapi.ForwardRndcCommandRsp{}, nil
+		}).Times(4)
+
+	var wg sync.WaitGroup
+	send := func(command string) {
+		defer wg.Done()
+		_, err := agents.sendAndRecvViaQueue(context.Background(), address, makeRndcReq(command))
+		require.NoError(t, err)
+	}
+
+	// The first request holds the worker, so the next ones wait in the
+	// queue in the order they are sent.
+	wg.Add(1)
+	go send("a")
+	waitForQueueDepth(t, address, 0)
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(commands) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	for i, command := range []string{"b", "c", "d"} {
+		wg.Add(1)
+		go send(command)
+		waitForQueueDepth(t, address, i+1)
+	}
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, []string{"a", "b", "c", "d"}, commands)
+	waitForQueueDepth(t, address, 0)
+}
+
+// Test that the requestor doesn't wait for the agent longer than the
+// deadline of the request.
+func TestRequestDeadline(t *testing.T) {
+	settings := &AgentsSettings{
+		RequestTimeout: 100 * time.Millisecond,
+	}
+	agents, clients, teardown := setupManagerTestCase(t, settings, "192.0.2.1:8080")
+	defer teardown()
+
+	clients[0].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardRndcCommandReq, opts ...interface{}) (*agentapi.ForwardRndcCommandRsp, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).Times(2)
+
+	// The default deadline from the settings is applied.
+	start := time.Now()
+	_, err := agents.sendAndRecvViaQueue(context.Background(), "192.0.2.1:8080", makeRndcReq("foo"))
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	// The agent which didn't respond is not contacted during the backoff
+	// period, so forget about the failure. The requestor may get the error
+	// before the worker records the failure, so wait for it first.
+	require.Eventually(t, func() bool {
+		return agents.GetAgentHealth("192.0.2.1", 8080).ConsecutiveFailures == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	agents.mutex.Lock()
+	delete(agents.Health, "192.0.2.1:8080")
+	agents.mutex.Unlock()
+
+	// The deadline of the caller takes precedence.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = agents.sendAndRecvViaQueue(ctx, "192.0.2.1:8080", makeRndcReq("foo"))
+	require.Error(t, err)
+}
+
+// Test that the number of agents the requests are sent to in parallel is
+// limited.
+func TestMaxParallelAgents(t *testing.T) {
+	settings := &AgentsSettings{
+		MaxParallelAgents: 1,
+	}
+	agents, clients, teardown := setupManagerTestCase(t, settings, "192.0.2.1:8080", "192.0.2.2:8080")
+	defer teardown()
+
+	release := make(chan bool)
+	clients[0].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardRndcCommandReq, opts ...interface{}) (*agentapi.ForwardRndcCommandRsp, error) {
+			<-release
+			return &agentapi.ForwardRndcCommandRsp{}, nil
+		})
+	clients[1].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		Return(&agentapi.ForwardRndcCommandRsp{}, nil)
+
+	firstDone := make(chan error)
+	go func() {
+		_, err := agents.sendAndRecvViaQueue(context.Background(), "192.0.2.1:8080", makeRndcReq("first"))
+		firstDone <- err
+	}()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(agentRequestsInProgress) >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The only slot is taken so the request to the other agent can't
+	// be sent before its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := agents.sendAndRecvViaQueue(ctx, "192.0.2.2:8080", makeRndcReq("second"))
+	require.Error(t, err)
+
+	// When the slot is released the other agent can be contacted.
+	close(release)
+	require.NoError(t, <-firstDone)
+	_, err = agents.sendAndRecvViaQueue(context.Background(), "192.0.2.2:8080", makeRndcReq("second"))
+	require.NoError(t, err)
+}
+
+// Test that the requests are refused after shutdown.
+func TestSendAfterShutdown(t *testing.T) {
+	certs := makeTestCerts(t)
+	agents, err := NewConnectedAgents(&AgentsSettings{}, nil, certs.caCert, certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+	agents.Shutdown()
+
+	_, err = agents.(*connectedAgentsData).sendAndRecvViaQueue(context.Background(), "192.0.2.1:8080", makeRndcReq("foo"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "stopped")
+}
+
+// Test that removing the agent stops its worker, rejects the requests
+// waiting in its queue and removes its queue depth metric.
+func TestRemoveAgent(t *testing.T) {
+	address := "192.0.2.1:8080"
+	agents, clients, teardown := setupManagerTestCase(t, &AgentsSettings{}, address)
+	defer teardown()
+
+	started := make(chan bool)
+	release := make(chan bool)
+	clients[0].EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardRndcCommandReq, opts ...interface{}) (*agentapi.ForwardRndcCommandRsp, error) {
+			close(started)
+			<-release
+			return &agentapi.ForwardRndcCommandRsp{}, nil
+		})
+
+	// The first request holds the worker and the second one waits
+	// in the queue.
+	firstDone := make(chan error)
+	go func() {
+		_, err := agents.sendAndRecvViaQueue(context.Background(), address, makeRndcReq("first"))
+		firstDone <- err
+	}()
+	<-started
+	secondDone := make(chan error)
+	go func() {
+		_, err := agents.sendAndRecvViaQueue(context.Background(), address, makeRndcReq("second"))
+		secondDone <- err
+	}()
+	waitForQueueDepth(t, address, 1)
+	metricsCount := testutil.CollectAndCount(agentQueueDepth)
+
+	agents.RemoveAgent("192.0.2.1", 8080)
+	require.Empty(t, agents.Workers)
+	require.Empty(t, agents.AgentsMap)
+	require.Equal(t, metricsCount-1, testutil.CollectAndCount(agentQueueDepth))
+
+	// The request being sent completes and the queued one is rejected.
+	close(release)
+	require.NoError(t, <-firstDone)
+	err := <-secondDone
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has been removed")
+}
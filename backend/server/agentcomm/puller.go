@@ -4,12 +4,38 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
 )
 
+// Metrics describing the pulls, labeled with the puller names.
+var (
+	pullerDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "stork_server",
+		Subsystem: "puller",
+		Name:      "duration_seconds",
+		Help:      "Duration of the last pull",
+	}, []string{"puller"})
+
+	pullerAppsPulled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "stork_server",
+		Subsystem: "puller",
+		Name:      "apps_pulled",
+		Help:      "Number of apps from which the data were pulled successfully during the last pull",
+	}, []string{"puller"})
+
+	pullerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "stork_server",
+		Subsystem: "puller",
+		Name:      "errors_total",
+		Help:      "Number of pulls which encountered errors",
+	}, []string{"puller"})
+)
+
 // Structure representing a periodic puller which is configured to
 // execute a function specified by a caller according to the timer
 // interval specified in the database. The user's fuction typically
@@ -81,6 +107,19 @@ func (puller *PeriodicPuller) Shutdown() {
 	log.Printf("Stopped %s Puller", puller.pullerName)
 }
 
+// Executes the user defined function and records its duration and
+// outcome in the metrics.
+func (puller *PeriodicPuller) pull() {
+	start := time.Now()
+	appsOkCnt, err := puller.pullFunc()
+	pullerDuration.WithLabelValues(puller.pullerName).Set(time.Since(start).Seconds())
+	pullerAppsPulled.WithLabelValues(puller.pullerName).Set(float64(appsOkCnt))
+	if err != nil {
+		pullerErrors.WithLabelValues(puller.pullerName).Inc()
+		log.Errorf("errors were encountered while pulling data from Kea apps: %+v", err)
+	}
+}
+
 // This function controls the timing of the function execution and captures the
 // termination signal.
 func (puller *PeriodicPuller) pullerLoop() {
@@ -90,10 +129,7 @@ func (puller *PeriodicPuller) pullerLoop() {
 		// every N seconds execute user defined function
 		case <-puller.Ticker.C:
 			if puller.Active {
-				_, err := puller.pullFunc()
-				if err != nil {
-					log.Errorf("errors were encountered while pulling data from Kea apps: %+v", err)
-				}
+				puller.pull()
 			}
 		// wait for done signal from shutdown function
 		case <-puller.Done:
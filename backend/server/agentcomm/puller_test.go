@@ -4,6 +4,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
 	dbmodel "isc.org/stork/server/database/model"
@@ -63,3 +65,24 @@ func TestNewPeriodicPuller(t *testing.T) {
 		time.Second,
 		"test puller did not invoke a function within a desired time period")
 }
+
+// Test that the outcome of the pull is recorded in the metrics.
+func TestPeriodicPullerMetrics(t *testing.T) {
+	var pullErr error
+	puller := &PeriodicPuller{
+		pullerName: "Metrics Test",
+		pullFunc: func() (int, error) {
+			return 2, pullErr
+		},
+	}
+
+	puller.pull()
+	require.EqualValues(t, 2, testutil.ToFloat64(pullerAppsPulled.WithLabelValues("Metrics Test")))
+	require.Zero(t, testutil.ToFloat64(pullerErrors.WithLabelValues("Metrics Test")))
+
+	pullErr = errors.New("unreachable")
+	puller.pull()
+	puller.pull()
+	require.EqualValues(t, 2, testutil.ToFloat64(pullerErrors.WithLabelValues("Metrics Test")))
+	require.GreaterOrEqual(t, testutil.ToFloat64(pullerDuration.WithLabelValues("Metrics Test")), 0.0)
+}
@@ -0,0 +1,164 @@
+package alerting
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+	storkutil "isc.org/stork/util"
+)
+
+// Evaluates the alert rules periodically against the data stored in the
+// database by the pullers and sends the notifications about the alerts
+// which fired or were resolved.
+type Alerter struct {
+	*agentcomm.PeriodicPuller
+}
+
+// Creates an instance of the alerter. The interval between the evaluations
+// is taken from the alerting_interval setting.
+func NewAlerter(db *dbops.PgDB, agents agentcomm.ConnectedAgents) (*Alerter, error) {
+	alerter := &Alerter{}
+	periodicPuller, err := agentcomm.NewPeriodicPuller(db, agents, "Alerting",
+		"alerting_interval", alerter.evaluate)
+	if err != nil {
+		return nil, err
+	}
+	alerter.PeriodicPuller = periodicPuller
+	return alerter, nil
+}
+
+// Stops evaluating the rules.
+func (alerter *Alerter) Shutdown() {
+	alerter.PeriodicPuller.Shutdown()
+}
+
+// Evaluates all rules. It returns the number of rules successfully
+// evaluated.
+func (alerter *Alerter) evaluate() (int, error) {
+	return evaluateRules(alerter.Db, storkutil.UTCNow())
+}
+
+// Evaluates all rules at the given time.
+func evaluateRules(db *dbops.PgDB, now time.Time) (int, error) {
+	rules, err := dbmodel.GetAlertRules(db)
+	if err != nil {
+		return 0, err
+	}
+	channels, err := dbmodel.GetAlertChannels(db, true)
+	if err != nil {
+		return 0, err
+	}
+	var notifiers []notifier
+	for i := range channels {
+		n, err := newNotifier(&channels[i])
+		if err != nil {
+			log.Warnf("skipped alert channel %s: %s", channels[i].Name, err)
+			continue
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	var lastErr error
+	evaluated := 0
+	for i := range rules {
+		err = evaluateRule(db, &rules[i], notifiers, now)
+		if err != nil {
+			log.Errorf("problem with evaluating alert rule %s: %+v", rules[i].Name, err)
+			lastErr = err
+			continue
+		}
+		evaluated++
+	}
+	return evaluated, lastErr
+}
+
+// Evaluates the rule against the current state of the objects. The alerts
+// are only kept in the database while they are firing, pending or
+// silenced. The alerts of the disabled rules are removed.
+func evaluateRule(db *dbops.PgDB, rule *dbmodel.AlertRule, notifiers []notifier, now time.Time) error {
+	if !rule.Enabled {
+		return dbmodel.DeleteAlertsExcept(db, rule.ID, nil)
+	}
+
+	observations, err := observe(db, rule)
+	if err != nil {
+		return err
+	}
+	existing, err := dbmodel.GetAlertsByRuleID(db, rule.ID)
+	if err != nil {
+		return err
+	}
+	alerts := make(map[string]*dbmodel.Alert)
+	for i := range existing {
+		alerts[existing[i].ObjectKey] = &existing[i]
+	}
+
+	var keepIDs []int64
+	for _, obs := range observations {
+		alert, ok := alerts[obs.key]
+		if !ok {
+			alert = &dbmodel.Alert{
+				RuleID:    rule.ID,
+				ObjectKey: obs.key,
+			}
+		}
+		result := updateAlert(alert, rule, obs, now)
+		if !alert.Firing && alert.PendingCount == 0 && !now.Before(alert.SilencedUntil) && result != alertResolved {
+			// Nothing to remember about the object.
+			continue
+		}
+		err = dbmodel.CommitAlert(db, alert)
+		if err != nil {
+			return err
+		}
+		keepIDs = append(keepIDs, alert.ID)
+
+		if result != noTransition {
+			reportTransition(db, rule, alert, obs, result, notifiers, now)
+		}
+	}
+
+	// Remove the alerts for the objects which are gone or are fine.
+	return dbmodel.DeleteAlertsExcept(db, rule.ID, keepIDs)
+}
+
+// Records the event about the alert which fired or was resolved and sends
+// the notifications unless the alert is silenced.
+func reportTransition(db *dbops.PgDB, rule *dbmodel.AlertRule, alert *dbmodel.Alert, obs *observation, result transition, notifiers []notifier, now time.Time) {
+	n := &Notification{
+		Status:      StatusFiring,
+		Rule:        rule.Name,
+		Kind:        rule.Kind,
+		Description: obs.description,
+		Value:       obs.value,
+		Labels:      obs.labels,
+		FiredAt:     alert.FiredAt,
+	}
+	if rule.Kind == dbmodel.AlertRuleSubnetUtilization {
+		n.Threshold = rule.Threshold
+	}
+	level := dbmodel.EvWarning
+	if result == alertResolved {
+		n.Status = StatusResolved
+		resolvedAt := alert.ResolvedAt
+		n.ResolvedAt = &resolvedAt
+		level = dbmodel.EvInfo
+	}
+	text := fmt.Sprintf("alert %s %s: %s", rule.Name, n.Status, obs.description)
+	eventcenter.AddEvent(db, eventcenter.CreateEvent(level, text, obs.objects...))
+
+	if alert.IsSilenced(rule, now) {
+		return
+	}
+	for _, notifier := range notifiers {
+		if err := notifier.notify(n); err != nil {
+			log.Errorf("problem with sending notification about alert %s: %+v", rule.Name, err)
+		}
+	}
+}
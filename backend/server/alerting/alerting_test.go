@@ -0,0 +1,158 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the subnet utilization alert fires, is silenced and is
+// resolved and that the notifications are sent to the webhook.
+func TestEvaluateRules(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	var mutex sync.Mutex
+	var notifications []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		n := Notification{}
+		_ = json.Unmarshal(body, &n)
+		mutex.Lock()
+		notifications = append(notifications, n)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	received := func() []Notification {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]Notification{}, notifications...)
+	}
+
+	err := dbmodel.AddAlertChannel(db, &dbmodel.AlertChannel{
+		Name:    "hook",
+		Type:    dbmodel.AlertChannelWebhook,
+		Enabled: true,
+		Params:  dbmodel.AlertChannelParams{URL: server.URL},
+	})
+	require.NoError(t, err)
+
+	rule := &dbmodel.AlertRule{
+		Name:           "utilization",
+		Kind:           dbmodel.AlertRuleSubnetUtilization,
+		Threshold:      90,
+		ClearThreshold: 80,
+		ForEvaluations: 2,
+		Enabled:        true,
+	}
+	err = dbmodel.AddAlertRule(db, rule)
+	require.NoError(t, err)
+
+	subnet := &dbmodel.Subnet{Prefix: "192.0.2.0/24"}
+	err = dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	evaluate := func(utilization int16) {
+		require.NoError(t, subnet.UpdateUtilization(db, utilization, 0))
+		evaluated, err := evaluateRules(db, now)
+		require.NoError(t, err)
+		require.Equal(t, 1, evaluated)
+		now = now.Add(time.Minute)
+	}
+
+	// The utilization is low so there is nothing to store.
+	evaluate(500)
+	alerts, err := dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// The alert is pending after the first evaluation.
+	evaluate(950)
+	alerts, err = dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.False(t, alerts[0].Firing)
+	require.Equal(t, 1, alerts[0].PendingCount)
+	require.Empty(t, received())
+
+	evaluate(950)
+	alerts, err = dbmodel.GetAlerts(db, true)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "subnet:1", alerts[0].ObjectKey)
+	require.NotNil(t, alerts[0].Rule)
+	require.Equal(t, "utilization", alerts[0].Rule.Name)
+	require.Len(t, received(), 1)
+	n := received()[0]
+	require.Equal(t, StatusFiring, n.Status)
+	require.EqualValues(t, 95, n.Value)
+	require.EqualValues(t, 90, n.Threshold)
+	require.Equal(t, "192.0.2.0/24", n.Labels["subnet"])
+	require.Nil(t, n.ResolvedAt)
+
+	// The event about the alert has been recorded.
+	events, total, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, subnet.ID, dbmodel.SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, dbmodel.EvWarning, events[0].Level)
+	require.Contains(t, events[0].Text, "alert utilization firing")
+
+	// The silenced alert is resolved but the notification is not sent.
+	err = dbmodel.SilenceAlert(db, alerts[0].ID, now.Add(time.Hour))
+	require.NoError(t, err)
+	evaluate(100)
+	evaluate(100)
+	require.Len(t, received(), 1)
+	alerts, err = dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.False(t, alerts[0].Firing)
+	require.False(t, alerts[0].ResolvedAt.IsZero())
+
+	// Silencing has expired. The alert fires again.
+	now = now.Add(2 * time.Hour)
+	evaluate(1000)
+	evaluate(1000)
+	require.Len(t, received(), 2)
+
+	// The value between the thresholds doesn't resolve the alert.
+	evaluate(850)
+	evaluate(850)
+	require.Len(t, received(), 2)
+
+	evaluate(700)
+	evaluate(700)
+	require.Len(t, received(), 3)
+	n = received()[2]
+	require.Equal(t, StatusResolved, n.Status)
+	require.NotNil(t, n.ResolvedAt)
+
+	// The resolved alert is removed in the next evaluation.
+	evaluate(700)
+	alerts, err = dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// The alerts of the disabled rule are removed.
+	evaluate(1000)
+	alerts, err = dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	rule.Enabled = false
+	err = dbmodel.UpdateAlertRule(db, rule)
+	require.NoError(t, err)
+	evaluate(1000)
+	alerts, err = dbmodel.GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+}
@@ -0,0 +1,247 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Maximum time for sending a notification over a single channel.
+const notificationTimeout = 10 * time.Second
+
+// Value returned over the ReST API instead of the values of the webhook
+// headers, which often carry credentials. The stored value is kept when
+// the channel is updated with this value.
+const RedactedHeaderValue = "********"
+
+// Statuses of the alert reported in the notifications.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Notification about the alert which fired or was resolved. It is sent
+// as JSON by the webhook channel.
+type Notification struct {
+	Status      string            `json:"status"`
+	Rule        string            `json:"rule"`
+	Kind        string            `json:"kind"`
+	Description string            `json:"description"`
+	Value       float64           `json:"value"`
+	Threshold   float64           `json:"threshold,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	FiredAt     time.Time         `json:"firedAt"`
+	ResolvedAt  *time.Time        `json:"resolvedAt,omitempty"`
+}
+
+// Returns the one line summary of the notification.
+func (n *Notification) summary() string {
+	return fmt.Sprintf("[Stork] %s: %s: %s", strings.ToUpper(n.Status), n.Rule, n.Description)
+}
+
+// Interface implemented by the notification channels.
+type notifier interface {
+	notify(n *Notification) error
+}
+
+// Channel posting the notifications in JSON format to the given URL.
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// Channel sending the notifications by email.
+type smtpNotifier struct {
+	address  string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+// Creates the notifier for the channel. It returns an error if the
+// parameters of the channel are invalid.
+func newNotifier(channel *dbmodel.AlertChannel) (notifier, error) {
+	params := channel.Params
+	switch channel.Type {
+	case dbmodel.AlertChannelWebhook:
+		u, err := url.Parse(params.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("invalid webhook URL %s", params.URL)
+		}
+		return &webhookNotifier{
+			url:     params.URL,
+			headers: params.Headers,
+			client:  &http.Client{Timeout: notificationTimeout},
+		}, nil
+	case dbmodel.AlertChannelSMTP:
+		if _, _, err := net.SplitHostPort(params.SMTPAddress); err != nil {
+			return nil, errors.Errorf("invalid SMTP server address %s, expected host:port", params.SMTPAddress)
+		}
+		if len(params.SMTPTo) == 0 {
+			return nil, errors.New("no recipients specified for SMTP channel")
+		}
+		from := params.SMTPFrom
+		if from == "" {
+			from = "stork@localhost"
+		}
+		// The addresses are put in the email headers, so the line breaks
+		// would allow for injecting other headers.
+		if hasLineBreak(from) {
+			return nil, errors.New("SMTP sender address must not contain line breaks")
+		}
+		for _, to := range params.SMTPTo {
+			if hasLineBreak(to) {
+				return nil, errors.New("SMTP recipient address must not contain line breaks")
+			}
+		}
+		return &smtpNotifier{
+			address:  params.SMTPAddress,
+			from:     from,
+			to:       params.SMTPTo,
+			username: params.SMTPUsername,
+			password: params.SMTPPassword,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported alert channel type %s", channel.Type)
+	}
+}
+
+// Checks if the text contains CR or LF.
+func hasLineBreak(text string) bool {
+	return strings.ContainsAny(text, "\r\n")
+}
+
+// Replaces CR and LF with spaces, so the text can be safely put in the
+// email header.
+func stripLineBreaks(text string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+}
+
+// Validates the notification channel before it is stored in the database.
+func ValidateChannel(channel *dbmodel.AlertChannel) error {
+	if strings.TrimSpace(channel.Name) == "" {
+		return errors.New("alert channel name must not be empty")
+	}
+	_, err := newNotifier(channel)
+	return err
+}
+
+// Posts the notification to the webhook URL. Any 2xx response status is
+// considered a success.
+func (w *webhookNotifier) notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrapf(err, "problem with serializing notification")
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "problem with creating request to webhook %s", w.url)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "problem with sending notification to webhook %s", w.url)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return errors.Errorf("webhook %s returned status %s", w.url, rsp.Status)
+	}
+	return nil
+}
+
+// Returns the email message with the notification. The line breaks are
+// stripped from the header values, so the rule name or the description
+// can't be used to inject other headers.
+func (s *smtpNotifier) message(n *Notification) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", stripLineBreaks(s.from))
+	fmt.Fprintf(&b, "To: %s\r\n", stripLineBreaks(strings.Join(s.to, ", ")))
+	fmt.Fprintf(&b, "Subject: %s\r\n", stripLineBreaks(n.summary()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", n.Description)
+	fmt.Fprintf(&b, "Rule: %s (%s)\r\n", stripLineBreaks(n.Rule), n.Kind)
+	fmt.Fprintf(&b, "Status: %s\r\n", n.Status)
+	fmt.Fprintf(&b, "Value: %g\r\n", n.Value)
+	if n.Threshold > 0 {
+		fmt.Fprintf(&b, "Threshold: %g\r\n", n.Threshold)
+	}
+	fmt.Fprintf(&b, "Fired at: %s\r\n", n.FiredAt.Format(time.RFC3339))
+	if n.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved at: %s\r\n", n.ResolvedAt.Format(time.RFC3339))
+	}
+	var names []string
+	for name := range n.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, n.Labels[name])
+	}
+	return []byte(b.String())
+}
+
+// Sends the notification by email. The connection is upgraded to TLS if
+// the server supports it. The credentials are only sent when specified.
+func (s *smtpNotifier) notify(n *Notification) error {
+	host, _, _ := net.SplitHostPort(s.address)
+	conn, err := net.DialTimeout("tcp", s.address, notificationTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "problem with connecting to SMTP server %s", s.address)
+	}
+	_ = conn.SetDeadline(time.Now().Add(notificationTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrapf(err, "problem with connecting to SMTP server %s", s.address)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return errors.Wrapf(err, "problem with starting TLS with SMTP server %s", s.address)
+		}
+	}
+	if s.username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.username, s.password, host)); err != nil {
+			return errors.Wrapf(err, "problem with authenticating to SMTP server %s", s.address)
+		}
+	}
+	if err = c.Mail(s.from); err != nil {
+		return errors.Wrapf(err, "SMTP server %s rejected sender %s", s.address, s.from)
+	}
+	for _, to := range s.to {
+		if err = c.Rcpt(to); err != nil {
+			return errors.Wrapf(err, "SMTP server %s rejected recipient %s", s.address, to)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrapf(err, "problem with sending email to SMTP server %s", s.address)
+	}
+	if _, err = w.Write(s.message(n)); err != nil {
+		return errors.Wrapf(err, "problem with sending email to SMTP server %s", s.address)
+	}
+	if err = w.Close(); err != nil {
+		return errors.Wrapf(err, "problem with sending email to SMTP server %s", s.address)
+	}
+	return c.Quit()
+}
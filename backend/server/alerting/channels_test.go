@@ -0,0 +1,293 @@
+package alerting
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Email received by the fake SMTP server.
+type receivedEmail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Minimal SMTP server accepting all emails. It doesn't support any
+// extensions.
+type fakeSMTPServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	emails   []receivedEmail
+	wg       sync.WaitGroup
+}
+
+// Starts the fake SMTP server on a random local port.
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeSMTPServer{listener: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.wg.Add(1)
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Returns the address the server listens on.
+func (s *fakeSMTPServer) address() string {
+	return s.listener.Addr().String()
+}
+
+// Stops the server.
+func (s *fakeSMTPServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Returns the received emails.
+func (s *fakeSMTPServer) received() []receivedEmail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedEmail{}, s.emails...)
+}
+
+// Handles a single SMTP session.
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost fake SMTP")
+	email := receivedEmail{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			email.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			email.to = append(email.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			email.data = data.String()
+			s.mutex.Lock()
+			s.emails = append(s.emails, email)
+			s.mutex.Unlock()
+			email = receivedEmail{}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+// Returns a notification used in the tests.
+func makeTestNotification() *Notification {
+	return &Notification{
+		Status:      StatusFiring,
+		Rule:        "utilization",
+		Kind:        dbmodel.AlertRuleSubnetUtilization,
+		Description: "address utilization of subnet 192.0.2.0/24 is 95.0%",
+		Value:       95,
+		Threshold:   90,
+		Labels: map[string]string{
+			"subnet":  "192.0.2.0/24",
+			"servers": "192.0.2.1:8000",
+		},
+		FiredAt: time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// Test that the channels with invalid parameters are rejected.
+func TestValidateChannel(t *testing.T) {
+	channel := &dbmodel.AlertChannel{
+		Name: "hook",
+		Type: dbmodel.AlertChannelWebhook,
+		Params: dbmodel.AlertChannelParams{
+			URL: "https://example.org/hook",
+		},
+	}
+	require.NoError(t, ValidateChannel(channel))
+	channel.Params.URL = "ftp://example.org"
+	require.Error(t, ValidateChannel(channel))
+	channel.Params.URL = "http://"
+	require.Error(t, ValidateChannel(channel))
+
+	channel = &dbmodel.AlertChannel{
+		Name: "email",
+		Type: dbmodel.AlertChannelSMTP,
+		Params: dbmodel.AlertChannelParams{
+			SMTPAddress: "mail.example.org:25",
+			SMTPTo:      []string{"noc@example.org"},
+		},
+	}
+	require.NoError(t, ValidateChannel(channel))
+	channel.Params.SMTPAddress = "mail.example.org"
+	require.Error(t, ValidateChannel(channel))
+	channel.Params.SMTPAddress = "mail.example.org:25"
+	channel.Params.SMTPTo = nil
+	require.Error(t, ValidateChannel(channel))
+	channel.Params.SMTPTo = []string{"noc@example.org\r\nBcc: victim@example.org"}
+	require.Error(t, ValidateChannel(channel))
+	channel.Params.SMTPTo = []string{"noc@example.org"}
+	channel.Params.SMTPFrom = "stork@example.org\nBcc: victim@example.org"
+	require.Error(t, ValidateChannel(channel))
+	channel.Params.SMTPFrom = ""
+
+	channel.Name = ""
+	require.Error(t, ValidateChannel(channel))
+	channel.Name = "email"
+
+	channel.Type = "pager"
+	require.Error(t, ValidateChannel(channel))
+}
+
+// Test that the notification is posted to the webhook as JSON.
+func TestWebhookNotifier(t *testing.T) {
+	var received Notification
+	var contentType, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		token = r.Header.Get("X-Token")
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	n, err := newNotifier(&dbmodel.AlertChannel{
+		Type: dbmodel.AlertChannelWebhook,
+		Params: dbmodel.AlertChannelParams{
+			URL:     server.URL,
+			Headers: map[string]string{"X-Token": "secret"},
+		},
+	})
+	require.NoError(t, err)
+
+	notification := makeTestNotification()
+	err = n.notify(notification)
+	require.NoError(t, err)
+	require.Equal(t, "application/json", contentType)
+	require.Equal(t, "secret", token)
+	require.Equal(t, *notification, received)
+}
+
+// Test that the error status returned by the webhook is reported.
+func TestWebhookNotifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n, err := newNotifier(&dbmodel.AlertChannel{
+		Type:   dbmodel.AlertChannelWebhook,
+		Params: dbmodel.AlertChannelParams{URL: server.URL},
+	})
+	require.NoError(t, err)
+	err = n.notify(makeTestNotification())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "500")
+}
+
+// Test that the notification is sent by email.
+func TestSMTPNotifier(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	defer server.close()
+
+	n, err := newNotifier(&dbmodel.AlertChannel{
+		Type: dbmodel.AlertChannelSMTP,
+		Params: dbmodel.AlertChannelParams{
+			SMTPAddress: server.address(),
+			SMTPFrom:    "stork@example.org",
+			SMTPTo:      []string{"noc@example.org", "admin@example.org"},
+		},
+	})
+	require.NoError(t, err)
+
+	err = n.notify(makeTestNotification())
+	require.NoError(t, err)
+
+	emails := server.received()
+	require.Len(t, emails, 1)
+	require.Equal(t, "stork@example.org", emails[0].from)
+	require.Equal(t, []string{"noc@example.org", "admin@example.org"}, emails[0].to)
+	require.Contains(t, emails[0].data, "Subject: [Stork] FIRING: utilization: address utilization of subnet 192.0.2.0/24 is 95.0%")
+	require.Contains(t, emails[0].data, "Threshold: 90")
+	require.Contains(t, emails[0].data, "servers: 192.0.2.1:8000")
+	require.Contains(t, emails[0].data, "subnet: 192.0.2.0/24")
+}
+
+// Test that the line breaks are stripped from the email headers.
+func TestSMTPMessageHeaders(t *testing.T) {
+	s := &smtpNotifier{
+		from: "stork@example.org\r\nBcc: victim@example.org",
+		to:   []string{"noc@example.org\nBcc: victim@example.org"},
+	}
+	n := makeTestNotification()
+	n.Rule = "utilization\r\nBcc: victim@example.org"
+	message := string(s.message(n))
+	require.NotContains(t, message, "\nBcc:")
+	require.Contains(t, message, "From: stork@example.org  Bcc: victim@example.org\r\n")
+	require.Contains(t, message, "To: noc@example.org Bcc: victim@example.org\r\n")
+	require.Contains(t, message, "Subject: [Stork] FIRING: utilization  Bcc: victim@example.org: ")
+}
+
+// Test that the failure to connect to the SMTP server is reported.
+func TestSMTPNotifierConnectionError(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	address := server.address()
+	server.close()
+
+	n, err := newNotifier(&dbmodel.AlertChannel{
+		Type: dbmodel.AlertChannelSMTP,
+		Params: dbmodel.AlertChannelParams{
+			SMTPAddress: address,
+			SMTPTo:      []string{"noc@example.org"},
+		},
+	})
+	require.NoError(t, err)
+	require.Error(t, n.notify(makeTestNotification()))
+}
@@ -0,0 +1,282 @@
+package alerting
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// State of a single object checked by the rule, e.g. a subnet, in a single
+// evaluation.
+type observation struct {
+	// Key identifying the object across the evaluations.
+	key         string
+	description string
+	// Value compared against the thresholds of the rule. For the rules
+	// without thresholds it is greater than 0 when the object is in
+	// a bad state.
+	value float64
+	// Inventory information about the object sent in the notifications.
+	labels map[string]string
+	// Objects the events about the alert refer to.
+	objects []interface{}
+}
+
+// Outcome of the alert update.
+type transition int
+
+const (
+	noTransition transition = iota
+	alertFired
+	alertResolved
+)
+
+// Validates the alert rule before it is stored in the database.
+func ValidateRule(rule *dbmodel.AlertRule) error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return errors.New("alert rule name must not be empty")
+	}
+	// The rule name is put in the subject of the email notifications.
+	if hasLineBreak(rule.Name) {
+		return errors.New("alert rule name must not contain line breaks")
+	}
+	switch rule.Kind {
+	case dbmodel.AlertRuleSubnetUtilization:
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return errors.Errorf("threshold of the %s alert rule must be in range (0, 100]", rule.Kind)
+		}
+		if rule.ClearThreshold < 0 || rule.ClearThreshold > rule.Threshold {
+			return errors.Errorf("clear threshold of the %s alert rule must be in range [0, %g]", rule.Kind, rule.Threshold)
+		}
+	case dbmodel.AlertRuleHAPeerUnreachable, dbmodel.AlertRuleDaemonInactive:
+	default:
+		return errors.Errorf("unsupported alert rule kind %s", rule.Kind)
+	}
+	if rule.ForEvaluations < 1 {
+		return errors.New("number of evaluations before the alert fires or is resolved must be at least 1")
+	}
+	return nil
+}
+
+// Returns the thresholds of the rule. The alert fires when the value is
+// at or above the first threshold and is resolved when the value drops
+// below the second one. The rules without thresholds fire when the value
+// is greater than 0.
+func thresholds(rule *dbmodel.AlertRule) (fire, clear float64) {
+	if rule.Kind != dbmodel.AlertRuleSubnetUtilization {
+		return 1, 1
+	}
+	fire = rule.Threshold
+	clear = rule.ClearThreshold
+	if clear <= 0 || clear > fire {
+		clear = fire
+	}
+	return fire, clear
+}
+
+// Updates the alert with the current state of the object. The alert fires
+// or is resolved when the condition has changed for the number of
+// consecutive evaluations specified in the rule.
+func updateAlert(alert *dbmodel.Alert, rule *dbmodel.AlertRule, obs *observation, now time.Time) transition {
+	alert.Value = obs.value
+	alert.Description = obs.description
+
+	fire, clear := thresholds(rule)
+	var changing bool
+	if alert.Firing {
+		changing = obs.value < clear
+	} else {
+		changing = obs.value >= fire
+	}
+	if !changing {
+		alert.PendingCount = 0
+		return noTransition
+	}
+
+	alert.PendingCount++
+	if alert.PendingCount < rule.ForEvaluations {
+		return noTransition
+	}
+	alert.PendingCount = 0
+	if alert.Firing {
+		alert.Firing = false
+		alert.ResolvedAt = now
+		return alertResolved
+	}
+	alert.Firing = true
+	alert.FiredAt = now
+	alert.ResolvedAt = time.Time{}
+	return alertFired
+}
+
+// Returns the current state of the objects checked by the rule.
+func observe(db *dbops.PgDB, rule *dbmodel.AlertRule) ([]*observation, error) {
+	switch rule.Kind {
+	case dbmodel.AlertRuleSubnetUtilization:
+		return observeSubnets(db)
+	case dbmodel.AlertRuleHAPeerUnreachable:
+		return observeHAServices(db)
+	case dbmodel.AlertRuleDaemonInactive:
+		return observeDaemons(db)
+	default:
+		return nil, errors.Errorf("unsupported alert rule kind %s", rule.Kind)
+	}
+}
+
+// Returns the address utilization of the subnets in percent.
+func observeSubnets(db *dbops.PgDB) ([]*observation, error) {
+	subnets, err := dbmodel.GetAllSubnets(db, 0)
+	if err != nil {
+		return nil, err
+	}
+	var observations []*observation
+	for i := range subnets {
+		subnet := &subnets[i]
+		utilization := float64(subnet.AddrUtilization) / 10
+		labels := map[string]string{
+			"subnet": subnet.Prefix,
+		}
+		if subnet.SharedNetwork != nil {
+			labels["sharedNetwork"] = subnet.SharedNetwork.Name
+		}
+		var servers []string
+		for _, ls := range subnet.LocalSubnets {
+			if ls.App == nil {
+				continue
+			}
+			if ap, err := ls.App.GetControlAccessPoint(); err == nil {
+				servers = append(servers, ap.Location())
+			}
+		}
+		if len(servers) > 0 {
+			labels["servers"] = strings.Join(servers, ",")
+		}
+		observations = append(observations, &observation{
+			key:         fmt.Sprintf("subnet:%d", subnet.ID),
+			description: fmt.Sprintf("address utilization of subnet %s is %.1f%%", subnet.Prefix, utilization),
+			value:       utilization,
+			labels:      labels,
+			objects:     []interface{}{subnet},
+		})
+	}
+	return observations, nil
+}
+
+// Returns the number of unreachable servers in the HA services. The server
+// which status hasn't been pulled yet is not considered unreachable.
+func observeHAServices(db *dbops.PgDB) ([]*observation, error) {
+	services, err := dbmodel.GetDetailedAllServices(db)
+	if err != nil {
+		return nil, err
+	}
+	apps := make(map[int64]*dbmodel.App)
+	var observations []*observation
+	for i := range services {
+		ha := services[i].HAService
+		if ha == nil {
+			continue
+		}
+		obs := &observation{
+			key: fmt.Sprintf("ha-service:%d", services[i].ID),
+			labels: map[string]string{
+				"service": services[i].Name,
+				"haType":  ha.HAType,
+				"haMode":  ha.HAMode,
+			},
+		}
+		var unreachable []string
+		servers := []struct {
+			role      string
+			daemonID  int64
+			reachable bool
+			state     string
+		}{
+			{"primary", ha.PrimaryID, ha.PrimaryReachable, ha.PrimaryLastState},
+			{"secondary", ha.SecondaryID, ha.SecondaryReachable, ha.SecondaryLastState},
+		}
+		for _, server := range servers {
+			var daemon *dbmodel.Daemon
+			for _, d := range services[i].Daemons {
+				if d.ID == server.daemonID {
+					daemon = d
+					break
+				}
+			}
+			if daemon != nil {
+				app, ok := apps[daemon.AppID]
+				if !ok {
+					app, err = dbmodel.GetAppByID(db, daemon.AppID)
+					if err != nil {
+						return nil, err
+					}
+					apps[daemon.AppID] = app
+				}
+				if app != nil && app.Machine != nil {
+					obs.labels[server.role] = app.Machine.Address
+				}
+			}
+			if !server.reachable && server.state != "" {
+				unreachable = append(unreachable, server.role)
+				if daemon != nil {
+					obs.objects = append(obs.objects, daemon)
+				}
+			}
+		}
+		obs.value = float64(len(unreachable))
+		if len(unreachable) > 0 {
+			obs.labels["unreachable"] = strings.Join(unreachable, ",")
+			obs.description = fmt.Sprintf("%s server of HA service %d is unreachable", strings.Join(unreachable, " and "), services[i].ID)
+		} else {
+			obs.description = fmt.Sprintf("servers of HA service %d are reachable", services[i].ID)
+		}
+		observations = append(observations, obs)
+	}
+	return observations, nil
+}
+
+// Returns the state of the daemons of the apps running on the authorized
+// machines. The value is 1 for the daemons which are not running. The
+// daemons are identified by the app and the daemon name because the
+// daemons are re-created when the state of the app is refreshed.
+func observeDaemons(db *dbops.PgDB) ([]*observation, error) {
+	var observations []*observation
+	for _, appType := range []string{dbmodel.AppTypeKea, dbmodel.AppTypeBind9} {
+		apps, err := dbmodel.GetAuthorizedAppsByType(db, appType)
+		if err != nil {
+			return nil, err
+		}
+		for i := range apps {
+			app := &apps[i]
+			for _, daemon := range app.Daemons {
+				obs := &observation{
+					key:     fmt.Sprintf("app:%d/%s", app.ID, daemon.Name),
+					labels:  map[string]string{"app": app.Type, "daemon": daemon.Name},
+					objects: []interface{}{daemon, app},
+				}
+				address := ""
+				if app.Machine != nil {
+					address = app.Machine.Address
+					obs.labels["machine"] = address
+					obs.labels["hostname"] = app.Machine.State.Hostname
+					obs.objects = append(obs.objects, app.Machine)
+				}
+				if daemon.Version != "" {
+					obs.labels["version"] = daemon.Version
+				}
+				if daemon.Active {
+					obs.description = fmt.Sprintf("%s daemon of %s app on machine %s is active", daemon.Name, app.Type, address)
+				} else {
+					obs.value = 1
+					obs.description = fmt.Sprintf("%s daemon of %s app on machine %s is not active", daemon.Name, app.Type, address)
+				}
+				observations = append(observations, obs)
+			}
+		}
+	}
+	return observations, nil
+}
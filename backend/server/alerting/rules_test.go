@@ -0,0 +1,150 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Test that the invalid rules are rejected.
+func TestValidateRule(t *testing.T) {
+	rule := &dbmodel.AlertRule{
+		Name:           "utilization",
+		Kind:           dbmodel.AlertRuleSubnetUtilization,
+		Threshold:      90,
+		ClearThreshold: 80,
+		ForEvaluations: 1,
+	}
+	require.NoError(t, ValidateRule(rule))
+
+	rule.ClearThreshold = 95
+	require.Error(t, ValidateRule(rule))
+	rule.ClearThreshold = 0
+	require.NoError(t, ValidateRule(rule))
+
+	rule.Threshold = 0
+	require.Error(t, ValidateRule(rule))
+	rule.Threshold = 101
+	require.Error(t, ValidateRule(rule))
+	rule.Threshold = 100
+
+	rule.ForEvaluations = 0
+	require.Error(t, ValidateRule(rule))
+	rule.ForEvaluations = 3
+
+	rule.Name = " "
+	require.Error(t, ValidateRule(rule))
+	rule.Name = "foo\r\nBcc: victim@example.org"
+	require.Error(t, ValidateRule(rule))
+	rule.Name = "foo"
+
+	// The rules without thresholds.
+	rule.Kind = dbmodel.AlertRuleDaemonInactive
+	rule.Threshold = 0
+	require.NoError(t, ValidateRule(rule))
+	rule.Kind = dbmodel.AlertRuleHAPeerUnreachable
+	require.NoError(t, ValidateRule(rule))
+
+	rule.Kind = "foo"
+	require.Error(t, ValidateRule(rule))
+}
+
+// Test that the clear threshold defaults to the threshold.
+func TestThresholds(t *testing.T) {
+	rule := &dbmodel.AlertRule{
+		Kind:           dbmodel.AlertRuleSubnetUtilization,
+		Threshold:      90,
+		ClearThreshold: 80,
+	}
+	fire, clear := thresholds(rule)
+	require.EqualValues(t, 90, fire)
+	require.EqualValues(t, 80, clear)
+
+	rule.ClearThreshold = 0
+	fire, clear = thresholds(rule)
+	require.EqualValues(t, 90, fire)
+	require.EqualValues(t, 90, clear)
+
+	rule.Kind = dbmodel.AlertRuleDaemonInactive
+	fire, clear = thresholds(rule)
+	require.EqualValues(t, 1, fire)
+	require.EqualValues(t, 1, clear)
+}
+
+// Test that the alert doesn't flap when the value oscillates between the
+// threshold and the clear threshold.
+func TestUpdateAlertClearThreshold(t *testing.T) {
+	rule := &dbmodel.AlertRule{
+		Kind:           dbmodel.AlertRuleSubnetUtilization,
+		Threshold:      90,
+		ClearThreshold: 80,
+		ForEvaluations: 1,
+	}
+	alert := &dbmodel.Alert{}
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	require.Equal(t, noTransition, updateAlert(alert, rule, &observation{value: 89.9}, now))
+	require.False(t, alert.Firing)
+
+	require.Equal(t, alertFired, updateAlert(alert, rule, &observation{value: 90, description: "foo"}, now))
+	require.True(t, alert.Firing)
+	require.Equal(t, now, alert.FiredAt)
+	require.EqualValues(t, 90, alert.Value)
+	require.Equal(t, "foo", alert.Description)
+
+	// Below the threshold but above the clear threshold.
+	require.Equal(t, noTransition, updateAlert(alert, rule, &observation{value: 85}, now))
+	require.True(t, alert.Firing)
+	require.Equal(t, noTransition, updateAlert(alert, rule, &observation{value: 95}, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, &observation{value: 80}, now))
+	require.True(t, alert.Firing)
+
+	later := now.Add(time.Minute)
+	require.Equal(t, alertResolved, updateAlert(alert, rule, &observation{value: 79.9}, later))
+	require.False(t, alert.Firing)
+	require.Equal(t, later, alert.ResolvedAt)
+	require.Equal(t, now, alert.FiredAt)
+
+	// Firing again clears the resolution time.
+	require.Equal(t, alertFired, updateAlert(alert, rule, &observation{value: 100}, later))
+	require.True(t, alert.ResolvedAt.IsZero())
+}
+
+// Test that the alert fires and is resolved after the condition changes for
+// the specified number of consecutive evaluations.
+func TestUpdateAlertForEvaluations(t *testing.T) {
+	rule := &dbmodel.AlertRule{
+		Kind:           dbmodel.AlertRuleDaemonInactive,
+		ForEvaluations: 3,
+	}
+	alert := &dbmodel.Alert{}
+	now := time.Now()
+	down := &observation{value: 1}
+	up := &observation{value: 0}
+
+	require.Equal(t, noTransition, updateAlert(alert, rule, down, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, down, now))
+	require.Equal(t, 2, alert.PendingCount)
+
+	// The condition is gone before the alert fired.
+	require.Equal(t, noTransition, updateAlert(alert, rule, up, now))
+	require.Zero(t, alert.PendingCount)
+
+	require.Equal(t, noTransition, updateAlert(alert, rule, down, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, down, now))
+	require.Equal(t, alertFired, updateAlert(alert, rule, down, now))
+	require.True(t, alert.Firing)
+	require.Zero(t, alert.PendingCount)
+
+	// A single good evaluation doesn't resolve the alert.
+	require.Equal(t, noTransition, updateAlert(alert, rule, up, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, down, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, up, now))
+	require.Equal(t, noTransition, updateAlert(alert, rule, up, now))
+	require.True(t, alert.Firing)
+	require.Equal(t, alertResolved, updateAlert(alert, rule, up, now))
+	require.False(t, alert.Firing)
+}
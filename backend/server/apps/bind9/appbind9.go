@@ -43,7 +43,7 @@ func GetAppStatistics(ctx context.Context, agents agentcomm.ConnectedAgents, dbA
 		log.Warnf("problem with getting named statistics-channel access point: %s", err)
 		return
 	}
-	statsAddress := storkutil.HostWithPortURL(statsChannel.Address, statsChannel.Port)
+	statsAddress := storkutil.HostWithPortURL(statsChannel.Address, statsChannel.Port, statsChannel.UseSecureProtocol)
 	statsRequest := "json/v1/server"
 	statsURL := fmt.Sprintf("%s%s", statsAddress, statsRequest)
 
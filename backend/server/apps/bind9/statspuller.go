@@ -40,7 +40,7 @@ func (statsPuller *StatsPuller) Shutdown() {
 // pulled and last encountered error.
 func (statsPuller *StatsPuller) pullStats() (int, error) {
 	// get list of all bind9 apps from database
-	dbApps, err := dbmodel.GetAppsByType(statsPuller.Db, dbmodel.AppTypeBind9)
+	dbApps, err := dbmodel.GetAuthorizedAppsByType(statsPuller.Db, dbmodel.AppTypeBind9)
 	if err != nil {
 		return 0, err
 	}
@@ -50,6 +50,10 @@ func (statsPuller *StatsPuller) pullStats() (int, error) {
 	appsOkCnt := 0
 	for _, dbApp := range dbApps {
 		dbApp2 := dbApp
+		if !statsPuller.Agents.IsAgentAvailable(dbApp.Machine.Address, dbApp.Machine.AgentPort) {
+			log.Warnf("skipped pulling stats from app %d because its agent is unreachable", dbApp.ID)
+			continue
+		}
 		err := statsPuller.getStatsFromApp(&dbApp2)
 		if err != nil {
 			lastErr = err
@@ -59,6 +63,13 @@ func (statsPuller *StatsPuller) pullStats() (int, error) {
 		}
 	}
 	log.Printf("completed pulling stats from BIND 9 apps: %d/%d succeeded", appsOkCnt, len(dbApps))
+
+	// apply the retention policy to the stats history
+	err = dbmodel.CompactStatisticSamples(statsPuller.Db, storkutil.UTCNow())
+	if err != nil {
+		log.Errorf("cannot compact history of stats: %s", err)
+		lastErr = err
+	}
 	return appsOkCnt, lastErr
 }
 
@@ -69,7 +80,7 @@ func (statsPuller *StatsPuller) getStatsFromApp(dbApp *dbmodel.App) error {
 	if err != nil {
 		return err
 	}
-	statsAddress := storkutil.HostWithPortURL(statsChannel.Address, statsChannel.Port)
+	statsAddress := storkutil.HostWithPortURL(statsChannel.Address, statsChannel.Port, statsChannel.UseSecureProtocol)
 	statsRequest := "json/v1/server"
 	statsURL := fmt.Sprintf("%s%s", statsAddress, statsRequest)
 
@@ -99,6 +110,30 @@ func (statsPuller *StatsPuller) getStatsFromApp(dbApp *dbmodel.App) error {
 			dbApp.Daemons[0].Bind9Daemon.Stats.CacheHitRatio = ratio
 			dbApp.Daemons[0].Bind9Daemon.Stats.CacheHits = hits
 			dbApp.Daemons[0].Bind9Daemon.Stats.CacheMisses = misses
+
+			// keep the history of the cache stats too
+			owner := dbmodel.StatisticOwner{
+				AppID:      dbApp.ID,
+				DaemonName: dbApp.Daemons[0].Name,
+			}
+			now := storkutil.UTCNow()
+			samples := []dbmodel.StatisticSample{}
+			for name, value := range map[string]float64{
+				dbmodel.StatisticCacheHits:     float64(hits),
+				dbmodel.StatisticCacheMisses:   float64(misses),
+				dbmodel.StatisticCacheHitRatio: ratio,
+			} {
+				samples = append(samples, dbmodel.StatisticSample{
+					StatisticOwner: owner,
+					Name:           name,
+					SampledAt:      now,
+					Value:          value,
+				})
+			}
+			err = dbmodel.AddStatisticSamples(statsPuller.Db, samples)
+			if err != nil {
+				return err
+			}
 			break
 		}
 	}
@@ -84,9 +84,10 @@ func TestStatsPullerPullStats(t *testing.T) {
 	}
 
 	machine1 := &dbmodel.Machine{
-		ID:        0,
-		Address:   "192.0.1.0",
-		AgentPort: 1111,
+		ID:         0,
+		Address:    "192.0.1.0",
+		AgentPort:  1111,
+		Authorized: true,
 	}
 	err = dbmodel.AddMachine(db, machine1)
 	require.NoError(t, err)
@@ -105,9 +106,10 @@ func TestStatsPullerPullStats(t *testing.T) {
 	daemon.ID = 0
 	daemon.Bind9Daemon.ID = 0
 	machine2 := &dbmodel.Machine{
-		ID:        0,
-		Address:   "192.0.2.0",
-		AgentPort: 2222,
+		ID:         0,
+		Address:    "192.0.2.0",
+		AgentPort:  2222,
+		Authorized: true,
 	}
 	err = dbmodel.AddMachine(db, machine2)
 	require.NoError(t, err)
@@ -192,9 +194,10 @@ func TestStatsPullerEmptyResponse(t *testing.T) {
 	}
 
 	machine := &dbmodel.Machine{
-		ID:        0,
-		Address:   "192.0.1.0",
-		AgentPort: 1111,
+		ID:         0,
+		Address:    "192.0.1.0",
+		AgentPort:  1111,
+		Authorized: true,
 	}
 	err = dbmodel.AddMachine(db, machine)
 	require.NoError(t, err)
@@ -240,3 +243,55 @@ func TestStatsPullerEmptyResponse(t *testing.T) {
 	require.EqualValues(t, 0, daemon.Bind9Daemon.Stats.CacheMisses)
 	require.EqualValues(t, 0, daemon.Bind9Daemon.Stats.CacheHitRatio)
 }
+
+// Check that the stats are not pulled from the app whose agent is down.
+func TestStatsPullerSkipUnavailableAgent(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := storktest.NewFakeAgents(nil, nil)
+	fa.UnavailableAgents = map[string]bool{"192.0.1.0:1111": true}
+
+	var accessPoints []*dbmodel.AccessPoint
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "127.0.0.1", "abcd", 953)
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointStatistics, "127.0.0.1", "abcd", 8000)
+
+	machine := &dbmodel.Machine{
+		Address:    "192.0.1.0",
+		AgentPort:  1111,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+	dbApp := dbmodel.App{
+		Type:         dbmodel.AppTypeBind9,
+		AccessPoints: accessPoints,
+		MachineID:    machine.ID,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:        "named",
+				Active:      true,
+				Bind9Daemon: &dbmodel.Bind9Daemon{},
+			},
+		},
+	}
+	err = CommitAppIntoDB(db, &dbApp)
+	require.NoError(t, err)
+
+	setting := dbmodel.Setting{
+		Name:    "bind9_stats_puller_interval",
+		ValType: dbmodel.SettingValTypeInt,
+		Value:   "60",
+	}
+	err = db.Insert(&setting)
+	require.NoError(t, err)
+
+	sp, err := NewStatsPuller(db, fa)
+	require.NoError(t, err)
+	defer sp.Shutdown()
+
+	appsOkCnt, err := sp.pullStats()
+	require.NoError(t, err)
+	require.Zero(t, appsOkCnt)
+	require.Empty(t, fa.RecordedStatsURL)
+}
@@ -2,14 +2,17 @@ package kea
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"isc.org/stork/server/agentcomm"
+	"isc.org/stork/server/configreview"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
 	storkutil "isc.org/stork/util"
 )
 
@@ -78,12 +81,64 @@ type VersionGetResponse struct {
 	Arguments *VersionGetRespArgs `json:"arguments,omitempty"`
 }
 
+// Forwards the commands to the Kea app via the Stork agent. The commands are
+// sent over HTTP to the Kea Control Agent or, if the app is the Kea daemon
+// running without the Kea Control Agent, directly to its control socket.
+func forwardToKea(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, commands []*agentcomm.KeaCommand, cmdResponses ...interface{}) (*agentcomm.KeaCmdsResult, error) {
+	ctrlPoint, err := dbApp.GetControlAccessPoint()
+	if err != nil {
+		return nil, err
+	}
+	if ctrlPoint.Type == dbmodel.AccessPointControlSocket {
+		return agents.ForwardToKeaOverUnixSocket(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, ctrlPoint.Address, commands, cmdResponses...)
+	}
+	caURL := storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port, ctrlPoint.UseSecureProtocol)
+	return agents.ForwardToKeaOverHTTP(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, caURL, commands, cmdResponses...)
+}
+
+// Get the name of the Kea daemon running without the Kea Control Agent.
+// The daemon is recognized by the top level key of its configuration,
+// e.g. Dhcp4, because it is the only one listening on the control socket.
+func getDaemonFromSocket(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App) (string, error) {
+	cmds := []*agentcomm.KeaCommand{
+		{
+			Command: "config-get",
+		},
+	}
+	configGetResp := []agentcomm.KeaResponse{}
+
+	cmdsResult, err := forwardToKea(ctx, agents, dbApp, cmds, &configGetResp)
+	if err != nil {
+		return "", err
+	}
+	if cmdsResult.Error != nil {
+		return "", cmdsResult.Error
+	}
+	if cmdsResult.CmdsErrors[0] != nil {
+		return "", cmdsResult.CmdsErrors[0]
+	}
+	if len(configGetResp) == 0 || configGetResp[0].Result != 0 || configGetResp[0].Arguments == nil {
+		return "", errors.New("problem with config-get sent to kea control socket")
+	}
+	for key := range *configGetResp[0].Arguments {
+		switch key {
+		case "Dhcp4":
+			return dhcp4, nil
+		case "Dhcp6":
+			return dhcp6, nil
+		case "DhcpDdns":
+			return d2, nil
+		}
+	}
+	return "", errors.New("unknown kea daemon configuration returned by config-get")
+}
+
 // Get state of Kea application Control Agent using ForwardToKeaOverHTTP function.
 // The state, that is stored into dbApp, includes: version and config of CA.
 // It also returns:
 // - list of all Kea daemons
 // - list of DHCP daemons (dhcpv4 and/or dhcpv6)
-func getStateFromCA(ctx context.Context, agents agentcomm.ConnectedAgents, caURL string, dbApp *dbmodel.App, daemonsMap map[string]*dbmodel.Daemon) (agentcomm.KeaDaemons, agentcomm.KeaDaemons, error) {
+func getStateFromCA(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, daemonsMap map[string]*dbmodel.Daemon) (agentcomm.KeaDaemons, agentcomm.KeaDaemons, error) {
 	// prepare the command to get config and version from CA
 	cmds := []*agentcomm.KeaCommand{
 		{
@@ -98,7 +153,7 @@ func getStateFromCA(ctx context.Context, agents agentcomm.ConnectedAgents, caURL
 	versionGetResp := []VersionGetResponse{}
 	caConfigGetResp := []CAConfigGetResponse{}
 
-	cmdsResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, caURL, cmds, &versionGetResp, &caConfigGetResp)
+	cmdsResult, err := forwardToKea(ctx, agents, dbApp, cmds, &versionGetResp, &caConfigGetResp)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -150,7 +205,7 @@ func getStateFromCA(ctx context.Context, agents agentcomm.ConnectedAgents, caURL
 
 // Get state of Kea application daemons (beside Control Agent) using ForwardToKeaOverHTTP function.
 // The state, that is stored into dbApp, includes: version, config and runtime state of indicated Kea daemons.
-func getStateFromDaemons(ctx context.Context, agents agentcomm.ConnectedAgents, caURL string, dbApp *dbmodel.App, daemonsMap map[string]*dbmodel.Daemon, allDaemons agentcomm.KeaDaemons, dhcpDaemons agentcomm.KeaDaemons) error {
+func getStateFromDaemons(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, daemonsMap map[string]*dbmodel.Daemon, allDaemons agentcomm.KeaDaemons, dhcpDaemons agentcomm.KeaDaemons) error {
 	now := storkutil.UTCNow()
 
 	// issue 3 commands to Kea daemons at once to get their state
@@ -173,7 +228,7 @@ func getStateFromDaemons(ctx context.Context, agents agentcomm.ConnectedAgents,
 	statusGetResp := []StatusGetResponse{}
 	configGetResp := []agentcomm.KeaResponse{}
 
-	cmdsResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, caURL, cmds, &versionGetResp, &statusGetResp, &configGetResp)
+	cmdsResult, err := forwardToKea(ctx, agents, dbApp, cmds, &versionGetResp, &statusGetResp, &configGetResp)
 	if err != nil {
 		return err
 	}
@@ -249,29 +304,45 @@ func getStateFromDaemons(ctx context.Context, agents agentcomm.ConnectedAgents,
 // Get state of Kea application daemons using ForwardToKeaOverHTTP function.
 // The state that is stored into dbApp includes: version, config and runtime state of indicated Kea daemons.
 func GetAppState(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App) {
-	// prepare URL to CA
-	ctrlPoint, err := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
+	ctrlPoint, err := dbApp.GetControlAccessPoint()
 	if err != nil {
 		log.Warnf("problem with getting kea access control point: %s", err)
 		return
 	}
-	caURL := storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port)
 
 	ctx2, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	// get state from CA
 	daemonsMap := map[string]*dbmodel.Daemon{}
-	allDaemons, dhcpDaemons, err := getStateFromCA(ctx2, agents, caURL, dbApp, daemonsMap)
-
-	// if not problems then now get state from the rest of Kea daemons
-	if err == nil {
-		err = getStateFromDaemons(ctx2, agents, caURL, dbApp, daemonsMap, allDaemons, dhcpDaemons)
+	if ctrlPoint.Type == dbmodel.AccessPointControlSocket {
+		// The Kea daemon running without CA is the only daemon of the app.
+		var daemon string
+		daemon, err = getDaemonFromSocket(ctx2, agents, dbApp)
+		if err == nil {
+			allDaemons := agentcomm.KeaDaemons{daemon: true}
+			dhcpDaemons := agentcomm.KeaDaemons{}
+			if daemon != d2 {
+				dhcpDaemons[daemon] = true
+			}
+			err = getStateFromDaemons(ctx2, agents, dbApp, daemonsMap, allDaemons, dhcpDaemons)
+		}
 		if err != nil {
-			log.Warnf("problem with getting state from kea daemons: %s", err)
+			log.Warnf("problem with getting state from kea daemon over %s: %s", ctrlPoint.Address, err)
 		}
 	} else {
-		log.Warnf("problem with getting state from kea CA: %s", err)
+		// get state from CA
+		var allDaemons, dhcpDaemons agentcomm.KeaDaemons
+		allDaemons, dhcpDaemons, err = getStateFromCA(ctx2, agents, dbApp, daemonsMap)
+
+		// if not problems then now get state from the rest of Kea daemons
+		if err == nil {
+			err = getStateFromDaemons(ctx2, agents, dbApp, daemonsMap, allDaemons, dhcpDaemons)
+			if err != nil {
+				log.Warnf("problem with getting state from kea daemons: %s", err)
+			}
+		} else {
+			log.Warnf("problem with getting state from kea CA: %s", err)
+		}
 	}
 
 	// store all collected details in app db record
@@ -353,7 +424,48 @@ func CommitAppIntoDB(db *dbops.PgDB, app *dbmodel.App) error {
 		}
 	}
 
+	// Store the new versions of the daemons' configurations. The changes
+	// are reported after the commit.
+	changed := map[*dbmodel.Daemon]*dbmodel.KeaConfigSnapshot{}
+	var added []*dbmodel.Daemon
+	for _, daemon := range app.Daemons {
+		if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil {
+			continue
+		}
+		snapshot, isNew, err := dbmodel.AddKeaConfigSnapshot(tx, app.ID, daemon.Name, daemon.KeaDaemon.Config)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			added = append(added, daemon)
+		}
+		// The first version is not a change.
+		if isNew && snapshot.Version > 1 {
+			changed[daemon] = snapshot
+		}
+	}
+
 	// Commit the changes if everything went fine.
 	err = commit()
-	return err
+	if err != nil {
+		return err
+	}
+
+	for _, daemon := range app.Daemons {
+		snapshot, ok := changed[daemon]
+		if !ok {
+			continue
+		}
+		eventcenter.AddInfoEvent(db, fmt.Sprintf("configuration of %s daemon of app %d changed, new version %d",
+			daemon.Name, app.ID, snapshot.Version), app, daemon)
+	}
+
+	// Review the new configurations.
+	for _, daemon := range added {
+		err = configreview.ReviewDaemon(db, app, daemon)
+		if err != nil {
+			log.Warnf("problem with reviewing configuration of %s daemon of Kea app %d: %s", daemon.Name, app.ID, err)
+		}
+	}
+	return nil
 }
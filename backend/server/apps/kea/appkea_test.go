@@ -182,6 +182,56 @@ func TestGetAppStateWith1Daemon(t *testing.T) {
 	require.Equal(t, "config-get", fa.RecordedCommands[1].Command)
 }
 
+// Check that the state of the Kea daemon running without the Kea Control
+// Agent is fetched over its control socket.
+func TestGetAppStateWithoutCA(t *testing.T) {
+	ctx := context.Background()
+
+	keaMock := func(callNo int, cmdResponses []interface{}) {
+		if callNo == 0 {
+			// config-get sent to recognize the daemon
+			list := cmdResponses[0].(*[]agentcomm.KeaResponse)
+			*list = []agentcomm.KeaResponse{
+				{
+					Arguments: &map[string]interface{}{
+						"Dhcp4": map[string]interface{}{},
+					},
+				},
+			}
+		} else if callNo == 1 {
+			mockGetConfigFromOtherDaemonsResponse(1, cmdResponses)
+		}
+	}
+	fa := storktest.NewFakeAgents(keaMock, nil)
+
+	dbApp := dbmodel.App{
+		AccessPoints: []*dbmodel.AccessPoint{
+			{
+				Type:    dbmodel.AccessPointControlSocket,
+				Address: "/run/kea/dhcp4.sock",
+			},
+		},
+		Machine: &dbmodel.Machine{
+			Address:   "192.0.2.0",
+			AgentPort: 1111,
+		},
+	}
+
+	GetAppState(ctx, fa, &dbApp)
+
+	require.Empty(t, fa.RecordedURL)
+	require.Equal(t, "/run/kea/dhcp4.sock", fa.RecordedSocket)
+	require.Len(t, fa.RecordedCommands, 4)
+	require.Equal(t, "config-get", fa.RecordedCommands[0].Command)
+	require.Nil(t, fa.RecordedCommands[0].Daemons)
+	require.Equal(t, "version-get", fa.RecordedCommands[1].Command)
+	require.Equal(t, []string{"dhcp4"}, fa.RecordedCommands[1].Daemons.List())
+
+	require.Len(t, dbApp.Daemons, 1)
+	require.Equal(t, "dhcp4", dbApp.Daemons[0].Name)
+	require.True(t, dbApp.Active)
+}
+
 func TestGetAppStateWith2Daemons(t *testing.T) {
 	ctx := context.Background()
 
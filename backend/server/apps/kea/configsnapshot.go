@@ -0,0 +1,106 @@
+package kea
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Types of the changes between two versions of the configuration.
+const (
+	ConfigChangeAdd     = "add"
+	ConfigChangeRemove  = "remove"
+	ConfigChangeReplace = "replace"
+)
+
+// Single difference between two versions of the configuration. The path
+// is a JSON pointer to the changed value, e.g. /Dhcp4/subnet4/0/pools.
+// The old value is not set for the added values and the new value is
+// not set for the removed values.
+type ConfigChange struct {
+	Path     string
+	Op       string
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// Escapes the key of the map so it can be used in the JSON pointer.
+func escapeConfigPathKey(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// Appends the differences between the two values to the changes. The maps
+// are compared key by key and the lists are compared element by element,
+// so the element inserted in the middle of the list shows up as replacing
+// all subsequent elements.
+func diffConfigValues(path string, from, to interface{}, changes *[]ConfigChange) {
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range fromValue {
+			keys = append(keys, key)
+		}
+		for key := range toValue {
+			if _, ok := fromValue[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := path + "/" + escapeConfigPathKey(key)
+			fromElem, fromOk := fromValue[key]
+			toElem, toOk := toValue[key]
+			switch {
+			case !fromOk:
+				*changes = append(*changes, ConfigChange{Path: keyPath, Op: ConfigChangeAdd, NewValue: toElem})
+			case !toOk:
+				*changes = append(*changes, ConfigChange{Path: keyPath, Op: ConfigChangeRemove, OldValue: fromElem})
+			default:
+				diffConfigValues(keyPath, fromElem, toElem, changes)
+			}
+		}
+		return
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(fromValue) || i < len(toValue); i++ {
+			elemPath := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(fromValue):
+				*changes = append(*changes, ConfigChange{Path: elemPath, Op: ConfigChangeAdd, NewValue: toValue[i]})
+			case i >= len(toValue):
+				*changes = append(*changes, ConfigChange{Path: elemPath, Op: ConfigChangeRemove, OldValue: fromValue[i]})
+			default:
+				diffConfigValues(elemPath, fromValue[i], toValue[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, ConfigChange{Path: path, Op: ConfigChangeReplace, OldValue: from, NewValue: to})
+	}
+}
+
+// Returns the differences between two versions of the daemon's
+// configuration ordered by the path.
+func DiffConfigs(from, to *dbmodel.KeaConfig) []ConfigChange {
+	var fromValue, toValue map[string]interface{}
+	if from != nil {
+		fromValue = *from
+	}
+	if to != nil {
+		toValue = *to
+	}
+	changes := []ConfigChange{}
+	diffConfigValues("", fromValue, toValue, &changes)
+	return changes
+}
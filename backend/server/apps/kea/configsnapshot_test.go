@@ -0,0 +1,153 @@
+package kea
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the differences between the configurations are found.
+func TestDiffConfigs(t *testing.T) {
+	from, err := dbmodel.NewKeaConfigFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 4000,
+            "interfaces-config": {
+                "interfaces": [ "eth0", "eth1" ]
+            },
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24"
+                }
+            ],
+            "a/b": 1
+        }
+    }`)
+	require.NoError(t, err)
+	to, err := dbmodel.NewKeaConfigFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 5000,
+            "interfaces-config": {
+                "interfaces": [ "eth0" ]
+            },
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "pools": [ { "pool": "192.0.2.10-192.0.2.20" } ]
+                },
+                {
+                    "id": 2,
+                    "subnet": "192.0.3.0/24"
+                }
+            ],
+            "a/b": "1"
+        }
+    }`)
+	require.NoError(t, err)
+
+	changes := DiffConfigs(from, to)
+	require.Len(t, changes, 5)
+
+	require.Equal(t, "/Dhcp4/a~1b", changes[0].Path)
+	require.Equal(t, ConfigChangeReplace, changes[0].Op)
+	require.EqualValues(t, 1, changes[0].OldValue)
+	require.Equal(t, "1", changes[0].NewValue)
+
+	require.Equal(t, "/Dhcp4/interfaces-config/interfaces/1", changes[1].Path)
+	require.Equal(t, ConfigChangeRemove, changes[1].Op)
+	require.Equal(t, "eth1", changes[1].OldValue)
+	require.Nil(t, changes[1].NewValue)
+
+	require.Equal(t, "/Dhcp4/subnet4/0/pools", changes[2].Path)
+	require.Equal(t, ConfigChangeAdd, changes[2].Op)
+	require.Nil(t, changes[2].OldValue)
+	require.Len(t, changes[2].NewValue, 1)
+
+	require.Equal(t, "/Dhcp4/subnet4/1", changes[3].Path)
+	require.Equal(t, ConfigChangeAdd, changes[3].Op)
+
+	require.Equal(t, "/Dhcp4/valid-lifetime", changes[4].Path)
+	require.Equal(t, ConfigChangeReplace, changes[4].Op)
+	require.EqualValues(t, 4000, changes[4].OldValue)
+	require.EqualValues(t, 5000, changes[4].NewValue)
+
+	// No changes.
+	require.Empty(t, DiffConfigs(from, from))
+
+	// Everything is added to the empty configuration.
+	changes = DiffConfigs(nil, to)
+	require.Len(t, changes, 1)
+	require.Equal(t, "/Dhcp4", changes[0].Path)
+	require.Equal(t, ConfigChangeAdd, changes[0].Op)
+}
+
+// Test that the new versions of the daemon's configuration are stored
+// when the app is committed into the database and the change is reported
+// as an event.
+func TestCommitAppIntoDBConfigSnapshots(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	config, err := dbmodel.NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 4000}}`)
+	require.NoError(t, err)
+
+	var accessPoints []*dbmodel.AccessPoint
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "", "", 1234)
+	app := &dbmodel.App{
+		MachineID:    machine.ID,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config: config,
+				},
+			},
+		},
+	}
+	err = CommitAppIntoDB(db, app)
+	require.NoError(t, err)
+
+	// The configuration hasn't changed.
+	err = CommitAppIntoDB(db, app)
+	require.NoError(t, err)
+
+	snapshots, err := dbmodel.GetKeaConfigSnapshots(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+
+	_, total, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, 0, dbmodel.SortDirAny)
+	require.NoError(t, err)
+	require.Zero(t, total)
+
+	config, err = dbmodel.NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 5000}}`)
+	require.NoError(t, err)
+	app.Daemons[0].KeaDaemon.Config = config
+	err = CommitAppIntoDB(db, app)
+	require.NoError(t, err)
+
+	snapshots, err = dbmodel.GetKeaConfigSnapshots(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.EqualValues(t, 2, snapshots[0].Version)
+
+	events, total, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, 0, dbmodel.SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, fmt.Sprintf("configuration of dhcp4 daemon of app %d changed, new version 2", app.ID), events[0].Text)
+}
@@ -0,0 +1,112 @@
+package kea
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Period of the utilization history the forecasts are based on.
+const forecastHistoryPeriod = 7 * 24 * time.Hour
+
+// The exhaustion is not forecast further than this into the future,
+// because the trend is unlikely to last that long.
+const forecastHorizon = 365 * 24 * time.Hour
+
+// Minimal number of samples and minimal period covered by them required
+// to make a forecast. The forecast made from a few recent samples would
+// follow the short-term fluctuations rather than the trend.
+const (
+	forecastMinSamples = 3
+	forecastMinPeriod  = time.Hour
+)
+
+// Forecasts when the utilization reaches 100% by fitting a line to the
+// utilization samples with the least squares method. It returns nil if
+// there are not enough samples, the utilization isn't growing or it won't
+// reach 100% within the forecast horizon. If the fitted line reaches 100%
+// before the last sample, the time of the last sample is returned. The
+// samples must be ordered by time.
+func forecastExhaustion(samples []dbmodel.StatisticSample) *time.Time {
+	if len(samples) < forecastMinSamples {
+		return nil
+	}
+	first := samples[0].SampledAt
+	last := samples[len(samples)-1].SampledAt
+	if last.Sub(first) < forecastMinPeriod {
+		return nil
+	}
+
+	// The time is expressed in hours since the first sample.
+	n := float64(len(samples))
+	meanX := 0.0
+	meanY := 0.0
+	for _, sample := range samples {
+		meanX += sample.SampledAt.Sub(first).Hours()
+		meanY += sample.Value
+	}
+	meanX /= n
+	meanY /= n
+	covariance := 0.0
+	variance := 0.0
+	for _, sample := range samples {
+		dx := sample.SampledAt.Sub(first).Hours() - meanX
+		covariance += dx * (sample.Value - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return nil
+	}
+	slope := covariance / variance
+	if slope <= 0 {
+		return nil
+	}
+	intercept := meanY - slope*meanX
+
+	hours := (100 - intercept) / slope
+	if hours > (last.Sub(first) + forecastHorizon).Hours() {
+		return nil
+	}
+	exhaustedAt := first.Add(time.Duration(hours * float64(time.Hour)))
+	if exhaustedAt.Before(last) {
+		exhaustedAt = last
+	}
+	return &exhaustedAt
+}
+
+// Forecasts when the addresses in the given subnets and their shared
+// networks run out and stores the forecasts in the database. The forecasts
+// are based on the hourly averages of the utilization, so each hour of the
+// history has the same weight regardless of the number of samples.
+func (statsPuller *StatsPuller) updateAddrExhaustion(subnets []*dbmodel.Subnet, now time.Time) error {
+	series, err := dbmodel.GetHourlyStatisticSeriesByOwner(statsPuller.Db, dbmodel.StatisticAddrUtilization,
+		now.Add(-forecastHistoryPeriod), now)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	sharedNetworks := make(map[int64]bool)
+	for _, sn := range subnets {
+		exhaustedAt := forecastExhaustion(series[dbmodel.StatisticOwner{SubnetID: sn.ID}])
+		err = sn.UpdateAddrExhaustion(statsPuller.Db, exhaustedAt)
+		if err != nil {
+			log.Errorf("cannot update addresses exhaustion forecast in subnet %d: %s", sn.ID, err)
+			lastErr = err
+		}
+		if sn.SharedNetworkID != 0 {
+			sharedNetworks[sn.SharedNetworkID] = true
+		}
+	}
+	for sharedNetworkID := range sharedNetworks {
+		exhaustedAt := forecastExhaustion(series[dbmodel.StatisticOwner{SharedNetworkID: sharedNetworkID}])
+		err = dbmodel.UpdateAddrExhaustionInSharedNetwork(statsPuller.Db, sharedNetworkID, exhaustedAt)
+		if err != nil {
+			log.Errorf("cannot update addresses exhaustion forecast in shared network %d: %s", sharedNetworkID, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
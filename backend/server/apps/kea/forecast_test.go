@@ -0,0 +1,64 @@
+package kea
+
+import (
+	"testing"
+	"time"
+
+	require "github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Returns the samples taken every hour, starting from the given time.
+func makeForecastTestSamples(start time.Time, values ...float64) []dbmodel.StatisticSample {
+	samples := []dbmodel.StatisticSample{}
+	for i, value := range values {
+		samples = append(samples, dbmodel.StatisticSample{
+			SampledAt: start.Add(time.Duration(i) * time.Hour),
+			Value:     value,
+		})
+	}
+	return samples
+}
+
+// Test that the exhaustion is forecast from the growing utilization.
+func TestForecastExhaustion(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// The utilization grows by 10% per hour, so it reaches 100% after
+	// 10 hours.
+	exhaustedAt := forecastExhaustion(makeForecastTestSamples(start, 0, 10, 20, 30))
+	require.NotNil(t, exhaustedAt)
+	require.Equal(t, start.Add(10*time.Hour), *exhaustedAt)
+
+	// The fluctuations are smoothed out.
+	exhaustedAt = forecastExhaustion(makeForecastTestSamples(start, 1, 9, 21, 29))
+	require.NotNil(t, exhaustedAt)
+	require.WithinDuration(t, start.Add(10*time.Hour), *exhaustedAt, 30*time.Minute)
+
+	// The trend has already reached 100%.
+	exhaustedAt = forecastExhaustion(makeForecastTestSamples(start, 80, 100, 120))
+	require.NotNil(t, exhaustedAt)
+	require.Equal(t, start.Add(2*time.Hour), *exhaustedAt)
+}
+
+// Test that the exhaustion is not forecast when the utilization isn't
+// growing or there is too little history.
+func TestForecastExhaustionNone(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Nil(t, forecastExhaustion(nil))
+	require.Nil(t, forecastExhaustion(makeForecastTestSamples(start, 10, 20)))
+	require.Nil(t, forecastExhaustion(makeForecastTestSamples(start, 30, 30, 30)))
+	require.Nil(t, forecastExhaustion(makeForecastTestSamples(start, 30, 20, 10)))
+
+	// The samples cover too short period.
+	samples := makeForecastTestSamples(start, 10, 20, 30)
+	for i := range samples {
+		samples[i].SampledAt = start.Add(time.Duration(i) * time.Minute)
+	}
+	require.Nil(t, forecastExhaustion(samples))
+
+	// The utilization grows too slowly to reach 100% within the horizon.
+	require.Nil(t, forecastExhaustion(makeForecastTestSamples(start, 10, 10.001, 10.002)))
+}
@@ -0,0 +1,228 @@
+package kea
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Default values of the HA timers and limits used by Kea when they are
+// not specified in the configuration. They are used to avoid reporting
+// a mismatch when one of the servers specifies the default value
+// explicitly and the other server relies on the default.
+const (
+	defaultHAHeartbeatDelay    = 10000
+	defaultHAMaxResponseDelay  = 60000
+	defaultHAMaxAckDelay       = 10000
+	defaultHAMaxUnackedClients = 10
+)
+
+// HA configuration of the daemon belonging to the HA service along with
+// the label identifying the daemon in the reported mismatches.
+type haMember struct {
+	label   string
+	config  dbmodel.KeaConfigHA
+	subnets []dbmodel.KeaConfigSubnet
+}
+
+// Returns the value of the HA parameter or the default value if the
+// parameter is not set.
+func getHAParameter(value *int, defaultValue int) int {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
+
+// Returns the prefix in the canonical form, so as the prefixes specified
+// differently in the configurations of the servers can be compared.
+func getCanonicalPrefix(prefix string) string {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return prefix
+	}
+	return network.String()
+}
+
+// Returns the sorted list of the address and prefix delegation pools of
+// the subnet in the canonical form.
+func getCanonicalPools(subnet *dbmodel.KeaConfigSubnet) []string {
+	var pools []string
+	for _, pool := range subnet.Pools {
+		addressPool, err := dbmodel.NewAddressPoolFromRange(pool.Pool)
+		if err != nil {
+			pools = append(pools, strings.TrimSpace(pool.Pool))
+			continue
+		}
+		pools = append(pools, fmt.Sprintf("%s-%s", net.ParseIP(addressPool.LowerBound), net.ParseIP(addressPool.UpperBound)))
+	}
+	for _, pool := range subnet.PdPools {
+		pools = append(pools, fmt.Sprintf("%s delegated /%d",
+			getCanonicalPrefix(fmt.Sprintf("%s/%d", pool.Prefix, pool.PrefixLen)), pool.DelegatedLen))
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+// Compares the peers configured on the two servers. All servers belonging
+// to the HA service must have the same list of peers with the same URLs
+// and roles.
+func compareHAPeers(first, second *haMember) (mismatches []string) {
+	findPeer := func(member *haMember, name string) *dbmodel.Peer {
+		for i := range member.config.Peers {
+			if *member.config.Peers[i].Name == name {
+				return &member.config.Peers[i]
+			}
+		}
+		return nil
+	}
+	for _, peer := range first.config.Peers {
+		other := findPeer(second, *peer.Name)
+		if other == nil {
+			mismatches = append(mismatches, fmt.Sprintf("peer %s is configured on %s but not on %s",
+				*peer.Name, first.label, second.label))
+			continue
+		}
+		if *peer.URL != *other.URL {
+			mismatches = append(mismatches, fmt.Sprintf("peer %s has URL %s on %s and URL %s on %s",
+				*peer.Name, *peer.URL, first.label, *other.URL, second.label))
+		}
+		if *peer.Role != *other.Role {
+			mismatches = append(mismatches, fmt.Sprintf("peer %s has role %s on %s and role %s on %s",
+				*peer.Name, *peer.Role, first.label, *other.Role, second.label))
+		}
+	}
+	for _, peer := range second.config.Peers {
+		if findPeer(first, *peer.Name) == nil {
+			mismatches = append(mismatches, fmt.Sprintf("peer %s is configured on %s but not on %s",
+				*peer.Name, second.label, first.label))
+		}
+	}
+	return mismatches
+}
+
+// Compares the HA timers and limits configured on the two servers. The
+// servers use them to detect the failure of the partner, so different
+// values cause the servers to disagree about the partner's state.
+func compareHAParameters(first, second *haMember) (mismatches []string) {
+	parameters := []struct {
+		name          string
+		first, second *int
+		defaultValue  int
+	}{
+		{"heartbeat-delay", first.config.HeartbeatDelay, second.config.HeartbeatDelay, defaultHAHeartbeatDelay},
+		{"max-response-delay", first.config.MaxResponseDelay, second.config.MaxResponseDelay, defaultHAMaxResponseDelay},
+		{"max-ack-delay", first.config.MaxAckDelay, second.config.MaxAckDelay, defaultHAMaxAckDelay},
+		{"max-unacked-clients", first.config.MaxUnackedClients, second.config.MaxUnackedClients, defaultHAMaxUnackedClients},
+	}
+	for _, p := range parameters {
+		firstValue := getHAParameter(p.first, p.defaultValue)
+		secondValue := getHAParameter(p.second, p.defaultValue)
+		if firstValue != secondValue {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %d on %s and %d on %s",
+				p.name, firstValue, first.label, secondValue, second.label))
+		}
+	}
+	return mismatches
+}
+
+// Compares the subnets served by the two servers. The servers must serve
+// the same subnets with the same IDs and pools, so as the partner can take
+// over the clients of the failed server and the lease updates sent between
+// the servers refer to the same subnets.
+func compareHASubnets(first, second *haMember) (mismatches []string) {
+	secondSubnets := make(map[string]*dbmodel.KeaConfigSubnet)
+	for i := range second.subnets {
+		secondSubnets[getCanonicalPrefix(second.subnets[i].Subnet)] = &second.subnets[i]
+	}
+	firstPrefixes := make(map[string]bool)
+	for i := range first.subnets {
+		subnet := &first.subnets[i]
+		prefix := getCanonicalPrefix(subnet.Subnet)
+		firstPrefixes[prefix] = true
+		other, ok := secondSubnets[prefix]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("subnet %s is served by %s but not by %s",
+				prefix, first.label, second.label))
+			continue
+		}
+		if subnet.ID != other.ID {
+			mismatches = append(mismatches, fmt.Sprintf("subnet %s has id %d on %s and id %d on %s",
+				prefix, subnet.ID, first.label, other.ID, second.label))
+		}
+		firstPools := getCanonicalPools(subnet)
+		secondPools := getCanonicalPools(other)
+		if strings.Join(firstPools, ",") != strings.Join(secondPools, ",") {
+			mismatches = append(mismatches, fmt.Sprintf("subnet %s has pools [%s] on %s and pools [%s] on %s",
+				prefix, strings.Join(firstPools, ", "), first.label, strings.Join(secondPools, ", "), second.label))
+		}
+	}
+	for i := range second.subnets {
+		prefix := getCanonicalPrefix(second.subnets[i].Subnet)
+		if !firstPrefixes[prefix] {
+			mismatches = append(mismatches, fmt.Sprintf("subnet %s is served by %s but not by %s",
+				prefix, second.label, first.label))
+		}
+	}
+	return mismatches
+}
+
+// Compares the configurations of the daemons belonging to the HA service
+// and returns the descriptions of the mismatches which prevent the servers
+// from cooperating, e.g. from taking over the clients of the failed partner.
+// The configuration of each daemon is compared with the configuration of
+// the daemon having the lowest HA server name. The daemons are identified
+// in the descriptions by their HA server names. The empty list is returned when
+// the configurations are consistent or when the service is not an HA
+// service.
+func CheckHAServiceConsistency(service *dbmodel.Service) (mismatches []string) {
+	if service.HAService == nil {
+		return mismatches
+	}
+
+	var members []*haMember
+	for _, daemon := range service.Daemons {
+		if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil {
+			continue
+		}
+		label := fmt.Sprintf("%s daemon of app %d", daemon.Name, daemon.AppID)
+		_, config, ok := daemon.KeaDaemon.Config.GetHAHooksLibrary()
+		if !ok || !config.IsSet() {
+			mismatches = append(mismatches, fmt.Sprintf("%s has no valid HA configuration", label))
+			continue
+		}
+		label = fmt.Sprintf("server %s", *config.ThisServerName)
+		for _, member := range members {
+			if member.label == label {
+				mismatches = append(mismatches, fmt.Sprintf("%s is configured on more than one daemon", label))
+				label = fmt.Sprintf("%s (%s daemon of app %d)", label, daemon.Name, daemon.AppID)
+				break
+			}
+		}
+		members = append(members, &haMember{
+			label:   label,
+			config:  config,
+			subnets: daemon.KeaDaemon.Config.GetSubnets(),
+		})
+	}
+
+	// Order the servers by name to report the mismatches consistently
+	// regardless of the order in which the daemons were fetched.
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].label < members[j].label
+	})
+	for i := 1; i < len(members); i++ {
+		first, second := members[0], members[i]
+		if *first.config.Mode != *second.config.Mode {
+			mismatches = append(mismatches, fmt.Sprintf("HA mode is %s on %s and %s on %s",
+				*first.config.Mode, first.label, *second.config.Mode, second.label))
+		}
+		mismatches = append(mismatches, compareHAPeers(first, second)...)
+		mismatches = append(mismatches, compareHAParameters(first, second)...)
+		mismatches = append(mismatches, compareHASubnets(first, second)...)
+	}
+	return mismatches
+}
@@ -0,0 +1,176 @@
+package kea
+
+import (
+	"fmt"
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Returns the daemon with the DHCPv4 server configuration including
+// the HA configuration of the load-balancing pair and the given subnets.
+// The heartbeat delay of 0 means that the parameter is not specified.
+func getHAConsistencyTestDaemon(t *testing.T, appID int64, thisServerName string, heartbeatDelay int, subnets string) *dbmodel.Daemon {
+	heartbeat := ""
+	if heartbeatDelay > 0 {
+		heartbeat = fmt.Sprintf(`"heartbeat-delay": %d,`, heartbeatDelay)
+	}
+	configStr := fmt.Sprintf(`{
+        "Dhcp4": {
+            "subnet4": [ %s ],
+            "hooks-libraries": [
+                {
+                    "library": "libdhcp_ha.so",
+                    "parameters": {
+                        "high-availability": [{
+                            "this-server-name": "%s",
+                            "mode": "load-balancing",
+                            %s
+                            "peers": [
+                                {
+                                    "name": "server1",
+                                    "url": "http://192.0.2.33:8000",
+                                    "role": "primary"
+                                },
+                                {
+                                    "name": "server2",
+                                    "url": "http://192.0.2.66:8000",
+                                    "role": "secondary"
+                                }
+                            ]
+                        }]
+                    }
+                }
+            ]
+        }
+    }`, subnets, thisServerName, heartbeat)
+
+	config, err := dbmodel.NewKeaConfigFromJSON(configStr)
+	require.NoError(t, err)
+
+	return &dbmodel.Daemon{
+		AppID: appID,
+		Name:  "dhcp4",
+		KeaDaemon: &dbmodel.KeaDaemon{
+			Config:        config,
+			KeaDHCPDaemon: &dbmodel.KeaDHCPDaemon{},
+		},
+	}
+}
+
+// Test that no mismatches are reported for the servers having consistent
+// configurations.
+func TestCheckHAServiceConsistencyNoMismatches(t *testing.T) {
+	subnets := `{
+        "id": 1,
+        "subnet": "192.0.2.0/24",
+        "pools": [ { "pool": "192.0.2.10-192.0.2.100" } ]
+    }`
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			Daemons: []*dbmodel.Daemon{
+				getHAConsistencyTestDaemon(t, 1, "server1", 0, subnets),
+				// The default heartbeat delay is specified explicitly and the
+				// subnet and the pool are specified in the other form.
+				getHAConsistencyTestDaemon(t, 2, "server2", 10000, `{
+                "id": 1,
+                "subnet": "192.0.2.1/24",
+                "pools": [ { "pool": "192.0.2.10 - 192.0.2.100" } ]
+            }`),
+			},
+		},
+		HAService: &dbmodel.BaseHAService{},
+	}
+	require.Empty(t, CheckHAServiceConsistency(service))
+
+	// The service which is not the HA service is not checked.
+	service.HAService = nil
+	service.Daemons[1] = getHAConsistencyTestDaemon(t, 2, "server2", 5000, "")
+	require.Empty(t, CheckHAServiceConsistency(service))
+}
+
+// Test that the mismatches between the configurations of the HA servers
+// are reported.
+func TestCheckHAServiceConsistencyMismatches(t *testing.T) {
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			Daemons: []*dbmodel.Daemon{
+				getHAConsistencyTestDaemon(t, 1, "server1", 0, `{
+                "id": 1,
+                "subnet": "192.0.2.0/24",
+                "pools": [ { "pool": "192.0.2.10-192.0.2.100" } ]
+            },
+            {
+                "id": 2,
+                "subnet": "192.0.3.0/24"
+            }`),
+				getHAConsistencyTestDaemon(t, 2, "server2", 5000, `{
+                "id": 3,
+                "subnet": "192.0.2.0/24",
+                "pools": [ { "pool": "192.0.2.10-192.0.2.50" } ]
+            },
+            {
+                "id": 4,
+                "subnet": "192.0.4.0/24"
+            }`),
+			},
+		},
+		HAService: &dbmodel.BaseHAService{},
+	}
+
+	// Modify the peers of the second server.
+	_, params, _ := service.Daemons[1].KeaDaemon.Config.GetHooksLibrary("libdhcp_ha")
+	ha := params["high-availability"].([]interface{})[0].(map[string]interface{})
+	ha["mode"] = "hot-standby"
+	peers := ha["peers"].([]interface{})
+	peers[1].(map[string]interface{})["url"] = "http://192.0.2.67:8000"
+	peers[1].(map[string]interface{})["role"] = "standby"
+	ha["peers"] = append(peers, map[string]interface{}{
+		"name": "server3",
+		"url":  "http://192.0.2.99:8000",
+		"role": "backup",
+	})
+
+	mismatches := CheckHAServiceConsistency(service)
+	require.ElementsMatch(t, []string{
+		"HA mode is load-balancing on server server1 and hot-standby on server server2",
+		"peer server2 has URL http://192.0.2.66:8000 on server server1 and URL http://192.0.2.67:8000 on server server2",
+		"peer server2 has role secondary on server server1 and role standby on server server2",
+		"peer server3 is configured on server server2 but not on server server1",
+		"heartbeat-delay is 10000 on server server1 and 5000 on server server2",
+		"subnet 192.0.2.0/24 has id 1 on server server1 and id 3 on server server2",
+		"subnet 192.0.2.0/24 has pools [192.0.2.10-192.0.2.100] on server server1 and pools [192.0.2.10-192.0.2.50] on server server2",
+		"subnet 192.0.3.0/24 is served by server server1 but not by server server2",
+		"subnet 192.0.4.0/24 is served by server server2 but not by server server1",
+	}, mismatches)
+}
+
+// Test that the servers lacking the HA configuration and the servers
+// using the same name are reported.
+func TestCheckHAServiceConsistencyInvalidMembers(t *testing.T) {
+	noHA, err := dbmodel.NewKeaConfigFromJSON(`{ "Dhcp4": { } }`)
+	require.NoError(t, err)
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			Daemons: []*dbmodel.Daemon{
+				getHAConsistencyTestDaemon(t, 1, "server1", 0, ""),
+				getHAConsistencyTestDaemon(t, 2, "server1", 0, ""),
+				{
+					AppID: 3,
+					Name:  "dhcp4",
+					KeaDaemon: &dbmodel.KeaDaemon{
+						Config: noHA,
+					},
+				},
+			},
+		},
+		HAService: &dbmodel.BaseHAService{},
+	}
+	mismatches := CheckHAServiceConsistency(service)
+	require.ElementsMatch(t, []string{
+		"server server1 is configured on more than one daemon",
+		"dhcp4 daemon of app 3 has no valid HA configuration",
+	}, mismatches)
+}
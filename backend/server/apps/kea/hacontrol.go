@@ -0,0 +1,304 @@
+package kea
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+)
+
+// Actions which can be run on the HA service.
+const (
+	HAActionMaintenanceStart  = "maintenance-start"
+	HAActionMaintenanceCancel = "maintenance-cancel"
+	HAActionContinue          = "continue"
+	HAActionSync              = "sync"
+	HAActionScopes            = "scopes"
+)
+
+// HA states of the servers during the maintenance.
+const (
+	HAStatusInMaintenance        = "in-maintenance"
+	HAStatusPartnerInMaintenance = "partner-in-maintenance"
+)
+
+// Maximum time to wait for the servers to reach the states expected
+// after the HA command and the interval between the status-get commands
+// sent while waiting. They are variables, so the tests can shorten them.
+var (
+	haActionWaitTimeout  = 30 * time.Second
+	haActionPollInterval = time.Second
+)
+
+// Action to be run on the HA service. The daemon ID designates the server
+// the action concerns: the server to be put into or taken out of the
+// maintenance, the server to be resumed, the server which synchronizes
+// the leases from its partner or the server which scopes are set.
+type HAAction struct {
+	Name     string
+	DaemonID int64
+	// Scopes to be served by the server, used by the scopes action.
+	Scopes []string
+	// Maximum time in seconds to wait for the leases from the partner,
+	// used by the sync action. Kea uses its default if it is zero.
+	MaxPeriod int64
+}
+
+// Server of the HA service taking part in the action along with the HA
+// command sent to it and the state it was in when the action completed.
+// The state is unavailable when the status of the server couldn't be
+// fetched.
+type HAActionServer struct {
+	App     *dbmodel.App
+	Daemon  *dbmodel.Daemon
+	Name    string
+	Command string
+	State   string
+	Scopes  []string
+}
+
+// Outcome of the action run on the HA service. The error is set when the
+// HA command failed or when the servers haven't reached the expected
+// states on time.
+type HAActionOutcome struct {
+	Servers []HAActionServer
+	Error   error
+}
+
+// Checks if the action can be run on the HA service. The action must
+// concern the primary or the secondary server. The maintenance and the
+// sync actions require the partner, so they are not supported in the
+// passive-backup mode.
+func ValidateHAAction(service *dbmodel.Service, action *HAAction) error {
+	if service.HAService == nil {
+		return errors.Errorf("service %d is not an HA service", service.ID)
+	}
+	switch action.Name {
+	case HAActionMaintenanceStart, HAActionMaintenanceCancel, HAActionSync:
+		if service.HAService.HAMode == "passive-backup" {
+			return errors.Errorf("%s action is not supported in the passive-backup mode", action.Name)
+		}
+	case HAActionContinue, HAActionScopes:
+	default:
+		return errors.Errorf("unsupported HA action %s", action.Name)
+	}
+	if action.DaemonID == 0 ||
+		(action.DaemonID != service.HAService.PrimaryID && action.DaemonID != service.HAService.SecondaryID) {
+		return errors.Errorf("daemon %d is not a primary or secondary server of HA service %d",
+			action.DaemonID, service.ID)
+	}
+	return nil
+}
+
+// Returns the primary and the secondary server of the HA service. The
+// daemons fetched with the service lack the machines, so the apps are
+// fetched again.
+func getHAActionServers(db *dbops.PgDB, service *dbmodel.Service) ([]HAActionServer, error) {
+	var servers []HAActionServer
+	for _, daemonID := range []int64{service.HAService.PrimaryID, service.HAService.SecondaryID} {
+		for _, sd := range service.Daemons {
+			if sd.ID != daemonID || daemonID == 0 {
+				continue
+			}
+			app, err := dbmodel.GetAppByID(db, sd.AppID)
+			if err != nil {
+				return nil, err
+			}
+			if app == nil {
+				return nil, errors.Errorf("app %d of daemon %d not found", sd.AppID, sd.ID)
+			}
+			for _, d := range app.Daemons {
+				if d.ID != sd.ID {
+					continue
+				}
+				server := HAActionServer{
+					App:    app,
+					Daemon: d,
+				}
+				if d.KeaDaemon != nil && d.KeaDaemon.Config != nil {
+					if _, config, ok := d.KeaDaemon.Config.GetHAHooksLibrary(); ok && config.ThisServerName != nil {
+						server.Name = *config.ThisServerName
+					}
+				}
+				servers = append(servers, server)
+				break
+			}
+		}
+	}
+	return servers, nil
+}
+
+// Fetches the HA states and scopes of the servers with the status-get
+// command.
+func refreshHAActionStates(agents agentcomm.ConnectedAgents, servers []HAActionServer) {
+	for i := range servers {
+		servers[i].State = HAStatusUnavailable
+		servers[i].Scopes = nil
+		status, err := getDHCPStatus(context.Background(), agents, servers[i].App)
+		if err != nil {
+			continue
+		}
+		for _, s := range status {
+			if s.Daemon != servers[i].Daemon.Name {
+				continue
+			}
+			// Kea supports one HA relationship per server.
+			var local *HALocalStatus
+			if len(s.HA) > 0 {
+				local = &s.HA[0].HAServers.Local
+			} else if s.HAServers != nil {
+				local = &s.HAServers.Local
+			}
+			if local != nil {
+				servers[i].State = local.State
+				servers[i].Scopes = local.Scopes
+			}
+		}
+	}
+}
+
+// Checks if the server serves exactly the given scopes.
+func haScopesEqual(scopes, expected []string) bool {
+	a := append([]string{}, scopes...)
+	b := append([]string{}, expected...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// Sends the status-get commands to the servers until the servers reach
+// the expected states or the time is up. The states are checked once
+// when the expected states are not specified.
+func waitForHAStates(agents agentcomm.ConnectedAgents, servers []HAActionServer, expected func(servers []HAActionServer) bool) error {
+	deadline := time.Now().Add(haActionWaitTimeout)
+	for {
+		refreshHAActionStates(agents, servers)
+		if expected == nil || expected(servers) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			var states []string
+			for _, server := range servers {
+				states = append(states, fmt.Sprintf("%s is %s", server.Name, server.State))
+			}
+			return errors.Errorf("servers haven't reached the expected states on time (%s)",
+				strings.Join(states, ", "))
+		}
+		time.Sleep(haActionPollInterval)
+	}
+}
+
+// Runs the action on the HA service. The HA commands are sent to the
+// servers, so as they perform the action in the right order:
+//
+// - maintenance-start sends ha-maintenance-start to the partner of the
+// server to be maintained, and waits until the partner is in the
+// partner-in-maintenance state and the server is in the in-maintenance
+// state, i.e. the server can be shut down,
+//
+// - maintenance-cancel sends ha-maintenance-cancel to the partner of the
+// server in maintenance and waits until both servers leave the maintenance
+// states,
+//
+// - continue sends ha-continue to the server which state machine is paused,
+//
+// - sync sends ha-sync to the server, so it fetches the leases from its
+// partner,
+//
+// - scopes sends ha-scopes to the server and waits until it serves the
+// given scopes.
+//
+// The outcome including the states of the servers is returned and
+// recorded as an event. The error is returned when the servers can't be
+// fetched from the database.
+func RunHAAction(db *dbops.PgDB, agents agentcomm.ConnectedAgents, service *dbmodel.Service, action *HAAction) (*HAActionOutcome, error) {
+	if err := ValidateHAAction(service, action); err != nil {
+		return nil, err
+	}
+	servers, err := getHAActionServers(db, service)
+	if err != nil {
+		return nil, err
+	}
+	var target, partner *HAActionServer
+	for i := range servers {
+		if servers[i].Daemon.ID == action.DaemonID {
+			target = &servers[i]
+		} else {
+			partner = &servers[i]
+		}
+	}
+	if target == nil {
+		return nil, errors.Errorf("daemon %d of HA service %d not found", action.DaemonID, service.ID)
+	}
+	if partner == nil && action.Name != HAActionContinue && action.Name != HAActionScopes {
+		return nil, errors.Errorf("partner of daemon %d in HA service %d not found", action.DaemonID, service.ID)
+	}
+
+	var (
+		recipient *HAActionServer
+		arguments map[string]interface{}
+		expected  func(servers []HAActionServer) bool
+	)
+	switch action.Name {
+	case HAActionMaintenanceStart:
+		recipient = partner
+		recipient.Command = "ha-maintenance-start"
+		expected = func([]HAActionServer) bool {
+			return partner.State == HAStatusPartnerInMaintenance && target.State == HAStatusInMaintenance
+		}
+	case HAActionMaintenanceCancel:
+		recipient = partner
+		recipient.Command = "ha-maintenance-cancel"
+		expected = func([]HAActionServer) bool {
+			return partner.State != HAStatusPartnerInMaintenance && partner.State != HAStatusUnavailable &&
+				target.State != HAStatusInMaintenance && target.State != HAStatusUnavailable
+		}
+	case HAActionContinue:
+		recipient = target
+		recipient.Command = "ha-continue"
+	case HAActionSync:
+		recipient = target
+		recipient.Command = "ha-sync"
+		arguments = map[string]interface{}{
+			"server-name": partner.Name,
+		}
+		if action.MaxPeriod > 0 {
+			arguments["max-period"] = action.MaxPeriod
+		}
+	case HAActionScopes:
+		recipient = target
+		recipient.Command = "ha-scopes"
+		arguments = map[string]interface{}{
+			"scopes": action.Scopes,
+		}
+		expected = func([]HAActionServer) bool {
+			return haScopesEqual(target.Scopes, action.Scopes)
+		}
+	}
+
+	outcome := &HAActionOutcome{}
+	outcome.Error = sendDaemonCommand(agents, recipient.App, recipient.Daemon.Name, recipient.Command, "ha", arguments)
+	if outcome.Error == nil {
+		outcome.Error = waitForHAStates(agents, servers, expected)
+	} else {
+		refreshHAActionStates(agents, servers)
+	}
+	outcome.Servers = servers
+
+	description := fmt.Sprintf("%s action on %s server in HA service %d", action.Name, target.Name, service.ID)
+	if outcome.Error != nil {
+		eventcenter.AddErrorEvent(db, fmt.Sprintf("%s failed: %s", description, outcome.Error),
+			target.App, target.Daemon)
+	} else {
+		eventcenter.AddInfoEvent(db, fmt.Sprintf("%s succeeded", description), target.App, target.Daemon)
+	}
+	return outcome, nil
+}
@@ -0,0 +1,306 @@
+package kea
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	require "github.com/stretchr/testify/require"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	storktest "isc.org/stork/server/test"
+)
+
+// Emulates the HA pair of the DHCPv4 servers. The servers are identified
+// by the URLs of their Control Agents. The HA commands change the states
+// and the scopes of the servers as Kea does, and the status-get command
+// returns them.
+type haControlTestServers struct {
+	fa     *storktest.FakeAgents
+	states map[string]string
+	scopes map[string][]string
+	// Makes the servers ignore the HA commands.
+	stuck bool
+}
+
+// Generates the responses to the commands sent to the emulated servers.
+func (s *haControlTestServers) mock(callNo int, cmdResponses []interface{}) {
+	url := s.fa.RecordedURL
+	command := s.fa.RecordedCommands[len(s.fa.RecordedCommands)-1]
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	request, _ := agentcomm.NewKeaCommand(command.Command, daemons, nil)
+
+	if command.Command == "status-get" {
+		scopes, _ := json.Marshal(s.scopes[url])
+		response := fmt.Sprintf(`[{
+            "result": 0,
+            "arguments": {
+                "high-availability": [{
+                    "ha-mode": "load-balancing",
+                    "ha-servers": {
+                        "local": {
+                            "role": "primary",
+                            "scopes": %s,
+                            "state": "%s"
+                        }
+                    }
+                }]
+            }
+        }]`, scopes, s.states[url])
+		_ = agentcomm.UnmarshalKeaResponseList(request, response, cmdResponses[0])
+		return
+	}
+
+	if !s.stuck {
+		for other := range s.states {
+			switch command.Command {
+			case "ha-maintenance-start":
+				if other == url {
+					s.states[other] = HAStatusPartnerInMaintenance
+				} else {
+					s.states[other] = HAStatusInMaintenance
+				}
+			case "ha-maintenance-cancel":
+				s.states[other] = HAStatusLoadBalancing
+			case "ha-scopes":
+				if other == url {
+					s.scopes[other] = (*command.Arguments)["scopes"].([]string)
+				}
+			}
+		}
+	}
+	response := `[{ "result": 0, "text": "done" }]`
+	_ = agentcomm.UnmarshalKeaResponseList(request, response, cmdResponses[0])
+}
+
+// Adds two Kea apps with the DHCPv4 servers belonging to the HA service
+// in the load-balancing mode.
+func addHAControlTestService(t *testing.T, db *dbops.PgDB) *dbmodel.Service {
+	var daemons []*dbmodel.Daemon
+	for i, name := range []string{"server1", "server2"} {
+		m := &dbmodel.Machine{
+			Address:   fmt.Sprintf("machine%d", i),
+			AgentPort: 8080,
+		}
+		err := dbmodel.AddMachine(db, m)
+		require.NoError(t, err)
+
+		accessPoints := []*dbmodel.AccessPoint{}
+		accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", int64(8001+i))
+		app := &dbmodel.App{
+			MachineID:    m.ID,
+			Type:         dbmodel.AppTypeKea,
+			Active:       true,
+			AccessPoints: accessPoints,
+			Daemons: []*dbmodel.Daemon{
+				{
+					Name:   "dhcp4",
+					Active: true,
+					KeaDaemon: &dbmodel.KeaDaemon{
+						Config:        getHATestConfig("Dhcp4", name, "load-balancing", "server1", "server2"),
+						KeaDHCPDaemon: &dbmodel.KeaDHCPDaemon{},
+					},
+				},
+			},
+		}
+		err = dbmodel.AddApp(db, app)
+		require.NoError(t, err)
+		daemons = append(daemons, app.Daemons[0])
+	}
+
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			ServiceType: "ha_dhcp",
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAType:      "dhcp4",
+			HAMode:      "load-balancing",
+			PrimaryID:   daemons[0].ID,
+			SecondaryID: daemons[1].ID,
+		},
+	}
+	err := dbmodel.AddService(db, service)
+	require.NoError(t, err)
+	for _, daemon := range daemons {
+		err = dbmodel.AddDaemonToService(db, service.ID, daemon)
+		require.NoError(t, err)
+	}
+	service, err = dbmodel.GetDetailedService(db, service.ID)
+	require.NoError(t, err)
+	return service
+}
+
+// Test that the HA actions are validated.
+func TestValidateHAAction(t *testing.T) {
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			ID: 1,
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAMode:      "load-balancing",
+			PrimaryID:   10,
+			SecondaryID: 11,
+		},
+	}
+	require.NoError(t, ValidateHAAction(service, &HAAction{Name: HAActionMaintenanceStart, DaemonID: 10}))
+	require.NoError(t, ValidateHAAction(service, &HAAction{Name: HAActionScopes, DaemonID: 11}))
+
+	// Unknown action.
+	require.Error(t, ValidateHAAction(service, &HAAction{Name: "failover", DaemonID: 10}))
+	// The daemon doesn't belong to the service.
+	require.Error(t, ValidateHAAction(service, &HAAction{Name: HAActionContinue, DaemonID: 12}))
+	require.Error(t, ValidateHAAction(service, &HAAction{Name: HAActionContinue}))
+
+	// No partner in the passive-backup mode.
+	service.HAService.HAMode = "passive-backup"
+	require.Error(t, ValidateHAAction(service, &HAAction{Name: HAActionSync, DaemonID: 10}))
+	require.NoError(t, ValidateHAAction(service, &HAAction{Name: HAActionContinue, DaemonID: 10}))
+
+	// Not an HA service.
+	service.HAService = nil
+	require.Error(t, ValidateHAAction(service, &HAAction{Name: HAActionContinue, DaemonID: 10}))
+}
+
+// Test that the maintenance is started and cancelled by sending the
+// commands to the partner of the maintained server and that the states
+// of the servers are awaited.
+func TestRunHAActionMaintenance(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	haActionPollInterval = time.Millisecond
+	defer func() {
+		haActionPollInterval = time.Second
+	}()
+
+	service := addHAControlTestService(t, db)
+	servers := &haControlTestServers{
+		states: map[string]string{
+			"http://localhost:8001/": HAStatusLoadBalancing,
+			"http://localhost:8002/": HAStatusLoadBalancing,
+		},
+		scopes: map[string][]string{},
+	}
+	servers.fa = storktest.NewFakeAgents(servers.mock, nil)
+
+	// Put the secondary server into the maintenance.
+	outcome, err := RunHAAction(db, servers.fa, service, &HAAction{
+		Name:     HAActionMaintenanceStart,
+		DaemonID: service.HAService.SecondaryID,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, outcome)
+	require.NoError(t, outcome.Error)
+
+	// The command is sent to the primary server.
+	require.Equal(t, "ha-maintenance-start", servers.fa.RecordedCommands[0].Command)
+	require.Len(t, outcome.Servers, 2)
+	require.Equal(t, "server1", outcome.Servers[0].Name)
+	require.Equal(t, "ha-maintenance-start", outcome.Servers[0].Command)
+	require.Equal(t, HAStatusPartnerInMaintenance, outcome.Servers[0].State)
+	require.Equal(t, "server2", outcome.Servers[1].Name)
+	require.Empty(t, outcome.Servers[1].Command)
+	require.Equal(t, HAStatusInMaintenance, outcome.Servers[1].State)
+
+	// Take the secondary server out of the maintenance.
+	outcome, err = RunHAAction(db, servers.fa, service, &HAAction{
+		Name:     HAActionMaintenanceCancel,
+		DaemonID: service.HAService.SecondaryID,
+	})
+	require.NoError(t, err)
+	require.NoError(t, outcome.Error)
+	require.Equal(t, "ha-maintenance-cancel", outcome.Servers[0].Command)
+	require.Equal(t, HAStatusLoadBalancing, outcome.Servers[0].State)
+	require.Equal(t, HAStatusLoadBalancing, outcome.Servers[1].State)
+}
+
+// Test that the error is reported when the servers don't reach the
+// expected states on time.
+func TestRunHAActionTimeout(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	haActionPollInterval = time.Millisecond
+	haActionWaitTimeout = 10 * time.Millisecond
+	defer func() {
+		haActionPollInterval = time.Second
+		haActionWaitTimeout = 30 * time.Second
+	}()
+
+	service := addHAControlTestService(t, db)
+	servers := &haControlTestServers{
+		states: map[string]string{
+			"http://localhost:8001/": HAStatusLoadBalancing,
+			"http://localhost:8002/": HAStatusLoadBalancing,
+		},
+		scopes: map[string][]string{},
+		stuck:  true,
+	}
+	servers.fa = storktest.NewFakeAgents(servers.mock, nil)
+
+	outcome, err := RunHAAction(db, servers.fa, service, &HAAction{
+		Name:     HAActionScopes,
+		DaemonID: service.HAService.PrimaryID,
+		Scopes:   []string{"server1", "server2"},
+	})
+	require.NoError(t, err)
+	require.Error(t, outcome.Error)
+	require.Contains(t, outcome.Error.Error(), "server1 is load-balancing")
+}
+
+// Test that the scopes are set and the leases are synchronized from the
+// partner.
+func TestRunHAActionScopesAndSync(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	haActionPollInterval = time.Millisecond
+	defer func() {
+		haActionPollInterval = time.Second
+	}()
+
+	service := addHAControlTestService(t, db)
+	servers := &haControlTestServers{
+		states: map[string]string{
+			"http://localhost:8001/": HAStatusPartnerDown,
+			"http://localhost:8002/": HAStatusUnavailable,
+		},
+		scopes: map[string][]string{
+			"http://localhost:8001/": {"server1"},
+		},
+	}
+	servers.fa = storktest.NewFakeAgents(servers.mock, nil)
+
+	outcome, err := RunHAAction(db, servers.fa, service, &HAAction{
+		Name:     HAActionScopes,
+		DaemonID: service.HAService.PrimaryID,
+		Scopes:   []string{"server1", "server2"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, outcome.Error)
+	require.Equal(t, []string{"server1", "server2"}, outcome.Servers[0].Scopes)
+
+	outcome, err = RunHAAction(db, servers.fa, service, &HAAction{
+		Name:      HAActionSync,
+		DaemonID:  service.HAService.SecondaryID,
+		MaxPeriod: 60,
+	})
+	require.NoError(t, err)
+	require.NoError(t, outcome.Error)
+	require.Equal(t, "ha-sync", outcome.Servers[1].Command)
+
+	// The server fetches the leases from its partner.
+	var command *agentcomm.KeaCommand
+	for i := range servers.fa.RecordedCommands {
+		if servers.fa.RecordedCommands[i].Command == "ha-sync" {
+			command = &servers.fa.RecordedCommands[i]
+		}
+	}
+	require.NotNil(t, command)
+	require.Equal(t, "server1", (*command.Arguments)["server-name"])
+	require.EqualValues(t, 60, (*command.Arguments)["max-period"])
+}
@@ -10,7 +10,6 @@ import (
 	"isc.org/stork/server/agentcomm"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
-	storkutil "isc.org/stork/util"
 )
 
 const (
@@ -66,7 +65,7 @@ func (puller *HostsPuller) Shutdown() {
 // Triggers fetch of the host reservations from the monitored Kea apps.
 func (puller *HostsPuller) pullData() (int, error) {
 	// Get the list of all Kea apps from the database.
-	apps, err := dbmodel.GetAppsByType(puller.Db, dbmodel.AppTypeKea)
+	apps, err := dbmodel.GetAuthorizedAppsByType(puller.Db, dbmodel.AppTypeKea)
 	if err != nil {
 		return 0, err
 	}
@@ -82,6 +81,10 @@ func (puller *HostsPuller) pullData() (int, error) {
 	var lastErr error
 	appsOkCnt := 0
 	for i := range apps {
+		if !puller.Agents.IsAgentAvailable(apps[i].Machine.Address, apps[i].Machine.AgentPort) {
+			log.Warnf("skipped fetching hosts from app %d because its agent is unreachable", apps[i].ID)
+			continue
+		}
 		err := updateHostsFromHostCmds(puller.Db, puller.Agents, &apps[i], seq)
 		if err != nil {
 			lastErr = err
@@ -134,7 +137,6 @@ type HostDetectionIterator struct {
 	family      int
 	from        int64
 	sourceIndex int64
-	url         string
 	subnets     []dbmodel.Subnet
 	subnetIndex int
 }
@@ -150,7 +152,6 @@ func NewHostDetectionIterator(db *dbops.PgDB, app *dbmodel.App, agents agentcomm
 		family:      0,
 		from:        0,
 		sourceIndex: 1,
-		url:         "",
 		subnets:     []dbmodel.Subnet{},
 		subnetIndex: -1,
 	}
@@ -230,8 +231,7 @@ func (iterator *HostDetectionIterator) sendReservationGetPage() (hosts []dbmodel
 	commands := []*agentcomm.KeaCommand{command}
 	response := make([]ReservationGetPageResponse, 1)
 	ctx := context.Background()
-	respResult, err := iterator.agents.ForwardToKeaOverHTTP(ctx, iterator.app.Machine.Address, iterator.app.Machine.AgentPort,
-		iterator.url, commands, &response)
+	respResult, err := forwardToKea(ctx, iterator.agents, iterator.app, commands, &response)
 	if err != nil {
 		// Can retry because the error may go away upon the next attempt.
 		return hosts, agentcomm.KeaResponseError, true, err
@@ -308,15 +308,10 @@ func (iterator *HostDetectionIterator) DetectHostsPageFromHostCmds() (hosts []db
 		return hosts, done, err
 	}
 
-	// During the first call to this function we have to initialize the URL of
-	// the Kea app we wicll be communicating with. We don't repeat this operation
-	// for subequent calls.
-	if len(iterator.url) == 0 {
-		ctrlPoint, err := iterator.app.GetAccessPoint(dbmodel.AccessPointControl)
-		if err != nil {
-			return hosts, done, errors.WithMessagef(err, "problem with getting Kea access points upon an attempt to detect host reservations over the host_cmds hooks library")
-		}
-		iterator.url = storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port)
+	// Make sure that the Kea app can be communicated with. There is no point
+	// to retry if it has no control access point.
+	if _, err = iterator.app.GetControlAccessPoint(); err != nil {
+		return hosts, done, errors.WithMessagef(err, "problem with getting Kea access points upon an attempt to detect host reservations over the host_cmds hooks library")
 	}
 
 	// Count the servers we have iterated over to make sure we use the one we used
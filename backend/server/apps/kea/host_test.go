@@ -1014,9 +1014,10 @@ func TestPullHostsIntoDB(t *testing.T) {
 	defer teardown()
 
 	m := &dbmodel.Machine{
-		ID:        0,
-		Address:   "localhost",
-		AgentPort: 8080,
+		ID:         0,
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
 	}
 	err := dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
@@ -1091,9 +1092,10 @@ func TestReduceHostsIntoDB(t *testing.T) {
 	defer teardown()
 
 	m := &dbmodel.Machine{
-		ID:        0,
-		Address:   "localhost",
-		AgentPort: 8080,
+		ID:         0,
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
 	}
 	err := dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
@@ -0,0 +1,603 @@
+package kea
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+)
+
+// Matches the identifiers, i.e. MAC address, client identifier or DUID,
+// specified as hexadecimal bytes separated with colons or dashes.
+var leaseIdentifierRegexp = regexp.MustCompile(`^([0-9a-fA-F]{1,2}[:-])+[0-9a-fA-F]{1,2}$`)
+
+// Structure reflecting a lease returned by the Kea lease_cmds hook library.
+// It holds both DHCPv4 and DHCPv6 lease fields. The remaining fields are
+// set by Stork and indicate where the lease was found.
+type Lease struct {
+	IPAddress         string `json:"ip-address"`
+	PrefixLength      int64  `json:"prefix-len"`
+	HWAddress         string `json:"hw-address"`
+	ClientID          string `json:"client-id"`
+	DUID              string `json:"duid"`
+	IAID              int64  `json:"iaid"`
+	Type              string `json:"type"`
+	Hostname          string `json:"hostname"`
+	SubnetID          int64  `json:"subnet-id"`
+	State             int64  `json:"state"`
+	CLTT              int64  `json:"cltt"`
+	ValidLifetime     int64  `json:"valid-lft"`
+	PreferredLifetime int64  `json:"preferred-lft"`
+	FqdnFwd           bool   `json:"fqdn-fwd"`
+	FqdnRev           bool   `json:"fqdn-rev"`
+
+	AppID    int64  `json:"-"`
+	DaemonID int64  `json:"-"`
+	Family   int    `json:"-"`
+	Daemon   string `json:"-"`
+}
+
+// Structure reflecting a Kea response to the lease4-get and lease6-get
+// commands.
+type LeaseGetResponse struct {
+	agentcomm.KeaResponseHeader
+	Arguments *Lease `json:"arguments,omitempty"`
+}
+
+// Structure reflecting arguments of the Kea response to the commands
+// returning multiple leases.
+type LeasesGetArgs struct {
+	Leases []Lease
+}
+
+// Structure reflecting a Kea response to the commands returning multiple
+// leases, e.g. lease4-get-by-hw-address.
+type LeasesGetResponse struct {
+	agentcomm.KeaResponseHeader
+	Arguments *LeasesGetArgs `json:"arguments,omitempty"`
+}
+
+// Describes a lease command to be sent to a DHCP daemon.
+type leaseCommand struct {
+	daemon    string
+	name      string
+	arguments map[string]interface{}
+}
+
+// Converts the identifier to the format accepted by Kea, i.e. lower case
+// hexadecimal bytes separated with colons. It returns the identifier and
+// the number of bytes in it.
+func normalizeLeaseIdentifier(text string) (string, int) {
+	bytes := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return r == ':' || r == '-'
+	})
+	for i, b := range bytes {
+		if len(b) == 1 {
+			bytes[i] = "0" + b
+		}
+	}
+	return strings.Join(bytes, ":"), len(bytes)
+}
+
+// Returns the lease commands to be sent to the DHCP servers to find the
+// leases matching the search text. An IP address is searched with
+// lease4-get or lease6-get. An IPv6 address may be an address or a
+// delegated prefix, so both types of the leases are searched. A prefix
+// specified with its length is only searched among the delegated
+// prefixes. A MAC address, client identifier or DUID
+// specified as hexadecimal bytes is searched with the commands looking
+// up the leases by identifiers. Any other text is considered a hostname.
+func getLeaseCommands(text string) (commands []leaseCommand) {
+	if ip := net.ParseIP(text); ip != nil {
+		if ip.To4() != nil {
+			return []leaseCommand{{
+				daemon: dbmodel.DaemonNameDHCPv4,
+				name:   "lease4-get",
+				arguments: map[string]interface{}{
+					"ip-address": text,
+				},
+			}}
+		}
+		for _, leaseType := range []string{"IA_NA", "IA_PD"} {
+			commands = append(commands, leaseCommand{
+				daemon: dbmodel.DaemonNameDHCPv6,
+				name:   "lease6-get",
+				arguments: map[string]interface{}{
+					"ip-address": text,
+					"type":       leaseType,
+				},
+			})
+		}
+		return commands
+	}
+
+	if ip, prefix, err := net.ParseCIDR(text); err == nil && ip.To4() == nil && ip.Equal(prefix.IP) {
+		return []leaseCommand{{
+			daemon: dbmodel.DaemonNameDHCPv6,
+			name:   "lease6-get",
+			arguments: map[string]interface{}{
+				"ip-address": ip.String(),
+				"type":       "IA_PD",
+			},
+		}}
+	}
+
+	if leaseIdentifierRegexp.MatchString(text) {
+		identifier, length := normalizeLeaseIdentifier(text)
+		// Only the MAC addresses are 6 bytes long.
+		if length == 6 {
+			commands = append(commands, leaseCommand{
+				daemon: dbmodel.DaemonNameDHCPv4,
+				name:   "lease4-get-by-hw-address",
+				arguments: map[string]interface{}{
+					"hw-address": identifier,
+				},
+			})
+		}
+		commands = append(commands, leaseCommand{
+			daemon: dbmodel.DaemonNameDHCPv4,
+			name:   "lease4-get-by-client-id",
+			arguments: map[string]interface{}{
+				"client-id": identifier,
+			},
+		}, leaseCommand{
+			daemon: dbmodel.DaemonNameDHCPv6,
+			name:   "lease6-get-by-duid",
+			arguments: map[string]interface{}{
+				"duid": identifier,
+			},
+		})
+		return commands
+	}
+
+	return []leaseCommand{
+		{
+			daemon: dbmodel.DaemonNameDHCPv4,
+			name:   "lease4-get-by-hostname",
+			arguments: map[string]interface{}{
+				"hostname": text,
+			},
+		},
+		{
+			daemon: dbmodel.DaemonNameDHCPv6,
+			name:   "lease6-get-by-hostname",
+			arguments: map[string]interface{}{
+				"hostname": text,
+			},
+		},
+	}
+}
+
+// Sends the lease commands to the active DHCP daemons of the app and
+// returns the leases found. The commands which are not supported by the
+// daemons, e.g. because the lease_cmds hook library is not loaded, return
+// no leases.
+func findAppLeases(agents agentcomm.ConnectedAgents, app *dbmodel.App, leaseCommands []leaseCommand) ([]Lease, error) {
+	// Only send the commands to the daemons which are running.
+	activeDaemons := app.GetActiveDHCPDaemonNames()
+	var (
+		commands  []*agentcomm.KeaCommand
+		sent      []leaseCommand
+		responses []interface{}
+	)
+	for _, lc := range leaseCommands {
+		active := false
+		for _, d := range activeDaemons {
+			if d == lc.daemon {
+				active = true
+				break
+			}
+		}
+		if !active {
+			continue
+		}
+		daemons, _ := agentcomm.NewKeaDaemons(lc.daemon)
+		arguments := lc.arguments
+		command, _ := agentcomm.NewKeaCommand(lc.name, daemons, &arguments)
+		commands = append(commands, command)
+		sent = append(sent, lc)
+		if lc.name == "lease4-get" || lc.name == "lease6-get" {
+			responses = append(responses, &[]LeaseGetResponse{})
+		} else {
+			responses = append(responses, &[]LeasesGetResponse{})
+		}
+	}
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	respResult, err := forwardToKea(ctx, agents, app, commands, responses...)
+	if err != nil {
+		return nil, err
+	}
+	if respResult.Error != nil {
+		return nil, respResult.Error
+	}
+
+	var leases []Lease
+	for i, lc := range sent {
+		if i < len(respResult.CmdsErrors) && respResult.CmdsErrors[i] != nil {
+			return nil, errors.WithMessagef(respResult.CmdsErrors[i], "problem with sending %s command", lc.name)
+		}
+		var (
+			header agentcomm.KeaResponseHeader
+			found  []Lease
+		)
+		switch response := responses[i].(type) {
+		case *[]LeaseGetResponse:
+			if len(*response) == 0 {
+				return nil, errors.Errorf("invalid response to %s command received", lc.name)
+			}
+			header = (*response)[0].KeaResponseHeader
+			if (*response)[0].Arguments != nil {
+				found = append(found, *(*response)[0].Arguments)
+			}
+		case *[]LeasesGetResponse:
+			if len(*response) == 0 {
+				return nil, errors.Errorf("invalid response to %s command received", lc.name)
+			}
+			header = (*response)[0].KeaResponseHeader
+			if (*response)[0].Arguments != nil {
+				found = (*response)[0].Arguments.Leases
+			}
+		}
+		switch header.Result {
+		case agentcomm.KeaResponseSuccess:
+		case agentcomm.KeaResponseEmpty, agentcomm.KeaResponseCommandUnsupported:
+			continue
+		default:
+			return nil, errors.Errorf("error returned by Kea in response to %s command: %s", lc.name, header.Text)
+		}
+		for _, lease := range found {
+			lease.AppID = app.ID
+			lease.Daemon = lc.daemon
+			lease.Family = 4
+			if lc.daemon == dbmodel.DaemonNameDHCPv6 {
+				lease.Family = 6
+			}
+			for _, d := range app.Daemons {
+				if d.Name == lc.daemon {
+					lease.DaemonID = d.ID
+					break
+				}
+			}
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+// Finds the leases matching the search text on all monitored Kea servers.
+// The text may be an IP address, a MAC address, a client identifier, a DUID
+// or a hostname. The same lease returned by the servers being HA peers is
+// only included once, i.e. the instance with the latest client last
+// transaction time is returned. The apps for which the search failed are
+// returned along with the leases found on the remaining apps.
+func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (leases []Lease, erredApps []*dbmodel.App, err error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return leases, erredApps, nil
+	}
+
+	apps, err := dbmodel.GetAuthorizedAppsByType(db, dbmodel.AppTypeKea)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(apps) == 0 {
+		return leases, erredApps, nil
+	}
+
+	leaseCommands := getLeaseCommands(text)
+	indexByKey := make(map[string]int)
+	for i := range apps {
+		app := &apps[i]
+		if !agents.IsAgentAvailable(app.Machine.Address, app.Machine.AgentPort) {
+			erredApps = append(erredApps, app)
+			continue
+		}
+		appLeases, err := findAppLeases(agents, app, leaseCommands)
+		if err != nil {
+			log.Warnf("problem with searching leases on app %d: %+v", app.ID, err)
+			erredApps = append(erredApps, app)
+			continue
+		}
+		// The same lease returned by the HA peers should only be shown
+		// once, so the leases are keyed by the HA service rather than
+		// the daemon if the daemon belongs to such service.
+		owners := make(map[int64]string)
+		for _, d := range app.Daemons {
+			owners[d.ID] = fmt.Sprintf("daemon:%d", d.ID)
+			for _, service := range d.Services {
+				if service.HAService != nil {
+					owners[d.ID] = fmt.Sprintf("service:%d", service.ID)
+					break
+				}
+			}
+		}
+		for _, lease := range appLeases {
+			owner := owners[lease.DaemonID]
+			key := fmt.Sprintf("%s/%d/%s", lease.IPAddress, lease.PrefixLength, owner)
+			if index, ok := indexByKey[key]; ok {
+				if lease.CLTT > leases[index].CLTT {
+					leases[index] = lease
+				}
+				continue
+			}
+			indexByKey[key] = len(leases)
+			leases = append(leases, lease)
+		}
+	}
+	return leases, erredApps, nil
+}
+
+// Outcome of the lease operation, e.g. lease deletion, performed on
+// one of the DHCP daemons.
+type LeaseOperationResult struct {
+	App    *dbmodel.App
+	Daemon *dbmodel.Daemon
+	Error  error
+}
+
+// Returns the lease arguments of the lease4-add and lease6-add commands.
+// The zero values are not included, so Kea uses its defaults for them.
+func (lease *Lease) getAddArguments(family int) map[string]interface{} {
+	arguments := map[string]interface{}{
+		"ip-address": lease.IPAddress,
+	}
+	if family == 4 {
+		arguments["hw-address"] = lease.HWAddress
+		if lease.ClientID != "" {
+			arguments["client-id"] = lease.ClientID
+		}
+	} else {
+		arguments["duid"] = lease.DUID
+		arguments["iaid"] = lease.IAID
+		if lease.Type != "" {
+			arguments["type"] = lease.Type
+		}
+		if lease.PrefixLength > 0 {
+			arguments["prefix-len"] = lease.PrefixLength
+		}
+		if lease.PreferredLifetime > 0 {
+			arguments["preferred-lft"] = lease.PreferredLifetime
+		}
+	}
+	if lease.SubnetID > 0 {
+		arguments["subnet-id"] = lease.SubnetID
+	}
+	if lease.ValidLifetime > 0 {
+		arguments["valid-lft"] = lease.ValidLifetime
+	}
+	if lease.Hostname != "" {
+		arguments["hostname"] = lease.Hostname
+		arguments["fqdn-fwd"] = lease.FqdnFwd
+		arguments["fqdn-rev"] = lease.FqdnRev
+	}
+	return arguments
+}
+
+// Returns the family of the DHCP daemon with the given name.
+func getDaemonFamily(daemonName string) int {
+	if daemonName == dbmodel.DaemonNameDHCPv6 {
+		return 6
+	}
+	return 4
+}
+
+// Returns the daemon with the given name and the daemons being its peers
+// in the HA services. The lease operations are performed on all of them,
+// so the HA peers hold the same leases.
+func getLeaseTargets(db *dbops.PgDB, app *dbmodel.App, daemonName string) ([]LeaseOperationResult, error) {
+	var daemon *dbmodel.Daemon
+	for _, d := range app.Daemons {
+		if d.Name == daemonName {
+			daemon = d
+			break
+		}
+	}
+	if daemon == nil {
+		return nil, errors.Errorf("%s daemon not found in app %d", daemonName, app.ID)
+	}
+	targets := []LeaseOperationResult{{App: app, Daemon: daemon}}
+
+	services, err := dbmodel.GetDetailedServicesByAppID(db, app.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		if service.HAService == nil {
+			continue
+		}
+		member := false
+		for _, d := range service.Daemons {
+			if d.ID == daemon.ID {
+				member = true
+				break
+			}
+		}
+		if !member {
+			continue
+		}
+		for _, peer := range service.Daemons {
+			duplicate := false
+			for _, target := range targets {
+				if target.Daemon.ID == peer.ID {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				continue
+			}
+			// The daemons fetched with the service lack the machines,
+			// so the apps are fetched again.
+			peerApp, err := dbmodel.GetAppByID(db, peer.AppID)
+			if err != nil {
+				return nil, err
+			}
+			if peerApp == nil {
+				continue
+			}
+			for _, d := range peerApp.Daemons {
+				if d.ID == peer.ID {
+					targets = append(targets, LeaseOperationResult{App: peerApp, Daemon: d})
+					break
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Sends the command implemented by the given hooks library to the daemon.
+// The arguments may be nil. The empty result, e.g. returned when the
+// deleted lease doesn't exist, is not considered an error.
+func sendDaemonCommand(agents agentcomm.ConnectedAgents, app *dbmodel.App, daemonName, commandName, hooksLibrary string, arguments map[string]interface{}) error {
+	daemons, _ := agentcomm.NewKeaDaemons(daemonName)
+	// Some commands take no arguments.
+	var commandArguments *map[string]interface{}
+	if arguments != nil {
+		commandArguments = &arguments
+	}
+	command, _ := agentcomm.NewKeaCommand(commandName, daemons, commandArguments)
+
+	response := []agentcomm.KeaResponse{}
+	ctx := context.Background()
+	respResult, err := forwardToKea(ctx, agents, app, []*agentcomm.KeaCommand{command}, &response)
+	if err != nil {
+		return err
+	}
+	if respResult.Error != nil {
+		return respResult.Error
+	}
+	if len(respResult.CmdsErrors) > 0 && respResult.CmdsErrors[0] != nil {
+		return respResult.CmdsErrors[0]
+	}
+	if len(response) == 0 {
+		return errors.Errorf("invalid response to %s command received", commandName)
+	}
+	switch response[0].Result {
+	case agentcomm.KeaResponseSuccess, agentcomm.KeaResponseEmpty:
+		return nil
+	case agentcomm.KeaResponseCommandUnsupported:
+		return errors.Errorf("%s command not supported, the %s hooks library may not be loaded", commandName, hooksLibrary)
+	default:
+		return errors.Errorf("error returned by Kea in response to %s command: %s", commandName, response[0].Text)
+	}
+}
+
+// Performs the lease operation on each of the targets and records the
+// outcome as an event.
+func runLeaseOperation(db *dbops.PgDB, agents agentcomm.ConnectedAgents, targets []LeaseOperationResult, description string, getCommand func(target *LeaseOperationResult) (string, map[string]interface{})) []LeaseOperationResult {
+	for i := range targets {
+		target := &targets[i]
+		commandName, arguments := getCommand(target)
+		target.Error = sendDaemonCommand(agents, target.App, target.Daemon.Name, commandName, "lease_cmds", arguments)
+		if target.Error != nil {
+			eventcenter.AddErrorEvent(db, fmt.Sprintf("failed to %s on %s daemon of app %d: %s",
+				description, target.Daemon.Name, target.App.ID, target.Error), target.App, target.Daemon)
+		} else {
+			eventcenter.AddInfoEvent(db, fmt.Sprintf("%s on %s daemon of app %d succeeded",
+				description, target.Daemon.Name, target.App.ID), target.App, target.Daemon)
+		}
+	}
+	return targets
+}
+
+// Adds the lease on the given DHCP daemon of the app and its HA peers. The
+// lease must include the IP address and the hardware address (DHCPv4) or
+// DUID and IAID (DHCPv6). The outcome on each daemon is returned and
+// recorded as an event.
+func AddLease(db *dbops.PgDB, agents agentcomm.ConnectedAgents, app *dbmodel.App, daemonName string, lease *Lease) ([]LeaseOperationResult, error) {
+	targets, err := getLeaseTargets(db, app, daemonName)
+	if err != nil {
+		return nil, err
+	}
+	family := getDaemonFamily(daemonName)
+	results := runLeaseOperation(db, agents, targets, fmt.Sprintf("add lease %s", lease.IPAddress),
+		func(target *LeaseOperationResult) (string, map[string]interface{}) {
+			arguments := lease.getAddArguments(family)
+			// The subnet identifiers may differ between the HA peers, so
+			// let the peers find the subnet on their own.
+			if target.App.ID != app.ID {
+				delete(arguments, "subnet-id")
+			}
+			return fmt.Sprintf("lease%d-add", family), arguments
+		})
+	return results, nil
+}
+
+// Deletes the lease with the given IP address from the given DHCP daemon
+// of the app and its HA peers. The lease type is only used for DHCPv6 and
+// it defaults to IA_NA. The outcome on each daemon is returned and recorded
+// as an event.
+func DeleteLease(db *dbops.PgDB, agents agentcomm.ConnectedAgents, app *dbmodel.App, daemonName, ipAddress, leaseType string) ([]LeaseOperationResult, error) {
+	targets, err := getLeaseTargets(db, app, daemonName)
+	if err != nil {
+		return nil, err
+	}
+	family := getDaemonFamily(daemonName)
+	arguments := map[string]interface{}{
+		"ip-address": ipAddress,
+	}
+	if family == 6 {
+		if leaseType == "" {
+			leaseType = "IA_NA"
+		}
+		arguments["type"] = leaseType
+	}
+	results := runLeaseOperation(db, agents, targets, fmt.Sprintf("delete lease %s", ipAddress),
+		func(target *LeaseOperationResult) (string, map[string]interface{}) {
+			return fmt.Sprintf("lease%d-del", family), arguments
+		})
+	return results, nil
+}
+
+// Deletes all leases in the subnet from all DHCP daemons serving it. The
+// HA peers serve the same subnet, so they are all covered. The outcome on
+// each daemon is returned and recorded as an event.
+func WipeSubnetLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, subnet *dbmodel.Subnet) ([]LeaseOperationResult, error) {
+	family := subnet.GetFamily()
+	daemonName := dbmodel.DaemonNameDHCPv4
+	if family == 6 {
+		daemonName = dbmodel.DaemonNameDHCPv6
+	}
+
+	var targets []LeaseOperationResult
+	localSubnetIDs := make(map[int64]int64)
+	for _, ls := range subnet.LocalSubnets {
+		// The apps fetched with the subnet lack the machines and the
+		// daemons.
+		app, err := dbmodel.GetAppByID(db, ls.AppID)
+		if err != nil {
+			return nil, err
+		}
+		if app == nil {
+			continue
+		}
+		for _, d := range app.Daemons {
+			if d.Name == daemonName {
+				targets = append(targets, LeaseOperationResult{App: app, Daemon: d})
+				localSubnetIDs[app.ID] = ls.LocalSubnetID
+				break
+			}
+		}
+	}
+	results := runLeaseOperation(db, agents, targets, fmt.Sprintf("wipe leases in subnet %s", subnet.Prefix),
+		func(target *LeaseOperationResult) (string, map[string]interface{}) {
+			return fmt.Sprintf("lease%d-wipe", family), map[string]interface{}{
+				"subnet-id": localSubnetIDs[target.App.ID],
+			}
+		})
+	return results, nil
+}
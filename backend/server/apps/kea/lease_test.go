@@ -0,0 +1,392 @@
+package kea
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	storktest "isc.org/stork/server/test"
+)
+
+// Generates responses to the lease4-get-by-hostname command. The first
+// server returns one lease and the second server returns the same lease
+// with later cltt and another lease.
+func mockLease4GetByHostname(callNo int, cmdResponses []interface{}) {
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	command, _ := agentcomm.NewKeaCommand("lease4-get-by-hostname", daemons, nil)
+	json := `[{
+        "result": 0,
+        "text": "1 IPv4 lease(s) found.",
+        "arguments": {
+            "leases": [
+                {
+                    "ip-address": "192.0.2.1",
+                    "hw-address": "08:08:08:08:08:08",
+                    "hostname": "myhost.example.org.",
+                    "subnet-id": 1,
+                    "cltt": 1000,
+                    "valid-lft": 3600,
+                    "state": 0
+                }
+            ]
+        }
+    }]`
+	if callNo%2 == 1 {
+		json = `[{
+            "result": 0,
+            "text": "2 IPv4 lease(s) found.",
+            "arguments": {
+                "leases": [
+                    {
+                        "ip-address": "192.0.2.1",
+                        "hw-address": "08:08:08:08:08:08",
+                        "hostname": "myhost.example.org.",
+                        "subnet-id": 1,
+                        "cltt": 2000,
+                        "valid-lft": 3600,
+                        "state": 0
+                    },
+                    {
+                        "ip-address": "192.0.2.2",
+                        "hw-address": "09:09:09:09:09:09",
+                        "hostname": "myhost.example.org.",
+                        "subnet-id": 1,
+                        "cltt": 1500,
+                        "valid-lft": 3600,
+                        "state": 0
+                    }
+                ]
+            }
+        }]`
+	}
+	_ = agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+}
+
+// Adds two Kea apps with the DHCPv4 servers to the database.
+func addLeaseTestApps(t *testing.T, db *dbops.PgDB) (apps []*dbmodel.App) {
+	for i := 0; i < 2; i++ {
+		m := &dbmodel.Machine{
+			Address:    fmt.Sprintf("machine%d", i),
+			AgentPort:  8080,
+			Authorized: true,
+		}
+		err := dbmodel.AddMachine(db, m)
+		require.NoError(t, err)
+
+		accessPoints := []*dbmodel.AccessPoint{}
+		accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000)
+		app := &dbmodel.App{
+			MachineID:    m.ID,
+			Type:         dbmodel.AppTypeKea,
+			Active:       true,
+			AccessPoints: accessPoints,
+			Daemons: []*dbmodel.Daemon{
+				{
+					Name:   "dhcp4",
+					Active: true,
+					KeaDaemon: &dbmodel.KeaDaemon{
+						Config: getTestConfigWithIPv4Subnets(t),
+					},
+				},
+			},
+		}
+		err = dbmodel.AddApp(db, app)
+		require.NoError(t, err)
+		apps = append(apps, app)
+	}
+	return apps
+}
+
+// Test that the commands searching for the leases are selected according
+// to the search text.
+func TestGetLeaseCommands(t *testing.T) {
+	commands := getLeaseCommands("192.0.2.1")
+	require.Len(t, commands, 1)
+	require.Equal(t, "lease4-get", commands[0].name)
+	require.Equal(t, "dhcp4", commands[0].daemon)
+	require.Equal(t, "192.0.2.1", commands[0].arguments["ip-address"])
+
+	// The IPv6 address may be an address or a delegated prefix.
+	commands = getLeaseCommands("2001:db8:1::1")
+	require.Len(t, commands, 2)
+	require.Equal(t, "lease6-get", commands[0].name)
+	require.Equal(t, "dhcp6", commands[0].daemon)
+	require.Equal(t, "IA_NA", commands[0].arguments["type"])
+	require.Equal(t, "lease6-get", commands[1].name)
+	require.Equal(t, "dhcp6", commands[1].daemon)
+	require.Equal(t, "IA_PD", commands[1].arguments["type"])
+	require.Equal(t, "2001:db8:1::1", commands[1].arguments["ip-address"])
+
+	commands = getLeaseCommands("2001:db8:1:100::/56")
+	require.Len(t, commands, 1)
+	require.Equal(t, "lease6-get", commands[0].name)
+	require.Equal(t, "IA_PD", commands[0].arguments["type"])
+	require.Equal(t, "2001:db8:1:100::", commands[0].arguments["ip-address"])
+
+	commands = getLeaseCommands("01-02-03-04-05-0A")
+	require.Len(t, commands, 3)
+	require.Equal(t, "lease4-get-by-hw-address", commands[0].name)
+	require.Equal(t, "01:02:03:04:05:0a", commands[0].arguments["hw-address"])
+	require.Equal(t, "lease4-get-by-client-id", commands[1].name)
+	require.Equal(t, "01:02:03:04:05:0a", commands[1].arguments["client-id"])
+	require.Equal(t, "lease6-get-by-duid", commands[2].name)
+	require.Equal(t, "dhcp6", commands[2].daemon)
+
+	// It is not a MAC address so it is only searched as client
+	// identifier and DUID.
+	commands = getLeaseCommands("1:2:3:4:5:6:7")
+	require.Len(t, commands, 2)
+	require.Equal(t, "lease4-get-by-client-id", commands[0].name)
+	require.Equal(t, "01:02:03:04:05:06:07", commands[0].arguments["client-id"])
+
+	commands = getLeaseCommands("myhost.example.org")
+	require.Len(t, commands, 2)
+	require.Equal(t, "lease4-get-by-hostname", commands[0].name)
+	require.Equal(t, "lease6-get-by-hostname", commands[1].name)
+	require.Equal(t, "myhost.example.org", commands[1].arguments["hostname"])
+}
+
+// Test that the leases are searched on all Kea servers and the leases
+// returned by the HA peers are merged.
+func TestFindLeases(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+
+	fa := storktest.NewFakeAgents(mockLease4GetByHostname, nil)
+
+	// The servers don't belong to the HA service, so all leases are
+	// returned.
+	leases, erredApps, err := FindLeases(db, fa, "myhost.example.org.")
+	require.NoError(t, err)
+	require.Empty(t, erredApps)
+	require.Len(t, leases, 3)
+	require.Len(t, fa.RecordedCommands, 2)
+	require.Equal(t, "lease4-get-by-hostname", fa.RecordedCommands[0].Command)
+	require.Equal(t, "http://localhost:8000/", fa.RecordedURL)
+	require.Equal(t, apps[0].ID, leases[0].AppID)
+	require.Equal(t, apps[0].Daemons[0].ID, leases[0].DaemonID)
+	require.Equal(t, 4, leases[0].Family)
+	require.Equal(t, "08:08:08:08:08:08", leases[0].HWAddress)
+	require.EqualValues(t, 1000, leases[0].CLTT)
+
+	// Put the servers into the HA service.
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			Name:    "ha",
+			Daemons: []*dbmodel.Daemon{apps[0].Daemons[0], apps[1].Daemons[0]},
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAType:      "dhcp4",
+			PrimaryID:   apps[0].Daemons[0].ID,
+			SecondaryID: apps[1].Daemons[0].ID,
+		},
+	}
+	err = dbmodel.AddService(db, service)
+	require.NoError(t, err)
+
+	// The lease returned by both servers should be included once. The
+	// instance with later cltt is returned.
+	fa.CallNo = 0
+	leases, erredApps, err = FindLeases(db, fa, "myhost.example.org.")
+	require.NoError(t, err)
+	require.Empty(t, erredApps)
+	require.Len(t, leases, 2)
+	require.Equal(t, "192.0.2.1", leases[0].IPAddress)
+	require.Equal(t, apps[1].ID, leases[0].AppID)
+	require.EqualValues(t, 2000, leases[0].CLTT)
+	require.Equal(t, "192.0.2.2", leases[1].IPAddress)
+
+	// The unavailable server is reported and the leases are returned
+	// by the other server.
+	fa.CallNo = 0
+	fa.UnavailableAgents = map[string]bool{"machine1:8080": true}
+	leases, erredApps, err = FindLeases(db, fa, "myhost.example.org.")
+	require.NoError(t, err)
+	require.Len(t, erredApps, 1)
+	require.Equal(t, apps[1].ID, erredApps[0].ID)
+	require.Len(t, leases, 1)
+
+	// The DHCPv6 server is not running, so no command is sent.
+	fa.RecordedCommands = nil
+	fa.UnavailableAgents = nil
+	leases, erredApps, err = FindLeases(db, fa, "2001:db8:1::1")
+	require.NoError(t, err)
+	require.Empty(t, erredApps)
+	require.Empty(t, leases)
+	require.Empty(t, fa.RecordedCommands)
+}
+
+// Generates a successful response to the lease command on the first
+// server and an error on the second server.
+func mockLeaseCommandFirstSucceeds(callNo int, cmdResponses []interface{}) {
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	command, _ := agentcomm.NewKeaCommand("lease4-del", daemons, nil)
+	json := `[{
+        "result": 0,
+        "text": "IPv4 lease deleted."
+    }]`
+	if callNo%2 == 1 {
+		json = `[{
+            "result": 1,
+            "text": "unable to communicate with the lease database"
+        }]`
+	}
+	_ = agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+}
+
+// Puts the DHCPv4 daemons of the apps into the HA service.
+func addLeaseTestHAService(t *testing.T, db *dbops.PgDB, apps []*dbmodel.App) {
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			Name:    "ha",
+			Daemons: []*dbmodel.Daemon{apps[0].Daemons[0], apps[1].Daemons[0]},
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAType:      "dhcp4",
+			PrimaryID:   apps[0].Daemons[0].ID,
+			SecondaryID: apps[1].Daemons[0].ID,
+		},
+	}
+	err := dbmodel.AddService(db, service)
+	require.NoError(t, err)
+}
+
+// Test that the arguments of the lease4-add and lease6-add commands
+// include only the specified values.
+func TestLeaseGetAddArguments(t *testing.T) {
+	lease := &Lease{
+		IPAddress: "192.0.2.1",
+		HWAddress: "08:08:08:08:08:08",
+		SubnetID:  1,
+	}
+	arguments := lease.getAddArguments(4)
+	require.Len(t, arguments, 3)
+	require.Equal(t, "192.0.2.1", arguments["ip-address"])
+	require.Equal(t, "08:08:08:08:08:08", arguments["hw-address"])
+	require.EqualValues(t, 1, arguments["subnet-id"])
+
+	lease = &Lease{
+		IPAddress:     "2001:db8:1::",
+		PrefixLength:  64,
+		Type:          "IA_PD",
+		DUID:          "01:02:03:04",
+		IAID:          5,
+		ValidLifetime: 3600,
+		Hostname:      "myhost.example.org.",
+		FqdnFwd:       true,
+	}
+	arguments = lease.getAddArguments(6)
+	require.Len(t, arguments, 9)
+	require.Equal(t, "01:02:03:04", arguments["duid"])
+	require.EqualValues(t, 5, arguments["iaid"])
+	require.Equal(t, "IA_PD", arguments["type"])
+	require.EqualValues(t, 64, arguments["prefix-len"])
+	require.EqualValues(t, 3600, arguments["valid-lft"])
+	require.Equal(t, "myhost.example.org.", arguments["hostname"])
+	require.Equal(t, true, arguments["fqdn-fwd"])
+	require.Equal(t, false, arguments["fqdn-rev"])
+}
+
+// Test that the lease is added and deleted on the daemon and its HA
+// peer and that the outcome is recorded as events.
+func TestAddDeleteLease(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+	addLeaseTestHAService(t, db, apps)
+
+	fa := storktest.NewFakeAgents(mockLeaseCommandFirstSucceeds, nil)
+
+	lease := &Lease{
+		IPAddress: "192.0.3.1",
+		HWAddress: "08:08:08:08:08:08",
+		SubnetID:  234,
+	}
+	results, err := AddLease(db, fa, apps[0], "dhcp4", lease)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, apps[0].ID, results[0].App.ID)
+	require.Equal(t, apps[0].Daemons[0].ID, results[0].Daemon.ID)
+	require.NoError(t, results[0].Error)
+	require.Equal(t, apps[1].ID, results[1].App.ID)
+	require.Equal(t, apps[1].Daemons[0].ID, results[1].Daemon.ID)
+	require.Error(t, results[1].Error)
+
+	require.Len(t, fa.RecordedCommands, 2)
+	require.Equal(t, "lease4-add", fa.RecordedCommands[0].Command)
+	arguments := *fa.RecordedCommands[0].Arguments
+	require.Equal(t, "192.0.3.1", arguments["ip-address"])
+	require.EqualValues(t, 234, arguments["subnet-id"])
+	// The peer finds the subnet on its own.
+	arguments = *fa.RecordedCommands[1].Arguments
+	require.NotContains(t, arguments, "subnet-id")
+
+	events, total, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, 0, dbmodel.SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	var texts []string
+	for _, event := range events {
+		texts = append(texts, event.Text)
+	}
+	require.Contains(t, texts, fmt.Sprintf("add lease 192.0.3.1 on dhcp4 daemon of app %d succeeded", apps[0].ID))
+	require.Contains(t, texts, fmt.Sprintf("failed to add lease 192.0.3.1 on dhcp4 daemon of app %d: error returned by Kea in response to lease4-add command: unable to communicate with the lease database", apps[1].ID))
+
+	fa.CallNo = 0
+	fa.RecordedCommands = nil
+	results, err = DeleteLease(db, fa, apps[1], "dhcp4", "192.0.3.1", "")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, apps[1].ID, results[0].App.ID)
+	require.NoError(t, results[0].Error)
+	require.Equal(t, apps[0].ID, results[1].App.ID)
+	require.Len(t, fa.RecordedCommands, 2)
+	require.Equal(t, "lease4-del", fa.RecordedCommands[0].Command)
+	require.Equal(t, "192.0.3.1", (*fa.RecordedCommands[0].Arguments)["ip-address"])
+	require.NotContains(t, *fa.RecordedCommands[0].Arguments, "type")
+
+	// There is no such daemon.
+	_, err = DeleteLease(db, fa, apps[1], "dhcp6", "2001:db8:1::1", "")
+	require.Error(t, err)
+}
+
+// Test that the leases are wiped on all servers serving the subnet.
+func TestWipeSubnetLeases(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+
+	subnet := &dbmodel.Subnet{
+		Prefix: "192.0.3.0/24",
+	}
+	err := dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+	for _, app := range apps {
+		err = dbmodel.AddAppToSubnet(db, subnet, app)
+		require.NoError(t, err)
+	}
+	subnet, err = dbmodel.GetSubnet(db, subnet.ID)
+	require.NoError(t, err)
+
+	fa := storktest.NewFakeAgents(mockLeaseCommandFirstSucceeds, nil)
+
+	results, err := WipeSubnetLeases(db, fa, subnet)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.NoError(t, results[0].Error)
+	require.Error(t, results[1].Error)
+
+	require.Len(t, fa.RecordedCommands, 2)
+	for _, command := range fa.RecordedCommands {
+		require.Equal(t, "lease4-wipe", command.Command)
+		require.EqualValues(t, 234, (*command.Arguments)["subnet-id"])
+	}
+}
@@ -0,0 +1,446 @@
+package kea
+
+import (
+	"fmt"
+	"net"
+
+	errors "github.com/pkg/errors"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+	storkutil "isc.org/stork/util"
+)
+
+// Host identifier types supported by the Kea servers in the reservations
+// for the given family.
+var reservationIdentifierTypes = map[int][]string{
+	4: {"hw-address", "duid", "circuit-id", "client-id", "flex-id"},
+	6: {"hw-address", "duid", "flex-id"},
+}
+
+// The app serving the subnet along with its daemon and the identifier of
+// the subnet in its configuration.
+type reservationTarget struct {
+	app           *dbmodel.App
+	daemon        *dbmodel.Daemon
+	localSubnetID int64
+}
+
+// Checks if the host reservation can be pushed to the Kea servers serving
+// the subnet. The reservation must include exactly one identifier because
+// Kea doesn't support more. The reserved addresses must belong to the
+// subnet and must not belong to the dynamic pools, so they are never
+// allocated to other clients. Similarly, the reserved prefixes must not
+// overlap with the prefix pools. Finally, the identifier and the reserved
+// addresses must not be used by other hosts in the subnet.
+func ValidateHost(db *dbops.PgDB, host *dbmodel.Host, subnet *dbmodel.Subnet) error {
+	family := subnet.GetFamily()
+
+	if len(host.HostIdentifiers) != 1 {
+		return errors.Errorf("host reservation must include exactly one identifier")
+	}
+	identifier := host.HostIdentifiers[0]
+	supported := false
+	for _, idType := range reservationIdentifierTypes[family] {
+		if idType == identifier.Type {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return errors.Errorf("identifier type %s is not supported in DHCPv%d reservations", identifier.Type, family)
+	}
+	if len(identifier.Value) == 0 {
+		return errors.Errorf("identifier %s must not be empty", identifier.Type)
+	}
+
+	_, subnetNet, err := net.ParseCIDR(subnet.Prefix)
+	if err != nil {
+		return errors.Wrapf(err, "invalid prefix %s of subnet %d", subnet.Prefix, subnet.ID)
+	}
+
+	addresses := 0
+	for _, r := range host.IPReservations {
+		address, isPrefix, ok := storkutil.ParseIP(r.Address)
+		if !ok {
+			address = r.Address
+			if ip := net.ParseIP(address); ip != nil {
+				ok = true
+			}
+		}
+		if !ok {
+			return errors.Errorf("invalid IP reservation %s", r.Address)
+		}
+
+		if isPrefix {
+			if family != 6 {
+				return errors.Errorf("prefix %s can only be reserved in DHCPv6 subnet", address)
+			}
+			_, prefixNet, _ := net.ParseCIDR(address)
+			for _, pool := range subnet.PrefixPools {
+				_, poolNet, err := net.ParseCIDR(pool.Prefix)
+				if err != nil {
+					continue
+				}
+				if poolNet.Contains(prefixNet.IP) || prefixNet.Contains(poolNet.IP) {
+					return errors.Errorf("reserved prefix %s overlaps with prefix pool %s", address, pool.Prefix)
+				}
+			}
+			continue
+		}
+
+		ip := net.ParseIP(address)
+		if !subnetNet.Contains(ip) {
+			return errors.Errorf("reserved address %s does not belong to subnet %s", address, subnet.Prefix)
+		}
+		for _, pool := range subnet.AddressPools {
+			if storkutil.IPInRange(ip, net.ParseIP(pool.LowerBound), net.ParseIP(pool.UpperBound)) {
+				return errors.Errorf("reserved address %s belongs to pool %s-%s", address, pool.LowerBound, pool.UpperBound)
+			}
+		}
+		addresses++
+	}
+	if family == 4 && addresses > 1 {
+		return errors.Errorf("at most one IPv4 address can be reserved for a host")
+	}
+
+	otherHosts, err := dbmodel.GetHostsBySubnetID(db, subnet.ID)
+	if err != nil {
+		return err
+	}
+	for _, other := range otherHosts {
+		if other.ID == host.ID {
+			continue
+		}
+		if _, equal := other.HasIdentifier(identifier.Type, identifier.Value); equal {
+			return errors.Errorf("%s %s is already used by host %d", identifier.Type, identifier.ToHex(":"), other.ID)
+		}
+		for _, r := range host.IPReservations {
+			if other.HasIPAddress(r.Address) {
+				return errors.Errorf("%s is already reserved for host %d", r.Address, other.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the reservation in the format of the reservation-add command
+// arguments.
+func getKeaReservation(host *dbmodel.Host, localSubnetID int64) map[string]interface{} {
+	reservation := map[string]interface{}{
+		"subnet-id": localSubnetID,
+	}
+	for _, identifier := range host.HostIdentifiers {
+		reservation[identifier.Type] = identifier.ToHex(":")
+	}
+	var addresses, prefixes []string
+	for _, r := range host.IPReservations {
+		address, isPrefix, ok := storkutil.ParseIP(r.Address)
+		if !ok {
+			address = r.Address
+		}
+		switch {
+		case isPrefix:
+			prefixes = append(prefixes, address)
+		case net.ParseIP(address).To4() != nil:
+			reservation["ip-address"] = address
+		default:
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) > 0 {
+		reservation["ip-addresses"] = addresses
+	}
+	if len(prefixes) > 0 {
+		reservation["prefixes"] = prefixes
+	}
+	return reservation
+}
+
+// Returns the arguments of the reservation-del command deleting the
+// reservation by its identifier.
+func getKeaReservationDelArguments(host *dbmodel.Host, localSubnetID int64) map[string]interface{} {
+	arguments := map[string]interface{}{
+		"subnet-id": localSubnetID,
+	}
+	if len(host.HostIdentifiers) > 0 {
+		arguments["identifier-type"] = host.HostIdentifiers[0].Type
+		arguments["identifier"] = host.HostIdentifiers[0].ToHex(":")
+	}
+	return arguments
+}
+
+// Returns the apps serving the subnet along with their DHCP daemons.
+func getReservationTargets(db *dbops.PgDB, subnetID int64) (*dbmodel.Subnet, []reservationTarget, error) {
+	subnet, err := dbmodel.GetSubnet(db, subnetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subnet == nil {
+		return nil, nil, errors.Errorf("subnet %d not found", subnetID)
+	}
+	daemonName := dbmodel.DaemonNameDHCPv4
+	if subnet.GetFamily() == 6 {
+		daemonName = dbmodel.DaemonNameDHCPv6
+	}
+	var targets []reservationTarget
+	for _, ls := range subnet.LocalSubnets {
+		// The apps fetched with the subnet lack the machines and the
+		// daemons.
+		app, err := dbmodel.GetAppByID(db, ls.AppID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if app == nil {
+			continue
+		}
+		for _, d := range app.Daemons {
+			if d.Name == daemonName {
+				targets = append(targets, reservationTarget{
+					app:           app,
+					daemon:        d,
+					localSubnetID: ls.LocalSubnetID,
+				})
+				break
+			}
+		}
+	}
+	return subnet, targets, nil
+}
+
+// Records the outcome of the reservation command sent to the daemon as an
+// event.
+func addReservationEvent(db *dbops.PgDB, target *reservationTarget, description string, err error) {
+	if err != nil {
+		eventcenter.AddErrorEvent(db, fmt.Sprintf("failed to %s on %s daemon of app %d: %s",
+			description, target.daemon.Name, target.app.ID, err), target.app, target.daemon)
+		return
+	}
+	eventcenter.AddInfoEvent(db, fmt.Sprintf("%s on %s daemon of app %d succeeded",
+		description, target.daemon.Name, target.app.ID), target.app, target.daemon)
+}
+
+// Combines the errors returned by the daemons into one error.
+func combineReservationErrors(description string, failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("failed to %s on %d daemon(s): %v", description, len(failed), failed)
+}
+
+// Checks that the host reservation is not specified in the configuration
+// files. Such reservations can't be modified with the host_cmds hooks
+// library. The global reservations are not supported either.
+func ValidateHostDataSource(host *dbmodel.Host) error {
+	if host.SubnetID == 0 {
+		return errors.Errorf("host %d is a global reservation and can't be modified", host.ID)
+	}
+	for _, lh := range host.LocalHosts {
+		if lh.DataSource == "config" {
+			return errors.Errorf("host %d is specified in the configuration file of app %d and can't be modified", host.ID, lh.AppID)
+		}
+	}
+	return nil
+}
+
+// Adds the host reservation to all Kea servers serving its subnet with the
+// reservation-add command and stores the host in the database. The host
+// is associated with the apps which accepted the reservation. The failures
+// of the other apps are recorded as events. The error is only returned
+// when none of the apps accepted the reservation or the database update
+// failed. The host must have been validated with ValidateHost.
+func AddHostReservation(db *dbops.PgDB, agents agentcomm.ConnectedAgents, host *dbmodel.Host) error {
+	subnet, targets, err := getReservationTargets(db, host.SubnetID)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.Errorf("no Kea server serves subnet %s", subnet.Prefix)
+	}
+
+	description := fmt.Sprintf("add host reservation in subnet %s", subnet.Prefix)
+	var (
+		succeeded []*dbmodel.App
+		failed    []string
+	)
+	for i := range targets {
+		target := &targets[i]
+		err = sendDaemonCommand(agents, target.app, target.daemon.Name, "reservation-add", "host_cmds",
+			map[string]interface{}{
+				"reservation": getKeaReservation(host, target.localSubnetID),
+			})
+		addReservationEvent(db, target, description, err)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("app %d: %s", target.app.ID, err))
+			continue
+		}
+		succeeded = append(succeeded, target.app)
+	}
+	if len(succeeded) == 0 {
+		return combineReservationErrors(description, failed)
+	}
+
+	// Update the database right away rather than waiting for the hosts
+	// puller to fetch the new reservation.
+	seq, err := dbmodel.GetNextBulkUpdateSeq(db)
+	if err != nil {
+		return err
+	}
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+	err = dbmodel.AddHost(tx, host)
+	if err != nil {
+		return err
+	}
+	for _, app := range succeeded {
+		err = dbmodel.AddAppToHost(tx, host, app, "api", seq)
+		if err != nil {
+			return err
+		}
+	}
+	err = commit()
+	if err != nil {
+		return errors.WithMessagef(err, "problem with committing host reservation added in subnet %s", subnet.Prefix)
+	}
+	return nil
+}
+
+// Replaces the host reservation on all Kea servers serving its subnet. The
+// existing reservation is deleted with the reservation-del command and the
+// updated one is added with the reservation-add command. If the server
+// refuses the updated reservation, the existing one is added back, so the
+// server is not left without the reservation. The host is updated
+// in the database and it is only associated with the apps which accepted
+// the updated reservation. As in AddHostReservation, the error is only
+// returned when none of the apps accepted it. The updated host must have
+// been validated with ValidateHost and the subnet must not change.
+func UpdateHostReservation(db *dbops.PgDB, agents agentcomm.ConnectedAgents, existing, host *dbmodel.Host) error {
+	if err := ValidateHostDataSource(existing); err != nil {
+		return err
+	}
+	subnet, targets, err := getReservationTargets(db, existing.SubnetID)
+	if err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("update host reservation %d in subnet %s", existing.ID, subnet.Prefix)
+	var (
+		succeeded []*dbmodel.App
+		failed    []string
+	)
+	for i := range targets {
+		target := &targets[i]
+		err = sendDaemonCommand(agents, target.app, target.daemon.Name, "reservation-del", "host_cmds",
+			getKeaReservationDelArguments(existing, target.localSubnetID))
+		if err == nil {
+			err = sendDaemonCommand(agents, target.app, target.daemon.Name, "reservation-add", "host_cmds",
+				map[string]interface{}{
+					"reservation": getKeaReservation(host, target.localSubnetID),
+				})
+			if err != nil {
+				restoreErr := sendDaemonCommand(agents, target.app, target.daemon.Name, "reservation-add", "host_cmds",
+					map[string]interface{}{
+						"reservation": getKeaReservation(existing, target.localSubnetID),
+					})
+				if restoreErr != nil {
+					err = errors.Errorf("%s; restoring the previous reservation failed: %s", err, restoreErr)
+				} else {
+					err = errors.Errorf("%s; the previous reservation has been restored", err)
+				}
+			}
+		}
+		addReservationEvent(db, target, description, err)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("app %d: %s", target.app.ID, err))
+			continue
+		}
+		succeeded = append(succeeded, target.app)
+	}
+	if len(succeeded) == 0 {
+		return combineReservationErrors(description, failed)
+	}
+
+	seq, err := dbmodel.GetNextBulkUpdateSeq(db)
+	if err != nil {
+		return err
+	}
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+	host.ID = existing.ID
+	host.CreatedAt = existing.CreatedAt
+	host.SubnetID = existing.SubnetID
+	err = dbmodel.UpdateHost(tx, host)
+	if err != nil {
+		return err
+	}
+	// The apps which failed hold the old reservation, unless it couldn't
+	// be restored, so they are no longer associated with the host. The
+	// next pull of the hosts will fix it.
+	for _, lh := range existing.LocalHosts {
+		err = dbmodel.DeleteAppFromHost(tx, existing.ID, lh.AppID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, app := range succeeded {
+		err = dbmodel.AddAppToHost(tx, host, app, "api", seq)
+		if err != nil {
+			return err
+		}
+	}
+	err = commit()
+	if err != nil {
+		return errors.WithMessagef(err, "problem with committing host reservation %d updated in subnet %s", existing.ID, subnet.Prefix)
+	}
+	return nil
+}
+
+// Deletes the host reservation from all Kea servers serving its subnet with
+// the reservation-del command. The host is deleted from the database when
+// all apps deleted the reservation. Otherwise, only the apps which deleted
+// it are no longer associated with the host and the error is returned.
+func DeleteHostReservation(db *dbops.PgDB, agents agentcomm.ConnectedAgents, host *dbmodel.Host) error {
+	if err := ValidateHostDataSource(host); err != nil {
+		return err
+	}
+	subnet, targets, err := getReservationTargets(db, host.SubnetID)
+	if err != nil {
+		return err
+	}
+
+	description := fmt.Sprintf("delete host reservation %d in subnet %s", host.ID, subnet.Prefix)
+	var (
+		succeeded []*dbmodel.App
+		failed    []string
+	)
+	for i := range targets {
+		target := &targets[i]
+		err = sendDaemonCommand(agents, target.app, target.daemon.Name, "reservation-del", "host_cmds",
+			getKeaReservationDelArguments(host, target.localSubnetID))
+		addReservationEvent(db, target, description, err)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("app %d: %s", target.app.ID, err))
+			continue
+		}
+		succeeded = append(succeeded, target.app)
+	}
+
+	if len(failed) == 0 {
+		return dbmodel.DeleteHost(db, host.ID)
+	}
+	for _, app := range succeeded {
+		err = dbmodel.DeleteAppFromHost(db, host.ID, app.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return combineReservationErrors(description, failed)
+}
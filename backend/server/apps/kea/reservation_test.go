@@ -0,0 +1,309 @@
+package kea
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	storktest "isc.org/stork/server/test"
+)
+
+// Generates successful responses to the reservation commands.
+func mockReservationCommand(callNo int, cmdResponses []interface{}) {
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	command, _ := agentcomm.NewKeaCommand("reservation-add", daemons, nil)
+	json := `[{
+        "result": 0,
+        "text": "Host added."
+    }]`
+	_ = agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+}
+
+// Generates successful responses to the reservation-del and reservation-add
+// commands sent to the first server and errors for the second server.
+func mockReservationUpdateFirstSucceeds(callNo int, cmdResponses []interface{}) {
+	if callNo < 2 {
+		mockReservationCommand(callNo, cmdResponses)
+		return
+	}
+	mockLeaseCommandFirstSucceeds(1, cmdResponses)
+}
+
+// Generates successful responses to the reservation-del commands and
+// to the reservation-add commands restoring the previous reservation, and
+// errors for the reservation-add commands adding the updated reservation.
+func mockReservationUpdateAddFails(callNo int, cmdResponses []interface{}) {
+	if callNo%3 == 1 {
+		mockLeaseCommandFirstSucceeds(1, cmdResponses)
+		return
+	}
+	mockReservationCommand(callNo, cmdResponses)
+}
+
+// Adds the subnet served by the lease test apps along with an address
+// pool.
+func addReservationTestSubnet(t *testing.T, db *dbops.PgDB, apps []*dbmodel.App) *dbmodel.Subnet {
+	subnet := &dbmodel.Subnet{
+		Prefix: "192.0.3.0/24",
+		AddressPools: []dbmodel.AddressPool{
+			{
+				LowerBound: "192.0.3.100",
+				UpperBound: "192.0.3.200",
+			},
+		},
+	}
+	err := dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+	for _, app := range apps {
+		err = dbmodel.AddAppToSubnet(db, subnet, app)
+		require.NoError(t, err)
+	}
+	return subnet
+}
+
+// Returns the host with the HW address and the IP address reservation.
+func newReservationTestHost(subnetID int64, hwAddress []byte, address string) *dbmodel.Host {
+	return &dbmodel.Host{
+		SubnetID: subnetID,
+		HostIdentifiers: []dbmodel.HostIdentifier{
+			{
+				Type:  "hw-address",
+				Value: hwAddress,
+			},
+		},
+		IPReservations: []dbmodel.IPReservation{
+			{
+				Address: address,
+			},
+		},
+	}
+}
+
+// Test that the reservation is converted to the arguments of the
+// reservation-add and reservation-del commands.
+func TestGetKeaReservation(t *testing.T) {
+	host := newReservationTestHost(1, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.10")
+	reservation := getKeaReservation(host, 123)
+	require.EqualValues(t, 123, reservation["subnet-id"])
+	require.Equal(t, "01:02:03:04:05:06", reservation["hw-address"])
+	require.Equal(t, "192.0.3.10", reservation["ip-address"])
+	require.NotContains(t, reservation, "ip-addresses")
+
+	host = &dbmodel.Host{
+		HostIdentifiers: []dbmodel.HostIdentifier{
+			{
+				Type:  "duid",
+				Value: []byte{1, 2, 3},
+			},
+		},
+		IPReservations: []dbmodel.IPReservation{
+			{
+				Address: "2001:db8:1::10",
+			},
+			{
+				Address: "3000::/64",
+			},
+		},
+	}
+	reservation = getKeaReservation(host, 5)
+	require.Equal(t, "01:02:03", reservation["duid"])
+	require.Equal(t, []string{"2001:db8:1::10"}, reservation["ip-addresses"])
+	require.Equal(t, []string{"3000::/64"}, reservation["prefixes"])
+	require.NotContains(t, reservation, "ip-address")
+
+	arguments := getKeaReservationDelArguments(host, 5)
+	require.EqualValues(t, 5, arguments["subnet-id"])
+	require.Equal(t, "duid", arguments["identifier-type"])
+	require.Equal(t, "01:02:03", arguments["identifier"])
+}
+
+// Test that the reservations which can't be pushed to Kea are rejected.
+func TestValidateHost(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+	subnet := addReservationTestSubnet(t, db, apps)
+	subnet, err := dbmodel.GetSubnet(db, subnet.ID)
+	require.NoError(t, err)
+
+	host := newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.10")
+	require.NoError(t, ValidateHost(db, host, subnet))
+
+	// Outside of the subnet.
+	host.IPReservations[0].Address = "192.0.2.10"
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// Within the pool.
+	host.IPReservations[0].Address = "192.0.3.150"
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// Prefix in the DHCPv4 subnet.
+	host.IPReservations[0].Address = "3000::/64"
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// Two IPv4 addresses.
+	host.IPReservations = []dbmodel.IPReservation{{Address: "192.0.3.10"}, {Address: "192.0.3.11"}}
+	require.Error(t, ValidateHost(db, host, subnet))
+	host.IPReservations = host.IPReservations[:1]
+
+	// Identifier not supported by Kea.
+	host.HostIdentifiers[0].Type = "remote-id"
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// No identifier.
+	host.HostIdentifiers = nil
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// The identifier and the address are already used by other host.
+	other := newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.20")
+	err = dbmodel.AddHost(db, other)
+	require.NoError(t, err)
+	host = newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.10")
+	require.Error(t, ValidateHost(db, host, subnet))
+	host = newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 7}, "192.0.3.20")
+	require.Error(t, ValidateHost(db, host, subnet))
+
+	// The host may keep its own identifier and address.
+	host.ID = other.ID
+	require.NoError(t, ValidateHost(db, host, subnet))
+}
+
+// Test that the host reservation is added, updated and deleted on all
+// Kea servers serving the subnet and in the database.
+func TestAddUpdateDeleteHostReservation(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+	subnet := addReservationTestSubnet(t, db, apps)
+
+	fa := storktest.NewFakeAgents(mockReservationCommand, nil)
+
+	host := newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.10")
+	err := AddHostReservation(db, fa, host)
+	require.NoError(t, err)
+	require.NotZero(t, host.ID)
+
+	require.Len(t, fa.RecordedCommands, 2)
+	for _, command := range fa.RecordedCommands {
+		require.Equal(t, "reservation-add", command.Command)
+		reservation := (*command.Arguments)["reservation"].(map[string]interface{})
+		require.EqualValues(t, 234, reservation["subnet-id"])
+		require.Equal(t, "01:02:03:04:05:06", reservation["hw-address"])
+		require.Equal(t, "192.0.3.10", reservation["ip-address"])
+	}
+
+	returned, err := dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Len(t, returned.LocalHosts, 2)
+	for _, lh := range returned.LocalHosts {
+		require.Equal(t, "api", lh.DataSource)
+	}
+
+	// The second server fails to update the reservation, so the host is
+	// only associated with the first one.
+	fa = storktest.NewFakeAgents(mockReservationUpdateFirstSucceeds, nil)
+	updated := newReservationTestHost(0, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.11")
+	err = UpdateHostReservation(db, fa, returned, updated)
+	require.NoError(t, err)
+
+	// The first server received reservation-del and reservation-add and the
+	// second one only reservation-del.
+	require.Len(t, fa.RecordedCommands, 3)
+	require.Equal(t, "reservation-del", fa.RecordedCommands[0].Command)
+	require.Equal(t, "hw-address", (*fa.RecordedCommands[0].Arguments)["identifier-type"])
+	require.Equal(t, "reservation-add", fa.RecordedCommands[1].Command)
+	require.Equal(t, "reservation-del", fa.RecordedCommands[2].Command)
+
+	returned, err = dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, subnet.ID, returned.SubnetID)
+	require.Len(t, returned.IPReservations, 1)
+	require.Equal(t, "192.0.3.11/32", returned.IPReservations[0].Address)
+	require.Len(t, returned.LocalHosts, 1)
+	require.Equal(t, apps[0].ID, returned.LocalHosts[0].AppID)
+
+	// The reservation is deleted from the only server holding it.
+	fa = storktest.NewFakeAgents(mockReservationCommand, nil)
+	err = DeleteHostReservation(db, fa, returned)
+	require.NoError(t, err)
+	require.Len(t, fa.RecordedCommands, 2)
+
+	returned, err = dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
+
+// Test that the previous reservation is restored on the servers refusing
+// the updated reservation.
+func TestUpdateHostReservationRestore(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addLeaseTestApps(t, db)
+	subnet := addReservationTestSubnet(t, db, apps)
+
+	fa := storktest.NewFakeAgents(mockReservationCommand, nil)
+	host := newReservationTestHost(subnet.ID, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.10")
+	err := AddHostReservation(db, fa, host)
+	require.NoError(t, err)
+	returned, err := dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+
+	fa = storktest.NewFakeAgents(mockReservationUpdateAddFails, nil)
+	updated := newReservationTestHost(0, []byte{1, 2, 3, 4, 5, 6}, "192.0.3.11")
+	err = UpdateHostReservation(db, fa, returned, updated)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "previous reservation has been restored")
+
+	// Each server received reservation-del, reservation-add with the
+	// updated reservation and reservation-add with the previous one.
+	require.Len(t, fa.RecordedCommands, 6)
+	for i := 0; i < 2; i++ {
+		commands := fa.RecordedCommands[3*i : 3*i+3]
+		require.Equal(t, "reservation-del", commands[0].Command)
+		require.Equal(t, "reservation-add", commands[1].Command)
+		reservation := (*commands[1].Arguments)["reservation"].(map[string]interface{})
+		require.Equal(t, "192.0.3.11", reservation["ip-address"])
+		require.Equal(t, "reservation-add", commands[2].Command)
+		reservation = (*commands[2].Arguments)["reservation"].(map[string]interface{})
+		require.Equal(t, "192.0.3.10", reservation["ip-address"])
+	}
+
+	// The host is not updated in the database.
+	returned, err = dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.Len(t, returned.IPReservations, 1)
+	require.Equal(t, "192.0.3.10/32", returned.IPReservations[0].Address)
+	require.Len(t, returned.LocalHosts, 2)
+}
+
+// Test that the reservations specified in the configuration files can't be
+// modified.
+func TestValidateHostDataSource(t *testing.T) {
+	host := &dbmodel.Host{
+		ID:       1,
+		SubnetID: 1,
+		LocalHosts: []dbmodel.LocalHost{
+			{
+				AppID:      1,
+				DataSource: "api",
+			},
+		},
+	}
+	require.NoError(t, ValidateHostDataSource(host))
+
+	host.LocalHosts[0].DataSource = "config"
+	require.Error(t, ValidateHostDataSource(host))
+
+	host.LocalHosts = nil
+	host.SubnetID = 0
+	require.Error(t, ValidateHostDataSource(host))
+}
@@ -2,6 +2,8 @@ package kea
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-pg/pg/v9"
 	"github.com/pkg/errors"
@@ -81,7 +83,7 @@ func getStatsFromLocalSubnets(localSubnets []*dbmodel.LocalSubnet, family int, a
 // of apps for which the stats were successfully pulled and last encountered error.
 func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 	// get list of all kea apps from database
-	dbApps, err := dbmodel.GetAppsByType(statsPuller.Db, dbmodel.AppTypeKea)
+	dbApps, err := dbmodel.GetAuthorizedAppsByType(statsPuller.Db, dbmodel.AppTypeKea)
 	if err != nil {
 		return 0, err
 	}
@@ -91,6 +93,10 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 	appsOkCnt := 0
 	for _, dbApp := range dbApps {
 		dbApp2 := dbApp
+		if !statsPuller.Agents.IsAgentAvailable(dbApp.Machine.Address, dbApp.Machine.AgentPort) {
+			log.Warnf("skipped pulling lease stats from app %d because its agent is unreachable", dbApp.ID)
+			continue
+		}
 		err := statsPuller.getLeaseStatsFromApp(&dbApp2)
 		if err != nil {
 			lastErr = err
@@ -123,6 +129,10 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 		"declined-nas":      0,
 	}
 
+	// samples of the subnets and shared networks stats to be stored in the history
+	now := storkutil.UTCNow()
+	samples := []dbmodel.StatisticSample{}
+
 	// go through all Subnets and:
 	// 1) estimate utilization per Subnet and per SharedNetwork
 	// 2) estimate global stats
@@ -131,41 +141,59 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 	netTotalPds := int64(0)
 	netAssignedPds := int64(0)
 	sharedNetworkID := subnets[0].SharedNetworkID
+	netFamily := subnets[0].GetFamily()
+	updateSharedNetwork := func() error {
+		used := int16(0)
+		if netTotal > 0 {
+			used = int16(1000 * netAssigned / netTotal)
+		}
+		usedPds := int16(0)
+		if netTotalPds > 0 {
+			usedPds = int16(1000 * netAssignedPds / netTotalPds)
+		}
+		err := dbmodel.UpdateUtilizationInSharedNetwork(statsPuller.Db, sharedNetworkID, used, usedPds)
+		if err != nil {
+			log.Errorf("cannot update utilization (%d, %d) in shared network %d: %s", used, usedPds, sharedNetworkID, err)
+			return err
+		}
+		owner := dbmodel.StatisticOwner{SharedNetworkID: sharedNetworkID}
+		samples = appendLeaseStatsSamples(samples, owner, now, netFamily, netAssigned, netTotal, -1, netAssignedPds, netTotalPds)
+		return nil
+	}
 	for _, sn := range subnets {
 		// We go through subnets which are sorted by shared network ID.
 		// When this ID changes it means that we completed scanning subnets of given
 		// shared network and we can store utilization data to shared network in db.
-		if sharedNetworkID != sn.SharedNetworkID && sharedNetworkID != 0 {
-			used := int16(0)
-			if netTotal > 0 {
-				used = int16(1000 * netAssigned / netTotal)
-			}
-			usedPds := int16(0)
-			if netTotalPds > 0 {
-				usedPds = int16(1000 * netAssignedPds / netTotalPds)
-			}
-			err := dbmodel.UpdateUtilizationInSharedNetwork(statsPuller.Db, sharedNetworkID, used, usedPds)
-			if err != nil {
-				lastErr = err
-				log.Errorf("cannot update utilization (%d, %d) in shared network %d: %s", used, usedPds, sharedNetworkID, err)
-				continue
+		if sharedNetworkID != sn.SharedNetworkID {
+			if sharedNetworkID != 0 {
+				if err := updateSharedNetwork(); err != nil {
+					lastErr = err
+				}
 			}
 			netTotal = 0
 			netAssigned = 0
 			netTotalPds = 0
 			netAssignedPds = 0
 			sharedNetworkID = sn.SharedNetworkID
+			netFamily = sn.GetFamily()
 		}
 
-		// prepare stats keys depending on IP version
+		// prepare stats keys depending on IP version; the global stats
+		// are stored under their historical names
 		family := sn.GetFamily()
-		totalKey := "total-addreses"
-		assignedKey := "assigned-addreses"
-		declinedKey := "declined-addreses"
+		totalKey := "total-addresses"
+		assignedKey := "assigned-addresses"
+		declinedKey := "declined-addresses"
+		globalTotalKey := "total-addreses"
+		globalAssignedKey := "assigned-addreses"
+		globalDeclinedKey := "declined-addreses"
 		if family == 6 {
 			totalKey = "total-nas"
 			assignedKey = "assigned-nas"
 			declinedKey = "declined-nas"
+			globalTotalKey = totalKey
+			globalAssignedKey = assignedKey
+			globalDeclinedKey = declinedKey
 		}
 
 		// go through LocalSubnets and get max stats about assigned, total and declined addresses and pds
@@ -174,9 +202,9 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 		// add subnet counts to shared network ones and global stats
 		netTotal += snTotal
 		netAssigned += snAssigned
-		statsMap[assignedKey] += snAssigned
-		statsMap[totalKey] += snTotal
-		statsMap[declinedKey] += snDeclined
+		statsMap[globalAssignedKey] += snAssigned
+		statsMap[globalTotalKey] += snTotal
+		statsMap[globalDeclinedKey] += snDeclined
 		if family == 6 {
 			netTotalPds += snTotalPds
 			netAssignedPds += snAssignedPds
@@ -198,6 +226,14 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 			log.Errorf("cannot update utilization (%d, %d) in subnet %d: %s", snMaxUsed, snMaxUsedPds, sn.ID, err)
 			continue
 		}
+		owner := dbmodel.StatisticOwner{SubnetID: sn.ID}
+		samples = appendLeaseStatsSamples(samples, owner, now, family, snAssigned, snTotal, snDeclined, snAssignedPds, snTotalPds)
+	}
+	// the subnets of the last shared network have been scanned too
+	if sharedNetworkID != 0 {
+		if err := updateSharedNetwork(); err != nil {
+			lastErr = err
+		}
 	}
 
 	// update global statistics in db
@@ -206,15 +242,83 @@ func (statsPuller *StatsPuller) pullLeaseStats() (int, error) {
 		lastErr = err
 	}
 
+	// store the history of the stats and apply the retention policy to it
+	err = dbmodel.AddStatisticSamples(statsPuller.Db, samples)
+	if err != nil {
+		log.Errorf("cannot store history of lease stats: %s", err)
+		lastErr = err
+	}
+	err = statsPuller.updateAddrExhaustion(subnets, now)
+	if err != nil {
+		lastErr = err
+	}
+	err = dbmodel.CompactStatisticSamples(statsPuller.Db, now)
+	if err != nil {
+		log.Errorf("cannot compact history of stats: %s", err)
+		lastErr = err
+	}
+
 	return appsOkCnt, lastErr
 }
 
+// Appends the samples of the lease stats of the subnet, the shared network or
+// the daemon to the given list. The utilizations are converted to percents.
+// The declined addresses are not appended when their number is negative and
+// the delegated prefixes are appended only for the DHCPv6.
+func appendLeaseStatsSamples(samples []dbmodel.StatisticSample, owner dbmodel.StatisticOwner, sampledAt time.Time, family int, assigned, total, declined, assignedPds, totalPds int64) []dbmodel.StatisticSample {
+	add := func(name string, value float64) {
+		samples = append(samples, dbmodel.StatisticSample{
+			StatisticOwner: owner,
+			Name:           name,
+			SampledAt:      sampledAt,
+			Value:          value,
+		})
+	}
+	utilization := 0.0
+	if total > 0 {
+		utilization = 100 * float64(assigned) / float64(total)
+	}
+	add(dbmodel.StatisticAddrUtilization, utilization)
+	add(dbmodel.StatisticAssignedAddresses, float64(assigned))
+	add(dbmodel.StatisticTotalAddresses, float64(total))
+	if declined >= 0 {
+		add(dbmodel.StatisticDeclinedAddresses, float64(declined))
+	}
+	if family == 6 {
+		utilization = 0.0
+		if totalPds > 0 {
+			utilization = 100 * float64(assignedPds) / float64(totalPds)
+		}
+		add(dbmodel.StatisticPdUtilization, utilization)
+		add(dbmodel.StatisticAssignedPds, float64(assignedPds))
+		add(dbmodel.StatisticTotalPds, float64(totalPds))
+	}
+	return samples
+}
+
 // Part of response for stat-lease4-get and stat-lease6-get commands.
 type ResultSetInStatLeaseGet struct {
 	Columns []string
 	Rows    [][]int
 }
 
+// Returns the sum of the values in the given column over all subnets. If
+// there is no such column, 0 is returned.
+func (rs *ResultSetInStatLeaseGet) sumColumn(name string) int64 {
+	sum := int64(0)
+	for colIdx, column := range rs.Columns {
+		if column != name {
+			continue
+		}
+		for _, row := range rs.Rows {
+			if colIdx < len(row) {
+				sum += int64(row[colIdx])
+			}
+		}
+	}
+	return sum
+}
+
 // Part of response for stat-lease4-get and stat-lease6-get commands.
 type StatLeaseGetArgs struct {
 	ResultSet ResultSetInStatLeaseGet `json:"result-set"`
@@ -266,13 +370,6 @@ func (statsPuller *StatsPuller) storeDaemonStats(resultSet *ResultSetInStatLease
 
 // Get lease stats from given kea app.
 func (statsPuller *StatsPuller) getLeaseStatsFromApp(dbApp *dbmodel.App) error {
-	// prepare URL to CA
-	ctrlPoint, err := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
-	if err != nil {
-		return err
-	}
-	caURL := storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port)
-
 	// get active dhcp daemons
 	dhcpDaemons := make(agentcomm.KeaDaemons)
 	found := false
@@ -306,7 +403,7 @@ func (statsPuller *StatsPuller) getLeaseStatsFromApp(dbApp *dbmodel.App) error {
 	statsResp1 := []StatLeaseGetResponse{}
 	statsResp2 := []StatLeaseGetResponse{}
 	ctx := context.Background()
-	cmdsResult, err := statsPuller.Agents.ForwardToKeaOverHTTP(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, caURL, cmds, &statsResp1, &statsResp2)
+	cmdsResult, err := forwardToKea(ctx, statsPuller.Agents, dbApp, cmds, &statsResp1, &statsResp2)
 	if err != nil {
 		return err
 	}
@@ -342,6 +439,8 @@ func (statsPuller *StatsPuller) getLeaseStatsFromApp(dbApp *dbmodel.App) error {
 
 	// process response from kea daemons
 	var lastErr error
+	now := storkutil.UTCNow()
+	samples := []dbmodel.StatisticSample{}
 	for idx, srs := range [][]StatLeaseGetResponse{stats4Resp, stats6Resp} {
 		family := 4
 		if idx == 1 {
@@ -355,8 +454,28 @@ func (statsPuller *StatsPuller) getLeaseStatsFromApp(dbApp *dbmodel.App) error {
 			if err != nil {
 				lastErr = err
 			}
+
+			// the totals of the daemon are stored in the stats history
+			rs := &sr.Arguments.ResultSet
+			owner := dbmodel.StatisticOwner{
+				AppID:      dbApp.ID,
+				DaemonName: fmt.Sprintf("dhcp%d", family),
+			}
+			if family == 4 {
+				samples = appendLeaseStatsSamples(samples, owner, now, family,
+					rs.sumColumn("assigned-addresses"), rs.sumColumn("total-addresses"), rs.sumColumn("declined-addresses"), 0, 0)
+			} else {
+				samples = appendLeaseStatsSamples(samples, owner, now, family,
+					rs.sumColumn("assigned-nas"), rs.sumColumn("total-nas"), rs.sumColumn("declined-nas"),
+					rs.sumColumn("assigned-pds"), rs.sumColumn("total-pds"))
+			}
 		}
 	}
 
+	err = dbmodel.AddStatisticSamples(statsPuller.Db, samples)
+	if err != nil {
+		lastErr = err
+	}
+
 	return lastErr
 }
@@ -168,6 +168,103 @@ func TestStatsPullerPullStats(t *testing.T) {
 	require.Equal(t, 5, snCnt)
 }
 
+// Check that the utilizations of the DHCPv4 subnets and all shared networks,
+// including the last one, are updated.
+func TestStatsPullerUtilization(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// prepare fake agents
+	keaMock := func(callNo int, cmdResponses []interface{}) {
+		// DHCPv4
+		daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+		command, _ := agentcomm.NewKeaCommand("stat-lease4-get", daemons, nil)
+		json := `[{
+                            "result": 0,
+                            "text": "Everything is fine",
+                            "arguments": {
+                                "result-set": {
+                                    "columns": [ "subnet-id", "total-addresses", "assigned-addresses", "declined-addresses" ],
+                                    "rows": [
+                                        [ 10, 256, 111, 0 ],
+                                        [ 20, 4098, 2034, 4 ]
+                                    ],
+                                    "timestamp": "2018-05-04 15:03:37.000000"
+                                }
+                            }
+                         }]`
+		agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+
+		// DHCPv6
+		daemons, _ = agentcomm.NewKeaDaemons("dhcp6")
+		command, _ = agentcomm.NewKeaCommand("stat-lease6-get", daemons, nil)
+		json = `[{
+                           "result": 0,
+                           "text": "Everything is fine",
+                           "arguments": {}
+                        }]`
+		agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[1])
+	}
+	fa := storktest.NewFakeAgents(keaMock, nil)
+
+	// all subnets belong to the shared networks
+	v4Config := `
+        {
+            "Dhcp4": {
+                "shared-networks": [
+                    {
+                        "name": "foo",
+                        "subnet4": [{"id": 10, "subnet": "192.0.2.0/24"}]
+                    },
+                    {
+                        "name": "bar",
+                        "subnet4": [{"id": 20, "subnet": "192.0.3.0/24"}]
+                    }
+                ]
+            }
+        }`
+	app := createAppWithSubnets(t, db, 0, v4Config, "")
+	nets, snets, err := DetectNetworks(db, app)
+	require.NoError(t, err)
+	err = dbmodel.CommitNetworksIntoDB(db, nets, snets, app, 1)
+	require.NoError(t, err)
+
+	// set one setting that is needed by puller
+	setting := dbmodel.Setting{
+		Name:    "kea_stats_puller_interval",
+		ValType: dbmodel.SettingValTypeInt,
+		Value:   "60",
+	}
+	err = db.Insert(&setting)
+	require.NoError(t, err)
+
+	sp, err := NewStatsPuller(db, fa)
+	require.NoError(t, err)
+	defer sp.Shutdown()
+
+	_, err = sp.pullLeaseStats()
+	require.NoError(t, err)
+
+	for prefix, utilization := range map[string]int16{"192.0.2.0/24": 433, "192.0.3.0/24": 496} {
+		subnets, err := dbmodel.GetSubnetsByPrefix(db, prefix)
+		require.NoError(t, err)
+		require.Len(t, subnets, 1)
+		require.Equal(t, utilization, subnets[0].AddrUtilization, prefix)
+
+		network, err := dbmodel.GetSharedNetwork(db, subnets[0].SharedNetworkID)
+		require.NoError(t, err)
+		require.NotNil(t, network)
+		require.Equal(t, utilization, network.AddrUtilization, network.Name)
+	}
+
+	// the global stats keep their names
+	stats, err := dbmodel.GetAllStats(db)
+	require.NoError(t, err)
+	require.EqualValues(t, 256+4098, stats["total-addreses"])
+	require.EqualValues(t, 111+2034, stats["assigned-addreses"])
+	require.EqualValues(t, 4, stats["declined-addreses"])
+}
+
 // Check if Kea response to stat-leaseX-get command is handled correctly when it is
 // empty or when stats hooks library is not loaded.
 func TestStatsPullerEmptyResponse(t *testing.T) {
@@ -201,9 +298,10 @@ func TestStatsPullerEmptyResponse(t *testing.T) {
 
 	// add one machine with one kea app
 	m := &dbmodel.Machine{
-		ID:        0,
-		Address:   "localhost",
-		AgentPort: 8080,
+		ID:         0,
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
 	}
 	err := dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
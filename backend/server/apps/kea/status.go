@@ -11,6 +11,7 @@ import (
 	"isc.org/stork/server/agentcomm"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
 	storkutil "isc.org/stork/util"
 )
 
@@ -18,6 +19,7 @@ const (
 	HAStatusUnavailable   = "unavailable"
 	HAStatusLoadBalancing = "load-balancing"
 	HAStatusHotStandby    = "hot-standby"
+	HAStatusPartnerDown   = "partner-down"
 )
 
 // === status-get response structs ================================================
@@ -212,25 +214,64 @@ func updateHAServiceStatus(status *HAServersStatus, daemon *dbmodel.Daemon, serv
 	// Finally, if any of the server's is in the partner-down state we should
 	// record it as failover event.
 	if primaryLastState != service.PrimaryLastState {
-		if primaryLastState == "partner-down" {
+		if primaryLastState == HAStatusPartnerDown {
 			service.PrimaryLastFailoverAt = service.PrimaryStatusCollectedAt
 		}
 		service.PrimaryLastState = primaryLastState
 	}
 	if secondaryLastState != service.SecondaryLastState {
-		if secondaryLastState == "partner-down" {
+		if secondaryLastState == HAStatusPartnerDown {
 			service.SecondaryLastFailoverAt = service.SecondaryStatusCollectedAt
 		}
 		service.SecondaryLastState = secondaryLastState
 	}
 }
 
+// HA states of the primary and secondary/standby server.
+type haStates struct {
+	primary   string
+	secondary string
+}
+
+// Emits events about the changes of the HA states of the servers within the
+// HA service. The previous states are the ones stored in the database before
+// the status was pulled.
+func addHAStateEvents(db *dbops.PgDB, service *dbmodel.Service, prev haStates) {
+	ha := service.HAService
+	addHAStateEvent(db, service, ha.PrimaryID, "primary", prev.primary, ha.PrimaryLastState)
+	addHAStateEvent(db, service, ha.SecondaryID, "secondary", prev.secondary, ha.SecondaryLastState)
+}
+
+// Emits an event about the change of the HA state of the given server. The
+// state recorded for the first time is not reported. The transitions to
+// the states indicating a problem with the server or its partner are
+// reported as warnings.
+func addHAStateEvent(db *dbops.PgDB, service *dbmodel.Service, daemonID int64, role, prevState, state string) {
+	if prevState == "" || state == "" || prevState == state {
+		return
+	}
+	level := dbmodel.EvInfo
+	switch state {
+	case HAStatusUnavailable, HAStatusPartnerDown:
+		level = dbmodel.EvWarning
+	}
+	var objects []interface{}
+	for _, d := range service.Daemons {
+		if d.ID == daemonID {
+			objects = append(objects, d, d.App)
+			break
+		}
+	}
+	text := fmt.Sprintf("%s server in HA service %d changed state from %s to %s", role, service.ID, prevState, state)
+	eventcenter.AddEvent(db, eventcenter.CreateEvent(level, text, objects...))
+}
+
 // Gets the status of the Kea apps and stores useful information in the database.
 // The High Availability status is stored in the database for those apps which
 // have the HA enabled.
 func (puller *StatusPuller) pullData() (int, error) {
 	// Get the list of all Kea apps from the database.
-	apps, err := dbmodel.GetAppsByType(puller.Db, dbmodel.AppTypeKea)
+	apps, err := dbmodel.GetAuthorizedAppsByType(puller.Db, dbmodel.AppTypeKea)
 	if err != nil {
 		return 0, err
 	}
@@ -256,10 +297,19 @@ func (puller *StatusPuller) pullData() (int, error) {
 		// command. These values will indicate that we can't say what is happening
 		// with the server we failed to connect to.
 		var haServices []dbmodel.Service
+		prevStates := make(map[int64]haStates)
 		for j := range dbServices {
 			if dbServices[j].HAService == nil {
 				continue
 			}
+			// Remember the states before they are reset, so the state changes
+			// can be reported.
+			if _, ok := prevStates[dbServices[j].ID]; !ok {
+				prevStates[dbServices[j].ID] = haStates{
+					primary:   dbServices[j].HAService.PrimaryLastState,
+					secondary: dbServices[j].HAService.SecondaryLastState,
+				}
+			}
 			for _, d := range apps[i].Daemons {
 				switch d.ID {
 				case dbServices[j].HAService.PrimaryID:
@@ -276,11 +326,18 @@ func (puller *StatusPuller) pullData() (int, error) {
 		}
 
 		appsCnt++
-		ctx := context.Background()
-		// Send the status-get command to both DHCPv4 and DHCPv6 servers.
-		appStatus, err := getDHCPStatus(ctx, puller.Agents, &apps[i])
-		if err != nil {
-			log.Errorf("error occurred while getting Kea app %d status: %s", apps[i].ID, err)
+		// Send the status-get command to both DHCPv4 and DHCPv6 servers unless
+		// the agent is known to be down. In that case the HA services are
+		// updated with the status indicating that the servers are unavailable.
+		var appStatus appStatus
+		if puller.Agents.IsAgentAvailable(apps[i].Machine.Address, apps[i].Machine.AgentPort) {
+			ctx := context.Background()
+			appStatus, err = getDHCPStatus(ctx, puller.Agents, &apps[i])
+			if err != nil {
+				log.Errorf("error occurred while getting Kea app %d status: %s", apps[i].ID, err)
+			}
+		} else {
+			log.Warnf("skipped getting Kea app %d status because its agent is unreachable", apps[i].ID)
 		}
 		// Go over the returned status values and match with the daemons.
 		for _, status := range appStatus {
@@ -327,6 +384,12 @@ func (puller *StatusPuller) pullData() (int, error) {
 				log.Errorf("error occurred while updating HA services status for Kea app %d: %s", apps[i].ID, err)
 				continue
 			}
+			// The same service may be on the list more than once, so make
+			// sure that the state changes are reported once.
+			if prev, ok := prevStates[haServices[j].ID]; ok {
+				addHAStateEvents(puller.Db, &haServices[j], prev)
+				delete(prevStates, haServices[j].ID)
+			}
 		}
 
 		appsOkCnt++
@@ -348,17 +411,11 @@ func getDHCPStatus(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	ctrlPoint, err := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
-	if err != nil {
-		return nil, err
-	}
-	caURL := storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port)
-
 	// The Kea response will be stored in this slice of structures.
 	response := []StatusGetResponse{}
 
 	// Send the command and receive the response.
-	cmdsResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp.Machine.Address, dbApp.Machine.AgentPort, caURL, []*agentcomm.KeaCommand{cmd}, &response)
+	cmdsResult, err := forwardToKea(ctx, agents, dbApp, []*agentcomm.KeaCommand{cmd}, &response)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package kea
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -521,8 +522,9 @@ func testPullHAStatus(t *testing.T, version178 bool) {
 
 	// Add a machine.
 	m := &dbmodel.Machine{
-		Address:   "localhost",
-		AgentPort: 8080,
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
 	}
 	err := dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
@@ -682,6 +684,25 @@ func testPullHAStatus(t *testing.T, version178 bool) {
 	require.False(t, service.HAService.PrimaryLastFailoverAt.IsZero())
 	require.True(t, service.HAService.SecondaryLastFailoverAt.IsZero())
 
+	// The state changes should have been recorded as events. The primary
+	// server belongs to our app.
+	events, _, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvWarning, 0, 0, 0, 0, dbmodel.SortDirAsc)
+	require.NoError(t, err)
+	var primaryEvent, secondaryEvent *dbmodel.Event
+	for i := range events {
+		switch events[i].Text {
+		case fmt.Sprintf("primary server in HA service %d changed state from load-balancing to partner-down", service.ID):
+			primaryEvent = &events[i]
+		case fmt.Sprintf("secondary server in HA service %d changed state from load-balancing to unavailable", service.ID):
+			secondaryEvent = &events[i]
+		}
+	}
+	require.NotNil(t, primaryEvent)
+	require.EqualValues(t, service.HAService.PrimaryID, primaryEvent.DaemonID)
+	require.EqualValues(t, keaApp.ID, primaryEvent.AppID)
+	require.EqualValues(t, keaApp.MachineID, primaryEvent.MachineID)
+	require.NotNil(t, secondaryEvent)
+
 	// These fields are only available in Kea 1.7.8+.
 	if version178 {
 		require.NotNil(t, service.HAService.SecondaryCommInterrupted)
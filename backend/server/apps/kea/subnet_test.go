@@ -17,9 +17,10 @@ import (
 func createAppWithSubnets(t *testing.T, db *dbops.PgDB, index int64, v4Config, v6Config string) *dbmodel.App {
 	// Add the machine.
 	m := &dbmodel.Machine{
-		ID:        0,
-		Address:   "localhost",
-		AgentPort: 8080 + index,
+		ID:         0,
+		Address:    "localhost",
+		AgentPort:  8080 + index,
+		Authorized: true,
 	}
 	err := dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
@@ -2,43 +2,260 @@ package auth
 
 import (
 	"net/http"
-	"regexp"
 	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	dbmodel "isc.org/stork/server/database/model"
 )
 
-// Checks if the given user is permitted to access a resource. Currently the
-// access pattern is very simple, the super-admin user can access all
-// resources. The admin-user can access all resources except those related
-// to users management.
-func Authorize(user *dbmodel.SystemUser, req *http.Request) (ok bool, err error) {
+// Resources the permissions can be granted for.
+const (
+	// All resources except the sensitive ones.
+	ResourceAll         = "*"
+	ResourceMachines    = "machines"
+	ResourceApps        = "apps"
+	ResourceDHCP        = "dhcp"
+	ResourceDNS         = "dns"
+	ResourceSettings    = "settings"
+	ResourceEvents      = "events"
+	ResourceAlerting    = "alerting"
+	ResourceSearch      = "search"
+	ResourceUsers       = "users"
+	ResourceAudit       = "audit"
+	ResourceServerToken = "server-token"
+)
+
+// The resources which are not covered by the permissions granted for all
+// resources. They allow for gaining access to other resources or reveal
+// the actions of other users, so they must be granted explicitly.
+var sensitiveResources = map[string]bool{
+	ResourceUsers:       true,
+	ResourceAudit:       true,
+	ResourceServerToken: true,
+}
+
+// Maps the first segment of the ReST API path to the resource.
+var pathResources = map[string]string{
+	"machines":              ResourceMachines,
+	"machines-server-token": ResourceServerToken,
+	"apps":                  ResourceApps,
+	"apps-stats":            ResourceApps,
+	"hosts":                 ResourceDHCP,
+	"leases":                ResourceDHCP,
+	"subnets":               ResourceDHCP,
+	"shared-networks":       ResourceDHCP,
+	"overview":              ResourceDHCP,
+	"config-checkers":       ResourceDHCP,
+	"services":              ResourceDHCP,
+	"settings":              ResourceSettings,
+	"events":                ResourceEvents,
+	"alerting":              ResourceAlerting,
+	"records":               ResourceSearch,
+	"users":                 ResourceUsers,
+	"groups":                ResourceUsers,
+	"audit":                 ResourceAudit,
+}
+
+// Operation performed by the ReST API request, i.e. the action on the
+// resource. The IDs of the machine, app and user are set when the request
+// concerns a particular object.
+type Operation struct {
+	Resource  string
+	Action    string
+	MachineID int64
+	AppID     int64
+	UserID    int
+	// Indicates that any signed in user is allowed to perform the operation.
+	AnyUser bool
+}
+
+// Returns the operation performed by the ReST API request. The GET and HEAD
+// requests read the resource, all other requests modify it. The resource of
+// the operation on the app is the apps resource, it should be replaced with
+// the resource returned by AppResource when the type of the app is known.
+func GetOperation(req *http.Request) *Operation {
+	op := &Operation{
+		Action: dbmodel.PermissionWrite,
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		op.Action = dbmodel.PermissionRead
+	}
+
+	// Split the path into segments skipping the empty ones and the
+	// /api prefix.
+	var segments []string
+	for _, s := range strings.Split(req.URL.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if len(segments) > 0 && segments[0] == "api" {
+		segments = segments[1:]
+	}
+	if len(segments) == 0 {
+		return op
+	}
+
+	var ok bool
+	op.Resource, ok = pathResources[segments[0]]
+	if !ok {
+		op.Resource = segments[0]
+	}
+	var id int64
+	if len(segments) > 1 {
+		id, _ = strconv.ParseInt(segments[1], 10, 64)
+	}
+
+	switch segments[0] {
+	case "machines":
+		op.MachineID = id
+	case "apps", "leases":
+		op.AppID = id
+	case "users":
+		op.UserID = int(id)
+	case "groups":
+		// The list of groups is used by the UI to present the users.
+		op.AnyUser = op.Action == dbmodel.PermissionRead && len(segments) == 1
+	}
+	return op
+}
+
+// Returns the resource the app belongs to. The Kea apps are DHCP servers
+// and the BIND 9 apps are DNS servers.
+func AppResource(appType string) string {
+	switch appType {
+	case dbmodel.AppTypeKea:
+		return ResourceDHCP
+	case dbmodel.AppTypeBind9:
+		return ResourceDNS
+	default:
+		return ResourceApps
+	}
+}
+
+// Checks if the permission allows for the operation.
+func permits(permission *dbmodel.Permission, op *Operation) bool {
+	if permission.Resource != op.Resource &&
+		(permission.Resource != ResourceAll || sensitiveResources[op.Resource]) {
+		return false
+	}
+	if permission.Action != dbmodel.PermissionWrite && op.Action != permission.Action {
+		return false
+	}
+	if permission.MachineID != 0 && permission.MachineID != op.MachineID {
+		return false
+	}
+	if permission.AppID != 0 && permission.AppID != op.AppID {
+		return false
+	}
+	return true
+}
+
+// Checks if the given user is permitted to perform the operation. The
+// super-admin user can perform all operations. The users can always access
+// their own accounts. Other operations are only allowed when any of the
+// permissions granted to the groups of the user permits it.
+func Authorize(user *dbmodel.SystemUser, permissions []dbmodel.Permission, op *Operation) bool {
 	// If there is no user (possibly the user has not signed in), the user
-	// does not belong to any groups or the request is nil, reject access to
-	// the resource.
-	if user == nil || len(user.Groups) == 0 || req == nil {
-		return false, nil
+	// does not belong to any groups or the operation is unknown, reject
+	// access to the resource.
+	if user == nil || len(user.Groups) == 0 || op == nil {
+		return false
 	}
 
 	// If the user is super-admin he can access all resources.
 	if user.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID}) {
-		return true, nil
+		return true
 	}
 
-	// If the user does not belong to the super-admin group and trying to
-	// access the user specific information, check if the data the user
-	// is trying to access belong to this user. If not, reject access.
-	if ok, _ := regexp.Match(`users/{0,}`, []byte(req.URL.Path)); ok {
-		ok, _ := regexp.Match(`users/{1,}`+strconv.Itoa(user.ID)+`/*`, []byte(req.URL.Path))
-		return ok, nil
+	if op.AnyUser {
+		return true
 	}
 
-	// All other resources can be accessed by the admin user.
-	if user.InGroup(&dbmodel.SystemGroup{ID: dbmodel.AdminGroupID}) {
-		return true, err
+	// Everyone can access own account.
+	if op.Resource == ResourceUsers && op.UserID != 0 && op.UserID == user.ID {
+		return true
 	}
 
-	// User who doesn't belong to any group is not allowed to access
-	// system resources.
-	return false, nil
+	for i := range permissions {
+		if !user.InGroup(&dbmodel.SystemGroup{ID: permissions[i].GroupID}) {
+			continue
+		}
+		if permits(&permissions[i], op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the resource and the action are supported.
+func validateResourceAction(resource, action string) error {
+	switch resource {
+	case ResourceAll, ResourceMachines, ResourceApps, ResourceDHCP, ResourceDNS,
+		ResourceSettings, ResourceEvents, ResourceAlerting, ResourceSearch,
+		ResourceUsers, ResourceAudit, ResourceServerToken:
+	default:
+		return errors.Errorf("unsupported resource %s", resource)
+	}
+	if action != dbmodel.PermissionRead && action != dbmodel.PermissionWrite {
+		return errors.Errorf("unsupported action %s, expected %s or %s", action,
+			dbmodel.PermissionRead, dbmodel.PermissionWrite)
+	}
+	return nil
+}
+
+// Validates the permission before it is stored in the database.
+func ValidatePermission(permission *dbmodel.Permission) error {
+	if err := validateResourceAction(permission.Resource, permission.Action); err != nil {
+		return err
+	}
+	if permission.MachineID != 0 && permission.AppID != 0 {
+		return errors.New("permission can be limited to a machine or an app but not both")
+	}
+	return nil
+}
+
+// Converts the scope of the API token to the permission. The scope has
+// the form of resource:action, e.g. dhcp:read.
+func scopeToPermission(scope string) (*dbmodel.Permission, error) {
+	parts := strings.Split(scope, ":")
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid scope %s, expected resource:action", scope)
+	}
+	if err := validateResourceAction(parts[0], parts[1]); err != nil {
+		return nil, err
+	}
+	return &dbmodel.Permission{
+		Resource: parts[0],
+		Action:   parts[1],
+	}, nil
+}
+
+// Validates the scope of the API token before it is stored in the
+// database.
+func ValidateScope(scope string) error {
+	_, err := scopeToPermission(scope)
+	return err
+}
+
+// Checks if the scopes of the API token allow for the operation. The
+// scopes only narrow down what the owner of the token is permitted to
+// do, so the Authorize must also be called. Empty scopes allow for all
+// operations.
+func AuthorizeScopes(scopes []string, op *Operation) bool {
+	if len(scopes) == 0 || op.AnyUser {
+		return true
+	}
+	for _, scope := range scopes {
+		permission, err := scopeToPermission(scope)
+		if err != nil {
+			continue
+		}
+		if permits(permission, op) {
+			return true
+		}
+	}
+	return false
 }
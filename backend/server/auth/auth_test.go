@@ -8,9 +8,26 @@ import (
 	dbmodel "isc.org/stork/server/database/model"
 )
 
+// Group IDs of the default groups created by the migrations.
+const (
+	readOnlyGroupID  = 3
+	dhcpAdminGroupID = 4
+	dnsAdminGroupID  = 5
+)
+
+// Permissions of the default groups.
+var defaultPermissions = []dbmodel.Permission{
+	{GroupID: dbmodel.AdminGroupID, Resource: ResourceAll, Action: dbmodel.PermissionWrite},
+	{GroupID: readOnlyGroupID, Resource: ResourceAll, Action: dbmodel.PermissionRead},
+	{GroupID: dhcpAdminGroupID, Resource: ResourceAll, Action: dbmodel.PermissionRead},
+	{GroupID: dhcpAdminGroupID, Resource: ResourceDHCP, Action: dbmodel.PermissionWrite},
+	{GroupID: dnsAdminGroupID, Resource: ResourceAll, Action: dbmodel.PermissionRead},
+	{GroupID: dnsAdminGroupID, Resource: ResourceDNS, Action: dbmodel.PermissionWrite},
+}
+
 // Helper function checking if the user belonging to the specified group
 // has access to the resource.
-func authorizeAccept(t *testing.T, groupID int, path string) bool {
+func authorizeMethod(groupID int, method, path string, permissions []dbmodel.Permission) bool {
 	// Create user with ID 5 and specified group id if the group id is
 	// positive.
 	user := &dbmodel.SystemUser{
@@ -25,11 +42,14 @@ func authorizeAccept(t *testing.T, groupID int, path string) bool {
 	}
 
 	// Create request with the specified path and authorize.
-	req, _ := http.NewRequest("GET", "http://example.org/api"+path, nil)
-	ok, err := Authorize(user, req)
-	require.NoError(t, err)
+	req, _ := http.NewRequest(method, "http://example.org/api"+path, nil)
+	return Authorize(user, permissions, GetOperation(req))
+}
 
-	return ok
+// Helper function checking if the user belonging to the specified group
+// can read the resource.
+func authorizeAccept(t *testing.T, groupID int, path string) bool {
+	return authorizeMethod(groupID, "GET", path, defaultPermissions)
 }
 
 // Verify that users belonging to the super-admin and admin group
@@ -56,10 +76,180 @@ func TestAuthorize(t *testing.T) {
 	// admin group have no restriction on machines
 	require.True(t, authorizeAccept(t, 2, "/machines/1/"))
 
+	// but only super-admin can access the server token
+	require.False(t, authorizeAccept(t, 2, "/machines-server-token"))
+	require.True(t, authorizeAccept(t, 1, "/machines-server-token"))
+
+	// and the audit trail
+	require.False(t, authorizeAccept(t, 2, "/audit"))
+	require.False(t, authorizeAccept(t, 2, "/audit/"))
+	require.True(t, authorizeAccept(t, 1, "/audit"))
+	require.True(t, authorizeAccept(t, 2, "/auditors"))
+
 	// but someone who belongs to no groups would not be able
 	// to access machines
 	require.False(t, authorizeAccept(t, 0, "/machines/1/"))
 
 	// the same in case of someone belonging to non existing group
-	require.False(t, authorizeAccept(t, 3, "/machines/1/"))
+	require.False(t, authorizeAccept(t, 6, "/machines/1/"))
+
+	// everyone can get the list of groups but only super-admin can
+	// modify them
+	require.True(t, authorizeAccept(t, readOnlyGroupID, "/groups"))
+	require.False(t, authorizeMethod(2, "POST", "/groups", defaultPermissions))
+	require.False(t, authorizeMethod(2, "GET", "/groups/2/permissions", defaultPermissions))
+	require.True(t, authorizeMethod(1, "POST", "/groups/2/permissions", defaultPermissions))
+}
+
+// Verify the access privileges of the read only, DHCP admin and DNS admin
+// groups.
+func TestAuthorizeDefaultGroups(t *testing.T) {
+	for _, groupID := range []int{readOnlyGroupID, dhcpAdminGroupID, dnsAdminGroupID} {
+		// All can look at everything except other users.
+		require.True(t, authorizeAccept(t, groupID, "/machines"))
+		require.True(t, authorizeAccept(t, groupID, "/subnets"))
+		require.True(t, authorizeAccept(t, groupID, "/hosts"))
+		require.True(t, authorizeAccept(t, groupID, "/events"))
+		require.True(t, authorizeAccept(t, groupID, "/records"))
+		require.False(t, authorizeAccept(t, groupID, "/users"))
+		require.False(t, authorizeAccept(t, groupID, "/audit"))
+		require.False(t, authorizeAccept(t, groupID, "/machines-server-token"))
+
+		// But none can delete machines or change settings.
+		require.False(t, authorizeMethod(groupID, "DELETE", "/machines/1", defaultPermissions))
+		require.False(t, authorizeMethod(groupID, "PUT", "/settings", defaultPermissions))
+
+		// The own account can be modified.
+		require.True(t, authorizeMethod(groupID, "PUT", "/users/5/password", defaultPermissions))
+	}
+
+	// Only the DHCP admin can modify the DHCP data.
+	require.False(t, authorizeMethod(readOnlyGroupID, "POST", "/hosts", defaultPermissions))
+	require.True(t, authorizeMethod(dhcpAdminGroupID, "POST", "/hosts", defaultPermissions))
+	require.False(t, authorizeMethod(dnsAdminGroupID, "POST", "/hosts", defaultPermissions))
+}
+
+// Verify that the permissions can be limited to a machine or an app.
+func TestAuthorizeScoped(t *testing.T) {
+	permissions := []dbmodel.Permission{
+		{GroupID: 6, Resource: ResourceAll, Action: dbmodel.PermissionWrite, MachineID: 3},
+		{GroupID: 6, Resource: ResourceDHCP, Action: dbmodel.PermissionRead, AppID: 7},
+		// Permissions of other groups are ignored.
+		{GroupID: 7, Resource: ResourceAll, Action: dbmodel.PermissionWrite},
+	}
+	require.True(t, authorizeMethod(6, "PUT", "/machines/3", permissions))
+	require.True(t, authorizeMethod(6, "GET", "/machines/3/state", permissions))
+	require.False(t, authorizeMethod(6, "PUT", "/machines/4", permissions))
+	require.False(t, authorizeMethod(6, "GET", "/machines", permissions))
+
+	user := &dbmodel.SystemUser{
+		ID:     5,
+		Groups: []*dbmodel.SystemGroup{{ID: 6}},
+	}
+	req, _ := http.NewRequest("GET", "http://example.org/api/apps/7", nil)
+	op := GetOperation(req)
+	require.Equal(t, ResourceApps, op.Resource)
+	require.EqualValues(t, 7, op.AppID)
+	op.Resource = AppResource(dbmodel.AppTypeKea)
+	op.MachineID = 4
+	require.True(t, Authorize(user, permissions, op))
+
+	// The lease operations are performed on the given app.
+	req, _ = http.NewRequest("DELETE", "http://example.org/api/leases/7/dhcp4/192.0.2.1", nil)
+	leaseOp := GetOperation(req)
+	require.Equal(t, ResourceDHCP, leaseOp.Resource)
+	require.Equal(t, dbmodel.PermissionWrite, leaseOp.Action)
+	require.EqualValues(t, 7, leaseOp.AppID)
+	leaseOp.MachineID = 4
+	require.False(t, Authorize(user, permissions, leaseOp))
+	leaseOp.Action = dbmodel.PermissionRead
+	require.True(t, Authorize(user, permissions, leaseOp))
+
+	// The app on the permitted machine.
+	op.AppID = 8
+	require.False(t, Authorize(user, permissions, op))
+	op.MachineID = 3
+	require.True(t, Authorize(user, permissions, op))
+}
+
+// Test that the requests are mapped to the operations.
+func TestGetOperation(t *testing.T) {
+	req, _ := http.NewRequest("DELETE", "http://example.org/api/machines/12", nil)
+	op := GetOperation(req)
+	require.Equal(t, ResourceMachines, op.Resource)
+	require.Equal(t, dbmodel.PermissionWrite, op.Action)
+	require.EqualValues(t, 12, op.MachineID)
+
+	req, _ = http.NewRequest("GET", "http://example.org/api/shared-networks?start=0", nil)
+	op = GetOperation(req)
+	require.Equal(t, ResourceDHCP, op.Resource)
+	require.Equal(t, dbmodel.PermissionRead, op.Action)
+
+	req, _ = http.NewRequest("PUT", "http://example.org/api/config-checkers/control_socket", nil)
+	op = GetOperation(req)
+	require.Equal(t, ResourceDHCP, op.Resource)
+	require.Equal(t, dbmodel.PermissionWrite, op.Action)
+
+	req, _ = http.NewRequest("POST", "http://example.org/api/services/3/ha-actions", nil)
+	op = GetOperation(req)
+	require.Equal(t, ResourceDHCP, op.Resource)
+	require.Equal(t, dbmodel.PermissionWrite, op.Action)
+
+	req, _ = http.NewRequest("GET", "http://example.org/api/foo", nil)
+	op = GetOperation(req)
+	require.Equal(t, "foo", op.Resource)
+
+	require.Equal(t, ResourceDNS, AppResource(dbmodel.AppTypeBind9))
+	require.Equal(t, ResourceApps, AppResource("foo"))
+}
+
+// Test that invalid permissions are rejected.
+func TestValidatePermission(t *testing.T) {
+	permission := &dbmodel.Permission{
+		Resource: ResourceDHCP,
+		Action:   dbmodel.PermissionWrite,
+	}
+	require.NoError(t, ValidatePermission(permission))
+	permission.MachineID = 1
+	require.NoError(t, ValidatePermission(permission))
+	permission.AppID = 1
+	require.Error(t, ValidatePermission(permission))
+	permission.MachineID = 0
+	permission.Action = "delete"
+	require.Error(t, ValidatePermission(permission))
+	permission.Action = dbmodel.PermissionRead
+	permission.Resource = "foo"
+	require.Error(t, ValidatePermission(permission))
+}
+
+// Test that the scopes of the API tokens limit the operations.
+func TestAuthorizeScopes(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "http://example.org/api/hosts/1", nil)
+	op := GetOperation(req)
+	require.True(t, AuthorizeScopes(nil, op))
+	require.True(t, AuthorizeScopes([]string{"dhcp:write"}, op))
+	require.True(t, AuthorizeScopes([]string{"dns:read", "*:write"}, op))
+	require.False(t, AuthorizeScopes([]string{"dhcp:read"}, op))
+	require.False(t, AuthorizeScopes([]string{"dns:write"}, op))
+	require.False(t, AuthorizeScopes([]string{"foo"}, op))
+
+	// The sensitive resources must be listed explicitly.
+	req, _ = http.NewRequest("GET", "http://example.org/api/users/5", nil)
+	op = GetOperation(req)
+	require.False(t, AuthorizeScopes([]string{"*:write"}, op))
+	require.True(t, AuthorizeScopes([]string{"users:read"}, op))
+
+	req, _ = http.NewRequest("GET", "http://example.org/api/groups", nil)
+	op = GetOperation(req)
+	require.True(t, AuthorizeScopes([]string{"dhcp:read"}, op))
+}
+
+// Test that invalid scopes are rejected.
+func TestValidateScope(t *testing.T) {
+	require.NoError(t, ValidateScope("dhcp:read"))
+	require.NoError(t, ValidateScope("*:write"))
+	require.Error(t, ValidateScope("dhcp"))
+	require.Error(t, ValidateScope("dhcp:read:write"))
+	require.Error(t, ValidateScope("dhcp:delete"))
+	require.Error(t, ValidateScope("foo:read"))
 }
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Interface implemented by the sources of the user credentials, e.g. the
+// database or an LDAP server.
+type Authenticator interface {
+	// Verifies the credentials of the user signing in. It returns the
+	// user along with the groups it belongs to or nil when the
+	// credentials are invalid. The error is only returned when it is
+	// not possible to verify the credentials.
+	Authenticate(login, password string) (*dbmodel.SystemUser, error)
+}
+
+// Authenticates the users with the passwords stored in the database.
+type DatabaseAuthenticator struct {
+	db *dbops.PgDB
+}
+
+// Creates new authenticator using the passwords stored in the database.
+func NewDatabaseAuthenticator(db *dbops.PgDB) *DatabaseAuthenticator {
+	return &DatabaseAuthenticator{
+		db: db,
+	}
+}
+
+// Verifies the password of the user. The user is found by email when the
+// login contains @ or by login otherwise.
+func (a *DatabaseAuthenticator) Authenticate(login, password string) (*dbmodel.SystemUser, error) {
+	user := &dbmodel.SystemUser{
+		Password: password,
+	}
+	if strings.Contains(login, "@") {
+		user.Email = login
+	} else {
+		user.Login = login
+	}
+	ok, err := dbmodel.Authenticate(a.db, user)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Settings of the authentication via LDAP. The LDAP authentication is
+// disabled when the URL is not set.
+type LDAPSettings struct {
+	URL               string   `long:"ldap-url" description:"the URL of the LDAP server used to authenticate the users, e.g. ldap://ldap.example.org; if not set the LDAP authentication is disabled" env:"STORK_LDAP_URL"`
+	StartTLS          bool     `long:"ldap-start-tls" description:"upgrade the connection to the LDAP server with StartTLS" env:"STORK_LDAP_START_TLS"`
+	SkipTLSVerify     bool     `long:"ldap-skip-tls-verify" description:"do not verify the certificate of the LDAP server" env:"STORK_LDAP_SKIP_TLS_VERIFY"`
+	BindDN            string   `long:"ldap-bind-dn" description:"the DN used to search for the users; if not set the search is anonymous" env:"STORK_LDAP_BIND_DN"`
+	BindPassword      string   `long:"ldap-bind-password" description:"the password used to search for the users" env:"STORK_LDAP_BIND_PASSWORD"`
+	UserBaseDN        string   `long:"ldap-user-base-dn" description:"the DN of the subtree with the users, e.g. ou=users,dc=example,dc=org" env:"STORK_LDAP_USER_BASE_DN"`
+	UserFilter        string   `long:"ldap-user-filter" description:"the filter used to find the user, %s is replaced with the login" default:"(uid=%s)" env:"STORK_LDAP_USER_FILTER"`
+	GroupAttribute    string   `long:"ldap-group-attribute" description:"the attribute of the user holding the DNs of the groups the user belongs to" default:"memberOf" env:"STORK_LDAP_GROUP_ATTRIBUTE"`
+	GroupMap          []string `long:"ldap-group-map" description:"maps the LDAP group to the Stork group in the form of stork-group=group-dn, e.g. admin=cn=admins,ou=groups,dc=example,dc=org; can be specified multiple times" env:"STORK_LDAP_GROUP_MAP" env-delim:";"`
+	EmailAttribute    string   `long:"ldap-email-attribute" description:"the attribute of the user holding the email" default:"mail" env:"STORK_LDAP_EMAIL_ATTRIBUTE"`
+	NameAttribute     string   `long:"ldap-name-attribute" description:"the attribute of the user holding the first name" default:"givenName" env:"STORK_LDAP_NAME_ATTRIBUTE"`
+	LastnameAttribute string   `long:"ldap-lastname-attribute" description:"the attribute of the user holding the last name" default:"sn" env:"STORK_LDAP_LASTNAME_ATTRIBUTE"`
+}
+
+// Maps the LDAP group to the Stork group.
+type ldapGroupMapping struct {
+	dn        *ldap.DN
+	rawDN     string
+	groupName string
+}
+
+// Authenticates the users with an LDAP server. The user is found with the
+// search, then the credentials are verified by binding as the user. The
+// LDAP groups the user belongs to are mapped to the Stork groups. The user
+// is created in the database upon first login and its details and groups
+// are updated upon subsequent logins. The users who don't belong to any of
+// the mapped groups are not allowed to sign in.
+type LDAPAuthenticator struct {
+	settings *LDAPSettings
+	db       *dbops.PgDB
+	groupMap []ldapGroupMapping
+}
+
+// Creates new LDAP authenticator. It returns an error when the settings
+// are invalid.
+func NewLDAPAuthenticator(settings *LDAPSettings, db *dbops.PgDB) (*LDAPAuthenticator, error) {
+	if settings.URL == "" {
+		return nil, errors.New("LDAP server URL must be specified")
+	}
+	if settings.UserBaseDN == "" {
+		return nil, errors.New("LDAP user base DN must be specified")
+	}
+	if strings.Count(settings.UserFilter, "%s") != 1 {
+		return nil, errors.Errorf("LDAP user filter %s must include exactly one %%s", settings.UserFilter)
+	}
+	a := &LDAPAuthenticator{
+		settings: settings,
+		db:       db,
+	}
+	for _, m := range settings.GroupMap {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid LDAP group mapping %s, expected stork-group=group-dn", m)
+		}
+		dn, err := ldap.ParseDN(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid DN in LDAP group mapping %s", m)
+		}
+		a.groupMap = append(a.groupMap, ldapGroupMapping{
+			dn:        dn,
+			rawDN:     parts[1],
+			groupName: parts[0],
+		})
+	}
+	if len(a.groupMap) == 0 {
+		return nil, errors.New("at least one LDAP group mapping must be specified")
+	}
+	return a, nil
+}
+
+// Connects to the LDAP server and binds with the DN used for searching
+// the users.
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: a.settings.SkipTLSVerify, //nolint:gosec
+	}
+	conn, err := ldap.DialURL(a.settings.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with connecting to LDAP server %s", a.settings.URL)
+	}
+	if a.settings.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "problem with StartTLS to LDAP server %s", a.settings.URL)
+		}
+	}
+	if a.settings.BindDN != "" {
+		err = conn.Bind(a.settings.BindDN, a.settings.BindPassword)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "problem with binding to LDAP server %s as %s", a.settings.URL, a.settings.BindDN)
+		}
+	}
+	return conn, nil
+}
+
+// Returns the names of the Stork groups the LDAP groups are mapped to.
+func (a *LDAPAuthenticator) mapGroups(groupDNs []string) []string {
+	var names []string
+	for _, m := range a.groupMap {
+		for _, groupDN := range groupDNs {
+			matched := strings.EqualFold(groupDN, m.rawDN)
+			if !matched {
+				dn, err := ldap.ParseDN(groupDN)
+				matched = err == nil && dn.Equal(m.dn)
+			}
+			if matched {
+				names = append(names, m.groupName)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Verifies the credentials of the user with the LDAP server and creates or
+// updates the user in the database.
+func (a *LDAPAuthenticator) Authenticate(login, password string) (*dbmodel.SystemUser, error) {
+	// The LDAP servers accept binds with empty password as unauthenticated
+	// binds, so they must be rejected here.
+	if login == "" || password == "" {
+		return nil, nil
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := ldap.NewSearchRequest(
+		a.settings.UserBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.settings.UserFilter, ldap.EscapeFilter(login)),
+		[]string{a.settings.EmailAttribute, a.settings.NameAttribute, a.settings.LastnameAttribute, a.settings.GroupAttribute},
+		nil)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, errors.Wrapf(err, "problem with searching for user %s in LDAP", login)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		log.Infof("user %s not found in LDAP or not unique", login)
+		return nil, nil
+	}
+	entry := result.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "problem with binding to LDAP as %s", entry.DN)
+	}
+
+	groupNames := a.mapGroups(entry.GetAttributeValues(a.settings.GroupAttribute))
+	if len(groupNames) == 0 {
+		log.Warnf("LDAP user %s does not belong to any of the mapped groups", login)
+		return nil, nil
+	}
+
+	user := &dbmodel.SystemUser{
+		Login:    login,
+		Email:    entry.GetAttributeValue(a.settings.EmailAttribute),
+		Name:     entry.GetAttributeValue(a.settings.NameAttribute),
+		Lastname: entry.GetAttributeValue(a.settings.LastnameAttribute),
+	}
+	// The names are mandatory.
+	if user.Name == "" {
+		user.Name = login
+	}
+	if user.Lastname == "" {
+		user.Lastname = login
+	}
+	for _, name := range groupNames {
+		group, err := dbmodel.GetGroupByName(a.db, name)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			log.Warnf("group %s mapped from LDAP does not exist", name)
+			continue
+		}
+		user.Groups = append(user.Groups, group)
+	}
+	if len(user.Groups) == 0 {
+		return nil, nil
+	}
+
+	return a.provisionUser(user)
+}
+
+// Creates the LDAP user in the database upon first login or updates it
+// upon subsequent logins. The users created by the administrators are
+// never modified.
+func (a *LDAPAuthenticator) provisionUser(user *dbmodel.SystemUser) (*dbmodel.SystemUser, error) {
+	existing, err := dbmodel.GetUserByLogin(a.db, user.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		// The user can't sign in with the password stored in the database,
+		// so let's make it impossible to guess.
+		buf := make([]byte, 32)
+		_, err = rand.Read(buf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "problem with generating password for LDAP user %s", user.Login)
+		}
+		user.Password = hex.EncodeToString(buf)
+		user.AuthMethod = dbmodel.AuthMethodLDAP
+		_, err = dbmodel.CreateUser(a.db, user)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("created user %s upon first login via LDAP", user.Login)
+	} else {
+		if existing.AuthMethod != dbmodel.AuthMethodLDAP {
+			log.Warnf("user %s exists in the database and can't sign in via LDAP", user.Login)
+			return nil, nil
+		}
+		// Empty password means that it is not updated.
+		user.ID = existing.ID
+		user.AuthMethod = existing.AuthMethod
+		_, err = dbmodel.UpdateUser(a.db, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	user.Password = ""
+	return user, nil
+}
@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Entry held by the fake LDAP server.
+type fakeLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// In-process LDAP server supporting simple binds and searches with the
+// equality filters. It is sufficient to test the LDAP authentication.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []fakeLDAPEntry
+	wg       sync.WaitGroup
+}
+
+// Starts the fake LDAP server listening on a random port.
+func newFakeLDAPServer(t *testing.T, entries []fakeLDAPEntry) *fakeLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeLDAPServer{
+		listener: listener,
+		entries:  entries,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+// Returns the URL of the fake server.
+func (s *fakeLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Stops the fake server.
+func (s *fakeLDAPServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Creates the LDAP message with the response to the request with the
+// given message ID. The response must be complete because its encoded
+// contents are copied to the message.
+func newLDAPMessage(messageID int64, response *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(response)
+	return packet
+}
+
+// Creates the response with the given result code.
+func newLDAPResult(tag ber.Tag, resultCode uint16) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return response
+}
+
+// Serves the requests received over the connection until it is closed.
+func (s *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			resultCode := uint16(ldap.LDAPResultInvalidCredentials)
+			if dn == "cn=stork,dc=example,dc=org" && password == "secret" {
+				resultCode = ldap.LDAPResultSuccess
+			}
+			for _, entry := range s.entries {
+				if entry.dn == dn && entry.password == password {
+					resultCode = ldap.LDAPResultSuccess
+				}
+			}
+			bound = resultCode == ldap.LDAPResultSuccess
+			response := newLDAPMessage(messageID, newLDAPResult(ldap.ApplicationBindResponse, resultCode))
+			_, _ = conn.Write(response.Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				response := newLDAPMessage(messageID, newLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				_, _ = conn.Write(response.Bytes())
+				continue
+			}
+			baseDN, _ := request.Children[0].Value.(string)
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			for _, entry := range s.entries {
+				if !strings.HasSuffix(entry.dn, baseDN) || !entryMatches(&entry, filter) {
+					continue
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+				attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for name, values := range entry.attributes {
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Name"))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				result.AppendChild(attributes)
+				_, _ = conn.Write(newLDAPMessage(messageID, result).Bytes())
+			}
+			response := newLDAPMessage(messageID, newLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+			_, _ = conn.Write(response.Bytes())
+
+		default:
+			// Unbind and everything else closes the connection.
+			return
+		}
+	}
+}
+
+// Checks if the entry matches the filter in the form of (attribute=value).
+func entryMatches(entry *fakeLDAPEntry, filter string) bool {
+	parts := strings.SplitN(strings.Trim(filter, "()"), "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	for _, value := range entry.attributes[parts[0]] {
+		if value == parts[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the users held by the fake LDAP server.
+func fakeLDAPEntries() []fakeLDAPEntry {
+	return []fakeLDAPEntry{
+		{
+			dn:       "uid=jdoe,ou=users,dc=example,dc=org",
+			password: "pass",
+			attributes: map[string][]string{
+				"uid":       {"jdoe"},
+				"mail":      {"jdoe@example.org"},
+				"givenName": {"John"},
+				"sn":        {"Doe"},
+				"memberOf":  {"CN=dhcp,ou=groups,dc=example,dc=org", "cn=other,ou=groups,dc=example,dc=org"},
+			},
+		},
+		{
+			dn:       "uid=nogroup,ou=users,dc=example,dc=org",
+			password: "pass",
+			attributes: map[string][]string{
+				"uid":      {"nogroup"},
+				"memberOf": {"cn=other,ou=groups,dc=example,dc=org"},
+			},
+		},
+		{
+			dn:       "uid=admin,ou=users,dc=example,dc=org",
+			password: "pass",
+			attributes: map[string][]string{
+				"uid":      {"admin"},
+				"memberOf": {"cn=admins,ou=groups,dc=example,dc=org"},
+			},
+		},
+	}
+}
+
+// Returns the LDAP settings used in the tests.
+func fakeLDAPSettings(url string) *LDAPSettings {
+	return &LDAPSettings{
+		URL:               url,
+		BindDN:            "cn=stork,dc=example,dc=org",
+		BindPassword:      "secret",
+		UserBaseDN:        "ou=users,dc=example,dc=org",
+		UserFilter:        "(uid=%s)",
+		GroupAttribute:    "memberOf",
+		EmailAttribute:    "mail",
+		NameAttribute:     "givenName",
+		LastnameAttribute: "sn",
+		GroupMap: []string{
+			"dhcp-admin=cn=dhcp,ou=groups,dc=example,dc=org",
+			"super-admin=cn=admins,ou=groups,dc=example,dc=org",
+		},
+	}
+}
+
+// Test that invalid LDAP settings are rejected.
+func TestNewLDAPAuthenticator(t *testing.T) {
+	settings := fakeLDAPSettings("ldap://localhost")
+	a, err := NewLDAPAuthenticator(settings, nil)
+	require.NoError(t, err)
+	require.Len(t, a.groupMap, 2)
+	require.Equal(t, "dhcp-admin", a.groupMap[0].groupName)
+
+	settings.GroupMap = []string{"dhcp-admin"}
+	_, err = NewLDAPAuthenticator(settings, nil)
+	require.Error(t, err)
+
+	settings.GroupMap = nil
+	_, err = NewLDAPAuthenticator(settings, nil)
+	require.Error(t, err)
+
+	settings = fakeLDAPSettings("ldap://localhost")
+	settings.UserFilter = "(uid=foo)"
+	_, err = NewLDAPAuthenticator(settings, nil)
+	require.Error(t, err)
+
+	settings = fakeLDAPSettings("")
+	_, err = NewLDAPAuthenticator(settings, nil)
+	require.Error(t, err)
+}
+
+// Test that the LDAP groups are mapped to the Stork groups regardless of
+// the case of the attribute types.
+func TestLDAPMapGroups(t *testing.T) {
+	a, err := NewLDAPAuthenticator(fakeLDAPSettings("ldap://localhost"), nil)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"dhcp-admin"}, a.mapGroups([]string{"CN=dhcp,OU=groups,DC=example,DC=org"}))
+	require.Equal(t, []string{"dhcp-admin", "super-admin"},
+		a.mapGroups([]string{"cn=admins,ou=groups,dc=example,dc=org", "cn=dhcp, ou=groups, dc=example, dc=org"}))
+	require.Empty(t, a.mapGroups([]string{"cn=other,ou=groups,dc=example,dc=org", "foo"}))
+}
+
+// Test that the users with invalid credentials or not belonging to any
+// mapped groups are rejected.
+func TestLDAPAuthenticateRejected(t *testing.T) {
+	server := newFakeLDAPServer(t, fakeLDAPEntries())
+	defer server.close()
+
+	// The users are rejected before they are looked up in the database,
+	// so no database is needed.
+	a, err := NewLDAPAuthenticator(fakeLDAPSettings(server.url()), nil)
+	require.NoError(t, err)
+
+	user, err := a.Authenticate("jdoe", "wrong")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	user, err = a.Authenticate("jdoe", "")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	user, err = a.Authenticate("foo", "pass")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	user, err = a.Authenticate("nogroup", "pass")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	// Filter injection is not possible.
+	user, err = a.Authenticate("*", "pass")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	// Searching fails when the server rejects the bind DN.
+	settings := fakeLDAPSettings(server.url())
+	settings.BindPassword = "wrong"
+	a, err = NewLDAPAuthenticator(settings, nil)
+	require.NoError(t, err)
+	_, err = a.Authenticate("jdoe", "pass")
+	require.Error(t, err)
+}
+
+// Test that the LDAP user is created in the database upon first login and
+// updated upon subsequent logins.
+func TestLDAPAuthenticateProvisioning(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	entries := fakeLDAPEntries()
+	server := newFakeLDAPServer(t, entries)
+	defer server.close()
+
+	a, err := NewLDAPAuthenticator(fakeLDAPSettings(server.url()), db)
+	require.NoError(t, err)
+
+	user, err := a.Authenticate("jdoe", "pass")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	require.NotZero(t, user.ID)
+	require.Equal(t, "jdoe", user.Login)
+	require.Equal(t, "jdoe@example.org", user.Email)
+	require.Equal(t, "John", user.Name)
+	require.Equal(t, "Doe", user.Lastname)
+	require.Empty(t, user.Password)
+	require.Len(t, user.Groups, 1)
+	require.Equal(t, "dhcp-admin", user.Groups[0].Name)
+
+	returned, err := dbmodel.GetUserByLogin(db, "jdoe")
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, user.ID, returned.ID)
+	require.Equal(t, dbmodel.AuthMethodLDAP, returned.AuthMethod)
+	require.Len(t, returned.Groups, 1)
+
+	// The LDAP user can't sign in with the password stored in the database.
+	dbAuthenticator := NewDatabaseAuthenticator(db)
+	returned, err = dbAuthenticator.Authenticate("jdoe", "pass")
+	require.NoError(t, err)
+	require.Nil(t, returned)
+
+	// The changes in LDAP are applied upon next login.
+	entries[0].attributes["sn"] = []string{"Smith"}
+	entries[0].attributes["memberOf"] = []string{"cn=admins,ou=groups,dc=example,dc=org"}
+	user, err = a.Authenticate("jdoe", "pass")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+
+	returned, err = dbmodel.GetUserByLogin(db, "jdoe")
+	require.NoError(t, err)
+	require.Equal(t, "Smith", returned.Lastname)
+	require.Len(t, returned.Groups, 1)
+	require.Equal(t, dbmodel.SuperAdminGroupID, returned.Groups[0].ID)
+
+	// The local admin user can't be taken over via LDAP.
+	user, err = a.Authenticate("admin", "pass")
+	require.NoError(t, err)
+	require.Nil(t, user)
+	returned, err = dbmodel.GetUserByLogin(db, "admin")
+	require.NoError(t, err)
+	require.Equal(t, dbmodel.AuthMethodInternal, returned.AuthMethod)
+}
+
+// Test that the user can sign in with the password stored in the database.
+func TestDatabaseAuthenticate(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	a := NewDatabaseAuthenticator(db)
+	user, err := a.Authenticate("admin", "admin")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	require.Equal(t, 1, user.ID)
+	require.Empty(t, user.Password)
+	require.True(t, user.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID}))
+
+	user, err = a.Authenticate("admin", "wrong")
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	user, err = a.Authenticate("foo@example.org", "admin")
+	require.NoError(t, err)
+	require.Nil(t, user)
+}
@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/pki"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Generates a new random token the agents present to the server when
+// they enroll.
+func generateServerToken() ([]byte, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with generating server token")
+	}
+	return []byte(hex.EncodeToString(buf)), nil
+}
+
+// Makes sure that the root CA key and certificate exist in the database.
+// They are generated when the server is started for the first time.
+func setupRootCA(db *dbops.PgDB) (caKeyPEM, caCertPEM []byte, err error) {
+	caKeyPEM, err = dbmodel.GetSecret(db, dbmodel.SecretCAKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCertPEM, err = dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if caKeyPEM != nil && caCertPEM != nil {
+		return caKeyPEM, caCertPEM, nil
+	}
+
+	log.Info("generating root CA key and certificate")
+	serial, err := dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKeyPEM, caCertPEM, err = pki.GenCAKeyCert(serial)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dbmodel.SetSecret(db, dbmodel.SecretCAKey, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dbmodel.SetSecret(db, dbmodel.SecretCACert, caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	return caKeyPEM, caCertPEM, nil
+}
+
+// Makes sure that the server key and certificate exist in the database.
+// The certificate is issued again when it is missing or when it is due
+// for renewal.
+func setupServerKeyCert(db *dbops.PgDB, caKeyPEM, caCertPEM []byte) (serverKeyPEM, serverCertPEM []byte, err error) {
+	serverKeyPEM, err = dbmodel.GetSecret(db, dbmodel.SecretServerKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverCertPEM, err = dbmodel.GetSecret(db, dbmodel.SecretServerCert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if serverKeyPEM != nil && serverCertPEM != nil {
+		serverCert, err := pki.ParseCert(serverCertPEM)
+		if err != nil {
+			log.Warnf("server certificate is broken and will be regenerated: %+v", err)
+		} else if !pki.NeedsRenewal(serverCert, time.Now()) {
+			return serverKeyPEM, serverCertPEM, nil
+		}
+	}
+
+	log.Info("generating server key and certificate")
+	serial, err := dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverKeyPEM, serverCertPEM, err = pki.GenServerKeyCert(serial, caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dbmodel.SetSecret(db, dbmodel.SecretServerKey, serverKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = dbmodel.SetSecret(db, dbmodel.SecretServerCert, serverCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	return serverKeyPEM, serverCertPEM, nil
+}
+
+// Prepares the keys, certificates and the server token used for
+// securing the communication with the agents. They are generated
+// upon the first start of the server and stored in the database.
+// It returns PEM encoded root CA certificate, server certificate
+// and server key.
+func SetupServerCerts(db *dbops.PgDB) (caCertPEM, serverCertPEM, serverKeyPEM []byte, err error) {
+	caKeyPEM, caCertPEM, err := setupRootCA(db)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessagef(err, "problem with setting up root CA")
+	}
+
+	serverKeyPEM, serverCertPEM, err = setupServerKeyCert(db, caKeyPEM, caCertPEM)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessagef(err, "problem with setting up server certificate")
+	}
+
+	token, err := dbmodel.GetSecret(db, dbmodel.SecretServerToken)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if token == nil {
+		_, err = RegenerateServerToken(db)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return caCertPEM, serverCertPEM, serverKeyPEM, nil
+}
+
+// Generates a new server token and stores it in the database. The
+// token used previously is not accepted anymore.
+func RegenerateServerToken(db *dbops.PgDB) ([]byte, error) {
+	token, err := generateServerToken()
+	if err != nil {
+		return nil, err
+	}
+	err = dbmodel.SetSecret(db, dbmodel.SecretServerToken, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Issues a certificate for the agent reachable at the given address
+// based on the CSR it sent. It returns PEM encoded agent certificate,
+// its serial number and PEM encoded root CA certificate which the agent
+// uses to verify the server.
+func SignAgentCSR(db *dbops.PgDB, address string, csrPEM []byte) (agentCertPEM []byte, serial int64, caCertPEM []byte, err error) {
+	caKeyPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCAKey)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	caCertPEM, err = dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if caKeyPEM == nil || caCertPEM == nil {
+		return nil, 0, nil, errors.New("root CA is not set up")
+	}
+
+	serial, err = dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	agentCertPEM, err = pki.SignAgentCSR(csrPEM, address, serial, caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, 0, nil, errors.WithMessagef(err, "problem with issuing certificate for agent %s", address)
+	}
+	return agentCertPEM, serial, caCertPEM, nil
+}
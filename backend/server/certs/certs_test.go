@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the certificates are generated upon the first start and
+// reused later.
+func TestSetupServerCerts(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	caCertPEM, serverCertPEM, serverKeyPEM, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	require.NotEmpty(t, caCertPEM)
+	require.NotEmpty(t, serverCertPEM)
+	require.NotEmpty(t, serverKeyPEM)
+
+	serverCert, err := pki.ParseCert(serverCertPEM)
+	require.NoError(t, err)
+	require.Equal(t, pki.ServerCommonName, serverCert.Subject.CommonName)
+
+	token, err := dbmodel.GetSecret(db, dbmodel.SecretServerToken)
+	require.NoError(t, err)
+	require.Len(t, token, 64)
+
+	// Subsequent call should return the same data.
+	caCertPEM2, serverCertPEM2, serverKeyPEM2, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	require.Equal(t, caCertPEM, caCertPEM2)
+	require.Equal(t, serverCertPEM, serverCertPEM2)
+	require.Equal(t, serverKeyPEM, serverKeyPEM2)
+
+	token2, err := dbmodel.GetSecret(db, dbmodel.SecretServerToken)
+	require.NoError(t, err)
+	require.Equal(t, token, token2)
+
+	// Regenerating the token should replace the old one.
+	token3, err := RegenerateServerToken(db)
+	require.NoError(t, err)
+	require.NotEqual(t, token, token3)
+}
+
+// Test that the agent certificate is issued with a unique serial number.
+func TestSignAgentCSR(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// The CA doesn't exist yet.
+	_, csrPEM, err := pki.GenKeyAndCSR("agent", nil, nil)
+	require.NoError(t, err)
+	_, _, _, err = SignAgentCSR(db, "192.0.2.1", csrPEM)
+	require.Error(t, err)
+
+	caCertPEM, _, _, err := SetupServerCerts(db)
+	require.NoError(t, err)
+
+	agentCertPEM, serial, caCertPEM2, err := SignAgentCSR(db, "192.0.2.1", csrPEM)
+	require.NoError(t, err)
+	require.Equal(t, caCertPEM, caCertPEM2)
+	agentCert, err := pki.ParseCert(agentCertPEM)
+	require.NoError(t, err)
+	require.Equal(t, serial, agentCert.SerialNumber.Int64())
+
+	_, serial2, _, err := SignAgentCSR(db, "192.0.2.1", csrPEM)
+	require.NoError(t, err)
+	require.NotEqual(t, serial, serial2)
+
+	// Garbage instead of CSR.
+	_, _, _, err = SignAgentCSR(db, "192.0.2.1", []byte("foo"))
+	require.Error(t, err)
+}
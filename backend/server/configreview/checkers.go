@@ -0,0 +1,176 @@
+package configreview
+
+import (
+	"fmt"
+	"net"
+
+	dbmodel "isc.org/stork/server/database/model"
+	storkutil "isc.org/stork/util"
+)
+
+func init() {
+	dhcpDaemons := []string{dbmodel.DaemonNameDHCPv4, dbmodel.DaemonNameDHCPv6}
+	RegisterChecker("subnets_overlap", "Checks if the subnets overlap.",
+		dhcpDaemons, checkSubnetsOverlap)
+	RegisterChecker("pools_outside_subnet", "Checks if the address pools belong to their subnets.",
+		dhcpDaemons, checkPoolsOutsideSubnet)
+	RegisterChecker("reservations_in_pools", "Checks if the reserved addresses and prefixes belong to the dynamic pools.",
+		dhcpDaemons, checkReservationsInPools)
+	RegisterChecker("lease_cmds_presence", "Checks if the lease_cmds hooks library is loaded along with the host_cmds hooks library.",
+		dhcpDaemons, checkLeaseCmdsPresence)
+	RegisterChecker("control_socket", "Checks if the control socket the Control Agent uses to reach the daemon is configured.",
+		[]string{dbmodel.DaemonNameDHCPv4, dbmodel.DaemonNameDHCPv6, "d2"}, checkControlSocket)
+}
+
+// Returns the top level map of the configuration, e.g. the map under
+// the Dhcp4 key.
+func getRootNode(config *dbmodel.KeaConfig) map[string]interface{} {
+	root, ok := config.GetRootName()
+	if !ok {
+		return nil
+	}
+	rootNode, _ := (*config)[root].(map[string]interface{})
+	return rootNode
+}
+
+// Returns the bounds of the address pool or nil if the pool is invalid.
+func getPoolBounds(pool string) (net.IP, net.IP) {
+	addressPool, err := dbmodel.NewAddressPoolFromRange(pool)
+	if err != nil {
+		return nil, nil
+	}
+	return net.ParseIP(addressPool.LowerBound), net.ParseIP(addressPool.UpperBound)
+}
+
+// Checks if the networks overlap.
+func networksOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Reports the subnets which overlap with other subnets. The overlapping
+// subnets are usually a mistake, because Kea selects the first matching
+// subnet for the client.
+func checkSubnetsOverlap(ctx *ReviewContext) ([]string, error) {
+	subnets := ctx.Config.GetSubnets()
+	networks := make([]*net.IPNet, len(subnets))
+	for i, subnet := range subnets {
+		_, networks[i], _ = net.ParseCIDR(subnet.Subnet)
+	}
+	var findings []string
+	for i := range subnets {
+		for j := i + 1; j < len(subnets); j++ {
+			if networks[i] == nil || networks[j] == nil || !networksOverlap(networks[i], networks[j]) {
+				continue
+			}
+			findings = append(findings, fmt.Sprintf("subnet %s (id %d) overlaps with subnet %s (id %d)",
+				subnets[i].Subnet, subnets[i].ID, subnets[j].Subnet, subnets[j].ID))
+		}
+	}
+	return findings, nil
+}
+
+// Reports the address pools which are invalid or do not belong to their
+// subnets.
+func checkPoolsOutsideSubnet(ctx *ReviewContext) ([]string, error) {
+	var findings []string
+	for _, subnet := range ctx.Config.GetSubnets() {
+		_, network, err := net.ParseCIDR(subnet.Subnet)
+		if err != nil {
+			continue
+		}
+		for _, pool := range subnet.Pools {
+			lower, upper := getPoolBounds(pool.Pool)
+			if lower == nil || upper == nil {
+				findings = append(findings, fmt.Sprintf("pool %s in subnet %s (id %d) is invalid",
+					pool.Pool, subnet.Subnet, subnet.ID))
+				continue
+			}
+			if !network.Contains(lower) || !network.Contains(upper) {
+				findings = append(findings, fmt.Sprintf("pool %s is outside of subnet %s (id %d)",
+					pool.Pool, subnet.Subnet, subnet.ID))
+			}
+		}
+	}
+	return findings, nil
+}
+
+// Reports the reserved addresses belonging to the address pools and the
+// reserved prefixes overlapping with the prefix pools. Such reservations
+// are allowed by Kea, but they require checking the reservations for each
+// allocated lease, and the reserved resource may be in use by other client
+// when its owner appears.
+func checkReservationsInPools(ctx *ReviewContext) ([]string, error) {
+	var findings []string
+	for _, subnet := range ctx.Config.GetSubnets() {
+		for _, reservation := range subnet.Reservations {
+			// Copy the addresses to not modify the reservation.
+			addresses := append([]string{}, reservation.IPAddresses...)
+			if reservation.IPAddress != "" {
+				addresses = append(addresses, reservation.IPAddress)
+			}
+			for _, address := range addresses {
+				ip := net.ParseIP(address)
+				for _, pool := range subnet.Pools {
+					lower, upper := getPoolBounds(pool.Pool)
+					if storkutil.IPInRange(ip, lower, upper) {
+						findings = append(findings, fmt.Sprintf("reserved address %s in subnet %s (id %d) belongs to pool %s",
+							address, subnet.Subnet, subnet.ID, pool.Pool))
+					}
+				}
+			}
+			for _, prefix := range reservation.Prefixes {
+				_, prefixNet, err := net.ParseCIDR(prefix)
+				if err != nil {
+					continue
+				}
+				for _, pool := range subnet.PdPools {
+					poolPrefix := fmt.Sprintf("%s/%d", pool.Prefix, pool.PrefixLen)
+					_, poolNet, err := net.ParseCIDR(poolPrefix)
+					if err != nil {
+						continue
+					}
+					if networksOverlap(prefixNet, poolNet) {
+						findings = append(findings, fmt.Sprintf("reserved prefix %s in subnet %s (id %d) overlaps with prefix pool %s",
+							prefix, subnet.Subnet, subnet.ID, poolPrefix))
+					}
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+// Reports the daemon using the host_cmds hooks library without the
+// lease_cmds hooks library. Stork can manage the host reservations of such
+// daemon but not its leases.
+func checkLeaseCmdsPresence(ctx *ReviewContext) ([]string, error) {
+	if _, _, ok := ctx.Config.GetHooksLibrary("libdhcp_host_cmds"); !ok {
+		return nil, nil
+	}
+	if _, _, ok := ctx.Config.GetHooksLibrary("libdhcp_lease_cmds"); ok {
+		return nil, nil
+	}
+	return []string{"the host_cmds hooks library is loaded but the lease_cmds hooks library is not, so the leases can't be managed"}, nil
+}
+
+// Reports the daemon without the control socket or with the control socket
+// of the unsupported type. The Control Agent can't forward the commands to
+// such daemon.
+func checkControlSocket(ctx *ReviewContext) ([]string, error) {
+	rootNode := getRootNode(ctx.Config)
+	if rootNode == nil {
+		return nil, nil
+	}
+	socket, ok := rootNode["control-socket"].(map[string]interface{})
+	if !ok {
+		return []string{"the control socket is not configured, so the daemon is unreachable via the Control Agent"}, nil
+	}
+	var findings []string
+	if socketType, _ := socket["socket-type"].(string); socketType != "unix" {
+		findings = append(findings, fmt.Sprintf("the control socket type %q is not supported by the Control Agent", socketType))
+	}
+	if socketName, _ := socket["socket-name"].(string); socketName == "" {
+		findings = append(findings, "the control socket name is not configured, so the daemon is unreachable via the Control Agent")
+	}
+	return findings, nil
+}
@@ -0,0 +1,162 @@
+package configreview
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Returns the review context for the given configuration.
+func newTestReviewContext(t *testing.T, config string) *ReviewContext {
+	keaConfig, err := dbmodel.NewKeaConfigFromJSON(config)
+	require.NoError(t, err)
+	return &ReviewContext{
+		Config: keaConfig,
+	}
+}
+
+// Test that the overlapping subnets are reported, including the subnets
+// belonging to the shared networks.
+func TestCheckSubnetsOverlap(t *testing.T) {
+	ctx := newTestReviewContext(t, `{
+        "Dhcp4": {
+            "subnet4": [
+                { "id": 1, "subnet": "192.0.2.0/24" },
+                { "id": 2, "subnet": "192.0.3.0/24" }
+            ],
+            "shared-networks": [
+                {
+                    "name": "foo",
+                    "subnet4": [
+                        { "id": 3, "subnet": "192.0.2.128/25" }
+                    ]
+                }
+            ]
+        }
+    }`)
+	findings, err := checkSubnetsOverlap(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"subnet 192.0.2.0/24 (id 1) overlaps with subnet 192.0.2.128/25 (id 3)"}, findings)
+}
+
+// Test that the pools outside of their subnets are reported.
+func TestCheckPoolsOutsideSubnet(t *testing.T) {
+	ctx := newTestReviewContext(t, `{
+        "Dhcp6": {
+            "subnet6": [
+                {
+                    "id": 1,
+                    "subnet": "2001:db8:1::/64",
+                    "pools": [
+                        { "pool": "2001:db8:1::10-2001:db8:1::20" },
+                        { "pool": "2001:db8:2::/80" },
+                        { "pool": "foo" }
+                    ]
+                }
+            ]
+        }
+    }`)
+	findings, err := checkPoolsOutsideSubnet(ctx)
+	require.NoError(t, err)
+	require.Len(t, findings, 2)
+	require.Equal(t, "pool 2001:db8:2::/80 is outside of subnet 2001:db8:1::/64 (id 1)", findings[0])
+	require.Equal(t, "pool foo in subnet 2001:db8:1::/64 (id 1) is invalid", findings[1])
+}
+
+// Test that the reservations within the dynamic pools are reported.
+func TestCheckReservationsInPools(t *testing.T) {
+	ctx := newTestReviewContext(t, `{
+        "Dhcp6": {
+            "subnet6": [
+                {
+                    "id": 1,
+                    "subnet": "2001:db8:1::/64",
+                    "pools": [
+                        { "pool": "2001:db8:1::10-2001:db8:1::20" }
+                    ],
+                    "pd-pools": [
+                        { "prefix": "3000::", "prefix-len": 48, "delegated-len": 64 }
+                    ],
+                    "reservations": [
+                        {
+                            "duid": "01:02:03",
+                            "ip-addresses": [ "2001:db8:1::15", "2001:db8:1::30" ],
+                            "prefixes": [ "3000:0:0:1::/64", "3001::/64" ]
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	findings, err := checkReservationsInPools(ctx)
+	require.NoError(t, err)
+	require.Len(t, findings, 2)
+	require.Equal(t, "reserved address 2001:db8:1::15 in subnet 2001:db8:1::/64 (id 1) belongs to pool 2001:db8:1::10-2001:db8:1::20", findings[0])
+	require.Equal(t, "reserved prefix 3000:0:0:1::/64 in subnet 2001:db8:1::/64 (id 1) overlaps with prefix pool 3000::/48", findings[1])
+}
+
+// Test that the missing lease_cmds hooks library is reported when the
+// host_cmds hooks library is loaded.
+func TestCheckLeaseCmdsPresence(t *testing.T) {
+	ctx := newTestReviewContext(t, `{
+        "Dhcp4": {
+            "hooks-libraries": [
+                { "library": "/usr/lib/kea/libdhcp_host_cmds.so" }
+            ]
+        }
+    }`)
+	findings, err := checkLeaseCmdsPresence(ctx)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+
+	ctx = newTestReviewContext(t, `{
+        "Dhcp4": {
+            "hooks-libraries": [
+                { "library": "/usr/lib/kea/libdhcp_host_cmds.so" },
+                { "library": "/usr/lib/kea/libdhcp_lease_cmds.so" }
+            ]
+        }
+    }`)
+	findings, err = checkLeaseCmdsPresence(ctx)
+	require.NoError(t, err)
+	require.Empty(t, findings)
+
+	ctx = newTestReviewContext(t, `{ "Dhcp4": { } }`)
+	findings, err = checkLeaseCmdsPresence(ctx)
+	require.NoError(t, err)
+	require.Empty(t, findings)
+}
+
+// Test that the missing or misconfigured control socket is reported.
+func TestCheckControlSocket(t *testing.T) {
+	ctx := newTestReviewContext(t, `{ "Dhcp4": { } }`)
+	findings, err := checkControlSocket(ctx)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+
+	ctx = newTestReviewContext(t, `{
+        "Dhcp4": {
+            "control-socket": {
+                "socket-type": "tcp"
+            }
+        }
+    }`)
+	findings, err = checkControlSocket(ctx)
+	require.NoError(t, err)
+	require.Len(t, findings, 2)
+	require.Equal(t, `the control socket type "tcp" is not supported by the Control Agent`, findings[0])
+
+	ctx = newTestReviewContext(t, `{
+        "Dhcp4": {
+            "control-socket": {
+                "socket-type": "unix",
+                "socket-name": "/tmp/kea4-ctrl-socket"
+            }
+        }
+    }`)
+	findings, err = checkControlSocket(ctx)
+	require.NoError(t, err)
+	require.Empty(t, findings)
+}
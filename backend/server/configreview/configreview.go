@@ -0,0 +1,149 @@
+package configreview
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Data available to the checker reviewing the configuration of the
+// daemon. The checkers must not modify it.
+type ReviewContext struct {
+	DB     *dbops.PgDB
+	App    *dbmodel.App
+	Daemon *dbmodel.Daemon
+	Config *dbmodel.KeaConfig
+}
+
+// Function reviewing the configuration. It returns the texts of the
+// findings, i.e. the issues found in the configuration. The error is
+// returned when the checker fails, not when it finds issues.
+type CheckerFunc func(ctx *ReviewContext) ([]string, error)
+
+// Configuration checker. The daemon names are the names of the daemons
+// the checker is applicable to.
+type Checker struct {
+	Name        string
+	Description string
+	DaemonNames []string
+	check       CheckerFunc
+}
+
+// Registered checkers ordered by name.
+var checkers []*Checker
+
+// Registers the checker, so it is run during the reviews of the
+// configurations of the given daemons. The checkers are enabled unless
+// disabled by the user. The checker name must be unique.
+func RegisterChecker(name, description string, daemonNames []string, check CheckerFunc) {
+	for _, c := range checkers {
+		if c.Name == name {
+			panic("configuration checker " + name + " registered twice")
+		}
+	}
+	checkers = append(checkers, &Checker{
+		Name:        name,
+		Description: description,
+		DaemonNames: daemonNames,
+		check:       check,
+	})
+	sort.Slice(checkers, func(i, j int) bool {
+		return checkers[i].Name < checkers[j].Name
+	})
+}
+
+// Returns the registered checkers ordered by name.
+func GetCheckers() []*Checker {
+	return checkers
+}
+
+// Returns the registered checker with the given name or nil.
+func GetChecker(name string) *Checker {
+	for _, c := range checkers {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Checks if the checker is applicable to the given daemon.
+func (c *Checker) appliesTo(daemonName string) bool {
+	for _, name := range c.DaemonNames {
+		if name == daemonName {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of the checkers disabled by the users.
+func getDisabledCheckers(db *dbops.PgDB) (map[string]bool, error) {
+	states, err := dbmodel.GetConfigCheckers(db)
+	if err != nil {
+		return nil, err
+	}
+	disabled := map[string]bool{}
+	for _, state := range states {
+		if !state.Enabled {
+			disabled[state.Name] = true
+		}
+	}
+	return disabled, nil
+}
+
+// Runs the enabled checkers over the configuration of the daemon and
+// replaces the findings of the previous review with the new ones. The
+// failure of a checker is logged and the other checkers are still run.
+// The daemons without the configuration are not reviewed.
+func ReviewDaemon(db *dbops.PgDB, app *dbmodel.App, daemon *dbmodel.Daemon) error {
+	if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil {
+		return nil
+	}
+	disabled, err := getDisabledCheckers(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := &ReviewContext{
+		DB:     db,
+		App:    app,
+		Daemon: daemon,
+		Config: daemon.KeaDaemon.Config,
+	}
+	findings := []dbmodel.ConfigReviewFinding{}
+	for _, c := range checkers {
+		if disabled[c.Name] || !c.appliesTo(daemon.Name) {
+			continue
+		}
+		texts, err := c.check(ctx)
+		if err != nil {
+			log.Warnf("configuration checker %s failed for %s daemon of app %d: %s",
+				c.Name, daemon.Name, app.ID, err)
+			continue
+		}
+		for _, text := range texts {
+			findings = append(findings, dbmodel.ConfigReviewFinding{
+				Checker: c.Name,
+				Text:    text,
+			})
+		}
+	}
+	return dbmodel.ReplaceConfigReviewFindings(db, app.ID, daemon.Name, findings)
+}
+
+// Reviews the configurations of all daemons of the app.
+func ReviewApp(db *dbops.PgDB, app *dbmodel.App) error {
+	for _, daemon := range app.Daemons {
+		err := ReviewDaemon(db, app, daemon)
+		if err != nil {
+			return errors.WithMessagef(err, "problem with reviewing configuration of %s daemon of app %d",
+				daemon.Name, app.ID)
+		}
+	}
+	return nil
+}
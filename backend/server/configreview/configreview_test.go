@@ -0,0 +1,90 @@
+package configreview
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the built-in checkers are registered and can be found by name.
+func TestGetCheckers(t *testing.T) {
+	registered := GetCheckers()
+	require.GreaterOrEqual(t, len(registered), 5)
+	for i := 1; i < len(registered); i++ {
+		require.Less(t, registered[i-1].Name, registered[i].Name)
+	}
+
+	checker := GetChecker("control_socket")
+	require.NotNil(t, checker)
+	require.True(t, checker.appliesTo("d2"))
+	require.False(t, GetChecker("subnets_overlap").appliesTo("d2"))
+	require.Nil(t, GetChecker("foo"))
+
+	require.Panics(t, func() {
+		RegisterChecker("control_socket", "", nil, checkControlSocket)
+	})
+}
+
+// Test that the enabled checkers review the daemon's configuration and
+// the findings are stored in the database.
+func TestReviewDaemon(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	config, err := dbmodel.NewKeaConfigFromJSON(`{
+        "Dhcp4": {
+            "subnet4": [
+                { "id": 1, "subnet": "192.0.2.0/24" },
+                { "id": 2, "subnet": "192.0.2.0/25" }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name: "dhcp4",
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config: config,
+				},
+			},
+			{
+				Name: "ca",
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	err = ReviewApp(db, app)
+	require.NoError(t, err)
+
+	findings, err := dbmodel.GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, findings, 2)
+	require.Equal(t, "control_socket", findings[0].Checker)
+	require.Equal(t, "subnets_overlap", findings[1].Checker)
+
+	// The disabled checker doesn't report the findings.
+	err = dbmodel.SetConfigCheckerEnabled(db, "control_socket", false)
+	require.NoError(t, err)
+	err = ReviewDaemon(db, app, app.Daemons[0])
+	require.NoError(t, err)
+
+	findings, err = dbmodel.GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	require.Equal(t, "subnets_overlap", findings[0].Checker)
+}
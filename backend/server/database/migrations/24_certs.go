@@ -0,0 +1,38 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Secrets such as keys and certificates of the internal CA.
+             CREATE TABLE IF NOT EXISTS secret (
+                 name TEXT NOT NULL PRIMARY KEY,
+                 content TEXT NOT NULL
+             );
+
+             -- Serial numbers of the certificates issued by the internal CA.
+             CREATE SEQUENCE IF NOT EXISTS certs_serial_number_seq;
+
+             -- Certificates which must not be accepted anymore.
+             CREATE TABLE IF NOT EXISTS revoked_cert (
+                 serial_number BIGINT NOT NULL PRIMARY KEY,
+                 revoked_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now())
+             );
+
+             -- Serial number of the certificate issued to the agent.
+             ALTER TABLE machine ADD COLUMN IF NOT EXISTS agent_cert_serial BIGINT;
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE machine DROP COLUMN IF EXISTS agent_cert_serial;
+             DROP TABLE IF EXISTS revoked_cert;
+             DROP SEQUENCE IF EXISTS certs_serial_number_seq;
+             DROP TABLE IF EXISTS secret;
+           `)
+		return err
+	})
+}
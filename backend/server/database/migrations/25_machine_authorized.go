@@ -0,0 +1,28 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Machines registered by the agents wait for the approval
+             -- of an administrator. Machines which already exist were
+             -- added by the administrators, so they are authorized.
+             ALTER TABLE machine ADD COLUMN IF NOT EXISTS authorized BOOLEAN NOT NULL DEFAULT FALSE;
+             UPDATE machine SET authorized = TRUE;
+
+             -- Token generated by the agent, which allows the agent to
+             -- register again without the server token.
+             ALTER TABLE machine ADD COLUMN IF NOT EXISTS agent_token TEXT;
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE machine DROP COLUMN IF EXISTS agent_token;
+             ALTER TABLE machine DROP COLUMN IF EXISTS authorized;
+           `)
+		return err
+	})
+}
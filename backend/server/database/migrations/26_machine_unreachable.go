@@ -0,0 +1,22 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Time when the server failed to reach the agent for the first
+             -- time since the agent was last reachable. NULL when the agent
+             -- is reachable.
+             ALTER TABLE machine ADD COLUMN IF NOT EXISTS unreachable_since TIMESTAMP WITHOUT TIME ZONE;
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE machine DROP COLUMN IF EXISTS unreachable_since;
+           `)
+		return err
+	})
+}
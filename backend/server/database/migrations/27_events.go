@@ -0,0 +1,54 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the events which happened in the monitored
+             -- network, e.g. detected or removed apps, HA state transitions.
+             -- The events are kept after the objects they refer to are deleted.
+             CREATE TABLE IF NOT EXISTS event (
+                 id bigserial NOT NULL,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 text TEXT NOT NULL,
+                 level INTEGER NOT NULL DEFAULT 0,
+                 details TEXT,
+                 machine_id bigint NULL,
+                 app_id bigint NULL,
+                 daemon_id bigint NULL,
+                 subnet_id bigint NULL,
+                 CONSTRAINT event_pkey PRIMARY KEY (id),
+                 CONSTRAINT event_machine_id_fkey FOREIGN KEY (machine_id)
+                     REFERENCES machine (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE SET NULL,
+                 CONSTRAINT event_app_id_fkey FOREIGN KEY (app_id)
+                     REFERENCES app (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE SET NULL,
+                 CONSTRAINT event_daemon_id_fkey FOREIGN KEY (daemon_id)
+                     REFERENCES daemon (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE SET NULL,
+                 CONSTRAINT event_subnet_id_fkey FOREIGN KEY (subnet_id)
+                     REFERENCES subnet (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE SET NULL
+             );
+             CREATE INDEX event_created_at_idx ON event (created_at);
+             CREATE INDEX event_machine_id_idx ON event (machine_id);
+             CREATE INDEX event_app_id_idx ON event (app_id);
+             CREATE INDEX event_daemon_id_idx ON event (daemon_id);
+             CREATE INDEX event_subnet_id_idx ON event (subnet_id);
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS event;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,71 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Rules evaluated periodically against the data collected by
+             -- the pullers. The kind of the rule determines which objects
+             -- it checks, e.g. subnets or HA services.
+             CREATE TABLE IF NOT EXISTS alert_rule (
+                 id bigserial NOT NULL,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 name TEXT NOT NULL,
+                 kind TEXT NOT NULL,
+                 threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+                 clear_threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+                 for_evaluations INTEGER NOT NULL DEFAULT 1,
+                 enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                 silenced_until TIMESTAMP WITHOUT TIME ZONE,
+                 CONSTRAINT alert_rule_pkey PRIMARY KEY (id),
+                 CONSTRAINT alert_rule_name_unique UNIQUE (name)
+             );
+
+             -- Channels the notifications about the alerts are sent to. The
+             -- parameters depend on the channel type.
+             CREATE TABLE IF NOT EXISTS alert_channel (
+                 id bigserial NOT NULL,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 name TEXT NOT NULL,
+                 type TEXT NOT NULL,
+                 enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                 params JSONB,
+                 CONSTRAINT alert_channel_pkey PRIMARY KEY (id),
+                 CONSTRAINT alert_channel_name_unique UNIQUE (name)
+             );
+
+             -- State of the rule for a single object. The object key is
+             -- stable across the updates of the object, e.g. the daemons
+             -- are identified by the app and the daemon name.
+             CREATE TABLE IF NOT EXISTS alert (
+                 id bigserial NOT NULL,
+                 rule_id bigint NOT NULL,
+                 object_key TEXT NOT NULL,
+                 description TEXT,
+                 value DOUBLE PRECISION NOT NULL DEFAULT 0,
+                 firing BOOLEAN NOT NULL DEFAULT FALSE,
+                 pending_count INTEGER NOT NULL DEFAULT 0,
+                 fired_at TIMESTAMP WITHOUT TIME ZONE,
+                 resolved_at TIMESTAMP WITHOUT TIME ZONE,
+                 silenced_until TIMESTAMP WITHOUT TIME ZONE,
+                 CONSTRAINT alert_pkey PRIMARY KEY (id),
+                 CONSTRAINT alert_rule_id_object_key_unique UNIQUE (rule_id, object_key),
+                 CONSTRAINT alert_rule_id_fkey FOREIGN KEY (rule_id)
+                     REFERENCES alert_rule (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE
+             );
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS alert;
+             DROP TABLE IF EXISTS alert_channel;
+             DROP TABLE IF EXISTS alert_rule;
+           `)
+		return err
+	})
+}
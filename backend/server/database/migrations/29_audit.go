@@ -0,0 +1,41 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the audit trail of the changes made by the
+             -- users via the ReST API. The login of the user is copied so
+             -- the entries remain meaningful after the user is deleted.
+             CREATE TABLE IF NOT EXISTS audit_entry (
+                 id bigserial NOT NULL,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 user_id INTEGER NULL,
+                 user_login TEXT,
+                 method TEXT NOT NULL,
+                 endpoint TEXT NOT NULL,
+                 status_code INTEGER NOT NULL DEFAULT 0,
+                 object_type TEXT,
+                 object_id BIGINT,
+                 diff JSONB,
+                 CONSTRAINT audit_entry_pkey PRIMARY KEY (id),
+                 CONSTRAINT audit_entry_user_id_fkey FOREIGN KEY (user_id)
+                     REFERENCES system_user (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE SET NULL
+             );
+             CREATE INDEX audit_entry_created_at_idx ON audit_entry (created_at);
+             CREATE INDEX audit_entry_user_id_idx ON audit_entry (user_id);
+             CREATE INDEX audit_entry_object_idx ON audit_entry (object_type, object_id);
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS audit_entry;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,64 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+// This migration adds permission table holding the permissions granted to
+// the groups of users. The admin group is granted write access to all
+// resources except the users, the audit trail and the server token. The
+// new default groups allow for read only access and for managing DHCP or
+// DNS servers only.
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             CREATE TABLE IF NOT EXISTS permission (
+                 id serial NOT NULL,
+                 group_id INTEGER NOT NULL,
+                 resource TEXT NOT NULL,
+                 action TEXT NOT NULL,
+                 machine_id bigint NULL,
+                 app_id bigint NULL,
+                 CONSTRAINT permission_pkey PRIMARY KEY (id),
+                 CONSTRAINT permission_action_check CHECK (action IN ('read', 'write')),
+                 CONSTRAINT permission_group_id_fkey FOREIGN KEY (group_id)
+                     REFERENCES system_group (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT permission_machine_id_fkey FOREIGN KEY (machine_id)
+                     REFERENCES machine (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT permission_app_id_fkey FOREIGN KEY (app_id)
+                     REFERENCES app (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE
+             );
+             CREATE INDEX permission_group_id_idx ON permission (group_id);
+
+             ALTER TABLE system_group ADD CONSTRAINT system_group_name_key UNIQUE (name);
+
+             INSERT INTO system_group (name, description) VALUES
+                 ('read-only', 'This group of users can view all system components except user accounts.'),
+                 ('dhcp-admin', 'This group of users can view all system components and manage DHCP servers.'),
+                 ('dns-admin', 'This group of users can view all system components and manage DNS servers.');
+
+             INSERT INTO permission (group_id, resource, action)
+                 SELECT id, '*', 'write' FROM system_group WHERE name = 'admin';
+             INSERT INTO permission (group_id, resource, action)
+                 SELECT id, '*', 'read' FROM system_group WHERE name IN ('read-only', 'dhcp-admin', 'dns-admin');
+             INSERT INTO permission (group_id, resource, action)
+                 SELECT id, 'dhcp', 'write' FROM system_group WHERE name = 'dhcp-admin';
+             INSERT INTO permission (group_id, resource, action)
+                 SELECT id, 'dns', 'write' FROM system_group WHERE name = 'dns-admin';
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS permission;
+             DELETE FROM system_group WHERE name IN ('read-only', 'dhcp-admin', 'dns-admin');
+             ALTER TABLE system_group DROP CONSTRAINT IF EXISTS system_group_name_key;
+           `)
+		return err
+	})
+}
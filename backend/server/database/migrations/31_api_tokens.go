@@ -0,0 +1,38 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the tokens the users can use to access
+             -- the ReST API without signing in. Only the SHA-256 hashes
+             -- of the tokens are stored. The scopes limit the operations
+             -- which can be performed with the token.
+             CREATE TABLE IF NOT EXISTS api_token (
+                 id SERIAL PRIMARY KEY,
+                 user_id INTEGER NOT NULL,
+                 name TEXT NOT NULL,
+                 token_hash TEXT NOT NULL,
+                 scopes TEXT[],
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 expires_at TIMESTAMP WITHOUT TIME ZONE,
+                 last_used_at TIMESTAMP WITHOUT TIME ZONE,
+                 CONSTRAINT api_token_user_id_fkey FOREIGN KEY (user_id)
+                     REFERENCES system_user (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT api_token_user_id_name_key UNIQUE (user_id, name),
+                 CONSTRAINT api_token_token_hash_key UNIQUE (token_hash)
+             );
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS api_token;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,23 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Indicates how the user is authenticated. The users created
+             -- by the administrators are authenticated with the passwords
+             -- stored in the database. The users signing in via LDAP are
+             -- created upon first login.
+             ALTER TABLE system_user ADD COLUMN auth_method TEXT NOT NULL DEFAULT 'internal';
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE system_user DROP COLUMN IF EXISTS auth_method;
+           `)
+		return err
+	})
+}
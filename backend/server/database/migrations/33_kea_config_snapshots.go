@@ -0,0 +1,40 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the history of the Kea daemons'
+             -- configurations. A new version is stored when the
+             -- configuration fetched from the daemon differs from the
+             -- most recent one, i.e. when the hashes of the
+             -- configurations differ. The snapshots are associated with
+             -- the app and the daemon name rather than the daemon,
+             -- because the daemons are re-created when the state of the
+             -- app is refreshed.
+             CREATE TABLE IF NOT EXISTS kea_config_snapshot (
+                 id BIGSERIAL PRIMARY KEY,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 app_id BIGINT NOT NULL,
+                 daemon_name TEXT NOT NULL,
+                 version BIGINT NOT NULL,
+                 hash TEXT NOT NULL,
+                 config JSONB NOT NULL,
+                 CONSTRAINT kea_config_snapshot_app_id_fkey FOREIGN KEY (app_id)
+                     REFERENCES app (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT kea_config_snapshot_app_id_daemon_name_version_key UNIQUE (app_id, daemon_name, version)
+             );
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS kea_config_snapshot;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,43 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the findings of the most recent review
+             -- of the daemon's configuration. Similarly to the
+             -- configuration snapshots, the findings are associated with
+             -- the app and the daemon name.
+             CREATE TABLE IF NOT EXISTS config_review_finding (
+                 id BIGSERIAL PRIMARY KEY,
+                 created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                 app_id BIGINT NOT NULL,
+                 daemon_name TEXT NOT NULL,
+                 checker TEXT NOT NULL,
+                 text TEXT NOT NULL,
+                 CONSTRAINT config_review_finding_app_id_fkey FOREIGN KEY (app_id)
+                     REFERENCES app (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE
+             );
+             CREATE INDEX config_review_finding_app_id_daemon_name_idx ON config_review_finding (app_id, daemon_name);
+
+             -- This table holds the checkers enabled or disabled by the
+             -- users. The checkers which are not listed are enabled.
+             CREATE TABLE IF NOT EXISTS config_checker (
+                 name TEXT PRIMARY KEY,
+                 enabled BOOLEAN NOT NULL
+             );
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS config_checker;
+             DROP TABLE IF EXISTS config_review_finding;
+           `)
+		return err
+	})
+}
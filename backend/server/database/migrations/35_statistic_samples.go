@@ -0,0 +1,61 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- This table holds the history of the statistics of the
+             -- subnets, the shared networks and the daemons. Each sample
+             -- belongs to exactly one of them. The daemons' samples are
+             -- associated with the app and the daemon name, because the
+             -- daemons are re-created when the state of the app is
+             -- refreshed. The raw samples are periodically replaced with
+             -- the hourly averages and the hourly averages with the daily
+             -- ones, as designated by the resolution column.
+             CREATE TABLE IF NOT EXISTS statistic_sample (
+                 id BIGSERIAL PRIMARY KEY,
+                 subnet_id BIGINT,
+                 shared_network_id BIGINT,
+                 app_id BIGINT,
+                 daemon_name TEXT,
+                 name TEXT NOT NULL,
+                 resolution TEXT NOT NULL,
+                 sampled_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+                 value DOUBLE PRECISION NOT NULL,
+                 CONSTRAINT statistic_sample_subnet_id_fkey FOREIGN KEY (subnet_id)
+                     REFERENCES subnet (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT statistic_sample_shared_network_id_fkey FOREIGN KEY (shared_network_id)
+                     REFERENCES shared_network (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT statistic_sample_app_id_fkey FOREIGN KEY (app_id)
+                     REFERENCES app (id) MATCH SIMPLE
+                         ON UPDATE CASCADE
+                         ON DELETE CASCADE,
+                 CONSTRAINT statistic_sample_owner_check CHECK (
+                     (subnet_id IS NOT NULL)::int +
+                     (shared_network_id IS NOT NULL)::int +
+                     (app_id IS NOT NULL AND daemon_name IS NOT NULL)::int = 1
+                 ),
+                 CONSTRAINT statistic_sample_resolution_check CHECK (
+                     resolution IN ('raw', 'hour', 'day')
+                 )
+             );
+             CREATE INDEX statistic_sample_subnet_id_name_idx ON statistic_sample (subnet_id, name, sampled_at);
+             CREATE INDEX statistic_sample_shared_network_id_name_idx ON statistic_sample (shared_network_id, name, sampled_at);
+             CREATE INDEX statistic_sample_app_id_daemon_name_name_idx ON statistic_sample (app_id, daemon_name, name, sampled_at);
+             CREATE INDEX statistic_sample_resolution_sampled_at_idx ON statistic_sample (resolution, sampled_at);
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DROP TABLE IF EXISTS statistic_sample;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,25 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- The time when the addresses in the subnet or the shared
+             -- network are expected to run out, forecast from the history
+             -- of the utilization. It is NULL when the utilization isn't
+             -- growing.
+             ALTER TABLE subnet ADD COLUMN addr_exhausted_at TIMESTAMP WITHOUT TIME ZONE;
+             ALTER TABLE shared_network ADD COLUMN addr_exhausted_at TIMESTAMP WITHOUT TIME ZONE;
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE shared_network DROP COLUMN IF EXISTS addr_exhausted_at;
+             ALTER TABLE subnet DROP COLUMN IF EXISTS addr_exhausted_at;
+           `)
+		return err
+	})
+}
@@ -0,0 +1,22 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- Indicates if the app accepts the connections to the access
+             -- point over TLS, e.g. Kea Control Agent configured with the
+             -- certificates.
+             ALTER TABLE access_point ADD COLUMN use_secure_protocol BOOLEAN NOT NULL DEFAULT FALSE;
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             ALTER TABLE access_point DROP COLUMN IF EXISTS use_secure_protocol;
+           `)
+		return err
+	})
+}
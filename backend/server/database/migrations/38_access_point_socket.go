@@ -0,0 +1,46 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v7"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+             -- The Kea daemons running without the Kea Control Agent are
+             -- controlled over the unix sockets. The new value can't be
+             -- added to the enum within the transaction, so the type is
+             -- recreated.
+             ALTER TYPE ACCESSPOINTTYPE RENAME TO ACCESSPOINTTYPE_OLD;
+             CREATE TYPE ACCESSPOINTTYPE AS ENUM
+                 ('control', 'statistics', 'control-socket');
+             ALTER TABLE access_point
+                 ALTER COLUMN type TYPE ACCESSPOINTTYPE USING type::text::ACCESSPOINTTYPE;
+             DROP TYPE ACCESSPOINTTYPE_OLD;
+
+             -- The sockets have no port and multiple Kea Control Agents
+             -- may listen on the same port on different addresses.
+             ALTER TABLE access_point DROP CONSTRAINT access_point_unique_idx;
+             ALTER TABLE access_point
+                 ADD CONSTRAINT access_point_unique_idx UNIQUE (machine_id, address, port);
+           `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+             DELETE FROM app WHERE id IN
+                 (SELECT app_id FROM access_point WHERE type = 'control-socket');
+
+             ALTER TABLE access_point DROP CONSTRAINT access_point_unique_idx;
+             ALTER TABLE access_point
+                 ADD CONSTRAINT access_point_unique_idx UNIQUE (machine_id, port);
+
+             ALTER TYPE ACCESSPOINTTYPE RENAME TO ACCESSPOINTTYPE_OLD;
+             CREATE TYPE ACCESSPOINTTYPE AS ENUM
+                 ('control', 'statistics');
+             ALTER TABLE access_point
+                 ALTER COLUMN type TYPE ACCESSPOINTTYPE USING type::text::ACCESSPOINTTYPE;
+             DROP TYPE ACCESSPOINTTYPE_OLD;
+           `)
+		return err
+	})
+}
@@ -1,6 +1,9 @@
 package dbmodel
 
 import (
+	"net"
+	"strconv"
+
 	"github.com/go-pg/pg/v9"
 	"github.com/pkg/errors"
 
@@ -9,17 +12,22 @@ import (
 
 // A structure reflecting the access_point SQL table.
 type AccessPoint struct {
-	AppID     int64  `pg:",pk"`
-	Type      string `pg:",pk"`
-	MachineID int64
-	Address   string
-	Port      int64
-	Key       string
+	AppID             int64  `pg:",pk"`
+	Type              string `pg:",pk"`
+	MachineID         int64
+	Address           string
+	Port              int64
+	Key               string
+	UseSecureProtocol bool `pg:",use_zero"`
 }
 
 const AccessPointControl = "control"
 const AccessPointStatistics = "statistics"
 
+// Unix socket of the Kea daemon running without the Kea Control Agent.
+// Its address is the path to the socket and the port is not used.
+const AccessPointControlSocket = "control-socket"
+
 // GetAllAccessPointsByAppID returns all access points for an app with given ID.
 func GetAllAccessPointsByAppID(db *dbops.PgDB, appID int64) ([]*AccessPoint, error) {
 	var accessPoints []*AccessPoint
@@ -47,3 +55,12 @@ func AppendAccessPoint(list []*AccessPoint, tp, address, key string, port int64)
 	})
 	return list
 }
+
+// Returns the location of the access point presented to the user, i.e. the
+// address and port or the path to the control socket.
+func (point *AccessPoint) Location() string {
+	if point.Type == AccessPointControlSocket {
+		return point.Address
+	}
+	return net.JoinHostPort(point.Address, strconv.FormatInt(point.Port, 10))
+}
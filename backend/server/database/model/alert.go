@@ -0,0 +1,281 @@
+package dbmodel
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+)
+
+// Kinds of the alert rules.
+const (
+	// Address utilization of a subnet in percent is at or above the threshold.
+	AlertRuleSubnetUtilization = "subnet-utilization"
+	// At least one server of an HA service can't be reached.
+	AlertRuleHAPeerUnreachable = "ha-peer-unreachable"
+	// A daemon of an app is not running.
+	AlertRuleDaemonInactive = "daemon-inactive"
+)
+
+// Types of the notification channels.
+const (
+	AlertChannelWebhook = "webhook"
+	AlertChannelSMTP    = "smtp"
+)
+
+// Represents an alert rule held in alert_rule table in the database.
+// The rule fires for an object when the condition has been met for
+// ForEvaluations consecutive evaluations and it is resolved when the
+// condition has not been met for the same number of evaluations. The
+// rules comparing values against the threshold are resolved when the
+// value drops below the ClearThreshold, which allows for avoiding the
+// alerts flapping around the threshold.
+type AlertRule struct {
+	ID             int64
+	CreatedAt      time.Time
+	Name           string
+	Kind           string
+	Threshold      float64 `pg:",use_zero"`
+	ClearThreshold float64 `pg:",use_zero"`
+	ForEvaluations int     `pg:",use_zero"`
+	Enabled        bool    `pg:",use_zero"`
+	// No notifications are sent for the rule until this time.
+	SilencedUntil time.Time
+}
+
+// Parameters of the notification channel. Only the parameters relevant
+// to the channel type are set. It is stored as JSONB in the database.
+type AlertChannelParams struct {
+	// Webhook parameters.
+	URL     string            `json:",omitempty"`
+	Headers map[string]string `json:",omitempty"`
+
+	// SMTP parameters.
+	SMTPAddress  string   `json:",omitempty"`
+	SMTPFrom     string   `json:",omitempty"`
+	SMTPTo       []string `json:",omitempty"`
+	SMTPUsername string   `json:",omitempty"`
+	SMTPPassword string   `json:",omitempty"`
+}
+
+// Represents a notification channel held in alert_channel table in the
+// database.
+type AlertChannel struct {
+	ID        int64
+	CreatedAt time.Time
+	Name      string
+	Type      string
+	Enabled   bool `pg:",use_zero"`
+	Params    AlertChannelParams
+}
+
+// Represents the state of the alert rule for a single object held in
+// alert table in the database.
+type Alert struct {
+	ID          int64
+	RuleID      int64
+	Rule        *AlertRule
+	ObjectKey   string
+	Description string
+	Value       float64 `pg:",use_zero"`
+	Firing      bool    `pg:",use_zero"`
+	// Number of consecutive evaluations for which the condition has
+	// been different than the current state of the alert.
+	PendingCount int `pg:",use_zero"`
+	FiredAt      time.Time
+	ResolvedAt   time.Time
+	// No notifications are sent for the alert until this time.
+	SilencedUntil time.Time
+}
+
+// Checks whether the notifications about the alert should be sent at
+// the given time, i.e. neither the alert nor its rule is silenced.
+func (alert *Alert) IsSilenced(rule *AlertRule, now time.Time) bool {
+	return now.Before(alert.SilencedUntil) || (rule != nil && now.Before(rule.SilencedUntil))
+}
+
+// Adds an alert rule to the database.
+func AddAlertRule(db *pg.DB, rule *AlertRule) error {
+	err := db.Insert(rule)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with inserting alert rule %s", rule.Name)
+	}
+	return err
+}
+
+// Updates an alert rule in the database.
+func UpdateAlertRule(db *pg.DB, rule *AlertRule) error {
+	err := db.Update(rule)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with updating alert rule %d", rule.ID)
+	}
+	return err
+}
+
+// Fetches the alert rule by ID. It returns nil if the rule doesn't exist.
+func GetAlertRuleByID(db *pg.DB, id int64) (*AlertRule, error) {
+	rule := &AlertRule{}
+	err := db.Model(rule).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with getting alert rule %d", id)
+	}
+	return rule, nil
+}
+
+// Fetches all alert rules ordered by ID.
+func GetAlertRules(db *pg.DB) ([]AlertRule, error) {
+	rules := []AlertRule{}
+	err := db.Model(&rules).OrderExpr("id ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting alert rules")
+	}
+	return rules, nil
+}
+
+// Deletes the alert rule and the alerts it has raised.
+func DeleteAlertRule(db *pg.DB, id int64) error {
+	_, err := db.Model((*AlertRule)(nil)).Where("id = ?", id).Delete()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with deleting alert rule %d", id)
+	}
+	return err
+}
+
+// Adds a notification channel to the database.
+func AddAlertChannel(db *pg.DB, channel *AlertChannel) error {
+	err := db.Insert(channel)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with inserting alert channel %s", channel.Name)
+	}
+	return err
+}
+
+// Updates a notification channel in the database.
+func UpdateAlertChannel(db *pg.DB, channel *AlertChannel) error {
+	err := db.Update(channel)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with updating alert channel %d", channel.ID)
+	}
+	return err
+}
+
+// Fetches the notification channel by ID. It returns nil if the channel
+// doesn't exist.
+func GetAlertChannelByID(db *pg.DB, id int64) (*AlertChannel, error) {
+	channel := &AlertChannel{}
+	err := db.Model(channel).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with getting alert channel %d", id)
+	}
+	return channel, nil
+}
+
+// Fetches all notification channels ordered by ID. If enabledOnly is true
+// the disabled channels are not returned.
+func GetAlertChannels(db *pg.DB, enabledOnly bool) ([]AlertChannel, error) {
+	channels := []AlertChannel{}
+	q := db.Model(&channels)
+	if enabledOnly {
+		q = q.Where("enabled = ?", true)
+	}
+	err := q.OrderExpr("id ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting alert channels")
+	}
+	return channels, nil
+}
+
+// Deletes the notification channel.
+func DeleteAlertChannel(db *pg.DB, id int64) error {
+	_, err := db.Model((*AlertChannel)(nil)).Where("id = ?", id).Delete()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with deleting alert channel %d", id)
+	}
+	return err
+}
+
+// Inserts a new alert or updates the existing one.
+func CommitAlert(db *pg.DB, alert *Alert) error {
+	var err error
+	if alert.ID == 0 {
+		err = db.Insert(alert)
+	} else {
+		err = db.Update(alert)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "problem with committing alert for object %s of rule %d", alert.ObjectKey, alert.RuleID)
+	}
+	return err
+}
+
+// Fetches the alert by ID including its rule. It returns nil if the alert
+// doesn't exist.
+func GetAlertByID(db *pg.DB, id int64) (*Alert, error) {
+	alert := &Alert{}
+	err := db.Model(alert).Relation("Rule").Where("alert.id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with getting alert %d", id)
+	}
+	return alert, nil
+}
+
+// Fetches the alerts raised by the given rule.
+func GetAlertsByRuleID(db *pg.DB, ruleID int64) ([]Alert, error) {
+	alerts := []Alert{}
+	err := db.Model(&alerts).Where("rule_id = ?", ruleID).OrderExpr("id ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting alerts of rule %d", ruleID)
+	}
+	return alerts, nil
+}
+
+// Fetches the alerts including their rules. If firingOnly is true only
+// the alerts which are currently firing are returned.
+func GetAlerts(db *pg.DB, firingOnly bool) ([]Alert, error) {
+	alerts := []Alert{}
+	q := db.Model(&alerts).Relation("Rule")
+	if firingOnly {
+		q = q.Where("alert.firing = ?", true)
+	}
+	err := q.OrderExpr("alert.id ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting alerts")
+	}
+	return alerts, nil
+}
+
+// Deletes the alerts of the rule except the ones with the given IDs. It is
+// used to remove the alerts for the objects which don't exist anymore.
+func DeleteAlertsExcept(db *pg.DB, ruleID int64, keepIDs []int64) error {
+	q := db.Model((*Alert)(nil)).Where("rule_id = ?", ruleID)
+	if len(keepIDs) > 0 {
+		q = q.Where("id NOT IN (?)", pg.In(keepIDs))
+	}
+	_, err := q.Delete()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with deleting alerts of rule %d", ruleID)
+	}
+	return err
+}
+
+// Silences the notifications about the alert until the given time. The
+// zero time removes the silence.
+func SilenceAlert(db *pg.DB, id int64, until time.Time) error {
+	q := db.Model((*Alert)(nil)).Where("id = ?", id)
+	if until.IsZero() {
+		q = q.Set("silenced_until = NULL")
+	} else {
+		q = q.Set("silenced_until = ?", until)
+	}
+	_, err := q.Update()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with silencing alert %d", id)
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package dbmodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the alert rules can be added, updated, fetched and deleted.
+func TestAlertRules(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rule := &AlertRule{
+		Name:           "utilization",
+		Kind:           AlertRuleSubnetUtilization,
+		Threshold:      90,
+		ClearThreshold: 85,
+		ForEvaluations: 2,
+		Enabled:        true,
+	}
+	err := AddAlertRule(db, rule)
+	require.NoError(t, err)
+	require.NotZero(t, rule.ID)
+
+	// The names must be unique.
+	err = AddAlertRule(db, &AlertRule{Name: "utilization", Kind: AlertRuleDaemonInactive})
+	require.Error(t, err)
+
+	rule.Enabled = false
+	rule.ClearThreshold = 0
+	rule.SilencedUntil = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = UpdateAlertRule(db, rule)
+	require.NoError(t, err)
+
+	returned, err := GetAlertRuleByID(db, rule.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.False(t, returned.Enabled)
+	require.Zero(t, returned.ClearThreshold)
+	require.EqualValues(t, 90, returned.Threshold)
+	require.Equal(t, 2, returned.ForEvaluations)
+	require.True(t, rule.SilencedUntil.Equal(returned.SilencedUntil))
+
+	rules, err := GetAlertRules(db)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	err = DeleteAlertRule(db, rule.ID)
+	require.NoError(t, err)
+	returned, err = GetAlertRuleByID(db, rule.ID)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
+
+// Test that the notification channels can be added, updated, fetched and
+// deleted.
+func TestAlertChannels(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	webhook := &AlertChannel{
+		Name:    "hook",
+		Type:    AlertChannelWebhook,
+		Enabled: true,
+		Params: AlertChannelParams{
+			URL: "http://localhost/hook",
+		},
+	}
+	err := AddAlertChannel(db, webhook)
+	require.NoError(t, err)
+
+	email := &AlertChannel{
+		Name: "email",
+		Type: AlertChannelSMTP,
+		Params: AlertChannelParams{
+			SMTPAddress: "localhost:25",
+			SMTPTo:      []string{"noc@example.org"},
+		},
+	}
+	err = AddAlertChannel(db, email)
+	require.NoError(t, err)
+
+	channels, err := GetAlertChannels(db, false)
+	require.NoError(t, err)
+	require.Len(t, channels, 2)
+
+	channels, err = GetAlertChannels(db, true)
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	require.Equal(t, "http://localhost/hook", channels[0].Params.URL)
+
+	email.Enabled = true
+	err = UpdateAlertChannel(db, email)
+	require.NoError(t, err)
+	returned, err := GetAlertChannelByID(db, email.ID)
+	require.NoError(t, err)
+	require.True(t, returned.Enabled)
+	require.Equal(t, []string{"noc@example.org"}, returned.Params.SMTPTo)
+
+	err = DeleteAlertChannel(db, webhook.ID)
+	require.NoError(t, err)
+	returned, err = GetAlertChannelByID(db, webhook.ID)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
+
+// Test that the alerts are stored, silenced and removed with their rules.
+func TestAlerts(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rule := &AlertRule{
+		Name:    "daemons",
+		Kind:    AlertRuleDaemonInactive,
+		Enabled: true,
+	}
+	err := AddAlertRule(db, rule)
+	require.NoError(t, err)
+
+	alert1 := &Alert{
+		RuleID:    rule.ID,
+		ObjectKey: "app:1/dhcp4",
+		Firing:    true,
+		FiredAt:   time.Now().UTC(),
+	}
+	err = CommitAlert(db, alert1)
+	require.NoError(t, err)
+	alert2 := &Alert{
+		RuleID:       rule.ID,
+		ObjectKey:    "app:1/dhcp6",
+		PendingCount: 1,
+	}
+	err = CommitAlert(db, alert2)
+	require.NoError(t, err)
+
+	// Only one alert for the object.
+	err = CommitAlert(db, &Alert{RuleID: rule.ID, ObjectKey: "app:1/dhcp4"})
+	require.Error(t, err)
+
+	alerts, err := GetAlerts(db, true)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "app:1/dhcp4", alerts[0].ObjectKey)
+	require.NotNil(t, alerts[0].Rule)
+	require.Equal(t, "daemons", alerts[0].Rule.Name)
+
+	// Resolving the alert.
+	alert1.Firing = false
+	alert1.ResolvedAt = time.Now().UTC()
+	err = CommitAlert(db, alert1)
+	require.NoError(t, err)
+	alerts, err = GetAlerts(db, true)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// Silencing the alert.
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = SilenceAlert(db, alert2.ID, until)
+	require.NoError(t, err)
+	returned, err := GetAlertByID(db, alert2.ID)
+	require.NoError(t, err)
+	require.True(t, until.Equal(returned.SilencedUntil))
+	require.True(t, returned.IsSilenced(returned.Rule, until.Add(-time.Hour)))
+	require.False(t, returned.IsSilenced(returned.Rule, until.Add(time.Hour)))
+
+	err = SilenceAlert(db, alert2.ID, time.Time{})
+	require.NoError(t, err)
+	returned, err = GetAlertByID(db, alert2.ID)
+	require.NoError(t, err)
+	require.True(t, returned.SilencedUntil.IsZero())
+
+	// Removing the alerts for the objects which are gone.
+	err = DeleteAlertsExcept(db, rule.ID, []int64{alert2.ID})
+	require.NoError(t, err)
+	alerts, err = GetAlertsByRuleID(db, rule.ID)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, alert2.ID, alerts[0].ID)
+
+	// The alerts are removed together with the rule.
+	err = DeleteAlertRule(db, rule.ID)
+	require.NoError(t, err)
+	alerts, err = GetAlerts(db, false)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+}
+
+// Test that the alert is silenced when either the alert or its rule is
+// silenced.
+func TestAlertIsSilenced(t *testing.T) {
+	now := time.Now()
+	alert := &Alert{}
+	rule := &AlertRule{}
+	require.False(t, alert.IsSilenced(rule, now))
+	require.False(t, alert.IsSilenced(nil, now))
+
+	rule.SilencedUntil = now.Add(time.Minute)
+	require.True(t, alert.IsSilenced(rule, now))
+
+	rule.SilencedUntil = now.Add(-time.Minute)
+	alert.SilencedUntil = now.Add(time.Minute)
+	require.True(t, alert.IsSilenced(rule, now))
+	require.False(t, alert.IsSilenced(rule, now.Add(time.Hour)))
+}
@@ -0,0 +1,125 @@
+package dbmodel
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents a token held in api_token table in the database. The token
+// allows the user to access the ReST API without signing in. The token
+// itself is only returned when it is created, the database holds its
+// hash. The scopes limit the operations which can be performed with
+// the token, empty scopes mean that it allows everything its owner is
+// permitted to do. The zero ExpiresAt means that the token never
+// expires.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scopes     []string `pg:",array"`
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+
+	User *SystemUser
+}
+
+// Returns the hash of the token under which it is stored in the database.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generates new random token and adds it to the database. The generated
+// token is returned to the caller and it can't be retrieved later. The
+// returned conflict flag is true when the user already has a token with
+// the same name.
+func AddAPIToken(db *dbops.PgDB, apiToken *APIToken) (token string, conflict bool, err error) {
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "problem with generating API token")
+	}
+	token = hex.EncodeToString(buf)
+	apiToken.TokenHash = hashAPIToken(token)
+
+	_, err = db.Model(apiToken).Insert()
+	if err != nil {
+		pgErr, ok := err.(pg.Error)
+		if ok {
+			conflict = pgErr.IntegrityViolation()
+		}
+		return "", conflict, errors.Wrapf(err, "problem with inserting API token %s of user %d",
+			apiToken.Name, apiToken.UserID)
+	}
+	return token, false, nil
+}
+
+// Fetches the tokens of the given user ordered by id.
+func GetAPITokensByUserID(db *dbops.PgDB, userID int) ([]APIToken, error) {
+	apiTokens := []APIToken{}
+	err := db.Model(&apiTokens).
+		Where("user_id = ?", userID).
+		OrderExpr("id ASC").
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting API tokens of user %d", userID)
+	}
+	return apiTokens, nil
+}
+
+// Deletes the token of the given user. It returns false when the user
+// had no such token.
+func DeleteAPIToken(db *dbops.PgDB, userID, tokenID int) (bool, error) {
+	res, err := db.Model((*APIToken)(nil)).
+		Where("id = ?", tokenID).
+		Where("user_id = ?", userID).
+		Delete()
+	if err != nil {
+		return false, errors.Wrapf(err, "problem with deleting API token %d", tokenID)
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+// Finds the token in the database and returns it along with the user and
+// the groups of the user. The time of the last use of the token is
+// updated. It returns nil when there is no such token or it has expired.
+func AuthenticateAPIToken(db *dbops.PgDB, token string) (*APIToken, error) {
+	apiToken := &APIToken{}
+	err := db.Model(apiToken).
+		Where("token_hash = ?", hashAPIToken(token)).
+		Where("expires_at IS NULL OR expires_at > timezone('utc'::text, now())").
+		Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with authenticating API token")
+	}
+
+	apiToken.User, err = GetUserByID(db, apiToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if apiToken.User == nil {
+		return nil, nil
+	}
+	// Don't pass the password hash any further.
+	apiToken.User.Password = ""
+
+	_, err = db.Model(apiToken).
+		Set("last_used_at = timezone('utc'::text, now())").
+		WherePK().
+		Update()
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with updating last use of API token %d", apiToken.ID)
+	}
+	return apiToken, nil
+}
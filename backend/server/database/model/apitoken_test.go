@@ -0,0 +1,86 @@
+package dbmodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the API tokens can be added, used for authentication and
+// deleted.
+func TestAPIToken(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apiToken := &APIToken{
+		UserID: 1,
+		Name:   "ci",
+		Scopes: []string{"dhcp:read"},
+	}
+	token, conflict, err := AddAPIToken(db, apiToken)
+	require.NoError(t, err)
+	require.False(t, conflict)
+	require.NotEmpty(t, token)
+	require.NotZero(t, apiToken.ID)
+	// Only the hash is stored.
+	require.NotEqual(t, token, apiToken.TokenHash)
+
+	// The name must be unique for the user.
+	_, conflict, err = AddAPIToken(db, &APIToken{UserID: 1, Name: "ci"})
+	require.Error(t, err)
+	require.True(t, conflict)
+
+	// This token has already expired.
+	expired := &APIToken{
+		UserID:    1,
+		Name:      "expired",
+		ExpiresAt: time.Now().UTC().Add(-time.Hour),
+	}
+	expiredToken, _, err := AddAPIToken(db, expired)
+	require.NoError(t, err)
+
+	apiTokens, err := GetAPITokensByUserID(db, 1)
+	require.NoError(t, err)
+	require.Len(t, apiTokens, 2)
+	require.Equal(t, "ci", apiTokens[0].Name)
+	require.Equal(t, []string{"dhcp:read"}, apiTokens[0].Scopes)
+	require.Zero(t, apiTokens[0].ExpiresAt)
+	require.Zero(t, apiTokens[0].LastUsedAt)
+	require.Equal(t, "expired", apiTokens[1].Name)
+
+	// Authenticate with the valid token.
+	returned, err := AuthenticateAPIToken(db, token)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, apiToken.ID, returned.ID)
+	require.NotNil(t, returned.User)
+	require.Equal(t, 1, returned.User.ID)
+	require.Empty(t, returned.User.Password)
+	require.True(t, returned.User.InGroup(&SystemGroup{ID: SuperAdminGroupID}))
+
+	apiTokens, err = GetAPITokensByUserID(db, 1)
+	require.NoError(t, err)
+	require.NotZero(t, apiTokens[0].LastUsedAt)
+
+	// The expired and unknown tokens are rejected.
+	returned, err = AuthenticateAPIToken(db, expiredToken)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+	returned, err = AuthenticateAPIToken(db, "foo")
+	require.NoError(t, err)
+	require.Nil(t, returned)
+
+	// Delete the token.
+	ok, err := DeleteAPIToken(db, 2, apiToken.ID)
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = DeleteAPIToken(db, 1, apiToken.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	returned, err = AuthenticateAPIToken(db, token)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
@@ -255,12 +255,25 @@ func GetAppsByMachine(db *pg.DB, machineID int64) ([]App, error) {
 
 // Fetches all apps by type including the corresponding services.
 func GetAppsByType(db *pg.DB, appType string) ([]App, error) {
+	return getAppsByType(db, appType, false)
+}
+
+// Fetches the apps of the given type running on the authorized machines.
+// The pullers use it to skip the machines which haven't been approved yet.
+func GetAuthorizedAppsByType(db *pg.DB, appType string) ([]App, error) {
+	return getAppsByType(db, appType, true)
+}
+
+func getAppsByType(db *pg.DB, appType string, authorizedOnly bool) ([]App, error) {
 	var apps []App
 
 	q := db.Model(&apps)
-	q = q.Where("type = ?", appType)
+	q = q.Where("app.type = ?", appType)
 	q = q.Relation("Machine")
 	q = q.Relation("AccessPoints")
+	if authorizedOnly {
+		q = q.Where("machine.authorized = ?", true)
+	}
 
 	switch appType {
 	case AppTypeKea:
@@ -392,3 +405,13 @@ func (app *App) GetAccessPoint(accessPointType string) (ap *AccessPoint, err err
 	}
 	return nil, errors.Errorf("no access point of type %s found for app id %d", accessPointType, app.ID)
 }
+
+// Returns the access point used to send the commands to the app. It is the
+// control access point or, for the Kea daemon running without the Kea
+// Control Agent, its control socket.
+func (app *App) GetControlAccessPoint() (*AccessPoint, error) {
+	if point, err := app.GetAccessPoint(AccessPointControlSocket); err == nil {
+		return point, nil
+	}
+	return app.GetAccessPoint(AccessPointControl)
+}
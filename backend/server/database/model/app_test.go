@@ -2,6 +2,7 @@ package dbmodel
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	require "github.com/stretchr/testify/require"
@@ -964,3 +965,43 @@ func TestGetLocalSubnetID(t *testing.T) {
 	// Next, try to find the existing subnet.
 	require.EqualValues(t, 1, aKea.GetLocalSubnetID("192.0.2.0/24"))
 }
+
+// Check that only the apps running on the authorized machines are
+// returned for the pullers.
+func TestGetAuthorizedAppsByType(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	var appIDs []int64
+	for i, authorized := range []bool{true, false} {
+		m := &Machine{
+			Address:    fmt.Sprintf("host-%d", i),
+			AgentPort:  8080,
+			Authorized: authorized,
+		}
+		err := AddMachine(db, m)
+		require.NoError(t, err)
+
+		var accessPoints []*AccessPoint
+		accessPoints = AppendAccessPoint(accessPoints, AccessPointControl, "", "", 1234)
+		a := &App{
+			MachineID:    m.ID,
+			Type:         AppTypeKea,
+			AccessPoints: accessPoints,
+		}
+		err = AddApp(db, a)
+		require.NoError(t, err)
+		appIDs = append(appIDs, a.ID)
+	}
+
+	apps, err := GetAuthorizedAppsByType(db, AppTypeKea)
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+	require.Equal(t, appIDs[0], apps[0].ID)
+	require.True(t, apps[0].Machine.Authorized)
+
+	// All apps are returned regardless of the machine state.
+	apps, err = GetAppsByType(db, AppTypeKea)
+	require.NoError(t, err)
+	require.Len(t, apps, 2)
+}
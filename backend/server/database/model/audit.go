@@ -0,0 +1,137 @@
+package dbmodel
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+)
+
+// Value of a single field of the object before and after the change.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Represents an entry of the audit trail held in audit_entry table in
+// the database. It describes a single request made by the user via the
+// ReST API. The object type, object ID and the changes are only set when
+// the request modified an object. The UserID is 0 when the request was
+// made by an unauthenticated user or the user has been deleted.
+type AuditEntry struct {
+	ID         int64
+	CreatedAt  time.Time
+	UserID     int
+	UserLogin  string
+	Method     string
+	Endpoint   string
+	StatusCode int `pg:",use_zero"`
+	ObjectType string
+	ObjectID   int64
+	Diff       map[string]AuditChange
+}
+
+// Adds an entry to the audit trail.
+func AddAuditEntry(db *pg.DB, entry *AuditEntry) error {
+	err := db.Insert(entry)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with inserting audit entry %+v", entry)
+	}
+	return err
+}
+
+// Fetches a collection of the audit trail entries from the database. The
+// offset and limit specify the beginning of the page and the maximum size
+// of the page. The userID, objectType and objectID limit the entries to
+// the ones made by the given user and the ones concerning the given
+// object. The 0 and empty values disable filtering. The from and to
+// limit the entries to the given time range. The zero time disables
+// filtering by the respective end of the range. The entries are sorted
+// by the time they were created, the most recent first unless SortDirAsc
+// is specified. This function returns a collection of entries, the total
+// number of entries matching the filters and error.
+func GetAuditEntriesByPage(db *pg.DB, offset, limit int64, userID int, objectType string, objectID int64, from, to time.Time, sortDir SortDirEnum) ([]AuditEntry, int64, error) {
+	if limit == 0 {
+		return nil, 0, errors.New("limit should be greater than 0")
+	}
+	entries := []AuditEntry{}
+	q := db.Model(&entries)
+
+	if userID != 0 {
+		q = q.Where("audit_entry.user_id = ?", userID)
+	}
+	if objectType != "" {
+		q = q.Where("audit_entry.object_type = ?", objectType)
+	}
+	if objectID != 0 {
+		q = q.Where("audit_entry.object_id = ?", objectID)
+	}
+	if !from.IsZero() {
+		q = q.Where("audit_entry.created_at >= ?", from)
+	}
+	if !to.IsZero() {
+		q = q.Where("audit_entry.created_at <= ?", to)
+	}
+
+	if sortDir == SortDirAsc {
+		q = q.OrderExpr("audit_entry.created_at ASC, audit_entry.id ASC")
+	} else {
+		q = q.OrderExpr("audit_entry.created_at DESC, audit_entry.id DESC")
+	}
+	q = q.Offset(int(offset))
+	q = q.Limit(int(limit))
+
+	total, err := q.SelectAndCount()
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "problem with getting audit entries")
+	}
+	return entries, int64(total), nil
+}
+
+// Converts the object to a map of its fields using its JSON representation.
+// Nil is converted to an empty map.
+func toAuditFields(obj interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if obj == nil || (reflect.ValueOf(obj).Kind() == reflect.Ptr && reflect.ValueOf(obj).IsNil()) {
+		return fields, nil
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with serializing %T", obj)
+	}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with converting %T to map", obj)
+	}
+	return fields, nil
+}
+
+// Returns the fields of the object which differ before and after the
+// change. The objects are compared using their JSON representations, so
+// they should not include the fields which must not be recorded, e.g.
+// passwords. Either object may be nil when the object was created or
+// deleted, in which case all fields of the other object are returned.
+func GetAuditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	beforeFields, err := toAuditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toAuditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	diff := make(map[string]AuditChange)
+	for name, value := range beforeFields {
+		if afterValue, ok := afterFields[name]; !ok || !reflect.DeepEqual(value, afterValue) {
+			diff[name] = AuditChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			diff[name] = AuditChange{After: value}
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,136 @@
+package dbmodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that only the changed fields are included in the diff.
+func TestGetAuditDiff(t *testing.T) {
+	type object struct {
+		Name    string
+		Port    int
+		Enabled bool
+		Tags    []string
+	}
+
+	before := &object{Name: "foo", Port: 8080, Tags: []string{"a"}}
+	after := &object{Name: "foo", Port: 8081, Enabled: true, Tags: []string{"a"}}
+	diff, err := GetAuditDiff(before, after)
+	require.NoError(t, err)
+	require.Len(t, diff, 2)
+	require.EqualValues(t, 8080, diff["Port"].Before)
+	require.EqualValues(t, 8081, diff["Port"].After)
+	require.Equal(t, false, diff["Enabled"].Before)
+	require.Equal(t, true, diff["Enabled"].After)
+
+	// Created object.
+	diff, err = GetAuditDiff(nil, after)
+	require.NoError(t, err)
+	require.Len(t, diff, 4)
+	require.Nil(t, diff["Name"].Before)
+	require.Equal(t, "foo", diff["Name"].After)
+
+	// Deleted object. The typed nil pointer is treated as no object.
+	var deleted *object
+	diff, err = GetAuditDiff(before, deleted)
+	require.NoError(t, err)
+	require.Len(t, diff, 4)
+	require.Equal(t, "foo", diff["Name"].Before)
+	require.Nil(t, diff["Name"].After)
+
+	// Maps are compared by their keys.
+	diff, err = GetAuditDiff(map[string]interface{}{"a": 1, "b": "x"}, map[string]interface{}{"a": 1, "c": "y"})
+	require.NoError(t, err)
+	require.Len(t, diff, 2)
+	require.Equal(t, "x", diff["b"].Before)
+	require.Nil(t, diff["b"].After)
+	require.Equal(t, "y", diff["c"].After)
+
+	diff, err = GetAuditDiff(after, after)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+}
+
+// Test that the audit entries can be added and fetched by page with
+// filtering.
+func TestGetAuditEntriesByPage(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	entries := []AuditEntry{
+		{
+			UserID:     1,
+			UserLogin:  "admin",
+			Method:     "PUT",
+			Endpoint:   "/api/settings",
+			StatusCode: 200,
+			ObjectType: "settings",
+			Diff: map[string]AuditChange{
+				"grafana_url": {Before: "", After: "http://grafana"},
+			},
+		},
+		{
+			UserID:     1,
+			UserLogin:  "admin",
+			Method:     "DELETE",
+			Endpoint:   "/api/machines/5",
+			StatusCode: 200,
+			ObjectType: "machine",
+			ObjectID:   5,
+		},
+		{
+			Method:     "POST",
+			Endpoint:   "/api/sessions",
+			StatusCode: 400,
+		},
+	}
+	for i := range entries {
+		err := AddAuditEntry(db, &entries[i])
+		require.NoError(t, err)
+	}
+
+	returned, total, err := GetAuditEntriesByPage(db, 0, 10, 0, "", 0, time.Time{}, time.Time{}, SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, returned, 3)
+	// The most recent first.
+	require.Equal(t, "/api/sessions", returned[0].Endpoint)
+	require.Zero(t, returned[0].UserID)
+	require.Equal(t, 400, returned[0].StatusCode)
+	require.Equal(t, "settings", returned[2].ObjectType)
+	require.Equal(t, "http://grafana", returned[2].Diff["grafana_url"].After)
+	require.False(t, returned[2].CreatedAt.IsZero())
+
+	returned, total, err = GetAuditEntriesByPage(db, 0, 10, 1, "", 0, time.Time{}, time.Time{}, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Equal(t, "/api/settings", returned[0].Endpoint)
+
+	returned, total, err = GetAuditEntriesByPage(db, 0, 10, 0, "machine", 5, time.Time{}, time.Time{}, SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, "DELETE", returned[0].Method)
+
+	// Paging.
+	returned, total, err = GetAuditEntriesByPage(db, 1, 1, 0, "", 0, time.Time{}, time.Time{}, SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, returned, 1)
+	require.Equal(t, "/api/machines/5", returned[0].Endpoint)
+
+	// Time range.
+	_, total, err = GetAuditEntriesByPage(db, 0, 10, 0, "", 0, time.Now().Add(time.Hour), time.Time{}, SortDirAny)
+	require.NoError(t, err)
+	require.Zero(t, total)
+	_, total, err = GetAuditEntriesByPage(db, 0, 10, 0, "", 0, time.Time{}, time.Now().Add(time.Hour), SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+
+	_, _, err = GetAuditEntriesByPage(db, 0, 0, 0, "", 0, time.Time{}, time.Time{}, SortDirAny)
+	require.Error(t, err)
+}
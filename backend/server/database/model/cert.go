@@ -0,0 +1,61 @@
+package dbmodel
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents a certificate which has been revoked, e.g. because the
+// machine it was issued for has been deleted or the agent has obtained
+// a new certificate.
+type RevokedCert struct {
+	SerialNumber int64 `pg:",pk"`
+	RevokedAt    time.Time
+}
+
+// Returns a new unique serial number for a certificate issued by the
+// internal CA.
+func GetNewCertSerialNumber(db *pg.DB) (serialNumber int64, err error) {
+	_, err = db.QueryOne(pg.Scan(&serialNumber), "SELECT nextval('certs_serial_number_seq')")
+	if err != nil {
+		err = errors.Wrapf(err, "problem with getting new certificate serial number")
+	}
+	return serialNumber, err
+}
+
+// Marks the certificate with the given serial number as revoked.
+// Revoking the same certificate twice is not an error.
+func RevokeCert(dbIface interface{}, serialNumber int64) error {
+	tx, rollback, commit, err := dbops.Transaction(dbIface)
+	if err != nil {
+		return errors.WithMessagef(err, "problem with starting transaction for revoking certificate %d", serialNumber)
+	}
+	defer rollback()
+
+	revoked := RevokedCert{
+		SerialNumber: serialNumber,
+	}
+	_, err = tx.Model(&revoked).OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return errors.Wrapf(err, "problem with revoking certificate %d", serialNumber)
+	}
+
+	err = commit()
+	if err != nil {
+		err = errors.WithMessagef(err, "problem with committing revocation of certificate %d", serialNumber)
+	}
+	return err
+}
+
+// Checks if the certificate with the given serial number has been revoked.
+func IsCertRevoked(db *pg.DB, serialNumber int64) (bool, error) {
+	count, err := db.Model((*RevokedCert)(nil)).Where("serial_number = ?", serialNumber).Count()
+	if err != nil {
+		return false, errors.Wrapf(err, "problem with checking if certificate %d is revoked", serialNumber)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,125 @@
+package dbmodel
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents a finding of the configuration review held in the
+// config_review_finding table. The checker is the name of the checker
+// which produced the finding.
+type ConfigReviewFinding struct {
+	ID         int64
+	CreatedAt  time.Time
+	AppID      int64
+	DaemonName string
+	Checker    string
+	Text       string
+}
+
+// Represents the state of the configuration checker held in the
+// config_checker table.
+type ConfigChecker struct {
+	Name    string `pg:",pk"`
+	Enabled bool   `pg:",use_zero"`
+}
+
+// Replaces the findings of the previous review of the daemon's
+// configuration with the new ones.
+func ReplaceConfigReviewFindings(dbIface interface{}, appID int64, daemonName string, findings []ConfigReviewFinding) error {
+	tx, rollback, commit, err := dbops.Transaction(dbIface)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	_, err = tx.Model((*ConfigReviewFinding)(nil)).
+		Where("app_id = ?", appID).
+		Where("daemon_name = ?", daemonName).
+		Delete()
+	if err != nil {
+		return errors.Wrapf(err, "problem with deleting configuration review findings of %s daemon of app %d",
+			daemonName, appID)
+	}
+	for i := range findings {
+		finding := &findings[i]
+		finding.AppID = appID
+		finding.DaemonName = daemonName
+		_, err = tx.Model(finding).Insert()
+		if err != nil {
+			return errors.Wrapf(err, "problem with inserting configuration review finding of %s daemon of app %d",
+				daemonName, appID)
+		}
+	}
+	err = commit()
+	if err != nil {
+		return errors.WithMessagef(err, "problem with committing configuration review findings of %s daemon of app %d",
+			daemonName, appID)
+	}
+	return nil
+}
+
+// Fetches the findings of the most recent review of the daemon's
+// configuration ordered by the checker name.
+func GetConfigReviewFindings(db *dbops.PgDB, appID int64, daemonName string) ([]ConfigReviewFinding, error) {
+	findings := []ConfigReviewFinding{}
+	err := db.Model(&findings).
+		Where("app_id = ?", appID).
+		Where("daemon_name = ?", daemonName).
+		OrderExpr("checker ASC, id ASC").
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting configuration review findings of %s daemon of app %d",
+			daemonName, appID)
+	}
+	return findings, nil
+}
+
+// Fetches the states of the checkers enabled or disabled by the users.
+func GetConfigCheckers(db *dbops.PgDB) ([]ConfigChecker, error) {
+	checkers := []ConfigChecker{}
+	err := db.Model(&checkers).OrderExpr("name ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting configuration checkers")
+	}
+	return checkers, nil
+}
+
+// Enables or disables the given checker. The findings of the disabled
+// checker are deleted, so they are no longer presented to the users.
+func SetConfigCheckerEnabled(db *dbops.PgDB, name string, enabled bool) error {
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	checker := &ConfigChecker{
+		Name:    name,
+		Enabled: enabled,
+	}
+	_, err = tx.Model(checker).
+		OnConflict("(name) DO UPDATE").
+		Set("enabled = EXCLUDED.enabled").
+		Insert()
+	if err != nil {
+		return errors.Wrapf(err, "problem with updating configuration checker %s", name)
+	}
+	if !enabled {
+		_, err = tx.Model((*ConfigReviewFinding)(nil)).
+			Where("checker = ?", name).
+			Delete()
+		if err != nil {
+			return errors.Wrapf(err, "problem with deleting configuration review findings of checker %s", name)
+		}
+	}
+	err = commit()
+	if err != nil {
+		return errors.WithMessagef(err, "problem with committing configuration checker %s", name)
+	}
+	return nil
+}
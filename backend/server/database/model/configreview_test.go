@@ -0,0 +1,120 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the findings of the previous review are replaced.
+func TestReplaceConfigReviewFindings(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+	app := &App{
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+	}
+	err = AddApp(db, app)
+	require.NoError(t, err)
+
+	findings := []ConfigReviewFinding{
+		{Checker: "subnets_overlap", Text: "foo"},
+		{Checker: "control_socket", Text: "bar"},
+	}
+	err = ReplaceConfigReviewFindings(db, app.ID, "dhcp4", findings)
+	require.NoError(t, err)
+	err = ReplaceConfigReviewFindings(db, app.ID, "dhcp6", []ConfigReviewFinding{{Checker: "control_socket", Text: "baz"}})
+	require.NoError(t, err)
+
+	returned, err := GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, returned, 2)
+	require.Equal(t, "control_socket", returned[0].Checker)
+	require.Equal(t, "bar", returned[0].Text)
+	require.NotZero(t, returned[0].CreatedAt)
+	require.Equal(t, "subnets_overlap", returned[1].Checker)
+
+	err = ReplaceConfigReviewFindings(db, app.ID, "dhcp4", nil)
+	require.NoError(t, err)
+	returned, err = GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Empty(t, returned)
+
+	// The findings of other daemons are preserved.
+	returned, err = GetConfigReviewFindings(db, app.ID, "dhcp6")
+	require.NoError(t, err)
+	require.Len(t, returned, 1)
+}
+
+// Test that the checkers can be enabled and disabled.
+func TestSetConfigCheckerEnabled(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	checkers, err := GetConfigCheckers(db)
+	require.NoError(t, err)
+	require.Empty(t, checkers)
+
+	err = SetConfigCheckerEnabled(db, "subnets_overlap", false)
+	require.NoError(t, err)
+	err = SetConfigCheckerEnabled(db, "control_socket", false)
+	require.NoError(t, err)
+	err = SetConfigCheckerEnabled(db, "control_socket", true)
+	require.NoError(t, err)
+
+	checkers, err = GetConfigCheckers(db)
+	require.NoError(t, err)
+	require.Len(t, checkers, 2)
+	require.Equal(t, "control_socket", checkers[0].Name)
+	require.True(t, checkers[0].Enabled)
+	require.Equal(t, "subnets_overlap", checkers[1].Name)
+	require.False(t, checkers[1].Enabled)
+}
+
+// Test that the findings of the disabled checker are deleted.
+func TestSetConfigCheckerEnabledDeletesFindings(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+	app := &App{
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+	}
+	err = AddApp(db, app)
+	require.NoError(t, err)
+
+	findings := []ConfigReviewFinding{
+		{Checker: "subnets_overlap", Text: "foo"},
+		{Checker: "control_socket", Text: "bar"},
+	}
+	err = ReplaceConfigReviewFindings(db, app.ID, "dhcp4", findings)
+	require.NoError(t, err)
+
+	// Enabling the checker preserves its findings.
+	err = SetConfigCheckerEnabled(db, "subnets_overlap", true)
+	require.NoError(t, err)
+	returned, err := GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, returned, 2)
+
+	err = SetConfigCheckerEnabled(db, "subnets_overlap", false)
+	require.NoError(t, err)
+	returned, err = GetConfigReviewFindings(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, returned, 1)
+	require.Equal(t, "control_socket", returned[0].Checker)
+}
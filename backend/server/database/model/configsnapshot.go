@@ -0,0 +1,132 @@
+package dbmodel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents a version of the Kea daemon's configuration held in the
+// kea_config_snapshot table. The versions are numbered from 1 for each
+// daemon of the app. The hash allows for detecting whether the
+// configuration fetched from the daemon differs from the most recent
+// snapshot.
+type KeaConfigSnapshot struct {
+	ID         int64
+	CreatedAt  time.Time
+	AppID      int64
+	DaemonName string
+	Version    int64
+	Hash       string
+	Config     *KeaConfig
+}
+
+// Returns the SHA-256 hash of the configuration. The JSON encoder sorts
+// the keys of the maps, so the equal configurations have equal hashes.
+func HashKeaConfig(config *KeaConfig) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", errors.Wrapf(err, "problem with serializing Kea configuration")
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Adds a new version of the configuration of the given daemon unless it
+// is the same as the most recent version. The returned flag indicates
+// whether the new version has been added. If it hasn't, the most recent
+// version is returned.
+func AddKeaConfigSnapshot(dbIface interface{}, appID int64, daemonName string, config *KeaConfig) (*KeaConfigSnapshot, bool, error) {
+	hash, err := HashKeaConfig(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, rollback, commit, err := dbops.Transaction(dbIface)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rollback()
+
+	latest := &KeaConfigSnapshot{}
+	err = tx.Model(latest).
+		ExcludeColumn("config").
+		Where("app_id = ?", appID).
+		Where("daemon_name = ?", daemonName).
+		OrderExpr("version DESC").
+		Limit(1).
+		Select()
+	switch {
+	case err == pg.ErrNoRows:
+		latest = nil
+	case err != nil:
+		return nil, false, errors.Wrapf(err, "problem with getting latest configuration of %s daemon of app %d",
+			daemonName, appID)
+	case latest.Hash == hash:
+		return latest, false, nil
+	}
+
+	snapshot := &KeaConfigSnapshot{
+		AppID:      appID,
+		DaemonName: daemonName,
+		Version:    1,
+		Hash:       hash,
+		Config:     config,
+	}
+	if latest != nil {
+		snapshot.Version = latest.Version + 1
+	}
+	_, err = tx.Model(snapshot).Insert()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "problem with inserting configuration version %d of %s daemon of app %d",
+			snapshot.Version, daemonName, appID)
+	}
+	err = commit()
+	if err != nil {
+		return nil, false, errors.WithMessagef(err, "problem with committing configuration version %d of %s daemon of app %d",
+			snapshot.Version, daemonName, appID)
+	}
+	return snapshot, true, nil
+}
+
+// Fetches the versions of the configuration of the given daemon without
+// the configurations themselves. The most recent version goes first.
+func GetKeaConfigSnapshots(db *dbops.PgDB, appID int64, daemonName string) ([]KeaConfigSnapshot, error) {
+	snapshots := []KeaConfigSnapshot{}
+	err := db.Model(&snapshots).
+		ExcludeColumn("config").
+		Where("app_id = ?", appID).
+		Where("daemon_name = ?", daemonName).
+		OrderExpr("version DESC").
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting configurations of %s daemon of app %d",
+			daemonName, appID)
+	}
+	return snapshots, nil
+}
+
+// Fetches the given version of the configuration of the daemon. It
+// returns nil if there is no such version.
+func GetKeaConfigSnapshot(db *dbops.PgDB, appID int64, daemonName string, version int64) (*KeaConfigSnapshot, error) {
+	snapshot := &KeaConfigSnapshot{}
+	err := db.Model(snapshot).
+		Where("app_id = ?", appID).
+		Where("daemon_name = ?", daemonName).
+		Where("version = ?", version).
+		Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "problem with getting configuration version %d of %s daemon of app %d",
+			version, daemonName, appID)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,105 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the equal configurations have equal hashes.
+func TestHashKeaConfig(t *testing.T) {
+	config1, err := NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 4000, "renew-timer": 1000}}`)
+	require.NoError(t, err)
+	config2, err := NewKeaConfigFromJSON(`{"Dhcp4": {"renew-timer": 1000, "valid-lifetime": 4000}}`)
+	require.NoError(t, err)
+	config3, err := NewKeaConfigFromJSON(`{"Dhcp4": {"renew-timer": 1000, "valid-lifetime": 4001}}`)
+	require.NoError(t, err)
+
+	hash1, err := HashKeaConfig(config1)
+	require.NoError(t, err)
+	require.Len(t, hash1, 64)
+	hash2, err := HashKeaConfig(config2)
+	require.NoError(t, err)
+	require.Equal(t, hash1, hash2)
+	hash3, err := HashKeaConfig(config3)
+	require.NoError(t, err)
+	require.NotEqual(t, hash1, hash3)
+}
+
+// Test that a new version of the configuration is only added when the
+// configuration changes.
+func TestAddKeaConfigSnapshot(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+	app := &App{
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+	}
+	err = AddApp(db, app)
+	require.NoError(t, err)
+
+	config1, err := NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 4000}}`)
+	require.NoError(t, err)
+	config2, err := NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 5000}}`)
+	require.NoError(t, err)
+
+	snapshot, added, err := AddKeaConfigSnapshot(db, app.ID, "dhcp4", config1)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.EqualValues(t, 1, snapshot.Version)
+
+	snapshot, added, err = AddKeaConfigSnapshot(db, app.ID, "dhcp4", config1)
+	require.NoError(t, err)
+	require.False(t, added)
+	require.EqualValues(t, 1, snapshot.Version)
+
+	snapshot, added, err = AddKeaConfigSnapshot(db, app.ID, "dhcp4", config2)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.EqualValues(t, 2, snapshot.Version)
+
+	// Reverting to the previous configuration is also a change.
+	snapshot, added, err = AddKeaConfigSnapshot(db, app.ID, "dhcp4", config1)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.EqualValues(t, 3, snapshot.Version)
+
+	// The versions are numbered for each daemon separately.
+	snapshot, added, err = AddKeaConfigSnapshot(db, app.ID, "dhcp6", config1)
+	require.NoError(t, err)
+	require.True(t, added)
+	require.EqualValues(t, 1, snapshot.Version)
+
+	snapshots, err := GetKeaConfigSnapshots(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 3)
+	require.EqualValues(t, 3, snapshots[0].Version)
+	require.EqualValues(t, 1, snapshots[2].Version)
+	require.Equal(t, snapshots[0].Hash, snapshots[2].Hash)
+	require.Nil(t, snapshots[0].Config)
+	require.NotZero(t, snapshots[0].CreatedAt)
+
+	returned, err := GetKeaConfigSnapshot(db, app.ID, "dhcp4", 2)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, config2, returned.Config)
+
+	returned, err = GetKeaConfigSnapshot(db, app.ID, "dhcp4", 4)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+
+	// The snapshots are deleted along with the app.
+	err = DeleteApp(db, app)
+	require.NoError(t, err)
+	snapshots, err = GetKeaConfigSnapshots(db, app.ID, "dhcp4")
+	require.NoError(t, err)
+	require.Empty(t, snapshots)
+}
@@ -0,0 +1,104 @@
+package dbmodel
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+)
+
+// Severity of an event.
+type EventLevel int
+
+const (
+	EvInfo EventLevel = iota
+	EvWarning
+	EvError
+)
+
+// Returns the name of the event level used in the logs and the ReST API.
+func (level EventLevel) String() string {
+	switch level {
+	case EvWarning:
+		return "warning"
+	case EvError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// Represents an event held in event table in the database. The event may
+// refer to a machine, app, daemon and subnet it is related to. The ID of
+// the object is 0 if the event is not related to any object of the given
+// type or the object has been deleted.
+type Event struct {
+	ID        int64
+	CreatedAt time.Time
+	Text      string
+	Level     EventLevel `pg:",use_zero"`
+	Details   string
+
+	MachineID int64
+	AppID     int64
+	DaemonID  int64
+	SubnetID  int64
+}
+
+// Adds an event to the database.
+func AddEvent(db *pg.DB, event *Event) error {
+	err := db.Insert(event)
+	if err != nil {
+		err = errors.Wrapf(err, "problem with inserting event %+v", event)
+	}
+	return err
+}
+
+// Fetches a collection of events from the database. The offset and
+// limit specify the beginning of the page and the maximum size of the
+// page. Only the events with the given level or higher are returned.
+// The machineID, appID, daemonID and subnetID limit the events to the
+// ones related to the given objects. The 0 value disables filtering by
+// the given object. The events are sorted by the time they were created,
+// the most recent first unless SortDirAsc is specified. This function
+// returns a collection of events, the total number of events matching
+// the filters and error.
+func GetEventsByPage(db *pg.DB, offset, limit int64, level EventLevel, machineID, appID, daemonID, subnetID int64, sortDir SortDirEnum) ([]Event, int64, error) {
+	if limit == 0 {
+		return nil, 0, errors.New("limit should be greater than 0")
+	}
+	events := []Event{}
+	q := db.Model(&events)
+
+	if level > EvInfo {
+		q = q.Where("event.level >= ?", level)
+	}
+	if machineID != 0 {
+		q = q.Where("event.machine_id = ?", machineID)
+	}
+	if appID != 0 {
+		q = q.Where("event.app_id = ?", appID)
+	}
+	if daemonID != 0 {
+		q = q.Where("event.daemon_id = ?", daemonID)
+	}
+	if subnetID != 0 {
+		q = q.Where("event.subnet_id = ?", subnetID)
+	}
+
+	// The most recent events are the most interesting ones so they are
+	// returned first by default.
+	if sortDir == SortDirAsc {
+		q = q.OrderExpr("event.created_at ASC, event.id ASC")
+	} else {
+		q = q.OrderExpr("event.created_at DESC, event.id DESC")
+	}
+	q = q.Offset(int(offset))
+	q = q.Limit(int(limit))
+
+	total, err := q.SelectAndCount()
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "problem with getting events")
+	}
+	return events, int64(total), nil
+}
@@ -0,0 +1,126 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the event level names are returned.
+func TestEventLevelString(t *testing.T) {
+	require.Equal(t, "info", EvInfo.String())
+	require.Equal(t, "warning", EvWarning.String())
+	require.Equal(t, "error", EvError.String())
+}
+
+// Test that the events can be added and fetched by page with filtering.
+func TestGetEventsByPage(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+
+	a := &App{
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+		Daemons: []*Daemon{
+			NewKeaDaemon(DaemonNameDHCPv4, true),
+		},
+	}
+	err = AddApp(db, a)
+	require.NoError(t, err)
+
+	events := []*Event{
+		{
+			Text:      "machine added",
+			Level:     EvInfo,
+			MachineID: m.ID,
+		},
+		{
+			Text:      "daemon is down",
+			Level:     EvError,
+			MachineID: m.ID,
+			AppID:     a.ID,
+			DaemonID:  a.Daemons[0].ID,
+		},
+		{
+			Text:  "something is wrong",
+			Level: EvWarning,
+		},
+	}
+	for _, ev := range events {
+		err = AddEvent(db, ev)
+		require.NoError(t, err)
+		require.NotZero(t, ev.ID)
+		require.False(t, ev.CreatedAt.IsZero())
+	}
+
+	// The most recent events are returned first.
+	page, total, err := GetEventsByPage(db, 0, 10, EvInfo, 0, 0, 0, 0, SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, page, 3)
+	require.Equal(t, "something is wrong", page[0].Text)
+	require.Equal(t, "machine added", page[2].Text)
+
+	page, total, err = GetEventsByPage(db, 0, 10, EvInfo, 0, 0, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Equal(t, "machine added", page[0].Text)
+
+	// Paging.
+	page, total, err = GetEventsByPage(db, 1, 1, EvInfo, 0, 0, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, page, 1)
+	require.Equal(t, "daemon is down", page[0].Text)
+
+	// Filtering by level.
+	page, total, err = GetEventsByPage(db, 0, 10, EvWarning, 0, 0, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Equal(t, "daemon is down", page[0].Text)
+	require.Equal(t, EvError, page[0].Level)
+	require.Equal(t, "something is wrong", page[1].Text)
+
+	// Filtering by related objects.
+	page, total, err = GetEventsByPage(db, 0, 10, EvInfo, m.ID, 0, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Equal(t, "machine added", page[0].Text)
+
+	page, total, err = GetEventsByPage(db, 0, 10, EvInfo, 0, a.ID, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, a.Daemons[0].ID, page[0].DaemonID)
+
+	page, total, err = GetEventsByPage(db, 0, 10, EvInfo, 0, 0, a.Daemons[0].ID, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Equal(t, "daemon is down", page[0].Text)
+
+	_, total, err = GetEventsByPage(db, 0, 10, EvInfo, 0, 0, 0, 1234, SortDirAsc)
+	require.NoError(t, err)
+	require.Zero(t, total)
+
+	// The limit must be specified.
+	_, _, err = GetEventsByPage(db, 0, 0, EvInfo, 0, 0, 0, 0, SortDirAsc)
+	require.Error(t, err)
+
+	// The events are kept when the objects they refer to are deleted.
+	err = DeleteApp(db, a)
+	require.NoError(t, err)
+	page, total, err = GetEventsByPage(db, 0, 10, EvError, 0, 0, 0, 0, SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Zero(t, page[0].AppID)
+	require.Zero(t, page[0].DaemonID)
+	require.Equal(t, m.ID, page[0].MachineID)
+}
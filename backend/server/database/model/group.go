@@ -1,6 +1,7 @@
 package dbmodel
 
 import (
+	"github.com/go-pg/pg/v9"
 	"github.com/go-pg/pg/v9/orm"
 	"github.com/pkg/errors"
 
@@ -56,3 +57,43 @@ func GetGroupsByPage(db *dbops.PgDB, offset, limit int64, filterText *string, so
 
 	return groups, int64(total), err
 }
+
+// Adds a new group to the database. It returns true as the first value
+// when the group with the same name already exists.
+func AddGroup(db *dbops.PgDB, group *SystemGroup) (conflict bool, err error) {
+	_, err = db.Model(group).Insert()
+	if err != nil {
+		pgErr, ok := err.(pg.Error)
+		if ok {
+			conflict = pgErr.IntegrityViolation()
+		}
+		err = errors.Wrapf(err, "problem with inserting group %s", group.Name)
+	}
+	return conflict, err
+}
+
+// Fetches a group with a given id from the database. If the group does not
+// exist the nil value is returned.
+func GetGroupByID(db *dbops.PgDB, id int) (*SystemGroup, error) {
+	group := &SystemGroup{}
+	err := db.Model(group).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with fetching group %d from the database", id)
+	}
+	return group, nil
+}
+
+// Fetches a group with a given name from the database. If the group does
+// not exist the nil value is returned.
+func GetGroupByName(db *dbops.PgDB, name string) (*SystemGroup, error) {
+	group := &SystemGroup{}
+	err := db.Model(group).Where("name = ?", name).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with fetching group %s from the database", name)
+	}
+	return group, nil
+}
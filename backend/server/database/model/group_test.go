@@ -14,31 +14,37 @@ func TestGetGroups(t *testing.T) {
 
 	groups, total, err := GetGroupsByPage(db, 0, 10, nil, "", SortDirAny)
 	require.NoError(t, err)
-	require.EqualValues(t, 2, total)
-	// There are two predefined groups.
-	require.Len(t, groups, 2)
+	require.EqualValues(t, 5, total)
+	// There are five predefined groups.
+	require.Len(t, groups, 5)
 
 	// Groups are supposed to be ordered by id.
 	require.Equal(t, 1, groups[0].ID)
 	require.Equal(t, "super-admin", groups[0].Name)
 	require.Equal(t, 2, groups[1].ID)
 	require.Equal(t, "admin", groups[1].Name)
+	require.Equal(t, "read-only", groups[2].Name)
+	require.Equal(t, "dhcp-admin", groups[3].Name)
+	require.Equal(t, "dns-admin", groups[4].Name)
 
 	// check sorting field and order ascending
 	groups, total, err = GetGroupsByPage(db, 0, 10, nil, "name", SortDirAsc)
 	require.NoError(t, err)
-	require.EqualValues(t, 2, total)
-	require.Len(t, groups, 2)
+	require.EqualValues(t, 5, total)
+	require.Len(t, groups, 5)
 	require.Equal(t, "admin", groups[0].Name)
-	require.Equal(t, "super-admin", groups[1].Name)
+	require.Equal(t, "dhcp-admin", groups[1].Name)
+	require.Equal(t, "dns-admin", groups[2].Name)
+	require.Equal(t, "read-only", groups[3].Name)
+	require.Equal(t, "super-admin", groups[4].Name)
 
 	// check sorting field and order descending
 	groups, total, err = GetGroupsByPage(db, 0, 10, nil, "name", SortDirDesc)
 	require.NoError(t, err)
-	require.EqualValues(t, 2, total)
-	require.Len(t, groups, 2)
+	require.EqualValues(t, 5, total)
+	require.Len(t, groups, 5)
 	require.Equal(t, "super-admin", groups[0].Name)
-	require.Equal(t, "admin", groups[1].Name)
+	require.Equal(t, "admin", groups[4].Name)
 
 	// check filtering by text
 	text := "super"
@@ -48,3 +54,42 @@ func TestGetGroups(t *testing.T) {
 	require.Len(t, groups, 1)
 	require.Equal(t, "super-admin", groups[0].Name)
 }
+
+// Test that a group can be added and fetched by id.
+func TestAddGroup(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	group := &SystemGroup{
+		Name:        "operators",
+		Description: "Operators",
+	}
+	conflict, err := AddGroup(db, group)
+	require.NoError(t, err)
+	require.False(t, conflict)
+	require.NotZero(t, group.ID)
+
+	returned, err := GetGroupByID(db, group.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, "operators", returned.Name)
+	require.Equal(t, "Operators", returned.Description)
+
+	// The names of the groups must be unique.
+	conflict, err = AddGroup(db, &SystemGroup{Name: "operators"})
+	require.Error(t, err)
+	require.True(t, conflict)
+
+	returned, err = GetGroupByName(db, "operators")
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, group.ID, returned.ID)
+
+	// Non existing group.
+	returned, err = GetGroupByID(db, 12345)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+	returned, err = GetGroupByName(db, "foo")
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
@@ -220,7 +220,8 @@ func GetHost(db *pg.DB, hostID int64) (*Host, error) {
 		Relation("IPReservations", func(q *orm.Query) (*orm.Query, error) {
 			return q.Order("ip_reservation.id ASC"), nil
 		}).
-		Relation("LocalHosts.App").
+		Relation("Subnet").
+		Relation("LocalHosts.App.AccessPoints").
 		Where("host.id = ?", hostID).
 		Select()
 
@@ -487,6 +488,36 @@ func AddAppToHost(dbIface interface{}, host *Host, app *App, source string, seq
 	return err
 }
 
+// Deletes the association of the app with the host. The host itself is
+// not deleted, even when it is no longer associated with any app.
+func DeleteAppFromHost(dbIface interface{}, hostID, appID int64) error {
+	tx, rollback, commit, err := dbops.Transaction(dbIface)
+	if err != nil {
+		err = errors.WithMessagef(err, "problem with starting transaction for deleting association of the app %d with the host %d",
+			appID, hostID)
+		return err
+	}
+	defer rollback()
+
+	localHost := &LocalHost{
+		AppID:  appID,
+		HostID: hostID,
+	}
+	_, err = tx.Model(localHost).WherePK().Delete()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with deleting association of the app %d with the host %d",
+			appID, hostID)
+		return err
+	}
+
+	err = commit()
+	if err != nil {
+		err = errors.WithMessagef(err, "problem with committing transaction deleting association of the app %d with the host %d",
+			appID, hostID)
+	}
+	return err
+}
+
 // Iterates over the list of hosts and commits them into the database. The hosts
 // can be associated with a subnet or can be made global.
 func commitHostsIntoDB(tx *pg.Tx, hosts []Host, subnetID int64, app *App, source string, seq int64) (err error) {
@@ -589,6 +589,30 @@ func TestDeleteHost(t *testing.T) {
 	require.Nil(t, returned)
 }
 
+// Test that the association of the app with the host can be deleted.
+func TestDeleteAppFromHost(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addTestSubnetApps(t, db)
+	hosts := addTestHosts(t, db)
+
+	host := hosts[0]
+	err := AddAppToHost(db, &host, apps[0], "api", 1)
+	require.NoError(t, err)
+	err = AddAppToHost(db, &host, apps[1], "api", 1)
+	require.NoError(t, err)
+
+	err = DeleteAppFromHost(db, host.ID, apps[0].ID)
+	require.NoError(t, err)
+
+	returned, err := GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Len(t, returned.LocalHosts, 1)
+	require.EqualValues(t, apps[1].ID, returned.LocalHosts[0].AppID)
+}
+
 // Test that an app can be associated with a host.
 func TestAddAppToHost(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
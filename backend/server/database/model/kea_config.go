@@ -304,6 +304,28 @@ func (c *KeaConfig) GetHAHooksLibrary() (path string, params KeaConfigHA, ok boo
 	return path, params, ok
 }
 
+// Returns all subnets found in the configuration, including the subnets
+// belonging to the shared networks. The subnets which can't be parsed are
+// skipped.
+func (c *KeaConfig) GetSubnets() (subnets []KeaConfigSubnet) {
+	for _, name := range []string{"subnet4", "subnet6"} {
+		if list, ok := c.GetTopLevelList(name); ok {
+			var parsed []KeaConfigSubnet
+			_ = mapstructure.Decode(list, &parsed)
+			subnets = append(subnets, parsed...)
+		}
+	}
+	if list, ok := c.GetTopLevelList("shared-networks"); ok {
+		var networks []KeaConfigSharedNetwork
+		_ = mapstructure.Decode(list, &networks)
+		for _, network := range networks {
+			subnets = append(subnets, network.Subnet4...)
+			subnets = append(subnets, network.Subnet6...)
+		}
+	}
+	return subnets
+}
+
 // Matches the prefix of a subnet with the given IP network. If the match is
 // found the local subnet id of that subnet is returned. Otherwise, the value
 // of 0 is returned.
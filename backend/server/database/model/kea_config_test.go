@@ -170,6 +170,25 @@ func TestGetLocalIPv6SubnetID(t *testing.T) {
 	require.EqualValues(t, 0, cfg.GetLocalSubnetID("2001:db8:4::/64"))
 }
 
+// Test that the subnets at the top level and within the shared networks
+// are returned.
+func TestGetSubnets(t *testing.T) {
+	cfg := getTestConfigWithIPv4Subnets(t)
+
+	subnets := cfg.GetSubnets()
+	require.Len(t, subnets, 5)
+	require.EqualValues(t, 123, subnets[0].ID)
+	require.Equal(t, "192.0.2.0/24", subnets[0].Subnet)
+	require.EqualValues(t, 567, subnets[3].ID)
+	require.Equal(t, "10.1.0.0/16", subnets[3].Subnet)
+
+	cfg = getTestConfigWithIPv6Subnets(t)
+	require.Len(t, cfg.GetSubnets(), 5)
+
+	cfg = getTestConfigWithoutHooks(t)
+	require.Empty(t, cfg.GetSubnets())
+}
+
 // Test that a list of configurations of all hooks libraries can be retrieved
 // from the Kea configuration.
 func TestGetHooksLibraries(t *testing.T) {
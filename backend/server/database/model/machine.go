@@ -7,6 +7,8 @@ import (
 	"github.com/go-pg/pg/v9/orm"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	dbops "isc.org/stork/server/database"
 )
 
 // Part of machine table in database that describes state of machine. In DB it is stored as JSONB.
@@ -39,6 +41,23 @@ type Machine struct {
 	Error         string
 	State         MachineState
 	Apps          []*App
+
+	// Serial number of the certificate issued to the agent running
+	// on this machine. It is 0 when the agent hasn't enrolled yet.
+	AgentCertSerial int64
+
+	// Machines registered by the agents are not authorized until an
+	// administrator approves them. The server doesn't pull data from
+	// unauthorized machines.
+	Authorized bool `pg:",use_zero"`
+
+	// Token generated by the agent upon its first registration. It
+	// allows the agent to register again without the server token.
+	AgentToken string
+
+	// Time since when the server can't reach the agent. It is zero when
+	// the agent is reachable.
+	UnreachableSince time.Time
 }
 
 func AddMachine(db *pg.DB, machine *Machine) error {
@@ -97,8 +116,9 @@ func RefreshMachineFromDb(db *pg.DB, machine *Machine) error {
 // returned. sortField allows indicating sort column in database and
 // sortDir allows selection the order of sorting. If sortField is
 // empty then id is used for sorting.  in SortDirAny is used then ASC
-// order is used.
-func GetMachinesByPage(db *pg.DB, offset int64, limit int64, filterText *string, sortField string, sortDir SortDirEnum) ([]Machine, int64, error) {
+// order is used. If authorized is not nil then only authorized or
+// only unauthorized machines are returned.
+func GetMachinesByPage(db *pg.DB, offset int64, limit int64, filterText *string, authorized *bool, sortField string, sortDir SortDirEnum) ([]Machine, int64, error) {
 	if limit == 0 {
 		return nil, 0, errors.New("limit should be greater than 0")
 	}
@@ -107,6 +127,9 @@ func GetMachinesByPage(db *pg.DB, offset int64, limit int64, filterText *string,
 	// prepare query
 	q := db.Model(&machines)
 	q = q.Relation("Apps.AccessPoints")
+	if authorized != nil {
+		q = q.Where("authorized = ?", *authorized)
+	}
 	if filterText != nil {
 		text := "%" + *filterText + "%"
 		q = q.WhereGroup(func(qq *orm.Query) (*orm.Query, error) {
@@ -140,10 +163,104 @@ func GetMachinesByPage(db *pg.DB, offset int64, limit int64, filterText *string,
 	return machines, int64(total), nil
 }
 
+// Fetches all machines without their apps. If authorized is not nil then
+// only authorized or only unauthorized machines are returned.
+func GetAllMachines(db *pg.DB, authorized *bool) ([]Machine, error) {
+	var machines []Machine
+	q := db.Model(&machines)
+	if authorized != nil {
+		q = q.Where("authorized = ?", *authorized)
+	}
+	err := q.OrderExpr("id ASC").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting all machines")
+	}
+	return machines, nil
+}
+
+// Marks the machine with the given agent address and port as unreachable
+// since the given time. The time is not changed if the machine has already
+// been marked as unreachable.
+func SetMachineUnreachable(db *pg.DB, address string, agentPort int64, since time.Time) error {
+	_, err := db.Model((*Machine)(nil)).
+		Set("unreachable_since = ?", since).
+		Where("address = ?", address).
+		Where("agent_port = ?", agentPort).
+		Where("unreachable_since IS NULL").
+		Update()
+	if err != nil {
+		return errors.Wrapf(err, "problem with marking machine %s:%d as unreachable", address, agentPort)
+	}
+	return nil
+}
+
+// Marks the machine with the given agent address and port as reachable.
+func SetMachineReachable(db *pg.DB, address string, agentPort int64) error {
+	_, err := db.Model((*Machine)(nil)).
+		Set("unreachable_since = NULL").
+		Where("address = ?", address).
+		Where("agent_port = ?", agentPort).
+		Update()
+	if err != nil {
+		return errors.Wrapf(err, "problem with marking machine %s:%d as reachable", address, agentPort)
+	}
+	return nil
+}
+
+// Updates the machine which has obtained a new certificate and revokes
+// the certificate previously issued to its agent. Both happen in one
+// transaction, so the old certificate remains valid if the machine
+// can't be updated.
+func UpdateMachineAgentCert(db *pg.DB, machine *Machine, oldSerial int64) error {
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return errors.WithMessagef(err, "problem with starting transaction for updating machine %v", machine.ID)
+	}
+	defer rollback()
+
+	if oldSerial != 0 && oldSerial != machine.AgentCertSerial {
+		err = RevokeCert(tx, oldSerial)
+		if err != nil {
+			return errors.WithMessagef(err, "problem with updating machine %v", machine.ID)
+		}
+	}
+
+	err = tx.Update(machine)
+	if err != nil {
+		return errors.Wrapf(err, "problem with updating machine %v", machine.ID)
+	}
+
+	err = commit()
+	if err != nil {
+		err = errors.WithMessagef(err, "problem with committing update of machine %v", machine.ID)
+	}
+	return err
+}
+
+// Deletes the machine and revokes the certificate issued to its agent,
+// so the agent can't be used anymore until it enrolls again.
 func DeleteMachine(db *pg.DB, machine *Machine) error {
-	err := db.Delete(machine)
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return errors.WithMessagef(err, "problem with starting transaction for deleting machine %v", machine.ID)
+	}
+	defer rollback()
+
+	if machine.AgentCertSerial != 0 {
+		err = RevokeCert(tx, machine.AgentCertSerial)
+		if err != nil {
+			return errors.WithMessagef(err, "problem with deleting machine %v", machine.ID)
+		}
+	}
+
+	err = tx.Delete(machine)
 	if err != nil {
 		return errors.Wrapf(err, "problem with deleting machine %v", machine.ID)
 	}
-	return nil
+
+	err = commit()
+	if err != nil {
+		err = errors.WithMessagef(err, "problem with committing deletion of machine %v", machine.ID)
+	}
+	return err
 }
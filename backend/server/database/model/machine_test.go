@@ -3,6 +3,7 @@ package dbmodel
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -148,7 +149,7 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	defer teardown()
 
 	// no machines yet but try to get some
-	ms, total, err := GetMachinesByPage(db, 0, 10, nil, "", SortDirAny)
+	ms, total, err := GetMachinesByPage(db, 0, 10, nil, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 0, total)
 	require.Len(t, ms, 0)
@@ -182,26 +183,26 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	}
 
 	// get 10 machines from 0
-	ms, total, err = GetMachinesByPage(db, 0, 10, nil, "", SortDirAny)
+	ms, total, err = GetMachinesByPage(db, 0, 10, nil, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 10)
 
 	// get 2 machines out of 10, from 0
-	ms, total, err = GetMachinesByPage(db, 0, 2, nil, "", SortDirAny)
+	ms, total, err = GetMachinesByPage(db, 0, 2, nil, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 2)
 
 	// get 3 machines out of 10, from 2
-	ms, total, err = GetMachinesByPage(db, 2, 3, nil, "", SortDirAny)
+	ms, total, err = GetMachinesByPage(db, 2, 3, nil, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 3)
 
 	// get 10 machines out of 10, from 0, but with '2' in contents; should return 1: 20 and 12
 	text := "2"
-	ms, total, err = GetMachinesByPage(db, 0, 10, &text, "", SortDirAny)
+	ms, total, err = GetMachinesByPage(db, 0, 10, &text, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 2, total)
 	require.Len(t, ms, 2)
@@ -222,7 +223,7 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	require.Empty(t, ms[1].Apps[0].AccessPoints[0].Key)
 
 	// check sorting by id asc
-	ms, total, err = GetMachinesByPage(db, 0, 100, nil, "", SortDirAsc)
+	ms, total, err = GetMachinesByPage(db, 0, 100, nil, nil, "", SortDirAsc)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 10)
@@ -230,7 +231,7 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	require.EqualValues(t, 6, ms[5].ID)
 
 	// check sorting by id desc
-	ms, total, err = GetMachinesByPage(db, 0, 100, nil, "", SortDirDesc)
+	ms, total, err = GetMachinesByPage(db, 0, 100, nil, nil, "", SortDirDesc)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 10)
@@ -238,7 +239,7 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	require.EqualValues(t, 5, ms[5].ID)
 
 	// check sorting by address asc
-	ms, total, err = GetMachinesByPage(db, 0, 100, nil, "address", SortDirAsc)
+	ms, total, err = GetMachinesByPage(db, 0, 100, nil, nil, "address", SortDirAsc)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 10)
@@ -246,7 +247,7 @@ func TestGetMachinesByPageBasic(t *testing.T) {
 	require.EqualValues(t, 5, ms[5].ID)
 
 	// check sorting by address desc
-	ms, total, err = GetMachinesByPage(db, 0, 100, nil, "address", SortDirDesc)
+	ms, total, err = GetMachinesByPage(db, 0, 100, nil, nil, "address", SortDirDesc)
 	require.Nil(t, err)
 	require.EqualValues(t, 10, total)
 	require.Len(t, ms, 10)
@@ -272,19 +273,88 @@ func TestGetMachinesByPageWithFiltering(t *testing.T) {
 
 	// filter machines by json fields: redhat
 	text := "redhat"
-	ms, total, err := GetMachinesByPage(db, 0, 10, &text, "", SortDirAny)
+	ms, total, err := GetMachinesByPage(db, 0, 10, &text, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 1, total)
 	require.Len(t, ms, 1)
 
 	// filter machines by json fields: my
 	text = "my"
-	ms, total, err = GetMachinesByPage(db, 0, 10, &text, "", SortDirAny)
+	ms, total, err = GetMachinesByPage(db, 0, 10, &text, nil, "", SortDirAny)
 	require.Nil(t, err)
 	require.EqualValues(t, 1, total)
 	require.Len(t, ms, 1)
 }
 
+// Check that the machines can be filtered by their authorization state.
+func TestGetMachinesByPageAuthorized(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	for i, authorized := range []bool{true, false, false} {
+		m := &Machine{
+			Address:    fmt.Sprintf("host-%d", i),
+			AgentPort:  8080,
+			Authorized: authorized,
+		}
+		err := AddMachine(db, m)
+		require.NoError(t, err)
+	}
+
+	authorized := true
+	ms, total, err := GetMachinesByPage(db, 0, 10, nil, &authorized, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, ms, 1)
+	require.Equal(t, "host-0", ms[0].Address)
+
+	authorized = false
+	ms, total, err = GetMachinesByPage(db, 0, 10, nil, &authorized, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Len(t, ms, 2)
+	require.False(t, ms[0].Authorized)
+
+	ms, total, err = GetMachinesByPage(db, 0, 10, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, ms, 3)
+}
+
+// Check that the machine can be marked as unreachable and reachable.
+func TestSetMachineUnreachable(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+	require.True(t, m.UnreachableSince.IsZero())
+
+	since := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	err = SetMachineUnreachable(db, "localhost", 8080, since)
+	require.NoError(t, err)
+	m, err = GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.True(t, since.Equal(m.UnreachableSince))
+
+	// Already unreachable machine keeps the original time.
+	err = SetMachineUnreachable(db, "localhost", 8080, since.Add(time.Hour))
+	require.NoError(t, err)
+	m, err = GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.True(t, since.Equal(m.UnreachableSince))
+
+	err = SetMachineReachable(db, "localhost", 8080)
+	require.NoError(t, err)
+	m, err = GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.True(t, m.UnreachableSince.IsZero())
+}
+
 func TestDeleteMachineOnly(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
@@ -375,3 +445,84 @@ func TestRefreshMachineFromDb(t *testing.T) {
 	require.EqualValues(t, 4, m.State.Cpus)
 	require.Equal(t, "some error", m.Error)
 }
+
+// Test that the certificate issued to the agent is revoked when the
+// machine is deleted.
+func TestDeleteMachineRevokesCert(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	serial, err := GetNewCertSerialNumber(db)
+	require.NoError(t, err)
+
+	m := &Machine{
+		Address:         "localhost",
+		AgentPort:       8080,
+		AgentCertSerial: serial,
+	}
+	err = AddMachine(db, m)
+	require.NoError(t, err)
+
+	revoked, err := IsCertRevoked(db, serial)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	err = DeleteMachine(db, m)
+	require.NoError(t, err)
+
+	revoked, err = IsCertRevoked(db, serial)
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
+
+// Test that the previous certificate of the agent is revoked when the
+// machine is updated with the new one.
+func TestUpdateMachineAgentCert(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	oldSerial, err := GetNewCertSerialNumber(db)
+	require.NoError(t, err)
+
+	m := &Machine{
+		Address:         "localhost",
+		AgentPort:       8080,
+		AgentCertSerial: oldSerial,
+	}
+	err = AddMachine(db, m)
+	require.NoError(t, err)
+
+	newSerial, err := GetNewCertSerialNumber(db)
+	require.NoError(t, err)
+	m.AgentCertSerial = newSerial
+	m.AgentToken = "abc"
+	err = UpdateMachineAgentCert(db, m, oldSerial)
+	require.NoError(t, err)
+
+	m, err = GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, newSerial, m.AgentCertSerial)
+	require.Equal(t, "abc", m.AgentToken)
+
+	revoked, err := IsCertRevoked(db, oldSerial)
+	require.NoError(t, err)
+	require.True(t, revoked)
+	revoked, err = IsCertRevoked(db, newSerial)
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	// The certificate is not revoked when the machine can't be updated,
+	// e.g. because another machine has the same address and port.
+	m2 := &Machine{
+		Address:   "localhost",
+		AgentPort: 8081,
+	}
+	err = AddMachine(db, m2)
+	require.NoError(t, err)
+	m2.AgentPort = 8080
+	err = UpdateMachineAgentCert(db, m2, newSerial)
+	require.Error(t, err)
+	revoked, err = IsCertRevoked(db, newSerial)
+	require.NoError(t, err)
+	require.False(t, revoked)
+}
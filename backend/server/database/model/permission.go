@@ -0,0 +1,67 @@
+package dbmodel
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Actions which can be permitted on the resources. The write action
+// implies the read action.
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// Represents a permission granted to a group of users held in permission
+// table in the database. The permission allows for the given action on
+// the resource, e.g. the machines or the DHCP servers. It may be limited
+// to the given machine or app. The 0 values of MachineID and AppID mean
+// that the permission is not limited.
+type Permission struct {
+	ID        int
+	GroupID   int
+	Resource  string
+	Action    string
+	MachineID int64
+	AppID     int64
+}
+
+// Adds a permission to the database.
+func AddPermission(db *dbops.PgDB, permission *Permission) error {
+	_, err := db.Model(permission).Insert()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with inserting permission %+v", permission)
+	}
+	return err
+}
+
+// Fetches the permissions granted to the given groups.
+func GetPermissionsByGroupIDs(db *dbops.PgDB, groupIDs []int) ([]Permission, error) {
+	permissions := []Permission{}
+	if len(groupIDs) == 0 {
+		return permissions, nil
+	}
+	err := db.Model(&permissions).
+		Where("group_id IN (?)", pg.In(groupIDs)).
+		OrderExpr("id ASC").
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting permissions of groups %v", groupIDs)
+	}
+	return permissions, nil
+}
+
+// Deletes the permission of the given group. It returns false when the
+// group had no such permission.
+func DeletePermission(db *dbops.PgDB, groupID, permissionID int) (bool, error) {
+	res, err := db.Model((*Permission)(nil)).
+		Where("id = ?", permissionID).
+		Where("group_id = ?", groupID).
+		Delete()
+	if err != nil {
+		return false, errors.Wrapf(err, "problem with deleting permission %d", permissionID)
+	}
+	return res.RowsAffected() > 0, nil
+}
@@ -0,0 +1,82 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the default groups have the permissions granted by the
+// migrations.
+func TestGetDefaultPermissions(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	permissions, err := GetPermissionsByGroupIDs(db, []int{AdminGroupID})
+	require.NoError(t, err)
+	require.Len(t, permissions, 1)
+	require.Equal(t, "*", permissions[0].Resource)
+	require.Equal(t, PermissionWrite, permissions[0].Action)
+	require.Zero(t, permissions[0].MachineID)
+	require.Zero(t, permissions[0].AppID)
+
+	// The super-admin needs no permissions.
+	permissions, err = GetPermissionsByGroupIDs(db, []int{SuperAdminGroupID})
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+
+	permissions, err = GetPermissionsByGroupIDs(db, nil)
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+}
+
+// Test that the permissions can be added, fetched and deleted.
+func TestAddDeletePermission(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	group := &SystemGroup{
+		Name: "operators",
+	}
+	_, err := AddGroup(db, group)
+	require.NoError(t, err)
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = AddMachine(db, m)
+	require.NoError(t, err)
+
+	permission := &Permission{
+		GroupID:   group.ID,
+		Resource:  "dhcp",
+		Action:    PermissionWrite,
+		MachineID: m.ID,
+	}
+	err = AddPermission(db, permission)
+	require.NoError(t, err)
+	require.NotZero(t, permission.ID)
+
+	permissions, err := GetPermissionsByGroupIDs(db, []int{group.ID, AdminGroupID})
+	require.NoError(t, err)
+	require.Len(t, permissions, 2)
+	require.Equal(t, permission.ID, permissions[1].ID)
+	require.Equal(t, group.ID, permissions[1].GroupID)
+	require.Equal(t, "dhcp", permissions[1].Resource)
+	require.Equal(t, m.ID, permissions[1].MachineID)
+
+	// The permission of another group is not deleted.
+	ok, err := DeletePermission(db, AdminGroupID, permission.ID)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = DeletePermission(db, group.ID, permission.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	permissions, err = GetPermissionsByGroupIDs(db, []int{group.ID})
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+}
@@ -0,0 +1,52 @@
+package dbmodel
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/pkg/errors"
+)
+
+// Names of the secrets held in the secret table.
+const (
+	SecretCAKey       = "cakey"   // private key of the root CA
+	SecretCACert      = "cacert"  // root CA certificate
+	SecretServerKey   = "srvkey"  // private key of the server
+	SecretServerCert  = "srvcert" // certificate the server presents to the agents
+	SecretServerToken = "srvtkn"  // token the agents use to enroll
+)
+
+// Represents a secret held in secret table in the database, e.g.
+// the keys and certificates of the internal CA.
+type Secret struct {
+	Name    string `pg:",pk"`
+	Content string
+}
+
+// Get the content of the secret with the given name. If the secret does
+// not exist, nil is returned without an error.
+func GetSecret(db *pg.DB, name string) ([]byte, error) {
+	secret := Secret{}
+	err := db.Model(&secret).Where("secret.name = ?", name).Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with getting secret %s", name)
+	}
+	return []byte(secret.Content), nil
+}
+
+// Set the content of the secret with the given name. The secret is created
+// if it doesn't exist yet.
+func SetSecret(db *pg.DB, name string, content []byte) error {
+	secret := Secret{
+		Name:    name,
+		Content: string(content),
+	}
+	_, err := db.Model(&secret).
+		OnConflict("(name) DO UPDATE").
+		Set("content = EXCLUDED.content").
+		Insert()
+	if err != nil {
+		return errors.Wrapf(err, "problem with setting secret %s", name)
+	}
+	return nil
+}
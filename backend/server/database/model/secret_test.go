@@ -0,0 +1,60 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the secrets can be stored and fetched.
+func TestSecret(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Non-existing secret.
+	content, err := GetSecret(db, SecretCACert)
+	require.NoError(t, err)
+	require.Nil(t, content)
+
+	err = SetSecret(db, SecretCACert, []byte("foo"))
+	require.NoError(t, err)
+	content, err = GetSecret(db, SecretCACert)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), content)
+
+	// Overwrite the secret.
+	err = SetSecret(db, SecretCACert, []byte("bar"))
+	require.NoError(t, err)
+	content, err = GetSecret(db, SecretCACert)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), content)
+}
+
+// Test that the serial numbers are unique and that the certificates
+// can be revoked.
+func TestCertSerialAndRevocation(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	serial1, err := GetNewCertSerialNumber(db)
+	require.NoError(t, err)
+	serial2, err := GetNewCertSerialNumber(db)
+	require.NoError(t, err)
+	require.Greater(t, serial2, serial1)
+
+	err = RevokeCert(db, serial1)
+	require.NoError(t, err)
+	// Revoking twice is fine.
+	err = RevokeCert(db, serial1)
+	require.NoError(t, err)
+
+	revoked, err := IsCertRevoked(db, serial1)
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	revoked, err = IsCertRevoked(db, serial2)
+	require.NoError(t, err)
+	require.False(t, revoked)
+}
@@ -54,6 +54,11 @@ func InitializeSettings(db *pg.DB) error {
 			ValType: SettingValTypeInt,
 			Value:   "30",
 		},
+		{
+			Name:    "alerting_interval", // in seconds
+			ValType: SettingValTypeInt,
+			Value:   "60",
+		},
 		{
 			Name:    "grafana_url",
 			ValType: SettingValTypeStr,
@@ -50,6 +50,10 @@ func TestInitializeSettings(t *testing.T) {
 	require.NoError(t, err)
 	require.EqualValues(t, 30, val)
 
+	val, err = GetSettingInt(db, "alerting_interval")
+	require.NoError(t, err)
+	require.EqualValues(t, 60, val)
+
 	// change the setting
 	err = SetSettingInt(db, "kea_stats_puller_interval", 123)
 	require.NoError(t, err)
@@ -23,6 +23,7 @@ type SharedNetwork struct {
 
 	AddrUtilization int16
 	PdUtilization   int16
+	AddrExhaustedAt *time.Time
 }
 
 // Adds new shared network to the database.
@@ -303,3 +304,21 @@ func UpdateUtilizationInSharedNetwork(db *pg.DB, sharedNetworkID int64, addrUtil
 	}
 	return err
 }
+
+// Update the forecast time when the addresses in the shared network run
+// out. The nil value means that no exhaustion is expected.
+func UpdateAddrExhaustionInSharedNetwork(db *pg.DB, sharedNetworkID int64, exhaustedAt *time.Time) error {
+	net := &SharedNetwork{
+		ID:              sharedNetworkID,
+		AddrExhaustedAt: exhaustedAt,
+	}
+	q := db.Model(net)
+	q = q.Column("addr_exhausted_at")
+	q = q.WherePK()
+	_, err := q.Update()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with updating addresses exhaustion forecast in the shared network: %d",
+			sharedNetworkID)
+	}
+	return err
+}
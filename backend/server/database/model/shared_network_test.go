@@ -5,6 +5,7 @@ import (
 	dbtest "isc.org/stork/server/database/test"
 
 	"testing"
+	"time"
 )
 
 // Tests that the shared network can be added and retrieved.
@@ -223,6 +224,17 @@ func TestUpdateSharedNetwork(t *testing.T) {
 	require.NotNil(t, returned)
 	require.EqualValues(t, 10, returned.AddrUtilization)
 	require.EqualValues(t, 20, returned.PdUtilization)
+	require.Nil(t, returned.AddrExhaustedAt)
+
+	// update addresses exhaustion forecast
+	exhaustedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = UpdateAddrExhaustionInSharedNetwork(db, network.ID, &exhaustedAt)
+	require.NoError(t, err)
+
+	returned, err = GetSharedNetwork(db, network.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned.AddrExhaustedAt)
+	require.Equal(t, exhaustedAt, returned.AddrExhaustedAt.UTC())
 }
 
 // Tests that the shared network can be deleted.
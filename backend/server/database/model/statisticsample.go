@@ -0,0 +1,213 @@
+package dbmodel
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/pkg/errors"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Resolutions of the statistic samples. The raw samples are the values
+// pulled from the servers. The hourly and daily samples are the averages
+// of the samples from the given hour or day.
+const (
+	StatisticResolutionRaw  = "raw"
+	StatisticResolutionHour = "hour"
+	StatisticResolutionDay  = "day"
+)
+
+// Names of the statistics which history is held in the database. The
+// utilizations are expressed in percents.
+const (
+	StatisticAddrUtilization   = "addr-utilization"
+	StatisticPdUtilization     = "pd-utilization"
+	StatisticAssignedAddresses = "assigned-addresses"
+	StatisticTotalAddresses    = "total-addresses"
+	StatisticDeclinedAddresses = "declined-addresses"
+	StatisticAssignedPds       = "assigned-pds"
+	StatisticTotalPds          = "total-pds"
+	StatisticCacheHits         = "cache-hits"
+	StatisticCacheMisses       = "cache-misses"
+	StatisticCacheHitRatio     = "cache-hit-ratio"
+)
+
+// Periods for which the samples of the given resolution are kept. The
+// older raw and hourly samples are replaced with the samples of the lower
+// resolution and the older daily samples are deleted.
+const (
+	RawStatisticRetention    = 24 * time.Hour
+	HourlyStatisticRetention = 30 * 24 * time.Hour
+	DailyStatisticRetention  = 365 * 24 * time.Hour
+)
+
+// Object the statistic samples belong to: a subnet, a shared network or
+// a daemon of the app. Only one of them should be set.
+type StatisticOwner struct {
+	SubnetID        int64
+	SharedNetworkID int64
+	AppID           int64
+	DaemonName      string
+}
+
+// Represents a sample of the statistic held in the statistic_sample
+// table.
+type StatisticSample struct {
+	ID int64
+	StatisticOwner
+	Name       string
+	Resolution string
+	SampledAt  time.Time
+	Value      float64 `pg:",use_zero"`
+}
+
+// Adds the conditions selecting the samples of the owner to the query.
+func (owner StatisticOwner) applyTo(q *orm.Query) *orm.Query {
+	switch {
+	case owner.SubnetID != 0:
+		return q.Where("subnet_id = ?", owner.SubnetID)
+	case owner.SharedNetworkID != 0:
+		return q.Where("shared_network_id = ?", owner.SharedNetworkID)
+	default:
+		return q.Where("app_id = ?", owner.AppID).Where("daemon_name = ?", owner.DaemonName)
+	}
+}
+
+// Adds the raw samples of the statistics.
+func AddStatisticSamples(db *pg.DB, samples []StatisticSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	for i := range samples {
+		samples[i].Resolution = StatisticResolutionRaw
+	}
+	_, err := db.Model(&samples).Insert()
+	if err != nil {
+		return errors.Wrapf(err, "problem with inserting %d statistic samples", len(samples))
+	}
+	return nil
+}
+
+// Fetches the samples of the statistic of the given owner collected in
+// the given period. The samples of all resolutions are returned ordered
+// by time. They don't overlap, because the samples of higher resolution
+// are removed when they are replaced with the samples of lower resolution.
+func GetStatisticSeries(db *dbops.PgDB, owner StatisticOwner, name string, from, to time.Time) ([]StatisticSample, error) {
+	samples := []StatisticSample{}
+	q := db.Model(&samples)
+	q = owner.applyTo(q)
+	err := q.Where("name = ?", name).
+		Where("sampled_at >= ?", from).
+		Where("sampled_at <= ?", to).
+		OrderExpr("sampled_at ASC").
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting %s statistic samples", name)
+	}
+	return samples, nil
+}
+
+// Fetches the hourly averages of the statistic of all owners in the given
+// period. The raw samples which haven't been replaced with the hourly
+// averages yet are averaged by the query, so the recent hours, for which
+// many raw samples are held, don't outweigh the earlier ones. The series
+// are grouped by the owners and ordered by time.
+func GetHourlyStatisticSeriesByOwner(db *dbops.PgDB, name string, from, to time.Time) (map[StatisticOwner][]StatisticSample, error) {
+	samples := []StatisticSample{}
+	_, err := db.Query(&samples, `
+        SELECT subnet_id, shared_network_id, app_id, daemon_name, name, ? AS resolution,
+            date_trunc('hour', sampled_at) AS sampled_at, AVG(value) AS value
+        FROM statistic_sample
+        WHERE name = ? AND resolution IN (?, ?) AND sampled_at >= ? AND sampled_at <= ?
+        GROUP BY subnet_id, shared_network_id, app_id, daemon_name, name, date_trunc('hour', sampled_at)
+        ORDER BY date_trunc('hour', sampled_at) ASC`,
+		StatisticResolutionHour, name, StatisticResolutionRaw, StatisticResolutionHour, from, to)
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting hourly %s statistic samples", name)
+	}
+	series := make(map[StatisticOwner][]StatisticSample)
+	for _, sample := range samples {
+		series[sample.StatisticOwner] = append(series[sample.StatisticOwner], sample)
+	}
+	return series, nil
+}
+
+// Replaces the samples of the given resolution collected before the
+// cutoff time with their averages in the periods of the given lower
+// resolution. The cutoff must be at the boundary of such period, so as
+// the averages cover the whole periods.
+func downsampleStatisticSamples(tx *pg.Tx, from, to string, cutoff time.Time) error {
+	_, err := tx.Exec(`
+        INSERT INTO statistic_sample (subnet_id, shared_network_id, app_id, daemon_name, name, resolution, sampled_at, value)
+        SELECT subnet_id, shared_network_id, app_id, daemon_name, name, ?, date_trunc(?, sampled_at), AVG(value)
+        FROM statistic_sample
+        WHERE resolution = ? AND sampled_at < ?
+        GROUP BY subnet_id, shared_network_id, app_id, daemon_name, name, date_trunc(?, sampled_at)`,
+		to, to, from, cutoff, to)
+	if err != nil {
+		return errors.Wrapf(err, "problem with averaging %s statistic samples", from)
+	}
+	_, err = tx.Exec(`DELETE FROM statistic_sample WHERE resolution = ? AND sampled_at < ?`, from, cutoff)
+	if err != nil {
+		return errors.Wrapf(err, "problem with deleting averaged %s statistic samples", from)
+	}
+	return nil
+}
+
+// Applies the retention policy to the statistic samples. The raw samples
+// older than a day are replaced with the hourly averages, the hourly
+// samples older than 30 days are replaced with the daily averages and the
+// daily samples older than a year are deleted. The Kea and BIND 9 stats
+// pullers compact the samples independently, so the table is locked to
+// prevent concurrent compactions from averaging the same samples twice.
+func CompactStatisticSamples(db *pg.DB, now time.Time) error {
+	tx, rollback, commit, err := dbops.Transaction(db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	_, err = tx.Exec(`LOCK TABLE statistic_sample IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return errors.Wrapf(err, "problem with locking statistic samples")
+	}
+	err = downsampleStatisticSamples(tx, StatisticResolutionHour, StatisticResolutionDay,
+		now.Add(-HourlyStatisticRetention).Truncate(24*time.Hour))
+	if err != nil {
+		return err
+	}
+	err = downsampleStatisticSamples(tx, StatisticResolutionRaw, StatisticResolutionHour,
+		now.Add(-RawStatisticRetention).Truncate(time.Hour))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM statistic_sample WHERE resolution = ? AND sampled_at < ?`,
+		StatisticResolutionDay, now.Add(-DailyStatisticRetention))
+	if err != nil {
+		return errors.Wrapf(err, "problem with deleting expired statistic samples")
+	}
+	err = commit()
+	if err != nil {
+		return errors.WithMessagef(err, "problem with committing compacted statistic samples")
+	}
+	return nil
+}
+
+// Returns the rate of change of the statistic per hour between the
+// consecutive samples. The rate is assigned to the time of the later
+// sample. The samples must be ordered by time.
+func GetStatisticRates(samples []StatisticSample) []StatisticSample {
+	rates := []StatisticSample{}
+	for i := 1; i < len(samples); i++ {
+		hours := samples[i].SampledAt.Sub(samples[i-1].SampledAt).Hours()
+		if hours <= 0 {
+			continue
+		}
+		rate := samples[i]
+		rate.Value = (samples[i].Value - samples[i-1].Value) / hours
+		rates = append(rates, rate)
+	}
+	return rates
+}
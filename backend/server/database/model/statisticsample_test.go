@@ -0,0 +1,265 @@
+package dbmodel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the statistic samples are added and fetched per owner.
+func TestAddStatisticSamples(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := &Subnet{
+		Prefix: "192.0.2.0/24",
+	}
+	err := AddSubnet(db, subnet)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	samples := []StatisticSample{
+		{
+			StatisticOwner: StatisticOwner{SubnetID: subnet.ID},
+			Name:           StatisticAddrUtilization,
+			SampledAt:      now.Add(-time.Hour),
+			Value:          0,
+		},
+		{
+			StatisticOwner: StatisticOwner{SubnetID: subnet.ID},
+			Name:           StatisticAddrUtilization,
+			SampledAt:      now,
+			Value:          12.5,
+		},
+		{
+			StatisticOwner: StatisticOwner{SubnetID: subnet.ID},
+			Name:           StatisticAssignedAddresses,
+			SampledAt:      now,
+			Value:          32,
+		},
+	}
+	err = AddStatisticSamples(db, samples)
+	require.NoError(t, err)
+
+	series, err := GetStatisticSeries(db, StatisticOwner{SubnetID: subnet.ID}, StatisticAddrUtilization,
+		now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+	require.Zero(t, series[0].Value)
+	require.Equal(t, StatisticResolutionRaw, series[0].Resolution)
+	require.Equal(t, 12.5, series[1].Value)
+	require.Equal(t, now, series[1].SampledAt.UTC())
+
+	// The samples outside of the period are not returned.
+	series, err = GetStatisticSeries(db, StatisticOwner{SubnetID: subnet.ID}, StatisticAddrUtilization,
+		now.Add(-30*time.Minute), now)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+
+	// The samples of other owners are not returned.
+	series, err = GetStatisticSeries(db, StatisticOwner{SubnetID: subnet.ID + 1}, StatisticAddrUtilization,
+		now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	require.Empty(t, series)
+}
+
+// Test that the raw samples are averaged per hour along with the hourly
+// samples and that the series are grouped by the owners.
+func TestGetHourlyStatisticSeriesByOwner(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnets := []*Subnet{{Prefix: "192.0.2.0/24"}, {Prefix: "192.0.3.0/24"}}
+	for _, subnet := range subnets {
+		require.NoError(t, AddSubnet(db, subnet))
+	}
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var samples []StatisticSample
+	for i, value := range []float64{10, 20, 30} {
+		samples = append(samples, StatisticSample{
+			StatisticOwner: StatisticOwner{SubnetID: subnets[0].ID},
+			Name:           StatisticAddrUtilization,
+			SampledAt:      now.Add(-2*time.Hour + time.Duration(i)*20*time.Minute),
+			Value:          value,
+		})
+	}
+	samples = append(samples, StatisticSample{
+		StatisticOwner: StatisticOwner{SubnetID: subnets[1].ID},
+		Name:           StatisticAddrUtilization,
+		SampledAt:      now,
+		Value:          50,
+	}, StatisticSample{
+		StatisticOwner: StatisticOwner{SubnetID: subnets[0].ID},
+		Name:           StatisticAssignedAddresses,
+		SampledAt:      now,
+		Value:          32,
+	})
+	require.NoError(t, AddStatisticSamples(db, samples))
+
+	// The sample which has already been averaged.
+	hourly := StatisticSample{
+		StatisticOwner: StatisticOwner{SubnetID: subnets[0].ID},
+		Name:           StatisticAddrUtilization,
+		Resolution:     StatisticResolutionHour,
+		SampledAt:      now.Add(-5 * time.Hour),
+		Value:          5,
+	}
+	_, err := db.Model(&hourly).Insert()
+	require.NoError(t, err)
+
+	series, err := GetHourlyStatisticSeriesByOwner(db, StatisticAddrUtilization, now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	first := series[StatisticOwner{SubnetID: subnets[0].ID}]
+	require.Len(t, first, 2)
+	require.Equal(t, now.Add(-5*time.Hour), first[0].SampledAt.UTC())
+	require.Equal(t, 5.0, first[0].Value)
+	require.Equal(t, now.Add(-2*time.Hour), first[1].SampledAt.UTC())
+	require.Equal(t, 20.0, first[1].Value)
+	require.Equal(t, StatisticResolutionHour, first[1].Resolution)
+
+	second := series[StatisticOwner{SubnetID: subnets[1].ID}]
+	require.Len(t, second, 1)
+	require.Equal(t, 50.0, second[0].Value)
+}
+
+// Test that the old samples are replaced with the averages and that the
+// expired samples are deleted.
+func TestCompactStatisticSamples(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+	app := &App{
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+	}
+	err = AddApp(db, app)
+	require.NoError(t, err)
+	owner := StatisticOwner{AppID: app.ID, DaemonName: "dhcp4"}
+
+	now := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	samples := []StatisticSample{}
+	add := func(sampledAt time.Time, value float64) {
+		samples = append(samples, StatisticSample{
+			StatisticOwner: owner,
+			Name:           StatisticAssignedAddresses,
+			SampledAt:      sampledAt,
+			Value:          value,
+		})
+	}
+	// Two samples in the hour two days ago.
+	add(now.Add(-48*time.Hour).Truncate(time.Hour), 10)
+	add(now.Add(-48*time.Hour).Truncate(time.Hour).Add(30*time.Minute), 20)
+	// Recent sample.
+	add(now.Add(-time.Hour), 30)
+	err = AddStatisticSamples(db, samples)
+	require.NoError(t, err)
+
+	// Hourly samples 40 days ago and daily sample 2 years ago.
+	for _, sample := range []StatisticSample{
+		{StatisticOwner: owner, Name: StatisticAssignedAddresses, Resolution: StatisticResolutionHour, SampledAt: now.Add(-40 * 24 * time.Hour).Truncate(24 * time.Hour), Value: 1},
+		{StatisticOwner: owner, Name: StatisticAssignedAddresses, Resolution: StatisticResolutionHour, SampledAt: now.Add(-40 * 24 * time.Hour).Truncate(24 * time.Hour).Add(time.Hour), Value: 3},
+		{StatisticOwner: owner, Name: StatisticAssignedAddresses, Resolution: StatisticResolutionDay, SampledAt: now.Add(-2 * DailyStatisticRetention), Value: 5},
+	} {
+		sample := sample
+		_, err = db.Model(&sample).Insert()
+		require.NoError(t, err)
+	}
+
+	err = CompactStatisticSamples(db, now)
+	require.NoError(t, err)
+
+	series, err := GetStatisticSeries(db, owner, StatisticAssignedAddresses, now.Add(-3*DailyStatisticRetention), now)
+	require.NoError(t, err)
+	require.Len(t, series, 3)
+
+	require.Equal(t, StatisticResolutionDay, series[0].Resolution)
+	require.Equal(t, now.Add(-40*24*time.Hour).Truncate(24*time.Hour), series[0].SampledAt.UTC())
+	require.Equal(t, 2.0, series[0].Value)
+
+	require.Equal(t, StatisticResolutionHour, series[1].Resolution)
+	require.Equal(t, now.Add(-48*time.Hour).Truncate(time.Hour), series[1].SampledAt.UTC())
+	require.Equal(t, 15.0, series[1].Value)
+
+	require.Equal(t, StatisticResolutionRaw, series[2].Resolution)
+	require.Equal(t, 30.0, series[2].Value)
+}
+
+// Test that the concurrent compactions, e.g. by the Kea and BIND 9 stats
+// pullers, average the samples only once.
+func TestCompactStatisticSamplesConcurrently(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := &Subnet{
+		Prefix: "192.0.2.0/24",
+	}
+	err := AddSubnet(db, subnet)
+	require.NoError(t, err)
+	owner := StatisticOwner{SubnetID: subnet.ID}
+
+	now := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	hour := now.Add(-48 * time.Hour).Truncate(time.Hour)
+	samples := []StatisticSample{}
+	for i := 0; i < 6; i++ {
+		samples = append(samples, StatisticSample{
+			StatisticOwner: owner,
+			Name:           StatisticAddrUtilization,
+			SampledAt:      hour.Add(time.Duration(i) * 10 * time.Minute),
+			Value:          float64(i),
+		})
+	}
+	err = AddStatisticSamples(db, samples)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- CompactStatisticSamples(db, now)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	series, err := GetStatisticSeries(db, owner, StatisticAddrUtilization, hour.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	require.Equal(t, StatisticResolutionHour, series[0].Resolution)
+	require.Equal(t, 2.5, series[0].Value)
+}
+
+// Test that the rates of change between the samples are computed.
+func TestGetStatisticRates(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	samples := []StatisticSample{
+		{SampledAt: now, Value: 10},
+		{SampledAt: now.Add(30 * time.Minute), Value: 20},
+		{SampledAt: now.Add(30 * time.Minute), Value: 25},
+		{SampledAt: now.Add(150 * time.Minute), Value: 15},
+	}
+	rates := GetStatisticRates(samples)
+	require.Len(t, rates, 2)
+	require.Equal(t, now.Add(30*time.Minute), rates[0].SampledAt)
+	require.Equal(t, 20.0, rates[0].Value)
+	require.Equal(t, now.Add(150*time.Minute), rates[1].SampledAt)
+	require.Equal(t, -5.0, rates[1].Value)
+
+	require.Empty(t, GetStatisticRates(samples[:1]))
+}
@@ -52,6 +52,7 @@ type Subnet struct {
 
 	AddrUtilization int16
 	PdUtilization   int16
+	AddrExhaustedAt *time.Time
 }
 
 // Hook executed after inserting a subnet to the database. It updates subnet
@@ -397,6 +398,32 @@ func GetSubnetsByPage(db *pg.DB, offset, limit, appID, family int64, filterText
 	return subnets, int64(total), err
 }
 
+// Fetches the subnets which addresses are expected to run out, starting
+// from the ones which run out first. The family is used to filter by IPv4
+// (if 4) or IPv6 (if 6). For all other values of the family parameter both
+// IPv4 and IPv6 subnets are returned.
+func GetSubnetsByAddrExhaustion(db *pg.DB, family int64, limit int64) ([]Subnet, error) {
+	subnets := []Subnet{}
+	q := db.Model(&subnets).
+		Relation("AddressPools", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("address_pool.id ASC"), nil
+		}).
+		Relation("SharedNetwork").
+		Relation("LocalSubnets.App.AccessPoints").
+		Relation("LocalSubnets.App.Machine").
+		Where("subnet.addr_exhausted_at IS NOT NULL")
+	if family == 4 || family == 6 {
+		q = q.Where("family(subnet.prefix) = ?", family)
+	}
+	err := q.OrderExpr("subnet.addr_exhausted_at ASC").
+		Limit(int(limit)).
+		Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, errors.Wrapf(err, "problem with getting subnets by addresses exhaustion")
+	}
+	return subnets, nil
+}
+
 // Get list of Subnets with LocalSubnets ordered by SharedNetworkID
 func GetSubnetsWithLocalSubnets(db *pg.DB) ([]*Subnet, error) {
 	subnets := []*Subnet{}
@@ -613,3 +640,18 @@ func (s *Subnet) UpdateUtilization(db *pg.DB, addrUtilization, pdUtilization int
 	}
 	return err
 }
+
+// Update the forecast time when the addresses in the subnet run out. The nil
+// value means that no exhaustion is expected.
+func (s *Subnet) UpdateAddrExhaustion(db *pg.DB, exhaustedAt *time.Time) error {
+	s.AddrExhaustedAt = exhaustedAt
+	q := db.Model(s)
+	q = q.Column("addr_exhausted_at")
+	q = q.WherePK()
+	_, err := q.Update()
+	if err != nil {
+		err = errors.Wrapf(err, "problem with updating addresses exhaustion forecast in the subnet: %d",
+			s.ID)
+	}
+	return err
+}
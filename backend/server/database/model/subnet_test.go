@@ -2,6 +2,7 @@ package dbmodel
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -680,3 +681,49 @@ func TestUpdateUtilization(t *testing.T) {
 	require.EqualValues(t, 10, returnedSubnet2.AddrUtilization)
 	require.EqualValues(t, 20, returnedSubnet2.PdUtilization)
 }
+
+// Test that the addresses exhaustion forecast is stored and that the
+// subnets are fetched by the forecast.
+func TestUpdateAddrExhaustion(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnets := []*Subnet{
+		{Prefix: "192.0.2.0/24"},
+		{Prefix: "192.0.3.0/24"},
+		{Prefix: "2001:db8:1::/64"},
+	}
+	for _, subnet := range subnets {
+		err := AddSubnet(db, subnet)
+		require.NoError(t, err)
+	}
+
+	// No forecasts yet.
+	returned, err := GetSubnetsByAddrExhaustion(db, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, returned)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(48 * time.Hour)
+	err = subnets[0].UpdateAddrExhaustion(db, &later)
+	require.NoError(t, err)
+	err = subnets[1].UpdateAddrExhaustion(db, &now)
+	require.NoError(t, err)
+	err = subnets[2].UpdateAddrExhaustion(db, &now)
+	require.NoError(t, err)
+
+	returned, err = GetSubnetsByAddrExhaustion(db, 4, 10)
+	require.NoError(t, err)
+	require.Len(t, returned, 2)
+	require.Equal(t, subnets[1].ID, returned[0].ID)
+	require.Equal(t, now, returned[0].AddrExhaustedAt.UTC())
+	require.Equal(t, subnets[0].ID, returned[1].ID)
+
+	// Clear the forecast.
+	err = subnets[1].UpdateAddrExhaustion(db, nil)
+	require.NoError(t, err)
+	returned, err = GetSubnetsByAddrExhaustion(db, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, returned, 1)
+	require.Equal(t, subnets[2].ID, returned[0].ID)
+}
@@ -10,14 +10,23 @@ import (
 	dbops "isc.org/stork/server/database"
 )
 
-// Represents a user held in system_user table in the database.
+// Methods of authenticating the users.
+const (
+	AuthMethodInternal = "internal"
+	AuthMethodLDAP     = "ldap"
+)
+
+// Represents a user held in system_user table in the database. The
+// AuthMethod indicates how the user is authenticated. It is only set
+// when the user is created and it defaults to AuthMethodInternal.
 type SystemUser struct {
-	ID       int
-	Login    string
-	Email    string
-	Lastname string
-	Name     string
-	Password string `pg:"password_hash"`
+	ID         int
+	Login      string
+	Email      string
+	Lastname   string
+	Name       string
+	Password   string `pg:"password_hash"`
+	AuthMethod string
 
 	Groups []*SystemGroup `pg:"many2many:system_user_to_group,fk:user_id,joinFK:group_id"`
 }
@@ -118,7 +127,11 @@ func UpdateUser(db *pg.DB, user *SystemUser) (conflict bool, err error) {
 		_ = tx.Rollback()
 	}()
 
-	err = db.Update(user)
+	// The authentication method can't be changed.
+	res, err := db.Model(user).ExcludeColumn("auth_method").WherePK().Update()
+	if err == nil && res.RowsAffected() == 0 {
+		err = pg.ErrNoRows
+	}
 
 	// Delete existing associations of the user with groups.
 	if err == nil {
@@ -274,6 +287,20 @@ func GetUserByID(db *dbops.PgDB, id int) (*SystemUser, error) {
 	return user, err
 }
 
+// Fetches a user with a given login from the database. If the user does
+// not exist the nil value is returned. The user is returned along with the
+// list of groups it belongs to.
+func GetUserByLogin(db *dbops.PgDB, login string) (*SystemUser, error) {
+	user := &SystemUser{}
+	err := db.Model(user).Relation("Groups").Where("login = ?", login).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "problem with fetching user %s from the database", login)
+	}
+	return user, err
+}
+
 // Associates a user with a group. Currently only insertion by group id is supported.
 func (user *SystemUser) AddToGroupByID(db *dbops.PgDB, group *SystemGroup) (added bool, err error) {
 	if group.ID > 0 {
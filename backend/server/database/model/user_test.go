@@ -365,6 +365,23 @@ func TestGetUserByID(t *testing.T) {
 	require.Nil(t, user)
 }
 
+// Test that the user can be fetched by login.
+func TestGetUserByLogin(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// The default user belongs to the super-admin group.
+	user, err := GetUserByLogin(db, "admin")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	require.Equal(t, 1, user.ID)
+	require.True(t, user.InGroup(&SystemGroup{ID: SuperAdminGroupID}))
+
+	user, err = GetUserByLogin(db, "foo")
+	require.NoError(t, err)
+	require.Nil(t, user)
+}
+
 // Test that user associations with groups are created when the user
 // is created or updated.
 func TestUserGroups(t *testing.T) {
@@ -11,30 +11,38 @@ import (
 	"github.com/alexedwards/scs/v2"
 	_ "github.com/lib/pq" // TODO: document why it is blank imported
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
 )
 
+// Key under which the API token used to authenticate the request is stored
+// in the request context.
+type apiTokenContextKey struct{}
+
 // Provides session management mechanisms for Stork. It wraps the scs.SessionManager
-// structure with Stork specific implementation of sessions.
+// structure with Stork specific implementation of sessions. The requests can also
+// be authenticated with the API tokens which are looked up in the database.
 type SessionMgr struct {
 	scsSessionMgr *scs.SessionManager
+	db            *dbops.PgDB
 }
 
 // Creates new session manager instance. The new connection is created using the
-// lib/pq driver via scs.SessionManager.
-func NewSessionMgr(conn *dbops.BaseDatabaseSettings) (*SessionMgr, error) {
+// lib/pq driver via scs.SessionManager. The db is used to authenticate the API
+// tokens.
+func NewSessionMgr(conn *dbops.BaseDatabaseSettings, db *dbops.PgDB) (*SessionMgr, error) {
 	connParams := conn.ConnectionParams()
-	db, err := sql.Open("postgres", connParams)
+	sqlDB, err := sql.Open("postgres", connParams)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error connecting to the database for session management using credentials %s", connParams)
 	}
 
 	s := scs.New()
-	s.Store = postgresstore.New(db)
+	s.Store = postgresstore.New(sqlDB)
 
-	mgr := &SessionMgr{scsSessionMgr: s}
+	mgr := &SessionMgr{scsSessionMgr: s, db: db}
 
 	return mgr, nil
 }
@@ -80,10 +88,48 @@ func (s *SessionMgr) LogoutHandler(ctx context.Context) error {
 	return nil
 }
 
+// Returns the API token from the Authorization header of the request or
+// an empty string if the request has no such header.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
 // Implements middleware which reads the session cookie, loads session data for the
-// user and stores the token/ in the Cookie being sent to the user.
+// user and stores the token/ in the Cookie being sent to the user. If the request
+// includes the API token in the Authorization header, the token is authenticated
+// instead and the request is rejected when the token is invalid or has expired.
 func (s *SessionMgr) SessionMiddleware(handler http.Handler) http.Handler {
-	return s.scsSessionMgr.LoadAndSave(handler)
+	sessionHandler := s.scsSessionMgr.LoadAndSave(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" || s.db == nil {
+			sessionHandler.ServeHTTP(w, r)
+			return
+		}
+		apiToken, err := dbmodel.AuthenticateAPIToken(s.db, token)
+		if err != nil {
+			log.Errorf("%+v", err)
+			http.Error(w, "problem with authenticating API token", http.StatusInternalServerError)
+			return
+		}
+		if apiToken == nil {
+			http.Error(w, "invalid or expired API token", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiTokenContextKey{}, apiToken)
+		sessionHandler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns the API token used to authenticate the request or nil if the
+// request was not authenticated with the token.
+func (s *SessionMgr) APIToken(ctx context.Context) *dbmodel.APIToken {
+	apiToken, _ := ctx.Value(apiTokenContextKey{}).(*dbmodel.APIToken)
+	return apiToken
 }
 
 // Checks if the given session token exists in the database. This is typically used
@@ -96,8 +142,14 @@ func (s *SessionMgr) HasToken(token string) bool {
 // Checks if the user is logged to the system. It is assumed that the session data
 // is already fetched from the database and is stored in the request context.
 // The returned values are: ok - if the user is logged, user identifier and user
-// login.
+// login. If the request was authenticated with the API token, the owner of the
+// token is returned.
 func (s *SessionMgr) Logged(ctx context.Context) (ok bool, user *dbmodel.SystemUser) {
+	// The user authenticated with the API token.
+	if apiToken := s.APIToken(ctx); apiToken != nil {
+		return true, apiToken.User
+	}
+
 	id := s.scsSessionMgr.GetInt(ctx, "userID")
 	// User has no session.
 	if id == 0 {
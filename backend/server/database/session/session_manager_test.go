@@ -26,10 +26,10 @@ func getCookie(response *http.Response, name string) (bool, string) {
 // Tests that new session is created via the middleware.
 func TestMiddlewareNewSession(t *testing.T) {
 	// Reset database schema.
-	_, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
 
-	mgr, err := NewSessionMgr(&dbSettings.BaseDatabaseSettings)
+	mgr, err := NewSessionMgr(&dbSettings.BaseDatabaseSettings, db)
 	require.NoError(t, err)
 
 	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
@@ -90,3 +90,56 @@ func TestMiddlewareNewSession(t *testing.T) {
 	// Check that the session token was stored in the database.
 	require.True(t, mgr.HasToken(value))
 }
+
+// Tests that the request can be authenticated with the API token.
+func TestMiddlewareAPIToken(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	mgr, err := NewSessionMgr(&dbSettings.BaseDatabaseSettings, db)
+	require.NoError(t, err)
+
+	apiToken := &dbmodel.APIToken{
+		UserID: 1,
+		Name:   "ci",
+		Scopes: []string{"dhcp:read"},
+	}
+	token, _, err := dbmodel.AddAPIToken(db, apiToken)
+	require.NoError(t, err)
+
+	handlerCalled := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		logged, user := mgr.Logged(r.Context())
+		require.True(t, logged)
+		require.NotNil(t, user)
+		require.Equal(t, 1, user.ID)
+		require.True(t, user.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID}))
+
+		returned := mgr.APIToken(r.Context())
+		require.NotNil(t, returned)
+		require.Equal(t, []string{"dhcp:read"}, returned.Scopes)
+	}
+	middlewareFunc := mgr.SessionMiddleware(http.HandlerFunc(handler))
+
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	middlewareFunc.ServeHTTP(w, req)
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, handlerCalled)
+
+	// No session is created for the request authenticated with the token.
+	hasCookie, _ := getCookie(resp, "session")
+	require.False(t, hasCookie)
+
+	// Invalid token.
+	handlerCalled = false
+	req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set("Authorization", "Bearer foo")
+	w = httptest.NewRecorder()
+	middlewareFunc.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	require.False(t, handlerCalled)
+}
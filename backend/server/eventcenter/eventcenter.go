@@ -0,0 +1,93 @@
+package eventcenter
+
+import (
+	"github.com/go-pg/pg/v9"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// Creates an event with the given level and text. The objects are the
+// machines, apps, daemons and subnets the event is related to. An app
+// implies its machine and a daemon implies its app, unless the machine
+// or the app is specified explicitly. Other objects and nil pointers
+// are ignored.
+func CreateEvent(level dbmodel.EventLevel, text string, objects ...interface{}) *dbmodel.Event {
+	event := &dbmodel.Event{
+		Text:  text,
+		Level: level,
+	}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *dbmodel.Machine:
+			if o != nil {
+				event.MachineID = o.ID
+			}
+		case *dbmodel.App:
+			if o == nil {
+				continue
+			}
+			event.AppID = o.ID
+			if event.MachineID == 0 {
+				event.MachineID = o.MachineID
+			}
+		case *dbmodel.Daemon:
+			if o == nil {
+				continue
+			}
+			event.DaemonID = o.ID
+			if event.AppID == 0 {
+				event.AppID = o.AppID
+			}
+		case *dbmodel.Subnet:
+			if o != nil {
+				event.SubnetID = o.ID
+			}
+		}
+	}
+	return event
+}
+
+// Logs the event and stores it in the database. The event is only logged
+// when the database is nil. The failure to store the event is logged but
+// not returned, so the code emitting the events doesn't have to care
+// about it.
+func AddEvent(db *pg.DB, event *dbmodel.Event) {
+	fields := log.Fields{}
+	if event.Details != "" {
+		fields["details"] = event.Details
+	}
+	entry := log.WithFields(fields)
+	switch event.Level {
+	case dbmodel.EvError:
+		entry.Error(event.Text)
+	case dbmodel.EvWarning:
+		entry.Warn(event.Text)
+	default:
+		entry.Info(event.Text)
+	}
+
+	if db == nil {
+		return
+	}
+	err := dbmodel.AddEvent(db, event)
+	if err != nil {
+		log.Errorf("problem with storing event: %+v", err)
+	}
+}
+
+// Adds an event with the info level. See CreateEvent for the objects
+// which can be specified.
+func AddInfoEvent(db *pg.DB, text string, objects ...interface{}) {
+	AddEvent(db, CreateEvent(dbmodel.EvInfo, text, objects...))
+}
+
+// Adds an event with the warning level.
+func AddWarningEvent(db *pg.DB, text string, objects ...interface{}) {
+	AddEvent(db, CreateEvent(dbmodel.EvWarning, text, objects...))
+}
+
+// Adds an event with the error level.
+func AddErrorEvent(db *pg.DB, text string, objects ...interface{}) {
+	AddEvent(db, CreateEvent(dbmodel.EvError, text, objects...))
+}
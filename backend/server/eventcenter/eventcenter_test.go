@@ -0,0 +1,72 @@
+package eventcenter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the references to the related objects are set in the event.
+func TestCreateEvent(t *testing.T) {
+	machine := &dbmodel.Machine{ID: 1}
+	app := &dbmodel.App{ID: 2, MachineID: 1}
+	daemon := &dbmodel.Daemon{ID: 3, AppID: 2}
+	subnet := &dbmodel.Subnet{ID: 4}
+
+	var nilApp *dbmodel.App
+	ev := CreateEvent(dbmodel.EvWarning, "foo", machine, subnet, "ignored", nilApp)
+	require.Equal(t, "foo", ev.Text)
+	require.Equal(t, dbmodel.EvWarning, ev.Level)
+	require.EqualValues(t, 1, ev.MachineID)
+	require.Zero(t, ev.AppID)
+	require.Zero(t, ev.DaemonID)
+	require.EqualValues(t, 4, ev.SubnetID)
+
+	// The daemon implies the app and the app implies the machine.
+	ev = CreateEvent(dbmodel.EvInfo, "bar", daemon, app)
+	require.EqualValues(t, 1, ev.MachineID)
+	require.EqualValues(t, 2, ev.AppID)
+	require.EqualValues(t, 3, ev.DaemonID)
+	require.Zero(t, ev.SubnetID)
+
+	// The explicitly specified objects take precedence.
+	ev = CreateEvent(dbmodel.EvInfo, "baz", &dbmodel.App{ID: 5, MachineID: 6}, machine)
+	require.EqualValues(t, 1, ev.MachineID)
+	require.EqualValues(t, 5, ev.AppID)
+}
+
+// Test that the events are stored in the database.
+func TestAddEvent(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	AddInfoEvent(db, "info", machine)
+	AddWarningEvent(db, "warning")
+	AddErrorEvent(db, "error", machine)
+
+	events, total, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, 0, dbmodel.SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Equal(t, "info", events[0].Text)
+	require.Equal(t, dbmodel.EvInfo, events[0].Level)
+	require.Equal(t, machine.ID, events[0].MachineID)
+	require.Equal(t, dbmodel.EvWarning, events[1].Level)
+	require.Zero(t, events[1].MachineID)
+	require.Equal(t, dbmodel.EvError, events[2].Level)
+
+	// The events are only logged without the database.
+	AddErrorEvent(nil, "not stored")
+	_, total, err = dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvInfo, 0, 0, 0, 0, dbmodel.SortDirAsc)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+}
@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	storkutil "isc.org/stork/util"
+)
+
+const namespace = "stork_server"
+
+// Prometheus collector exporting the state of the monitored network held
+// in the database: the utilization of the subnets and shared networks, the
+// states of the HA services and the reachability of the machines. Unlike
+// the agents' exporters, it covers all machines. The data are fetched from
+// the database upon each scrape. The subnets, shared networks and HA services
+// are labeled with their IDs because their names and prefixes need not be
+// unique.
+type Collector struct {
+	db *dbops.PgDB
+
+	subnetAddrUtilization        *prometheus.Desc
+	subnetPdUtilization          *prometheus.Desc
+	subnetAddrDaysUntilFull      *prometheus.Desc
+	sharedNetworkAddrUtilization *prometheus.Desc
+	sharedNetworkPdUtilization   *prometheus.Desc
+	haServerState                *prometheus.Desc
+	haServerReachable            *prometheus.Desc
+	machineUnreachable           *prometheus.Desc
+	unreachableMachines          *prometheus.Desc
+}
+
+// Creates the collector fetching the data from the given database.
+func NewCollector(db *dbops.PgDB) *Collector {
+	return &Collector{
+		db: db,
+		subnetAddrUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subnet", "addr_utilization"),
+			"Utilization of the addresses in the subnet in percents",
+			[]string{"subnet_id", "subnet", "shared_network"}, nil),
+		subnetPdUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subnet", "pd_utilization"),
+			"Utilization of the delegated prefixes in the subnet in percents",
+			[]string{"subnet_id", "subnet", "shared_network"}, nil),
+		subnetAddrDaysUntilFull: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "subnet", "addr_days_until_full"),
+			"Forecast number of days until the addresses in the subnet run out",
+			[]string{"subnet_id", "subnet", "shared_network"}, nil),
+		sharedNetworkAddrUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "shared_network", "addr_utilization"),
+			"Utilization of the addresses in the shared network in percents",
+			[]string{"shared_network_id", "shared_network", "family"}, nil),
+		sharedNetworkPdUtilization: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "shared_network", "pd_utilization"),
+			"Utilization of the delegated prefixes in the shared network in percents",
+			[]string{"shared_network_id", "shared_network", "family"}, nil),
+		haServerState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ha", "server_state"),
+			"State of the server in the HA service; the value is always 1",
+			[]string{"service_id", "service", "role", "machine", "app_id", "daemon", "state"}, nil),
+		haServerReachable: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "ha", "server_reachable"),
+			"Whether the server in the HA service responds to the status commands",
+			[]string{"service_id", "service", "role", "machine", "app_id", "daemon"}, nil),
+		machineUnreachable: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "machine", "unreachable"),
+			"Whether the agent on the machine is unreachable",
+			[]string{"machine", "address"}, nil),
+		unreachableMachines: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "unreachable_machines"),
+			"Number of authorized machines which agents are unreachable",
+			nil, nil),
+	}
+}
+
+// Sends the descriptions of all metrics returned by the collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.subnetAddrUtilization
+	ch <- c.subnetPdUtilization
+	ch <- c.subnetAddrDaysUntilFull
+	ch <- c.sharedNetworkAddrUtilization
+	ch <- c.sharedNetworkPdUtilization
+	ch <- c.haServerState
+	ch <- c.haServerReachable
+	ch <- c.machineUnreachable
+	ch <- c.unreachableMachines
+}
+
+// Fetches the data from the database and sends them as the metrics. The
+// metrics which data can't be fetched are skipped.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectSubnets(ch)
+	c.collectSharedNetworks(ch)
+	machines := c.collectMachines(ch)
+	c.collectHAServices(ch, machines)
+}
+
+// Returns the name of the machine used in the labels: its hostname or its
+// address if the hostname is unknown.
+func machineName(machine *dbmodel.Machine) string {
+	if machine.State.Hostname != "" {
+		return machine.State.Hostname
+	}
+	return machine.Address
+}
+
+func (c *Collector) collectSubnets(ch chan<- prometheus.Metric) {
+	subnets, err := dbmodel.GetAllSubnets(c.db, 0)
+	if err != nil {
+		log.Errorf("cannot get subnets for metrics: %s", err)
+		return
+	}
+	for _, subnet := range subnets {
+		id := strconv.FormatInt(subnet.ID, 10)
+		sharedNetwork := ""
+		if subnet.SharedNetwork != nil {
+			sharedNetwork = subnet.SharedNetwork.Name
+		}
+		ch <- prometheus.MustNewConstMetric(c.subnetAddrUtilization, prometheus.GaugeValue,
+			float64(subnet.AddrUtilization)/10, id, subnet.Prefix, sharedNetwork)
+		if subnet.GetFamily() == 6 {
+			ch <- prometheus.MustNewConstMetric(c.subnetPdUtilization, prometheus.GaugeValue,
+				float64(subnet.PdUtilization)/10, id, subnet.Prefix, sharedNetwork)
+		}
+		if subnet.AddrExhaustedAt != nil {
+			days := subnet.AddrExhaustedAt.Sub(storkutil.UTCNow()).Hours() / 24
+			if days < 0 {
+				days = 0
+			}
+			ch <- prometheus.MustNewConstMetric(c.subnetAddrDaysUntilFull, prometheus.GaugeValue,
+				days, id, subnet.Prefix, sharedNetwork)
+		}
+	}
+}
+
+func (c *Collector) collectSharedNetworks(ch chan<- prometheus.Metric) {
+	networks, err := dbmodel.GetAllSharedNetworks(c.db, 0)
+	if err != nil {
+		log.Errorf("cannot get shared networks for metrics: %s", err)
+		return
+	}
+	for _, network := range networks {
+		id := strconv.FormatInt(network.ID, 10)
+		family := strconv.Itoa(network.Family)
+		ch <- prometheus.MustNewConstMetric(c.sharedNetworkAddrUtilization, prometheus.GaugeValue,
+			float64(network.AddrUtilization)/10, id, network.Name, family)
+		if network.Family == 6 {
+			ch <- prometheus.MustNewConstMetric(c.sharedNetworkPdUtilization, prometheus.GaugeValue,
+				float64(network.PdUtilization)/10, id, network.Name, family)
+		}
+	}
+}
+
+// Sends the reachability of the authorized machines and returns them
+// indexed by their IDs.
+func (c *Collector) collectMachines(ch chan<- prometheus.Metric) map[int64]*dbmodel.Machine {
+	indexed := make(map[int64]*dbmodel.Machine)
+	authorized := true
+	machines, err := dbmodel.GetAllMachines(c.db, &authorized)
+	if err != nil {
+		log.Errorf("cannot get machines for metrics: %s", err)
+		return indexed
+	}
+	unreachable := 0
+	for i := range machines {
+		machine := &machines[i]
+		indexed[machine.ID] = machine
+		value := 0.0
+		if !machine.UnreachableSince.IsZero() {
+			value = 1
+			unreachable++
+		}
+		ch <- prometheus.MustNewConstMetric(c.machineUnreachable, prometheus.GaugeValue,
+			value, machineName(machine), fmt.Sprintf("%s:%d", machine.Address, machine.AgentPort))
+	}
+	ch <- prometheus.MustNewConstMetric(c.unreachableMachines, prometheus.GaugeValue, float64(unreachable))
+	return indexed
+}
+
+// Sends the last known states of the primary and secondary servers of
+// each HA service.
+func (c *Collector) collectHAServices(ch chan<- prometheus.Metric, machines map[int64]*dbmodel.Machine) {
+	services, err := dbmodel.GetDetailedAllServices(c.db)
+	if err != nil {
+		log.Errorf("cannot get services for metrics: %s", err)
+		return
+	}
+	for _, service := range services {
+		ha := service.HAService
+		if ha == nil {
+			continue
+		}
+		id := strconv.FormatInt(service.ID, 10)
+		name := service.Name
+		if name == "" {
+			name = fmt.Sprintf("service %d", service.ID)
+		}
+		for _, daemon := range service.Daemons {
+			var role, state string
+			var reachable bool
+			switch daemon.ID {
+			case ha.PrimaryID:
+				role, state, reachable = "primary", ha.PrimaryLastState, ha.PrimaryReachable
+			case ha.SecondaryID:
+				role, state, reachable = "secondary", ha.SecondaryLastState, ha.SecondaryReachable
+			default:
+				continue
+			}
+			machine := ""
+			appID := ""
+			if daemon.App != nil {
+				appID = strconv.FormatInt(daemon.App.ID, 10)
+				if m, ok := machines[daemon.App.MachineID]; ok {
+					machine = machineName(m)
+				}
+			}
+			if state != "" {
+				ch <- prometheus.MustNewConstMetric(c.haServerState, prometheus.GaugeValue,
+					1, id, name, role, machine, appID, daemon.Name, state)
+			}
+			value := 0.0
+			if reachable {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.haServerReachable, prometheus.GaugeValue,
+				value, id, name, role, machine, appID, daemon.Name)
+		}
+	}
+}
+
+// Creates the handler serving the metrics collected from the database
+// together with the metrics registered by the server's components, e.g.
+// the agents communication and the pullers, in the default registry.
+func NewHandler(db *dbops.PgDB) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(NewCollector(db)); err != nil {
+		return nil, err
+	}
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}), nil
+}
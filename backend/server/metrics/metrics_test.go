@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the metrics are collected from the database.
+func TestCollector(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	network := &dbmodel.SharedNetwork{
+		Name:   "frontend",
+		Family: 4,
+		Subnets: []dbmodel.Subnet{
+			{
+				Prefix: "192.0.2.0/24",
+			},
+		},
+	}
+	err := dbmodel.AddSharedNetwork(db, network)
+	require.NoError(t, err)
+	err = dbmodel.UpdateUtilizationInSharedNetwork(db, network.ID, 125, 0)
+	require.NoError(t, err)
+	// The shared networks of different servers may have the same names.
+	otherNetwork := &dbmodel.SharedNetwork{
+		Name:   "frontend",
+		Family: 4,
+	}
+	err = dbmodel.AddSharedNetwork(db, otherNetwork)
+	require.NoError(t, err)
+	subnet := &dbmodel.Subnet{
+		Prefix:          "192.0.3.0/24",
+		AddrUtilization: 500,
+	}
+	err = dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+	networkSubnets, err := dbmodel.GetSubnetsByPrefix(db, "192.0.2.0/24")
+	require.NoError(t, err)
+	require.Len(t, networkSubnets, 1)
+
+	for _, address := range []string{"192.0.2.1", "192.0.2.2"} {
+		m := &dbmodel.Machine{
+			Address:    address,
+			AgentPort:  8080,
+			Authorized: true,
+		}
+		err = dbmodel.AddMachine(db, m)
+		require.NoError(t, err)
+	}
+	err = dbmodel.SetMachineUnreachable(db, "192.0.2.2", 8080, time.Now().UTC())
+	require.NoError(t, err)
+
+	collector := NewCollector(db)
+	require.Equal(t, 7, testutil.CollectAndCount(collector))
+
+	expected := fmt.Sprintf(`
+# HELP stork_server_shared_network_addr_utilization Utilization of the addresses in the shared network in percents
+# TYPE stork_server_shared_network_addr_utilization gauge
+stork_server_shared_network_addr_utilization{family="4",shared_network="frontend",shared_network_id="%d"} 12.5
+stork_server_shared_network_addr_utilization{family="4",shared_network="frontend",shared_network_id="%d"} 0
+# HELP stork_server_subnet_addr_utilization Utilization of the addresses in the subnet in percents
+# TYPE stork_server_subnet_addr_utilization gauge
+stork_server_subnet_addr_utilization{shared_network="frontend",subnet="192.0.2.0/24",subnet_id="%d"} 0
+stork_server_subnet_addr_utilization{shared_network="",subnet="192.0.3.0/24",subnet_id="%d"} 50
+# HELP stork_server_unreachable_machines Number of authorized machines which agents are unreachable
+# TYPE stork_server_unreachable_machines gauge
+stork_server_unreachable_machines 1
+`, network.ID, otherNetwork.ID, networkSubnets[0].ID, subnet.ID)
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"stork_server_shared_network_addr_utilization",
+		"stork_server_subnet_addr_utilization",
+		"stork_server_unreachable_machines")
+	require.NoError(t, err)
+}
+
+// Test that the handler serves the metrics from the database and the
+// metrics registered by the other components.
+func TestHandler(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "stork_server_test_gauge",
+		Help: "Test gauge",
+	})
+	prometheus.MustRegister(gauge)
+	defer prometheus.Unregister(gauge)
+
+	handler, err := NewHandler(db)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://localhost/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.EqualValues(t, 200, w.Code)
+	require.Contains(t, w.Body.String(), "stork_server_test_gauge 0")
+	require.Contains(t, w.Body.String(), "stork_server_unreachable_machines 0")
+}
@@ -0,0 +1,448 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	storkalerting "isc.org/stork/server/alerting"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/alerting"
+)
+
+// Converts the time to the format used in the ReST API. The zero time
+// is converted to nil.
+func optionalTimeToRestAPI(t time.Time) *strfmt.DateTime {
+	if t.IsZero() {
+		return nil
+	}
+	dt := strfmt.DateTime(t)
+	return &dt
+}
+
+// Converts the time from the format used in the ReST API. The nil value
+// is converted to the zero time.
+func optionalTimeFromRestAPI(dt *strfmt.DateTime) time.Time {
+	if dt == nil {
+		return time.Time{}
+	}
+	return time.Time(*dt).UTC()
+}
+
+// Converts the alert rule from the database to the format used in the
+// ReST API.
+func alertRuleToRestAPI(dbRule *dbmodel.AlertRule) *models.AlertRule {
+	name := dbRule.Name
+	kind := dbRule.Kind
+	enabled := dbRule.Enabled
+	return &models.AlertRule{
+		ID:             dbRule.ID,
+		Name:           &name,
+		Kind:           &kind,
+		Threshold:      dbRule.Threshold,
+		ClearThreshold: dbRule.ClearThreshold,
+		ForEvaluations: int64(dbRule.ForEvaluations),
+		Enabled:        &enabled,
+		SilencedUntil:  optionalTimeToRestAPI(dbRule.SilencedUntil),
+	}
+}
+
+// Copies the alert rule received over the ReST API to the database model
+// and validates it. The rule is enabled and fires after a single evaluation
+// unless specified otherwise.
+func alertRuleFromRestAPI(rule *models.AlertRule, dbRule *dbmodel.AlertRule) error {
+	if rule == nil || rule.Name == nil || rule.Kind == nil {
+		return fmt.Errorf("alert rule name and kind must be specified")
+	}
+	dbRule.Name = *rule.Name
+	dbRule.Kind = *rule.Kind
+	dbRule.Threshold = rule.Threshold
+	dbRule.ClearThreshold = rule.ClearThreshold
+	dbRule.ForEvaluations = int(rule.ForEvaluations)
+	if dbRule.ForEvaluations == 0 {
+		dbRule.ForEvaluations = 1
+	}
+	dbRule.Enabled = rule.Enabled == nil || *rule.Enabled
+	dbRule.SilencedUntil = optionalTimeFromRestAPI(rule.SilencedUntil)
+	return storkalerting.ValidateRule(dbRule)
+}
+
+// Converts the notification channel from the database to the format used
+// in the ReST API. The SMTP password is not returned and the values of the
+// webhook headers are redacted.
+func alertChannelToRestAPI(dbChannel *dbmodel.AlertChannel) *models.AlertChannel {
+	name := dbChannel.Name
+	channelType := dbChannel.Type
+	enabled := dbChannel.Enabled
+	var headers map[string]string
+	if len(dbChannel.Params.Headers) > 0 {
+		headers = make(map[string]string)
+		for name := range dbChannel.Params.Headers {
+			headers[name] = storkalerting.RedactedHeaderValue
+		}
+	}
+	return &models.AlertChannel{
+		ID:           dbChannel.ID,
+		Name:         &name,
+		Type:         &channelType,
+		Enabled:      &enabled,
+		URL:          dbChannel.Params.URL,
+		Headers:      headers,
+		SMTPAddress:  dbChannel.Params.SMTPAddress,
+		SMTPFrom:     dbChannel.Params.SMTPFrom,
+		SMTPTo:       dbChannel.Params.SMTPTo,
+		SMTPUsername: dbChannel.Params.SMTPUsername,
+	}
+}
+
+// Copies the notification channel received over the ReST API to the
+// database model and validates it. The SMTP password is only replaced
+// when it is specified. The webhook headers sent back with the redacted
+// values keep their stored values. The stored secrets are only kept when
+// the notifications are sent to the same destination, so they can't be
+// sent elsewhere by changing the URL or the SMTP server address.
+func alertChannelFromRestAPI(channel *models.AlertChannel, dbChannel *dbmodel.AlertChannel) error {
+	if channel == nil || channel.Name == nil || channel.Type == nil {
+		return fmt.Errorf("alert channel name and type must be specified")
+	}
+	sameType := dbChannel.Type == *channel.Type
+	password := channel.SMTPPassword
+	if password == "" && channel.SMTPUsername != "" && dbChannel.Params.SMTPPassword != "" {
+		if !sameType || dbChannel.Params.SMTPAddress != channel.SMTPAddress {
+			return fmt.Errorf("SMTP password must be specified again when the SMTP server address changes")
+		}
+		password = dbChannel.Params.SMTPPassword
+	}
+	var headers map[string]string
+	if len(channel.Headers) > 0 {
+		headers = make(map[string]string)
+		for name, value := range channel.Headers {
+			if stored, ok := dbChannel.Params.Headers[name]; ok && value == storkalerting.RedactedHeaderValue {
+				if !sameType || dbChannel.Params.URL != channel.URL {
+					return fmt.Errorf("value of header %s must be specified again when the URL changes", name)
+				}
+				value = stored
+			}
+			headers[name] = value
+		}
+	}
+	dbChannel.Name = *channel.Name
+	dbChannel.Type = *channel.Type
+	dbChannel.Enabled = channel.Enabled == nil || *channel.Enabled
+	dbChannel.Params = dbmodel.AlertChannelParams{}
+	switch dbChannel.Type {
+	case dbmodel.AlertChannelWebhook:
+		dbChannel.Params.URL = channel.URL
+		dbChannel.Params.Headers = headers
+	case dbmodel.AlertChannelSMTP:
+		dbChannel.Params.SMTPAddress = channel.SMTPAddress
+		dbChannel.Params.SMTPFrom = channel.SMTPFrom
+		dbChannel.Params.SMTPTo = channel.SMTPTo
+		dbChannel.Params.SMTPUsername = channel.SMTPUsername
+		dbChannel.Params.SMTPPassword = password
+	}
+	return storkalerting.ValidateChannel(dbChannel)
+}
+
+// Converts the alert from the database to the format used in the ReST API.
+func alertToRestAPI(dbAlert *dbmodel.Alert) *models.Alert {
+	alert := &models.Alert{
+		ID:            dbAlert.ID,
+		RuleID:        dbAlert.RuleID,
+		ObjectKey:     dbAlert.ObjectKey,
+		Description:   dbAlert.Description,
+		Value:         dbAlert.Value,
+		Firing:        dbAlert.Firing,
+		PendingCount:  int64(dbAlert.PendingCount),
+		FiredAt:       optionalTimeToRestAPI(dbAlert.FiredAt),
+		ResolvedAt:    optionalTimeToRestAPI(dbAlert.ResolvedAt),
+		SilencedUntil: optionalTimeToRestAPI(dbAlert.SilencedUntil),
+	}
+	if dbAlert.Rule != nil {
+		alert.RuleName = dbAlert.Rule.Name
+		alert.Kind = dbAlert.Rule.Kind
+	}
+	return alert
+}
+
+// Get all alert rules.
+func (r *RestAPI) GetAlertRules(ctx context.Context, params alerting.GetAlertRulesParams) middleware.Responder {
+	dbRules, err := dbmodel.GetAlertRules(r.Db)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get alert rules from db"
+		rsp := alerting.NewGetAlertRulesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rules := &models.AlertRules{
+		Total: int64(len(dbRules)),
+	}
+	for i := range dbRules {
+		rules.Items = append(rules.Items, alertRuleToRestAPI(&dbRules[i]))
+	}
+	rsp := alerting.NewGetAlertRulesOK().WithPayload(rules)
+	return rsp
+}
+
+// Add new alert rule.
+func (r *RestAPI) CreateAlertRule(ctx context.Context, params alerting.CreateAlertRuleParams) middleware.Responder {
+	dbRule := &dbmodel.AlertRule{}
+	err := alertRuleFromRestAPI(params.Rule, dbRule)
+	if err != nil {
+		msg := fmt.Sprintf("invalid alert rule: %s", err)
+		rsp := alerting.NewCreateAlertRuleDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	err = dbmodel.AddAlertRule(r.Db, dbRule)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot store alert rule %s", dbRule.Name)
+		rsp := alerting.NewCreateAlertRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rule := alertRuleToRestAPI(dbRule)
+	auditObject(ctx, "alert-rule", dbRule.ID, nil, rule)
+	rsp := alerting.NewCreateAlertRuleOK().WithPayload(rule)
+	return rsp
+}
+
+// Update the alert rule. It can be used to disable or silence the rule.
+func (r *RestAPI) UpdateAlertRule(ctx context.Context, params alerting.UpdateAlertRuleParams) middleware.Responder {
+	dbRule, err := dbmodel.GetAlertRuleByID(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get alert rule with id %d from db", params.ID)
+		rsp := alerting.NewUpdateAlertRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbRule == nil {
+		msg := fmt.Sprintf("cannot find alert rule with id %d", params.ID)
+		rsp := alerting.NewUpdateAlertRuleDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	before := alertRuleToRestAPI(dbRule)
+	err = alertRuleFromRestAPI(params.Rule, dbRule)
+	if err != nil {
+		msg := fmt.Sprintf("invalid alert rule: %s", err)
+		rsp := alerting.NewUpdateAlertRuleDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	err = dbmodel.UpdateAlertRule(r.Db, dbRule)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot update alert rule with id %d", params.ID)
+		rsp := alerting.NewUpdateAlertRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rule := alertRuleToRestAPI(dbRule)
+	auditObject(ctx, "alert-rule", dbRule.ID, before, rule)
+	rsp := alerting.NewUpdateAlertRuleOK().WithPayload(rule)
+	return rsp
+}
+
+// Delete the alert rule and its alerts.
+func (r *RestAPI) DeleteAlertRule(ctx context.Context, params alerting.DeleteAlertRuleParams) middleware.Responder {
+	dbRule, err := dbmodel.GetAlertRuleByID(r.Db, params.ID)
+	if err == nil && dbRule != nil {
+		err = dbmodel.DeleteAlertRule(r.Db, params.ID)
+		if err == nil {
+			auditObject(ctx, "alert-rule", params.ID, alertRuleToRestAPI(dbRule), nil)
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete alert rule %d", params.ID)
+		rsp := alerting.NewDeleteAlertRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := alerting.NewDeleteAlertRuleOK()
+	return rsp
+}
+
+// Get all notification channels.
+func (r *RestAPI) GetAlertChannels(ctx context.Context, params alerting.GetAlertChannelsParams) middleware.Responder {
+	dbChannels, err := dbmodel.GetAlertChannels(r.Db, false)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get alert channels from db"
+		rsp := alerting.NewGetAlertChannelsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	channels := &models.AlertChannels{
+		Total: int64(len(dbChannels)),
+	}
+	for i := range dbChannels {
+		channels.Items = append(channels.Items, alertChannelToRestAPI(&dbChannels[i]))
+	}
+	rsp := alerting.NewGetAlertChannelsOK().WithPayload(channels)
+	return rsp
+}
+
+// Add new notification channel.
+func (r *RestAPI) CreateAlertChannel(ctx context.Context, params alerting.CreateAlertChannelParams) middleware.Responder {
+	dbChannel := &dbmodel.AlertChannel{}
+	err := alertChannelFromRestAPI(params.Channel, dbChannel)
+	if err != nil {
+		msg := fmt.Sprintf("invalid alert channel: %s", err)
+		rsp := alerting.NewCreateAlertChannelDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	err = dbmodel.AddAlertChannel(r.Db, dbChannel)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot store alert channel %s", dbChannel.Name)
+		rsp := alerting.NewCreateAlertChannelDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	channel := alertChannelToRestAPI(dbChannel)
+	auditObject(ctx, "alert-channel", dbChannel.ID, nil, channel)
+	rsp := alerting.NewCreateAlertChannelOK().WithPayload(channel)
+	return rsp
+}
+
+// Update the notification channel.
+func (r *RestAPI) UpdateAlertChannel(ctx context.Context, params alerting.UpdateAlertChannelParams) middleware.Responder {
+	dbChannel, err := dbmodel.GetAlertChannelByID(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get alert channel with id %d from db", params.ID)
+		rsp := alerting.NewUpdateAlertChannelDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbChannel == nil {
+		msg := fmt.Sprintf("cannot find alert channel with id %d", params.ID)
+		rsp := alerting.NewUpdateAlertChannelDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	before := alertChannelToRestAPI(dbChannel)
+	err = alertChannelFromRestAPI(params.Channel, dbChannel)
+	if err != nil {
+		msg := fmt.Sprintf("invalid alert channel: %s", err)
+		rsp := alerting.NewUpdateAlertChannelDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	err = dbmodel.UpdateAlertChannel(r.Db, dbChannel)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot update alert channel with id %d", params.ID)
+		rsp := alerting.NewUpdateAlertChannelDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	channel := alertChannelToRestAPI(dbChannel)
+	auditObject(ctx, "alert-channel", dbChannel.ID, before, channel)
+	rsp := alerting.NewUpdateAlertChannelOK().WithPayload(channel)
+	return rsp
+}
+
+// Delete the notification channel.
+func (r *RestAPI) DeleteAlertChannel(ctx context.Context, params alerting.DeleteAlertChannelParams) middleware.Responder {
+	dbChannel, err := dbmodel.GetAlertChannelByID(r.Db, params.ID)
+	if err == nil && dbChannel != nil {
+		err = dbmodel.DeleteAlertChannel(r.Db, params.ID)
+		if err == nil {
+			auditObject(ctx, "alert-channel", params.ID, alertChannelToRestAPI(dbChannel), nil)
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete alert channel %d", params.ID)
+		rsp := alerting.NewDeleteAlertChannelDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := alerting.NewDeleteAlertChannelOK()
+	return rsp
+}
+
+// Get the alerts which are firing, pending or were recently resolved.
+func (r *RestAPI) GetAlerts(ctx context.Context, params alerting.GetAlertsParams) middleware.Responder {
+	firingOnly := params.Firing != nil && *params.Firing
+	dbAlerts, err := dbmodel.GetAlerts(r.Db, firingOnly)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get alerts from db"
+		rsp := alerting.NewGetAlertsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	alerts := &models.Alerts{
+		Total: int64(len(dbAlerts)),
+	}
+	for i := range dbAlerts {
+		alerts.Items = append(alerts.Items, alertToRestAPI(&dbAlerts[i]))
+	}
+	rsp := alerting.NewGetAlertsOK().WithPayload(alerts)
+	return rsp
+}
+
+// Silence the notifications about the alert until the specified time.
+func (r *RestAPI) SilenceAlert(ctx context.Context, params alerting.SilenceAlertParams) middleware.Responder {
+	var until time.Time
+	if params.Silence != nil {
+		until = optionalTimeFromRestAPI(params.Silence.Until)
+	}
+	dbAlert, err := dbmodel.GetAlertByID(r.Db, params.ID)
+	if err == nil && dbAlert != nil {
+		err = dbmodel.SilenceAlert(r.Db, params.ID, until)
+		if err == nil {
+			before := alertToRestAPI(dbAlert)
+			dbAlert.SilencedUntil = until
+			auditObject(ctx, "alert", dbAlert.ID, before, alertToRestAPI(dbAlert))
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot silence alert %d", params.ID)
+		rsp := alerting.NewSilenceAlertDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbAlert == nil {
+		msg := fmt.Sprintf("cannot find alert with id %d", params.ID)
+		rsp := alerting.NewSilenceAlertDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := alerting.NewSilenceAlertOK().WithPayload(alertToRestAPI(dbAlert))
+	return rsp
+}
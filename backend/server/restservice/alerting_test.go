@@ -0,0 +1,300 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/stretchr/testify/require"
+
+	storkalerting "isc.org/stork/server/alerting"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/alerting"
+	storktest "isc.org/stork/server/test"
+)
+
+// Check adding, updating and deleting the alert rules via rest api functions.
+func TestAlertRules(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Invalid threshold.
+	name := "utilization"
+	kind := dbmodel.AlertRuleSubnetUtilization
+	rule := &models.AlertRule{
+		Name:      &name,
+		Kind:      &kind,
+		Threshold: 120,
+	}
+	rsp := rapi.CreateAlertRule(ctx, alerting.CreateAlertRuleParams{Rule: rule})
+	require.IsType(t, &alerting.CreateAlertRuleDefault{}, rsp)
+	defaultRsp := rsp.(*alerting.CreateAlertRuleDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	rule.Threshold = 90
+	rsp = rapi.CreateAlertRule(ctx, alerting.CreateAlertRuleParams{Rule: rule})
+	require.IsType(t, &alerting.CreateAlertRuleOK{}, rsp)
+	created := rsp.(*alerting.CreateAlertRuleOK).Payload
+	require.NotZero(t, created.ID)
+	require.EqualValues(t, 1, created.ForEvaluations)
+	require.True(t, *created.Enabled)
+	require.Nil(t, created.SilencedUntil)
+
+	// Disable and silence the rule.
+	enabled := false
+	until := strfmt.DateTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	rule.Enabled = &enabled
+	rule.SilencedUntil = &until
+	rule.ForEvaluations = 3
+	rsp = rapi.UpdateAlertRule(ctx, alerting.UpdateAlertRuleParams{ID: created.ID, Rule: rule})
+	require.IsType(t, &alerting.UpdateAlertRuleOK{}, rsp)
+
+	rsp = rapi.UpdateAlertRule(ctx, alerting.UpdateAlertRuleParams{ID: created.ID + 1, Rule: rule})
+	require.IsType(t, &alerting.UpdateAlertRuleDefault{}, rsp)
+	updateRsp := rsp.(*alerting.UpdateAlertRuleDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*updateRsp))
+
+	rsp = rapi.GetAlertRules(ctx, alerting.GetAlertRulesParams{})
+	require.IsType(t, &alerting.GetAlertRulesOK{}, rsp)
+	rules := rsp.(*alerting.GetAlertRulesOK).Payload
+	require.EqualValues(t, 1, rules.Total)
+	require.False(t, *rules.Items[0].Enabled)
+	require.EqualValues(t, 3, rules.Items[0].ForEvaluations)
+	require.NotNil(t, rules.Items[0].SilencedUntil)
+	require.Equal(t, until.String(), rules.Items[0].SilencedUntil.String())
+
+	rsp = rapi.DeleteAlertRule(ctx, alerting.DeleteAlertRuleParams{ID: created.ID})
+	require.IsType(t, &alerting.DeleteAlertRuleOK{}, rsp)
+	rsp = rapi.GetAlertRules(ctx, alerting.GetAlertRulesParams{})
+	require.Zero(t, rsp.(*alerting.GetAlertRulesOK).Payload.Total)
+}
+
+// Check that the SMTP password is not returned and it is preserved when
+// the channel is updated without the password.
+func TestAlertChannels(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	name := "email"
+	channelType := dbmodel.AlertChannelSMTP
+	channel := &models.AlertChannel{
+		Name:         &name,
+		Type:         &channelType,
+		SMTPAddress:  "mail.example.org:25",
+		SMTPTo:       []string{"noc@example.org"},
+		SMTPUsername: "stork",
+		SMTPPassword: "secret",
+	}
+	rsp := rapi.CreateAlertChannel(ctx, alerting.CreateAlertChannelParams{Channel: channel})
+	require.IsType(t, &alerting.CreateAlertChannelOK{}, rsp)
+	created := rsp.(*alerting.CreateAlertChannelOK).Payload
+	require.Empty(t, created.SMTPPassword)
+
+	channel.SMTPPassword = ""
+	channel.SMTPFrom = "stork@example.org"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: channel})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+
+	dbChannel, err := dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "secret", dbChannel.Params.SMTPPassword)
+	require.Equal(t, "stork@example.org", dbChannel.Params.SMTPFrom)
+
+	// The password is not kept when the server address changes.
+	channel.SMTPAddress = "mail.example.com:25"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: channel})
+	require.IsType(t, &alerting.UpdateAlertChannelDefault{}, rsp)
+	defaultRsp := rsp.(*alerting.UpdateAlertChannelDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	channel.SMTPPassword = "other"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: channel})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+
+	dbChannel, err = dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "other", dbChannel.Params.SMTPPassword)
+	require.Equal(t, "mail.example.com:25", dbChannel.Params.SMTPAddress)
+
+	// Missing recipients.
+	channel.SMTPTo = nil
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: channel})
+	require.IsType(t, &alerting.UpdateAlertChannelDefault{}, rsp)
+	defaultRsp = rsp.(*alerting.UpdateAlertChannelDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	rsp = rapi.GetAlertChannels(ctx, alerting.GetAlertChannelsParams{})
+	require.IsType(t, &alerting.GetAlertChannelsOK{}, rsp)
+	channels := rsp.(*alerting.GetAlertChannelsOK).Payload
+	require.EqualValues(t, 1, channels.Total)
+	require.Empty(t, channels.Items[0].SMTPPassword)
+	require.Equal(t, "stork", channels.Items[0].SMTPUsername)
+
+	rsp = rapi.DeleteAlertChannel(ctx, alerting.DeleteAlertChannelParams{ID: created.ID})
+	require.IsType(t, &alerting.DeleteAlertChannelOK{}, rsp)
+}
+
+// Test that the values of the webhook headers are not returned and that
+// the redacted values sent back keep the stored values.
+func TestAlertChannelHeadersRedacted(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	name := "hook"
+	channelType := dbmodel.AlertChannelWebhook
+	channel := &models.AlertChannel{
+		Name:    &name,
+		Type:    &channelType,
+		URL:     "https://example.org/hook",
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+	}
+	rsp := rapi.CreateAlertChannel(ctx, alerting.CreateAlertChannelParams{Channel: channel})
+	require.IsType(t, &alerting.CreateAlertChannelOK{}, rsp)
+	created := rsp.(*alerting.CreateAlertChannelOK).Payload
+	require.Equal(t, map[string]string{"Authorization": storkalerting.RedactedHeaderValue}, created.Headers)
+
+	rsp = rapi.GetAlertChannels(ctx, alerting.GetAlertChannelsParams{})
+	require.IsType(t, &alerting.GetAlertChannelsOK{}, rsp)
+	channels := rsp.(*alerting.GetAlertChannelsOK).Payload
+	require.Len(t, channels.Items, 1)
+	require.Equal(t, storkalerting.RedactedHeaderValue, channels.Items[0].Headers["Authorization"])
+
+	// Send back what was returned and add another header.
+	updated := channels.Items[0]
+	updated.Headers["X-Team"] = "noc"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: updated})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+
+	dbChannel, err := dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Authorization": "Bearer secret", "X-Team": "noc"}, dbChannel.Params.Headers)
+
+	// The header which is not sent back is removed.
+	updated.Headers = map[string]string{"X-Team": "ops"}
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: updated})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+
+	dbChannel, err = dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"X-Team": "ops"}, dbChannel.Params.Headers)
+
+	// The redacted values are not accepted when the URL changes.
+	updated.Headers = map[string]string{"Authorization": "Bearer secret", "X-Team": "ops"}
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: updated})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+	updated.Headers["Authorization"] = storkalerting.RedactedHeaderValue
+	updated.URL = "https://example.com/hook"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: updated})
+	require.IsType(t, &alerting.UpdateAlertChannelDefault{}, rsp)
+	defaultRsp := rsp.(*alerting.UpdateAlertChannelDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	dbChannel, err = dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.org/hook", dbChannel.Params.URL)
+
+	// The values must be sent again.
+	updated.Headers["Authorization"] = "Bearer other"
+	rsp = rapi.UpdateAlertChannel(ctx, alerting.UpdateAlertChannelParams{ID: created.ID, Channel: updated})
+	require.IsType(t, &alerting.UpdateAlertChannelOK{}, rsp)
+
+	dbChannel, err = dbmodel.GetAlertChannelByID(db, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/hook", dbChannel.Params.URL)
+	require.Equal(t, map[string]string{"Authorization": "Bearer other", "X-Team": "ops"}, dbChannel.Params.Headers)
+}
+
+// Check getting and silencing the alerts via rest api functions.
+func TestGetAndSilenceAlerts(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	rule := &dbmodel.AlertRule{
+		Name:           "inactive",
+		Kind:           dbmodel.AlertRuleDaemonInactive,
+		ForEvaluations: 1,
+		Enabled:        true,
+	}
+	require.NoError(t, dbmodel.AddAlertRule(db, rule))
+	firing := &dbmodel.Alert{
+		RuleID:      rule.ID,
+		ObjectKey:   "app:1/dhcp4",
+		Description: "dhcp4 daemon is not active",
+		Value:       1,
+		Firing:      true,
+		FiredAt:     time.Now().UTC(),
+	}
+	require.NoError(t, dbmodel.CommitAlert(db, firing))
+	pending := &dbmodel.Alert{
+		RuleID:       rule.ID,
+		ObjectKey:    "app:1/dhcp6",
+		Value:        1,
+		PendingCount: 1,
+	}
+	require.NoError(t, dbmodel.CommitAlert(db, pending))
+
+	rsp := rapi.GetAlerts(ctx, alerting.GetAlertsParams{})
+	require.IsType(t, &alerting.GetAlertsOK{}, rsp)
+	require.EqualValues(t, 2, rsp.(*alerting.GetAlertsOK).Payload.Total)
+
+	firingOnly := true
+	rsp = rapi.GetAlerts(ctx, alerting.GetAlertsParams{Firing: &firingOnly})
+	require.IsType(t, &alerting.GetAlertsOK{}, rsp)
+	alerts := rsp.(*alerting.GetAlertsOK).Payload
+	require.EqualValues(t, 1, alerts.Total)
+	require.Equal(t, "inactive", alerts.Items[0].RuleName)
+	require.Equal(t, dbmodel.AlertRuleDaemonInactive, alerts.Items[0].Kind)
+	require.NotNil(t, alerts.Items[0].FiredAt)
+	require.Nil(t, alerts.Items[0].ResolvedAt)
+
+	until := strfmt.DateTime(time.Now().Add(time.Hour))
+	rsp = rapi.SilenceAlert(ctx, alerting.SilenceAlertParams{
+		ID:      firing.ID,
+		Silence: &models.AlertSilence{Until: &until},
+	})
+	require.IsType(t, &alerting.SilenceAlertOK{}, rsp)
+	require.NotNil(t, rsp.(*alerting.SilenceAlertOK).Payload.SilencedUntil)
+	dbAlert, err := dbmodel.GetAlertByID(db, firing.ID)
+	require.NoError(t, err)
+	require.False(t, dbAlert.SilencedUntil.IsZero())
+
+	// Cancel silencing.
+	rsp = rapi.SilenceAlert(ctx, alerting.SilenceAlertParams{ID: firing.ID})
+	require.IsType(t, &alerting.SilenceAlertOK{}, rsp)
+	dbAlert, err = dbmodel.GetAlertByID(db, firing.ID)
+	require.NoError(t, err)
+	require.True(t, dbAlert.SilencedUntil.IsZero())
+
+	rsp = rapi.SilenceAlert(ctx, alerting.SilenceAlertParams{ID: firing.ID + 100})
+	require.IsType(t, &alerting.SilenceAlertDefault{}, rsp)
+	defaultRsp := rsp.(*alerting.SilenceAlertDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+}
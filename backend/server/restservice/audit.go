@@ -0,0 +1,186 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/audit"
+)
+
+// Key under which the audit record of the request is stored in the
+// request context.
+type auditContextKey struct{}
+
+// Information about the object modified by the request. It is filled
+// by the handler and stored in the audit trail by the middleware when
+// the request has been served.
+type auditRecord struct {
+	objectType string
+	objectID   int64
+	diff       map[string]dbmodel.AuditChange
+}
+
+// Records the object modified by the current request and its state before
+// and after the modification in the audit trail. The before is nil when
+// the object has been created and the after is nil when it has been
+// deleted. The objects must not include any secrets, e.g. passwords. It
+// does nothing when the request is not audited.
+func auditObject(ctx context.Context, objectType string, objectID int64, before, after interface{}) {
+	record, ok := ctx.Value(auditContextKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	record.objectType = objectType
+	record.objectID = objectID
+	diff, err := dbmodel.GetAuditDiff(before, after)
+	if err != nil {
+		log.Errorf("problem with recording changes of %s %d in audit trail: %+v", objectType, objectID, err)
+		return
+	}
+	record.diff = diff
+}
+
+// Response writer remembering the status code returned to the user.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// Remembers the status code and passes it to the wrapped writer.
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Install a middleware that records the requests modifying the state of
+// the system in the audit trail. The read only requests are not recorded.
+// It must be invoked after the session middleware so as the user who made
+// the request is known.
+func (r *RestAPI) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Remember the user before serving the request because the
+		// user may be logging out.
+		_, user := r.SessionManager.Logged(req.Context())
+
+		record := &auditRecord{}
+		req = req.WithContext(context.WithValue(req.Context(), auditContextKey{}, record))
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sr, req)
+
+		if user == nil {
+			_, user = r.SessionManager.Logged(req.Context())
+		}
+		entry := &dbmodel.AuditEntry{
+			Method:     req.Method,
+			Endpoint:   req.URL.Path,
+			StatusCode: sr.status,
+			ObjectType: record.objectType,
+			ObjectID:   record.objectID,
+			Diff:       record.diff,
+		}
+		if user != nil {
+			entry.UserID = user.ID
+			entry.UserLogin = user.Login
+			if entry.UserLogin == "" {
+				entry.UserLogin = user.Email
+			}
+		}
+		err := dbmodel.AddAuditEntry(r.Db, entry)
+		if err != nil {
+			log.Errorf("problem with recording %s %s in audit trail: %+v", req.Method, req.URL.Path, err)
+		}
+	})
+}
+
+// Converts the audit entry from the database to the format used in the
+// ReST API. The changes are sorted by the field names.
+func auditEntryToRestAPI(dbEntry *dbmodel.AuditEntry) *models.AuditEntry {
+	entry := &models.AuditEntry{
+		ID:         dbEntry.ID,
+		CreatedAt:  strfmt.DateTime(dbEntry.CreatedAt),
+		UserID:     int64(dbEntry.UserID),
+		UserLogin:  dbEntry.UserLogin,
+		Method:     dbEntry.Method,
+		Endpoint:   dbEntry.Endpoint,
+		StatusCode: int64(dbEntry.StatusCode),
+		ObjectType: dbEntry.ObjectType,
+		ObjectID:   dbEntry.ObjectID,
+	}
+	var fields []string
+	for field := range dbEntry.Diff {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		change := dbEntry.Diff[field]
+		entry.Changes = append(entry.Changes, &models.AuditChange{
+			Field:  field,
+			Before: change.Before,
+			After:  change.After,
+		})
+	}
+	return entry
+}
+
+// Get the audit trail of the changes made by the users. The most recent
+// entries are returned first.
+func (r *RestAPI) GetAuditEntries(ctx context.Context, params audit.GetAuditEntriesParams) middleware.Responder {
+	var start int64 = 0
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	var limit int64 = 10
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	var userID int
+	if params.User != nil {
+		userID = int(*params.User)
+	}
+	var objectType string
+	if params.ObjectType != nil {
+		objectType = *params.ObjectType
+	}
+	var objectID int64
+	if params.ObjectID != nil {
+		objectID = *params.ObjectID
+	}
+	from := optionalTimeFromRestAPI(params.From)
+	to := optionalTimeFromRestAPI(params.To)
+
+	dbEntries, total, err := dbmodel.GetAuditEntriesByPage(r.Db, start, limit, userID, objectType, objectID, from, to, dbmodel.SortDirAny)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get audit entries from db"
+		rsp := audit.NewGetAuditEntriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	entries := &models.AuditEntries{
+		Total: total,
+	}
+	for i := range dbEntries {
+		entries.Items = append(entries.Items, auditEntryToRestAPI(&dbEntries[i]))
+	}
+
+	rsp := audit.NewGetAuditEntriesOK().WithPayload(entries)
+	return rsp
+}
@@ -0,0 +1,132 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbsession "isc.org/stork/server/database/session"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/restapi/operations/audit"
+	storktest "isc.org/stork/server/test"
+)
+
+// Check that the handler can record the modified object only when the
+// request is audited.
+func TestAuditObjectWithoutRecord(t *testing.T) {
+	// Nothing happens without the audit record in the context.
+	auditObject(context.Background(), "machine", 1, nil, map[string]interface{}{"address": "localhost"})
+
+	record := &auditRecord{}
+	ctx := context.WithValue(context.Background(), auditContextKey{}, record)
+	auditObject(ctx, "machine", 1,
+		map[string]interface{}{"address": "localhost", "agentPort": 8080},
+		map[string]interface{}{"address": "localhost", "agentPort": 8081})
+	require.Equal(t, "machine", record.objectType)
+	require.EqualValues(t, 1, record.objectID)
+	require.Len(t, record.diff, 1)
+	require.EqualValues(t, 8080, record.diff["agentPort"].Before)
+	require.EqualValues(t, 8081, record.diff["agentPort"].After)
+}
+
+// Check that the modifying requests are recorded in the audit trail by the
+// middleware and that they can be fetched via rest api functions.
+func TestAuditMiddleware(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	sm, err := dbsession.NewSessionMgr(&rapi.DbSettings.BaseDatabaseSettings, db)
+	require.NoError(t, err)
+	rapi.SessionManager = sm
+
+	user, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+
+	// Simulates the handlers of the ReST API.
+	handler := rapi.InnerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/sessions":
+			err := sm.LoginHandler(req.Context(), user)
+			require.NoError(t, err)
+		case "/api/machines/5":
+			auditObject(req.Context(), "machine", 5,
+				map[string]interface{}{"authorized": false},
+				map[string]interface{}{"authorized": true})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	// Log in.
+	req := httptest.NewRequest("POST", "http://localhost/api/sessions", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	rsp := w.Result()
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	cookies := rsp.Cookies()
+	require.NotEmpty(t, cookies)
+
+	serve := func(method, path string) {
+		req := httptest.NewRequest(method, "http://localhost"+path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Result().Body.Close()
+	}
+	// The read only requests are not recorded.
+	serve("GET", "/api/machines/5")
+	serve("PUT", "/api/machines/5")
+	serve("DELETE", "/api/foo")
+
+	ctx := context.Background()
+	params := audit.GetAuditEntriesParams{}
+	rspAudit := rapi.GetAuditEntries(ctx, params)
+	require.IsType(t, &audit.GetAuditEntriesOK{}, rspAudit)
+	entries := rspAudit.(*audit.GetAuditEntriesOK).Payload
+	require.EqualValues(t, 3, entries.Total)
+	require.Len(t, entries.Items, 3)
+
+	// The most recent entries are returned first.
+	require.Equal(t, "DELETE", entries.Items[0].Method)
+	require.EqualValues(t, http.StatusBadRequest, entries.Items[0].StatusCode)
+	require.Empty(t, entries.Items[0].ObjectType)
+
+	require.Equal(t, "PUT", entries.Items[1].Method)
+	require.Equal(t, "/api/machines/5", entries.Items[1].Endpoint)
+	require.EqualValues(t, http.StatusOK, entries.Items[1].StatusCode)
+	require.EqualValues(t, 1, entries.Items[1].UserID)
+	require.Equal(t, user.Login, entries.Items[1].UserLogin)
+	require.Equal(t, "machine", entries.Items[1].ObjectType)
+	require.EqualValues(t, 5, entries.Items[1].ObjectID)
+	require.Len(t, entries.Items[1].Changes, 1)
+	require.Equal(t, "authorized", entries.Items[1].Changes[0].Field)
+	require.Equal(t, false, entries.Items[1].Changes[0].Before)
+	require.Equal(t, true, entries.Items[1].Changes[0].After)
+
+	// The user who has just logged in is recorded.
+	require.Equal(t, "/api/sessions", entries.Items[2].Endpoint)
+	require.EqualValues(t, 1, entries.Items[2].UserID)
+
+	// Filtering by object.
+	objectType := "machine"
+	params = audit.GetAuditEntriesParams{
+		ObjectType: &objectType,
+	}
+	rspAudit = rapi.GetAuditEntries(ctx, params)
+	require.IsType(t, &audit.GetAuditEntriesOK{}, rspAudit)
+	entries = rspAudit.(*audit.GetAuditEntriesOK).Payload
+	require.EqualValues(t, 1, entries.Total)
+	require.Equal(t, "PUT", entries.Items[0].Method)
+}
@@ -0,0 +1,192 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/configreview"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+)
+
+// Fetches the findings of the most recent review of the daemon's
+// configuration and converts them to the format used in the ReST API.
+func (r *RestAPI) getConfigReviewFindings(appID int64, daemonName string) (*models.ConfigReviewFindings, error) {
+	dbFindings, err := dbmodel.GetConfigReviewFindings(r.Db, appID, daemonName)
+	if err != nil {
+		return nil, err
+	}
+	findings := &models.ConfigReviewFindings{
+		Items: []*models.ConfigReviewFinding{},
+		Total: int64(len(dbFindings)),
+	}
+	for _, dbFinding := range dbFindings {
+		findings.Items = append(findings.Items, &models.ConfigReviewFinding{
+			Checker:   dbFinding.Checker,
+			Text:      dbFinding.Text,
+			CreatedAt: strfmt.DateTime(dbFinding.CreatedAt),
+		})
+	}
+	return findings, nil
+}
+
+// Gets the findings of the most recent review of the daemon's
+// configuration.
+func (r *RestAPI) GetDaemonConfigReview(ctx context.Context, params services.GetDaemonConfigReviewParams) middleware.Responder {
+	findings, err := r.getConfigReviewFindings(params.ID, params.Daemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review of %s daemon of app %d from db", params.Daemon, params.ID)
+		rsp := services.NewGetDaemonConfigReviewDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := services.NewGetDaemonConfigReviewOK().WithPayload(findings)
+	return rsp
+}
+
+// Reviews the current configuration of the daemon and returns the
+// findings.
+func (r *RestAPI) ReviewDaemonConfig(ctx context.Context, params services.ReviewDaemonConfigParams) middleware.Responder {
+	dbApp, err := dbmodel.GetAppByID(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get app with id %d from db", params.ID)
+		rsp := services.NewReviewDaemonConfigDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	var dbDaemon *dbmodel.Daemon
+	if dbApp != nil {
+		for _, d := range dbApp.Daemons {
+			if d.Name == params.Daemon && d.KeaDaemon != nil && d.KeaDaemon.Config != nil {
+				dbDaemon = d
+				break
+			}
+		}
+	}
+	if dbDaemon == nil {
+		msg := fmt.Sprintf("cannot find configuration of %s daemon of app %d", params.Daemon, params.ID)
+		rsp := services.NewReviewDaemonConfigDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = configreview.ReviewDaemon(r.Db, dbApp, dbDaemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot review configuration of %s daemon of app %d", params.Daemon, params.ID)
+		rsp := services.NewReviewDaemonConfigDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	findings, err := r.getConfigReviewFindings(params.ID, params.Daemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review of %s daemon of app %d from db", params.Daemon, params.ID)
+		rsp := services.NewReviewDaemonConfigDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := services.NewReviewDaemonConfigOK().WithPayload(findings)
+	return rsp
+}
+
+// Returns the registered configuration checkers along with their states
+// in the format used in the ReST API.
+func (r *RestAPI) getConfigCheckers() ([]*models.ConfigChecker, error) {
+	states, err := dbmodel.GetConfigCheckers(r.Db)
+	if err != nil {
+		return nil, err
+	}
+	disabled := map[string]bool{}
+	for _, state := range states {
+		disabled[state.Name] = !state.Enabled
+	}
+	checkers := []*models.ConfigChecker{}
+	for _, c := range configreview.GetCheckers() {
+		checkers = append(checkers, &models.ConfigChecker{
+			Name:        c.Name,
+			Description: c.Description,
+			Daemons:     c.DaemonNames,
+			Enabled:     !disabled[c.Name],
+		})
+	}
+	return checkers, nil
+}
+
+// Gets the configuration checkers.
+func (r *RestAPI) GetConfigCheckers(ctx context.Context, params services.GetConfigCheckersParams) middleware.Responder {
+	checkers, err := r.getConfigCheckers()
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get configuration checkers from db"
+		rsp := services.NewGetConfigCheckersDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := services.NewGetConfigCheckersOK().WithPayload(&models.ConfigCheckers{
+		Items: checkers,
+		Total: int64(len(checkers)),
+	})
+	return rsp
+}
+
+// Enables or disables the configuration checker.
+func (r *RestAPI) UpdateConfigChecker(ctx context.Context, params services.UpdateConfigCheckerParams) middleware.Responder {
+	if configreview.GetChecker(params.Name) == nil {
+		msg := fmt.Sprintf("cannot find configuration checker %s", params.Name)
+		rsp := services.NewUpdateConfigCheckerDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if params.Checker == nil {
+		msg := "missing configuration checker"
+		rsp := services.NewUpdateConfigCheckerDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	checkers, err := r.getConfigCheckers()
+	if err == nil {
+		err = dbmodel.SetConfigCheckerEnabled(r.Db, params.Name, params.Checker.Enabled)
+	}
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot update configuration checker %s in db", params.Name)
+		rsp := services.NewUpdateConfigCheckerDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	var before, after *models.ConfigChecker
+	for _, c := range checkers {
+		if c.Name == params.Name {
+			before = c
+			updated := *c
+			updated.Enabled = params.Checker.Enabled
+			after = &updated
+			break
+		}
+	}
+	auditObject(ctx, "config-checker", 0, before, after)
+
+	rsp := services.NewUpdateConfigCheckerOK().WithPayload(after)
+	return rsp
+}
@@ -0,0 +1,109 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+)
+
+// Test that the daemon's configuration can be reviewed and the findings
+// are returned.
+func TestDaemonConfigReview(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+	config, err := dbmodel.NewKeaConfigFromJSON(`{"Dhcp4": {}}`)
+	require.NoError(t, err)
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name: "dhcp4",
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config: config,
+				},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	// Not reviewed yet.
+	rsp := rapi.GetDaemonConfigReview(ctx, services.GetDaemonConfigReviewParams{ID: app.ID, Daemon: "dhcp4"})
+	require.IsType(t, &services.GetDaemonConfigReviewOK{}, rsp)
+	require.Empty(t, rsp.(*services.GetDaemonConfigReviewOK).Payload.Items)
+
+	rsp = rapi.ReviewDaemonConfig(ctx, services.ReviewDaemonConfigParams{ID: app.ID, Daemon: "dhcp6"})
+	require.IsType(t, &services.ReviewDaemonConfigDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.ReviewDaemonConfigDefault)))
+
+	rsp = rapi.ReviewDaemonConfig(ctx, services.ReviewDaemonConfigParams{ID: app.ID, Daemon: "dhcp4"})
+	require.IsType(t, &services.ReviewDaemonConfigOK{}, rsp)
+	findings := rsp.(*services.ReviewDaemonConfigOK).Payload
+	require.EqualValues(t, 1, findings.Total)
+	require.Equal(t, "control_socket", findings.Items[0].Checker)
+
+	rsp = rapi.GetDaemonConfigReview(ctx, services.GetDaemonConfigReviewParams{ID: app.ID, Daemon: "dhcp4"})
+	require.IsType(t, &services.GetDaemonConfigReviewOK{}, rsp)
+	require.Len(t, rsp.(*services.GetDaemonConfigReviewOK).Payload.Items, 1)
+}
+
+// Test that the configuration checkers can be listed, enabled and
+// disabled.
+func TestConfigCheckers(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	rsp := rapi.UpdateConfigChecker(ctx, services.UpdateConfigCheckerParams{
+		Name:    "foo",
+		Checker: &models.ConfigChecker{},
+	})
+	require.IsType(t, &services.UpdateConfigCheckerDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.UpdateConfigCheckerDefault)))
+
+	rsp = rapi.UpdateConfigChecker(ctx, services.UpdateConfigCheckerParams{
+		Name:    "control_socket",
+		Checker: &models.ConfigChecker{Enabled: false},
+	})
+	require.IsType(t, &services.UpdateConfigCheckerOK{}, rsp)
+	checker := rsp.(*services.UpdateConfigCheckerOK).Payload
+	require.Equal(t, "control_socket", checker.Name)
+	require.False(t, checker.Enabled)
+
+	rsp = rapi.GetConfigCheckers(ctx, services.GetConfigCheckersParams{})
+	require.IsType(t, &services.GetConfigCheckersOK{}, rsp)
+	checkers := rsp.(*services.GetConfigCheckersOK).Payload
+	require.EqualValues(t, len(checkers.Items), checkers.Total)
+	for _, c := range checkers.Items {
+		require.NotEmpty(t, c.Description)
+		require.NotEmpty(t, c.Daemons)
+		require.Equal(t, c.Name != "control_socket", c.Enabled)
+	}
+}
@@ -0,0 +1,137 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/apps/kea"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+)
+
+// Converts the version of the daemon's configuration to the format used
+// in the ReST API.
+func configSnapshotToRestAPI(snapshot *dbmodel.KeaConfigSnapshot) *models.KeaConfigSnapshot {
+	restSnapshot := &models.KeaConfigSnapshot{
+		Version:   snapshot.Version,
+		CreatedAt: strfmt.DateTime(snapshot.CreatedAt),
+		Hash:      snapshot.Hash,
+	}
+	if snapshot.Config != nil {
+		restSnapshot.Config = *snapshot.Config
+	}
+	return restSnapshot
+}
+
+// Gets the versions of the configuration of the given Kea daemon.
+func (r *RestAPI) GetDaemonConfigs(ctx context.Context, params services.GetDaemonConfigsParams) middleware.Responder {
+	dbApp, err := dbmodel.GetAppByID(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get app with id %d from db", params.ID)
+		rsp := services.NewGetDaemonConfigsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbApp == nil || dbApp.Type != dbmodel.AppTypeKea {
+		msg := fmt.Sprintf("cannot find Kea app with id %d", params.ID)
+		rsp := services.NewGetDaemonConfigsDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbSnapshots, err := dbmodel.GetKeaConfigSnapshots(r.Db, params.ID, params.Daemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configurations of %s daemon of app %d from db", params.Daemon, params.ID)
+		rsp := services.NewGetDaemonConfigsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	snapshots := &models.KeaConfigSnapshots{
+		Items: []*models.KeaConfigSnapshot{},
+		Total: int64(len(dbSnapshots)),
+	}
+	for i := range dbSnapshots {
+		snapshots.Items = append(snapshots.Items, configSnapshotToRestAPI(&dbSnapshots[i]))
+	}
+	rsp := services.NewGetDaemonConfigsOK().WithPayload(snapshots)
+	return rsp
+}
+
+// Gets the given version of the configuration of the Kea daemon.
+func (r *RestAPI) GetDaemonConfig(ctx context.Context, params services.GetDaemonConfigParams) middleware.Responder {
+	dbSnapshot, err := dbmodel.GetKeaConfigSnapshot(r.Db, params.ID, params.Daemon, params.Version)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration version %d of %s daemon of app %d from db",
+			params.Version, params.Daemon, params.ID)
+		rsp := services.NewGetDaemonConfigDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbSnapshot == nil {
+		msg := fmt.Sprintf("cannot find configuration version %d of %s daemon of app %d",
+			params.Version, params.Daemon, params.ID)
+		rsp := services.NewGetDaemonConfigDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := services.NewGetDaemonConfigOK().WithPayload(configSnapshotToRestAPI(dbSnapshot))
+	return rsp
+}
+
+// Gets the differences between two versions of the configuration of the
+// Kea daemon.
+func (r *RestAPI) GetDaemonConfigDiff(ctx context.Context, params services.GetDaemonConfigDiffParams) middleware.Responder {
+	var dbSnapshots []*dbmodel.KeaConfigSnapshot
+	for _, version := range []int64{params.From, params.To} {
+		dbSnapshot, err := dbmodel.GetKeaConfigSnapshot(r.Db, params.ID, params.Daemon, version)
+		if err != nil {
+			log.Error(err)
+			msg := fmt.Sprintf("cannot get configuration version %d of %s daemon of app %d from db",
+				version, params.Daemon, params.ID)
+			rsp := services.NewGetDaemonConfigDiffDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		if dbSnapshot == nil {
+			msg := fmt.Sprintf("cannot find configuration version %d of %s daemon of app %d",
+				version, params.Daemon, params.ID)
+			rsp := services.NewGetDaemonConfigDiffDefault(http.StatusNotFound).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		dbSnapshots = append(dbSnapshots, dbSnapshot)
+	}
+
+	diff := &models.KeaConfigDiff{
+		From:    params.From,
+		To:      params.To,
+		Changes: []*models.KeaConfigChange{},
+	}
+	for _, change := range kea.DiffConfigs(dbSnapshots[0].Config, dbSnapshots[1].Config) {
+		diff.Changes = append(diff.Changes, &models.KeaConfigChange{
+			Path:     change.Path,
+			Op:       change.Op,
+			OldValue: change.OldValue,
+			NewValue: change.NewValue,
+		})
+	}
+	rsp := services.NewGetDaemonConfigDiffOK().WithPayload(diff)
+	return rsp
+}
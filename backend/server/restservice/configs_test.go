@@ -0,0 +1,85 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+)
+
+// Test that the versions of the daemon's configuration and the
+// differences between them are returned.
+func TestGetDaemonConfigs(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	rsp := rapi.GetDaemonConfigs(ctx, services.GetDaemonConfigsParams{ID: 123, Daemon: "dhcp4"})
+	require.IsType(t, &services.GetDaemonConfigsDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonConfigsDefault)))
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	for _, text := range []string{`{"Dhcp4": {"valid-lifetime": 4000}}`, `{"Dhcp4": {"valid-lifetime": 5000}}`} {
+		config, err := dbmodel.NewKeaConfigFromJSON(text)
+		require.NoError(t, err)
+		_, _, err = dbmodel.AddKeaConfigSnapshot(db, app.ID, "dhcp4", config)
+		require.NoError(t, err)
+	}
+
+	rsp = rapi.GetDaemonConfigs(ctx, services.GetDaemonConfigsParams{ID: app.ID, Daemon: "dhcp4"})
+	require.IsType(t, &services.GetDaemonConfigsOK{}, rsp)
+	snapshots := rsp.(*services.GetDaemonConfigsOK).Payload
+	require.EqualValues(t, 2, snapshots.Total)
+	require.Len(t, snapshots.Items, 2)
+	require.EqualValues(t, 2, snapshots.Items[0].Version)
+	require.NotEmpty(t, snapshots.Items[0].Hash)
+	require.Nil(t, snapshots.Items[0].Config)
+
+	rsp = rapi.GetDaemonConfig(ctx, services.GetDaemonConfigParams{ID: app.ID, Daemon: "dhcp4", Version: 1})
+	require.IsType(t, &services.GetDaemonConfigOK{}, rsp)
+	snapshot := rsp.(*services.GetDaemonConfigOK).Payload
+	require.EqualValues(t, 1, snapshot.Version)
+	require.NotNil(t, snapshot.Config)
+
+	rsp = rapi.GetDaemonConfig(ctx, services.GetDaemonConfigParams{ID: app.ID, Daemon: "dhcp6", Version: 1})
+	require.IsType(t, &services.GetDaemonConfigDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonConfigDefault)))
+
+	rsp = rapi.GetDaemonConfigDiff(ctx, services.GetDaemonConfigDiffParams{ID: app.ID, Daemon: "dhcp4", From: 1, To: 2})
+	require.IsType(t, &services.GetDaemonConfigDiffOK{}, rsp)
+	diff := rsp.(*services.GetDaemonConfigDiffOK).Payload
+	require.EqualValues(t, 1, diff.From)
+	require.EqualValues(t, 2, diff.To)
+	require.Len(t, diff.Changes, 1)
+	require.Equal(t, "/Dhcp4/valid-lifetime", diff.Changes[0].Path)
+	require.Equal(t, "replace", diff.Changes[0].Op)
+	require.EqualValues(t, 4000, diff.Changes[0].OldValue)
+	require.EqualValues(t, 5000, diff.Changes[0].NewValue)
+
+	rsp = rapi.GetDaemonConfigDiff(ctx, services.GetDaemonConfigDiffParams{ID: app.ID, Daemon: "dhcp4", From: 1, To: 3})
+	require.IsType(t, &services.GetDaemonConfigDiffDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonConfigDiffDefault)))
+}
@@ -0,0 +1,340 @@
+package restservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/go-openapi/runtime/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/certs"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+)
+
+// Enroll an agent. The agent sends a CSR, the token it generated and,
+// optionally, the server token. The server issues the certificate for the
+// agent, remembers its serial number in the machine and revokes the
+// certificate the agent had previously. The machine is added if it doesn't
+// exist yet. Such a machine is authorized only when the agent provided the
+// server token. Otherwise, it waits for the approval of an administrator.
+func (r *RestAPI) EnrollMachine(ctx context.Context, params services.EnrollMachineParams) middleware.Responder {
+	enrollment := params.Enrollment
+	if enrollment == nil || enrollment.Address == nil || enrollment.AgentPort == nil ||
+		enrollment.AgentCSR == nil || enrollment.AgentToken == nil || *enrollment.AgentToken == "" {
+		msg := "missing parameters of the enrollment request"
+		rsp := services.NewEnrollMachineDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	addr := *enrollment.Address
+	agentPort := *enrollment.AgentPort
+	if !govalidator.IsHost(addr) {
+		log.Warnf("problem with parsing address %s", addr)
+		msg := "cannot parse address"
+		rsp := services.NewEnrollMachineDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if agentPort <= 0 || agentPort > 65535 {
+		log.Warnf("bad agent port %d", agentPort)
+		msg := "bad port"
+		rsp := services.NewEnrollMachineDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// The server token is optional but if it is provided it must be valid.
+	serverTokenValid := false
+	if enrollment.ServerToken != "" {
+		serverToken, err := dbmodel.GetSecret(r.Db, dbmodel.SecretServerToken)
+		if err != nil {
+			msg := "cannot get server token from db"
+			log.Error(err)
+			rsp := services.NewEnrollMachineDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		if len(serverToken) == 0 || subtle.ConstantTimeCompare(serverToken, []byte(enrollment.ServerToken)) != 1 {
+			log.Warnf("agent %s:%d provided invalid server token", addr, agentPort)
+			msg := "invalid server token"
+			rsp := services.NewEnrollMachineDefault(http.StatusForbidden).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		serverTokenValid = true
+	}
+
+	dbMachine, err := dbmodel.GetMachineByAddressAndAgentPort(r.Db, addr, agentPort)
+	if err != nil {
+		msg := fmt.Sprintf("cannot get machine %s:%d from db", addr, agentPort)
+		log.Error(err)
+		rsp := services.NewEnrollMachineDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// Without the server token, only the agent which registered the machine
+	// can register it again. Otherwise, anyone could take over the machine
+	// by registering with its address.
+	if dbMachine != nil && !serverTokenValid &&
+		subtle.ConstantTimeCompare([]byte(dbMachine.AgentToken), []byte(*enrollment.AgentToken)) != 1 {
+		log.Warnf("agent %s:%d provided invalid agent token", addr, agentPort)
+		msg := "machine is already registered by another agent, server token is required"
+		rsp := services.NewEnrollMachineDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	agentCertPEM, serial, caCertPEM, err := certs.SignAgentCSR(r.Db, addr, []byte(*enrollment.AgentCSR))
+	if err != nil {
+		msg := fmt.Sprintf("cannot issue certificate for agent %s:%d", addr, agentPort)
+		log.Error(err)
+		rsp := services.NewEnrollMachineDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	if dbMachine == nil {
+		dbMachine = &dbmodel.Machine{
+			Address:         addr,
+			AgentPort:       agentPort,
+			AgentCertSerial: serial,
+			AgentToken:      *enrollment.AgentToken,
+			Authorized:      serverTokenValid,
+		}
+		err = dbmodel.AddMachine(r.Db, dbMachine)
+	} else {
+		// The agent obtained a new certificate so the old one is not
+		// needed anymore.
+		oldSerial := dbMachine.AgentCertSerial
+		dbMachine.AgentCertSerial = serial
+		dbMachine.AgentToken = *enrollment.AgentToken
+		if serverTokenValid {
+			dbMachine.Authorized = true
+		}
+		err = dbmodel.UpdateMachineAgentCert(r.Db, dbMachine, oldSerial)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("cannot store machine %s:%d", addr, agentPort)
+		log.Error(err)
+		rsp := services.NewEnrollMachineDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// The server accepts only the certificate issued to the machine, so
+	// the connection established with the old certificate is closed.
+	r.Agents.RemoveAgent(addr, agentPort)
+
+	log.WithFields(log.Fields{
+		"serial":     serial,
+		"authorized": dbMachine.Authorized,
+	}).Infof("issued certificate for agent %s:%d", addr, agentPort)
+
+	rsp := services.NewEnrollMachineOK().WithPayload(&models.MachineEnrollmentResult{
+		AgentCert:    string(agentCertPEM),
+		ServerCACert: string(caCertPEM),
+		Authorized:   dbMachine.Authorized,
+	})
+	return rsp
+}
+
+// Delay of the refresh of the machine state after the notification from
+// its agent. The agent often sends several notifications in a row, e.g.
+// when the apps are restarted, so they are coalesced into one refresh.
+const machineRefreshDelay = 5 * time.Second
+
+// Schedules the refreshes of the machines' states. The refresh of the
+// machine is delayed and the notifications received in the meantime
+// postpone it, so the machine is refreshed once after the agent stops
+// sending the notifications.
+type machineRefresher struct {
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	delay   time.Duration
+	pending map[int64]*time.Timer
+	stopped bool
+	refresh func(machineID int64)
+}
+
+// Creates the refresher calling the given function to refresh the machine.
+func newMachineRefresher(delay time.Duration, refresh func(machineID int64)) *machineRefresher {
+	return &machineRefresher{
+		delay:   delay,
+		pending: make(map[int64]*time.Timer),
+		refresh: refresh,
+	}
+}
+
+// Schedules the refresh of the machine or postpones the already scheduled
+// one.
+func (mr *machineRefresher) schedule(machineID int64) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if mr.stopped {
+		return
+	}
+	if timer, ok := mr.pending[machineID]; ok && timer.Stop() {
+		timer.Reset(mr.delay)
+		return
+	}
+	// The timer may have fired already and its function may be waiting for
+	// the mutex. It must not remove the new timer then.
+	var timer *time.Timer
+	mr.wg.Add(1)
+	timer = time.AfterFunc(mr.delay, func() {
+		defer mr.wg.Done()
+		mr.mutex.Lock()
+		if mr.pending[machineID] == timer {
+			delete(mr.pending, machineID)
+		}
+		mr.mutex.Unlock()
+		mr.refresh(machineID)
+	})
+	mr.pending[machineID] = timer
+}
+
+// Cancels the scheduled refreshes and waits for the running ones.
+func (mr *machineRefresher) stop() {
+	mr.mutex.Lock()
+	mr.stopped = true
+	for machineID, timer := range mr.pending {
+		if timer.Stop() {
+			mr.wg.Done()
+		}
+		delete(mr.pending, machineID)
+	}
+	mr.mutex.Unlock()
+	mr.wg.Wait()
+}
+
+// Fetches the state of the machine and its apps from the agent and stores
+// it in the database. It is called by the machine refresher.
+func (r *RestAPI) refreshMachineState(machineID int64) {
+	dbMachine, err := dbmodel.GetMachineByID(r.Db, machineID)
+	if err != nil {
+		log.Errorf("cannot get machine %d from db: %s", machineID, err)
+		return
+	}
+	if dbMachine == nil || !dbMachine.Authorized {
+		return
+	}
+	errStr := getMachineAndAppsState(context.Background(), r.Db, dbMachine, r.Agents)
+	if errStr != "" {
+		log.Warnf("problem with refreshing state of machine %d: %s", machineID, errStr)
+	}
+}
+
+// Schedule the refresh of the machine state upon the notification from its
+// agent that the apps running on the machine have changed. The agent must
+// provide the agent token it enrolled with, so nobody else can make the
+// server contact the agent. The machine must be authorized. The state is
+// fetched in the background, so the agent doesn't wait for it.
+func (r *RestAPI) NotifyMachineAppsChanged(ctx context.Context, params services.NotifyMachineAppsChangedParams) middleware.Responder {
+	notification := params.Notification
+	if notification == nil || notification.Address == nil || notification.AgentPort == nil ||
+		notification.AgentToken == nil || *notification.AgentToken == "" {
+		msg := "missing parameters of the apps changed notification"
+		rsp := services.NewNotifyMachineAppsChangedDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	addr := *notification.Address
+	agentPort := *notification.AgentPort
+	dbMachine, err := dbmodel.GetMachineByAddressAndAgentPort(r.Db, addr, agentPort)
+	if err != nil {
+		msg := fmt.Sprintf("cannot get machine %s:%d from db", addr, agentPort)
+		log.Error(err)
+		rsp := services.NewNotifyMachineAppsChangedDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbMachine == nil {
+		msg := fmt.Sprintf("cannot find machine %s:%d", addr, agentPort)
+		rsp := services.NewNotifyMachineAppsChangedDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if subtle.ConstantTimeCompare([]byte(dbMachine.AgentToken), []byte(*notification.AgentToken)) != 1 {
+		log.Warnf("agent %s:%d provided invalid agent token", addr, agentPort)
+		msg := "invalid agent token"
+		rsp := services.NewNotifyMachineAppsChangedDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if !dbMachine.Authorized {
+		msg := fmt.Sprintf("machine %s:%d is not authorized", addr, agentPort)
+		rsp := services.NewNotifyMachineAppsChangedDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	log.Infof("agent %s:%d reported changed apps, scheduling refresh of machine state", addr, agentPort)
+	r.machineRefresher.schedule(dbMachine.ID)
+
+	rsp := services.NewNotifyMachineAppsChangedAccepted()
+	return rsp
+}
+
+// Get the server token the agents use to enroll.
+func (r *RestAPI) GetMachinesServerToken(ctx context.Context, params services.GetMachinesServerTokenParams) middleware.Responder {
+	token, err := dbmodel.GetSecret(r.Db, dbmodel.SecretServerToken)
+	if err != nil || token == nil {
+		msg := "cannot get server token from db"
+		log.Error(err)
+		rsp := services.NewGetMachinesServerTokenDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rsp := services.NewGetMachinesServerTokenOK().WithPayload(&models.ServerToken{
+		Token: string(token),
+	})
+	return rsp
+}
+
+// Generate new server token. The agents which have already enrolled
+// are not affected but new agents must use the new token.
+func (r *RestAPI) RegenerateMachinesServerToken(ctx context.Context, params services.RegenerateMachinesServerTokenParams) middleware.Responder {
+	token, err := certs.RegenerateServerToken(r.Db)
+	if err != nil {
+		msg := "cannot regenerate server token"
+		log.Error(err)
+		rsp := services.NewRegenerateMachinesServerTokenDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// The token itself is secret so only the fact it has changed is recorded.
+	auditObject(ctx, "server-token", 0, nil, nil)
+
+	rsp := services.NewRegenerateMachinesServerTokenOK().WithPayload(&models.ServerToken{
+		Token: string(token),
+	})
+	return rsp
+}
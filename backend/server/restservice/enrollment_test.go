@@ -0,0 +1,327 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+	"isc.org/stork/server/agentcomm"
+	"isc.org/stork/server/certs"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+)
+
+// Test that the agent can enroll with valid server token and that the
+// certificate issued previously is revoked when the agent enrolls again.
+func TestEnrollMachine(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+	token, err := dbmodel.GetSecret(db, dbmodel.SecretServerToken)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, csrPEM, err := pki.GenKeyAndCSR("agent", nil, nil)
+	require.NoError(t, err)
+
+	addr := "192.0.2.1"
+	port := int64(8080)
+	csr := string(csrPEM)
+	agentToken := "agent-token"
+	params := services.EnrollMachineParams{
+		Enrollment: &models.MachineEnrollment{
+			Address:     &addr,
+			AgentPort:   &port,
+			AgentCSR:    &csr,
+			AgentToken:  &agentToken,
+			ServerToken: "foo",
+		},
+	}
+
+	// Invalid token.
+	rsp := rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineDefault{}, rsp)
+	defaultRsp := rsp.(*services.EnrollMachineDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*defaultRsp))
+
+	// Valid token.
+	params.Enrollment.ServerToken = string(token)
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineOK{}, rsp)
+	okRsp := rsp.(*services.EnrollMachineOK)
+	require.True(t, okRsp.Payload.Authorized)
+	agentCert, err := pki.ParseCert([]byte(okRsp.Payload.AgentCert))
+	require.NoError(t, err)
+	require.Equal(t, addr, agentCert.IPAddresses[0].String())
+	_, err = pki.ParseCert([]byte(okRsp.Payload.ServerCACert))
+	require.NoError(t, err)
+
+	// The machine should have been added.
+	m, err := dbmodel.GetMachineByAddressAndAgentPort(db, addr, port)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.Equal(t, agentCert.SerialNumber.Int64(), m.AgentCertSerial)
+	require.True(t, m.Authorized)
+	require.Equal(t, agentToken, m.AgentToken)
+
+	// Enroll again, the old certificate should be revoked.
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineOK{}, rsp)
+	revoked, err := dbmodel.IsCertRevoked(db, agentCert.SerialNumber.Int64())
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	m2, err := dbmodel.GetMachineByAddressAndAgentPort(db, addr, port)
+	require.NoError(t, err)
+	require.Equal(t, m.ID, m2.ID)
+	require.NotEqual(t, m.AgentCertSerial, m2.AgentCertSerial)
+
+	// Invalid CSR.
+	badCSR := "foo"
+	params.Enrollment.AgentCSR = &badCSR
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineDefault{}, rsp)
+	defaultRsp = rsp.(*services.EnrollMachineDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+}
+
+// Test that the agent can register without the server token and that
+// such a machine waits for the approval.
+func TestRegisterMachineWithoutServerToken(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+	token, err := dbmodel.GetSecret(db, dbmodel.SecretServerToken)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, csrPEM, err := pki.GenKeyAndCSR("agent", nil, nil)
+	require.NoError(t, err)
+
+	addr := "192.0.2.1"
+	port := int64(8080)
+	csr := string(csrPEM)
+	agentToken := "agent-token"
+	params := services.EnrollMachineParams{
+		Enrollment: &models.MachineEnrollment{
+			Address:    &addr,
+			AgentPort:  &port,
+			AgentCSR:   &csr,
+			AgentToken: &agentToken,
+		},
+	}
+
+	// The machine is added but it is not authorized.
+	rsp := rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineOK{}, rsp)
+	require.False(t, rsp.(*services.EnrollMachineOK).Payload.Authorized)
+	m, err := dbmodel.GetMachineByAddressAndAgentPort(db, addr, port)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.False(t, m.Authorized)
+
+	// The same agent can register again.
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineOK{}, rsp)
+	require.False(t, rsp.(*services.EnrollMachineOK).Payload.Authorized)
+
+	// Another agent can't take over the machine.
+	otherToken := "other-token"
+	params.Enrollment.AgentToken = &otherToken
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineDefault{}, rsp)
+	defaultRsp := rsp.(*services.EnrollMachineDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*defaultRsp))
+
+	// Unless it provides the server token which also authorizes the
+	// machine.
+	params.Enrollment.ServerToken = string(token)
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineOK{}, rsp)
+	require.True(t, rsp.(*services.EnrollMachineOK).Payload.Authorized)
+	m, err = dbmodel.GetMachineByAddressAndAgentPort(db, addr, port)
+	require.NoError(t, err)
+	require.True(t, m.Authorized)
+	require.Equal(t, otherToken, m.AgentToken)
+
+	// Agent token is mandatory.
+	params.Enrollment.AgentToken = nil
+	rsp = rapi.EnrollMachine(ctx, params)
+	require.IsType(t, &services.EnrollMachineDefault{}, rsp)
+	defaultRsp = rsp.(*services.EnrollMachineDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+}
+
+// Test that the state of the machine is refreshed upon the notification
+// from its agent and that the notification from anyone else is refused.
+func TestNotifyMachineAppsChanged(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	rapi.machineRefresher = newMachineRefresher(10*time.Millisecond, rapi.refreshMachineState)
+	defer rapi.machineRefresher.stop()
+	ctx := context.Background()
+
+	m := &dbmodel.Machine{
+		Address:    "192.0.2.1",
+		AgentPort:  8080,
+		AgentToken: "agent-token",
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	addr := m.Address
+	port := m.AgentPort
+	agentToken := "other-token"
+	params := services.NotifyMachineAppsChangedParams{
+		Notification: &models.MachineAppsChanged{
+			Address:    &addr,
+			AgentPort:  &port,
+			AgentToken: &agentToken,
+		},
+	}
+
+	// Invalid agent token.
+	rsp := rapi.NotifyMachineAppsChanged(ctx, params)
+	require.IsType(t, &services.NotifyMachineAppsChangedDefault{}, rsp)
+	defaultRsp := rsp.(*services.NotifyMachineAppsChangedDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*defaultRsp))
+
+	// The machine is not authorized.
+	agentToken = m.AgentToken
+	rsp = rapi.NotifyMachineAppsChanged(ctx, params)
+	require.IsType(t, &services.NotifyMachineAppsChangedDefault{}, rsp)
+	defaultRsp = rsp.(*services.NotifyMachineAppsChangedDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*defaultRsp))
+
+	// The apps reported by the agent are stored in the database.
+	m.Authorized = true
+	err = db.Update(m)
+	require.NoError(t, err)
+	fa.MachineState = &agentcomm.State{
+		Apps: []*agentcomm.App{
+			{
+				Type:         dbmodel.AppTypeBind9,
+				AccessPoints: makeAccessPoint(dbmodel.AccessPointControl, "127.0.0.1", "abcd", 953),
+			},
+		},
+	}
+	rsp = rapi.NotifyMachineAppsChanged(ctx, params)
+	require.IsType(t, &services.NotifyMachineAppsChangedAccepted{}, rsp)
+	require.Eventually(t, func() bool {
+		machine, err := dbmodel.GetMachineByID(db, m.ID)
+		return err == nil && len(machine.Apps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.Equal(t, dbmodel.AppTypeBind9, m.Apps[0].Type)
+
+	// Unknown machine.
+	otherPort := int64(8081)
+	params.Notification.AgentPort = &otherPort
+	rsp = rapi.NotifyMachineAppsChanged(ctx, params)
+	require.IsType(t, &services.NotifyMachineAppsChangedDefault{}, rsp)
+	defaultRsp = rsp.(*services.NotifyMachineAppsChangedDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+
+	// Agent token is mandatory.
+	params.Notification.AgentToken = nil
+	rsp = rapi.NotifyMachineAppsChanged(ctx, params)
+	require.IsType(t, &services.NotifyMachineAppsChangedDefault{}, rsp)
+	defaultRsp = rsp.(*services.NotifyMachineAppsChangedDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+}
+
+// Test that the notifications received in a short period result in one
+// refresh of the machine and that the refreshes are canceled upon stop.
+func TestMachineRefresher(t *testing.T) {
+	var mutex sync.Mutex
+	refreshed := make(map[int64]int)
+	mr := newMachineRefresher(50*time.Millisecond, func(machineID int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		refreshed[machineID]++
+	})
+	count := func(machineID int64) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return refreshed[machineID]
+	}
+
+	for i := 0; i < 5; i++ {
+		mr.schedule(1)
+		mr.schedule(2)
+	}
+	require.Eventually(t, func() bool {
+		return count(1) == 1 && count(2) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The machine is refreshed again upon the next notification.
+	mr.schedule(1)
+	require.Eventually(t, func() bool {
+		return count(1) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The pending refreshes are canceled.
+	mr.delay = time.Hour
+	mr.schedule(1)
+	mr.stop()
+	mr.schedule(2)
+	require.Equal(t, 2, count(1))
+	require.Equal(t, 1, count(2))
+	require.Empty(t, mr.pending)
+}
+
+// Test that the server token can be fetched and regenerated.
+func TestMachinesServerToken(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	rsp := rapi.GetMachinesServerToken(ctx, services.GetMachinesServerTokenParams{})
+	require.IsType(t, &services.GetMachinesServerTokenOK{}, rsp)
+	token := rsp.(*services.GetMachinesServerTokenOK).Payload.Token
+	require.NotEmpty(t, token)
+
+	rsp = rapi.RegenerateMachinesServerToken(ctx, services.RegenerateMachinesServerTokenParams{})
+	require.IsType(t, &services.RegenerateMachinesServerTokenOK{}, rsp)
+	newToken := rsp.(*services.RegenerateMachinesServerTokenOK).Payload.Token
+	require.NotEqual(t, token, newToken)
+
+	rsp = rapi.GetMachinesServerToken(ctx, services.GetMachinesServerTokenParams{})
+	require.Equal(t, newToken, rsp.(*services.GetMachinesServerTokenOK).Payload.Token)
+}
@@ -0,0 +1,82 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/events"
+)
+
+// Converts the event from the database to the format used in the ReST API.
+func eventToRestAPI(dbEvent *dbmodel.Event) *models.Event {
+	return &models.Event{
+		ID:        dbEvent.ID,
+		CreatedAt: strfmt.DateTime(dbEvent.CreatedAt),
+		Text:      dbEvent.Text,
+		Level:     int64(dbEvent.Level),
+		Details:   dbEvent.Details,
+		MachineID: dbEvent.MachineID,
+		AppID:     dbEvent.AppID,
+		DaemonID:  dbEvent.DaemonID,
+		SubnetID:  dbEvent.SubnetID,
+	}
+}
+
+// Get events which happened in the monitored network. The most recent
+// events are returned first.
+func (r *RestAPI) GetEvents(ctx context.Context, params events.GetEventsParams) middleware.Responder {
+	var start int64 = 0
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	var limit int64 = 10
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	level := dbmodel.EvInfo
+	if params.Level != nil {
+		level = dbmodel.EventLevel(*params.Level)
+	}
+
+	var machineID, appID, daemonID, subnetID int64
+	if params.Machine != nil {
+		machineID = *params.Machine
+	}
+	if params.App != nil {
+		appID = *params.App
+	}
+	if params.Daemon != nil {
+		daemonID = *params.Daemon
+	}
+	if params.Subnet != nil {
+		subnetID = *params.Subnet
+	}
+
+	dbEvents, total, err := dbmodel.GetEventsByPage(r.Db, start, limit, level, machineID, appID, daemonID, subnetID, dbmodel.SortDirAny)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get events from db"
+		rsp := events.NewGetEventsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	eventsList := &models.Events{
+		Total: total,
+	}
+	for i := range dbEvents {
+		eventsList.Items = append(eventsList.Items, eventToRestAPI(&dbEvents[i]))
+	}
+
+	rsp := events.NewGetEventsOK().WithPayload(eventsList)
+	return rsp
+}
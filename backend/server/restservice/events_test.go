@@ -0,0 +1,84 @@
+package restservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/eventcenter"
+	"isc.org/stork/server/gen/restapi/operations/events"
+	storktest "isc.org/stork/server/test"
+)
+
+// Check getting the events via rest api functions.
+func TestGetEvents(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// No events yet.
+	params := events.GetEventsParams{}
+	rsp := rapi.GetEvents(ctx, params)
+	require.IsType(t, &events.GetEventsOK{}, rsp)
+	okRsp := rsp.(*events.GetEventsOK)
+	require.Zero(t, okRsp.Payload.Total)
+	require.Empty(t, okRsp.Payload.Items)
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	eventcenter.AddInfoEvent(db, "machine added", m)
+	eventcenter.AddErrorEvent(db, "machine is down", m)
+	eventcenter.AddWarningEvent(db, "something happened")
+
+	// All events, the most recent first.
+	rsp = rapi.GetEvents(ctx, params)
+	require.IsType(t, &events.GetEventsOK{}, rsp)
+	okRsp = rsp.(*events.GetEventsOK)
+	require.EqualValues(t, 3, okRsp.Payload.Total)
+	require.Len(t, okRsp.Payload.Items, 3)
+	require.Equal(t, "something happened", okRsp.Payload.Items[0].Text)
+	require.EqualValues(t, dbmodel.EvWarning, okRsp.Payload.Items[0].Level)
+	require.Zero(t, okRsp.Payload.Items[0].MachineID)
+	require.Equal(t, "machine added", okRsp.Payload.Items[2].Text)
+	require.Equal(t, m.ID, okRsp.Payload.Items[2].MachineID)
+	require.NotZero(t, okRsp.Payload.Items[2].ID)
+
+	// Filter by level and machine.
+	level := int64(dbmodel.EvWarning)
+	params = events.GetEventsParams{
+		Level:   &level,
+		Machine: &m.ID,
+	}
+	rsp = rapi.GetEvents(ctx, params)
+	require.IsType(t, &events.GetEventsOK{}, rsp)
+	okRsp = rsp.(*events.GetEventsOK)
+	require.EqualValues(t, 1, okRsp.Payload.Total)
+	require.Equal(t, "machine is down", okRsp.Payload.Items[0].Text)
+
+	// Paging.
+	start := int64(1)
+	limit := int64(1)
+	params = events.GetEventsParams{
+		Start: &start,
+		Limit: &limit,
+	}
+	rsp = rapi.GetEvents(ctx, params)
+	require.IsType(t, &events.GetEventsOK{}, rsp)
+	okRsp = rsp.(*events.GetEventsOK)
+	require.EqualValues(t, 3, okRsp.Payload.Total)
+	require.Len(t, okRsp.Payload.Items, 1)
+	require.Equal(t, "machine is down", okRsp.Payload.Items[0].Text)
+}
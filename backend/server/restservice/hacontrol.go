@@ -0,0 +1,94 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/apps/kea"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+)
+
+// Converts the outcome of the HA action to the format used in the ReST API.
+func haActionOutcomeToRestAPI(outcome *kea.HAActionOutcome) *models.HAActionResult {
+	result := &models.HAActionResult{
+		Success: outcome.Error == nil,
+		Servers: []*models.HAActionServer{},
+	}
+	if outcome.Error != nil {
+		result.Error = outcome.Error.Error()
+	}
+	for _, server := range outcome.Servers {
+		result.Servers = append(result.Servers, &models.HAActionServer{
+			AppID:    server.App.ID,
+			DaemonID: server.Daemon.ID,
+			Name:     server.Name,
+			Command:  server.Command,
+			State:    server.State,
+			Scopes:   server.Scopes,
+		})
+	}
+	return result
+}
+
+// Runs the action on the HA service, e.g. puts one of the servers into
+// the maintenance, and returns the outcome. The failure of the action is
+// reported in the outcome rather than as an error, so the states of the
+// servers are returned anyway.
+func (r *RestAPI) RunHAAction(ctx context.Context, params services.RunHAActionParams) middleware.Responder {
+	if params.Action == nil || params.Action.Action == nil || params.Action.DaemonID == nil {
+		msg := "missing HA action or daemon"
+		rsp := services.NewRunHAActionDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	dbService, err := dbmodel.GetDetailedService(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get service with id %d from db", params.ID)
+		rsp := services.NewRunHAActionDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbService == nil {
+		msg := fmt.Sprintf("cannot find service with id %d", params.ID)
+		rsp := services.NewRunHAActionDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	action := &kea.HAAction{
+		Name:      *params.Action.Action,
+		DaemonID:  *params.Action.DaemonID,
+		Scopes:    params.Action.Scopes,
+		MaxPeriod: params.Action.MaxPeriod,
+	}
+	if err = kea.ValidateHAAction(dbService, action); err != nil {
+		msg := fmt.Sprintf("cannot run HA action: %s", err)
+		rsp := services.NewRunHAActionDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	outcome, err := kea.RunHAAction(r.Db, r.Agents, dbService, action)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot run %s action on service %d", action.Name, params.ID)
+		rsp := services.NewRunHAActionDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	auditObject(ctx, "service", dbService.ID, nil, params.Action)
+	rsp := services.NewRunHAActionOK().WithPayload(haActionOutcomeToRestAPI(outcome))
+	return rsp
+}
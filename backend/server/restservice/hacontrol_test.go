@@ -0,0 +1,108 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+)
+
+// Test that the actions are run on the HA service via the ReST API.
+func TestRunHAAction(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000)
+	app := &dbmodel.App{
+		MachineID:    m.ID,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					KeaDHCPDaemon: &dbmodel.KeaDHCPDaemon{},
+				},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			ServiceType: "ha_dhcp",
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAType:    "dhcp4",
+			HAMode:    "passive-backup",
+			PrimaryID: app.Daemons[0].ID,
+		},
+	}
+	err = dbmodel.AddService(db, service)
+	require.NoError(t, err)
+	err = dbmodel.AddDaemonToService(db, service.ID, app.Daemons[0])
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(mockLeaseCommandSuccess, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// There is no partner in the passive-backup mode.
+	action := "maintenance-start"
+	daemonID := app.Daemons[0].ID
+	params := services.RunHAActionParams{
+		ID: service.ID,
+		Action: &models.HAAction{
+			Action:   &action,
+			DaemonID: &daemonID,
+		},
+	}
+	rsp := rapi.RunHAAction(ctx, params)
+	require.IsType(t, &services.RunHAActionDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*services.RunHAActionDefault)))
+	require.Empty(t, fa.RecordedCommands)
+
+	// The service doesn't exist.
+	action = "continue"
+	params.ID = service.ID + 1
+	rsp = rapi.RunHAAction(ctx, params)
+	require.IsType(t, &services.RunHAActionDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.RunHAActionDefault)))
+
+	params.ID = service.ID
+	rsp = rapi.RunHAAction(ctx, params)
+	require.IsType(t, &services.RunHAActionOK{}, rsp)
+	okRsp := rsp.(*services.RunHAActionOK)
+	require.True(t, okRsp.Payload.Success)
+	require.Len(t, okRsp.Payload.Servers, 1)
+	require.Equal(t, app.ID, okRsp.Payload.Servers[0].AppID)
+	require.Equal(t, daemonID, okRsp.Payload.Servers[0].DaemonID)
+	require.Equal(t, "ha-continue", okRsp.Payload.Servers[0].Command)
+
+	// The ha-continue command is followed by status-get.
+	require.Len(t, fa.RecordedCommands, 2)
+	require.Equal(t, "ha-continue", fa.RecordedCommands[0].Command)
+	require.Nil(t, fa.RecordedCommands[0].Arguments)
+	require.Equal(t, "status-get", fa.RecordedCommands[1].Command)
+}
@@ -2,18 +2,75 @@ package restservice
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-openapi/runtime/middleware"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"isc.org/stork/server/apps/kea"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/gen/models"
 	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
 	storkutil "isc.org/stork/util"
 )
 
+// Converts the host fetched from the database to the format used in the
+// ReST API.
+func hostToRestAPI(dbHost *dbmodel.Host) *models.Host {
+	host := &models.Host{
+		ID:       dbHost.ID,
+		SubnetID: dbHost.SubnetID,
+	}
+	// Include subnet prefix if this is subnet specific host.
+	if dbHost.Subnet != nil {
+		host.SubnetPrefix = dbHost.Subnet.Prefix
+	}
+	// Convert DHCP host identifiers.
+	for _, dbHostID := range dbHost.HostIdentifiers {
+		hostID := models.HostIdentifier{
+			IDType:     dbHostID.Type,
+			IDHexValue: dbHostID.ToHex(":"),
+		}
+		host.HostIdentifiers = append(host.HostIdentifiers, &hostID)
+	}
+	// Convert IP reservations.
+	for _, dbHostIP := range dbHost.IPReservations {
+		ip, prefix, ok := storkutil.ParseIP(dbHostIP.Address)
+		if !ok {
+			continue
+		}
+		hostIP := models.IPReservation{
+			Address: ip,
+		}
+		if prefix {
+			host.PrefixReservations = append(host.PrefixReservations, &hostIP)
+		} else {
+			host.AddressReservations = append(host.AddressReservations, &hostIP)
+		}
+	}
+	// Append local hosts containing associations of the host with
+	// apps.
+	for _, dbLocalHost := range dbHost.LocalHosts {
+		ctrl, err := dbLocalHost.App.GetControlAccessPoint()
+		if err != nil {
+			log.Warnf("problem with getting access point for app: %d: %s", dbLocalHost.AppID, err)
+			continue
+		}
+
+		localHost := models.LocalHost{
+			AppID:          dbLocalHost.AppID,
+			MachineAddress: ctrl.Location(),
+			DataSource:     dbLocalHost.DataSource,
+		}
+		host.LocalHosts = append(host.LocalHosts, &localHost)
+	}
+	return host
+}
+
 func (r *RestAPI) getHosts(offset, limit, appID int64, subnetID *int64, filterText *string, global *bool, sortField string, sortDir dbmodel.SortDirEnum) (*models.Hosts, error) {
 	// Get the hosts from the database.
 	dbHosts, total, err := dbmodel.GetHostsByPage(r.Db, offset, limit, appID, subnetID, filterText, global, sortField, sortDir)
@@ -26,55 +83,8 @@ func (r *RestAPI) getHosts(offset, limit, appID int64, subnetID *int64, filterTe
 	}
 
 	// Convert hosts fetched from the database to REST.
-	for _, dbHost := range dbHosts {
-		host := models.Host{
-			ID:       dbHost.ID,
-			SubnetID: dbHost.SubnetID,
-		}
-		// Include subnet prefix if this is subnet specific host.
-		if dbHost.Subnet != nil {
-			host.SubnetPrefix = dbHost.Subnet.Prefix
-		}
-		// Convert DHCP host identifiers.
-		for _, dbHostID := range dbHost.HostIdentifiers {
-			hostID := models.HostIdentifier{
-				IDType:     dbHostID.Type,
-				IDHexValue: dbHostID.ToHex(":"),
-			}
-			host.HostIdentifiers = append(host.HostIdentifiers, &hostID)
-		}
-		// Convert IP reservations.
-		for _, dbHostIP := range dbHost.IPReservations {
-			ip, prefix, ok := storkutil.ParseIP(dbHostIP.Address)
-			if !ok {
-				continue
-			}
-			hostIP := models.IPReservation{
-				Address: ip,
-			}
-			if prefix {
-				host.PrefixReservations = append(host.PrefixReservations, &hostIP)
-			} else {
-				host.AddressReservations = append(host.AddressReservations, &hostIP)
-			}
-		}
-		// Append local hosts containing associations of the host with
-		// apps.
-		for _, dbLocalHost := range dbHost.LocalHosts {
-			ctrl, err := dbLocalHost.App.GetAccessPoint(dbmodel.AccessPointControl)
-			if err != nil {
-				log.Warnf("problem with getting access point for app: %d: %s", dbLocalHost.AppID, err)
-				continue
-			}
-
-			localHost := models.LocalHost{
-				AppID:          dbLocalHost.AppID,
-				MachineAddress: fmt.Sprintf("%s:%d", ctrl.Address, ctrl.Port),
-				DataSource:     dbLocalHost.DataSource,
-			}
-			host.LocalHosts = append(host.LocalHosts, &localHost)
-		}
-		hosts.Items = append(hosts.Items, &host)
+	for i := range dbHosts {
+		hosts.Items = append(hosts.Items, hostToRestAPI(&dbHosts[i]))
 	}
 
 	return hosts, nil
@@ -113,3 +123,234 @@ func (r *RestAPI) GetHosts(ctx context.Context, params dhcp.GetHostsParams) midd
 	rsp := dhcp.NewGetHostsOK().WithPayload(hosts)
 	return rsp
 }
+
+// Converts the host received over the ReST API to the database format.
+// The identifiers may be specified with or without separators between
+// the pairs of hexadecimal digits.
+func restHostToDB(restHost *models.Host) (*dbmodel.Host, error) {
+	host := &dbmodel.Host{
+		SubnetID: restHost.SubnetID,
+	}
+	for _, restID := range restHost.HostIdentifiers {
+		if restID == nil {
+			continue
+		}
+		hexValue := strings.NewReplacer(":", "", "-", "", " ", "").Replace(restID.IDHexValue)
+		value, err := hex.DecodeString(hexValue)
+		if err != nil {
+			return nil, errors.Errorf("invalid value %s of identifier %s", restID.IDHexValue, restID.IDType)
+		}
+		host.HostIdentifiers = append(host.HostIdentifiers, dbmodel.HostIdentifier{
+			Type:  restID.IDType,
+			Value: value,
+		})
+	}
+	for _, reservations := range [][]*models.IPReservation{restHost.AddressReservations, restHost.PrefixReservations} {
+		for _, r := range reservations {
+			if r == nil {
+				continue
+			}
+			host.IPReservations = append(host.IPReservations, dbmodel.IPReservation{
+				Address: strings.TrimSpace(r.Address),
+			})
+		}
+	}
+	return host, nil
+}
+
+// Adds new host reservation to the Kea servers serving the subnet.
+func (r *RestAPI) CreateHost(ctx context.Context, params dhcp.CreateHostParams) middleware.Responder {
+	if params.Host == nil {
+		msg := "missing host"
+		rsp := dhcp.NewCreateHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if params.Host.SubnetID == 0 {
+		msg := "subnet must be specified, global reservations are not supported"
+		rsp := dhcp.NewCreateHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	subnet, err := dbmodel.GetSubnet(r.Db, params.Host.SubnetID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get subnet with id %d from db", params.Host.SubnetID)
+		rsp := dhcp.NewCreateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if subnet == nil {
+		msg := fmt.Sprintf("cannot find subnet with id %d", params.Host.SubnetID)
+		rsp := dhcp.NewCreateHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	host, err := restHostToDB(params.Host)
+	if err == nil {
+		err = kea.ValidateHost(r.Db, host, subnet)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("invalid host reservation: %s", err)
+		rsp := dhcp.NewCreateHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = kea.AddHostReservation(r.Db, r.Agents, host)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot add host reservation: %s", err)
+		rsp := dhcp.NewCreateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbHost, err := dbmodel.GetHost(r.Db, host.ID)
+	if err != nil || dbHost == nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get added host %d from db", host.ID)
+		rsp := dhcp.NewCreateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	restHost := hostToRestAPI(dbHost)
+	auditObject(ctx, "host", dbHost.ID, nil, restHost)
+
+	rsp := dhcp.NewCreateHostOK().WithPayload(restHost)
+	return rsp
+}
+
+// Replaces the host reservation on the Kea servers serving its subnet.
+func (r *RestAPI) UpdateHost(ctx context.Context, params dhcp.UpdateHostParams) middleware.Responder {
+	existing, err := dbmodel.GetHost(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get host with id %d from db", params.ID)
+		rsp := dhcp.NewUpdateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if existing == nil {
+		msg := fmt.Sprintf("cannot find host with id %d", params.ID)
+		rsp := dhcp.NewUpdateHostDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	var host *dbmodel.Host
+	switch {
+	case params.Host == nil:
+		err = errors.New("missing host")
+	case params.Host.SubnetID != 0 && params.Host.SubnetID != existing.SubnetID:
+		err = errors.New("subnet of the host reservation can't be changed")
+	default:
+		err = kea.ValidateHostDataSource(existing)
+	}
+	if err == nil {
+		host, err = restHostToDB(params.Host)
+	}
+	if err == nil {
+		// The subnet fetched with the host lacks the pools.
+		var subnet *dbmodel.Subnet
+		subnet, err = dbmodel.GetSubnet(r.Db, existing.SubnetID)
+		switch {
+		case err != nil:
+			log.Error(err)
+			msg := fmt.Sprintf("cannot get subnet with id %d from db", existing.SubnetID)
+			rsp := dhcp.NewUpdateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		case subnet == nil:
+			err = errors.Errorf("subnet %d not found", existing.SubnetID)
+		default:
+			host.ID = existing.ID
+			err = kea.ValidateHost(r.Db, host, subnet)
+		}
+	}
+	if err != nil {
+		msg := fmt.Sprintf("cannot update host %d: %s", params.ID, err)
+		rsp := dhcp.NewUpdateHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = kea.UpdateHostReservation(r.Db, r.Agents, existing, host)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot update host reservation %d: %s", params.ID, err)
+		rsp := dhcp.NewUpdateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbHost, err := dbmodel.GetHost(r.Db, params.ID)
+	if err != nil || dbHost == nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get updated host %d from db", params.ID)
+		rsp := dhcp.NewUpdateHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	restHost := hostToRestAPI(dbHost)
+	auditObject(ctx, "host", dbHost.ID, hostToRestAPI(existing), restHost)
+
+	rsp := dhcp.NewUpdateHostOK().WithPayload(restHost)
+	return rsp
+}
+
+// Deletes the host reservation from the Kea servers serving its subnet.
+func (r *RestAPI) DeleteHost(ctx context.Context, params dhcp.DeleteHostParams) middleware.Responder {
+	existing, err := dbmodel.GetHost(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get host with id %d from db", params.ID)
+		rsp := dhcp.NewDeleteHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if existing == nil {
+		msg := fmt.Sprintf("cannot find host with id %d", params.ID)
+		rsp := dhcp.NewDeleteHostDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if err = kea.ValidateHostDataSource(existing); err != nil {
+		msg := fmt.Sprintf("cannot delete host %d: %s", params.ID, err)
+		rsp := dhcp.NewDeleteHostDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = kea.DeleteHostReservation(r.Db, r.Agents, existing)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete host reservation %d: %s", params.ID, err)
+		rsp := dhcp.NewDeleteHostDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	auditObject(ctx, "host", existing.ID, hostToRestAPI(existing), nil)
+
+	rsp := dhcp.NewDeleteHostOK()
+	return rsp
+}
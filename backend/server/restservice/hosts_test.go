@@ -2,6 +2,7 @@ package restservice
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/go-pg/pg/v9"
@@ -298,3 +299,138 @@ func TestGetHostsWithFiltering(t *testing.T) {
 	require.Len(t, okRsp.Payload.Items, 2)
 	require.EqualValues(t, 2, okRsp.Payload.Total)
 }
+
+// Test that the host reservations can be created, updated and deleted via
+// the ReST API.
+func TestCreateUpdateDeleteHost(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000)
+	app := &dbmodel.App{
+		MachineID:    m.ID,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:      "dhcp4",
+				Active:    true,
+				KeaDaemon: &dbmodel.KeaDaemon{},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	subnet := &dbmodel.Subnet{
+		Prefix: "192.0.2.0/24",
+	}
+	err = dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+	err = dbmodel.AddAppToSubnet(db, subnet, app)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(mockLeaseCommandSuccess, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// The global reservations are not supported.
+	params := dhcp.CreateHostParams{
+		Host: &models.Host{
+			HostIdentifiers: []*models.HostIdentifier{
+				{
+					IDType:     "hw-address",
+					IDHexValue: "01:02:03:04:05:06",
+				},
+			},
+			AddressReservations: []*models.IPReservation{
+				{
+					Address: "192.0.2.10",
+				},
+			},
+		},
+	}
+	rsp := rapi.CreateHost(ctx, params)
+	require.IsType(t, &dhcp.CreateHostDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*dhcp.CreateHostDefault)))
+
+	// The identifier must be in the hexadecimal format.
+	params.Host.SubnetID = subnet.ID
+	params.Host.HostIdentifiers[0].IDHexValue = "foo"
+	rsp = rapi.CreateHost(ctx, params)
+	require.IsType(t, &dhcp.CreateHostDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*dhcp.CreateHostDefault)))
+	require.Empty(t, fa.RecordedCommands)
+
+	params.Host.HostIdentifiers[0].IDHexValue = "01-02-03-04-05-06"
+	rsp = rapi.CreateHost(ctx, params)
+	require.IsType(t, &dhcp.CreateHostOK{}, rsp)
+	host := rsp.(*dhcp.CreateHostOK).Payload
+	require.NotZero(t, host.ID)
+	require.Equal(t, "01:02:03:04:05:06", host.HostIdentifiers[0].IDHexValue)
+	require.Len(t, host.LocalHosts, 1)
+	require.Equal(t, app.ID, host.LocalHosts[0].AppID)
+	require.Equal(t, "api", host.LocalHosts[0].DataSource)
+	require.Len(t, fa.RecordedCommands, 1)
+	require.Equal(t, "reservation-add", fa.RecordedCommands[0].Command)
+
+	// Update the reserved address.
+	updateParams := dhcp.UpdateHostParams{
+		ID:   host.ID + 1,
+		Host: params.Host,
+	}
+	updateParams.Host.AddressReservations[0].Address = "192.0.2.11"
+	rsp = rapi.UpdateHost(ctx, updateParams)
+	require.IsType(t, &dhcp.UpdateHostDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*dhcp.UpdateHostDefault)))
+
+	updateParams.ID = host.ID
+	rsp = rapi.UpdateHost(ctx, updateParams)
+	require.IsType(t, &dhcp.UpdateHostOK{}, rsp)
+	host = rsp.(*dhcp.UpdateHostOK).Payload
+	require.Len(t, host.AddressReservations, 1)
+	require.Equal(t, "192.0.2.11", host.AddressReservations[0].Address)
+	require.Len(t, fa.RecordedCommands, 3)
+	require.Equal(t, "reservation-del", fa.RecordedCommands[1].Command)
+	require.Equal(t, "reservation-add", fa.RecordedCommands[2].Command)
+
+	// The reservations from the configuration files can't be deleted.
+	configHost := &dbmodel.Host{
+		SubnetID: subnet.ID,
+		HostIdentifiers: []dbmodel.HostIdentifier{
+			{
+				Type:  "hw-address",
+				Value: []byte{1, 1, 1, 1, 1, 1},
+			},
+		},
+	}
+	err = dbmodel.AddHost(db, configHost)
+	require.NoError(t, err)
+	err = dbmodel.AddAppToHost(db, configHost, app, "config", 1)
+	require.NoError(t, err)
+
+	rsp = rapi.DeleteHost(ctx, dhcp.DeleteHostParams{ID: configHost.ID})
+	require.IsType(t, &dhcp.DeleteHostDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*dhcp.DeleteHostDefault)))
+
+	rsp = rapi.DeleteHost(ctx, dhcp.DeleteHostParams{ID: host.ID})
+	require.IsType(t, &dhcp.DeleteHostOK{}, rsp)
+	require.Len(t, fa.RecordedCommands, 4)
+	require.Equal(t, "reservation-del", fa.RecordedCommands[3].Command)
+
+	returned, err := dbmodel.GetHost(db, host.ID)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+}
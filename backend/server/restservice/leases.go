@@ -0,0 +1,318 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/server/apps/kea"
+	"isc.org/stork/server/auth"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+)
+
+// Searches for the leases on the Kea servers and converts them to the
+// ReST API format. The empty list is returned when the text is empty.
+func (r *RestAPI) getLeases(text string) (*models.Leases, error) {
+	leases := &models.Leases{}
+	if r.Agents == nil {
+		return leases, nil
+	}
+	keaLeases, erredApps, err := kea.FindLeases(r.Db, r.Agents, text)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range keaLeases {
+		lease := &models.Lease{
+			AppID:             l.AppID,
+			Daemon:            l.Daemon,
+			IPAddress:         l.IPAddress,
+			PrefixLength:      l.PrefixLength,
+			LeaseType:         l.Type,
+			HwAddress:         l.HWAddress,
+			ClientID:          l.ClientID,
+			Duid:              l.DUID,
+			Iaid:              l.IAID,
+			Hostname:          l.Hostname,
+			LocalSubnetID:     l.SubnetID,
+			State:             l.State,
+			Cltt:              l.CLTT,
+			ValidLifetime:     l.ValidLifetime,
+			PreferredLifetime: l.PreferredLifetime,
+			FqdnFwd:           l.FqdnFwd,
+			FqdnRev:           l.FqdnRev,
+		}
+		leases.Items = append(leases.Items, lease)
+	}
+	leases.Total = int64(len(leases.Items))
+	for _, app := range erredApps {
+		leases.ErredApps = append(leases.ErredApps, app.ID)
+	}
+	return leases, nil
+}
+
+// Finds the leases by IP address, MAC address, client identifier, DUID
+// or hostname on all monitored Kea servers.
+func (r *RestAPI) GetLeases(ctx context.Context, params dhcp.GetLeasesParams) middleware.Responder {
+	text := ""
+	if params.Text != nil {
+		text = *params.Text
+	}
+
+	leases, err := r.getLeases(text)
+	if err != nil {
+		msg := "problem with searching for leases"
+		log.Error(err)
+		rsp := dhcp.NewGetLeasesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rsp := dhcp.NewGetLeasesOK().WithPayload(leases)
+	return rsp
+}
+
+// Converts the outcome of the lease operation on the daemons to the
+// ReST API format. It returns an error when the operation failed on all
+// daemons.
+func leaseOperationResultsToRestAPI(results []kea.LeaseOperationResult) (*models.LeaseOperationResults, error) {
+	restResults := &models.LeaseOperationResults{}
+	var firstErr error
+	succeeded := false
+	for _, result := range results {
+		restResult := &models.LeaseOperationResult{
+			AppID:    result.App.ID,
+			DaemonID: result.Daemon.ID,
+			Daemon:   result.Daemon.Name,
+			Success:  result.Error == nil,
+		}
+		if result.Error != nil {
+			restResult.Error = result.Error.Error()
+			if firstErr == nil {
+				firstErr = result.Error
+			}
+		} else {
+			succeeded = true
+		}
+		restResults.Items = append(restResults.Items, restResult)
+	}
+	if !succeeded && firstErr != nil {
+		return nil, firstErr
+	}
+	return restResults, nil
+}
+
+// Returns the Kea app with the given ID if it has the given DHCP daemon.
+// Otherwise it returns nil.
+func (r *RestAPI) getLeaseApp(appID int64, daemonName string) (*dbmodel.App, error) {
+	if daemonName != dbmodel.DaemonNameDHCPv4 && daemonName != dbmodel.DaemonNameDHCPv6 {
+		return nil, nil
+	}
+	app, err := dbmodel.GetAppByID(r.Db, appID)
+	if err != nil || app == nil || app.Type != dbmodel.AppTypeKea {
+		return nil, err
+	}
+	for _, d := range app.Daemons {
+		if d.Name == daemonName {
+			return app, nil
+		}
+	}
+	return nil, nil
+}
+
+// Adds the lease on the given DHCP daemon of the app and its HA peers.
+func (r *RestAPI) CreateLease(ctx context.Context, params dhcp.CreateLeaseParams) middleware.Responder {
+	restLease := params.Lease
+	if restLease == nil {
+		msg := "missing lease"
+		rsp := dhcp.NewCreateLeaseDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	app, err := r.getLeaseApp(restLease.AppID, restLease.Daemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get app with id %d from db", restLease.AppID)
+		rsp := dhcp.NewCreateLeaseDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if app == nil {
+		msg := fmt.Sprintf("cannot find %s daemon of Kea app with id %d", restLease.Daemon, restLease.AppID)
+		rsp := dhcp.NewCreateLeaseDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// The app is specified in the request body, so the authorizer could not
+	// check if the user is permitted to modify its leases.
+	op := &auth.Operation{
+		Resource: auth.ResourceDHCP,
+		Action:   dbmodel.PermissionWrite,
+		AppID:    app.ID,
+	}
+	if err = r.authorizeOperation(ctx, op); err != nil {
+		log.Warn(err)
+		msg := fmt.Sprintf("not permitted to add leases on app with id %d", app.ID)
+		rsp := dhcp.NewCreateLeaseDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// The address must belong to the family of the daemon and the lease
+	// must include the client identifier mandatory in this family.
+	ip := net.ParseIP(restLease.IPAddress)
+	var msg string
+	switch {
+	case ip == nil:
+		msg = fmt.Sprintf("invalid IP address %s", restLease.IPAddress)
+	case restLease.Daemon == dbmodel.DaemonNameDHCPv4 && ip.To4() == nil:
+		msg = fmt.Sprintf("%s is not an IPv4 address", restLease.IPAddress)
+	case restLease.Daemon == dbmodel.DaemonNameDHCPv4 && restLease.HwAddress == "":
+		msg = "hardware address must be specified for DHCPv4 lease"
+	case restLease.Daemon == dbmodel.DaemonNameDHCPv6 && ip.To4() != nil:
+		msg = fmt.Sprintf("%s is not an IPv6 address", restLease.IPAddress)
+	case restLease.Daemon == dbmodel.DaemonNameDHCPv6 && restLease.Duid == "":
+		msg = "DUID must be specified for DHCPv6 lease"
+	}
+	if msg != "" {
+		rsp := dhcp.NewCreateLeaseDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	lease := &kea.Lease{
+		IPAddress:         restLease.IPAddress,
+		PrefixLength:      restLease.PrefixLength,
+		HWAddress:         restLease.HwAddress,
+		ClientID:          restLease.ClientID,
+		DUID:              restLease.Duid,
+		IAID:              restLease.Iaid,
+		Type:              restLease.LeaseType,
+		Hostname:          restLease.Hostname,
+		SubnetID:          restLease.LocalSubnetID,
+		ValidLifetime:     restLease.ValidLifetime,
+		PreferredLifetime: restLease.PreferredLifetime,
+		FqdnFwd:           restLease.FqdnFwd,
+		FqdnRev:           restLease.FqdnRev,
+	}
+	results, err := kea.AddLease(r.Db, r.Agents, app, restLease.Daemon, lease)
+	if err == nil {
+		var restResults *models.LeaseOperationResults
+		restResults, err = leaseOperationResultsToRestAPI(results)
+		if err == nil {
+			auditObject(ctx, "lease", app.ID, nil, restLease)
+			rsp := dhcp.NewCreateLeaseOK().WithPayload(restResults)
+			return rsp
+		}
+	}
+	log.Error(err)
+	msg = fmt.Sprintf("cannot add lease %s: %s", restLease.IPAddress, err)
+	rsp := dhcp.NewCreateLeaseDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+		Message: &msg,
+	})
+	return rsp
+}
+
+// Deletes the lease from the given DHCP daemon of the app and its HA peers.
+func (r *RestAPI) DeleteLease(ctx context.Context, params dhcp.DeleteLeaseParams) middleware.Responder {
+	app, err := r.getLeaseApp(params.AppID, params.Daemon)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get app with id %d from db", params.AppID)
+		rsp := dhcp.NewDeleteLeaseDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if app == nil {
+		msg := fmt.Sprintf("cannot find %s daemon of Kea app with id %d", params.Daemon, params.AppID)
+		rsp := dhcp.NewDeleteLeaseDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	leaseType := ""
+	if params.LeaseType != nil {
+		leaseType = *params.LeaseType
+	}
+	if leaseType != "" && leaseType != "IA_NA" && leaseType != "IA_PD" {
+		msg := fmt.Sprintf("invalid lease type %s, expected IA_NA or IA_PD", leaseType)
+		rsp := dhcp.NewDeleteLeaseDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	results, err := kea.DeleteLease(r.Db, r.Agents, app, params.Daemon, params.IPAddress, leaseType)
+	if err == nil {
+		var restResults *models.LeaseOperationResults
+		restResults, err = leaseOperationResultsToRestAPI(results)
+		if err == nil {
+			auditObject(ctx, "lease", app.ID, map[string]interface{}{
+				"daemon":    params.Daemon,
+				"ipAddress": params.IPAddress,
+			}, nil)
+			rsp := dhcp.NewDeleteLeaseOK().WithPayload(restResults)
+			return rsp
+		}
+	}
+	log.Error(err)
+	msg := fmt.Sprintf("cannot delete lease %s: %s", params.IPAddress, err)
+	rsp := dhcp.NewDeleteLeaseDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+		Message: &msg,
+	})
+	return rsp
+}
+
+// Deletes all leases in the subnet from all Kea servers serving it.
+func (r *RestAPI) WipeSubnetLeases(ctx context.Context, params dhcp.WipeSubnetLeasesParams) middleware.Responder {
+	subnet, err := dbmodel.GetSubnet(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get subnet with id %d from db", params.ID)
+		rsp := dhcp.NewWipeSubnetLeasesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if subnet == nil {
+		msg := fmt.Sprintf("cannot find subnet with id %d", params.ID)
+		rsp := dhcp.NewWipeSubnetLeasesDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	results, err := kea.WipeSubnetLeases(r.Db, r.Agents, subnet)
+	if err == nil {
+		var restResults *models.LeaseOperationResults
+		restResults, err = leaseOperationResultsToRestAPI(results)
+		if err == nil {
+			auditObject(ctx, "subnet-leases", subnet.ID, map[string]interface{}{
+				"prefix": subnet.Prefix,
+			}, nil)
+			rsp := dhcp.NewWipeSubnetLeasesOK().WithPayload(restResults)
+			return rsp
+		}
+	}
+	log.Error(err)
+	msg := fmt.Sprintf("cannot wipe leases in subnet %s: %s", subnet.Prefix, err)
+	rsp := dhcp.NewWipeSubnetLeasesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+		Message: &msg,
+	})
+	return rsp
+}
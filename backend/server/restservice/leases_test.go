@@ -0,0 +1,283 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/server/agentcomm"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+	storktest "isc.org/stork/server/test"
+)
+
+// Generates a response to the lease4-get command.
+func mockLease4Get(callNo int, cmdResponses []interface{}) {
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	command, _ := agentcomm.NewKeaCommand("lease4-get", daemons, nil)
+	json := `[{
+        "result": 0,
+        "text": "IPv4 lease found.",
+        "arguments": {
+            "ip-address": "192.0.2.1",
+            "hw-address": "08:08:08:08:08:08",
+            "client-id": "01:02:03:04",
+            "hostname": "myhost.example.org.",
+            "subnet-id": 44,
+            "cltt": 12345678,
+            "valid-lft": 3600,
+            "state": 0
+        }
+    }]`
+	_ = agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+}
+
+// Test that the leases are searched on the Kea servers via the ReST API.
+func TestGetLeases(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000)
+	app := &dbmodel.App{
+		MachineID:    m.ID,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:      "dhcp4",
+				Active:    true,
+				KeaDaemon: &dbmodel.KeaDaemon{},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(mockLease4Get, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// No text, no leases.
+	rsp := rapi.GetLeases(ctx, dhcp.GetLeasesParams{})
+	require.IsType(t, &dhcp.GetLeasesOK{}, rsp)
+	okRsp := rsp.(*dhcp.GetLeasesOK)
+	require.Empty(t, okRsp.Payload.Items)
+	require.Empty(t, fa.RecordedCommands)
+
+	text := "192.0.2.1"
+	rsp = rapi.GetLeases(ctx, dhcp.GetLeasesParams{Text: &text})
+	require.IsType(t, &dhcp.GetLeasesOK{}, rsp)
+	okRsp = rsp.(*dhcp.GetLeasesOK)
+	require.Len(t, okRsp.Payload.Items, 1)
+	require.EqualValues(t, 1, okRsp.Payload.Total)
+	require.Empty(t, okRsp.Payload.ErredApps)
+
+	lease := okRsp.Payload.Items[0]
+	require.Equal(t, app.ID, lease.AppID)
+	require.Equal(t, "dhcp4", lease.Daemon)
+	require.Equal(t, "192.0.2.1", lease.IPAddress)
+	require.Equal(t, "08:08:08:08:08:08", lease.HwAddress)
+	require.Equal(t, "01:02:03:04", lease.ClientID)
+	require.Equal(t, "myhost.example.org.", lease.Hostname)
+	require.EqualValues(t, 44, lease.LocalSubnetID)
+	require.EqualValues(t, 12345678, lease.Cltt)
+	require.EqualValues(t, 3600, lease.ValidLifetime)
+
+	require.Len(t, fa.RecordedCommands, 1)
+	require.Equal(t, "lease4-get", fa.RecordedCommands[0].Command)
+}
+
+// Generates a successful response to the lease command.
+func mockLeaseCommandSuccess(callNo int, cmdResponses []interface{}) {
+	daemons, _ := agentcomm.NewKeaDaemons("dhcp4")
+	command, _ := agentcomm.NewKeaCommand("lease4-add", daemons, nil)
+	json := `[{
+        "result": 0,
+        "text": "Lease for address 192.0.2.1, subnet-id 44 added."
+    }]`
+	_ = agentcomm.UnmarshalKeaResponseList(command, json, cmdResponses[0])
+}
+
+// Test that the leases can be added, deleted and wiped via the ReST API.
+func TestCreateDeleteLease(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000)
+	app := &dbmodel.App{
+		MachineID:    m.ID,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:      "dhcp4",
+				Active:    true,
+				KeaDaemon: &dbmodel.KeaDaemon{},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(mockLeaseCommandSuccess, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	admin, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	ctx := makeLoggedInContext(t, rapi, admin)
+
+	// The DHCPv4 lease requires the hardware address.
+	params := dhcp.CreateLeaseParams{
+		Lease: &models.Lease{
+			AppID:     app.ID,
+			Daemon:    "dhcp4",
+			IPAddress: "192.0.2.1",
+		},
+	}
+	rsp := rapi.CreateLease(ctx, params)
+	require.IsType(t, &dhcp.CreateLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*dhcp.CreateLeaseDefault)))
+
+	// The address must belong to the family of the daemon.
+	params.Lease.HwAddress = "08:08:08:08:08:08"
+	params.Lease.IPAddress = "2001:db8:1::1"
+	rsp = rapi.CreateLease(ctx, params)
+	require.IsType(t, &dhcp.CreateLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*dhcp.CreateLeaseDefault)))
+
+	// There is no DHCPv6 daemon.
+	params.Lease.Daemon = "dhcp6"
+	rsp = rapi.CreateLease(ctx, params)
+	require.IsType(t, &dhcp.CreateLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*dhcp.CreateLeaseDefault)))
+	require.Empty(t, fa.RecordedCommands)
+
+	params.Lease.Daemon = "dhcp4"
+	params.Lease.IPAddress = "192.0.2.1"
+	rsp = rapi.CreateLease(ctx, params)
+	require.IsType(t, &dhcp.CreateLeaseOK{}, rsp)
+	okRsp := rsp.(*dhcp.CreateLeaseOK)
+	require.Len(t, okRsp.Payload.Items, 1)
+	require.Equal(t, app.ID, okRsp.Payload.Items[0].AppID)
+	require.True(t, okRsp.Payload.Items[0].Success)
+	require.Len(t, fa.RecordedCommands, 1)
+	require.Equal(t, "lease4-add", fa.RecordedCommands[0].Command)
+
+	// Delete the lease.
+	delParams := dhcp.DeleteLeaseParams{
+		AppID:     app.ID,
+		Daemon:    "dhcp4",
+		IPAddress: "192.0.2.1",
+	}
+	rsp = rapi.DeleteLease(ctx, delParams)
+	require.IsType(t, &dhcp.DeleteLeaseOK{}, rsp)
+	require.Len(t, fa.RecordedCommands, 2)
+	require.Equal(t, "lease4-del", fa.RecordedCommands[1].Command)
+
+	delParams.AppID = app.ID + 1
+	rsp = rapi.DeleteLease(ctx, delParams)
+	require.IsType(t, &dhcp.DeleteLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*dhcp.DeleteLeaseDefault)))
+
+	// The subnet doesn't exist.
+	rsp = rapi.WipeSubnetLeases(ctx, dhcp.WipeSubnetLeasesParams{ID: 123})
+	require.IsType(t, &dhcp.WipeSubnetLeasesDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*dhcp.WipeSubnetLeasesDefault)))
+}
+
+// Test that the lease can only be added by the user permitted to manage
+// the app specified in the request body.
+func TestCreateLeaseNotPermitted(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Active:    true,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:      "dhcp4",
+				Active:    true,
+				KeaDaemon: &dbmodel.KeaDaemon{},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	// The DNS administrators can only read the DHCP data.
+	group, err := dbmodel.GetGroupByName(db, "dns-admin")
+	require.NoError(t, err)
+	require.NotNil(t, group)
+	user := &dbmodel.SystemUser{
+		Login:    "dnsadmin",
+		Lastname: "Doe",
+		Name:     "John",
+		Password: "pass",
+		Groups:   []*dbmodel.SystemGroup{group},
+	}
+	con, err := dbmodel.CreateUser(db, user)
+	require.False(t, con)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(mockLeaseCommandSuccess, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+
+	params := dhcp.CreateLeaseParams{
+		Lease: &models.Lease{
+			AppID:     app.ID,
+			Daemon:    "dhcp4",
+			IPAddress: "192.0.2.1",
+			HwAddress: "08:08:08:08:08:08",
+		},
+	}
+
+	// The user has not signed in.
+	rsp := rapi.CreateLease(context.Background(), params)
+	require.IsType(t, &dhcp.CreateLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*dhcp.CreateLeaseDefault)))
+
+	ctx := makeLoggedInContext(t, rapi, user)
+	rsp = rapi.CreateLease(ctx, params)
+	require.IsType(t, &dhcp.CreateLeaseDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*dhcp.CreateLeaseDefault)))
+	require.Empty(t, fa.RecordedCommands)
+}
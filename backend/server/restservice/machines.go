@@ -19,6 +19,7 @@ import (
 	"isc.org/stork/server/apps/kea"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
 	"isc.org/stork/server/gen/models"
 	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
 	"isc.org/stork/server/gen/restapi/operations/general"
@@ -66,6 +67,7 @@ func machineToRestAPI(dbMachine dbmodel.Machine) *models.Machine {
 		ID:                   dbMachine.ID,
 		Address:              &dbMachine.Address,
 		AgentPort:            dbMachine.AgentPort,
+		Authorized:           &dbMachine.Authorized,
 		AgentVersion:         dbMachine.State.AgentVersion,
 		Cpus:                 dbMachine.State.Cpus,
 		CpusLoad:             dbMachine.State.CpusLoad,
@@ -86,40 +88,40 @@ func machineToRestAPI(dbMachine dbmodel.Machine) *models.Machine {
 		Error:                dbMachine.Error,
 		Apps:                 apps,
 	}
+	if !dbMachine.UnreachableSince.IsZero() {
+		unreachableSince := strfmt.DateTime(dbMachine.UnreachableSince)
+		m.UnreachableSince = &unreachableSince
+	}
 	return &m
 }
 
 // appCompare compares two apps on equality.  Two apps are considered equal if
-// their type matches and if they have the same control port.  Return true if
-// equal, false otherwise.
+// their type matches and if they have the same control access point, i.e. the
+// same control address and port or the same control socket of the Kea daemon
+// running without the Kea Control Agent.  Multiple apps of the same type may
+// listen on the same port on different addresses.  Return true if equal,
+// false otherwise.
 func appCompare(dbApp *dbmodel.App, app *agentcomm.App) bool {
 	if dbApp.Type != app.Type {
 		return false
 	}
 
-	var controlPortEqual bool
 	for _, pt1 := range dbApp.AccessPoints {
-		if pt1.Type != dbmodel.AccessPointControl {
+		if pt1.Type != dbmodel.AccessPointControl && pt1.Type != dbmodel.AccessPointControlSocket {
 			continue
 		}
 		for _, pt2 := range app.AccessPoints {
-			if pt2.Type != dbmodel.AccessPointControl {
+			if pt2.Type != pt1.Type {
 				continue
 			}
 
-			if pt1.Port == pt2.Port {
-				controlPortEqual = true
-				break
+			if pt1.Address == pt2.Address && pt1.Port == pt2.Port {
+				return true
 			}
 		}
-
-		// If a match is found, we can break.
-		if controlPortEqual {
-			break
-		}
 	}
 
-	return controlPortEqual
+	return false
 }
 
 func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmodel.Machine, agents agentcomm.ConnectedAgents) string {
@@ -128,6 +130,17 @@ func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 
 	// get state of machine from agent
 	state, err := agents.GetState(ctx2, dbMachine.Address, dbMachine.AgentPort)
+
+	// The connectivity state may have been updated in the database while
+	// the machine was being contacted, so don't overwrite it with the stale
+	// value.
+	health := agents.GetAgentHealth(dbMachine.Address, dbMachine.AgentPort)
+	if health.UnreachableSince.IsZero() {
+		dbMachine.UnreachableSince = time.Time{}
+	} else if dbMachine.UnreachableSince.IsZero() {
+		dbMachine.UnreachableSince = health.UnreachableSince
+	}
+
 	if err != nil {
 		log.Warn(err)
 		dbMachine.Error = "cannot get state of machine"
@@ -159,19 +172,19 @@ func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 				break
 			}
 		}
+		var accessPoints []*dbmodel.AccessPoint
+		for _, point := range app.AccessPoints {
+			accessPoints = append(accessPoints, &dbmodel.AccessPoint{
+				Type:              point.Type,
+				Address:           point.Address,
+				Port:              point.Port,
+				Key:               point.Key,
+				UseSecureProtocol: point.UseSecureProtocol,
+			})
+		}
+
 		// if no old app in db then prepare new record
 		if dbApp == nil {
-			var accessPoints []*dbmodel.AccessPoint
-
-			for _, point := range app.AccessPoints {
-				accessPoints = append(accessPoints, &dbmodel.AccessPoint{
-					Type:    point.Type,
-					Address: point.Address,
-					Port:    point.Port,
-					Key:     point.Key,
-				})
-			}
-
 			dbApp = &dbmodel.App{
 				ID:           0,
 				MachineID:    dbMachine.ID,
@@ -180,7 +193,23 @@ func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 				AccessPoints: accessPoints,
 			}
 		} else {
+			// The access points of the known app may have changed, e.g.
+			// TLS may have been enabled.
 			dbApp.Machine = dbMachine
+			dbApp.AccessPoints = accessPoints
+		}
+
+		// Remember the daemons of the already known app, so the changes
+		// of their state can be reported.
+		var oldDaemons []*dbmodel.Daemon
+		newApp := dbApp.ID == 0
+		if !newApp {
+			oldApp, err := dbmodel.GetAppByID(db, dbApp.ID)
+			if err != nil {
+				log.Warn(err)
+			} else if oldApp != nil {
+				oldDaemons = oldApp.Daemons
+			}
 		}
 
 		switch app.Type {
@@ -202,6 +231,12 @@ func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 		log.Printf("committed information about %s app running on %s to database",
 			dbApp.Type, dbMachine.Address)
 
+		if newApp {
+			eventcenter.AddInfoEvent(db, fmt.Sprintf("new %s app detected on machine %s", dbApp.Type, dbMachine.Address), dbMachine, dbApp)
+		} else {
+			addDaemonStateEvents(db, dbMachine, dbApp, oldDaemons)
+		}
+
 		// add app to machine's apps list
 		dbMachine.Apps = append(dbMachine.Apps, dbApp)
 	}
@@ -219,14 +254,36 @@ func getMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 			err = dbmodel.DeleteApp(db, dbApp)
 			if err != nil {
 				log.Error(err)
+				continue
 			}
-			log.Printf("deleted %s app on %s", dbApp.Type, dbMachine.Address)
+			eventcenter.AddWarningEvent(db, fmt.Sprintf("%s app removed from machine %s", dbApp.Type, dbMachine.Address), dbMachine)
 		}
 	}
 
 	return ""
 }
 
+// Emits events about the daemons of the app which stopped or started
+// running since the app state was last fetched. The old daemons are
+// matched with the new ones by name.
+func addDaemonStateEvents(db *dbops.PgDB, dbMachine *dbmodel.Machine, dbApp *dbmodel.App, oldDaemons []*dbmodel.Daemon) {
+	for _, daemon := range dbApp.Daemons {
+		for _, oldDaemon := range oldDaemons {
+			if oldDaemon.Name != daemon.Name || oldDaemon.Active == daemon.Active {
+				continue
+			}
+			if daemon.Active {
+				eventcenter.AddInfoEvent(db, fmt.Sprintf("%s daemon of %s app on machine %s is active again",
+					daemon.Name, dbApp.Type, dbMachine.Address), dbMachine, dbApp, daemon)
+			} else {
+				eventcenter.AddErrorEvent(db, fmt.Sprintf("%s daemon of %s app on machine %s is not active",
+					daemon.Name, dbApp.Type, dbMachine.Address), dbMachine, dbApp, daemon)
+			}
+			break
+		}
+	}
+}
+
 // Get runtime state of indicated machine.
 func (r *RestAPI) GetMachineState(ctx context.Context, params services.GetMachineStateParams) middleware.Responder {
 	dbMachine, err := dbmodel.GetMachineByID(r.Db, params.ID)
@@ -245,6 +302,13 @@ func (r *RestAPI) GetMachineState(ctx context.Context, params services.GetMachin
 		})
 		return rsp
 	}
+	if !dbMachine.Authorized {
+		msg := fmt.Sprintf("machine with id %d is not authorized", params.ID)
+		rsp := services.NewGetMachineStateDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
 
 	errStr := getMachineAndAppsState(ctx, r.Db, dbMachine, r.Agents)
 	if errStr != "" {
@@ -260,8 +324,8 @@ func (r *RestAPI) GetMachineState(ctx context.Context, params services.GetMachin
 	return rsp
 }
 
-func (r *RestAPI) getMachines(offset, limit int64, filterText *string, sortField string, sortDir dbmodel.SortDirEnum) (*models.Machines, error) {
-	dbMachines, total, err := dbmodel.GetMachinesByPage(r.Db, offset, limit, filterText, sortField, sortDir)
+func (r *RestAPI) getMachines(offset, limit int64, filterText *string, authorized *bool, sortField string, sortDir dbmodel.SortDirEnum) (*models.Machines, error) {
+	dbMachines, total, err := dbmodel.GetMachinesByPage(r.Db, offset, limit, filterText, authorized, sortField, sortDir)
 	if err != nil {
 		return nil, err
 	}
@@ -306,7 +370,7 @@ func (r *RestAPI) GetMachines(ctx context.Context, params services.GetMachinesPa
 		"app":   app,
 	}).Info("query machines")
 
-	machines, err := r.getMachines(start, limit, params.Text, "", dbmodel.SortDirAny)
+	machines, err := r.getMachines(start, limit, params.Text, params.Authorized, "", dbmodel.SortDirAny)
 	if err != nil {
 		log.Error(err)
 		msg := "cannot get machines from db"
@@ -342,6 +406,16 @@ func (r *RestAPI) GetMachine(ctx context.Context, params services.GetMachinePara
 	return rsp
 }
 
+// Returns the machine fields which can be changed by the users and are
+// recorded in the audit trail.
+func machineToAudit(dbMachine *dbmodel.Machine) map[string]interface{} {
+	return map[string]interface{}{
+		"address":    dbMachine.Address,
+		"agentPort":  dbMachine.AgentPort,
+		"authorized": dbMachine.Authorized,
+	}
+}
+
 // Add a machine where Stork Agent is running.
 func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachineParams) middleware.Responder {
 	addr := *params.Machine.Address
@@ -373,7 +447,8 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 	}
 
 	if dbMachine == nil {
-		dbMachine = &dbmodel.Machine{Address: addr, AgentPort: params.Machine.AgentPort}
+		// The machines added by an administrator are authorized.
+		dbMachine = &dbmodel.Machine{Address: addr, AgentPort: params.Machine.AgentPort, Authorized: true}
 		err = dbmodel.AddMachine(r.Db, dbMachine)
 		if err != nil {
 			msg := fmt.Sprintf("cannot store machine %s", addr)
@@ -383,6 +458,7 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 			})
 			return rsp
 		}
+		auditObject(ctx, "machine", dbMachine.ID, nil, machineToAudit(dbMachine))
 	}
 
 	errStr := getMachineAndAppsState(ctx, r.Db, dbMachine, r.Agents)
@@ -450,9 +526,18 @@ func (r *RestAPI) UpdateMachine(ctx context.Context, params services.UpdateMachi
 		}
 	}
 
+	before := machineToAudit(dbMachine)
+
 	// copy fields
+	oldAddress := dbMachine.Address
+	oldAgentPort := dbMachine.AgentPort
 	dbMachine.Address = addr
 	dbMachine.AgentPort = params.Machine.AgentPort
+	approved := false
+	if params.Machine.Authorized != nil {
+		approved = *params.Machine.Authorized && !dbMachine.Authorized
+		dbMachine.Authorized = *params.Machine.Authorized
+	}
 	err = r.Db.Update(dbMachine)
 	if err != nil {
 		msg := fmt.Sprintf("cannot update machine with id %d in db", params.ID)
@@ -462,6 +547,25 @@ func (r *RestAPI) UpdateMachine(ctx context.Context, params services.UpdateMachi
 		})
 		return rsp
 	}
+	auditObject(ctx, "machine", dbMachine.ID, before, machineToAudit(dbMachine))
+
+	// The requests are sent to the agent at the new address from now on.
+	// The connection with the agent of the machine which is not authorized
+	// anymore is closed.
+	if oldAddress != dbMachine.Address || oldAgentPort != dbMachine.AgentPort || !dbMachine.Authorized {
+		r.Agents.RemoveAgent(oldAddress, oldAgentPort)
+	}
+
+	// The machine has just been approved so fetch its state right away
+	// rather than waiting for the pullers. The machine has been updated
+	// already, so the failure is not reported to the user. The pullers
+	// will fetch the state later.
+	if approved {
+		errStr := getMachineAndAppsState(ctx, r.Db, dbMachine, r.Agents)
+		if errStr != "" {
+			log.Warnf("problem with fetching state of approved machine %d: %s", dbMachine.ID, errStr)
+		}
+	}
 	m := machineToRestAPI(*dbMachine)
 	rsp := services.NewUpdateMachineOK().WithPayload(m)
 	return rsp
@@ -518,6 +622,10 @@ func (r *RestAPI) DeleteMachine(ctx context.Context, params services.DeleteMachi
 		})
 		return rsp
 	}
+	auditObject(ctx, "machine", dbMachine.ID, machineToAudit(dbMachine), nil)
+
+	// stop the worker sending requests to the agent of the deleted machine
+	r.Agents.RemoveAgent(dbMachine.Address, dbMachine.AgentPort)
 
 	rsp := services.NewDeleteMachineOK()
 
@@ -540,10 +648,11 @@ func appToRestAPI(dbApp *dbmodel.App) *models.App {
 	var accessPoints []*models.AppAccessPoint
 	for _, point := range dbApp.AccessPoints {
 		accessPoints = append(accessPoints, &models.AppAccessPoint{
-			Type:    point.Type,
-			Address: point.Address,
-			Port:    point.Port,
-			Key:     point.Key,
+			Type:              point.Type,
+			Address:           point.Address,
+			Port:              point.Port,
+			Key:               point.Key,
+			UseSecureProtocol: point.UseSecureProtocol,
 		})
 	}
 	app.AccessPoints = accessPoints
@@ -737,7 +846,11 @@ func (r *RestAPI) GetAppServicesStatus(ctx context.Context, params services.GetA
 			}
 			ha := s.HAService
 			keaStatus := models.KeaStatus{
-				Daemon: ha.HAType,
+				ServiceID: s.ID,
+				Daemon:    ha.HAType,
+				// Let the user know about the configuration issues which
+				// may affect the failover.
+				HaConfigMismatches: kea.CheckHAServiceConsistency(&s),
 			}
 			secondaryRole := "secondary"
 			if ha.HAMode == "hot-standby" {
@@ -935,6 +1048,24 @@ func (r *RestAPI) GetDhcpOverview(ctx context.Context, params dhcp.GetDhcpOvervi
 		return rsp
 	}
 
+	// get list of subnets which addresses run out first
+	dbExhaustingSubnets, err := dbmodel.GetSubnetsByAddrExhaustion(r.Db, 0, 5)
+	if err != nil {
+		msg := "cannot get subnets with addresses exhaustion forecast from the db"
+		log.Error(err)
+		rsp := dhcp.NewGetDhcpOverviewDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	exhaustingSubnets := &models.Subnets{
+		Items: []*models.Subnet{},
+		Total: int64(len(dbExhaustingSubnets)),
+	}
+	for i := range dbExhaustingSubnets {
+		exhaustingSubnets.Items = append(exhaustingSubnets.Items, subnetToRestAPI(&dbExhaustingSubnets[i]))
+	}
+
 	// get dhcp statistics
 	stats, err := dbmodel.GetAllStats(r.Db)
 	if err != nil {
@@ -1004,13 +1135,14 @@ func (r *RestAPI) GetDhcpOverview(ctx context.Context, params dhcp.GetDhcpOvervi
 
 	// combine gathered information
 	overview := &models.DhcpOverview{
-		Subnets4:        subnets4,
-		Subnets6:        subnets6,
-		SharedNetworks4: sharedNetworks4,
-		SharedNetworks6: sharedNetworks6,
-		Dhcp4Stats:      dhcp4Stats,
-		Dhcp6Stats:      dhcp6Stats,
-		DhcpDaemons:     dhcpDaemons,
+		Subnets4:          subnets4,
+		Subnets6:          subnets6,
+		SharedNetworks4:   sharedNetworks4,
+		SharedNetworks6:   sharedNetworks6,
+		ExhaustingSubnets: exhaustingSubnets,
+		Dhcp4Stats:        dhcp4Stats,
+		Dhcp6Stats:        dhcp6Stats,
+		DhcpDaemons:       dhcpDaemons,
 	}
 
 	rsp := dhcp.NewGetDhcpOverviewOK().WithPayload(overview)
@@ -2,6 +2,7 @@ package restservice
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"testing"
 	"time"
@@ -48,6 +49,18 @@ func TestGetVersion(t *testing.T) {
 	require.Equal(t, "unset", *p.Date)
 }
 
+// Check that the time since when the machine is unreachable is returned
+// only for the unreachable machines.
+func TestMachineToRestAPIUnreachable(t *testing.T) {
+	m := machineToRestAPI(dbmodel.Machine{Address: "localhost"})
+	require.Nil(t, m.UnreachableSince)
+
+	since := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	m = machineToRestAPI(dbmodel.Machine{Address: "localhost", UnreachableSince: since})
+	require.NotNil(t, m.UnreachableSince)
+	require.True(t, since.Equal(time.Time(*m.UnreachableSince)))
+}
+
 func TestGetMachineStateOnly(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
@@ -76,11 +89,20 @@ func TestGetMachineStateOnly(t *testing.T) {
 	err = dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
 
-	// get added machine
+	// the machine is not authorized so the agent must not be contacted
 	params = services.GetMachineStateParams{
 		ID: m.ID,
 	}
 	rsp = rapi.GetMachineState(ctx, params)
+	require.IsType(t, &services.GetMachineStateDefault{}, rsp)
+	defaultRsp = rsp.(*services.GetMachineStateDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*defaultRsp))
+
+	// authorize the machine and get its state
+	m.Authorized = true
+	err = db.Update(m)
+	require.NoError(t, err)
+	rsp = rapi.GetMachineState(ctx, params)
 	require.IsType(t, &services.GetMachineStateOK{}, rsp)
 	okRsp := rsp.(*services.GetMachineStateOK)
 	require.Equal(t, "localhost", *okRsp.Payload.Address)
@@ -176,6 +198,48 @@ func mockGetAppsState(callNo int, cmdResponses []interface{}) {
 	}
 }
 
+// Test that the apps are matched by the control address and port or by
+// the control socket.
+func TestAppCompare(t *testing.T) {
+	dbApp := &dbmodel.App{
+		Type: dbmodel.AppTypeKea,
+		AccessPoints: []*dbmodel.AccessPoint{
+			{
+				Type:    dbmodel.AccessPointControl,
+				Address: "192.0.2.1",
+				Port:    8000,
+			},
+		},
+	}
+	require.True(t, appCompare(dbApp, &agentcomm.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: makeAccessPoint(dbmodel.AccessPointControl, "192.0.2.1", "", 8000),
+	}))
+	// The other Kea Control Agent listening on the same port.
+	require.False(t, appCompare(dbApp, &agentcomm.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: makeAccessPoint(dbmodel.AccessPointControl, "192.0.2.2", "", 8000),
+	}))
+	require.False(t, appCompare(dbApp, &agentcomm.App{
+		Type:         dbmodel.AppTypeBind9,
+		AccessPoints: makeAccessPoint(dbmodel.AccessPointControl, "192.0.2.1", "", 8000),
+	}))
+
+	// The Kea daemons running without the Kea Control Agent.
+	dbApp.AccessPoints[0] = &dbmodel.AccessPoint{
+		Type:    dbmodel.AccessPointControlSocket,
+		Address: "/run/kea/dhcp4.sock",
+	}
+	require.True(t, appCompare(dbApp, &agentcomm.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: makeAccessPoint(dbmodel.AccessPointControlSocket, "/run/kea/dhcp4.sock", "", 0),
+	}))
+	require.False(t, appCompare(dbApp, &agentcomm.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: makeAccessPoint(dbmodel.AccessPointControlSocket, "/run/kea/dhcp6.sock", "", 0),
+	}))
+}
+
 func TestGetMachineAndAppsState(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
@@ -188,8 +252,9 @@ func TestGetMachineAndAppsState(t *testing.T) {
 
 	// add machine
 	m := &dbmodel.Machine{
-		Address:   "localhost",
-		AgentPort: 8080,
+		Address:    "localhost",
+		AgentPort:  8080,
+		Authorized: true,
 	}
 	err = dbmodel.AddMachine(db, m)
 	require.NoError(t, err)
@@ -243,6 +308,23 @@ func TestGetMachineAndAppsState(t *testing.T) {
 	require.Len(t, okRsp.Payload.Apps, 2)
 	require.Equal(t, dbmodel.AppTypeKea, okRsp.Payload.Apps[0].Type)
 	require.Equal(t, dbmodel.AppTypeBind9, okRsp.Payload.Apps[1].Type)
+
+	// BIND 9 is not running anymore so it should be removed and this
+	// should be recorded as an event.
+	fa.CallNo = 0
+	fa.MachineState.Apps = fa.MachineState.Apps[:1]
+	rsp = rapi.GetMachineState(ctx, params)
+	require.IsType(t, &services.GetMachineStateOK{}, rsp)
+	okRsp = rsp.(*services.GetMachineStateOK)
+	require.Len(t, okRsp.Payload.Apps, 1)
+
+	dbEvents, _, err := dbmodel.GetEventsByPage(db, 0, 10, dbmodel.EvWarning, m.ID, 0, 0, 0, dbmodel.SortDirAny)
+	require.NoError(t, err)
+	var texts []string
+	for _, ev := range dbEvents {
+		texts = append(texts, ev.Text)
+	}
+	require.Contains(t, texts, "bind9 app removed from machine localhost")
 }
 
 func TestCreateMachine(t *testing.T) {
@@ -296,6 +378,7 @@ func TestCreateMachine(t *testing.T) {
 	okRsp := rsp.(*services.CreateMachineOK)
 	require.Equal(t, addr, *okRsp.Payload.Address)
 	require.EqualValues(t, 8080, okRsp.Payload.AgentPort)
+	require.True(t, *okRsp.Payload.Authorized)
 	require.Less(t, int64(0), okRsp.Payload.Memory)
 	require.Less(t, int64(0), okRsp.Payload.Cpus)
 	require.LessOrEqual(t, int64(0), okRsp.Payload.Uptime)
@@ -323,6 +406,50 @@ func TestGetMachines(t *testing.T) {
 	//require.Greater(t, ms.Items, )
 }
 
+// Check that the machines waiting for the approval can be listed
+// separately from the authorized ones.
+func TestGetMachinesAuthorized(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for i, authorized := range []bool{true, false} {
+		m := &dbmodel.Machine{
+			Address:    "localhost",
+			AgentPort:  int64(8080 + i),
+			Authorized: authorized,
+		}
+		err = dbmodel.AddMachine(db, m)
+		require.NoError(t, err)
+	}
+
+	authorized := false
+	params := services.GetMachinesParams{
+		Authorized: &authorized,
+	}
+	rsp := rapi.GetMachines(ctx, params)
+	ms := rsp.(*services.GetMachinesOK).Payload
+	require.EqualValues(t, 1, ms.Total)
+	require.EqualValues(t, 8081, ms.Items[0].AgentPort)
+	require.False(t, *ms.Items[0].Authorized)
+
+	authorized = true
+	rsp = rapi.GetMachines(ctx, params)
+	ms = rsp.(*services.GetMachinesOK).Payload
+	require.EqualValues(t, 1, ms.Total)
+	require.EqualValues(t, 8080, ms.Items[0].AgentPort)
+	require.True(t, *ms.Items[0].Authorized)
+
+	rsp = rapi.GetMachines(ctx, services.GetMachinesParams{})
+	ms = rsp.(*services.GetMachinesOK).Payload
+	require.EqualValues(t, 2, ms.Total)
+}
+
 func TestGetMachine(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
@@ -479,6 +606,52 @@ func TestUpdateMachine(t *testing.T) {
 	require.Equal(t, "cannot parse address", *defaultRsp.Payload.Message)
 }
 
+// Check that approving the machine registered by the agent fetches
+// its state.
+func TestUpdateMachineAuthorize(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	// update without the authorized flag leaves the machine unauthorized
+	addr := "localhost"
+	params := services.UpdateMachineParams{
+		ID: m.ID,
+		Machine: &models.Machine{
+			Address:   &addr,
+			AgentPort: 8080,
+		},
+	}
+	rsp := rapi.UpdateMachine(ctx, params)
+	okRsp := rsp.(*services.UpdateMachineOK)
+	require.False(t, *okRsp.Payload.Authorized)
+	require.Zero(t, okRsp.Payload.Cpus)
+
+	// approve the machine
+	authorized := true
+	params.Machine.Authorized = &authorized
+	rsp = rapi.UpdateMachine(ctx, params)
+	okRsp = rsp.(*services.UpdateMachineOK)
+	require.True(t, *okRsp.Payload.Authorized)
+	require.EqualValues(t, 1, okRsp.Payload.Cpus)
+
+	dbMachine, err := dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.True(t, dbMachine.Authorized)
+}
+
 func TestDeleteMachine(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
@@ -923,6 +1096,117 @@ func TestRestGetAppServicesStatus(t *testing.T) {
 	require.Zero(t, haStatus.SecondaryServer.UnackedClients)
 	require.Zero(t, haStatus.SecondaryServer.UnackedClientsLeft)
 	require.Zero(t, haStatus.SecondaryServer.AnalyzedPackets)
+
+	// The daemons have no configurations, so there is nothing to compare.
+	require.Empty(t, status.HaConfigMismatches)
+}
+
+// Test that the mismatches between the configurations of the servers
+// belonging to the HA service are returned along with the status.
+func TestRestGetAppServicesStatusHAConfigMismatches(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	// Add two Kea apps with the HA servers using different heartbeat
+	// delays.
+	configTemplate := `{
+        "Dhcp4": {
+            "hooks-libraries": [
+                {
+                    "library": "libdhcp_ha.so",
+                    "parameters": {
+                        "high-availability": [{
+                            "this-server-name": "%s",
+                            "mode": "load-balancing",
+                            "heartbeat-delay": %d,
+                            "peers": [
+                                {
+                                    "name": "server1",
+                                    "url": "http://192.0.2.33:8000",
+                                    "role": "primary"
+                                },
+                                {
+                                    "name": "server2",
+                                    "url": "http://192.0.2.66:8000",
+                                    "role": "secondary"
+                                }
+                            ]
+                        }]
+                    }
+                }
+            ]
+        }
+    }`
+	var apps []*dbmodel.App
+	for i, name := range []string{"server1", "server2"} {
+		config, err := dbmodel.NewKeaConfigFromJSON(fmt.Sprintf(configTemplate, name, (i+1)*10000))
+		require.NoError(t, err)
+		var accessPoints []*dbmodel.AccessPoint
+		accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "127.0.0.1", "", int64(1234+i))
+		app := &dbmodel.App{
+			MachineID:    m.ID,
+			Type:         dbmodel.AppTypeKea,
+			Active:       true,
+			AccessPoints: accessPoints,
+			Daemons: []*dbmodel.Daemon{
+				{
+					Name:   "dhcp4",
+					Active: true,
+					KeaDaemon: &dbmodel.KeaDaemon{
+						Config:        config,
+						KeaDHCPDaemon: &dbmodel.KeaDHCPDaemon{},
+					},
+				},
+			},
+		}
+		err = dbmodel.AddApp(db, app)
+		require.NoError(t, err)
+		apps = append(apps, app)
+	}
+
+	service := &dbmodel.Service{
+		BaseService: dbmodel.BaseService{
+			ServiceType: "ha_dhcp",
+		},
+		HAService: &dbmodel.BaseHAService{
+			HAType:      "dhcp4",
+			HAMode:      "load-balancing",
+			PrimaryID:   apps[0].Daemons[0].ID,
+			SecondaryID: apps[1].Daemons[0].ID,
+		},
+	}
+	err = dbmodel.AddService(db, service)
+	require.NoError(t, err)
+	for _, app := range apps {
+		err = dbmodel.AddDaemonToService(db, service.ID, app.Daemons[0])
+		require.NoError(t, err)
+	}
+
+	params := services.GetAppServicesStatusParams{
+		ID: apps[0].ID,
+	}
+	rsp := rapi.GetAppServicesStatus(ctx, params)
+	require.IsType(t, &services.GetAppServicesStatusOK{}, rsp)
+	okRsp := rsp.(*services.GetAppServicesStatusOK)
+	require.Len(t, okRsp.Payload.Items, 1)
+
+	status := okRsp.Payload.Items[0].Status.KeaStatus
+	require.Len(t, status.HaConfigMismatches, 1)
+	require.Equal(t, "heartbeat-delay is 10000 on server server1 and 20000 on server server2",
+		status.HaConfigMismatches[0])
 }
 
 // Test that status of a HA service providing passive-backup mode is
@@ -1113,5 +1397,6 @@ func TestGetDhcpOverview(t *testing.T) {
 	require.Len(t, okRsp.Payload.Subnets6.Items, 0)
 	require.Len(t, okRsp.Payload.SharedNetworks4.Items, 0)
 	require.Len(t, okRsp.Payload.SharedNetworks6.Items, 0)
+	require.Len(t, okRsp.Payload.ExhaustingSubnets.Items, 0)
 	require.Len(t, okRsp.Payload.DhcpDaemons, 0)
 }
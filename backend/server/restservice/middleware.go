@@ -1,6 +1,7 @@
 package restservice
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,6 +12,8 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"isc.org/stork/server/auth"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/metrics"
 )
 
 // Install a middleware that traces ReST calls using logrus.
@@ -62,10 +65,24 @@ func fileServerMiddleware(next http.Handler, staticFilesDir string) http.Handler
 	})
 }
 
+// Install a middleware that is serving the Prometheus metrics under the
+// /metrics path. Other requests are passed to the next handler.
+func metricsMiddleware(next http.Handler, metricsHandler http.Handler) http.Handler {
+	log.Info("installed metrics middleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			metricsHandler.ServeHTTP(w, r)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
 // Inner middleware function provides a common place to setup middlewares for
 // the server. It is invoked after routing but before authentication, binding and validation
 func (r *RestAPI) InnerMiddleware(handler http.Handler) http.Handler {
 	// last handler is executed first for incoming request
+	handler = r.auditMiddleware(handler)
 	handler = r.SessionManager.SessionMiddleware(handler)
 	return handler
 }
@@ -75,21 +92,76 @@ func (r *RestAPI) InnerMiddleware(handler http.Handler) http.Handler {
 func (r *RestAPI) GlobalMiddleware(handler http.Handler, staticFilesDir string) http.Handler {
 	// last handler is executed first for incoming request
 	handler = fileServerMiddleware(handler, staticFilesDir)
+	if r.Settings.EnableMetrics {
+		metricsHandler, err := metrics.NewHandler(r.Db)
+		if err != nil {
+			log.Errorf("cannot setup metrics endpoint: %s", err)
+		} else {
+			handler = metricsMiddleware(handler, metricsHandler)
+		}
+	}
 	handler = loggingMiddleware(handler)
 	return handler
 }
 
-// Checks if the user us authorized to access the system (has session).
+// Checks if the user is authorized to access the system (has session or
+// API token) and is permitted to perform the requested operation. The
+// permissions granted to the groups of the user are fetched from the
+// database. The scopes of the API token further limit the operations.
 func (r *RestAPI) Authorizer(req *http.Request) error {
-	ok, u := r.SessionManager.Logged(req.Context())
+	return r.authorizeOperation(req.Context(), auth.GetOperation(req))
+}
+
+// Checks if the user who sent the request is permitted to perform the
+// operation. It is used by the Authorizer and by the handlers which can
+// only tell the object of the operation after parsing the request body.
+func (r *RestAPI) authorizeOperation(ctx context.Context, op *auth.Operation) error {
+	if r.SessionManager == nil {
+		return fmt.Errorf("user unauthorized")
+	}
+	ok, u := r.SessionManager.Logged(ctx)
 	if !ok {
 		return fmt.Errorf("user unauthorized")
 	}
 
-	ok, _ = auth.Authorize(u, req)
-	if !ok {
+	var scopes []string
+	if apiToken := r.SessionManager.APIToken(ctx); apiToken != nil {
+		scopes = apiToken.Scopes
+	}
+	superAdmin := u.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID})
+
+	// The permissions to the app depend on its type and may be granted
+	// for the machine the app is running on.
+	if op.AppID != 0 && (!superAdmin || len(scopes) > 0) {
+		app, err := dbmodel.GetAppByID(r.Db, op.AppID)
+		if err != nil {
+			return err
+		}
+		if app != nil {
+			op.Resource = auth.AppResource(app.Type)
+			op.MachineID = app.MachineID
+		}
+	}
+
+	var permissions []dbmodel.Permission
+	if !superAdmin {
+		var groupIDs []int
+		for _, g := range u.Groups {
+			groupIDs = append(groupIDs, g.ID)
+		}
+		var err error
+		permissions, err = dbmodel.GetPermissionsByGroupIDs(r.Db, groupIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !auth.Authorize(u, permissions, op) {
 		return fmt.Errorf("user logged in but not allowed to access the resource")
 	}
+	if !auth.AuthorizeScopes(scopes, op) {
+		return fmt.Errorf("API token does not allow to access the resource")
+	}
 
 	return nil
 }
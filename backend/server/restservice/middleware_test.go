@@ -1,6 +1,7 @@
 package restservice
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -9,6 +10,7 @@ import (
 
 	dbsession "isc.org/stork/server/database/session"
 	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/restapi"
 	storktest "isc.org/stork/server/test"
 )
 
@@ -44,6 +46,34 @@ func TestFileServerMiddleware(t *testing.T) {
 	require.True(t, apiRequestReceived)
 }
 
+// Check if metricsMiddleware serves the metrics and passes other requests
+// to the next handler.
+func TestMetricsMiddleware(t *testing.T) {
+	nextRequestReceived := false
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextRequestReceived = true
+	})
+	metricsRequestReceived := false
+	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsRequestReceived = true
+	})
+
+	handler := metricsMiddleware(nextHandler, metricsHandler)
+
+	req := httptest.NewRequest("GET", "http://localhost/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.True(t, metricsRequestReceived)
+	require.False(t, nextRequestReceived)
+
+	metricsRequestReceived = false
+	req = httptest.NewRequest("GET", "http://localhost/api/subnets", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.False(t, metricsRequestReceived)
+	require.True(t, nextRequestReceived)
+}
+
 // Check if InnerMiddleware works.
 func TestInnerMiddleware(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
@@ -52,10 +82,48 @@ func TestInnerMiddleware(t *testing.T) {
 	fa := storktest.NewFakeAgents(nil, nil)
 	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
 	require.NoError(t, err)
-	sm, err := dbsession.NewSessionMgr(&rapi.DbSettings.BaseDatabaseSettings)
+	sm, err := dbsession.NewSessionMgr(&rapi.DbSettings.BaseDatabaseSettings, db)
 	require.NoError(t, err)
 	rapi.SessionManager = sm
 
 	handler := rapi.InnerMiddleware(nil)
 	require.NotNil(t, handler)
 }
+
+// Check that all operations accepting the session cookie also accept
+// the API token, so the operations specifying their own security
+// requirements don't reject the requests authenticated with the token.
+func TestOperationsAcceptBearerToken(t *testing.T) {
+	var spec struct {
+		Security []map[string][]string                 `json:"security"`
+		Paths    map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(restapi.SwaggerJSON, &spec))
+
+	hasScheme := func(security []map[string][]string, scheme string) bool {
+		for _, requirement := range security {
+			if _, ok := requirement[scheme]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	require.True(t, hasScheme(spec.Security, "BearerToken"))
+
+	for path, ops := range spec.Paths {
+		for method, rawOp := range ops {
+			if method == "parameters" {
+				continue
+			}
+			var op struct {
+				OperationID string                `json:"operationId"`
+				Security    []map[string][]string `json:"security"`
+			}
+			require.NoError(t, json.Unmarshal(rawOp, &op))
+			if hasScheme(op.Security, "Token") {
+				require.True(t, hasScheme(op.Security, "BearerToken"),
+					"%s %s (%s) does not accept the API token", method, path, op.OperationID)
+			}
+		}
+	}
+}
@@ -22,7 +22,8 @@ func handleSearchError(err error, text string) middleware.Responder {
 }
 
 // Search through different tables in database. Currently supported tables are:
-// machines, apps, subnets, shared networks, hosts, users, groups.
+// machines, apps, subnets, shared networks, hosts, users, groups. The
+// leases are searched on the Kea servers.
 // If filter text is empty then empty result is returned.
 func (r *RestAPI) SearchRecords(ctx context.Context, params search.SearchRecordsParams) middleware.Responder {
 	// if empty text is provided then empty result is returned
@@ -31,6 +32,7 @@ func (r *RestAPI) SearchRecords(ctx context.Context, params search.SearchRecords
 			Subnets:        &models.Subnets{},
 			SharedNetworks: &models.SharedNetworks{},
 			Hosts:          &models.Hosts{},
+			Leases:         &models.Leases{},
 			Machines:       &models.Machines{},
 			Apps:           &models.Apps{},
 			Users:          &models.Users{},
@@ -59,8 +61,14 @@ func (r *RestAPI) SearchRecords(ctx context.Context, params search.SearchRecords
 		return handleSearchError(err, "cannot get hosts from the db")
 	}
 
+	// get list of leases from the Kea servers
+	leases, err := r.getLeases(text)
+	if err != nil {
+		return handleSearchError(err, "cannot get leases from the Kea servers")
+	}
+
 	// get list of machines
-	machines, err := r.getMachines(0, 5, &text, "", dbmodel.SortDirAny)
+	machines, err := r.getMachines(0, 5, &text, nil, "", dbmodel.SortDirAny)
 	if err != nil {
 		return handleSearchError(err, "cannot get machines from the db")
 	}
@@ -88,6 +96,7 @@ func (r *RestAPI) SearchRecords(ctx context.Context, params search.SearchRecords
 		Subnets:        subnets,
 		SharedNetworks: sharedNetworks,
 		Hosts:          hosts,
+		Leases:         leases,
 		Machines:       machines,
 		Apps:           apps,
 		Users:          users,
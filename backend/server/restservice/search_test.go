@@ -35,6 +35,7 @@ func TestSearchRecords(t *testing.T) {
 	require.EqualValues(t, 0, okRsp.Payload.Groups.Total)
 	require.Len(t, okRsp.Payload.Hosts.Items, 0)
 	require.EqualValues(t, 0, okRsp.Payload.Hosts.Total)
+	require.Len(t, okRsp.Payload.Leases.Items, 0)
 	require.Len(t, okRsp.Payload.Machines.Items, 0)
 	require.EqualValues(t, 0, okRsp.Payload.Machines.Total)
 	require.Len(t, okRsp.Payload.SharedNetworks.Items, 0)
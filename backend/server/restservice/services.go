@@ -19,6 +19,7 @@ import (
 	"golang.org/x/net/netutil"
 
 	"isc.org/stork/server/agentcomm"
+	"isc.org/stork/server/auth"
 	dbops "isc.org/stork/server/database"
 	dbsession "isc.org/stork/server/database/session"
 	"isc.org/stork/server/gen/restapi"
@@ -42,6 +43,8 @@ type RestAPISettings struct {
 	TLSCACertificate  flags.Filename `long:"rest-tls-ca" description:"the certificate authority file to be used with mutual tls auth" env:"STORK_REST_TLS_CA_CERTIFICATE"`
 
 	StaticFilesDir string `long:"rest-static-files-dir" description:"Directory with static files for UI" default:"" env:"STORK_REST_STATIC_FILES_DIR"`
+
+	EnableMetrics bool `long:"rest-enable-metrics" description:"serve Prometheus metrics describing the state of the monitored network under /metrics; no authentication is required" env:"STORK_REST_ENABLE_METRICS"`
 }
 
 // Runtime information and settings for ReST API service.
@@ -50,6 +53,10 @@ type RestAPI struct {
 	DbSettings     *dbops.DatabaseSettings
 	Db             *dbops.PgDB
 	SessionManager *dbsession.SessionMgr
+	// Authenticators verifying the credentials of the users signing in,
+	// tried in turn. The passwords stored in the database are used when
+	// none are specified.
+	Authenticators []auth.Authenticator
 
 	Agents agentcomm.ConnectedAgents
 
@@ -61,6 +68,10 @@ type RestAPI struct {
 	hasListeners bool
 	Host         string // actual host for listening
 	Port         int    // actual port for listening
+
+	// Refreshes the states of the machines which agents reported
+	// changed apps.
+	machineRefresher *machineRefresher
 }
 
 // Do API initialization.
@@ -71,6 +82,7 @@ func NewRestAPI(settings *RestAPISettings, dbSettings *dbops.DatabaseSettings, d
 		Db:         db,
 		Agents:     agents,
 	}
+	r.machineRefresher = newMachineRefresher(machineRefreshDelay, r.refreshMachineState)
 	return r, nil
 }
 
@@ -148,7 +160,7 @@ func prepareTLS(httpServer *http.Server, s *RestAPISettings) error {
 // Serve the API
 func (r *RestAPI) Serve() (err error) {
 	// Initialize sessions with access to the database.
-	sm, err := dbsession.NewSessionMgr(&r.DbSettings.BaseDatabaseSettings)
+	sm, err := dbsession.NewSessionMgr(&r.DbSettings.BaseDatabaseSettings, r.Db)
 	if err != nil {
 		return errors.Wrap(err, "unable to establish connection to the session database")
 	}
@@ -162,6 +174,9 @@ func (r *RestAPI) Serve() (err error) {
 		DhcpAPI:         r,
 		SettingsAPI:     r,
 		SearchAPI:       r,
+		EventsAPI:       r,
+		AlertingAPI:     r,
+		AuditAPI:        r,
 		Logger:          log.Infof,
 		InnerMiddleware: r.InnerMiddleware,
 		Authorizer:      r.Authorizer,
@@ -173,6 +188,11 @@ func (r *RestAPI) Serve() (err error) {
 			// return the token.
 			return token, nil
 		},
+		AuthBearerToken: func(token string) (interface{}, error) {
+			// The API token is authenticated by the session
+			// middleware.
+			return token, nil
+		},
 	})
 	if err != nil {
 		return errors.Wrap(err, "cannot setup ReST API handler")
@@ -293,6 +313,7 @@ func (r *RestAPI) Listen() error {
 
 func (r *RestAPI) Shutdown() {
 	log.Printf("Stopping ReST API Service")
+	r.machineRefresher.stop()
 	if r.HTTPServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
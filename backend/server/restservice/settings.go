@@ -25,6 +25,7 @@ func (r *RestAPI) GetSettings(ctx context.Context, params settings.GetSettingsPa
 	}
 
 	s := &models.Settings{
+		AlertingInterval:         dbSettingsMap["alerting_interval"].(int64),
 		Bind9StatsPullerInterval: dbSettingsMap["bind9_stats_puller_interval"].(int64),
 		GrafanaURL:               dbSettingsMap["grafana_url"].(string),
 		KeaHostsPullerInterval:   dbSettingsMap["kea_hosts_puller_interval"].(int64),
@@ -54,7 +55,18 @@ func (r *RestAPI) UpdateSettings(ctx context.Context, params settings.UpdateSett
 		Message: &msg,
 	})
 
-	err := dbmodel.SetSettingInt(r.Db, "bind9_stats_puller_interval", s.Bind9StatsPullerInterval)
+	before, err := dbmodel.GetAllSettings(r.Db)
+	if err != nil {
+		log.Error(err)
+		return errRsp
+	}
+
+	err = dbmodel.SetSettingInt(r.Db, "alerting_interval", s.AlertingInterval)
+	if err != nil {
+		log.Error(err)
+		return errRsp
+	}
+	err = dbmodel.SetSettingInt(r.Db, "bind9_stats_puller_interval", s.Bind9StatsPullerInterval)
 	if err != nil {
 		log.Error(err)
 		return errRsp
@@ -85,6 +97,13 @@ func (r *RestAPI) UpdateSettings(ctx context.Context, params settings.UpdateSett
 		return errRsp
 	}
 
+	after, err := dbmodel.GetAllSettings(r.Db)
+	if err != nil {
+		log.Error(err)
+		return errRsp
+	}
+	auditObject(ctx, "settings", 0, before, after)
+
 	rsp := settings.NewUpdateSettingsOK()
 	return rsp
 }
@@ -34,12 +34,14 @@ func TestSettings(t *testing.T) {
 	rsp := rapi.GetSettings(ctx, paramsGS)
 	require.IsType(t, &settings.GetSettingsOK{}, rsp)
 	okRsp := rsp.(*settings.GetSettingsOK)
+	require.EqualValues(t, 60, okRsp.Payload.AlertingInterval)
 	require.EqualValues(t, 60, okRsp.Payload.Bind9StatsPullerInterval)
 	require.EqualValues(t, "", okRsp.Payload.GrafanaURL)
 
 	// update settings
 	paramsUS := settings.UpdateSettingsParams{
 		Settings: &models.Settings{
+			AlertingInterval:         120,
 			Bind9StatsPullerInterval: 10,
 			GrafanaURL:               "http://localhost:3000",
 		},
@@ -52,6 +54,7 @@ func TestSettings(t *testing.T) {
 	rsp = rapi.GetSettings(ctx, paramsGS)
 	require.IsType(t, &settings.GetSettingsOK{}, rsp)
 	okRsp = rsp.(*settings.GetSettingsOK)
+	require.EqualValues(t, 120, okRsp.Payload.AlertingInterval)
 	require.EqualValues(t, 10, okRsp.Payload.Bind9StatsPullerInterval)
 	require.EqualValues(t, "http://localhost:3000", okRsp.Payload.GrafanaURL)
 }
@@ -0,0 +1,170 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storkutil "isc.org/stork/util"
+)
+
+// Name of the pseudo statistic returning the number of the leases assigned
+// per hour. It is computed from the history of the assigned addresses.
+const statisticLeaseRate = "lease-rate"
+
+// Fetches the history of the statistic of the given owner in the given
+// period and converts it to the format used in the ReST API. The period
+// defaults to the last 24 hours.
+func (r *RestAPI) getStatisticSeries(owner dbmodel.StatisticOwner, name string, fromParam, toParam *strfmt.DateTime) (*models.StatisticSeries, error) {
+	to := storkutil.UTCNow()
+	if toParam != nil {
+		to = optionalTimeFromRestAPI(toParam)
+	}
+	from := to.Add(-dbmodel.RawStatisticRetention)
+	if fromParam != nil {
+		from = optionalTimeFromRestAPI(fromParam)
+	}
+
+	dbName := name
+	if name == statisticLeaseRate {
+		dbName = dbmodel.StatisticAssignedAddresses
+	}
+	samples, err := dbmodel.GetStatisticSeries(r.Db, owner, dbName, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if name == statisticLeaseRate {
+		samples = dbmodel.GetStatisticRates(samples)
+	}
+
+	series := &models.StatisticSeries{
+		Name:   name,
+		Points: []*models.StatisticPoint{},
+	}
+	for _, sample := range samples {
+		series.Points = append(series.Points, &models.StatisticPoint{
+			Time:  strfmt.DateTime(sample.SampledAt),
+			Value: sample.Value,
+		})
+	}
+	return series, nil
+}
+
+// Gets the history of the statistic of the subnet, e.g. the addresses
+// utilization.
+func (r *RestAPI) GetSubnetStatsSeries(ctx context.Context, params dhcp.GetSubnetStatsSeriesParams) middleware.Responder {
+	subnet, err := dbmodel.GetSubnet(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get subnet with id %d from db", params.ID)
+		rsp := dhcp.NewGetSubnetStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if subnet == nil {
+		msg := fmt.Sprintf("cannot find subnet with id %d", params.ID)
+		rsp := dhcp.NewGetSubnetStatsSeriesDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	series, err := r.getStatisticSeries(dbmodel.StatisticOwner{SubnetID: subnet.ID}, params.Name, params.From, params.To)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get %s statistic of subnet %d from db", params.Name, params.ID)
+		rsp := dhcp.NewGetSubnetStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := dhcp.NewGetSubnetStatsSeriesOK().WithPayload(series)
+	return rsp
+}
+
+// Gets the history of the statistic of the shared network.
+func (r *RestAPI) GetSharedNetworkStatsSeries(ctx context.Context, params dhcp.GetSharedNetworkStatsSeriesParams) middleware.Responder {
+	network, err := dbmodel.GetSharedNetwork(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get shared network with id %d from db", params.ID)
+		rsp := dhcp.NewGetSharedNetworkStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if network == nil {
+		msg := fmt.Sprintf("cannot find shared network with id %d", params.ID)
+		rsp := dhcp.NewGetSharedNetworkStatsSeriesDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	series, err := r.getStatisticSeries(dbmodel.StatisticOwner{SharedNetworkID: network.ID}, params.Name, params.From, params.To)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get %s statistic of shared network %d from db", params.Name, params.ID)
+		rsp := dhcp.NewGetSharedNetworkStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := dhcp.NewGetSharedNetworkStatsSeriesOK().WithPayload(series)
+	return rsp
+}
+
+// Gets the history of the statistic of the daemon, e.g. the total number
+// of the assigned addresses or the cache hit ratio.
+func (r *RestAPI) GetDaemonStatsSeries(ctx context.Context, params services.GetDaemonStatsSeriesParams) middleware.Responder {
+	dbApp, err := dbmodel.GetAppByID(r.Db, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get app with id %d from db", params.ID)
+		rsp := services.NewGetDaemonStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	found := false
+	if dbApp != nil {
+		for _, daemon := range dbApp.Daemons {
+			if daemon.Name == params.Daemon {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		msg := fmt.Sprintf("cannot find %s daemon of app with id %d", params.Daemon, params.ID)
+		rsp := services.NewGetDaemonStatsSeriesDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	owner := dbmodel.StatisticOwner{
+		AppID:      dbApp.ID,
+		DaemonName: params.Daemon,
+	}
+	series, err := r.getStatisticSeries(owner, params.Name, params.From, params.To)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get %s statistic of %s daemon of app %d from db", params.Name, params.Daemon, params.ID)
+		rsp := services.NewGetDaemonStatsSeriesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	rsp := services.NewGetDaemonStatsSeriesOK().WithPayload(series)
+	return rsp
+}
@@ -0,0 +1,145 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/stretchr/testify/require"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+	storkutil "isc.org/stork/util"
+)
+
+// Test that the history of the subnet's statistics is returned, including
+// the lease rate computed from the assigned addresses.
+func TestGetSubnetStatsSeries(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := &dbmodel.Subnet{
+		Prefix: "192.0.2.0/24",
+	}
+	err := dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+
+	now := storkutil.UTCNow().Truncate(time.Second)
+	samples := []dbmodel.StatisticSample{}
+	for i, assigned := range []float64{10, 20, 40} {
+		sampledAt := now.Add(time.Duration(i-3) * time.Hour)
+		samples = append(samples, dbmodel.StatisticSample{
+			StatisticOwner: dbmodel.StatisticOwner{SubnetID: subnet.ID},
+			Name:           dbmodel.StatisticAssignedAddresses,
+			SampledAt:      sampledAt,
+			Value:          assigned,
+		}, dbmodel.StatisticSample{
+			StatisticOwner: dbmodel.StatisticOwner{SubnetID: subnet.ID},
+			Name:           dbmodel.StatisticAddrUtilization,
+			SampledAt:      sampledAt,
+			Value:          100 * assigned / 256,
+		})
+	}
+	err = dbmodel.AddStatisticSamples(db, samples)
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	params := dhcp.GetSubnetStatsSeriesParams{
+		ID:   subnet.ID,
+		Name: "addr-utilization",
+	}
+	rsp := rapi.GetSubnetStatsSeries(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetStatsSeriesOK{}, rsp)
+	series := rsp.(*dhcp.GetSubnetStatsSeriesOK).Payload
+	require.Equal(t, "addr-utilization", series.Name)
+	require.Len(t, series.Points, 3)
+	require.InDelta(t, 100*40.0/256, series.Points[2].Value, 0.001)
+
+	// Limit the period.
+	from := strfmt.DateTime(now.Add(-90 * time.Minute))
+	params.From = &from
+	rsp = rapi.GetSubnetStatsSeries(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetStatsSeriesOK{}, rsp)
+	require.Len(t, rsp.(*dhcp.GetSubnetStatsSeriesOK).Payload.Points, 1)
+
+	params.From = nil
+	params.Name = "lease-rate"
+	rsp = rapi.GetSubnetStatsSeries(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetStatsSeriesOK{}, rsp)
+	series = rsp.(*dhcp.GetSubnetStatsSeriesOK).Payload
+	require.Len(t, series.Points, 2)
+	require.InDelta(t, 10, series.Points[0].Value, 0.001)
+	require.InDelta(t, 20, series.Points[1].Value, 0.001)
+
+	// The subnet doesn't exist.
+	params.ID = subnet.ID + 1
+	rsp = rapi.GetSubnetStatsSeries(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetStatsSeriesDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*dhcp.GetSubnetStatsSeriesDefault)))
+}
+
+// Test that the history of the daemon's statistics is returned.
+func TestGetDaemonStatsSeries(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeBind9,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:        "named",
+				Bind9Daemon: &dbmodel.Bind9Daemon{},
+			},
+		},
+	}
+	err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	err = dbmodel.AddStatisticSamples(db, []dbmodel.StatisticSample{{
+		StatisticOwner: dbmodel.StatisticOwner{AppID: app.ID, DaemonName: "named"},
+		Name:           dbmodel.StatisticCacheHitRatio,
+		SampledAt:      storkutil.UTCNow().Add(-time.Minute),
+		Value:          0.75,
+	}})
+	require.NoError(t, err)
+
+	settings := RestAPISettings{}
+	fa := storktest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	params := services.GetDaemonStatsSeriesParams{
+		ID:     app.ID,
+		Daemon: "named",
+		Name:   "cache-hit-ratio",
+	}
+	rsp := rapi.GetDaemonStatsSeries(ctx, params)
+	require.IsType(t, &services.GetDaemonStatsSeriesOK{}, rsp)
+	series := rsp.(*services.GetDaemonStatsSeriesOK).Payload
+	require.Len(t, series.Points, 1)
+	require.Equal(t, 0.75, series.Points[0].Value)
+
+	// The app has no such daemon.
+	params.Daemon = "dhcp4"
+	rsp = rapi.GetDaemonStatsSeries(ctx, params)
+	require.IsType(t, &services.GetDaemonStatsSeriesDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonStatsSeriesDefault)))
+}
@@ -2,8 +2,8 @@ package restservice
 
 import (
 	"context"
-	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/go-openapi/strfmt"
@@ -12,14 +12,30 @@ import (
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/gen/models"
 	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+	storkutil "isc.org/stork/util"
 )
 
+// Converts the forecast time of the addresses exhaustion to the number of
+// days from now. It returns 0 if the time has passed and nil if no
+// exhaustion is expected.
+func daysUntilExhaustion(exhaustedAt *time.Time) *float64 {
+	if exhaustedAt == nil {
+		return nil
+	}
+	days := exhaustedAt.Sub(storkutil.UTCNow()).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return &days
+}
+
 func subnetToRestAPI(sn *dbmodel.Subnet) *models.Subnet {
 	subnet := &models.Subnet{
-		ID:              sn.ID,
-		Subnet:          sn.Prefix,
-		ClientClass:     sn.ClientClass,
-		AddrUtilization: float64(sn.AddrUtilization) / 10,
+		ID:                sn.ID,
+		Subnet:            sn.Prefix,
+		ClientClass:       sn.ClientClass,
+		AddrUtilization:   float64(sn.AddrUtilization) / 10,
+		AddrDaysUntilFull: daysUntilExhaustion(sn.AddrExhaustedAt),
 	}
 
 	for _, poolDetails := range sn.AddressPools {
@@ -32,7 +48,7 @@ func subnetToRestAPI(sn *dbmodel.Subnet) *models.Subnet {
 	}
 
 	for _, lsn := range sn.LocalSubnets {
-		ctrl, err := lsn.App.GetAccessPoint(dbmodel.AccessPointControl)
+		ctrl, err := lsn.App.GetControlAccessPoint()
 		if err != nil {
 			log.Warnf("problem with getting access point for app: %d: %s", lsn.AppID, err)
 			continue
@@ -41,7 +57,7 @@ func subnetToRestAPI(sn *dbmodel.Subnet) *models.Subnet {
 		localSubnet := &models.LocalSubnet{
 			AppID:            lsn.App.ID,
 			ID:               lsn.LocalSubnetID,
-			MachineAddress:   fmt.Sprintf("%s:%d", ctrl.Address, ctrl.Port),
+			MachineAddress:   ctrl.Location(),
 			MachineHostname:  lsn.App.Machine.State.Hostname,
 			Stats:            lsn.Stats,
 			StatsCollectedAt: strfmt.DateTime(lsn.StatsCollectedAt),
@@ -136,10 +152,11 @@ func (r *RestAPI) getSharedNetworks(offset, limit, appID, family int64, filterTe
 		}
 		// Create shared network.
 		sharedNetwork := &models.SharedNetwork{
-			ID:              net.ID,
-			Name:            net.Name,
-			Subnets:         subnets,
-			AddrUtilization: float64(net.AddrUtilization) / 10,
+			ID:                net.ID,
+			Name:              net.Name,
+			Subnets:           subnets,
+			AddrUtilization:   float64(net.AddrUtilization) / 10,
+			AddrDaysUntilFull: daysUntilExhaustion(net.AddrExhaustedAt),
 		}
 		sharedNetworks.Items = append(sharedNetworks.Items, sharedNetwork)
 	}
@@ -3,6 +3,7 @@ package restservice
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -10,6 +11,7 @@ import (
 	dbtest "isc.org/stork/server/database/test"
 	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
 	storktest "isc.org/stork/server/test"
+	storkutil "isc.org/stork/util"
 )
 
 // Check getting subnets via rest api functions.
@@ -503,3 +505,26 @@ func TestGetSharedNetworks(t *testing.T) {
 	require.Equal(t, a4.ID, okRsp.Payload.Items[1].Subnets[0].LocalSubnets[0].AppID)
 	require.ElementsMatch(t, []string{"mouse", "frog"}, []string{okRsp.Payload.Items[0].Name, okRsp.Payload.Items[1].Name})
 }
+
+// Test that the forecast time of the addresses exhaustion is converted to
+// the number of days.
+func TestDaysUntilExhaustion(t *testing.T) {
+	require.Nil(t, daysUntilExhaustion(nil))
+
+	exhaustedAt := storkutil.UTCNow().Add(36 * time.Hour)
+	days := daysUntilExhaustion(&exhaustedAt)
+	require.NotNil(t, days)
+	require.InDelta(t, 1.5, *days, 0.01)
+
+	exhaustedAt = storkutil.UTCNow().Add(-time.Hour)
+	days = daysUntilExhaustion(&exhaustedAt)
+	require.NotNil(t, days)
+	require.Zero(t, *days)
+
+	subnet := subnetToRestAPI(&dbmodel.Subnet{
+		Prefix:          "192.0.2.0/24",
+		AddrExhaustedAt: &exhaustedAt,
+	})
+	require.NotNil(t, subnet.AddrDaysUntilFull)
+	require.Nil(t, subnetToRestAPI(&dbmodel.Subnet{Prefix: "192.0.2.0/24"}).AddrDaysUntilFull)
+}
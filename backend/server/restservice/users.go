@@ -7,9 +7,11 @@ import (
 	"strings"
 
 	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"isc.org/stork/server/auth"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/gen/models"
 	"isc.org/stork/server/gen/restapi/operations/users"
@@ -50,18 +52,62 @@ func newRestGroup(g dbmodel.SystemGroup) *models.Group {
 	return r
 }
 
+// Returns the user who sent the request or nil if the request has not
+// been sent by a logged in user.
+func (r *RestAPI) getLoggedUser(ctx context.Context) *dbmodel.SystemUser {
+	if r.SessionManager == nil {
+		return nil
+	}
+	_, user := r.SessionManager.Logged(ctx)
+	return user
+}
+
+// Checks if the user belongs to the super-admin group.
+func isSuperAdmin(user *dbmodel.SystemUser) bool {
+	return user != nil && user.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID})
+}
+
+// Checks if both lists contain the same groups regardless of their order.
+func sameGroups(groups1, groups2 []*dbmodel.SystemGroup) bool {
+	ids := make(map[int]bool)
+	for _, g := range groups1 {
+		ids[g.ID] = true
+	}
+	ids2 := make(map[int]bool)
+	for _, g := range groups2 {
+		if !ids[g.ID] {
+			return false
+		}
+		ids2[g.ID] = true
+	}
+	return len(ids) == len(ids2)
+}
+
 // Attempts to login the user to the system.
 func (r *RestAPI) CreateSession(ctx context.Context, params users.CreateSessionParams) middleware.Responder {
-	user := &dbmodel.SystemUser{}
-	login := *params.Useremail
-	if strings.Contains(login, "@") {
-		user.Email = login
-	} else {
-		user.Login = login
+	var login, password string
+	if params.Useremail != nil {
+		login = *params.Useremail
+	}
+	if params.Userpassword != nil {
+		password = *params.Userpassword
 	}
-	user.Password = *params.Userpassword
 
-	ok, err := dbmodel.Authenticate(r.Db, user)
+	// Try the authenticators in turn until one of them accepts the
+	// credentials.
+	authenticators := r.Authenticators
+	if len(authenticators) == 0 {
+		authenticators = []auth.Authenticator{auth.NewDatabaseAuthenticator(r.Db)}
+	}
+	var user *dbmodel.SystemUser
+	var err error
+	for _, a := range authenticators {
+		user, err = a.Authenticate(login, password)
+		if user != nil || err != nil {
+			break
+		}
+	}
+	ok := user != nil
 	if ok {
 		err = r.SessionManager.LoginHandler(ctx, user)
 	}
@@ -182,6 +228,16 @@ func (r *RestAPI) CreateUser(ctx context.Context, params users.CreateUserParams)
 		su.Groups = append(su.Groups, &dbmodel.SystemGroup{ID: int(gid)})
 	}
 
+	// The groups grant the permissions, so only the super-admin can
+	// assign them.
+	if len(su.Groups) > 0 && !isSuperAdmin(r.getLoggedUser(ctx)) {
+		msg := "only super-admin can assign groups to the user"
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserDefault(http.StatusForbidden).WithPayload(&rspErr)
+	}
+
 	con, err := dbmodel.CreateUser(r.Db, su)
 	if err != nil {
 		if con {
@@ -206,6 +262,7 @@ func (r *RestAPI) CreateUser(ctx context.Context, params users.CreateUserParams)
 	}
 
 	*u.ID = int64(su.ID)
+	auditObject(ctx, "user", int64(su.ID), nil, u)
 	return users.NewCreateUserOK().WithPayload(u)
 }
 
@@ -214,6 +271,16 @@ func (r *RestAPI) UpdateUser(ctx context.Context, params users.UpdateUserParams)
 	u := params.Account.User
 	p := params.Account.Password
 
+	// The access to the user account is authorized using the id from
+	// the path, so the account with another id must not be updated.
+	if u.ID == nil || *u.ID != params.ID {
+		msg := fmt.Sprintf("user id in the request body does not match user id %d in the path", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewUpdateUserDefault(http.StatusBadRequest).WithPayload(&rspErr)
+	}
+
 	su := &dbmodel.SystemUser{
 		ID:       int(*u.ID),
 		Login:    *u.Login,
@@ -227,6 +294,44 @@ func (r *RestAPI) UpdateUser(ctx context.Context, params users.UpdateUserParams)
 		su.Groups = append(su.Groups, &dbmodel.SystemGroup{ID: int(gid)})
 	}
 
+	oldUser, err := dbmodel.GetUserByID(r.Db, su.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get user with id %d from db", su.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewUpdateUserDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+	if oldUser == nil {
+		msg := fmt.Sprintf("cannot find user with id %d", su.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewUpdateUserDefault(http.StatusNotFound).WithPayload(&rspErr)
+	}
+
+	// The permission to modify the users doesn't allow for taking over
+	// the super-admin accounts or for joining other groups. Otherwise,
+	// the users having this permission could gain any other permission.
+	if !isSuperAdmin(r.getLoggedUser(ctx)) {
+		msg := ""
+		if isSuperAdmin(oldUser) {
+			msg = "only super-admin can modify super-admin accounts"
+		} else if !sameGroups(oldUser.Groups, su.Groups) {
+			msg = "only super-admin can change the groups of the user"
+		}
+		if msg != "" {
+			rspErr := models.APIError{
+				Message: &msg,
+			}
+			return users.NewUpdateUserDefault(http.StatusForbidden).WithPayload(&rspErr)
+		}
+	}
+
+	// Remember the current state of the user for the audit trail.
+	before := newRestUser(*oldUser)
+
 	con, err := dbmodel.UpdateUser(r.Db, su)
 	if con {
 		log.WithFields(log.Fields{
@@ -255,6 +360,7 @@ func (r *RestAPI) UpdateUser(ctx context.Context, params users.UpdateUserParams)
 		return rsp
 	}
 
+	auditObject(ctx, "user", int64(su.ID), before, newRestUser(*su))
 	return users.NewUpdateUserOK()
 }
 
@@ -294,7 +400,9 @@ func (r *RestAPI) UpdateUserPassword(ctx context.Context, params users.UpdateUse
 		return rsp
 	}
 
-	// Password successfully changed.
+	// Password successfully changed. The passwords are not recorded in the
+	// audit trail.
+	auditObject(ctx, "user", int64(id), nil, nil)
 	return users.NewUpdateUserPasswordOK()
 }
 
@@ -340,3 +448,287 @@ func (r *RestAPI) GetGroups(ctx context.Context, params users.GetGroupsParams) m
 	rsp := users.NewGetGroupsOK().WithPayload(groups)
 	return rsp
 }
+
+// Creates new group of users in the database.
+func (r *RestAPI) CreateGroup(ctx context.Context, params users.CreateGroupParams) middleware.Responder {
+	g := params.Group
+	if g == nil || g.Name == nil || strings.TrimSpace(*g.Name) == "" {
+		msg := "group name must be specified"
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupDefault(http.StatusBadRequest).WithPayload(&rspErr)
+	}
+
+	sg := &dbmodel.SystemGroup{
+		Name: *g.Name,
+	}
+	if g.Description != nil {
+		sg.Description = *g.Description
+	}
+	con, err := dbmodel.AddGroup(r.Db, sg)
+	if err != nil {
+		if con {
+			msg := fmt.Sprintf("group %s already exists", sg.Name)
+			rspErr := models.APIError{
+				Message: &msg,
+			}
+			return users.NewCreateGroupDefault(http.StatusConflict).WithPayload(&rspErr)
+		}
+		log.Error(err)
+		msg := fmt.Sprintf("failed to create group %s", sg.Name)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+
+	group := newRestGroup(*sg)
+	auditObject(ctx, "group", int64(sg.ID), nil, group)
+	return users.NewCreateGroupOK().WithPayload(group)
+}
+
+// Creates new instance of the permission model used by REST API from the
+// permission instance returned from the database.
+func newRestPermission(p dbmodel.Permission) *models.Permission {
+	return &models.Permission{
+		ID:        int64(p.ID),
+		Resource:  &p.Resource,
+		Action:    &p.Action,
+		MachineID: p.MachineID,
+		AppID:     p.AppID,
+	}
+}
+
+// Returns the permissions granted to the group.
+func (r *RestAPI) GetGroupPermissions(ctx context.Context, params users.GetGroupPermissionsParams) middleware.Responder {
+	permissions, err := dbmodel.GetPermissionsByGroupIDs(r.Db, []int{int(params.ID)})
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to get permissions of group %d from the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewGetGroupPermissionsDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+
+	rspPermissions := &models.Permissions{
+		Total: int64(len(permissions)),
+	}
+	for _, p := range permissions {
+		rspPermissions.Items = append(rspPermissions.Items, newRestPermission(p))
+	}
+	return users.NewGetGroupPermissionsOK().WithPayload(rspPermissions)
+}
+
+// Grants new permission to the group.
+func (r *RestAPI) CreateGroupPermission(ctx context.Context, params users.CreateGroupPermissionParams) middleware.Responder {
+	group, err := dbmodel.GetGroupByID(r.Db, int(params.ID))
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to get group %d from the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupPermissionDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	} else if group == nil {
+		msg := fmt.Sprintf("failed to find group with id %d in the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupPermissionDefault(http.StatusNotFound).WithPayload(&rspErr)
+	}
+
+	p := params.Permission
+	if p == nil || p.Resource == nil || p.Action == nil {
+		msg := "permission resource and action must be specified"
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupPermissionDefault(http.StatusBadRequest).WithPayload(&rspErr)
+	}
+	permission := &dbmodel.Permission{
+		GroupID:   group.ID,
+		Resource:  *p.Resource,
+		Action:    *p.Action,
+		MachineID: p.MachineID,
+		AppID:     p.AppID,
+	}
+	err = auth.ValidatePermission(permission)
+	if err != nil {
+		msg := fmt.Sprintf("invalid permission: %s", err)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupPermissionDefault(http.StatusBadRequest).WithPayload(&rspErr)
+	}
+	err = dbmodel.AddPermission(r.Db, permission)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to grant permission to group %s", group.Name)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateGroupPermissionDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+
+	rspPermission := newRestPermission(*permission)
+	auditObject(ctx, "permission", int64(permission.ID), nil, rspPermission)
+	return users.NewCreateGroupPermissionOK().WithPayload(rspPermission)
+}
+
+// Revokes the permission from the group.
+func (r *RestAPI) DeleteGroupPermission(ctx context.Context, params users.DeleteGroupPermissionParams) middleware.Responder {
+	deleted, err := dbmodel.DeletePermission(r.Db, int(params.ID), int(params.PermissionID))
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to revoke permission %d from group %d", params.PermissionID, params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewDeleteGroupPermissionDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	} else if !deleted {
+		msg := fmt.Sprintf("failed to find permission %d of group %d", params.PermissionID, params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewDeleteGroupPermissionDefault(http.StatusNotFound).WithPayload(&rspErr)
+	}
+	auditObject(ctx, "permission", params.PermissionID, map[string]interface{}{"groupId": params.ID}, nil)
+	return users.NewDeleteGroupPermissionOK()
+}
+
+// Creates new instance of the API token model used by REST API from the
+// API token instance returned from the database. The token itself is
+// never included.
+func newRestAPIToken(t dbmodel.APIToken) *models.APIToken {
+	name := t.Name
+	return &models.APIToken{
+		ID:         int64(t.ID),
+		Name:       &name,
+		Scopes:     t.Scopes,
+		CreatedAt:  strfmt.DateTime(t.CreatedAt),
+		ExpiresAt:  optionalTimeToRestAPI(t.ExpiresAt),
+		LastUsedAt: optionalTimeToRestAPI(t.LastUsedAt),
+	}
+}
+
+// Returns the API tokens of the user.
+func (r *RestAPI) GetUserTokens(ctx context.Context, params users.GetUserTokensParams) middleware.Responder {
+	apiTokens, err := dbmodel.GetAPITokensByUserID(r.Db, int(params.ID))
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to get API tokens of user %d from the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewGetUserTokensDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+
+	rspTokens := &models.APITokens{
+		Total: int64(len(apiTokens)),
+	}
+	for _, t := range apiTokens {
+		rspTokens.Items = append(rspTokens.Items, newRestAPIToken(t))
+	}
+	return users.NewGetUserTokensOK().WithPayload(rspTokens)
+}
+
+// Creates new API token for the user. The generated token is returned
+// in the response.
+func (r *RestAPI) CreateUserToken(ctx context.Context, params users.CreateUserTokenParams) middleware.Responder {
+	// The token allows for acting on behalf of the user, so the users can
+	// only create the tokens for themselves. The super-admin can create
+	// them for anyone.
+	loggedUser := r.getLoggedUser(ctx)
+	if loggedUser == nil || (int64(loggedUser.ID) != params.ID && !isSuperAdmin(loggedUser)) {
+		msg := "API tokens can only be created by the user they are for"
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserTokenDefault(http.StatusForbidden).WithPayload(&rspErr)
+	}
+
+	su, err := dbmodel.GetUserByID(r.Db, int(params.ID))
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to fetch user with id %d from the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserTokenDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	} else if su == nil {
+		msg := fmt.Sprintf("failed to find user with id %d in the database", params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserTokenDefault(http.StatusNotFound).WithPayload(&rspErr)
+	}
+
+	t := params.Token
+	if t == nil || t.Name == nil || strings.TrimSpace(*t.Name) == "" {
+		msg := "API token name must be specified"
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserTokenDefault(http.StatusBadRequest).WithPayload(&rspErr)
+	}
+	for _, scope := range t.Scopes {
+		if err := auth.ValidateScope(scope); err != nil {
+			msg := fmt.Sprintf("invalid API token scope: %s", err)
+			rspErr := models.APIError{
+				Message: &msg,
+			}
+			return users.NewCreateUserTokenDefault(http.StatusBadRequest).WithPayload(&rspErr)
+		}
+	}
+
+	apiToken := &dbmodel.APIToken{
+		UserID:    su.ID,
+		Name:      *t.Name,
+		Scopes:    t.Scopes,
+		ExpiresAt: optionalTimeFromRestAPI(t.ExpiresAt),
+	}
+	token, con, err := dbmodel.AddAPIToken(r.Db, apiToken)
+	if err != nil {
+		if con {
+			msg := fmt.Sprintf("API token %s already exists", apiToken.Name)
+			rspErr := models.APIError{
+				Message: &msg,
+			}
+			return users.NewCreateUserTokenDefault(http.StatusConflict).WithPayload(&rspErr)
+		}
+		log.Error(err)
+		msg := fmt.Sprintf("failed to create API token %s", apiToken.Name)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewCreateUserTokenDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	}
+
+	rspToken := newRestAPIToken(*apiToken)
+	auditObject(ctx, "api-token", int64(apiToken.ID), nil, rspToken)
+	rspToken.Token = token
+	return users.NewCreateUserTokenOK().WithPayload(rspToken)
+}
+
+// Revokes the API token of the user.
+func (r *RestAPI) DeleteUserToken(ctx context.Context, params users.DeleteUserTokenParams) middleware.Responder {
+	deleted, err := dbmodel.DeleteAPIToken(r.Db, int(params.ID), int(params.TokenID))
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("failed to revoke API token %d of user %d", params.TokenID, params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewDeleteUserTokenDefault(http.StatusInternalServerError).WithPayload(&rspErr)
+	} else if !deleted {
+		msg := fmt.Sprintf("failed to find API token %d of user %d", params.TokenID, params.ID)
+		rspErr := models.APIError{
+			Message: &msg,
+		}
+		return users.NewDeleteUserTokenDefault(http.StatusNotFound).WithPayload(&rspErr)
+	}
+	auditObject(ctx, "api-token", params.TokenID, map[string]interface{}{"userId": params.ID}, nil)
+	return users.NewDeleteUserTokenOK()
+}
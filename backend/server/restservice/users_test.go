@@ -3,10 +3,13 @@ package restservice
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/go-openapi/runtime/middleware"
 	"github.com/stretchr/testify/require"
 	dbmodel "isc.org/stork/server/database/model"
+	dbsession "isc.org/stork/server/database/session"
 	dbtest "isc.org/stork/server/database/test"
 	"isc.org/stork/server/gen/models"
 	"isc.org/stork/server/gen/restapi/operations/users"
@@ -107,6 +110,7 @@ func TestUpdateUser(t *testing.T) {
 	// Modify some values and update the user.
 	su.Lastname = "Born"
 	params := users.UpdateUserParams{
+		ID: int64(su.ID),
 		Account: &models.UserAccount{
 			User:     newRestUser(su),
 			Password: models.Password("pass"),
@@ -120,10 +124,22 @@ func TestUpdateUser(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, su.Lastname, returned.Lastname)
 
+	// The user id in the body must match the id in the path.
+	params.ID = 1
+	rsp = rapi.UpdateUser(ctx, params)
+	require.IsType(t, &users.UpdateUserDefault{}, rsp)
+	defaultRsp := rsp.(*users.UpdateUserDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	admin, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, su.Lastname, admin.Lastname)
+
 	// An attempt to update non-existing user (non macthing ID) should
-	// result in an error 409.
+	// result in an error 404.
 	su.ID = 123
 	params = users.UpdateUserParams{
+		ID: int64(su.ID),
 		Account: &models.UserAccount{
 			User:     newRestUser(su),
 			Password: models.Password("pass"),
@@ -131,8 +147,135 @@ func TestUpdateUser(t *testing.T) {
 	}
 	rsp = rapi.UpdateUser(ctx, params)
 	require.IsType(t, &users.UpdateUserDefault{}, rsp)
-	defaultRsp := rsp.(*users.UpdateUserDefault)
-	require.Equal(t, 409, getStatusCode(*defaultRsp))
+	defaultRsp = rsp.(*users.UpdateUserDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+}
+
+// Returns the context of the request sent by the given user.
+func makeLoggedInContext(t *testing.T, rapi *RestAPI, user *dbmodel.SystemUser) context.Context {
+	if rapi.SessionManager == nil {
+		sm, err := dbsession.NewSessionMgr(&rapi.DbSettings.BaseDatabaseSettings, rapi.Db)
+		require.NoError(t, err)
+		rapi.SessionManager = sm
+	}
+	var ctx context.Context
+	handler := rapi.SessionManager.SessionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := rapi.SessionManager.LoginHandler(req.Context(), user)
+		require.NoError(t, err)
+		ctx = req.Context()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://localhost/api/sessions", nil))
+	require.NotNil(t, ctx)
+	return ctx
+}
+
+// Tests that the users who are not super-admins can neither modify the
+// super-admin accounts nor change the groups of the users.
+func TestUpdateUserPrivileges(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+
+	// The user having the permission to modify the users.
+	operator := &dbmodel.SystemUser{
+		Login:    "operator",
+		Lastname: "Doe",
+		Name:     "John",
+		Password: "pass",
+		Groups:   []*dbmodel.SystemGroup{{ID: dbmodel.AdminGroupID}},
+	}
+	con, err := dbmodel.CreateUser(db, operator)
+	require.False(t, con)
+	require.NoError(t, err)
+	ctx := makeLoggedInContext(t, rapi, operator)
+
+	update := func(ctx context.Context, user *dbmodel.SystemUser) middleware.Responder {
+		return rapi.UpdateUser(ctx, users.UpdateUserParams{
+			ID: int64(user.ID),
+			Account: &models.UserAccount{
+				User:     newRestUser(*user),
+				Password: models.Password("pass"),
+			},
+		})
+	}
+
+	// Joining the super-admin group is not allowed.
+	operator.Groups = append(operator.Groups, &dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID})
+	rsp := update(ctx, operator)
+	require.IsType(t, &users.UpdateUserDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.UpdateUserDefault)))
+
+	// Leaving the group isn't allowed either.
+	operator.Groups = nil
+	rsp = update(ctx, operator)
+	require.IsType(t, &users.UpdateUserDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.UpdateUserDefault)))
+
+	// Other fields can be modified.
+	operator.Groups = []*dbmodel.SystemGroup{{ID: dbmodel.AdminGroupID}}
+	operator.Lastname = "Smith"
+	rsp = update(ctx, operator)
+	require.IsType(t, &users.UpdateUserOK{}, rsp)
+
+	// The super-admin account can't be modified.
+	admin, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	require.True(t, isSuperAdmin(admin))
+	admin.Email = "operator@example.org"
+	rsp = update(ctx, admin)
+	require.IsType(t, &users.UpdateUserDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.UpdateUserDefault)))
+
+	// The super-admin can do all of this.
+	ctx = makeLoggedInContext(t, rapi, admin)
+	rsp = update(ctx, admin)
+	require.IsType(t, &users.UpdateUserOK{}, rsp)
+	operator.Groups = append(operator.Groups, &dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID})
+	rsp = update(ctx, operator)
+	require.IsType(t, &users.UpdateUserOK{}, rsp)
+
+	returned, err := dbmodel.GetUserByID(db, operator.ID)
+	require.NoError(t, err)
+	require.True(t, isSuperAdmin(returned))
+}
+
+// Tests that only super-admin can create users belonging to groups.
+func TestCreateUserWithGroups(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+
+	su := dbmodel.SystemUser{
+		Email:    "jb@example.org",
+		Lastname: "Born",
+		Login:    "jb",
+		Name:     "John",
+		Groups:   []*dbmodel.SystemGroup{{ID: dbmodel.SuperAdminGroupID}},
+	}
+	params := users.CreateUserParams{
+		Account: &models.UserAccount{
+			User:     newRestUser(su),
+			Password: models.Password("pass"),
+		},
+	}
+
+	operator := &dbmodel.SystemUser{
+		ID:     100,
+		Login:  "operator",
+		Groups: []*dbmodel.SystemGroup{{ID: dbmodel.AdminGroupID}},
+	}
+	rsp := rapi.CreateUser(makeLoggedInContext(t, rapi, operator), params)
+	require.IsType(t, &users.CreateUserDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.CreateUserDefault)))
+
+	admin, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	rsp = rapi.CreateUser(makeLoggedInContext(t, rapi, admin), params)
+	require.IsType(t, &users.CreateUserOK{}, rsp)
 }
 
 // Tests that user password can be updated via REST API.
@@ -198,7 +341,7 @@ func TestGetGroups(t *testing.T) {
 
 	groups := rspOK.Payload
 	require.NotNil(t, groups.Items)
-	require.GreaterOrEqual(t, 2, len(groups.Items))
+	require.Len(t, groups.Items, 5)
 }
 
 // Tests that user information can be retrieved via REST API.
@@ -294,3 +437,235 @@ func TestGetUser(t *testing.T) {
 	// TODO: check that the new user belongs to a group
 	// require.Len(t, okRsp.Payload.Groups, 1)
 }
+
+// Tests that new group can be created via REST API.
+func TestCreateGroup(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	ctx := context.Background()
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+
+	name := "operators"
+	description := "Operators"
+	params := users.CreateGroupParams{
+		Group: &models.Group{
+			Name:        &name,
+			Description: &description,
+		},
+	}
+	rsp := rapi.CreateGroup(ctx, params)
+	require.IsType(t, &users.CreateGroupOK{}, rsp)
+	group := rsp.(*users.CreateGroupOK).Payload
+	require.NotZero(t, group.ID)
+	require.Equal(t, name, *group.Name)
+
+	// The same name again.
+	rsp = rapi.CreateGroup(ctx, params)
+	require.IsType(t, &users.CreateGroupDefault{}, rsp)
+	require.Equal(t, http.StatusConflict, getStatusCode(*rsp.(*users.CreateGroupDefault)))
+
+	// No name.
+	name = ""
+	rsp = rapi.CreateGroup(ctx, params)
+	require.IsType(t, &users.CreateGroupDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*users.CreateGroupDefault)))
+}
+
+// Tests that the permissions can be granted to the group and revoked via
+// REST API.
+func TestGroupPermissions(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	ctx := context.Background()
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+
+	// The admin group has the permission to modify everything except
+	// the sensitive resources.
+	rsp := rapi.GetGroupPermissions(ctx, users.GetGroupPermissionsParams{ID: int64(dbmodel.AdminGroupID)})
+	require.IsType(t, &users.GetGroupPermissionsOK{}, rsp)
+	permissions := rsp.(*users.GetGroupPermissionsOK).Payload
+	require.EqualValues(t, 1, permissions.Total)
+	require.Equal(t, "*", *permissions.Items[0].Resource)
+	require.Equal(t, "write", *permissions.Items[0].Action)
+
+	group := &dbmodel.SystemGroup{
+		Name: "operators",
+	}
+	_, err = dbmodel.AddGroup(db, group)
+	require.NoError(t, err)
+
+	// Grant the permission.
+	resource := "dhcp"
+	action := "write"
+	params := users.CreateGroupPermissionParams{
+		ID: int64(group.ID),
+		Permission: &models.Permission{
+			Resource: &resource,
+			Action:   &action,
+		},
+	}
+	rsp = rapi.CreateGroupPermission(ctx, params)
+	require.IsType(t, &users.CreateGroupPermissionOK{}, rsp)
+	permission := rsp.(*users.CreateGroupPermissionOK).Payload
+	require.NotZero(t, permission.ID)
+
+	rsp = rapi.GetGroupPermissions(ctx, users.GetGroupPermissionsParams{ID: int64(group.ID)})
+	require.IsType(t, &users.GetGroupPermissionsOK{}, rsp)
+	permissions = rsp.(*users.GetGroupPermissionsOK).Payload
+	require.EqualValues(t, 1, permissions.Total)
+	require.Equal(t, permission.ID, permissions.Items[0].ID)
+	require.Equal(t, "dhcp", *permissions.Items[0].Resource)
+
+	// Unknown resource.
+	resource = "foo"
+	rsp = rapi.CreateGroupPermission(ctx, params)
+	require.IsType(t, &users.CreateGroupPermissionDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*users.CreateGroupPermissionDefault)))
+
+	// Unknown group.
+	resource = "dhcp"
+	params.ID = 12345
+	rsp = rapi.CreateGroupPermission(ctx, params)
+	require.IsType(t, &users.CreateGroupPermissionDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*users.CreateGroupPermissionDefault)))
+
+	// Revoke the permission.
+	delParams := users.DeleteGroupPermissionParams{
+		ID:           int64(group.ID),
+		PermissionID: permission.ID,
+	}
+	rsp = rapi.DeleteGroupPermission(ctx, delParams)
+	require.IsType(t, &users.DeleteGroupPermissionOK{}, rsp)
+
+	rsp = rapi.DeleteGroupPermission(ctx, delParams)
+	require.IsType(t, &users.DeleteGroupPermissionDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*users.DeleteGroupPermissionDefault)))
+}
+
+// Tests that the API tokens can be created, listed and revoked via REST API.
+func TestUserTokens(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+	admin, err := dbmodel.GetUserByID(db, 1)
+	require.NoError(t, err)
+	ctx := makeLoggedInContext(t, rapi, admin)
+
+	name := "ci"
+	params := users.CreateUserTokenParams{
+		ID: 1,
+		Token: &models.APIToken{
+			Name:   &name,
+			Scopes: []string{"dhcp:read", "events:read"},
+		},
+	}
+	rsp := rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenOK{}, rsp)
+	created := rsp.(*users.CreateUserTokenOK).Payload
+	require.NotZero(t, created.ID)
+	require.NotEmpty(t, created.Token)
+	require.Nil(t, created.ExpiresAt)
+
+	// The token can be used for authentication.
+	apiToken, err := dbmodel.AuthenticateAPIToken(db, created.Token)
+	require.NoError(t, err)
+	require.NotNil(t, apiToken)
+
+	// The same name again.
+	rsp = rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusConflict, getStatusCode(*rsp.(*users.CreateUserTokenDefault)))
+
+	// Invalid scope.
+	name = "other"
+	params.Token.Scopes = []string{"dhcp"}
+	rsp = rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*users.CreateUserTokenDefault)))
+
+	// Non existing user.
+	params.ID = 12345
+	params.Token.Scopes = nil
+	rsp = rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*users.CreateUserTokenDefault)))
+
+	// The token is not returned in the list.
+	rsp = rapi.GetUserTokens(ctx, users.GetUserTokensParams{ID: 1})
+	require.IsType(t, &users.GetUserTokensOK{}, rsp)
+	apiTokens := rsp.(*users.GetUserTokensOK).Payload
+	require.EqualValues(t, 1, apiTokens.Total)
+	require.Equal(t, created.ID, apiTokens.Items[0].ID)
+	require.Equal(t, "ci", *apiTokens.Items[0].Name)
+	require.Equal(t, []string{"dhcp:read", "events:read"}, apiTokens.Items[0].Scopes)
+	require.Empty(t, apiTokens.Items[0].Token)
+	require.NotNil(t, apiTokens.Items[0].LastUsedAt)
+
+	// Revoke the token.
+	delParams := users.DeleteUserTokenParams{
+		ID:      1,
+		TokenID: created.ID,
+	}
+	rsp = rapi.DeleteUserToken(ctx, delParams)
+	require.IsType(t, &users.DeleteUserTokenOK{}, rsp)
+
+	rsp = rapi.DeleteUserToken(ctx, delParams)
+	require.IsType(t, &users.DeleteUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*users.DeleteUserTokenDefault)))
+
+	apiToken, err = dbmodel.AuthenticateAPIToken(db, created.Token)
+	require.NoError(t, err)
+	require.Nil(t, apiToken)
+}
+
+// Tests that the users who are not super-admins can only create the API
+// tokens for themselves.
+func TestCreateUserTokenForOtherUser(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rapi, err := NewRestAPI(nil, dbSettings, db, nil)
+	require.NoError(t, err)
+
+	operator := &dbmodel.SystemUser{
+		Login:    "operator",
+		Lastname: "Doe",
+		Name:     "John",
+		Password: "pass",
+		Groups:   []*dbmodel.SystemGroup{{ID: dbmodel.AdminGroupID}},
+	}
+	con, err := dbmodel.CreateUser(db, operator)
+	require.False(t, con)
+	require.NoError(t, err)
+
+	name := "ci"
+	params := users.CreateUserTokenParams{
+		ID:    1,
+		Token: &models.APIToken{Name: &name},
+	}
+
+	// No user logged in.
+	rsp := rapi.CreateUserToken(context.Background(), params)
+	require.IsType(t, &users.CreateUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.CreateUserTokenDefault)))
+
+	// The token for the super-admin.
+	ctx := makeLoggedInContext(t, rapi, operator)
+	rsp = rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenDefault{}, rsp)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*rsp.(*users.CreateUserTokenDefault)))
+
+	// Own token.
+	params.ID = int64(operator.ID)
+	rsp = rapi.CreateUserToken(ctx, params)
+	require.IsType(t, &users.CreateUserTokenOK{}, rsp)
+	apiTokens, err := dbmodel.GetAPITokensByUserID(db, operator.ID)
+	require.NoError(t, err)
+	require.Len(t, apiTokens, 1)
+}
@@ -7,8 +7,11 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"isc.org/stork/server/agentcomm"
+	"isc.org/stork/server/alerting"
 	"isc.org/stork/server/apps/bind9"
 	"isc.org/stork/server/apps/kea"
+	"isc.org/stork/server/auth"
+	"isc.org/stork/server/certs"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/restservice"
@@ -25,10 +28,13 @@ type StorkServer struct {
 	RestAPISettings restservice.RestAPISettings
 	RestAPI         *restservice.RestAPI
 
+	LDAPSettings auth.LDAPSettings
+
 	Bind9StatsPuller *bind9.StatsPuller
 	KeaStatsPuller   *kea.StatsPuller
 	KeaHostsPuller   *kea.HostsPuller
 	StatusPuller     *kea.StatusPuller
+	Alerter          *alerting.Alerter
 }
 
 func (ss *StorkServer) ParseArgs() {
@@ -49,6 +55,12 @@ func (ss *StorkServer) ParseArgs() {
 		log.Fatalf("FATAL error: %+v", err)
 	}
 
+	// Process LDAP authentication specific args.
+	_, err = parser.AddGroup("LDAP Authentication Flags", "", &ss.LDAPSettings)
+	if err != nil {
+		log.Fatalf("FATAL error: %+v", err)
+	}
+
 	// Process agent comm specific args.
 	_, err = parser.AddGroup("Agents Communication Flags", "", &ss.AgentsSettings)
 	if err != nil {
@@ -72,8 +84,25 @@ func NewStorkServer() (ss *StorkServer, err error) {
 	ss = &StorkServer{}
 	ss.ParseArgs()
 
+	// setup database connection
+	ss.Db, err = dbops.NewPgDB(&ss.DbSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup CA and certificates for securing communication with agents
+	caCertPEM, serverCertPEM, serverKeyPEM, err := certs.SetupServerCerts(ss.Db)
+	if err != nil {
+		ss.Db.Close()
+		return nil, err
+	}
+
 	// setup connected agents
-	ss.Agents = agentcomm.NewConnectedAgents(&ss.AgentsSettings)
+	ss.Agents, err = agentcomm.NewConnectedAgents(&ss.AgentsSettings, ss.Db, caCertPEM, serverCertPEM, serverKeyPEM)
+	if err != nil {
+		ss.Db.Close()
+		return nil, err
+	}
 	// TODO: if any operation below fails then this Shutdown here causes segfault.
 	// I do not know why and do not how to fix this. Commenting out for now.
 	// defer func() {
@@ -82,12 +111,6 @@ func NewStorkServer() (ss *StorkServer, err error) {
 	// 	}
 	// }()
 
-	// setup database connection
-	ss.Db, err = dbops.NewPgDB(&ss.DbSettings)
-	if err != nil {
-		return nil, err
-	}
-
 	// initialize stork settings
 	err = dbmodel.InitializeSettings(ss.Db)
 	if err != nil {
@@ -124,6 +147,12 @@ func NewStorkServer() (ss *StorkServer, err error) {
 		return nil, err
 	}
 
+	// Setup alert rules evaluation.
+	ss.Alerter, err = alerting.NewAlerter(ss.Db, ss.Agents)
+	if err != nil {
+		return nil, err
+	}
+
 	// setup ReST API service
 	r, err := restservice.NewRestAPI(&ss.RestAPISettings, &ss.DbSettings, ss.Db, ss.Agents)
 	if err != nil {
@@ -134,6 +163,17 @@ func NewStorkServer() (ss *StorkServer, err error) {
 		return nil, err
 	}
 	ss.RestAPI = r
+
+	// The users are authenticated with the passwords stored in the
+	// database and, if configured, via LDAP.
+	r.Authenticators = []auth.Authenticator{auth.NewDatabaseAuthenticator(ss.Db)}
+	if ss.LDAPSettings.URL != "" {
+		ldapAuthenticator, err := auth.NewLDAPAuthenticator(&ss.LDAPSettings, ss.Db)
+		if err != nil {
+			return nil, err
+		}
+		r.Authenticators = append(r.Authenticators, ldapAuthenticator)
+	}
 	return ss, nil
 }
 
@@ -154,6 +194,7 @@ func (ss *StorkServer) Shutdown() {
 	ss.KeaStatsPuller.Shutdown()
 	ss.Bind9StatsPuller.Shutdown()
 	ss.StatusPuller.Shutdown()
+	ss.Alerter.Shutdown()
 	ss.Db.Close()
 	ss.Agents.Shutdown()
 	log.Println("Stork Server shut down")
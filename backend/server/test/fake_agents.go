@@ -2,6 +2,8 @@ package storktest
 
 import (
 	"context"
+	"net"
+	"strconv"
 
 	"isc.org/stork/server/agentcomm"
 )
@@ -9,6 +11,7 @@ import (
 // Helper struct to mock Agents behavior.
 type FakeAgents struct {
 	RecordedURL      string
+	RecordedSocket   string
 	RecordedCommands []agentcomm.KeaCommand
 	mockKeaFunc      func(int, []interface{})
 	CallNo           int
@@ -23,6 +26,9 @@ type FakeAgents struct {
 	mockNamedFunc    func(int, interface{})
 
 	MachineState *agentcomm.State
+
+	// Addresses (host:port) of the agents which are reported as unavailable.
+	UnavailableAgents map[string]bool
 }
 
 // mockRndcOutput returns some mocked named response.
@@ -61,6 +67,19 @@ func (fa *FakeAgents) Shutdown() {}
 func (fa *FakeAgents) GetConnectedAgent(address string) (*agentcomm.Agent, error) {
 	return nil, nil
 }
+func (fa *FakeAgents) RemoveAgent(address string, agentPort int64) {}
+
+// FakeAgents specific implementation of the GetAgentHealth. All agents
+// are healthy.
+func (fa *FakeAgents) GetAgentHealth(address string, agentPort int64) agentcomm.AgentHealth {
+	return agentcomm.AgentHealth{}
+}
+
+// FakeAgents specific implementation of the IsAgentAvailable. The agents
+// listed in UnavailableAgents are not available.
+func (fa *FakeAgents) IsAgentAvailable(address string, agentPort int64) bool {
+	return !fa.UnavailableAgents[net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))]
+}
 
 // FakeAgents specific implementation of the GetState.
 func (fa *FakeAgents) GetState(ctx context.Context, address string, agentPort int64) (*agentcomm.State, error) {
@@ -104,6 +123,24 @@ func (fa *FakeAgents) ForwardToKeaOverHTTP(ctx context.Context, agentAddress str
 	return result, nil
 }
 
+// FakeAgents specific implementation of the function to forward a command
+// directly to the Kea daemon over its control socket. It records the socket
+// and the commands and returns a custom response like ForwardToKeaOverHTTP.
+func (fa *FakeAgents) ForwardToKeaOverUnixSocket(ctx context.Context, agentAddress string, agentPort int64, socketName string, commands []*agentcomm.KeaCommand, cmdResponses ...interface{}) (*agentcomm.KeaCmdsResult, error) {
+	fa.RecordedSocket = socketName
+	result := &agentcomm.KeaCmdsResult{}
+	for _, cmd := range commands {
+		fa.RecordedCommands = append(fa.RecordedCommands, *cmd)
+		result.CmdsErrors = append(result.CmdsErrors, nil)
+	}
+	// Generate response.
+	if fa.mockKeaFunc != nil {
+		fa.mockKeaFunc(fa.CallNo, cmdResponses)
+	}
+	fa.CallNo++
+	return result, nil
+}
+
 // FakeAgents specific implementation of the function to forward a command
 // to the named statistics-channel. It records some arguments used in the call
 // to this function so as they can be later validated. It also returns a custom
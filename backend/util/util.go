@@ -1,6 +1,7 @@
 package storkutil
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net"
@@ -20,9 +21,14 @@ func UTCNow() time.Time {
 	return time.Now().UTC()
 }
 
-// Returns URL of the host with port.
-func HostWithPortURL(address string, port int64) string {
-	return fmt.Sprintf("http://%s:%d/", address, port)
+// Returns URL of the host with port. The https scheme is used if the
+// secure protocol is requested.
+func HostWithPortURL(address string, port int64, secure bool) string {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(address, strconv.FormatInt(port, 10)))
 }
 
 // Parses URL into host and port.
@@ -86,6 +92,14 @@ func ParseIP(address string) (string, bool, bool) {
 	return ipNet.String(), true, true
 }
 
+// Checks if the IP address belongs to the range between the lower and
+// upper bound, inclusive. It returns false when any of the addresses is
+// nil, e.g. when it could not be parsed.
+func IPInRange(ip, lower, upper net.IP) bool {
+	return ip != nil && lower != nil && upper != nil &&
+		bytes.Compare(ip.To16(), lower.To16()) >= 0 && bytes.Compare(ip.To16(), upper.To16()) <= 0
+}
+
 func SetupLogging() {
 	log.SetLevel(log.DebugLevel)
 	log.SetOutput(os.Stdout)
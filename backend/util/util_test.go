@@ -1,6 +1,7 @@
 package storkutil
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -8,8 +9,10 @@ import (
 
 // Test that HostWithPort function generates proper output.
 func TestHostWithPortURL(t *testing.T) {
-	require.Equal(t, "http://localhost:1000/", HostWithPortURL("localhost", 1000))
-	require.Equal(t, "http://192.0.2.0:1/", HostWithPortURL("192.0.2.0", 1))
+	require.Equal(t, "http://localhost:1000/", HostWithPortURL("localhost", 1000, false))
+	require.Equal(t, "http://192.0.2.0:1/", HostWithPortURL("192.0.2.0", 1, false))
+	require.Equal(t, "https://192.0.2.0:1/", HostWithPortURL("192.0.2.0", 1, true))
+	require.Equal(t, "http://[::1]:8000/", HostWithPortURL("::1", 8000, false))
 }
 
 // Test parsing URL into host and port.
@@ -68,3 +71,20 @@ func TestParseIP(t *testing.T) {
 	require.False(t, prefix)
 	require.True(t, ok)
 }
+
+// Test that the IP address is checked against the range.
+func TestIPInRange(t *testing.T) {
+	lower := net.ParseIP("192.0.2.10")
+	upper := net.ParseIP("192.0.2.20")
+	require.True(t, IPInRange(net.ParseIP("192.0.2.10"), lower, upper))
+	require.True(t, IPInRange(net.ParseIP("192.0.2.15"), lower, upper))
+	require.True(t, IPInRange(net.ParseIP("192.0.2.20"), lower, upper))
+	require.False(t, IPInRange(net.ParseIP("192.0.2.9"), lower, upper))
+	require.False(t, IPInRange(net.ParseIP("192.0.2.21"), lower, upper))
+	require.True(t, IPInRange(net.ParseIP("2001:db8:1::5"), net.ParseIP("2001:db8:1::"), net.ParseIP("2001:db8:1::ffff")))
+
+	// Invalid addresses are never in range.
+	require.False(t, IPInRange(net.ParseIP("foo"), lower, upper))
+	require.False(t, IPInRange(net.ParseIP("192.0.2.15"), nil, upper))
+	require.False(t, IPInRange(net.ParseIP("192.0.2.15"), lower, nil))
+}
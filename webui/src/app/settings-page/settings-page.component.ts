@@ -15,6 +15,7 @@ export class SettingsPageComponent implements OnInit {
 
     constructor(private fb: FormBuilder, private settingsApi: SettingsService, private msgSrv: MessageService) {
         this.settingsForm = this.fb.group({
+            alerting_interval: ['', [Validators.required, Validators.min(0)]],
             bind9_stats_puller_interval: ['', [Validators.required, Validators.min(0)]],
             grafana_url: [''],
             kea_hosts_puller_interval: ['', [Validators.required, Validators.min(0)]],
@@ -28,6 +29,7 @@ export class SettingsPageComponent implements OnInit {
         this.settingsApi.getSettings().subscribe(
             (data) => {
                 const numericSettings = [
+                    'alerting_interval',
                     'bind9_stats_puller_interval',
                     'kea_hosts_puller_interval',
                     'kea_stats_puller_interval',
@@ -473,7 +473,7 @@ export class UsersPageComponent implements OnInit {
         const password = this.userform.controls.userpassword.value
         const account = { user, password }
 
-        this.usersApi.updateUser(account).subscribe(
+        this.usersApi.updateUser(user.id, account).subscribe(
             (data) => {
                 this.msgSrv.add({
                     severity: 'success',